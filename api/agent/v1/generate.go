@@ -0,0 +1,4 @@
+// Package agentv1 holds the gRPC API edge agents use to talk to the orchestrator
+package agentv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative agent/v1/agent.proto
@@ -0,0 +1,8 @@
+module github.com/ishaqelkhalifa/kubernetes-edge-framework/api
+
+go 1.21
+
+require (
+	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
+)
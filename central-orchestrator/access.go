@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles an API token or role binding grants
+const (
+	// Every route
+	RoleAdmin = "admin"
+	// Every route but the /api/v1/admin ones
+	RoleOperator = "operator"
+	// Read-only routes
+	RoleViewer = "viewer"
+	// Edge agents; every route, node routes further limited by RequireNodeIdentity
+	RoleNode = "node"
+	// Devices presenting a join token or claim secret, which no policy lists; the join
+	// and claim routes, and the node routes of devices registered with the join token
+	RoleDevice = "device"
+)
+
+// Context keys set by AuthMiddleware and ImpersonationMiddleware for policy-authenticated callers
+const (
+	ContextKeyGroups       = "groups"
+	ContextKeyTenants      = "tenants"
+	ContextKeyImpersonator = "impersonator"
+	ContextKeyScopes       = "scopes"
+	// ID of the join token a device authenticated with
+	ContextKeyJoinToken = "join_token"
+)
+
+// routeScopes names the scope of routes whose derived scope would not say what they do
+var routeScopes = map[string]string{
+	"POST /api/v1/workloads/:id/snapshots": "workloads:snapshot",
+}
+
+// roleRanks orders the roles a binding may grant, least privileged first
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// APIToken is a bearer token of the access policy and the identity it authenticates
+type APIToken struct {
+	// Hex SHA-256 of the token, so the policy file holds no usable secret
+	TokenSHA256 string   `json:"token_sha256"`
+	User        string   `json:"user"`
+	Role        string   `json:"role"`
+	Groups      []string `json:"groups,omitempty"`
+	// Tenants whose workloads the token may act on; empty for all
+	Tenants []string `json:"tenants,omitempty"`
+	// Scopes narrowing the role to resource:verb pairs, such as workloads:read or
+	// nodes:drain; either half may be "*". Empty for everything the role allows.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// RoleBinding grants a role to a user or a group. Bindings apply to impersonated identities.
+type RoleBinding struct {
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+	Role  string `json:"role"`
+	// Tenants whose workloads the binding covers; empty for all
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// ChatUser maps a Slack or Teams account to a user, whose role bindings apply to the
+// chatops commands it runs
+type ChatUser struct {
+	// "slack" or "teams"
+	Platform string `json:"platform"`
+	// Slack user ID or Teams Azure AD object ID
+	ID     string   `json:"id"`
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// AccessPolicy is the token registry and role bindings read from ACCESS_POLICY_FILE
+type AccessPolicy struct {
+	Tokens    []APIToken    `json:"tokens"`
+	Bindings  []RoleBinding `json:"bindings"`
+	ChatUsers []ChatUser    `json:"chat_users,omitempty"`
+
+	tokens map[string]*APIToken
+}
+
+// loadAccessPolicy reads and validates an access policy file
+func loadAccessPolicy(path string) (*AccessPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy AccessPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid access policy: %v", err)
+	}
+
+	policy.tokens = make(map[string]*APIToken, len(policy.Tokens))
+	for i := range policy.Tokens {
+		token := &policy.Tokens[i]
+		if token.User == "" {
+			return nil, fmt.Errorf("token %d has no user", i)
+		}
+		if _, known := roleRanks[token.Role]; !known && token.Role != RoleNode {
+			return nil, fmt.Errorf("token of %s has unknown role %q", token.User, token.Role)
+		}
+		digest := strings.ToLower(token.TokenSHA256)
+		if _, err := hex.DecodeString(digest); err != nil || len(digest) != sha256.Size*2 {
+			return nil, fmt.Errorf("token of %s has an invalid token_sha256", token.User)
+		}
+		for _, scope := range token.Scopes {
+			if resource, verb, found := strings.Cut(scope, ":"); scope != "*" && (!found || resource == "" || verb == "") {
+				return nil, fmt.Errorf("token of %s has invalid scope %q, want resource:verb", token.User, scope)
+			}
+		}
+		policy.tokens[digest] = token
+	}
+	for i, binding := range policy.Bindings {
+		if (binding.User == "") == (binding.Group == "") {
+			return nil, fmt.Errorf("binding %d must name exactly one of user or group", i)
+		}
+		if _, known := roleRanks[binding.Role]; !known {
+			return nil, fmt.Errorf("binding %d has unknown role %q", i, binding.Role)
+		}
+	}
+	for i, chatUser := range policy.ChatUsers {
+		if chatUser.Platform != ChatPlatformSlack && chatUser.Platform != ChatPlatformTeams {
+			return nil, fmt.Errorf("chat user %d has unknown platform %q", i, chatUser.Platform)
+		}
+		if chatUser.ID == "" || chatUser.User == "" {
+			return nil, fmt.Errorf("chat user %d must have an id and a user", i)
+		}
+	}
+	return &policy, nil
+}
+
+// lookupChatUser returns the user a chat platform account is mapped to
+func (p *AccessPolicy) lookupChatUser(platform, id string) (*ChatUser, bool) {
+	for i := range p.ChatUsers {
+		if p.ChatUsers[i].Platform == platform && p.ChatUsers[i].ID == id {
+			return &p.ChatUsers[i], true
+		}
+	}
+	return nil, false
+}
+
+// lookupToken returns the policy token matching a bearer token
+func (p *AccessPolicy) lookupToken(bearer string) (*APIToken, bool) {
+	sum := sha256.Sum256([]byte(bearer))
+	token, exists := p.tokens[hex.EncodeToString(sum[:])]
+	return token, exists
+}
+
+// roleAllows reports whether a role may make a request with this method to this path
+func roleAllows(role, method, path string) bool {
+	switch role {
+	case RoleAdmin:
+		return true
+	case RoleNode:
+		// Agents register and send batched heartbeats; their own node routes are allowed
+		// by AuthorizeMiddleware, which knows the routes RequireNodeIdentity guards
+		return method == http.MethodPost && (path == "/api/v1/nodes/register" || path == "/api/v1/nodes/heartbeats")
+	case RoleOperator:
+		// Tenant quotas bound what operators may run, so only admins change them
+		quotaChange := strings.HasPrefix(path, "/api/v1/tenant-quotas/") && method != http.MethodGet && method != http.MethodHead
+		return !strings.HasPrefix(path, "/api/v1/admin/") && !quotaChange
+	case RoleViewer:
+		// Viewers may exchange their credentials for a token of the same role
+		return method == http.MethodGet || method == http.MethodHead || (method == http.MethodPost && path == "/api/v1/auth/token")
+	case RoleDevice:
+		// Devices register, and announce themselves and poll while waiting to be claimed
+		return method == http.MethodPost && (path == "/api/v1/nodes/register" || path == "/api/v1/claims") ||
+			method == http.MethodGet && strings.HasPrefix(path, "/api/v1/claims/") && strings.HasSuffix(path, "/status")
+	default:
+		return false
+	}
+}
+
+// requestScope returns the resource:verb scope a request to a route needs. The resource is
+// the first path segment after /api/v1. Reads are "read"; a POST ending in an action, as in
+// /workloads/:id/scale, is that action; other writes are "create", "update" or "delete".
+func requestScope(method, route string) string {
+	if scope, exists := routeScopes[method+" "+route]; exists {
+		return scope
+	}
+
+	segments := strings.Split(strings.TrimPrefix(route, "/api/v1/"), "/")
+	resource, last := segments[0], segments[len(segments)-1]
+	switch {
+	case method == http.MethodGet || method == http.MethodHead:
+		return resource + ":read"
+	case method == http.MethodPost && len(segments) > 1 && !strings.HasPrefix(last, ":"):
+		return resource + ":" + last
+	case method == http.MethodPost:
+		return resource + ":create"
+	case method == http.MethodDelete && len(segments) <= 2:
+		return resource + ":delete"
+	default:
+		return resource + ":update"
+	}
+}
+
+// scopeAllows reports whether one of the scopes grants the required scope
+func scopeAllows(scopes []string, required string) bool {
+	resource, verb, _ := strings.Cut(required, ":")
+	for _, scope := range scopes {
+		if scope == "*" {
+			return true
+		}
+		r, v, _ := strings.Cut(scope, ":")
+		if (r == "*" || r == resource) && (v == "*" || v == verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantAllowed reports whether the caller may act on a tenant's workloads; callers without
+// a tenants list may act on all of them
+func tenantAllowed(c *gin.Context, tenant string) bool {
+	value, limited := c.Get(ContextKeyTenants)
+	if !limited {
+		return true
+	}
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	for _, allowed := range value.([]string) {
+		if allowed == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeMiddleware enforces the caller's role and token scopes, and its tenants on workload
+// and node routes. Callers authenticated with a token without an access policy or JWT keys
+// keep full access; nodes authenticated with their certificate never do.
+func (co *CentralOrchestrator) AuthorizeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		authMethod := c.GetString(ContextKeyAuthMethod)
+		if authMethod == "" || (!co.SecurityManager.enforcesRoles() && authMethod != "certificate") {
+			c.Next()
+			return
+		}
+
+		// Organizations among the caller's tenants stand for their projects
+		co.expandTenantScope(c)
+
+		// Nodes, and devices that joined, act on their own node routes, which
+		// RequireNodeIdentity binds to their certificate or to the devices registered with
+		// their join token
+		agent := role == RoleNode || (role == RoleDevice && c.GetString(ContextKeyJoinToken) != "")
+		ownNodeRoute := agent && co.requiresNodeIdentity(c)
+		if !ownNodeRoute && !roleAllows(role, c.Request.Method, c.Request.URL.Path) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Role %s may not %s %s", role, c.Request.Method, c.Request.URL.Path)})
+			c.Abort()
+			return
+		}
+
+		// Scopes are those of the token, and still apply when it impersonates someone
+		if value, scoped := c.Get(ContextKeyScopes); scoped && strings.HasPrefix(c.FullPath(), "/api/v1/") {
+			if required := requestScope(c.Request.Method, c.FullPath()); !scopeAllows(value.([]string), required) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Token lacks scope " + required})
+				c.Abort()
+				return
+			}
+		}
+
+		if strings.HasPrefix(c.FullPath(), "/api/v1/workloads/:id") {
+			co.WorkloadManager.mutex.RLock()
+			workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+			co.WorkloadManager.mutex.RUnlock()
+
+			// Missing workloads are left to the handler to report
+			if exists && !tenantAllowed(c, workloadTenant(workload)) {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", workloadTenant(workload))})
+				c.Abort()
+				return
+			}
+		}
+		if strings.HasPrefix(c.FullPath(), "/api/v1/nodes/:id") {
+			co.NodeManager.mutex.RLock()
+			node, exists := co.NodeManager.nodes[c.Param("id")]
+			co.NodeManager.mutex.RUnlock()
+
+			// Shared nodes are open to every tenant
+			if exists && !nodeVisible(c, node) {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", nodeTenant(node))})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TestDeviceCredentialsWithAccessPolicy checks that join tokens and claim secrets, which no
+// access policy lists, still reach the join and claim routes once a policy is configured,
+// and nothing else
+func TestDeviceCredentialsWithAccessPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	viewerSum := sha256.Sum256([]byte("viewer-secret"))
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	policyJSON := `{"tokens": [{"token_sha256": "` + hex.EncodeToString(viewerSum[:]) + `", "user": "alice", "role": "viewer"}]}`
+	if err := os.WriteFile(policyFile, []byte(policyJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := loadAccessPolicy(policyFile)
+	if err != nil {
+		t.Fatalf("Failed to load access policy: %v", err)
+	}
+
+	co := &CentralOrchestrator{
+		NodeManager:     NewNodeManager(logger),
+		ImageBuilder:    NewImageBuilder(logger),
+		ClaimManager:    NewClaimManager(logger),
+		SecurityManager: &SecurityManager{policy: policy, logger: logger},
+		Logger:          logger,
+	}
+	co.SecurityManager.lookupDevice = co.lookupDeviceCredential
+
+	joinToken, joinSecret := newJoinToken("site-a", "", "", nil, 5, time.Now().Add(time.Hour))
+	joinToken.Devices = []string{"edge-1"}
+	co.ImageBuilder.tokens[joinToken.ID] = joinToken
+	co.NodeManager.nodes["node-1"] = &EdgeNode{ID: "node-1", Name: "edge-1"}
+	co.NodeManager.nodes["node-2"] = &EdgeNode{ID: "node-2", Name: "edge-2"}
+	co.ClaimManager.claims["ABCD-2345"] = &DeviceClaim{Status: DeviceClaimUnclaimed, secretHash: hashJoinToken("claim-secret")}
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	router.Use(co.SecurityManager.AuthMiddleware())
+	router.Use(co.AuthorizeMiddleware())
+	v1 := router.Group("/api/v1")
+	router.POST("/api/v1/nodes/register", ok)
+	co.nodeRoute(v1, http.MethodPost, "/nodes/:id/heartbeat", ok)
+	router.POST("/api/v1/nodes/:id/drain", ok)
+	router.GET("/api/v1/workloads", ok)
+	router.POST("/api/v1/claims", ok)
+	router.GET("/api/v1/claims/:code/status", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"policy token", "GET", "/api/v1/workloads", "viewer-secret", http.StatusOK},
+		{"unknown token", "POST", "/api/v1/nodes/register", "unknown", http.StatusUnauthorized},
+		{"join token registers", "POST", "/api/v1/nodes/register", joinSecret, http.StatusOK},
+		{"join token heartbeats its node", "POST", "/api/v1/nodes/node-1/heartbeat", joinSecret, http.StatusOK},
+		{"join token heartbeats another node", "POST", "/api/v1/nodes/node-2/heartbeat", joinSecret, http.StatusForbidden},
+		{"join token drains its node", "POST", "/api/v1/nodes/node-1/drain", joinSecret, http.StatusForbidden},
+		{"join token lists workloads", "GET", "/api/v1/workloads", joinSecret, http.StatusForbidden},
+		{"new device announces", "POST", "/api/v1/claims", "fresh-device-secret", http.StatusOK},
+		{"device polls its claim", "GET", "/api/v1/claims/abcd2345/status", "claim-secret", http.StatusOK},
+		{"device polls another claim", "GET", "/api/v1/claims/ABCD-2345/status", "other-secret", http.StatusUnauthorized},
+		{"claim secret registers", "POST", "/api/v1/nodes/register", "claim-secret", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != test.want {
+				t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.path, recorder.Code, test.want, recorder.Body.String())
+			}
+		})
+	}
+}
+
+// TestNodeCertificateRoutes checks that a node authenticated with its certificate reaches the
+// agent routes of its own node and nothing else, with or without an access policy
+func TestNodeCertificateRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, withPolicy := range []bool{false, true} {
+		security := &SecurityManager{
+			certificates: map[string]*Certificate{"cert-1": {ID: "cert-1", NodeID: "node-1", IssuedAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)}},
+			fingerprints: map[string]string{certificateFingerprint(der): "cert-1"},
+			logger:       logger,
+		}
+		if withPolicy {
+			security.policy = &AccessPolicy{tokens: make(map[string]*APIToken)}
+		}
+		co := &CentralOrchestrator{
+			NodeManager:     NewNodeManager(logger),
+			WorkloadManager: NewWorkloadManager(logger),
+			SecurityManager: security,
+			Logger:          logger,
+		}
+		co.NodeManager.nodes["node-1"] = &EdgeNode{ID: "node-1", Name: "node-1"}
+		co.NodeManager.nodes["node-2"] = &EdgeNode{ID: "node-2", Name: "node-2"}
+
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		router := gin.New()
+		router.Use(security.AuthMiddleware())
+		router.Use(co.AuthorizeMiddleware())
+		v1 := router.Group("/api/v1")
+		v1.POST("/nodes/register", ok)
+		v1.POST("/nodes/heartbeats", ok)
+		co.nodeRoute(v1, http.MethodPost, "/nodes/:id/heartbeat", ok)
+		v1.POST("/nodes/:id/drain", ok)
+		v1.GET("/admin/consistency", ok)
+		v1.POST("/admin/storage/backup", ok)
+		v1.POST("/certificates/issue", ok)
+		v1.DELETE("/workloads/:id", ok)
+		v1.PUT("/tenant-quotas/:tenant", ok)
+		v1.POST("/auth/token", ok)
+
+		tests := []struct {
+			method string
+			path   string
+			want   int
+		}{
+			{"POST", "/api/v1/nodes/register", http.StatusOK},
+			{"POST", "/api/v1/nodes/heartbeats", http.StatusOK},
+			{"POST", "/api/v1/nodes/node-1/heartbeat", http.StatusOK},
+			{"POST", "/api/v1/nodes/node-2/heartbeat", http.StatusForbidden},
+			{"POST", "/api/v1/nodes/node-1/drain", http.StatusForbidden},
+			{"GET", "/api/v1/admin/consistency", http.StatusForbidden},
+			{"POST", "/api/v1/admin/storage/backup", http.StatusForbidden},
+			{"POST", "/api/v1/certificates/issue", http.StatusForbidden},
+			{"DELETE", "/api/v1/workloads/w-1", http.StatusForbidden},
+			{"PUT", "/api/v1/tenant-quotas/team-a", http.StatusForbidden},
+			{"POST", "/api/v1/auth/token", http.StatusForbidden},
+		}
+		for _, test := range tests {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != test.want {
+				t.Errorf("Policy %v, %s %s: got status %d, want %d: %s", withPolicy, test.method, test.path, recorder.Code, test.want, recorder.Body.String())
+			}
+		}
+	}
+}
+
+// TestImpersonationKeepsTenantLimits checks that an admin limited to tenants can only
+// impersonate users bound within them, and that the limit stays on the request
+func TestImpersonationKeepsTenantLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	limitedSum := sha256.Sum256([]byte("limited-admin"))
+	fullSum := sha256.Sum256([]byte("full-admin"))
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	policyJSON := `{
+		"tokens": [
+			{"token_sha256": "` + hex.EncodeToString(limitedSum[:]) + `", "user": "ci-a", "role": "admin", "tenants": ["team-a"]},
+			{"token_sha256": "` + hex.EncodeToString(fullSum[:]) + `", "user": "gitops", "role": "admin"}
+		],
+		"bindings": [
+			{"group": "everyone", "role": "operator"},
+			{"group": "team-a", "role": "operator", "tenants": ["team-a"]},
+			{"group": "team-ab", "role": "operator", "tenants": ["team-a", "team-b"]}
+		]
+	}`
+	if err := os.WriteFile(policyFile, []byte(policyJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := loadAccessPolicy(policyFile)
+	if err != nil {
+		t.Fatalf("Failed to load access policy: %v", err)
+	}
+	security := &SecurityManager{policy: policy, logger: logger}
+
+	router := gin.New()
+	router.Use(security.AuthMiddleware())
+	router.Use(security.ImpersonationMiddleware())
+	router.GET("/api/v1/workloads", func(c *gin.Context) {
+		tenants, limited := c.Get(ContextKeyTenants)
+		c.JSON(http.StatusOK, gin.H{"limited": limited, "tenants": tenants})
+	})
+
+	tests := []struct {
+		name        string
+		token       string
+		group       string
+		want        int
+		wantTenants string
+	}{
+		{"limited admin as user on every tenant", "limited-admin", "everyone", http.StatusForbidden, ""},
+		{"limited admin as user on wider tenants", "limited-admin", "team-ab", http.StatusForbidden, ""},
+		{"limited admin as user on its tenant", "limited-admin", "team-a", http.StatusOK, `{"limited":true,"tenants":["team-a"]}`},
+		{"full admin as user on every tenant", "full-admin", "everyone", http.StatusOK, `{"limited":false,"tenants":null}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/workloads", nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			req.Header.Set(ImpersonateUserHeader, "deploy-bot")
+			req.Header.Set(ImpersonateGroupHeader, test.group)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != test.want {
+				t.Fatalf("Got status %d, want %d: %s", recorder.Code, test.want, recorder.Body.String())
+			}
+			if test.wantTenants != "" && recorder.Body.String() != test.wantTenants {
+				t.Errorf("Got %s, want %s", recorder.Body.String(), test.wantTenants)
+			}
+		})
+	}
+}
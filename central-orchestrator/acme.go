@@ -0,0 +1,998 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long a Replay-Nonce stays usable
+	ACMENonceLifetime = time.Hour
+
+	// How long a new order and its authorizations stay pending
+	ACMEOrderLifetime = 24 * time.Hour
+
+	// Validity of certificates issued through ACME
+	ACMECertificateValidity = 90 * 24 * time.Hour
+
+	// Time allowed for one http-01 or dns-01 validation
+	ACMEValidationTimeout = 10 * time.Second
+
+	// Largest JWS request body accepted
+	ACMEMaxRequestBytes = 64 * 1024
+
+	// How often expired nonces, orders and certificates are pruned
+	ACMEJanitorInterval = 5 * time.Minute
+
+	acmeErrorPrefix = "urn:ietf:params:acme:error:"
+)
+
+// ACMEStatus is the state of an ACME account, order, authorization or challenge
+// (RFC 8555 §7.1.6)
+type ACMEStatus string
+
+const (
+	ACMEStatusPending     ACMEStatus = "pending"
+	ACMEStatusReady       ACMEStatus = "ready"
+	ACMEStatusProcessing  ACMEStatus = "processing"
+	ACMEStatusValid       ACMEStatus = "valid"
+	ACMEStatusInvalid     ACMEStatus = "invalid"
+	ACMEStatusExpired     ACMEStatus = "expired"
+	ACMEStatusDeactivated ACMEStatus = "deactivated"
+)
+
+// ACME challenge types offered for DNS identifiers
+const (
+	ACMEChallengeHTTP01 = "http-01"
+	ACMEChallengeDNS01  = "dns-01"
+)
+
+// ACMEIdentifier names what a certificate is requested for; only "dns" is supported
+type ACMEIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ACMEProblem is an RFC 7807 problem document with an ACME error type
+type ACMEProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func acmeProblem(kind, detail string, status int) *ACMEProblem {
+	return &ACMEProblem{Type: acmeErrorPrefix + kind, Detail: detail, Status: status}
+}
+
+// ACMEAccount is an ACME client identified by its account key
+type ACMEAccount struct {
+	ID         string
+	Status     ACMEStatus
+	Contact    []string
+	Thumbprint string
+	Key        crypto.PublicKey
+	CreatedAt  time.Time
+}
+
+// ACMEChallenge is one way of proving control of an authorization's identifier
+type ACMEChallenge struct {
+	ID              string
+	AuthorizationID string
+	Type            string
+	Token           string
+	Status          ACMEStatus
+	Validated       *time.Time
+	Error           *ACMEProblem
+}
+
+// ACMEAuthorization is an account's proof of control over one identifier. Wildcard
+// identifiers are authorized for their base domain.
+type ACMEAuthorization struct {
+	ID         string
+	AccountID  string
+	Identifier ACMEIdentifier
+	Wildcard   bool
+	Status     ACMEStatus
+	Expires    time.Time
+	Challenges []*ACMEChallenge
+}
+
+// ACMEOrder is a request for a certificate covering a set of identifiers
+type ACMEOrder struct {
+	ID             string
+	AccountID      string
+	Status         ACMEStatus
+	Identifiers    []ACMEIdentifier
+	Authorizations []*ACMEAuthorization
+	Expires        time.Time
+	CertificateID  string
+	Error          *ACMEProblem
+}
+
+// acmeCertificate is a certificate issued for an order, served as leaf plus chain
+type acmeCertificate struct {
+	AccountID   string
+	Fingerprint string
+	PEM         []byte
+	ExpiresAt   time.Time
+}
+
+// ACMEServer issues certificates from the framework CA to standard ACME clients on
+// edge clusters, such as cert-manager and certbot
+type ACMEServer struct {
+	accounts       map[string]*ACMEAccount
+	accountKeys    map[string]string // key thumbprint -> account ID
+	orders         map[string]*ACMEOrder
+	authorizations map[string]*ACMEAuthorization
+	challenges     map[string]*ACMEChallenge
+	certificates   map[string]*acmeCertificate
+	mutex          sync.Mutex
+
+	nonces     map[string]time.Time
+	nonceMutex sync.Mutex
+
+	enabled bool
+	// Base URL clients reach the orchestrator at; derived from the request when unset
+	externalURL string
+	// DNS suffixes identifiers must fall under; empty allows any validated name
+	allowedDomains []string
+	httpClient     *http.Client
+	logger         *logrus.Logger
+}
+
+// NewACMEServer creates the ACME server, enabled by ACME_ENABLED=true
+func NewACMEServer(logger *logrus.Logger) *ACMEServer {
+	server := &ACMEServer{
+		accounts:       make(map[string]*ACMEAccount),
+		accountKeys:    make(map[string]string),
+		orders:         make(map[string]*ACMEOrder),
+		authorizations: make(map[string]*ACMEAuthorization),
+		challenges:     make(map[string]*ACMEChallenge),
+		certificates:   make(map[string]*acmeCertificate),
+		nonces:         make(map[string]time.Time),
+		enabled:        os.Getenv("ACME_ENABLED") == "true",
+		externalURL:    strings.TrimSuffix(os.Getenv("ACME_EXTERNAL_URL"), "/"),
+		httpClient:     &http.Client{Timeout: ACMEValidationTimeout},
+		logger:         logger,
+	}
+
+	for _, domain := range strings.Split(os.Getenv("ACME_ALLOWED_DOMAINS"), ",") {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			server.allowedDomains = append(server.allowedDomains, domain)
+		}
+	}
+
+	return server
+}
+
+// Enabled reports whether the ACME endpoints are served
+func (s *ACMEServer) Enabled() bool {
+	return s.enabled
+}
+
+// baseURL returns the URL prefix ACME resources are addressed under
+func (s *ACMEServer) baseURL(c *gin.Context) string {
+	if s.externalURL != "" {
+		return s.externalURL
+	}
+	return "https://" + c.Request.Host
+}
+
+func (s *ACMEServer) newNonce() string {
+	nonce := base64.RawURLEncoding.EncodeToString([]byte(generateID()))
+
+	s.nonceMutex.Lock()
+	s.nonces[nonce] = time.Now()
+	s.nonceMutex.Unlock()
+
+	return nonce
+}
+
+// consumeNonce reports whether a nonce was issued and unused, and marks it used
+func (s *ACMEServer) consumeNonce(nonce string) bool {
+	s.nonceMutex.Lock()
+	defer s.nonceMutex.Unlock()
+
+	issuedAt, exists := s.nonces[nonce]
+	if !exists {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return time.Since(issuedAt) < ACMENonceLifetime
+}
+
+// setResponseHeaders adds the fresh nonce and directory link every ACME response carries
+func (s *ACMEServer) setResponseHeaders(c *gin.Context) {
+	c.Header("Replay-Nonce", s.newNonce())
+	c.Header("Link", fmt.Sprintf(`<%s/acme/directory>;rel="index"`, s.baseURL(c)))
+	c.Header("Cache-Control", "no-store")
+}
+
+func (s *ACMEServer) respond(c *gin.Context, status int, location string, body interface{}) {
+	s.setResponseHeaders(c)
+	if location != "" {
+		c.Header("Location", location)
+	}
+	c.JSON(status, body)
+}
+
+func (s *ACMEServer) problem(c *gin.Context, problem *ACMEProblem) {
+	s.setResponseHeaders(c)
+	data, _ := json.Marshal(problem)
+	c.Data(problem.Status, "application/problem+json", data)
+}
+
+// acmeRequest is a verified ACME request. Account is set for kid-signed requests;
+// new-account requests carry the key itself.
+type acmeRequest struct {
+	Payload    []byte
+	Account    *ACMEAccount
+	Key        crypto.PublicKey
+	Thumbprint string
+}
+
+// verifyRequest authenticates a JWS-signed ACME request. New-account requests are signed
+// with an embedded jwk, every other request with the kid of a valid account.
+func (s *ACMEServer) verifyRequest(c *gin.Context, embeddedKey bool) (*acmeRequest, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, ACMEMaxRequestBytes))
+	if err != nil {
+		s.problem(c, acmeProblem("malformed", "failed to read request body", http.StatusBadRequest))
+		return nil, false
+	}
+
+	msg, header, payload, err := parseJWS(body)
+	if err != nil {
+		s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+		return nil, false
+	}
+	if !s.consumeNonce(header.Nonce) {
+		s.problem(c, acmeProblem("badNonce", "nonce is unknown, used or expired", http.StatusBadRequest))
+		return nil, false
+	}
+	base := s.baseURL(c)
+	if header.URL != base+c.Request.URL.Path {
+		s.problem(c, acmeProblem("unauthorized", "url header does not match the request URL", http.StatusUnauthorized))
+		return nil, false
+	}
+
+	req := &acmeRequest{Payload: payload}
+	if embeddedKey {
+		if len(header.JWK) == 0 {
+			s.problem(c, acmeProblem("malformed", "request must be signed with an embedded jwk", http.StatusBadRequest))
+			return nil, false
+		}
+		req.Key, req.Thumbprint, err = parseJWK(header.JWK)
+		if err != nil {
+			s.problem(c, acmeProblem("badPublicKey", err.Error(), http.StatusBadRequest))
+			return nil, false
+		}
+	} else {
+		if header.KID == "" {
+			s.problem(c, acmeProblem("malformed", "request must be signed with an account kid", http.StatusBadRequest))
+			return nil, false
+		}
+		s.mutex.Lock()
+		account, exists := s.accounts[strings.TrimPrefix(header.KID, base+"/acme/account/")]
+		s.mutex.Unlock()
+		if !exists {
+			s.problem(c, acmeProblem("accountDoesNotExist", "no account for kid", http.StatusBadRequest))
+			return nil, false
+		}
+		if account.Status != ACMEStatusValid {
+			s.problem(c, acmeProblem("unauthorized", "account is "+string(account.Status), http.StatusUnauthorized))
+			return nil, false
+		}
+		req.Account, req.Key, req.Thumbprint = account, account.Key, account.Thumbprint
+	}
+
+	if err := msg.verify(header.Alg, req.Key); err != nil {
+		s.problem(c, acmeProblem("malformed", fmt.Sprintf("JWS verification failed: %v", err), http.StatusBadRequest))
+		return nil, false
+	}
+	return req, true
+}
+
+// refresh moves a pending authorization past its expiry to expired
+func (authz *ACMEAuthorization) refresh(now time.Time) {
+	if authz.Status == ACMEStatusPending && now.After(authz.Expires) {
+		authz.Status = ACMEStatusExpired
+	}
+}
+
+// refresh derives a pending order's status from its authorizations
+func (order *ACMEOrder) refresh(now time.Time) {
+	if order.Status != ACMEStatusPending {
+		return
+	}
+	if now.After(order.Expires) {
+		order.Status = ACMEStatusInvalid
+		order.Error = acmeProblem("unauthorized", "order expired before it was authorized", http.StatusForbidden)
+		return
+	}
+
+	ready := true
+	for _, authz := range order.Authorizations {
+		authz.refresh(now)
+		switch authz.Status {
+		case ACMEStatusValid:
+		case ACMEStatusPending:
+			ready = false
+		default:
+			order.Status = ACMEStatusInvalid
+			order.Error = acmeProblem("unauthorized", fmt.Sprintf("authorization for %s is %s", authz.Identifier.Value, authz.Status), http.StatusForbidden)
+			return
+		}
+	}
+	if ready {
+		order.Status = ACMEStatusReady
+	}
+}
+
+func (s *ACMEServer) accountJSON(account *ACMEAccount) gin.H {
+	return gin.H{
+		"status":  account.Status,
+		"contact": account.Contact,
+	}
+}
+
+func (s *ACMEServer) orderJSON(base string, order *ACMEOrder) gin.H {
+	authorizations := make([]string, 0, len(order.Authorizations))
+	for _, authz := range order.Authorizations {
+		authorizations = append(authorizations, base+"/acme/authz/"+authz.ID)
+	}
+
+	body := gin.H{
+		"status":         order.Status,
+		"expires":        order.Expires,
+		"identifiers":    order.Identifiers,
+		"authorizations": authorizations,
+		"finalize":       base + "/acme/order/" + order.ID + "/finalize",
+	}
+	if order.CertificateID != "" {
+		body["certificate"] = base + "/acme/certificate/" + order.CertificateID
+	}
+	if order.Error != nil {
+		body["error"] = order.Error
+	}
+	return body
+}
+
+func (s *ACMEServer) challengeJSON(base string, challenge *ACMEChallenge) gin.H {
+	body := gin.H{
+		"type":   challenge.Type,
+		"url":    base + "/acme/challenge/" + challenge.ID,
+		"token":  challenge.Token,
+		"status": challenge.Status,
+	}
+	if challenge.Validated != nil {
+		body["validated"] = challenge.Validated
+	}
+	if challenge.Error != nil {
+		body["error"] = challenge.Error
+	}
+	return body
+}
+
+func (s *ACMEServer) authorizationJSON(base string, authz *ACMEAuthorization) gin.H {
+	challenges := make([]gin.H, 0, len(authz.Challenges))
+	for _, challenge := range authz.Challenges {
+		challenges = append(challenges, s.challengeJSON(base, challenge))
+	}
+
+	body := gin.H{
+		"identifier": authz.Identifier,
+		"status":     authz.Status,
+		"expires":    authz.Expires,
+		"challenges": challenges,
+	}
+	if authz.Wildcard {
+		body["wildcard"] = true
+	}
+	return body
+}
+
+// prune drops used-up nonces, expires stale orders and forgets expired certificates
+func (s *ACMEServer) prune(now time.Time) {
+	s.nonceMutex.Lock()
+	for nonce, issuedAt := range s.nonces {
+		if now.Sub(issuedAt) >= ACMENonceLifetime {
+			delete(s.nonces, nonce)
+		}
+	}
+	s.nonceMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, cert := range s.certificates {
+		if now.After(cert.ExpiresAt) {
+			delete(s.certificates, id)
+		}
+	}
+
+	for id, order := range s.orders {
+		order.refresh(now)
+		if now.Sub(order.Expires) < ACMEOrderLifetime {
+			continue
+		}
+		if _, issued := s.certificates[order.CertificateID]; issued {
+			continue
+		}
+		for _, authz := range order.Authorizations {
+			for _, challenge := range authz.Challenges {
+				delete(s.challenges, challenge.ID)
+			}
+			delete(s.authorizations, authz.ID)
+		}
+		delete(s.orders, id)
+	}
+}
+
+// acmeJanitor periodically prunes ACME state
+func (co *CentralOrchestrator) acmeJanitor() {
+	if !co.ACMEServer.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(ACMEJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.ACMEServer.prune(time.Now())
+		}
+	}
+}
+
+// GetACMEDirectory returns the ACME directory clients are configured with
+func (co *CentralOrchestrator) GetACMEDirectory(c *gin.Context) {
+	base := co.ACMEServer.baseURL(c)
+	c.JSON(http.StatusOK, gin.H{
+		"newNonce":   base + "/acme/new-nonce",
+		"newAccount": base + "/acme/new-account",
+		"newOrder":   base + "/acme/new-order",
+		"revokeCert": base + "/acme/revoke-cert",
+		"meta": gin.H{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+// NewACMENonce hands out a fresh Replay-Nonce
+func (co *CentralOrchestrator) NewACMENonce(c *gin.Context) {
+	co.ACMEServer.setResponseHeaders(c)
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// NewACMEAccount registers an account key, or returns the existing account for it
+func (co *CentralOrchestrator) NewACMEAccount(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, true)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	base := s.baseURL(c)
+	if id, exists := s.accountKeys[req.Thumbprint]; exists {
+		s.respond(c, http.StatusOK, base+"/acme/account/"+id, s.accountJSON(s.accounts[id]))
+		return
+	}
+	if payload.OnlyReturnExisting {
+		s.problem(c, acmeProblem("accountDoesNotExist", "no account exists for this key", http.StatusBadRequest))
+		return
+	}
+	if problem := checkACMEContacts(payload.Contact); problem != nil {
+		s.problem(c, problem)
+		return
+	}
+
+	account := &ACMEAccount{
+		ID:         generateID(),
+		Status:     ACMEStatusValid,
+		Contact:    payload.Contact,
+		Thumbprint: req.Thumbprint,
+		Key:        req.Key,
+		CreatedAt:  time.Now(),
+	}
+	s.accounts[account.ID] = account
+	s.accountKeys[account.Thumbprint] = account.ID
+
+	s.logger.Infof("ACME account %s registered (contact %v)", account.ID, account.Contact)
+	s.respond(c, http.StatusCreated, base+"/acme/account/"+account.ID, s.accountJSON(account))
+}
+
+// checkACMEContacts accepts mailto: contact URLs only
+func checkACMEContacts(contacts []string) *ACMEProblem {
+	for _, contact := range contacts {
+		if !strings.HasPrefix(contact, "mailto:") || len(contact) == len("mailto:") {
+			return acmeProblem("unsupportedContact", fmt.Sprintf("contact %q is not a mailto: URL", contact), http.StatusBadRequest)
+		}
+	}
+	return nil
+}
+
+// UpdateACMEAccount returns an account, or updates its contacts or deactivates it
+func (co *CentralOrchestrator) UpdateACMEAccount(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+	if req.Account.ID != c.Param("id") {
+		s.problem(c, acmeProblem("unauthorized", "request is not signed by this account", http.StatusUnauthorized))
+		return
+	}
+
+	var payload struct {
+		Contact []string   `json:"contact"`
+		Status  ACMEStatus `json:"status"`
+	}
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	account := req.Account
+	if payload.Contact != nil {
+		if problem := checkACMEContacts(payload.Contact); problem != nil {
+			s.problem(c, problem)
+			return
+		}
+		account.Contact = payload.Contact
+	}
+	switch payload.Status {
+	case "":
+	case ACMEStatusDeactivated:
+		account.Status = ACMEStatusDeactivated
+		s.logger.Infof("ACME account %s deactivated", account.ID)
+	default:
+		s.problem(c, acmeProblem("malformed", "status may only be set to deactivated", http.StatusBadRequest))
+		return
+	}
+
+	s.respond(c, http.StatusOK, "", s.accountJSON(account))
+}
+
+// NewACMEOrder creates an order and a pending authorization for each identifier
+func (co *CentralOrchestrator) NewACMEOrder(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Identifiers []ACMEIdentifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.problem(c, acmeProblem("malformed", "order must contain at least one identifier", http.StatusBadRequest))
+		return
+	}
+
+	now := time.Now()
+	order := &ACMEOrder{
+		ID:        generateID(),
+		AccountID: req.Account.ID,
+		Status:    ACMEStatusPending,
+		Expires:   now.Add(ACMEOrderLifetime),
+	}
+
+	seen := make(map[string]bool)
+	for _, identifier := range payload.Identifiers {
+		domain, wildcard, problem := s.checkIdentifier(identifier)
+		if problem != nil {
+			s.problem(c, problem)
+			return
+		}
+		value := domain
+		if wildcard {
+			value = "*." + domain
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		order.Identifiers = append(order.Identifiers, ACMEIdentifier{Type: "dns", Value: value})
+
+		authz := &ACMEAuthorization{
+			ID:         generateID(),
+			AccountID:  req.Account.ID,
+			Identifier: ACMEIdentifier{Type: "dns", Value: domain},
+			Wildcard:   wildcard,
+			Status:     ACMEStatusPending,
+			Expires:    order.Expires,
+		}
+		// Wildcards can only be proven through DNS
+		challengeTypes := []string{ACMEChallengeHTTP01, ACMEChallengeDNS01}
+		if wildcard {
+			challengeTypes = []string{ACMEChallengeDNS01}
+		}
+		for _, challengeType := range challengeTypes {
+			authz.Challenges = append(authz.Challenges, &ACMEChallenge{
+				ID:              generateID(),
+				AuthorizationID: authz.ID,
+				Type:            challengeType,
+				Token:           generateID(),
+				Status:          ACMEStatusPending,
+			})
+		}
+		order.Authorizations = append(order.Authorizations, authz)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.orders[order.ID] = order
+	for _, authz := range order.Authorizations {
+		s.authorizations[authz.ID] = authz
+		for _, challenge := range authz.Challenges {
+			s.challenges[challenge.ID] = challenge
+		}
+	}
+
+	s.logger.Infof("ACME order %s created by account %s for %v", order.ID, order.AccountID, order.Identifiers)
+	base := s.baseURL(c)
+	s.respond(c, http.StatusCreated, base+"/acme/order/"+order.ID, s.orderJSON(base, order))
+}
+
+// GetACMEOrder returns an order's current state
+func (co *CentralOrchestrator) GetACMEOrder(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	order, exists := s.orders[c.Param("id")]
+	if !exists || order.AccountID != req.Account.ID {
+		s.problem(c, acmeProblem("malformed", "order not found", http.StatusNotFound))
+		return
+	}
+	order.refresh(time.Now())
+
+	s.respond(c, http.StatusOK, "", s.orderJSON(s.baseURL(c), order))
+}
+
+// GetACMEAuthorization returns an authorization, or deactivates it
+func (co *CentralOrchestrator) GetACMEAuthorization(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Status ACMEStatus `json:"status"`
+	}
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	authz, exists := s.authorizations[c.Param("id")]
+	if !exists || authz.AccountID != req.Account.ID {
+		s.problem(c, acmeProblem("malformed", "authorization not found", http.StatusNotFound))
+		return
+	}
+	authz.refresh(time.Now())
+
+	switch payload.Status {
+	case "":
+	case ACMEStatusDeactivated:
+		if authz.Status != ACMEStatusPending && authz.Status != ACMEStatusValid {
+			s.problem(c, acmeProblem("malformed", "authorization is "+string(authz.Status), http.StatusBadRequest))
+			return
+		}
+		authz.Status = ACMEStatusDeactivated
+	default:
+		s.problem(c, acmeProblem("malformed", "status may only be set to deactivated", http.StatusBadRequest))
+		return
+	}
+
+	s.respond(c, http.StatusOK, "", s.authorizationJSON(s.baseURL(c), authz))
+}
+
+// RespondACMEChallenge starts validating a challenge when the client signals it is
+// ready, and otherwise returns the challenge's state
+func (co *CentralOrchestrator) RespondACMEChallenge(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	challenge, exists := s.challenges[c.Param("id")]
+	if !exists {
+		s.problem(c, acmeProblem("malformed", "challenge not found", http.StatusNotFound))
+		return
+	}
+	authz := s.authorizations[challenge.AuthorizationID]
+	if authz == nil || authz.AccountID != req.Account.ID {
+		s.problem(c, acmeProblem("malformed", "challenge not found", http.StatusNotFound))
+		return
+	}
+	authz.refresh(time.Now())
+
+	// An empty payload is a POST-as-GET; "{}" asks the server to validate
+	if len(req.Payload) > 0 && challenge.Status == ACMEStatusPending {
+		if authz.Status != ACMEStatusPending {
+			s.problem(c, acmeProblem("malformed", "authorization is "+string(authz.Status), http.StatusBadRequest))
+			return
+		}
+		challenge.Status = ACMEStatusProcessing
+		go co.validateACMEChallenge(challenge.ID)
+	}
+
+	base := s.baseURL(c)
+	c.Writer.Header().Add("Link", fmt.Sprintf(`<%s/acme/authz/%s>;rel="up"`, base, authz.ID))
+	s.respond(c, http.StatusOK, "", s.challengeJSON(base, challenge))
+}
+
+// FinalizeACMEOrder signs the order's CSR once every identifier is authorized
+func (co *CentralOrchestrator) FinalizeACMEOrder(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	s.mutex.Lock()
+	order, exists := s.orders[c.Param("id")]
+	if !exists || order.AccountID != req.Account.ID {
+		s.mutex.Unlock()
+		s.problem(c, acmeProblem("malformed", "order not found", http.StatusNotFound))
+		return
+	}
+	order.refresh(time.Now())
+	if order.Status != ACMEStatusReady {
+		s.mutex.Unlock()
+		s.problem(c, acmeProblem("orderNotReady", "order is "+string(order.Status), http.StatusForbidden))
+		return
+	}
+
+	csr, problem := parseACMECSR(payload.CSR, order.Identifiers)
+	if problem != nil {
+		s.mutex.Unlock()
+		s.problem(c, problem)
+		return
+	}
+	order.Status = ACMEStatusProcessing
+	s.mutex.Unlock()
+
+	certID, cert, err := co.issueACMECertificate(csr)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		s.logger.Errorf("ACME order %s could not be issued: %v", order.ID, err)
+		order.Status = ACMEStatusInvalid
+		order.Error = acmeProblem("serverInternal", "certificate signing failed", http.StatusInternalServerError)
+		s.problem(c, order.Error)
+		return
+	}
+
+	cert.AccountID = order.AccountID
+	s.certificates[certID] = cert
+	order.CertificateID = certID
+	order.Status = ACMEStatusValid
+
+	s.logger.Infof("ACME order %s issued certificate %s for %v", order.ID, certID, order.Identifiers)
+	base := s.baseURL(c)
+	s.respond(c, http.StatusOK, base+"/acme/order/"+order.ID, s.orderJSON(base, order))
+}
+
+// parseACMECSR decodes a finalize CSR and checks it names exactly the order's identifiers
+func parseACMECSR(encoded string, identifiers []ACMEIdentifier) (*x509.CertificateRequest, *ACMEProblem) {
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, acmeProblem("badCSR", "csr is not base64url", http.StatusBadRequest)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, acmeProblem("badCSR", fmt.Sprintf("failed to parse csr: %v", err), http.StatusBadRequest)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, acmeProblem("badCSR", fmt.Sprintf("csr signature is invalid: %v", err), http.StatusBadRequest)
+	}
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, acmeProblem("badCSR", "csr may only request DNS names", http.StatusBadRequest)
+	}
+
+	requested := make(map[string]bool)
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+	if csr.Subject.CommonName != "" {
+		requested[strings.ToLower(csr.Subject.CommonName)] = true
+	}
+
+	if len(requested) != len(identifiers) {
+		return nil, acmeProblem("badCSR", "csr names do not match the order identifiers", http.StatusBadRequest)
+	}
+	for _, identifier := range identifiers {
+		if !requested[identifier.Value] {
+			return nil, acmeProblem("badCSR", fmt.Sprintf("csr does not request %s", identifier.Value), http.StatusBadRequest)
+		}
+	}
+	return csr, nil
+}
+
+// issueACMECertificate signs a CSR with the fleet CA and adds it to the certificate
+// inventory. ACME certificates are not node credentials, so they never authenticate API
+// callers.
+func (co *CentralOrchestrator) issueACMECertificate(csr *x509.CertificateRequest) (string, *acmeCertificate, error) {
+	signer, err := co.SecurityManager.certificateSigner()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CertificateSigningTimeout)
+	defer cancel()
+
+	signed, err := signer.Sign(ctx, &SigningRequest{
+		CSR:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}),
+		Request:  csr,
+		Validity: ACMECertificateValidity,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign certificate with %s signer: %v", signer.Name(), err)
+	}
+
+	block, _ := pem.Decode(signed.Certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", nil, fmt.Errorf("%s signer returned an invalid certificate", signer.Name())
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse signed certificate: %v", err)
+	}
+
+	record := &Certificate{
+		ID:          generateID(),
+		Certificate: signed.Certificate,
+		Chain:       signed.Chain,
+		Signer:      signer.Name(),
+		IssuedAt:    leaf.NotBefore,
+		ExpiresAt:   leaf.NotAfter,
+	}
+	co.SecurityManager.mutex.Lock()
+	co.SecurityManager.certificates[record.ID] = record
+	co.SecurityManager.mutex.Unlock()
+
+	chain := append(append([]byte{}, signed.Certificate...), signed.Chain...)
+	return record.ID, &acmeCertificate{
+		Fingerprint: certificateFingerprint(block.Bytes),
+		PEM:         chain,
+		ExpiresAt:   leaf.NotAfter,
+	}, nil
+}
+
+// GetACMECertificate downloads an issued certificate with its chain
+func (co *CentralOrchestrator) GetACMECertificate(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	cert, exists := s.certificates[c.Param("id")]
+	s.mutex.Unlock()
+
+	if !exists || cert.AccountID != req.Account.ID {
+		s.problem(c, acmeProblem("malformed", "certificate not found", http.StatusNotFound))
+		return
+	}
+
+	s.setResponseHeaders(c)
+	c.Data(http.StatusOK, "application/pem-certificate-chain", cert.PEM)
+}
+
+// RevokeACMECertificate revokes a certificate issued to the requesting account
+func (co *CentralOrchestrator) RevokeACMECertificate(c *gin.Context) {
+	s := co.ACMEServer
+	req, ok := s.verifyRequest(c, false)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.problem(c, acmeProblem("malformed", err.Error(), http.StatusBadRequest))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		s.problem(c, acmeProblem("malformed", "certificate is not base64url", http.StatusBadRequest))
+		return
+	}
+
+	fingerprint := certificateFingerprint(der)
+	certID := ""
+	s.mutex.Lock()
+	for id, cert := range s.certificates {
+		if cert.Fingerprint == fingerprint && cert.AccountID == req.Account.ID {
+			certID = id
+			break
+		}
+	}
+	s.mutex.Unlock()
+
+	if certID == "" {
+		s.problem(c, acmeProblem("unauthorized", "certificate was not issued to this account", http.StatusForbidden))
+		return
+	}
+	if err := co.SecurityManager.RevokeCertificate(certID); err != nil {
+		s.problem(c, acmeProblem("alreadyRevoked", err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	co.Logger.Infof("ACME certificate %s revoked by account %s (reason %d)", certID, req.Account.ID, payload.Reason)
+	s.setResponseHeaders(c)
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// jwsMessage is the flattened JWS serialization ACME requests are sent in (RFC 8555 §6.2)
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader is the protected header of an ACME request. Exactly one of JWK (new-account
+// and revoke-cert) or KID (everything else) is set.
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	KID   string          `json:"kid,omitempty"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+}
+
+// jsonWebKey is an RSA or EC public key in JWK form (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// parseJWS decodes a flattened JWS without verifying it
+func parseJWS(body []byte) (*jwsMessage, *jwsHeader, []byte, error) {
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, nil, nil, fmt.Errorf("request is not a flattened JWS: %v", err)
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("protected header is not base64url: %v", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, nil, fmt.Errorf("protected header is not JSON: %v", err)
+	}
+	if (header.KID == "") == (len(header.JWK) == 0) {
+		return nil, nil, nil, fmt.Errorf("protected header must contain exactly one of jwk and kid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("payload is not base64url: %v", err)
+	}
+	return &msg, &header, payload, nil
+}
+
+// verify checks the JWS signature with the given account key
+func (msg *jwsMessage) verify(alg string, key crypto.PublicKey) error {
+	signature, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64url: %v", err)
+	}
+	signingInput := []byte(msg.Protected + "." + msg.Payload)
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RS256 requires an RSA key")
+		}
+		digest := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "ES256":
+		digest := sha256.Sum256(signingInput)
+		return verifyECDSA(key, elliptic.P256(), digest[:], signature)
+	case "ES384":
+		digest := sha512.Sum384(signingInput)
+		return verifyECDSA(key, elliptic.P384(), digest[:], signature)
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+}
+
+// verifyECDSA checks a JWS ECDSA signature, which is r and s concatenated (RFC 7518 §3.4)
+func verifyECDSA(key crypto.PublicKey, curve elliptic.Curve, digest, signature []byte) error {
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != curve {
+		return fmt.Errorf("algorithm does not match the account key curve")
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return fmt.Errorf("invalid signature length")
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	if !ecdsa.Verify(pub, digest, r, s) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// parseJWK decodes a JWK into a public key and its RFC 7638 thumbprint
+func parseJWK(raw json.RawMessage) (crypto.PublicKey, string, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, "", fmt.Errorf("jwk is not a JSON object: %v", err)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil {
+			return nil, "", err
+		}
+		if n.BitLen() < RSAKeySize || !e.IsInt64() {
+			return nil, "", fmt.Errorf("RSA account keys must be at least %d bits", RSAKeySize)
+		}
+		key := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		canonical := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+		return key, jwkThumbprint(canonical), nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, "", fmt.Errorf("EC account key is not on curve %s", jwk.Crv)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		canonical := fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+		return key, jwkThumbprint(canonical), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("jwk contains an invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func jwkThumbprint(canonical string) string {
+	digest := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// checkIdentifier normalizes a DNS identifier, splitting off a leading wildcard label,
+// and checks it against the allowed domains
+func (s *ACMEServer) checkIdentifier(identifier ACMEIdentifier) (string, bool, *ACMEProblem) {
+	if identifier.Type != "dns" {
+		return "", false, acmeProblem("unsupportedIdentifier", fmt.Sprintf("identifier type %q is not supported", identifier.Type), http.StatusBadRequest)
+	}
+
+	domain := strings.TrimSuffix(strings.ToLower(identifier.Value), ".")
+	wildcard := strings.HasPrefix(domain, "*.")
+	domain = strings.TrimPrefix(domain, "*.")
+
+	if !validDNSName(domain) {
+		return "", false, acmeProblem("rejectedIdentifier", fmt.Sprintf("%q is not a valid DNS name", identifier.Value), http.StatusBadRequest)
+	}
+	if len(s.allowedDomains) > 0 && !domainAllowed(domain, s.allowedDomains) {
+		return "", false, acmeProblem("rejectedIdentifier", fmt.Sprintf("%s is outside the domains this server issues for", domain), http.StatusBadRequest)
+	}
+	return domain, wildcard, nil
+}
+
+// validDNSName checks for at least two labels of letters, digits and inner hyphens
+func validDNSName(name string) bool {
+	labels := strings.Split(name, ".")
+	if len(name) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func domainAllowed(domain string, allowed []string) bool {
+	for _, suffix := range allowed {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateACMEChallenge checks a challenge response and settles the challenge and its
+// authorization as valid or invalid
+func (co *CentralOrchestrator) validateACMEChallenge(challengeID string) {
+	s := co.ACMEServer
+
+	s.mutex.Lock()
+	challenge := s.challenges[challengeID]
+	authz := s.authorizations[challenge.AuthorizationID]
+	account := s.accounts[authz.AccountID]
+	challengeType, token, domain := challenge.Type, challenge.Token, authz.Identifier.Value
+	keyAuthorization := token + "." + account.Thumbprint
+	s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ACMEValidationTimeout)
+	defer cancel()
+
+	var problem *ACMEProblem
+	switch challengeType {
+	case ACMEChallengeHTTP01:
+		problem = s.validateHTTP01(ctx, domain, token, keyAuthorization)
+	case ACMEChallengeDNS01:
+		problem = validateDNS01(ctx, domain, keyAuthorization)
+	default:
+		problem = acmeProblem("malformed", fmt.Sprintf("unsupported challenge type %s", challengeType), http.StatusBadRequest)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if problem != nil {
+		challenge.Status = ACMEStatusInvalid
+		challenge.Error = problem
+		if authz.Status == ACMEStatusPending {
+			authz.Status = ACMEStatusInvalid
+		}
+		s.logger.Warnf("ACME %s challenge for %s failed: %s", challengeType, domain, problem.Detail)
+		return
+	}
+
+	now := time.Now()
+	challenge.Status = ACMEStatusValid
+	challenge.Validated = &now
+	if authz.Status == ACMEStatusPending {
+		authz.Status = ACMEStatusValid
+	}
+	s.logger.Infof("ACME %s challenge for %s validated", challengeType, domain)
+}
+
+// validateHTTP01 fetches the key authorization from the domain's well-known path
+// (RFC 8555 §8.3)
+func (s *ACMEServer) validateHTTP01(ctx context.Context, domain, token, keyAuthorization string) *ACMEProblem {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", domain, token)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return acmeProblem("malformed", fmt.Sprintf("failed to create request: %v", err), http.StatusBadRequest)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return acmeProblem("connection", fmt.Sprintf("failed to fetch %s: %v", url, err), http.StatusBadRequest)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return acmeProblem("unauthorized", fmt.Sprintf("%s returned status %d", url, resp.StatusCode), http.StatusForbidden)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return acmeProblem("connection", fmt.Sprintf("failed to read %s: %v", url, err), http.StatusBadRequest)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return acmeProblem("incorrectResponse", fmt.Sprintf("%s did not return the key authorization", url), http.StatusForbidden)
+	}
+	return nil
+}
+
+// validateDNS01 looks for the key authorization digest in the _acme-challenge TXT
+// records (RFC 8555 §8.4)
+func validateDNS01(ctx context.Context, domain, keyAuthorization string) *ACMEProblem {
+	name := "_acme-challenge." + domain
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		return acmeProblem("dns", fmt.Sprintf("failed to look up TXT records for %s: %v", name, err), http.StatusBadRequest)
+	}
+
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+	for _, record := range records {
+		if record == expected {
+			return nil
+		}
+	}
+	return acmeProblem("incorrectResponse", fmt.Sprintf("no TXT record for %s matches the key authorization", name), http.StatusForbidden)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// MaxWorkloadReplicas bounds the replicas a workload may ask for
+const MaxWorkloadReplicas = 1000
+
+// imageReferencePattern matches image references as container runtimes parse them: an
+// optional registry host and port, a lowercase repository path, an optional tag and an
+// optional digest
+var imageReferencePattern = regexp.MustCompile(`^` +
+	`(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?` +
+	`(?:@[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,})?$`)
+
+var workloadNameUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// nameSlug turns an ID, such as a camera's or a site's, into a DNS- and label-safe name
+func nameSlug(id string) string {
+	return strings.Trim(workloadNameUnsafe.ReplaceAllString(strings.ToLower(id), "-"), "-")
+}
+
+// workloadTypes are the workload types the agents can run
+var workloadTypes = map[WorkloadType]bool{
+	WorkloadTypeDeployment:  true,
+	WorkloadTypeDaemonSet:   true,
+	WorkloadTypeStatefulSet: true,
+	WorkloadTypeJob:         true,
+	WorkloadTypeCronJob:     true,
+}
+
+// placementStrategies are the strategies the scheduler ranks nodes by
+var placementStrategies = map[PlacementStrategy]bool{
+	PlacementStrategyEdgeFirst:   true,
+	PlacementStrategyCloudFirst:  true,
+	PlacementStrategyLoadBalance: true,
+	PlacementStrategyLatency:     true,
+	PlacementStrategyResource:    true,
+}
+
+// FieldError is a problem with one field of a workload spec
+type FieldError struct {
+	// JSON path of the field, such as resources.requests.cpu or labels[app]
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SpecValidationError lists every problem found in a workload spec
+type SpecValidationError struct {
+	Errors []FieldError
+}
+
+func (e *SpecValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		problems[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return "invalid workload spec: " + strings.Join(problems, "; ")
+}
+
+// add records a problem with a field
+func (e *SpecValidationError) add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// addErr records a validator's error against a field
+func (e *SpecValidationError) addErr(field string, err error) {
+	if err != nil {
+		e.add(field, "%v", err)
+	}
+}
+
+// validateWorkloadRequest checks a workload spec before anything is created from it and
+// reports every invalid field rather than the first
+func validateWorkloadRequest(req WorkloadDeploymentRequest) error {
+	problems := &SpecValidationError{}
+
+	for _, message := range validation.IsDNS1123Label(req.Name) {
+		problems.add("name", "%s", message)
+	}
+	if req.Namespace != "" {
+		for _, message := range validation.IsDNS1123Label(req.Namespace) {
+			problems.add("namespace", "%s", message)
+		}
+	}
+	if !workloadTypes[req.Type] {
+		problems.add("type", "must be one of deployment, daemonset, statefulset, job or cronjob")
+	}
+	if !imageReferencePattern.MatchString(req.Image) {
+		problems.add("image", "%q is not a valid image reference; expected a lowercase repository with an optional registry, tag and digest, such as registry.example.com/team/app:1.2", req.Image)
+	}
+	if err := validateReplicas(req.Replicas); err != nil {
+		problems.add("replicas", "%v", err)
+	}
+	keys := make([]string, 0, len(req.Labels))
+	for key := range req.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := req.Labels[key]
+		for _, message := range validation.IsQualifiedName(key) {
+			problems.add("labels["+key+"]", "invalid key: %s", message)
+		}
+		for _, message := range validation.IsValidLabelValue(value) {
+			problems.add("labels["+key+"]", "invalid value: %s", message)
+		}
+	}
+	problems.addErr("metadata", req.Metadata.validate())
+
+	validateResourceFields(problems, req.Resources)
+
+	placement := req.Placement
+	if placement.Strategy != "" && !placementStrategies[placement.Strategy] {
+		problems.add("placement.strategy", "must be one of edge-first, cloud-first, load-balance, latency-aware or resource-aware")
+	}
+	for i, constraint := range placement.Constraints {
+		problems.addErr(fmt.Sprintf("placement.constraints[%d]", i), constraint.validate())
+	}
+	for i, preference := range placement.Preferences {
+		field := fmt.Sprintf("placement.preferences[%d]", i)
+		if preference.Weight < 1 || preference.Weight > MaxPreferenceWeight {
+			problems.add(field+".weight", "must be between 1 and %d", MaxPreferenceWeight)
+		}
+		problems.addErr(field, preference.Terms.validate())
+	}
+	problems.addErr("placement.workload_affinity", validateWorkloadAffinity(placement))
+	problems.addErr("placement.tolerations", validateTolerations(placement))
+	if placement.MaxReplicasPerNode < 0 {
+		problems.add("placement.max_replicas_per_node", "must not be negative")
+	}
+	if placement.Gang != nil {
+		problems.addErr("placement.gang", placement.Gang.validate())
+	}
+	if probe := req.ReadinessProbe; probe != nil {
+		if probe.Port < 1 || probe.Port > 65535 {
+			problems.add("readiness_probe.port", "must be a container port between 1 and 65535")
+		}
+		if probe.HTTPPath != "" && !strings.HasPrefix(probe.HTTPPath, "/") {
+			problems.add("readiness_probe.http_path", "must start with /")
+		}
+	}
+
+	if len(problems.Errors) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// validateResourceFields checks that requests and limits are Kubernetes quantities and that
+// no request exceeds its limit
+func validateResourceFields(problems *SpecValidationError, resources WorkloadResources) {
+	for _, dimension := range []struct{ name, request, limit string }{
+		{"cpu", resources.Requests.CPU, resources.Limits.CPU},
+		{"memory", resources.Requests.Memory, resources.Limits.Memory},
+	} {
+		request, requestErr := parseWorkloadQuantity(dimension.request)
+		problems.addErr("resources.requests."+dimension.name, requestErr)
+		limit, limitErr := parseWorkloadQuantity(dimension.limit)
+		problems.addErr("resources.limits."+dimension.name, limitErr)
+		if requestErr == nil && limitErr == nil && dimension.request != "" && dimension.limit != "" && request.Cmp(limit) > 0 {
+			problems.add("resources.requests."+dimension.name, "%s exceeds the limit %s", dimension.request, dimension.limit)
+		}
+	}
+}
+
+// validateReplicas checks a requested replica count; 0 defaults to 1 on creation
+func validateReplicas(replicas int32) error {
+	if replicas < 0 || replicas > MaxWorkloadReplicas {
+		return fmt.Errorf("must be between 0 and %d", MaxWorkloadReplicas)
+	}
+	return nil
+}
+
+// workloadSpecError is the response body of a rejected workload spec, listing the invalid
+// fields when validation found them
+func workloadSpecError(err error) gin.H {
+	body := gin.H{"error": err.Error()}
+	var problems *SpecValidationError
+	if errors.As(err, &problems) {
+		body["field_errors"] = problems.Errors
+	}
+	return body
+}
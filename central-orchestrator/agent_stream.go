@@ -0,0 +1,434 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How often desired state is compared against what connected agents were sent, in
+	// addition to right after scheduling
+	AgentStreamSyncInterval = 2 * time.Second
+
+	// How often connected agents are pinged; keeps NAT mappings on the path open
+	AgentStreamPingInterval = 30 * time.Second
+
+	// A stream that sees no message or pong for this long is closed
+	AgentStreamTimeout = 90 * time.Second
+
+	// Commands queued for an agent before it is disconnected as too slow
+	agentStreamBuffer = 64
+
+	agentStreamWriteTimeout = 10 * time.Second
+)
+
+// StreamMessageType identifies a frame on an agent stream
+type StreamMessageType string
+
+const (
+	// Sent once by the agent when the stream opens
+	StreamMessageHello StreamMessageType = "hello"
+	// A workload command pushed to the agent
+	StreamMessageCommand StreamMessageType = "command"
+	// The agent's result for a command
+	StreamMessageResult StreamMessageType = "result"
+)
+
+// WorkloadCommandAction is what an agent is told to do with a workload
+type WorkloadCommandAction string
+
+const (
+	// Create or update the workload from Spec
+	WorkloadCommandDeploy WorkloadCommandAction = "deploy"
+	// Remove the workload and its service
+	WorkloadCommandDelete WorkloadCommandAction = "delete"
+	// Set the workload's replicas on the node without touching the rest of its spec
+	WorkloadCommandScale WorkloadCommandAction = "scale"
+)
+
+// WorkloadCommand is pushed to an agent when its desired state changes
+type WorkloadCommand struct {
+	ID         string                `json:"id"`
+	Action     WorkloadCommandAction `json:"action"`
+	WorkloadID string                `json:"workload_id"`
+	// Desired-state spec of the workload, for deploy
+	Spec map[string]interface{} `json:"spec,omitempty"`
+	// Replicas on this node, for deploy and scale
+	Replicas int32     `json:"replicas"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// StreamHello opens an agent stream
+type StreamHello struct {
+	// IDs of the workloads the agent currently runs, so ones removed while it was
+	// disconnected are deleted
+	Workloads []string `json:"workloads"`
+}
+
+// WorkloadCommandResult reports how an agent applied a command
+type WorkloadCommandResult struct {
+	CommandID  string                `json:"command_id"`
+	WorkloadID string                `json:"workload_id"`
+	Action     WorkloadCommandAction `json:"action"`
+	Success    bool                  `json:"success"`
+	Error      string                `json:"error,omitempty"`
+	AppliedAt  time.Time             `json:"applied_at"`
+}
+
+// StreamMessage is one JSON frame on an agent stream, in either direction
+type StreamMessage struct {
+	Type    StreamMessageType      `json:"type"`
+	Hello   *StreamHello           `json:"hello,omitempty"`
+	Command *WorkloadCommand       `json:"command,omitempty"`
+	Result  *WorkloadCommandResult `json:"result,omitempty"`
+}
+
+// AgentStreamStatus describes a connected agent
+type AgentStreamStatus struct {
+	NodeID         string                 `json:"node_id"`
+	RemoteAddress  string                 `json:"remote_address"`
+	ConnectedAt    time.Time              `json:"connected_at"`
+	CommandsSent   int                    `json:"commands_sent"`
+	CommandsFailed int                    `json:"commands_failed"`
+	LastResult     *WorkloadCommandResult `json:"last_result,omitempty"`
+}
+
+// agentConnection is the open stream of one agent
+type agentConnection struct {
+	status AgentStreamStatus
+	send   chan StreamMessage
+	done   chan struct{}
+	once   sync.Once
+	// Workload specs last sent, keyed by workload ID; only the push loop touches it
+	// once the connection is attached
+	pushed map[string]interface{}
+}
+
+func (ac *agentConnection) close() {
+	ac.once.Do(func() { close(ac.done) })
+}
+
+// AgentStreamHub holds the agent streams connected to this replica, and the assignment
+// long polls of agents that cannot stream. Every replica pushes to its own streams from
+// its copy of the desired state, so agents may connect to any replica.
+type AgentStreamHub struct {
+	connections map[string]*agentConnection
+	watches     map[*assignmentWatch]struct{}
+	wakeup      chan struct{}
+	mutex       sync.RWMutex
+	logger      *logrus.Logger
+}
+
+// NewAgentStreamHub creates an agent stream hub
+func NewAgentStreamHub(logger *logrus.Logger) *AgentStreamHub {
+	return &AgentStreamHub{
+		connections: make(map[string]*agentConnection),
+		watches:     make(map[*assignmentWatch]struct{}),
+		wakeup:      make(chan struct{}, 1),
+		logger:      logger,
+	}
+}
+
+// wake makes the push loop compare desired state now rather than at its next tick
+func (hub *AgentStreamHub) wake() {
+	select {
+	case hub.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// attach registers a connection, closing any earlier stream of the same node
+func (hub *AgentStreamHub) attach(conn *agentConnection) {
+	hub.mutex.Lock()
+	previous := hub.connections[conn.status.NodeID]
+	hub.connections[conn.status.NodeID] = conn
+	hub.mutex.Unlock()
+
+	if previous != nil {
+		previous.close()
+	}
+	hub.wake()
+}
+
+// detach removes a connection unless a newer stream of the node replaced it
+func (hub *AgentStreamHub) detach(conn *agentConnection) {
+	conn.close()
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if hub.connections[conn.status.NodeID] == conn {
+		delete(hub.connections, conn.status.NodeID)
+	}
+}
+
+func (hub *AgentStreamHub) snapshot() []*agentConnection {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	connections := make([]*agentConnection, 0, len(hub.connections))
+	for _, conn := range hub.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// push queues a command for the agent, closing the stream if the agent is not keeping up
+func (hub *AgentStreamHub) push(conn *agentConnection, command WorkloadCommand) bool {
+	select {
+	case conn.send <- StreamMessage{Type: StreamMessageCommand, Command: &command}:
+	default:
+		hub.logger.Warnf("Agent stream of node %s is not keeping up, disconnecting", conn.status.NodeID)
+		conn.close()
+		return false
+	}
+
+	hub.mutex.Lock()
+	conn.status.CommandsSent++
+	hub.mutex.Unlock()
+	return true
+}
+
+func (hub *AgentStreamHub) recordResult(conn *agentConnection, result *WorkloadCommandResult) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if !result.Success {
+		conn.status.CommandsFailed++
+	}
+	conn.status.LastResult = result
+}
+
+// workloadCommands returns the commands that take an agent from the pushed workloads to
+// the desired ones. Only the node's replica count changing is a scale; any other spec
+// change redeploys the workload.
+func workloadCommands(pushed, desired map[string]interface{}, now time.Time) []WorkloadCommand {
+	ids := make([]string, 0, len(pushed)+len(desired))
+	for id := range pushed {
+		ids = append(ids, id)
+	}
+	for id := range desired {
+		if _, exists := pushed[id]; !exists {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	commands := make([]WorkloadCommand, 0)
+	for _, id := range ids {
+		previous, had := pushed[id].(map[string]interface{})
+		spec, wanted := desired[id].(map[string]interface{})
+		command := WorkloadCommand{ID: generateID(), WorkloadID: id, IssuedAt: now}
+
+		switch {
+		case !wanted:
+			command.Action = WorkloadCommandDelete
+		case !had:
+			command.Action = WorkloadCommandDeploy
+		case reflect.DeepEqual(previous, spec):
+			continue
+		case reflect.DeepEqual(withoutNodeReplicas(previous), withoutNodeReplicas(spec)):
+			command.Action = WorkloadCommandScale
+		default:
+			command.Action = WorkloadCommandDeploy
+		}
+
+		if wanted {
+			if replicas, ok := spec["node_replicas"].(float64); ok {
+				command.Replicas = int32(replicas)
+			}
+			if command.Action == WorkloadCommandDeploy {
+				command.Spec = spec
+			}
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+func withoutNodeReplicas(spec map[string]interface{}) map[string]interface{} {
+	stripped := make(map[string]interface{}, len(spec))
+	for key, value := range spec {
+		if key != "node_replicas" {
+			stripped[key] = value
+		}
+	}
+	return stripped
+}
+
+// pushAgentStreams sends connected agents the commands for their desired-state changes
+// and answers the assignment long polls whose node's desired state changed. It runs on
+// every replica since each serves its own streams.
+func (co *CentralOrchestrator) pushAgentStreams() {
+	ticker := time.NewTicker(AgentStreamSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-co.AgentStreamHub.wakeup:
+		}
+		co.pushWorkloadCommands(time.Now())
+		co.notifyAssignmentWatchers()
+	}
+}
+
+func (co *CentralOrchestrator) pushWorkloadCommands(now time.Time) {
+	hub := co.AgentStreamHub
+	for _, conn := range hub.snapshot() {
+		co.WorkloadManager.mutex.RLock()
+		current, err := co.buildDesiredState(conn.status.NodeID)
+		co.WorkloadManager.mutex.RUnlock()
+		if err != nil {
+			co.Logger.Warnf("Failed to build desired state for agent stream of node %s: %v", conn.status.NodeID, err)
+			continue
+		}
+
+		desired, _ := current.document["workloads"].(map[string]interface{})
+		for _, command := range workloadCommands(conn.pushed, desired, now) {
+			if !hub.push(conn, command) {
+				break
+			}
+			co.Logger.Infof("Pushed %s of workload %s to node %s", command.Action, command.WorkloadID, conn.status.NodeID)
+		}
+		conn.pushed = desired
+	}
+}
+
+var agentStreamUpgrader = websocket.Upgrader{
+	HandshakeTimeout: agentStreamWriteTimeout,
+	// Agents are not browsers; the request is authenticated like any other API call
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// StreamAgent upgrades an agent's request to a WebSocket on which the orchestrator
+// pushes workload commands as the node's desired state changes. The agent dials out,
+// so commands reach nodes behind NAT.
+func (co *CentralOrchestrator) StreamAgent(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.NodeManager.mutex.RLock()
+	_, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	ws, err := agentStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already answered with an error status
+		co.Logger.Warnf("Failed to open agent stream for node %s: %v", nodeID, err)
+		return
+	}
+	defer ws.Close()
+
+	// Liveness is tracked with pings rather than the server's request timeouts
+	ws.SetReadDeadline(time.Now().Add(AgentStreamTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(AgentStreamTimeout))
+	})
+
+	var hello StreamMessage
+	if err := ws.ReadJSON(&hello); err != nil || hello.Type != StreamMessageHello || hello.Hello == nil {
+		co.Logger.Warnf("Agent stream of node %s did not open with a hello", nodeID)
+		return
+	}
+
+	conn := &agentConnection{
+		status: AgentStreamStatus{
+			NodeID:        nodeID,
+			RemoteAddress: c.ClientIP(),
+			ConnectedAt:   time.Now(),
+		},
+		send:   make(chan StreamMessage, agentStreamBuffer),
+		done:   make(chan struct{}),
+		pushed: make(map[string]interface{}),
+	}
+	// Workloads the agent already runs are redeployed if still wanted, deleted if not
+	for _, id := range hello.Hello.Workloads {
+		conn.pushed[id] = nil
+	}
+
+	co.AgentStreamHub.attach(conn)
+	defer co.AgentStreamHub.detach(conn)
+	co.Logger.Infof("Agent stream of node %s connected from %s", nodeID, conn.status.RemoteAddress)
+
+	go co.writeAgentStream(ws, conn)
+
+	for {
+		var message StreamMessage
+		if err := ws.ReadJSON(&message); err != nil {
+			select {
+			case <-conn.done:
+			default:
+				co.Logger.Infof("Agent stream of node %s closed: %v", nodeID, err)
+			}
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(AgentStreamTimeout))
+
+		if message.Type != StreamMessageResult || message.Result == nil {
+			continue
+		}
+		result := message.Result
+		co.AgentStreamHub.recordResult(conn, result)
+		if result.Success {
+			co.Logger.Debugf("Node %s applied %s of workload %s", nodeID, result.Action, result.WorkloadID)
+		} else {
+			co.Logger.Warnf("Node %s failed to %s workload %s: %s", nodeID, result.Action, result.WorkloadID, result.Error)
+		}
+	}
+}
+
+// writeAgentStream sends queued commands and pings until the connection is closed
+func (co *CentralOrchestrator) writeAgentStream(ws *websocket.Conn, conn *agentConnection) {
+	ticker := time.NewTicker(AgentStreamPingInterval)
+	defer ticker.Stop()
+	// Unblocks the reader when the stream is replaced or falls behind
+	defer ws.Close()
+
+	for {
+		select {
+		case <-conn.done:
+			ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(agentStreamWriteTimeout))
+			return
+		case message := <-conn.send:
+			ws.SetWriteDeadline(time.Now().Add(agentStreamWriteTimeout))
+			if err := ws.WriteJSON(message); err != nil {
+				co.Logger.Warnf("Failed to write to agent stream of node %s: %v", conn.status.NodeID, err)
+				conn.close()
+				return
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(agentStreamWriteTimeout)); err != nil {
+				conn.close()
+				return
+			}
+		}
+	}
+}
+
+// ListAgentStreams lists the agents connected to this replica
+func (co *CentralOrchestrator) ListAgentStreams(c *gin.Context) {
+	hub := co.AgentStreamHub
+	hub.mutex.RLock()
+	streams := make([]AgentStreamStatus, 0, len(hub.connections))
+	for _, conn := range hub.connections {
+		streams = append(streams, conn.status)
+	}
+	hub.mutex.RUnlock()
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].NodeID < streams[j].NodeID
+	})
+
+	c.JSON(http.StatusOK, gin.H{"streams": streams})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long resolved alerts are kept for history
+	ResolvedAlertRetention = 7 * 24 * time.Hour
+
+	// Fired when failover cannot re-place a workload's lost replicas
+	WorkloadUnschedulableAlert = "WorkloadUnschedulable"
+)
+
+// AlertSeverity represents the severity of an alert
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo     AlertSeverity = "info"
+	AlertSeverityWarning  AlertSeverity = "warning"
+	AlertSeverityCritical AlertSeverity = "critical"
+)
+
+// AlertStatus represents the status of an alert
+type AlertStatus string
+
+const (
+	AlertStatusFiring   AlertStatus = "firing"
+	AlertStatusResolved AlertStatus = "resolved"
+)
+
+// AlertScope identifies the kind of object an alert is about
+type AlertScope string
+
+const (
+	AlertScopeSite     AlertScope = "site"
+	AlertScopeNode     AlertScope = "node"
+	AlertScopeWorkload AlertScope = "workload"
+	AlertScopeCamera   AlertScope = "camera"
+	AlertScopeVolume   AlertScope = "volume"
+)
+
+// Alert represents a condition raised by the orchestrator
+type Alert struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Severity   AlertSeverity     `json:"severity"`
+	Status     AlertStatus       `json:"status"`
+	Scope      AlertScope        `json:"scope"`
+	ScopeID    string            `json:"scope_id"`
+	SiteID     string            `json:"site_id,omitempty"`
+	Code       MessageCode       `json:"code"`
+	Params     map[string]string `json:"params"`
+	Message    string            `json:"message"`
+	StartsAt   time.Time         `json:"starts_at"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+	// For workload alerts, who owns the workload and where its runbook lives
+	WorkloadMetadata *WorkloadMetadata `json:"workload_metadata,omitempty"`
+	// IDs of the silences suppressing the alert, filled in API responses
+	SilencedBy []string `json:"silenced_by,omitempty"`
+	// Who is working the firing alert, so responders do not pick it up twice
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	// Who resolved the alert, for alerts resolved by hand rather than by their condition
+	// clearing
+	ResolvedBy string `json:"resolved_by,omitempty"`
+}
+
+// AlertManager tracks firing and recently resolved alerts
+type AlertManager struct {
+	alerts map[string]*Alert
+	active map[string]string
+	mutex  sync.RWMutex
+	logger *logrus.Logger
+}
+
+// NewAlertManager creates a new alert manager
+func NewAlertManager(logger *logrus.Logger) *AlertManager {
+	return &AlertManager{
+		alerts: make(map[string]*Alert),
+		active: make(map[string]string),
+		logger: logger,
+	}
+}
+
+// alertFingerprint identifies an alert condition independent of when it fired
+func alertFingerprint(name string, scope AlertScope, scopeID string) string {
+	return name + "/" + string(scope) + "/" + scopeID
+}
+
+// Fire raises an alert, or returns the existing one if the condition is already firing
+func (am *AlertManager) Fire(name string, severity AlertSeverity, scope AlertScope, scopeID, siteID string, message Message) *Alert {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	fingerprint := alertFingerprint(name, scope, scopeID)
+	if id, exists := am.active[fingerprint]; exists {
+		alert := am.alerts[id]
+		alert.Severity = severity
+		alert.Code = message.Code
+		alert.Params = message.Params
+		alert.Message = message.String()
+		return alert
+	}
+
+	am.pruneResolved()
+
+	alert := &Alert{
+		ID:       generateID(),
+		Name:     name,
+		Severity: severity,
+		Status:   AlertStatusFiring,
+		Scope:    scope,
+		ScopeID:  scopeID,
+		SiteID:   siteID,
+		Code:     message.Code,
+		Params:   message.Params,
+		Message:  message.String(),
+		StartsAt: time.Now(),
+	}
+	am.alerts[alert.ID] = alert
+	am.active[fingerprint] = alert.ID
+
+	am.logger.WithField("code", message.Code).Warnf("Alert %s firing for %s %s: %s", name, scope, scopeID, alert.Message)
+	return alert
+}
+
+// Resolve resolves a firing alert condition, if any
+func (am *AlertManager) Resolve(name string, scope AlertScope, scopeID string) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	fingerprint := alertFingerprint(name, scope, scopeID)
+	id, exists := am.active[fingerprint]
+	if !exists {
+		return
+	}
+
+	now := time.Now()
+	alert := am.alerts[id]
+	alert.Status = AlertStatusResolved
+	alert.ResolvedAt = &now
+	delete(am.active, fingerprint)
+
+	am.logger.Infof("Alert %s resolved for %s %s", name, scope, scopeID)
+}
+
+// Acknowledge records who is working a firing alert and returns a copy of it. Resolved
+// alerts are returned unchanged; exists is false for unknown alerts.
+func (am *AlertManager) Acknowledge(id, user string, now time.Time) (alert Alert, exists bool) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	found, exists := am.alerts[id]
+	if !exists {
+		return Alert{}, false
+	}
+	if found.Status == AlertStatusFiring {
+		found.AcknowledgedBy = user
+		found.AcknowledgedAt = &now
+		am.logger.Infof("Alert %s for %s %s acknowledged by %s", found.Name, found.Scope, found.ScopeID, user)
+	}
+	return *found, true
+}
+
+// ResolveByID resolves a firing alert on behalf of a user and returns a copy of it. Should
+// its condition still hold, the alert fires again at the next check. Resolved alerts are
+// returned unchanged; exists is false for unknown alerts.
+func (am *AlertManager) ResolveByID(id, user string, now time.Time) (alert Alert, exists bool) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	found, exists := am.alerts[id]
+	if !exists {
+		return Alert{}, false
+	}
+	if found.Status == AlertStatusFiring {
+		found.Status = AlertStatusResolved
+		found.ResolvedAt = &now
+		found.ResolvedBy = user
+		delete(am.active, alertFingerprint(found.Name, found.Scope, found.ScopeID))
+		am.logger.Infof("Alert %s for %s %s resolved by %s", found.Name, found.Scope, found.ScopeID, user)
+	}
+	return *found, true
+}
+
+// pruneResolved drops resolved alerts past their retention; callers must hold the lock
+func (am *AlertManager) pruneResolved() {
+	for id, alert := range am.alerts {
+		if alert.ResolvedAt != nil && time.Since(*alert.ResolvedAt) > ResolvedAlertRetention {
+			delete(am.alerts, id)
+		}
+	}
+}
+
+// AlertFilter selects alerts by status and scope; empty fields match everything
+type AlertFilter struct {
+	Status  string
+	Scope   string
+	ScopeID string
+	SiteID  string
+}
+
+// List returns alerts matching the filter, newest first
+func (am *AlertManager) List(filter AlertFilter) []*Alert {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	alerts := make([]*Alert, 0)
+	for _, alert := range am.alerts {
+		if filter.Status != "" && string(alert.Status) != filter.Status {
+			continue
+		}
+		if filter.Scope != "" && string(alert.Scope) != filter.Scope {
+			continue
+		}
+		if filter.ScopeID != "" && alert.ScopeID != filter.ScopeID {
+			continue
+		}
+		if filter.SiteID != "" && alert.SiteID != filter.SiteID {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].StartsAt.After(alerts[j].StartsAt)
+	})
+
+	return alerts
+}
+
+// localizeAlerts returns copies of alerts with messages rendered in the given locale,
+// workload alerts annotated with the workload's metadata, and silenced alerts with their
+// silences
+func (co *CentralOrchestrator) localizeAlerts(alerts []*Alert, locale string) []*Alert {
+	metadata := co.workloadMetadataFor(alerts)
+	silenced := co.silencedAlerts(alerts, time.Now())
+
+	co.AlertManager.mutex.RLock()
+	defer co.AlertManager.mutex.RUnlock()
+
+	localized := make([]*Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		copied := *alert
+		copied.Message = co.MessageCatalog.Render(Message{Code: alert.Code, Params: alert.Params}, locale)
+		if workloadMetadata, exists := metadata[alert.ScopeID]; exists && alert.Scope == AlertScopeWorkload {
+			copied.WorkloadMetadata = &workloadMetadata
+		}
+		copied.SilencedBy = silenced[alert.ID]
+		localized = append(localized, &copied)
+	}
+	return localized
+}
+
+// visibleAlerts drops the alerts about workloads and nodes the caller may not see
+func (co *CentralOrchestrator) visibleAlerts(c *gin.Context, alerts []*Alert) []*Alert {
+	view := co.tenantView(c)
+	if view == nil {
+		return alerts
+	}
+	visible := make([]*Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		if view.alert(alert) {
+			visible = append(visible, alert)
+		}
+	}
+	return visible
+}
+
+// ListAlerts returns alerts, optionally filtered by status, scope, scope_id, or site_id and
+// rendered in the requested locale. Silenced alerts are left out unless silenced=true.
+func (co *CentralOrchestrator) ListAlerts(c *gin.Context) {
+	alerts := co.AlertManager.List(AlertFilter{
+		Status:  c.Query("status"),
+		Scope:   c.Query("scope"),
+		ScopeID: c.Query("scope_id"),
+		SiteID:  c.Query("site_id"),
+	})
+	alerts = co.visibleAlerts(c, alerts)
+	if c.Query("silenced") != "true" {
+		alerts = co.unsilencedAlerts(alerts)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": co.localizeAlerts(alerts, requestLocale(c))})
+}
+
+// AcknowledgeAlert marks a firing alert as being worked by the caller
+func (co *CentralOrchestrator) AcknowledgeAlert(c *gin.Context) {
+	alertID := c.Param("id")
+	view := co.tenantView(c)
+	co.AlertManager.mutex.RLock()
+	current, exists := co.AlertManager.alerts[alertID]
+	visible := exists && view.alert(current)
+	co.AlertManager.mutex.RUnlock()
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+
+	alert, exists := co.AlertManager.Acknowledge(alertID, requestActor(c), time.Now())
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	if alert.Status != AlertStatusFiring {
+		c.JSON(http.StatusConflict, gin.H{"error": "Alert is no longer firing"})
+		return
+	}
+
+	co.AuditLog.RecordRequest(c, "alert.acknowledge", "alert:"+alert.ID, map[string]string{"name": alert.Name})
+	c.JSON(http.StatusOK, gin.H{"alert": co.localizeAlerts([]*Alert{&alert}, requestLocale(c))[0]})
+}
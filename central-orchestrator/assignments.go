@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Longest an assignment request may wait for a change
+	MaxAssignmentWait = 5 * time.Minute
+
+	// Added to the wait when extending the write deadline, for building and sending the
+	// response
+	assignmentWriteSlack = 15 * time.Second
+)
+
+// AssignmentsResponse carries the workloads assigned to a node at a resource version.
+// The version is the hash of the node's desired state, so it is the same on every
+// replica; clients only compare it for equality.
+type AssignmentsResponse struct {
+	NodeID          string `json:"node_id"`
+	ResourceVersion string `json:"resource_version"`
+	// Set when a wait ended without the assignments moving from the requested version
+	Unchanged bool                   `json:"unchanged,omitempty"`
+	Workloads map[string]interface{} `json:"workloads,omitempty"`
+}
+
+// assignmentWatch is one request waiting for a node's assignments to leave a version
+type assignmentWatch struct {
+	nodeID          string
+	resourceVersion string
+	changed         chan struct{}
+	once            sync.Once
+}
+
+func (w *assignmentWatch) notify() {
+	w.once.Do(func() { close(w.changed) })
+}
+
+// watchAssignments registers a request waiting for a node's assignments to leave a version
+func (hub *AgentStreamHub) watchAssignments(nodeID, resourceVersion string) *assignmentWatch {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	watch := &assignmentWatch{nodeID: nodeID, resourceVersion: resourceVersion, changed: make(chan struct{})}
+	hub.watches[watch] = struct{}{}
+	return watch
+}
+
+func (hub *AgentStreamHub) unwatchAssignments(watch *assignmentWatch) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	delete(hub.watches, watch)
+}
+
+// assignmentWatches returns the waiting requests grouped by node
+func (hub *AgentStreamHub) assignmentWatches() map[string][]*assignmentWatch {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	nodes := make(map[string][]*assignmentWatch)
+	for watch := range hub.watches {
+		nodes[watch.nodeID] = append(nodes[watch.nodeID], watch)
+	}
+	return nodes
+}
+
+// notifyAssignmentWatchers wakes the requests whose node's desired state moved from the
+// version they wait on, building each watched node's desired state once
+func (co *CentralOrchestrator) notifyAssignmentWatchers() {
+	for nodeID, watches := range co.AgentStreamHub.assignmentWatches() {
+		co.WorkloadManager.mutex.RLock()
+		current, err := co.buildDesiredState(nodeID)
+		co.WorkloadManager.mutex.RUnlock()
+		if err != nil {
+			co.Logger.Warnf("Failed to build desired state for assignment watchers of node %s: %v", nodeID, err)
+			continue
+		}
+		for _, watch := range watches {
+			if watch.resourceVersion != current.hash {
+				watch.notify()
+			}
+		}
+	}
+}
+
+// parseAssignmentWait reads the wait query parameter, a Go duration such as 60s
+func parseAssignmentWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("wait must be a duration such as 60s")
+	}
+	if wait > MaxAssignmentWait {
+		wait = MaxAssignmentWait
+	}
+	return wait, nil
+}
+
+// GetNodeAssignments returns the workloads assigned to a node for agents that cannot hold
+// a stream open. With resourceVersion set to the version the agent has and wait=60s, the
+// request is held until the assignments change or the wait runs out, so new assignments
+// reach the agent as soon as they are made rather than on its next poll.
+func (co *CentralOrchestrator) GetNodeAssignments(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	wait, err := parseAssignmentWait(c.Query("wait"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	_, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	// Registered before the first look at the desired state, so a change made in between
+	// still wakes the request
+	resourceVersion := c.Query("resourceVersion")
+	var watch *assignmentWatch
+	if resourceVersion != "" && wait > 0 {
+		watch = co.AgentStreamHub.watchAssignments(nodeID, resourceVersion)
+		defer co.AgentStreamHub.unwatchAssignments(watch)
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	current, err := co.buildDesiredState(nodeID)
+	co.WorkloadManager.mutex.RUnlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if watch != nil && resourceVersion == current.hash {
+		// The server's write timeout is shorter than a long poll
+		if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(wait + assignmentWriteSlack)); err != nil {
+			co.Logger.Debugf("Failed to extend the write deadline of an assignment request: %v", err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-watch.changed:
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+		}
+		timer.Stop()
+
+		co.WorkloadManager.mutex.RLock()
+		current, err = co.buildDesiredState(nodeID)
+		co.WorkloadManager.mutex.RUnlock()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if resourceVersion == current.hash {
+		c.JSON(http.StatusOK, AssignmentsResponse{NodeID: nodeID, ResourceVersion: current.hash, Unchanged: true})
+		return
+	}
+
+	// Agents mixing this endpoint with desired-state patches can patch from this version
+	co.DesiredStateCache.record(nodeID, current)
+
+	workloads, _ := current.document["workloads"].(map[string]interface{})
+	c.JSON(http.StatusOK, AssignmentsResponse{NodeID: nodeID, ResourceVersion: current.hash, Workloads: workloads})
+}
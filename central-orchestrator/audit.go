@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Oldest audit records are dropped beyond this many
+	MaxAuditRecords = 10000
+
+	// Header operator tools use to name the person acting
+	OperatorHeader = "X-Edge-Operator"
+)
+
+// AuditRecord is an operator action that must be traceable afterwards
+type AuditRecord struct {
+	ID    string `json:"id"`
+	Actor string `json:"actor"`
+	// User the actor impersonated; its role bindings applied to the action
+	OnBehalfOf string            `json:"on_behalf_of,omitempty"`
+	Source     string            `json:"source"`
+	Action     string            `json:"action"`
+	Target     string            `json:"target"`
+	Details    map[string]string `json:"details,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+}
+
+// AuditLog keeps recent audit records in order
+type AuditLog struct {
+	records []*AuditRecord
+	mutex   sync.RWMutex
+	logger  *logrus.Logger
+}
+
+// NewAuditLog creates a new audit log
+func NewAuditLog(logger *logrus.Logger) *AuditLog {
+	return &AuditLog{
+		records: make([]*AuditRecord, 0),
+		logger:  logger,
+	}
+}
+
+// Record appends an audit record
+func (al *AuditLog) Record(actor, source, action, target string, details map[string]string) {
+	al.append(&AuditRecord{Actor: actor, Source: source, Action: action, Target: target, Details: details})
+}
+
+// RecordRequest appends an audit record of an action taken by a request, attributed to the
+// real initiator and to the user it impersonated, if any
+func (al *AuditLog) RecordRequest(c *gin.Context, action, target string, details map[string]string) {
+	record := &AuditRecord{Actor: requestActor(c), Source: c.ClientIP(), Action: action, Target: target, Details: details}
+	if c.GetString(ContextKeyImpersonator) != "" {
+		record.OnBehalfOf = c.GetString("user")
+	}
+	al.append(record)
+}
+
+func (al *AuditLog) append(record *AuditRecord) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	record.ID = generateID()
+	record.Timestamp = time.Now()
+	al.records = append(al.records, record)
+	if len(al.records) > MaxAuditRecords {
+		al.records = al.records[len(al.records)-MaxAuditRecords:]
+	}
+
+	fields := logrus.Fields{"audit": record.Action, "actor": record.Actor, "target": record.Target}
+	if record.OnBehalfOf != "" {
+		fields["on_behalf_of"] = record.OnBehalfOf
+	}
+	al.logger.WithFields(fields).Info("Audit record")
+}
+
+// requestActor identifies who made a request: the operator header when set, otherwise the
+// authenticated user, or the admin impersonating it
+func requestActor(c *gin.Context) string {
+	if operator := c.GetHeader(OperatorHeader); operator != "" {
+		return operator
+	}
+	if impersonator := c.GetString(ContextKeyImpersonator); impersonator != "" {
+		return impersonator
+	}
+	return c.GetString("user")
+}
+
+// auditRecordVisible reports whether a caller limited to tenants may see an audit record: only
+// those about workloads, nodes and projects it may see
+func auditRecordVisible(c *gin.Context, view *tenantView, record *AuditRecord) bool {
+	if view == nil {
+		return true
+	}
+	kind, id, _ := strings.Cut(record.Target, ":")
+	switch kind {
+	case "workload":
+		return view.workload(id)
+	case "node":
+		return view.node(id)
+	case "project":
+		return tenantAllowed(c, id)
+	}
+	return false
+}
+
+// ListAuditRecords returns audit records newest first, optionally filtered by action and
+// target. Callers limited to tenants see only the records about their workloads, nodes and
+// projects.
+func (co *CentralOrchestrator) ListAuditRecords(c *gin.Context) {
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+
+	view := co.tenantView(c)
+	co.AuditLog.mutex.RLock()
+	defer co.AuditLog.mutex.RUnlock()
+
+	records := make([]*AuditRecord, 0)
+	for i := len(co.AuditLog.records) - 1; i >= 0 && len(records) < limit; i-- {
+		record := co.AuditLog.records[i]
+		if action := c.Query("action"); action != "" && record.Action != action {
+			continue
+		}
+		if target := c.Query("target"); target != "" && record.Target != target {
+			continue
+		}
+		if !auditRecordVisible(c, view, record) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"records": records})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// How often autoscaled workloads are resized against the load forecast
+	AutoscalingInterval = 5 * time.Minute
+
+	// Default time ahead of predicted load that replicas are added
+	DefaultScalingLeadTime = time.Hour
+)
+
+// WorkloadAutoscaling sizes a workload for the CPU load forecast at the sites it runs
+// on, so predictable peaks are scaled for before they arrive
+type WorkloadAutoscaling struct {
+	MinReplicas      int32   `json:"min_replicas"`
+	MaxReplicas      int32   `json:"max_replicas"`
+	TargetCPUPercent float64 `json:"target_cpu_percent"`
+	// How far ahead predicted peaks are scaled for; defaults to an hour
+	LeadMinutes int `json:"lead_minutes,omitempty"`
+
+	// Last decision, reported back to the operator
+	PredictedCPUPercent float64    `json:"predicted_cpu_percent,omitempty"`
+	LastScaledAt        *time.Time `json:"last_scaled_at,omitempty"`
+}
+
+// validate checks the replica bounds and CPU target
+func (a *WorkloadAutoscaling) validate() error {
+	if a.MinReplicas < 1 || a.MaxReplicas < a.MinReplicas {
+		return fmt.Errorf("min_replicas must be at least 1 and max_replicas at least min_replicas")
+	}
+	if a.TargetCPUPercent <= 0 || a.TargetCPUPercent > 100 {
+		return fmt.Errorf("target_cpu_percent must be between 0 and 100")
+	}
+	if a.LeadMinutes < 0 {
+		return fmt.Errorf("lead_minutes must not be negative")
+	}
+	return nil
+}
+
+func (a *WorkloadAutoscaling) leadTime() time.Duration {
+	if a.LeadMinutes == 0 {
+		return DefaultScalingLeadTime
+	}
+	return time.Duration(a.LeadMinutes) * time.Minute
+}
+
+// desiredReplicas scales the current replica count by how far predicted CPU load is
+// from the target, within the policy's bounds
+func (a *WorkloadAutoscaling) desiredReplicas(current int32, predictedCPU float64) int32 {
+	desired := int32(math.Ceil(float64(current) * predictedCPU / a.TargetCPUPercent))
+	if desired < a.MinReplicas {
+		return a.MinReplicas
+	}
+	if desired > a.MaxReplicas {
+		return a.MaxReplicas
+	}
+	return desired
+}
+
+// predictedSiteCPU returns the highest predicted CPU load, within the window, across the
+// sites a workload is deployed to
+func (co *CentralOrchestrator) predictedSiteCPU(workload *Workload, now time.Time, within time.Duration) (float64, bool) {
+	co.NodeManager.mutex.RLock()
+	sites := make(map[string]bool)
+	for _, deployment := range workload.Deployments {
+		if node, exists := co.NodeManager.nodes[deployment.NodeID]; exists && node.SiteID != "" {
+			sites[node.SiteID] = true
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	peak, found := 0.0, false
+	for siteID := range sites {
+		forecast, exists := co.LoadForecaster.SiteForecast(siteID, "cpu_percent")
+		if !exists {
+			continue
+		}
+		if value, ok := forecast.Peak(now, within); ok && (!found || value > peak) {
+			peak, found = value, true
+		}
+	}
+	return peak, found
+}
+
+// autoscaleWorkloads resizes every autoscaled workload. Replicas are added for the peak
+// expected within the lead time, but only removed when the whole forecast horizon stays
+// below target, so capacity isn't given back just before the next rush.
+func (co *CentralOrchestrator) autoscaleWorkloads() {
+	now := time.Now()
+	horizon := time.Duration(co.LoadForecaster.horizon) * time.Hour
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	for _, workload := range co.WorkloadManager.workloads {
+		policy := workload.Autoscaling
+		if policy == nil || workload.Status != WorkloadStatusRunning {
+			continue
+		}
+
+		leadPeak, found := co.predictedSiteCPU(workload, now, policy.leadTime())
+		if !found {
+			continue
+		}
+		policy.PredictedCPUPercent = leadPeak
+
+		desired := policy.desiredReplicas(workload.Replicas, leadPeak)
+		if desired < workload.Replicas {
+			horizonPeak, _ := co.predictedSiteCPU(workload, now, horizon)
+			desired = policy.desiredReplicas(workload.Replicas, math.Max(leadPeak, horizonPeak))
+		}
+		if desired > workload.Replicas {
+			// Grow only as far as the namespace's quota allows
+			if limit := co.namespaceReplicaLimit(workload, desired); limit < desired {
+				co.Logger.Warnf("Autoscaling workload %s to %d replicas instead of %d: namespace %s is at its quota",
+					workload.ID, limit, desired, workloadNamespace(workload))
+				desired = limit
+			}
+		}
+		if desired == workload.Replicas {
+			continue
+		}
+
+		co.Logger.Infof("Autoscaling workload %s from %d to %d replicas for predicted CPU %.1f%%",
+			workload.ID, workload.Replicas, desired, leadPeak)
+		workload.Replicas = desired
+		workload.Status = WorkloadStatusPending // Trigger rescheduling
+		workload.UpdatedAt = now
+		policy.LastScaledAt = &now
+	}
+}
+
+// predictiveAutoscaler periodically applies autoscaling policies
+func (co *CentralOrchestrator) predictiveAutoscaler() {
+	ticker := time.NewTicker(AutoscalingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.autoscaleWorkloads()
+		}
+	}
+}
+
+// SetWorkloadAutoscaling sets or replaces a workload's autoscaling policy
+func (co *CentralOrchestrator) SetWorkloadAutoscaling(c *gin.Context) {
+	var req WorkloadAutoscaling
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.PredictedCPUPercent, req.LastScaledAt = 0, nil
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	workload.Autoscaling = &req
+	workload.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Workload %s autoscales between %d and %d replicas at %.0f%% CPU",
+		workload.ID, req.MinReplicas, req.MaxReplicas, req.TargetCPUPercent)
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
+
+// DeleteWorkloadAutoscaling turns autoscaling off, leaving the replica count as it is
+func (co *CentralOrchestrator) DeleteWorkloadAutoscaling(c *gin.Context) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	workload.Autoscaling = nil
+	workload.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Workload %s autoscaling disabled", workload.ID)
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
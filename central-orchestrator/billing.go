@@ -0,0 +1,522 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Currency of rate cards and reports when BILLING_CURRENCY is unset
+	DefaultBillingCurrency = "USD"
+
+	// Site of replicas on nodes that do not belong to one
+	UnassignedSite = "unassigned"
+
+	// Compute slices of the GPUs MIG profiles partition, as in the 1g of "1g.5gb"
+	migComputeSlices = 7
+)
+
+// Billed resources, stored as billing metrics named <resource>:<site> per workload
+const (
+	BillingResourceCPU     = "cpu_cores"
+	BillingResourceMemory  = "memory_gib"
+	BillingResourceStorage = "storage_gib"
+	BillingResourceGPU     = "gpus"
+	BillingResourceReplica = "replicas"
+)
+
+var billingResources = []string{BillingResourceCPU, BillingResourceMemory, BillingResourceStorage, BillingResourceGPU, BillingResourceReplica}
+
+// migProfileSlices reads the compute slices of a MIG profile
+var migProfileSlices = regexp.MustCompile(`^(\d+)g\.`)
+
+// ResourceHours is what a workload held over a period, in resource-hours
+type ResourceHours struct {
+	CPUCoreHours    float64 `json:"cpu_core_hours"`
+	MemoryGiBHours  float64 `json:"memory_gib_hours"`
+	StorageGiBHours float64 `json:"storage_gib_hours"`
+	GPUHours        float64 `json:"gpu_hours"`
+	ReplicaHours    float64 `json:"replica_hours"`
+}
+
+// add returns the sum of two usages
+func (h ResourceHours) add(other ResourceHours) ResourceHours {
+	return ResourceHours{
+		CPUCoreHours:    h.CPUCoreHours + other.CPUCoreHours,
+		MemoryGiBHours:  h.MemoryGiBHours + other.MemoryGiBHours,
+		StorageGiBHours: h.StorageGiBHours + other.StorageGiBHours,
+		GPUHours:        h.GPUHours + other.GPUHours,
+		ReplicaHours:    h.ReplicaHours + other.ReplicaHours,
+	}
+}
+
+// set records the resource-hours of one billed resource
+func (h *ResourceHours) set(resource string, hours float64) {
+	switch resource {
+	case BillingResourceCPU:
+		h.CPUCoreHours = hours
+	case BillingResourceMemory:
+		h.MemoryGiBHours = hours
+	case BillingResourceStorage:
+		h.StorageGiBHours = hours
+	case BillingResourceGPU:
+		h.GPUHours = hours
+	case BillingResourceReplica:
+		h.ReplicaHours = hours
+	}
+}
+
+// BillingRates are the prices of one hour of each resource
+type BillingRates struct {
+	CPUCoreHour    float64 `json:"cpu_core_hour"`
+	MemoryGiBHour  float64 `json:"memory_gib_hour"`
+	StorageGiBHour float64 `json:"storage_gib_hour"`
+	GPUHour        float64 `json:"gpu_hour"`
+	ReplicaHour    float64 `json:"replica_hour"`
+}
+
+// cost prices a usage, rounded to the cent
+func (r BillingRates) cost(usage ResourceHours) float64 {
+	cost := usage.CPUCoreHours*r.CPUCoreHour +
+		usage.MemoryGiBHours*r.MemoryGiBHour +
+		usage.StorageGiBHours*r.StorageGiBHour +
+		usage.GPUHours*r.GPUHour +
+		usage.ReplicaHours*r.ReplicaHour
+	return math.Round(cost*100) / 100
+}
+
+// RateCard prices the resources of a tenant, a site, both or, with neither, of everything
+// no more specific card covers
+type RateCard struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Tenant    string       `json:"tenant,omitempty"`
+	SiteID    string       `json:"site_id,omitempty"`
+	Rates     BillingRates `json:"rates"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// RateCardRequest represents a rate card creation request
+type RateCardRequest struct {
+	Name   string       `json:"name" binding:"required"`
+	Tenant string       `json:"tenant"`
+	SiteID string       `json:"site_id"`
+	Rates  BillingRates `json:"rates"`
+}
+
+// specificity ranks how closely a card matches; a tenant's card beats a site's
+func (card *RateCard) specificity() int {
+	score := 0
+	if card.Tenant != "" {
+		score += 2
+	}
+	if card.SiteID != "" {
+		score++
+	}
+	return score
+}
+
+// billedWorkload is what reports name a workload by, kept after it is deleted
+type billedWorkload struct {
+	Name      string
+	Namespace string
+	Tenant    string
+	LastSeen  time.Time
+}
+
+// BillingManager prices the resources workloads hold for chargeback
+type BillingManager struct {
+	rateCards map[string]*RateCard
+	workloads map[string]billedWorkload
+	currency  string
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewBillingManager creates a new billing manager
+func NewBillingManager(logger *logrus.Logger) *BillingManager {
+	currency := os.Getenv("BILLING_CURRENCY")
+	if currency == "" {
+		currency = DefaultBillingCurrency
+	}
+	return &BillingManager{
+		rateCards: make(map[string]*RateCard),
+		workloads: make(map[string]billedWorkload),
+		currency:  currency,
+		logger:    logger,
+	}
+}
+
+// rateCardFor returns the most specific card matching a tenant and site, or nil
+func (bm *BillingManager) rateCardFor(tenant, siteID string) *RateCard {
+	var best *RateCard
+	for _, card := range bm.rateCards {
+		if (card.Tenant != "" && card.Tenant != tenant) || (card.SiteID != "" && card.SiteID != siteID) {
+			continue
+		}
+		if best == nil || card.specificity() > best.specificity() ||
+			(card.specificity() == best.specificity() && card.CreatedAt.After(best.CreatedAt)) {
+			best = card
+		}
+	}
+	return best
+}
+
+// gpusRequested counts the GPUs a replica holds; shares and MIG instances count as
+// fractions of a card
+func gpusRequested(request *GPURequest) float64 {
+	switch {
+	case request == nil:
+		return 0
+	case request.Count > 0:
+		return float64(request.Count)
+	case request.Share > 0:
+		return request.Share
+	case request.MIGProfile != "":
+		if match := migProfileSlices.FindStringSubmatch(request.MIGProfile); match != nil {
+			slices, _ := strconv.Atoi(match[1])
+			return math.Min(float64(slices)/migComputeSlices, 1)
+		}
+		return 1
+	}
+	return 0
+}
+
+// replicaResources returns what one replica of a workload holds of each billed resource:
+// its requests, the size of its volumes and its GPUs
+func replicaResources(workload *Workload) map[string]float64 {
+	requests := workloadRequests(workload)
+	storage := int64(0)
+	for _, volume := range workload.Volumes {
+		if q, ok := parseQuantity(volume.Size); ok {
+			storage += q.Value()
+		}
+	}
+	return map[string]float64{
+		BillingResourceCPU:     float64(requests.MilliCPU) / 1000,
+		BillingResourceMemory:  float64(requests.MemoryBytes) / (1 << 30),
+		BillingResourceStorage: float64(storage) / (1 << 30),
+		BillingResourceGPU:     gpusRequested(workload.Resources.GPU),
+		BillingResourceReplica: 1,
+	}
+}
+
+// billingMetricSamples returns what each workload's running replicas hold at each site,
+// and remembers the workloads for reports
+func (co *CentralOrchestrator) billingMetricSamples(now time.Time) []MetricSample {
+	var samples []MetricSample
+
+	co.WorkloadManager.mutex.RLock()
+	co.NodeManager.mutex.RLock()
+	co.BillingManager.mutex.Lock()
+	for _, workload := range co.WorkloadManager.workloads {
+		replicas := make(map[string]int32)
+		for _, deployment := range workload.Deployments {
+			if deployment.Status != WorkloadStatusRunning {
+				continue
+			}
+			siteID := UnassignedSite
+			if node, exists := co.NodeManager.nodes[deployment.NodeID]; exists && node.SiteID != "" {
+				siteID = node.SiteID
+			}
+			replicas[siteID] += deployment.Replicas
+		}
+		if len(replicas) == 0 {
+			continue
+		}
+
+		co.BillingManager.workloads[workload.ID] = billedWorkload{
+			Name:      workload.Name,
+			Namespace: workload.Namespace,
+			Tenant:    workloadTenant(workload),
+			LastSeen:  now,
+		}
+		held := replicaResources(workload)
+		for siteID, count := range replicas {
+			for _, resource := range billingResources {
+				if held[resource] == 0 {
+					continue
+				}
+				samples = append(samples, MetricSample{
+					Class:    MetricClassBilling,
+					Name:     resource + ":" + siteID,
+					EntityID: workload.ID,
+					Value:    held[resource] * float64(count),
+				})
+			}
+		}
+	}
+
+	// Workloads are forgotten once their usage has expired from the store
+	retention := time.Duration(co.MetricsStore.Policy(MetricClassBilling).HourlyHours) * time.Hour
+	for workloadID, workload := range co.BillingManager.workloads {
+		if now.Sub(workload.LastSeen) > retention {
+			delete(co.BillingManager.workloads, workloadID)
+		}
+	}
+	co.BillingManager.mutex.Unlock()
+	co.NodeManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RUnlock()
+
+	return samples
+}
+
+// ChargebackLine is what one workload held at one site and what it costs
+type ChargebackLine struct {
+	WorkloadID   string        `json:"workload_id"`
+	WorkloadName string        `json:"workload_name"`
+	Namespace    string        `json:"namespace"`
+	SiteID       string        `json:"site_id"`
+	Usage        ResourceHours `json:"usage"`
+	RateCardID   string        `json:"rate_card_id,omitempty"`
+	Cost         float64       `json:"cost"`
+}
+
+// TenantChargeback is what a tenant is charged for a month
+type TenantChargeback struct {
+	Tenant string           `json:"tenant"`
+	Usage  ResourceHours    `json:"usage"`
+	Cost   float64          `json:"cost"`
+	Lines  []ChargebackLine `json:"lines"`
+}
+
+// ChargebackReport is the chargeback of every tenant for one month
+type ChargebackReport struct {
+	Month    string    `json:"month"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Currency string    `json:"currency"`
+	// Set while the month is still running; the report covers it up to now
+	Partial     bool               `json:"partial"`
+	Tenants     []TenantChargeback `json:"tenants"`
+	Cost        float64            `json:"cost"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
+
+// chargebackReport integrates the billing metrics of a month, in UTC, and prices them with
+// the rate cards. Only the tenants allowed returns true for are included.
+func (co *CentralOrchestrator) chargebackReport(month time.Time, allowed func(tenant string) bool, now time.Time) ChargebackReport {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	report := ChargebackReport{
+		Month:       from.Format("2006-01"),
+		From:        from,
+		To:          to,
+		Currency:    co.BillingManager.currency,
+		Partial:     now.Before(to),
+		Tenants:     make([]TenantChargeback, 0),
+		GeneratedAt: now,
+	}
+
+	type lineKey struct{ workloadID, siteID string }
+	usage := make(map[lineKey]ResourceHours)
+	hoursPerSample := MetricsCollectionInterval.Hours()
+	for _, id := range co.MetricsStore.seriesIDs() {
+		if id.class != MetricClassBilling {
+			continue
+		}
+		resource, siteID, found := strings.Cut(id.name, ":")
+		if !found {
+			continue
+		}
+		hours := co.MetricsStore.Sum(id.class, id.name, id.entityID, from, to) * hoursPerSample
+		if hours == 0 {
+			continue
+		}
+		key := lineKey{id.entityID, siteID}
+		lineUsage := usage[key]
+		lineUsage.set(resource, hours)
+		usage[key] = lineUsage
+	}
+
+	co.BillingManager.mutex.RLock()
+	tenants := make(map[string]*TenantChargeback)
+	for key, lineUsage := range usage {
+		workload, known := co.BillingManager.workloads[key.workloadID]
+		if !known {
+			workload = billedWorkload{Tenant: DefaultTenant}
+		}
+		if !allowed(workload.Tenant) {
+			continue
+		}
+		line := ChargebackLine{
+			WorkloadID:   key.workloadID,
+			WorkloadName: workload.Name,
+			Namespace:    workload.Namespace,
+			SiteID:       key.siteID,
+			Usage:        lineUsage,
+		}
+		if card := co.BillingManager.rateCardFor(workload.Tenant, key.siteID); card != nil {
+			line.RateCardID = card.ID
+			line.Cost = card.Rates.cost(lineUsage)
+		}
+
+		tenant, exists := tenants[workload.Tenant]
+		if !exists {
+			tenant = &TenantChargeback{Tenant: workload.Tenant}
+			tenants[workload.Tenant] = tenant
+		}
+		tenant.Lines = append(tenant.Lines, line)
+		tenant.Usage = tenant.Usage.add(lineUsage)
+		tenant.Cost += line.Cost
+	}
+	co.BillingManager.mutex.RUnlock()
+
+	for _, tenant := range tenants {
+		sort.Slice(tenant.Lines, func(i, j int) bool {
+			if tenant.Lines[i].WorkloadName != tenant.Lines[j].WorkloadName {
+				return tenant.Lines[i].WorkloadName < tenant.Lines[j].WorkloadName
+			}
+			return tenant.Lines[i].SiteID < tenant.Lines[j].SiteID
+		})
+		tenant.Cost = math.Round(tenant.Cost*100) / 100
+		report.Tenants = append(report.Tenants, *tenant)
+		report.Cost += tenant.Cost
+	}
+	sort.Slice(report.Tenants, func(i, j int) bool {
+		return report.Tenants[i].Tenant < report.Tenants[j].Tenant
+	})
+	report.Cost = math.Round(report.Cost*100) / 100
+	return report
+}
+
+// GetChargebackReport returns the chargeback of a month given as month=YYYY-MM, the
+// current one by default, as JSON or as CSV with format=csv. tenant limits it to one tenant.
+func (co *CentralOrchestrator) GetChargebackReport(c *gin.Context) {
+	now := time.Now().UTC()
+	month := now
+	if value := c.Query("month"); value != "" {
+		parsed, err := time.Parse("2006-01", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "month must be given as YYYY-MM"})
+			return
+		}
+		month = parsed
+	}
+	tenantFilter := c.Query("tenant")
+	allowed := func(tenant string) bool {
+		return (tenantFilter == "" || tenant == tenantFilter) && tenantAllowed(c, tenant)
+	}
+
+	report := co.chargebackReport(month, allowed, now)
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"report": report})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=chargeback-%s.csv", report.Month))
+
+	format := func(value float64) string { return strconv.FormatFloat(value, 'f', 3, 64) }
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"month", "tenant", "workload_id", "workload_name", "namespace", "site_id",
+		"cpu_core_hours", "memory_gib_hours", "storage_gib_hours", "gpu_hours", "replica_hours",
+		"rate_card_id", "cost", "currency"})
+	for _, tenant := range report.Tenants {
+		for _, line := range tenant.Lines {
+			writer.Write([]string{
+				report.Month,
+				tenant.Tenant,
+				line.WorkloadID,
+				line.WorkloadName,
+				line.Namespace,
+				line.SiteID,
+				format(line.Usage.CPUCoreHours),
+				format(line.Usage.MemoryGiBHours),
+				format(line.Usage.StorageGiBHours),
+				format(line.Usage.GPUHours),
+				format(line.Usage.ReplicaHours),
+				line.RateCardID,
+				strconv.FormatFloat(line.Cost, 'f', 2, 64),
+				report.Currency,
+			})
+		}
+	}
+	writer.Flush()
+}
+
+// CreateRateCard prices resources for a tenant, a site or by default
+func (co *CentralOrchestrator) CreateRateCard(c *gin.Context) {
+	var req RateCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rates := req.Rates
+	if rates.CPUCoreHour < 0 || rates.MemoryGiBHour < 0 || rates.StorageGiBHour < 0 || rates.GPUHour < 0 || rates.ReplicaHour < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rates must not be negative"})
+		return
+	}
+	if req.Tenant != "" && !tenantAllowed(c, req.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", req.Tenant)})
+		return
+	}
+
+	card := &RateCard{
+		ID:        generateID(),
+		Name:      req.Name,
+		Tenant:    req.Tenant,
+		SiteID:    req.SiteID,
+		Rates:     rates,
+		CreatedAt: time.Now(),
+	}
+
+	co.BillingManager.mutex.Lock()
+	co.BillingManager.rateCards[card.ID] = card
+	co.BillingManager.mutex.Unlock()
+
+	co.Logger.Infof("Rate card %s created with ID %s", card.Name, card.ID)
+	co.AuditLog.RecordRequest(c, "rate_card.create", card.ID, map[string]string{"tenant": card.Tenant, "site_id": card.SiteID})
+
+	c.JSON(http.StatusCreated, gin.H{"id": card.ID, "rate_card": card})
+}
+
+// ListRateCards returns all rate cards
+func (co *CentralOrchestrator) ListRateCards(c *gin.Context) {
+	co.BillingManager.mutex.RLock()
+	defer co.BillingManager.mutex.RUnlock()
+
+	cards := make([]*RateCard, 0, len(co.BillingManager.rateCards))
+	for _, card := range co.BillingManager.rateCards {
+		if card.Tenant == "" || tenantAllowed(c, card.Tenant) {
+			cards = append(cards, card)
+		}
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		return cards[i].CreatedAt.Before(cards[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{"rate_cards": cards, "currency": co.BillingManager.currency})
+}
+
+// DeleteRateCard removes a rate card
+func (co *CentralOrchestrator) DeleteRateCard(c *gin.Context) {
+	cardID := c.Param("id")
+
+	co.BillingManager.mutex.Lock()
+	card, exists := co.BillingManager.rateCards[cardID]
+	if !exists || (card.Tenant != "" && !tenantAllowed(c, card.Tenant)) {
+		co.BillingManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rate card not found"})
+		return
+	}
+	delete(co.BillingManager.rateCards, cardID)
+	co.BillingManager.mutex.Unlock()
+
+	co.Logger.Infof("Rate card %s deleted", cardID)
+	co.AuditLog.RecordRequest(c, "rate_card.delete", cardID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rate card deleted successfully"})
+}
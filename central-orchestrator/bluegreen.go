@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Interval at which blue-green deployments are moved along
+	BlueGreenCheckInterval = 10 * time.Second
+
+	// Appended to a workload's name for the workload running its new version
+	BlueGreenPreviewSuffix = "-green"
+)
+
+// BlueGreenPhase is how far a blue-green deployment has progressed
+type BlueGreenPhase string
+
+const (
+	// The new version is starting next to the current one
+	BlueGreenPhaseDeploying BlueGreenPhase = "deploying"
+	// The new version failed to start on a node; traffic stays on the current version
+	BlueGreenPhaseFailed BlueGreenPhase = "failed"
+	// The new version is healthy and waits for the traffic switch
+	BlueGreenPhaseReady BlueGreenPhase = "ready"
+	// The workload's service sends traffic to the new version
+	BlueGreenPhaseSwitched BlueGreenPhase = "switched"
+	// The workload itself is rolling to the new version while the preview serves traffic
+	BlueGreenPhasePromoting BlueGreenPhase = "promoting"
+)
+
+// BlueGreenDeployment runs a new version of a workload on the same nodes as the current
+// one, as a separate preview workload, and switches the workload's service to it once it
+// is healthy. Until the new version is promoted, one call switches traffic back.
+type BlueGreenDeployment struct {
+	Phase             BlueGreenPhase `json:"phase"`
+	PreviewWorkloadID string         `json:"preview_workload_id"`
+	// Spec of the new version, applied to the workload on promotion
+	Spec WorkloadDeploymentRequest `json:"spec"`
+	// Traffic is switched only on request, not as soon as the new version is healthy
+	ManualSwitch bool       `json:"manual_switch,omitempty"`
+	Message      string     `json:"message,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	ReadyAt      *time.Time `json:"ready_at,omitempty"`
+	SwitchedAt   *time.Time `json:"switched_at,omitempty"`
+}
+
+// BlueGreenRequest starts a blue-green deployment of a new version of a workload
+type BlueGreenRequest struct {
+	// Name, namespace, tenant, type, replicas and expiry are the workload's
+	Spec         WorkloadDeploymentRequest `json:"spec" binding:"required"`
+	ManualSwitch bool                      `json:"manual_switch"`
+}
+
+// servesPreview reports whether the workload's service sends its traffic to the preview
+func (bg *BlueGreenDeployment) servesPreview() bool {
+	return bg.Phase == BlueGreenPhaseSwitched || bg.Phase == BlueGreenPhasePromoting
+}
+
+// available reports whether every replica of the workload is placed and reported ready
+func (w *Workload) available() bool {
+	var replicas int32
+	for _, deployment := range w.Deployments {
+		if deployment.Status == WorkloadStatusPending {
+			return false
+		}
+		if deployment.Status == WorkloadStatusRunning {
+			replicas += deployment.Replicas
+		}
+	}
+	return replicas >= expectedReplicas(w)
+}
+
+// failingNode returns a node on which the workload's pods fail to start, or ""
+func (w *Workload) failingNode() string {
+	for _, deployment := range w.Deployments {
+		if deployment.placed() && deployment.Observed != nil && deployment.Observed.Phase == ObservedPhaseFailed {
+			return deployment.NodeID
+		}
+	}
+	return ""
+}
+
+// blueGreenWorkload looks up the workload a blue-green request acts on and checks the
+// caller may act on it; it writes the error response and returns nil otherwise. Callers
+// must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) blueGreenWorkload(c *gin.Context) *Workload {
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return nil
+	}
+	if !tenantAllowed(c, workload.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", workloadTenant(workload))})
+		return nil
+	}
+	return workload
+}
+
+// StartBlueGreenDeployment deploys a new version of a workload next to the current one on
+// the same nodes
+func (co *CentralOrchestrator) StartBlueGreenDeployment(c *gin.Context) {
+	var req BlueGreenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload := co.blueGreenWorkload(c)
+	if workload == nil {
+		return
+	}
+	switch {
+	case workload.BlueGreenOf != "":
+		c.JSON(http.StatusConflict, gin.H{"error": "Workload is the new version of a blue-green deployment"})
+		return
+	case workload.BlueGreen != nil:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Workload already has a blue-green deployment %s", workload.BlueGreen.Phase)})
+		return
+	case workload.Type != WorkloadTypeDeployment && workload.Type != WorkloadTypeStatefulSet && workload.Type != WorkloadTypeDaemonSet:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Blue-green deployments are not supported for %s workloads", workload.Type)})
+		return
+	}
+
+	preview, err := co.startBlueGreenDeployment(workload, req.Spec, req.ManualSwitch, time.Now())
+	if err != nil {
+		c.JSON(workloadErrorStatus(err), workloadSpecError(err))
+		return
+	}
+
+	co.Logger.Infof("Blue-green deployment of workload %s started with %s", workload.Name, preview.Name)
+	co.AuditLog.RecordRequest(c, "workload.blue_green.start", "workload:"+workload.ID,
+		map[string]string{"image": workload.BlueGreen.Spec.Image, "preview": preview.ID})
+	c.JSON(http.StatusAccepted, gin.H{"workload": workload, "preview": preview})
+}
+
+// startBlueGreenDeployment starts the preview of a new version of a workload next to it.
+// The spec's name, namespace, tenant, type, replicas and expiry are the workload's.
+// Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) startBlueGreenDeployment(workload *Workload, spec WorkloadDeploymentRequest, manualSwitch bool, now time.Time) (*Workload, error) {
+	spec.Name, spec.Namespace, spec.Tenant, spec.Type = workload.Name, workload.Namespace, workload.Tenant, workload.Type
+	spec.Replicas = workload.Replicas
+	spec.TTL, spec.ExpiresAt = "", workload.ExpiresAt
+
+	previewSpec := spec
+	previewSpec.Name = workload.Name + BlueGreenPreviewSuffix
+	// The preview has no DNS name of its own; it takes over the workload's traffic
+	previewSpec.DNS = nil
+	preview, err := newWorkload(previewSpec, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := co.validateDatasetConstraints(preview.Placement.Constraints); err != nil {
+		return nil, err
+	}
+	preview.BlueGreenOf = workload.ID
+	preview.EnvironmentBinding = workload.EnvironmentBinding
+	if err := co.checkNamespaceQuota(preview); err != nil {
+		return nil, err
+	}
+
+	// Next to the current version, replica for replica
+	for _, deployment := range workload.Deployments {
+		if deployment.placed() {
+			preview.addDeployment(deployment.NodeID, deployment.Replicas)
+		}
+	}
+	if preview.runningReplicas() < expectedReplicas(preview) {
+		preview.Status = WorkloadStatusPending
+	}
+	co.WorkloadManager.workloads[preview.ID] = preview
+
+	workload.BlueGreen = &BlueGreenDeployment{
+		Phase:             BlueGreenPhaseDeploying,
+		PreviewWorkloadID: preview.ID,
+		Spec:              spec,
+		ManualSwitch:      manualSwitch,
+		StartedAt:         now,
+	}
+	workload.UpdatedAt = now
+	co.AgentStreamHub.wake()
+	return preview, nil
+}
+
+// SwitchBlueGreenTraffic sends a workload's traffic to the new version of its blue-green
+// deployment once that is healthy
+func (co *CentralOrchestrator) SwitchBlueGreenTraffic(c *gin.Context) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload := co.blueGreenWorkload(c)
+	if workload == nil {
+		return
+	}
+	if workload.BlueGreen == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Workload has no blue-green deployment"})
+		return
+	}
+	if workload.BlueGreen.Phase != BlueGreenPhaseReady {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Blue-green deployment is %s, not ready", workload.BlueGreen.Phase)})
+		return
+	}
+
+	co.switchBlueGreenTraffic(workload, time.Now())
+	co.AuditLog.RecordRequest(c, "workload.blue_green.switch", "workload:"+workload.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
+
+// PromoteBlueGreenDeployment makes the new version the workload's own spec. The workload
+// rolls to it while the preview serves traffic; once it is available again, traffic
+// returns to it and the preview is removed.
+func (co *CentralOrchestrator) PromoteBlueGreenDeployment(c *gin.Context) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	current := co.blueGreenWorkload(c)
+	if current == nil {
+		return
+	}
+	if current.BlueGreen == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Workload has no blue-green deployment"})
+		return
+	}
+	if current.BlueGreen.Phase != BlueGreenPhaseSwitched {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Blue-green deployment is %s; switch traffic before promoting", current.BlueGreen.Phase)})
+		return
+	}
+
+	now := time.Now()
+	next, err := newWorkload(current.BlueGreen.Spec, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, workloadSpecError(err))
+		return
+	}
+	next = rollOutWorkload(current, next, now)
+	next.Metadata = current.Metadata
+	next.Autoscaling = current.Autoscaling
+	next.EnvironmentBinding = current.EnvironmentBinding
+	next.BlueGreen.Phase = BlueGreenPhasePromoting
+	next.BlueGreen.Message = ""
+	co.recordRevision(current, next, "blue-green promotion", now)
+	co.WorkloadManager.workloads[next.ID] = next
+	co.AgentStreamHub.wake()
+
+	co.Logger.Infof("Promoting blue-green deployment of workload %s", next.Name)
+	co.AuditLog.RecordRequest(c, "workload.blue_green.promote", "workload:"+next.ID, nil)
+	c.JSON(http.StatusAccepted, gin.H{"workload": next})
+}
+
+// RollbackBlueGreenDeployment switches a workload's traffic back to its current version
+// and removes the new one
+func (co *CentralOrchestrator) RollbackBlueGreenDeployment(c *gin.Context) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload := co.blueGreenWorkload(c)
+	if workload == nil {
+		return
+	}
+	if workload.BlueGreen == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Workload has no blue-green deployment"})
+		return
+	}
+	if workload.BlueGreen.Phase == BlueGreenPhasePromoting {
+		c.JSON(http.StatusConflict, gin.H{"error": "Blue-green deployment is being promoted; roll back to the previous revision instead"})
+		return
+	}
+
+	phase := workload.BlueGreen.Phase
+	co.endBlueGreenDeployment(workload, time.Now())
+	co.AgentStreamHub.wake()
+
+	co.Logger.Infof("Blue-green deployment of workload %s rolled back while %s", workload.Name, phase)
+	co.AuditLog.RecordRequest(c, "workload.blue_green.rollback", "workload:"+workload.ID,
+		map[string]string{"phase": string(phase)})
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
+
+// switchBlueGreenTraffic points the workload's service at the preview's pods on every node
+// in one desired-state change. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) switchBlueGreenTraffic(workload *Workload, now time.Time) {
+	workload.BlueGreen.Phase = BlueGreenPhaseSwitched
+	workload.BlueGreen.SwitchedAt = &now
+	workload.UpdatedAt = now
+	co.AgentStreamHub.wake()
+	co.Logger.Infof("Switched traffic of workload %s to its new version", workload.Name)
+}
+
+// endBlueGreenDeployment returns the workload's traffic to its own pods and removes the
+// preview. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) endBlueGreenDeployment(workload *Workload, now time.Time) {
+	if preview, exists := co.WorkloadManager.workloads[workload.BlueGreen.PreviewWorkloadID]; exists {
+		// Streaming agents remove it from their nodes once it leaves their desired state
+		preview.Status = WorkloadStatusStopped
+		preview.UpdatedAt = now
+		delete(co.WorkloadManager.workloads, preview.ID)
+		co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, preview.ID)
+		co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, preview.ID)
+		co.RevisionHistory.forget(preview.ID)
+	}
+	workload.BlueGreen = nil
+	workload.UpdatedAt = now
+}
+
+// blueGreenController moves blue-green deployments along as their workloads become healthy
+func (co *CentralOrchestrator) blueGreenController() {
+	ticker := time.NewTicker(BlueGreenCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.progressBlueGreenDeployments(time.Now())
+		}
+	}
+}
+
+// progressBlueGreenDeployments marks previews ready or failed, switches traffic to ready
+// ones unless the switch is manual, and finishes promotions once the promoted workload is
+// available
+func (co *CentralOrchestrator) progressBlueGreenDeployments(now time.Time) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	for _, workload := range co.WorkloadManager.workloads {
+		bg := workload.BlueGreen
+		if bg == nil {
+			continue
+		}
+		preview, exists := co.WorkloadManager.workloads[bg.PreviewWorkloadID]
+		if !exists {
+			co.Logger.Warnf("New version of workload %s was deleted; ending its blue-green deployment", workload.Name)
+			workload.BlueGreen = nil
+			workload.UpdatedAt = now
+			co.AgentStreamHub.wake()
+			continue
+		}
+
+		switch bg.Phase {
+		case BlueGreenPhaseDeploying, BlueGreenPhaseFailed:
+			if preview.available() {
+				bg.Phase = BlueGreenPhaseReady
+				bg.Message = ""
+				bg.ReadyAt = &now
+				workload.UpdatedAt = now
+				co.Logger.Infof("New version of workload %s is ready", workload.Name)
+				if !bg.ManualSwitch {
+					co.switchBlueGreenTraffic(workload, now)
+				}
+			} else if nodeID := preview.failingNode(); nodeID != "" && bg.Phase != BlueGreenPhaseFailed {
+				bg.Phase = BlueGreenPhaseFailed
+				bg.Message = fmt.Sprintf("new version is failing on node %s", nodeID)
+				workload.UpdatedAt = now
+				co.Logger.Warnf("New version of workload %s is failing on node %s", workload.Name, nodeID)
+			}
+		case BlueGreenPhasePromoting:
+			if workload.available() {
+				co.endBlueGreenDeployment(workload, now)
+				co.AgentStreamHub.wake()
+				co.Logger.Infof("Blue-green deployment of workload %s promoted", workload.Name)
+			}
+		}
+	}
+}
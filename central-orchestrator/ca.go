@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long an external CA may take to sign a node certificate
+	CertificateSigningTimeout = 10 * time.Second
+
+	// Validity of the root generated when no CA is configured
+	GeneratedRootValidity = 10 * 365 * 24 * time.Hour
+)
+
+// SigningRequest is a node certificate request to be signed by a CA
+type SigningRequest struct {
+	// PEM-encoded PKCS #10 request and its parsed form
+	CSR      []byte
+	Request  *x509.CertificateRequest
+	Validity time.Duration
+}
+
+// SignedCertificate is a signed leaf and the CA chain it was issued under, both PEM
+type SignedCertificate struct {
+	Certificate []byte
+	Chain       []byte
+}
+
+// CertificateSigner signs node certificates. Local signers hold the CA key in the
+// orchestrator; external ones delegate to an enterprise PKI.
+type CertificateSigner interface {
+	Name() string
+	Sign(ctx context.Context, req *SigningRequest) (*SignedCertificate, error)
+	// CAChain returns the PEM chain issued certificates verify against
+	CAChain(ctx context.Context) ([]byte, error)
+}
+
+// newCertificateSigner creates the signer selected by CA_SIGNER:
+//   - "local" (default): sign with CA_CERT_PATH/CA_KEY_PATH, an intermediate chained to an
+//     enterprise root through CA_CHAIN_PATH, or with a generated root when no CA is given
+//   - "vault": Vault PKI at VAULT_ADDR, mount VAULT_PKI_MOUNT and role VAULT_PKI_ROLE
+//   - "webhook": an external signing service at CA_WEBHOOK_URL, the adapter point for
+//     cert-manager, AWS Private CA and other CAs
+func newCertificateSigner(logger *logrus.Logger) (CertificateSigner, error) {
+	httpClient := &http.Client{Timeout: CertificateSigningTimeout}
+
+	switch os.Getenv("CA_SIGNER") {
+	case "", "local":
+		if os.Getenv("CA_CERT_PATH") == "" {
+			logger.Warn("CA_CERT_PATH is not set, issuing node certificates from a generated root")
+			return newGeneratedRootSigner()
+		}
+		return loadLocalCASigner(os.Getenv("CA_CERT_PATH"), os.Getenv("CA_KEY_PATH"), os.Getenv("CA_CHAIN_PATH"))
+	case "vault":
+		signer := &vaultSigner{
+			addr:       strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+			token:      os.Getenv("VAULT_TOKEN"),
+			mount:      os.Getenv("VAULT_PKI_MOUNT"),
+			role:       os.Getenv("VAULT_PKI_ROLE"),
+			httpClient: httpClient,
+		}
+		if signer.mount == "" {
+			signer.mount = "pki"
+		}
+		if signer.addr == "" || signer.role == "" {
+			return nil, fmt.Errorf("VAULT_ADDR and VAULT_PKI_ROLE are required for the vault signer")
+		}
+		return signer, nil
+	case "webhook":
+		signer := &webhookSigner{
+			url:        strings.TrimSuffix(os.Getenv("CA_WEBHOOK_URL"), "/"),
+			token:      os.Getenv("CA_WEBHOOK_TOKEN"),
+			httpClient: httpClient,
+		}
+		if signer.url == "" {
+			return nil, fmt.Errorf("CA_WEBHOOK_URL is required for the webhook signer")
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unknown CA signer %q", os.Getenv("CA_SIGNER"))
+	}
+}
+
+// localCASigner signs with a CA certificate and key held by the orchestrator
+type localCASigner struct {
+	name   string
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	// PEM of the CA certificate followed by any parents up to the root
+	chain []byte
+}
+
+func (s *localCASigner) Name() string {
+	return s.name
+}
+
+// newGeneratedRootSigner creates a self-signed root for deployments without a PKI
+func newGeneratedRootSigner() (*localCASigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %v", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Kubernetes Edge Framework"}, CommonName: "Edge Orchestrator Root CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(GeneratedRootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+
+	return &localCASigner{
+		name:   "generated-root",
+		caCert: caCert,
+		caKey:  key,
+		chain:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// loadLocalCASigner loads an intermediate (or root) CA certificate and key, plus the
+// rest of its chain when the CA is an intermediate
+func loadLocalCASigner(certPath, keyPath, chainPath string) (*localCASigner, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("CA certificate %s is not a PEM certificate", certPath)
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", certPath)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %v", err)
+	}
+	caKey, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(block)
+	name := "local-root"
+	if chainPath != "" {
+		parents, err := os.ReadFile(chainPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA chain: %v", err)
+		}
+		if err := verifyIntermediate(caCert, parents); err != nil {
+			return nil, err
+		}
+		chain = append(chain, parents...)
+		name = "local-intermediate"
+	}
+
+	return &localCASigner{name: name, caCert: caCert, caKey: caKey, chain: chain}, nil
+}
+
+// verifyIntermediate checks that the CA certificate chains to a root in the parents bundle
+func verifyIntermediate(caCert *x509.Certificate, parents []byte) error {
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for rest := parents; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		parent, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA chain: %v", err)
+		}
+		if parent.Subject.String() == parent.Issuer.String() {
+			roots.AddCert(parent)
+		} else {
+			intermediates.AddCert(parent)
+		}
+	}
+
+	_, err := caCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("CA certificate does not chain to a root in the CA chain: %v", err)
+	}
+	return nil
+}
+
+// parsePrivateKey decodes a PKCS #8, PKCS #1 or SEC 1 PEM private key
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("CA key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key type %T cannot sign", key)
+	}
+	return signer, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
+
+// Sign issues a client and server certificate for the request, never outliving the CA
+func (s *localCASigner) Sign(ctx context.Context, req *SigningRequest) (*SignedCertificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(req.Validity)
+	if notAfter.After(s.caCert.NotAfter) {
+		notAfter = s.caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      req.Request.Subject,
+		NotBefore:    now,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     req.Request.DNSNames,
+		IPAddresses:  req.Request.IPAddresses,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, req.Request.PublicKey, s.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+
+	return &SignedCertificate{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Chain:       s.chain,
+	}, nil
+}
+
+func (s *localCASigner) CAChain(ctx context.Context) ([]byte, error) {
+	return s.chain, nil
+}
+
+// GetCAChain returns the PEM chain node certificates are issued under, for adding the
+// framework's issuer to trust stores
+func (co *CentralOrchestrator) GetCAChain(c *gin.Context) {
+	signer, err := co.SecurityManager.certificateSigner()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), CertificateSigningTimeout)
+	defer cancel()
+
+	chain, err := signer.CAChain(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("failed to fetch CA chain: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"signer": signer.Name(), "chain": string(chain)})
+}
+
+// certificateSigner returns the configured signer, or why issuance is unavailable
+func (sm *SecurityManager) certificateSigner() (CertificateSigner, error) {
+	if sm.signer == nil {
+		return nil, fmt.Errorf("certificate signing is not configured: %v", sm.signerErr)
+	}
+	return sm.signer, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// vaultSigner signs node certificates with a Vault PKI secrets engine role
+type vaultSigner struct {
+	addr       string
+	token      string
+	mount      string
+	role       string
+	httpClient *http.Client
+}
+
+type vaultSignRequest struct {
+	CSR        string `json:"csr"`
+	CommonName string `json:"common_name"`
+	AltNames   string `json:"alt_names,omitempty"`
+	IPSANs     string `json:"ip_sans,omitempty"`
+	TTL        string `json:"ttl"`
+	Format     string `json:"format"`
+}
+
+type vaultSignResponse struct {
+	Errors []string `json:"errors"`
+	Data   struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+}
+
+func (s *vaultSigner) Name() string {
+	return "vault"
+}
+
+func (s *vaultSigner) Sign(ctx context.Context, req *SigningRequest) (*SignedCertificate, error) {
+	ipSANs := make([]string, 0, len(req.Request.IPAddresses))
+	for _, ip := range req.Request.IPAddresses {
+		ipSANs = append(ipSANs, ip.String())
+	}
+
+	payload := vaultSignRequest{
+		CSR:        string(req.CSR),
+		CommonName: req.Request.Subject.CommonName,
+		AltNames:   strings.Join(req.Request.DNSNames, ","),
+		IPSANs:     strings.Join(ipSANs, ","),
+		TTL:        fmt.Sprintf("%ds", int64(req.Validity.Seconds())),
+		Format:     "pem",
+	}
+
+	var resp vaultSignResponse
+	if err := s.do(ctx, "POST", fmt.Sprintf("/v1/%s/sign/%s", s.mount, s.role), payload, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data.Certificate == "" {
+		return nil, fmt.Errorf("vault returned no certificate: %s", strings.Join(resp.Errors, "; "))
+	}
+
+	chain := resp.Data.CAChain
+	if len(chain) == 0 && resp.Data.IssuingCA != "" {
+		chain = []string{resp.Data.IssuingCA}
+	}
+	return &SignedCertificate{
+		Certificate: []byte(resp.Data.Certificate + "\n"),
+		Chain:       []byte(strings.Join(chain, "\n") + "\n"),
+	}, nil
+}
+
+func (s *vaultSigner) CAChain(ctx context.Context) ([]byte, error) {
+	var chain bytes.Buffer
+	if err := s.do(ctx, "GET", fmt.Sprintf("/v1/%s/ca_chain", s.mount), nil, &chain); err != nil {
+		return nil, err
+	}
+	return chain.Bytes(), nil
+}
+
+// do sends a request to Vault; a *bytes.Buffer out receives the raw body, anything else
+// is decoded as JSON
+func (s *vaultSigner) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.addr+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("vault %s %s returned status %d: %s", method, path, resp.StatusCode, string(data))
+	}
+
+	if buffer, ok := out.(*bytes.Buffer); ok {
+		if _, err := buffer.ReadFrom(resp.Body); err != nil {
+			return fmt.Errorf("failed to read vault response: %v", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode vault response: %v", err)
+	}
+	return nil
+}
+
+// webhookSigner delegates signing to an external service. It is the integration point
+// for CAs without a built-in signer, such as cert-manager (through a CertificateRequest
+// bridge) or AWS Private CA. The service implements:
+//
+//	POST /sign  {"csr", "validity_seconds"} -> {"certificate", "chain"}
+//	GET  /ca    -> {"chain"}
+type webhookSigner struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+type webhookSignRequest struct {
+	CSR             string `json:"csr"`
+	ValiditySeconds int64  `json:"validity_seconds"`
+}
+
+type webhookSignResponse struct {
+	Certificate string `json:"certificate"`
+	Chain       string `json:"chain"`
+}
+
+func (s *webhookSigner) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSigner) Sign(ctx context.Context, req *SigningRequest) (*SignedCertificate, error) {
+	var resp webhookSignResponse
+	payload := webhookSignRequest{CSR: string(req.CSR), ValiditySeconds: int64(req.Validity.Seconds())}
+	if err := s.do(ctx, "POST", "/sign", payload, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Certificate == "" {
+		return nil, fmt.Errorf("ca webhook returned no certificate")
+	}
+	return &SignedCertificate{Certificate: []byte(resp.Certificate), Chain: []byte(resp.Chain)}, nil
+}
+
+func (s *webhookSigner) CAChain(ctx context.Context) ([]byte, error) {
+	var resp webhookSignResponse
+	if err := s.do(ctx, "GET", "/ca", nil, &resp); err != nil {
+		return nil, err
+	}
+	return []byte(resp.Chain), nil
+}
+
+func (s *webhookSigner) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ca webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ca webhook %s %s returned status %d: %s", method, path, resp.StatusCode, string(data))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode ca webhook response: %v", err)
+	}
+	return nil
+}
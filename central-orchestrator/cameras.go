@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Fired while a camera reports no frames or disappears from its node
+	CameraStreamDownAlert = "CameraStreamDown"
+
+	// Label identifying the camera a video analytics workload is bound to
+	CameraLabel = "edge.io/camera"
+)
+
+// CameraType is how a camera is attached to its node
+type CameraType string
+
+const (
+	// Locally attached V4L2 device such as a USB camera
+	CameraTypeUSB CameraType = "usb"
+	// Network camera reached over RTSP
+	CameraTypeRTSP CameraType = "rtsp"
+)
+
+// CameraStatus summarizes a camera's stream health
+type CameraStatus string
+
+const (
+	CameraStatusStreaming   CameraStatus = "streaming"
+	CameraStatusStalled     CameraStatus = "stalled"
+	CameraStatusUnreachable CameraStatus = "unreachable"
+	// The agent can see the camera but has no way to probe its stream
+	CameraStatusUnknown CameraStatus = "unknown"
+	// Set by the orchestrator when a camera is no longer reported
+	CameraStatusDisconnected CameraStatus = "disconnected"
+)
+
+// CameraHealth is the stream health an agent measured for a camera
+type CameraHealth struct {
+	Status          CameraStatus `json:"status"`
+	FramesPerSecond float64      `json:"frames_per_second"`
+	LastFrameAt     *time.Time   `json:"last_frame_at,omitempty"`
+	Error           string       `json:"error,omitempty"`
+	CheckedAt       time.Time    `json:"checked_at"`
+}
+
+// Camera is a video source attached to, or reachable from, an edge node. IDs are chosen by
+// the agent and shared by every node that can reach the same network camera.
+type Camera struct {
+	ID         string       `json:"id" binding:"required"`
+	Name       string       `json:"name"`
+	Type       CameraType   `json:"type" binding:"required"`
+	DevicePath string       `json:"device_path,omitempty"`
+	StreamURL  string       `json:"stream_url,omitempty"`
+	Health     CameraHealth `json:"health"`
+}
+
+// source is where an analytics container reads the camera's frames from
+func (cam *Camera) source() string {
+	if cam.Type == CameraTypeUSB {
+		return cam.DevicePath
+	}
+	return cam.StreamURL
+}
+
+// CameraReport is the full set of cameras an agent sees
+type CameraReport struct {
+	Cameras []Camera `json:"cameras" binding:"dive"`
+}
+
+// CameraView is a camera together with the node it was reported by
+type CameraView struct {
+	Camera
+	NodeID   string `json:"node_id"`
+	NodeName string `json:"node_name"`
+	SiteID   string `json:"site_id,omitempty"`
+}
+
+// CameraBinding ties a workload to the camera stream it analyzes
+type CameraBinding struct {
+	CameraID string     `json:"camera_id"`
+	Type     CameraType `json:"type"`
+	// Device path or stream URL, also passed to the container as CAMERA_SOURCE
+	Source string `json:"source"`
+}
+
+// VideoAnalyticsRequest creates one analytics workload per camera from a common template
+type VideoAnalyticsRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Namespace   string            `json:"namespace"`
+	Tenant      string            `json:"tenant"`
+	Metadata    WorkloadMetadata  `json:"metadata"`
+	Image       string            `json:"image" binding:"required"`
+	Resources   WorkloadResources `json:"resources"`
+	Environment map[string]string `json:"environment"`
+	Labels      map[string]string `json:"labels"`
+	Ports       []WorkloadPort    `json:"ports"`
+	Criticality int32             `json:"criticality"`
+	QoSClass    QoSClass          `json:"qos_class,omitempty"`
+	// Cameras to bind; when empty every camera reported at site_id is bound
+	Cameras []string `json:"cameras"`
+	SiteID  string   `json:"site_id"`
+}
+
+// camera returns an attached camera by ID, or nil
+func (node *EdgeNode) camera(id string) *Camera {
+	for i := range node.Cameras {
+		if node.Cameras[i].ID == id {
+			return &node.Cameras[i]
+		}
+	}
+	return nil
+}
+
+// cameraIDs returns the IDs of a set of cameras
+func cameraIDs(cameras []Camera) []string {
+	ids := make([]string, 0, len(cameras))
+	for _, camera := range cameras {
+		ids = append(ids, camera.ID)
+	}
+	return ids
+}
+
+// cameraScopeID identifies one node's view of a camera in alerts
+func cameraScopeID(nodeID, cameraID string) string {
+	return nodeID + "/" + cameraID
+}
+
+// ReportNodeCameras replaces a node's camera inventory and health. Cameras that stop
+// streaming or disappear raise an alert, and a changed set of cameras re-evaluates the
+// placement of camera-bound workloads.
+func (co *CentralOrchestrator) ReportNodeCameras(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req CameraReport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	for i := range req.Cameras {
+		if req.Cameras[i].Type != CameraTypeUSB && req.Cameras[i].Type != CameraTypeRTSP {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("camera %s type must be usb or rtsp", req.Cameras[i].ID)})
+			return
+		}
+		if req.Cameras[i].Health.CheckedAt.IsZero() {
+			req.Cameras[i].Health.CheckedAt = now
+		}
+	}
+
+	co.NodeManager.mutex.Lock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		co.NodeManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+	previous := node.Cameras
+	node.Cameras = req.Cameras
+	node.UpdatedAt = now
+	nodeName, siteID := node.Name, node.SiteID
+	co.NodeManager.mutex.Unlock()
+
+	for _, camera := range req.Cameras {
+		scopeID := cameraScopeID(nodeID, camera.ID)
+		switch camera.Health.Status {
+		case CameraStatusStalled, CameraStatusUnreachable:
+			co.AlertManager.Fire(CameraStreamDownAlert, AlertSeverityWarning, AlertScopeCamera, scopeID, siteID,
+				newMessage(MsgCameraStreamDown, "camera", camera.ID, "node", nodeName, "status", camera.Health.Status))
+		default:
+			co.AlertManager.Resolve(CameraStreamDownAlert, AlertScopeCamera, scopeID)
+		}
+	}
+	current := cameraIDs(req.Cameras)
+	for _, camera := range previous {
+		if !contains(current, camera.ID) {
+			co.AlertManager.Fire(CameraStreamDownAlert, AlertSeverityWarning, AlertScopeCamera, cameraScopeID(nodeID, camera.ID), siteID,
+				newMessage(MsgCameraStreamDown, "camera", camera.ID, "node", nodeName, "status", CameraStatusDisconnected))
+		}
+	}
+
+	if !sameStringSet(cameraIDs(previous), current) {
+		co.Logger.Infof("Node %s now has %d cameras", nodeID, len(req.Cameras))
+		co.reevaluatePlacement(nodeID, map[string]bool{"camera": true})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cameras updated"})
+}
+
+// cameraViews returns the cameras reported by the nodes the caller may see, optionally
+// limited to a site and status, ordered by camera then node
+func (co *CentralOrchestrator) cameraViews(c *gin.Context, siteID string, status CameraStatus) []CameraView {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	views := make([]CameraView, 0)
+	for _, node := range co.NodeManager.nodes {
+		if (siteID != "" && node.SiteID != siteID) || !nodeVisible(c, node) {
+			continue
+		}
+		for _, camera := range node.Cameras {
+			if status != "" && camera.Health.Status != status {
+				continue
+			}
+			views = append(views, CameraView{Camera: camera, NodeID: node.ID, NodeName: node.Name, SiteID: node.SiteID})
+		}
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].ID != views[j].ID {
+			return views[i].ID < views[j].ID
+		}
+		return views[i].NodeID < views[j].NodeID
+	})
+	return views
+}
+
+// ListCameras returns cameras across the fleet, filtered by site_id and status
+func (co *CentralOrchestrator) ListCameras(c *gin.Context) {
+	cameras := co.cameraViews(c, c.Query("site_id"), CameraStatus(c.Query("status")))
+	c.JSON(http.StatusOK, gin.H{"cameras": cameras})
+}
+
+// GetNodeCameras returns the cameras a node reported
+func (co *CentralOrchestrator) GetNodeCameras(c *gin.Context) {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	node, exists := co.NodeManager.nodes[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	cameras := node.Cameras
+	if cameras == nil {
+		cameras = []Camera{}
+	}
+	c.JSON(http.StatusOK, gin.H{"cameras": cameras})
+}
+
+// CreateVideoAnalyticsPipeline expands the video analytics template into one workload per
+// camera. Each workload is constrained to nodes that have its camera attached and gets
+// the stream through CAMERA_ID, CAMERA_TYPE and CAMERA_SOURCE.
+func (co *CentralOrchestrator) CreateVideoAnalyticsPipeline(c *gin.Context) {
+	var req VideoAnalyticsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Cameras) == 0 && req.SiteID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cameras or site_id is required"})
+		return
+	}
+
+	// A camera reachable from several nodes is bound once, from the first node reporting it
+	cameras := make(map[string]Camera)
+	for _, view := range co.cameraViews(c, req.SiteID, "") {
+		if _, seen := cameras[view.ID]; !seen {
+			cameras[view.ID] = view.Camera
+		}
+	}
+	selected := req.Cameras
+	if len(selected) == 0 {
+		for id := range cameras {
+			selected = append(selected, id)
+		}
+		sort.Strings(selected)
+	}
+	if len(selected) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No cameras reported at site %s", req.SiteID)})
+		return
+	}
+
+	now := time.Now()
+	workloads := make([]*Workload, 0, len(selected))
+	for _, cameraID := range selected {
+		camera, exists := cameras[cameraID]
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Camera %s is not reported by any node", cameraID)})
+			return
+		}
+
+		environment := map[string]string{
+			"CAMERA_ID":     camera.ID,
+			"CAMERA_TYPE":   string(camera.Type),
+			"CAMERA_SOURCE": camera.source(),
+		}
+		for key, value := range req.Environment {
+			environment[key] = value
+		}
+		labels := map[string]string{"pipeline": req.Name}
+		for key, value := range req.Labels {
+			labels[key] = value
+		}
+		labels[CameraLabel] = nameSlug(camera.ID)
+
+		workload, err := newWorkload(WorkloadDeploymentRequest{
+			Name:        req.Name + "-" + nameSlug(camera.ID),
+			Namespace:   req.Namespace,
+			Tenant:      req.Tenant,
+			Metadata:    req.Metadata,
+			Type:        WorkloadTypeDeployment,
+			Image:       req.Image,
+			Replicas:    1,
+			Resources:   req.Resources,
+			Environment: environment,
+			Labels:      labels,
+			Placement: PlacementPolicy{
+				Constraints: []PlacementConstraint{{Key: "camera", Operator: "In", Values: []string{camera.ID}}},
+			},
+			Ports:       req.Ports,
+			Criticality: req.Criticality,
+			QoSClass:    req.QoSClass,
+		}, now)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		workload.Camera = &CameraBinding{CameraID: camera.ID, Type: camera.Type, Source: camera.source()}
+		if status, err := co.admitNewWorkload(c, workload); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		workloads = append(workloads, workload)
+	}
+
+	if err := co.storeNewWorkloads(workloads...); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.Logger.Infof("Video analytics pipeline %s created for %d cameras", req.Name, len(workloads))
+	c.JSON(http.StatusCreated, gin.H{"workloads": workloads})
+}
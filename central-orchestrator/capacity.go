@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ResourceAmounts is a CPU/memory amount in machine-comparable units
+type ResourceAmounts struct {
+	MilliCPU    int64 `json:"milli_cpu"`
+	MemoryBytes int64 `json:"memory_bytes"`
+}
+
+// Add returns the sum of two amounts
+func (r ResourceAmounts) Add(other ResourceAmounts) ResourceAmounts {
+	return ResourceAmounts{
+		MilliCPU:    r.MilliCPU + other.MilliCPU,
+		MemoryBytes: r.MemoryBytes + other.MemoryBytes,
+	}
+}
+
+// Scale returns the amount multiplied by n
+func (r ResourceAmounts) Scale(n int32) ResourceAmounts {
+	return ResourceAmounts{
+		MilliCPU:    r.MilliCPU * int64(n),
+		MemoryBytes: r.MemoryBytes * int64(n),
+	}
+}
+
+// unitSuffixes maps the human-readable units reported by agents to Kubernetes quantity suffixes
+var unitSuffixes = map[string]string{
+	"KB": "Ki",
+	"MB": "Mi",
+	"GB": "Gi",
+	"TB": "Ti",
+}
+
+// parseQuantity parses Kubernetes quantities ("500m", "2Gi") as well as agent-reported
+// strings such as "846 MB"; ok is false for empty or non-numeric values like "100%"
+func parseQuantity(value string) (resource.Quantity, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return resource.Quantity{}, false
+	}
+
+	if fields := strings.Fields(value); len(fields) == 2 {
+		if suffix, known := unitSuffixes[strings.ToUpper(fields[1])]; known {
+			value = fields[0] + suffix
+		}
+	}
+
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+	return q, true
+}
+
+// parseWorkloadQuantity parses a quantity of a workload spec, which unlike agent reports
+// is passed on to Kubernetes as is; empty values are zero
+func parseWorkloadQuantity(value string) (resource.Quantity, error) {
+	if value == "" {
+		return resource.Quantity{}, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	if q.Sign() < 0 {
+		return resource.Quantity{}, fmt.Errorf("%s is negative", value)
+	}
+	return q, nil
+}
+
+// workloadRequests returns the per-replica resource requests of a workload
+func workloadRequests(workload *Workload) ResourceAmounts {
+	var amounts ResourceAmounts
+	if q, ok := parseQuantity(workload.Resources.Requests.CPU); ok {
+		amounts.MilliCPU = q.MilliValue()
+	}
+	if q, ok := parseQuantity(workload.Resources.Requests.Memory); ok {
+		amounts.MemoryBytes = q.Value()
+	}
+	return amounts
+}
+
+// normalized fills in the numeric fields of resources reported only as strings, such as
+// "846 MB" from older agents. Usage reported only as a percentage is converted against
+// the capacity.
+func (r NodeResources) normalized() NodeResources {
+	r.CPU.CapacityMillicores, r.CPU.UsageMillicores = normalizeAmounts(
+		r.CPU.CapacityMillicores, r.CPU.UsageMillicores, r.CPU.Capacity, r.CPU.Usage, r.CPU.Percentage, (*resource.Quantity).MilliValue)
+	r.Memory.CapacityBytes, r.Memory.UsageBytes = normalizeAmounts(
+		r.Memory.CapacityBytes, r.Memory.UsageBytes, r.Memory.Capacity, r.Memory.Usage, r.Memory.Percentage, (*resource.Quantity).Value)
+	r.Storage.CapacityBytes, r.Storage.UsageBytes = normalizeAmounts(
+		r.Storage.CapacityBytes, r.Storage.UsageBytes, r.Storage.Capacity, r.Storage.Usage, r.Storage.Percentage, (*resource.Quantity).Value)
+	return r
+}
+
+// normalizeAmounts returns the numeric capacity and usage of one resource, parsing the
+// strings for the ones not reported
+func normalizeAmounts(capacity, usage int64, capacityText, usageText string, percentage float64, value func(*resource.Quantity) int64) (int64, int64) {
+	if capacity == 0 {
+		if q, ok := parseQuantity(capacityText); ok {
+			capacity = value(&q)
+		}
+	}
+	if usage == 0 {
+		if q, ok := parseQuantity(usageText); ok {
+			usage = value(&q)
+		} else if capacity > 0 {
+			usage = int64(percentage / 100 * float64(capacity))
+		}
+	}
+	return capacity, usage
+}
+
+// nodeCapacity returns the node's capacity; a zero field means the capacity is unknown
+func nodeCapacity(node *EdgeNode) ResourceAmounts {
+	resources := node.Resources.normalized()
+	return ResourceAmounts{
+		MilliCPU:    resources.CPU.CapacityMillicores,
+		MemoryBytes: resources.Memory.CapacityBytes,
+	}
+}
+
+// committedResources sums the requests of placed deployments on each node
+func committedResources(workloads map[string]*Workload) map[string]Commitment {
+	committed := make(map[string]Commitment)
+	for _, workload := range workloads {
+		for _, deployment := range workload.Deployments {
+			if !deployment.placed() {
+				continue
+			}
+			committed[deployment.NodeID] = committed[deployment.NodeID].with(workload, deployment.Replicas)
+		}
+	}
+	return committed
+}
+
+// placedReplicas counts the replicas of placed deployments on each node, leaving out the
+// workload being scheduled
+func placedReplicas(workloads map[string]*Workload, excludeID string) map[string]int32 {
+	replicas := make(map[string]int32)
+	for _, workload := range workloads {
+		if workload.ID == excludeID {
+			continue
+		}
+		for _, deployment := range workload.Deployments {
+			if deployment.placed() {
+				replicas[deployment.NodeID] += deployment.Replicas
+			}
+		}
+	}
+	return replicas
+}
+
+// nodeUtilization is the mean of the node's reported CPU and memory utilization, in percent
+func nodeUtilization(node *EdgeNode) float64 {
+	return (node.Resources.CPU.Percentage + node.Resources.Memory.Percentage) / 2
+}
+
+// fits reports whether additional requests fit in the node's remaining capacity.
+// Dimensions with unknown capacity are not enforced.
+func fits(capacity, committed, additional ResourceAmounts) bool {
+	return exceeded(capacity, committed, additional) == ""
+}
+
+// exceeded returns the resource, "cpu" or "memory", whose capacity the additional amount
+// would exceed, or ""
+func exceeded(capacity, committed, additional ResourceAmounts) string {
+	if capacity.MilliCPU > 0 && committed.MilliCPU+additional.MilliCPU > capacity.MilliCPU {
+		return "cpu"
+	}
+	if capacity.MemoryBytes > 0 && committed.MemoryBytes+additional.MemoryBytes > capacity.MemoryBytes {
+		return "memory"
+	}
+	return ""
+}
+
+// nodeUsage returns what the node reports using; a zero field means the usage is unknown
+func nodeUsage(node *EdgeNode) ResourceAmounts {
+	resources := node.Resources.normalized()
+	return ResourceAmounts{
+		MilliCPU:    resources.CPU.UsageMillicores,
+		MemoryBytes: resources.Memory.UsageBytes,
+	}
+}
+
+// headroomScore rates the room a node has left once a replica with the given requests is
+// placed on it, from 0 (full) to 1 (idle). Each of CPU and memory loses the larger of what
+// scheduled workloads request and what the node reports using, since usage already
+// includes the workloads running there; the score is the scarcer of the two. A dimension
+// with unknown capacity falls back to the node's reported idle percentage, and a node
+// reporting neither scores 0.
+func (co *CentralOrchestrator) headroomScore(node *EdgeNode, committed Commitment, request ResourceAmounts) float64 {
+	allocatable := co.allocatableCapacity(node)
+	used := nodeUsage(node)
+
+	dimensions := []struct {
+		capacity, committed, used, request int64
+		percentage                         float64
+	}{
+		{allocatable.MilliCPU, committed.Total.MilliCPU, used.MilliCPU, request.MilliCPU, node.Resources.CPU.Percentage},
+		{allocatable.MemoryBytes, committed.Total.MemoryBytes, used.MemoryBytes, request.MemoryBytes, node.Resources.Memory.Percentage},
+	}
+
+	score, known := 1.0, false
+	for _, d := range dimensions {
+		var free float64
+		switch {
+		case d.capacity > 0:
+			taken := d.committed
+			if d.used > taken {
+				taken = d.used
+			}
+			free = float64(d.capacity-taken-d.request) / float64(d.capacity)
+		case d.percentage > 0:
+			free = 1 - d.percentage/100
+		default:
+			continue
+		}
+		known = true
+		if free < score {
+			score = free
+		}
+	}
+	if !known || score < 0 {
+		return 0
+	}
+	return score
+}
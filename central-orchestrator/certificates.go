@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Windows reported by the certificate expiry summary
+var certificateExpiryWindows = []struct {
+	name   string
+	within time.Duration
+}{
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"90d", 90 * 24 * time.Hour},
+}
+
+// CertificateStatus is the lifecycle state of an issued certificate
+type CertificateStatus string
+
+const (
+	CertificateStatusActive  CertificateStatus = "active"
+	CertificateStatusRevoked CertificateStatus = "revoked"
+	CertificateStatusExpired CertificateStatus = "expired"
+)
+
+// CertificateInfo is the inventory view of an issued certificate; it never carries the
+// private key
+type CertificateInfo struct {
+	ID           string            `json:"id"`
+	NodeID       string            `json:"node_id"`
+	NodeName     string            `json:"node_name,omitempty"`
+	CommonName   string            `json:"common_name"`
+	DNSNames     []string          `json:"dns_names,omitempty"`
+	Fingerprint  string            `json:"fingerprint"`
+	Issuer       string            `json:"issuer,omitempty"`
+	Signer       string            `json:"signer,omitempty"`
+	Status       CertificateStatus `json:"status"`
+	IssuedAt     time.Time         `json:"issued_at"`
+	ExpiresAt    time.Time         `json:"expires_at"`
+	RevokedAt    *time.Time        `json:"revoked_at,omitempty"`
+	DaysToExpiry int               `json:"days_to_expiry"`
+}
+
+// CertificateExpirySummary aggregates the inventory for expiry dashboards
+type CertificateExpirySummary struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+	// Active certificates expiring within each window
+	ExpiringWithin map[string]int   `json:"expiring_within"`
+	NextExpiry     *CertificateInfo `json:"next_expiry,omitempty"`
+}
+
+// status reports a certificate's lifecycle state at the given time
+func (cert *Certificate) status(now time.Time) CertificateStatus {
+	switch {
+	case cert.RevokedAt != nil:
+		return CertificateStatusRevoked
+	case now.After(cert.ExpiresAt):
+		return CertificateStatusExpired
+	default:
+		return CertificateStatusActive
+	}
+}
+
+// info builds the inventory view of a certificate record
+func (cert *Certificate) info(now time.Time) CertificateInfo {
+	info := CertificateInfo{
+		ID:           cert.ID,
+		NodeID:       cert.NodeID,
+		Signer:       cert.Signer,
+		Status:       cert.status(now),
+		IssuedAt:     cert.IssuedAt,
+		ExpiresAt:    cert.ExpiresAt,
+		RevokedAt:    cert.RevokedAt,
+		DaysToExpiry: int(cert.ExpiresAt.Sub(now).Hours() / 24),
+	}
+
+	if block, _ := pem.Decode(cert.Certificate); block != nil {
+		info.Fingerprint = certificateFingerprint(block.Bytes)
+		if parsed, err := x509.ParseCertificate(block.Bytes); err == nil {
+			info.CommonName = parsed.Subject.CommonName
+			info.DNSNames = parsed.DNSNames
+			info.Issuer = parsed.Issuer.CommonName
+		}
+	}
+	return info
+}
+
+// certificateInventory returns the inventory view of every issued certificate, soonest
+// expiry first
+func (co *CentralOrchestrator) certificateInventory(now time.Time) []CertificateInfo {
+	co.SecurityManager.mutex.RLock()
+	inventory := make([]CertificateInfo, 0, len(co.SecurityManager.certificates))
+	for _, cert := range co.SecurityManager.certificates {
+		inventory = append(inventory, cert.info(now))
+	}
+	co.SecurityManager.mutex.RUnlock()
+
+	co.NodeManager.mutex.RLock()
+	for i := range inventory {
+		if node, exists := co.NodeManager.nodes[inventory[i].NodeID]; exists {
+			inventory[i].NodeName = node.Name
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	sort.Slice(inventory, func(i, j int) bool {
+		return inventory[i].ExpiresAt.Before(inventory[j].ExpiresAt)
+	})
+	return inventory
+}
+
+// summarizeCertificates aggregates an inventory by status and expiry window
+func summarizeCertificates(inventory []CertificateInfo, now time.Time) CertificateExpirySummary {
+	summary := CertificateExpirySummary{
+		Total:          len(inventory),
+		ByStatus:       make(map[string]int),
+		ExpiringWithin: make(map[string]int),
+	}
+	for _, window := range certificateExpiryWindows {
+		summary.ExpiringWithin[window.name] = 0
+	}
+
+	for i, cert := range inventory {
+		summary.ByStatus[string(cert.Status)]++
+		if cert.Status != CertificateStatusActive {
+			continue
+		}
+		for _, window := range certificateExpiryWindows {
+			if cert.ExpiresAt.Sub(now) <= window.within {
+				summary.ExpiringWithin[window.name]++
+			}
+		}
+		// The inventory is sorted by expiry, so the first active certificate expires next
+		if summary.NextExpiry == nil {
+			summary.NextExpiry = &inventory[i]
+		}
+	}
+	return summary
+}
+
+// ListCertificates returns the certificate inventory, optionally filtered by node_id,
+// status (active, revoked or expired) and expires_within (a duration such as "720h")
+func (co *CentralOrchestrator) ListCertificates(c *gin.Context) {
+	now := time.Now()
+
+	var within time.Duration
+	if value := c.Query("expires_within"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_within must be a positive duration such as 720h"})
+			return
+		}
+		within = parsed
+	}
+
+	status := c.Query("status")
+	switch CertificateStatus(status) {
+	case "", CertificateStatusActive, CertificateStatusRevoked, CertificateStatusExpired:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, revoked or expired"})
+		return
+	}
+
+	certificates := make([]CertificateInfo, 0)
+	for _, cert := range co.certificateInventory(now) {
+		if nodeID := c.Query("node_id"); nodeID != "" && cert.NodeID != nodeID {
+			continue
+		}
+		if status != "" && string(cert.Status) != status {
+			continue
+		}
+		if within > 0 && cert.ExpiresAt.Sub(now) > within {
+			continue
+		}
+		certificates = append(certificates, cert)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"certificates": certificates,
+		"summary":      summarizeCertificates(certificates, now),
+	})
+}
+
+// GetCertificate returns one certificate's inventory record
+func (co *CentralOrchestrator) GetCertificate(c *gin.Context) {
+	co.SecurityManager.mutex.RLock()
+	cert, exists := co.SecurityManager.certificates[c.Param("id")]
+	var info CertificateInfo
+	if exists {
+		info = cert.info(time.Now())
+	}
+	co.SecurityManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	if node, exists := co.NodeManager.nodes[info.NodeID]; exists {
+		info.NodeName = node.Name
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"certificate": info})
+}
+
+// GetCertificateSummary returns fleet-wide certificate expiry metrics
+func (co *CentralOrchestrator) GetCertificateSummary(c *gin.Context) {
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{"summary": summarizeCertificates(co.certificateInventory(now), now)})
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Chat platforms chatops commands arrive from
+	ChatPlatformSlack = "slack"
+	ChatPlatformTeams = "teams"
+
+	// Slack requests signed longer ago than this are rejected as replays
+	SlackRequestMaxAge = 5 * time.Minute
+
+	// Largest chat request accepted
+	MaxChatRequestBody = 64 << 10
+
+	// Alerts and pending changes listed per reply
+	MaxChatListItems = 10
+)
+
+// teamsMarkup matches the mention of the outgoing webhook and other tags Teams wraps
+// around a message's text
+var teamsMarkup = regexp.MustCompile(`<at>[^<]*</at>|<[^>]+>`)
+
+const chatHelp = "Commands:\n" +
+	"`status`: fleet status\n" +
+	"`alerts`: firing alerts\n" +
+	"`ack <alert-id>`: acknowledge an alert\n" +
+	"`pending`: blue-green deployments waiting for their traffic switch\n" +
+	"`approve <workload>`: switch a pending blue-green deployment's traffic\n" +
+	"`scale <workload> <replicas>`: scale a workload\n" +
+	"Workloads are named by ID, name or namespace/name."
+
+// ChatOps answers Slack slash commands and Teams outgoing webhooks, so NOC teams can run
+// common fleet operations from the chat they handle incidents in
+type ChatOps struct {
+	slackSigningSecret string
+	teamsSecret        []byte
+	logger             *logrus.Logger
+}
+
+// NewChatOps creates the chatops bridge. SLACK_SIGNING_SECRET enables Slack and
+// TEAMS_WEBHOOK_SECRET, the base64 security token of a Teams outgoing webhook, enables Teams.
+func NewChatOps(logger *logrus.Logger) *ChatOps {
+	ch := &ChatOps{
+		slackSigningSecret: os.Getenv("SLACK_SIGNING_SECRET"),
+		logger:             logger,
+	}
+	if secret := os.Getenv("TEAMS_WEBHOOK_SECRET"); secret != "" {
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			logger.Errorf("Invalid TEAMS_WEBHOOK_SECRET, Teams chatops disabled: %v", err)
+		} else {
+			ch.teamsSecret = key
+		}
+	}
+	return ch
+}
+
+// SlackEnabled reports whether Slack slash commands are answered
+func (ch *ChatOps) SlackEnabled() bool {
+	return ch.slackSigningSecret != ""
+}
+
+// TeamsEnabled reports whether Teams outgoing webhooks are answered
+func (ch *ChatOps) TeamsEnabled() bool {
+	return len(ch.teamsSecret) > 0
+}
+
+// verifySlack checks a request's Slack signature, an HMAC of its timestamp and body
+func (ch *ChatOps) verifySlack(c *gin.Context, body []byte, now time.Time) bool {
+	timestamp := c.GetHeader("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > SlackRequestMaxAge || age < -SlackRequestMaxAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(ch.slackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(c.GetHeader("X-Slack-Signature")), []byte(expected))
+}
+
+// verifyTeams checks a request's Teams signature, an HMAC of its body
+func (ch *ChatOps) verifyTeams(c *gin.Context, body []byte) bool {
+	mac := hmac.New(sha256.New, ch.teamsSecret)
+	mac.Write(body)
+	expected := "HMAC " + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(c.GetHeader("Authorization")), []byte(expected))
+}
+
+// chatReply is the answer to a chat command. Replies to changes are posted to the channel
+// so the rest of the team sees them; others only to the caller where the platform allows.
+type chatReply struct {
+	text   string
+	public bool
+}
+
+// chatError is a reply refusing or failing a command
+func chatError(format string, args ...interface{}) chatReply {
+	return chatReply{text: fmt.Sprintf(format, args...)}
+}
+
+// ReceiveSlackCommand answers a Slack slash command
+func (co *CentralOrchestrator) ReceiveSlackCommand(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxChatRequestBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !co.ChatOps.verifySlack(c, body, time.Now()) {
+		co.Logger.Warnf("Rejected Slack command from %s", c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Slack signature"})
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reply := co.runChatCommand(c, ChatPlatformSlack, form.Get("user_id"), form.Get("text"))
+	responseType := "ephemeral"
+	if reply.public {
+		responseType = "in_channel"
+	}
+	c.JSON(http.StatusOK, gin.H{"response_type": responseType, "text": reply.text})
+}
+
+// teamsActivity is the part of a Teams outgoing webhook message the commands need
+type teamsActivity struct {
+	Text string `json:"text"`
+	From struct {
+		AADObjectID string `json:"aadObjectId"`
+	} `json:"from"`
+}
+
+// ReceiveTeamsCommand answers a message mentioning a Teams outgoing webhook
+func (co *CentralOrchestrator) ReceiveTeamsCommand(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxChatRequestBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !co.ChatOps.verifyTeams(c, body) {
+		co.Logger.Warnf("Rejected Teams command from %s", c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Teams signature"})
+		return
+	}
+	var activity teamsActivity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	text := html.UnescapeString(teamsMarkup.ReplaceAllString(activity.Text, " "))
+	// Teams replies in the channel the message was posted to
+	reply := co.runChatCommand(c, ChatPlatformTeams, activity.From.AADObjectID, text)
+	c.JSON(http.StatusOK, gin.H{"type": "message", "text": reply.text})
+}
+
+// runChatCommand runs a command as the access policy user the chat account is mapped to,
+// with that user's role and tenants, and records changes in the audit log under that user
+func (co *CentralOrchestrator) runChatCommand(c *gin.Context, platform, chatUserID, text string) chatReply {
+	policy := co.SecurityManager.policy
+	if policy == nil {
+		return chatError("Chatops requires an access policy to map chat users to roles")
+	}
+	chatUser, mapped := policy.lookupChatUser(platform, chatUserID)
+	if !mapped {
+		return chatError("Your %s account %s is not mapped to a user; add it to chat_users in the access policy", platform, chatUserID)
+	}
+	role, tenants, bound := policy.resolveBindings(chatUser.User, chatUser.Groups)
+	if !bound {
+		return chatError("%s has no role bindings", chatUser.User)
+	}
+	c.Set("user", chatUser.User)
+	c.Set("role", role)
+	c.Set(ContextKeyAuthMethod, "chatops:"+platform)
+	c.Set(ContextKeyGroups, chatUser.Groups)
+	if tenants != nil {
+		c.Set(ContextKeyTenants, tenants)
+		co.expandTenantScope(c)
+	}
+
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return chatReply{text: chatHelp}
+	}
+	co.Logger.Infof("Chatops command %q from %s on %s", text, chatUser.User, platform)
+
+	switch command, args := strings.ToLower(args[0]), args[1:]; {
+	case command == "status" && len(args) == 0:
+		return co.chatStatus(c)
+	case command == "alerts" && len(args) == 0:
+		return co.chatAlerts(c)
+	case command == "ack" && len(args) == 1:
+		return co.chatAcknowledge(c, args[0])
+	case command == "pending" && len(args) == 0:
+		return co.chatPending(c)
+	case command == "approve" && len(args) == 1:
+		return co.chatApprove(c, args[0])
+	case command == "scale" && len(args) == 2:
+		return co.chatScale(c, args[0], args[1])
+	default:
+		return chatReply{text: chatHelp}
+	}
+}
+
+// chatAllowed reports whether the caller's role may make the API request a chat command
+// stands for, and returns the refusal otherwise
+func chatAllowed(c *gin.Context, method, path string) (chatReply, bool) {
+	role := c.GetString("role")
+	if !roleAllows(role, method, path) {
+		return chatError("Role %s may not %s %s", role, method, path), false
+	}
+	return chatReply{}, true
+}
+
+// chatStatus summarizes the fleet
+func (co *CentralOrchestrator) chatStatus(c *gin.Context) chatReply {
+	if refusal, allowed := chatAllowed(c, http.MethodGet, "/api/v1/summary"); !allowed {
+		return refusal
+	}
+	summary := co.buildSummary(nil)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Nodes: %s\n", chatCounts(summary.Nodes))
+	fmt.Fprintf(&text, "Workloads: %s\n", chatCounts(summary.Workloads))
+	fmt.Fprintf(&text, "Firing alerts: %d", summary.ActiveAlerts)
+	if summary.ActiveAlerts > 0 {
+		fmt.Fprintf(&text, " (%s)", chatCounts(summary.AlertsBySeverity))
+	}
+	fmt.Fprintf(&text, "\nMigrations in progress: %d", summary.RolloutsInProgress)
+	for _, workload := range summary.FailingWorkloads {
+		fmt.Fprintf(&text, "\nFailing: %s (%s, %d firing alerts)", workload.Name, workload.Status, workload.FiringAlerts)
+		if workload.Metadata.OwnerTeam != "" {
+			fmt.Fprintf(&text, ", owned by %s", workload.Metadata.OwnerTeam)
+		}
+	}
+	return chatReply{text: text.String()}
+}
+
+// chatCounts formats counts by status as "online 12, offline 1"
+func chatCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s %d", key, counts[key]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// chatAlerts lists the firing alerts that are not silenced, newest first
+func (co *CentralOrchestrator) chatAlerts(c *gin.Context) chatReply {
+	if refusal, allowed := chatAllowed(c, http.MethodGet, "/api/v1/alerts"); !allowed {
+		return refusal
+	}
+	alerts := co.unsilencedAlerts(co.AlertManager.List(AlertFilter{Status: string(AlertStatusFiring)}))
+	if len(alerts) == 0 {
+		return chatReply{text: "No firing alerts"}
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d firing alerts", len(alerts))
+	for i, alert := range co.localizeAlerts(alerts, DefaultLocale) {
+		if i == MaxChatListItems {
+			fmt.Fprintf(&text, "\n… and %d more", len(alerts)-i)
+			break
+		}
+		fmt.Fprintf(&text, "\n`%s` %s %s on %s %s: %s", alert.ID, alert.Severity, alert.Name, alert.Scope, alert.ScopeID, alert.Message)
+		if alert.AcknowledgedBy != "" {
+			fmt.Fprintf(&text, " (acknowledged by %s)", alert.AcknowledgedBy)
+		}
+	}
+	return chatReply{text: text.String()}
+}
+
+// chatAcknowledge acknowledges a firing alert
+func (co *CentralOrchestrator) chatAcknowledge(c *gin.Context, alertID string) chatReply {
+	if refusal, allowed := chatAllowed(c, http.MethodPost, "/api/v1/alerts/"+alertID+"/acknowledge"); !allowed {
+		return refusal
+	}
+	alert, exists := co.AlertManager.Acknowledge(alertID, c.GetString("user"), time.Now())
+	if !exists {
+		return chatError("Alert %s not found", alertID)
+	}
+	if alert.Status != AlertStatusFiring {
+		return chatError("Alert %s is no longer firing", alertID)
+	}
+
+	co.AuditLog.RecordRequest(c, "alert.acknowledge", "alert:"+alert.ID, map[string]string{"name": alert.Name})
+	return chatReply{text: fmt.Sprintf("%s acknowledged %s on %s %s", alert.AcknowledgedBy, alert.Name, alert.Scope, alert.ScopeID), public: true}
+}
+
+// chatWorkload finds the workload a chat command names by ID, name or namespace/name among
+// those the caller may act on. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) chatWorkload(c *gin.Context, ref string) (*Workload, string) {
+	if workload, exists := co.WorkloadManager.workloads[ref]; exists && tenantAllowed(c, workload.Tenant) {
+		return workload, ""
+	}
+	namespace, name, qualified := strings.Cut(ref, "/")
+	if !qualified {
+		namespace, name = "", ref
+	}
+	var matches []*Workload
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.Name == name && (!qualified || workload.Namespace == namespace) && tenantAllowed(c, workload.Tenant) {
+			matches = append(matches, workload)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Sprintf("Workload %s not found", ref)
+	case 1:
+		return matches[0], ""
+	default:
+		return nil, fmt.Sprintf("%d workloads are named %s; name it by namespace/name or ID", len(matches), ref)
+	}
+}
+
+// chatPending lists the blue-green deployments whose new version is ready and waits for
+// its traffic switch
+func (co *CentralOrchestrator) chatPending(c *gin.Context) chatReply {
+	if refusal, allowed := chatAllowed(c, http.MethodGet, "/api/v1/workloads"); !allowed {
+		return refusal
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	var pending []*Workload
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.BlueGreen != nil && workload.BlueGreen.Phase == BlueGreenPhaseReady && tenantAllowed(c, workload.Tenant) {
+			pending = append(pending, workload)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].BlueGreen.StartedAt.Before(pending[j].BlueGreen.StartedAt) })
+
+	var text strings.Builder
+	for i, workload := range pending {
+		if i == MaxChatListItems {
+			fmt.Fprintf(&text, "\n… and %d more", len(pending)-i)
+			break
+		}
+		fmt.Fprintf(&text, "\n`%s` %s/%s: %s ready since %s", workload.ID, workload.Namespace, workload.Name,
+			workload.BlueGreen.Spec.Image, workload.BlueGreen.ReadyAt.UTC().Format(time.RFC3339))
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	if len(pending) == 0 {
+		return chatReply{text: "No changes waiting for approval"}
+	}
+	return chatReply{text: fmt.Sprintf("%d changes waiting for approval", len(pending)) + text.String()}
+}
+
+// chatApprove switches the traffic of a blue-green deployment waiting for it
+func (co *CentralOrchestrator) chatApprove(c *gin.Context, ref string) chatReply {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, problem := co.chatWorkload(c, ref)
+	if workload == nil {
+		return chatReply{text: problem}
+	}
+	if refusal, allowed := chatAllowed(c, http.MethodPost, "/api/v1/workloads/"+workload.ID+"/blue-green/switch"); !allowed {
+		return refusal
+	}
+	if workload.BlueGreen == nil {
+		return chatError("Workload %s has no blue-green deployment", workload.Name)
+	}
+	if workload.BlueGreen.Phase != BlueGreenPhaseReady {
+		return chatError("Blue-green deployment of %s is %s, not ready", workload.Name, workload.BlueGreen.Phase)
+	}
+
+	co.switchBlueGreenTraffic(workload, time.Now())
+	co.AuditLog.RecordRequest(c, "workload.blue_green.switch", "workload:"+workload.ID, nil)
+	return chatReply{text: fmt.Sprintf("%s switched %s to %s", c.GetString("user"), workload.Name, workload.BlueGreen.Spec.Image), public: true}
+}
+
+// chatScale sets a workload's replica count
+func (co *CentralOrchestrator) chatScale(c *gin.Context, ref, count string) chatReply {
+	replicas, err := strconv.ParseInt(count, 10, 32)
+	if err != nil || replicas < 1 || replicas > MaxWorkloadReplicas {
+		return chatError("Replicas must be a number from 1 to %d, not %s", MaxWorkloadReplicas, count)
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, problem := co.chatWorkload(c, ref)
+	if workload == nil {
+		return chatReply{text: problem}
+	}
+	if refusal, allowed := chatAllowed(c, http.MethodPost, "/api/v1/workloads/"+workload.ID+"/scale"); !allowed {
+		return refusal
+	}
+	if err := co.checkNamespaceScale(workload, int32(replicas)); err != nil {
+		return chatError("Cannot scale %s: %v", workload.Name, err)
+	}
+
+	oldReplicas := workload.Replicas
+	workload.Replicas = int32(replicas)
+	workload.Status = WorkloadStatusPending // Trigger rescheduling
+	workload.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Workload %s scaled from %d to %d replicas", workload.ID, oldReplicas, replicas)
+	co.AuditLog.RecordRequest(c, "workload.scale", "workload:"+workload.ID,
+		map[string]string{"from": fmt.Sprint(oldReplicas), "to": count})
+	return chatReply{text: fmt.Sprintf("%s scaled %s from %d to %d replicas", c.GetString("user"), workload.Name, oldReplicas, replicas), public: true}
+}
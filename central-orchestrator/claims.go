@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long an unclaimed device stays listed after it last checked in
+	DefaultClaimTTL = 24 * time.Hour
+
+	// How long a claimed device has to pick up its join token
+	ClaimJoinTokenTTL = 24 * time.Hour
+
+	// Label carrying the tenant a claimed device was bound to
+	NodeTenantLabel = "edge.io/tenant"
+)
+
+// Claim codes are two groups of four characters that cannot be mistaken for each
+// other when read off a screen, such as K7QF-M2XP
+var claimCodePattern = regexp.MustCompile(`^[A-HJ-NP-Z2-9]{4}-[A-HJ-NP-Z2-9]{4}$`)
+
+// DeviceClaimStatus is where a device is in the claim flow
+type DeviceClaimStatus string
+
+const (
+	DeviceClaimUnclaimed DeviceClaimStatus = "unclaimed"
+	DeviceClaimClaimed   DeviceClaimStatus = "claimed"
+	DeviceClaimRejected  DeviceClaimStatus = "rejected"
+)
+
+// DeviceAnnouncement is what an unclaimed agent reports while waiting to be claimed
+type DeviceAnnouncement struct {
+	Code         string   `json:"code" binding:"required"`
+	Name         string   `json:"name"`
+	Hostname     string   `json:"hostname"`
+	Address      string   `json:"address"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// ClaimDeviceRequest binds an unclaimed device to a site, tenant and labels
+type ClaimDeviceRequest struct {
+	SiteID string            `json:"site_id"`
+	Tenant string            `json:"tenant"`
+	Labels map[string]string `json:"labels"`
+	// Name the device registers under; defaults to the name it announced
+	NodeName string `json:"node_name"`
+	// Node whose identity the device takes over, when it replaces failed hardware
+	ReplacesNodeID string `json:"replaces_node_id"`
+}
+
+// DeviceClaim is a device waiting to be claimed, or claimed and not yet registered
+type DeviceClaim struct {
+	DeviceAnnouncement
+	Status    DeviceClaimStatus `json:"status"`
+	SiteID    string            `json:"site_id,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	NodeName  string            `json:"node_name,omitempty"`
+	ClaimedBy string            `json:"claimed_by,omitempty"`
+	// Node the device replaces, if it was claimed as a replacement
+	ReplacesNodeID string `json:"replaces_node_id,omitempty"`
+	// Join token issued to the device when it was claimed
+	JoinTokenID string     `json:"join_token_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+	ClaimedAt   *time.Time `json:"claimed_at,omitempty"`
+	// Proves later polls come from the device that announced the code
+	secretHash string
+	// Handed to the device on its next poll
+	joinSecret string
+}
+
+// ClaimBinding is what a claimed device needs to register
+type ClaimBinding struct {
+	NodeName  string            `json:"node_name"`
+	AuthToken string            `json:"auth_token"`
+	SiteID    string            `json:"site_id,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// ClaimManager tracks devices in the claim flow
+type ClaimManager struct {
+	claims map[string]*DeviceClaim
+	ttl    time.Duration
+	mutex  sync.RWMutex
+	logger *logrus.Logger
+}
+
+// NewClaimManager creates a claim manager; CLAIM_TTL sets how long unclaimed devices
+// that stop checking in stay listed
+func NewClaimManager(logger *logrus.Logger) *ClaimManager {
+	cm := &ClaimManager{
+		claims: make(map[string]*DeviceClaim),
+		ttl:    DefaultClaimTTL,
+		logger: logger,
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("CLAIM_TTL")); err == nil && ttl > 0 {
+		cm.ttl = ttl
+	}
+	return cm
+}
+
+// prune drops devices that stopped checking in; callers must hold the lock
+func (cm *ClaimManager) prune(now time.Time) {
+	for code, claim := range cm.claims {
+		if now.Sub(claim.LastSeenAt) > cm.ttl {
+			delete(cm.claims, code)
+		}
+	}
+}
+
+// normalizeClaimCode accepts codes as typed by operators, in any case and with or
+// without the dash
+func normalizeClaimCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+// deviceSecret is the secret an unclaimed device authenticates its polls with
+func deviceSecret(c *gin.Context) string {
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// AnnounceDevice records an unclaimed device and its claim code. Devices re-announce
+// while they wait; a code already held by another device is refused so the device
+// picks a new one.
+func (co *CentralOrchestrator) AnnounceDevice(c *gin.Context) {
+	var req DeviceAnnouncement
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Code = normalizeClaimCode(req.Code)
+	if !claimCodePattern.MatchString(req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid claim code"})
+		return
+	}
+	secretHash := hashJoinToken(deviceSecret(c))
+
+	now := time.Now()
+	cm := co.ClaimManager
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.prune(now)
+
+	claim, exists := cm.claims[req.Code]
+	if exists && claim.secretHash != secretHash {
+		c.JSON(http.StatusConflict, gin.H{"error": "Claim code is in use by another device"})
+		return
+	}
+	if !exists {
+		claim = &DeviceClaim{Status: DeviceClaimUnclaimed, CreatedAt: now, secretHash: secretHash}
+		cm.claims[req.Code] = claim
+		cm.logger.Infof("Device %s (%s) is waiting to be claimed with code %s", req.Name, req.Address, req.Code)
+	}
+	claim.DeviceAnnouncement = req
+	claim.LastSeenAt = now
+
+	c.JSON(http.StatusOK, gin.H{"status": claim.Status})
+}
+
+// GetClaimStatus tells a device whether it has been claimed, and hands a claimed device
+// the binding it registers with
+func (co *CentralOrchestrator) GetClaimStatus(c *gin.Context) {
+	code := normalizeClaimCode(c.Param("code"))
+
+	cm := co.ClaimManager
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	claim, exists := cm.claims[code]
+	if !exists || claim.secretHash != hashJoinToken(deviceSecret(c)) {
+		// Devices re-announce when their code is unknown, such as after a restart
+		c.JSON(http.StatusNotFound, gin.H{"error": "Claim code not found"})
+		return
+	}
+	claim.LastSeenAt = time.Now()
+
+	response := gin.H{"status": claim.Status}
+	if claim.Status == DeviceClaimClaimed {
+		response["binding"] = ClaimBinding{
+			NodeName:  claim.NodeName,
+			AuthToken: claim.joinSecret,
+			SiteID:    claim.SiteID,
+			Labels:    claim.Labels,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListDeviceClaims lists devices waiting to be claimed and recently claimed ones
+func (co *CentralOrchestrator) ListDeviceClaims(c *gin.Context) {
+	cm := co.ClaimManager
+	cm.mutex.Lock()
+	cm.prune(time.Now())
+	claims := make([]DeviceClaim, 0, len(cm.claims))
+	for _, claim := range cm.claims {
+		if status := c.Query("status"); status != "" && string(claim.Status) != status {
+			continue
+		}
+		// Devices waiting to be claimed belong to no tenant yet, and shared ones to all
+		if claim.Tenant != "" && !tenantAllowed(c, claim.Tenant) {
+			continue
+		}
+		claims = append(claims, *claim)
+	}
+	cm.mutex.Unlock()
+
+	sort.Slice(claims, func(i, j int) bool {
+		return claims[i].CreatedAt.Before(claims[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"claims": claims})
+}
+
+// ClaimDevice binds a device to a site, tenant and labels by its claim code. The
+// device is issued a single-use join token pinned to the binding and registers with
+// it on its next poll. A device claimed as a replacement takes over an existing node.
+func (co *CentralOrchestrator) ClaimDevice(c *gin.Context) {
+	code := normalizeClaimCode(c.Param("code"))
+
+	var req ClaimDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A replacement takes its site and labels from the node it replaces
+	if req.ReplacesNodeID != "" && (req.SiteID != "" || req.Tenant != "" || len(req.Labels) > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A replacement device takes its site, tenant and labels from the node it replaces"})
+		return
+	}
+
+	if req.SiteID != "" {
+		co.SiteManager.mutex.RLock()
+		_, exists := co.SiteManager.sites[req.SiteID]
+		co.SiteManager.mutex.RUnlock()
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+			return
+		}
+	}
+
+	labels := make(map[string]string, len(req.Labels)+1)
+	for key, value := range req.Labels {
+		labels[key] = value
+	}
+	if req.Tenant != "" {
+		labels[NodeTenantLabel] = req.Tenant
+	}
+
+	// A replacement joins the tenant of the node it replaces. Shared nodes count as the
+	// default tenant's.
+	tenant := labels[NodeTenantLabel]
+	if req.ReplacesNodeID != "" {
+		co.NodeManager.mutex.RLock()
+		node, exists := co.NodeManager.nodes[req.ReplacesNodeID]
+		if exists {
+			tenant = nodeTenant(node)
+		}
+		co.NodeManager.mutex.RUnlock()
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+	}
+	if !tenantAllowed(c, tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", tenant)})
+		return
+	}
+
+	cm := co.ClaimManager
+	cm.mutex.Lock()
+	claim, exists := cm.claims[code]
+	if !exists {
+		cm.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "No device is waiting with this claim code"})
+		return
+	}
+	if claim.Status != DeviceClaimUnclaimed {
+		cm.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Device has already been %s", claim.Status)})
+		return
+	}
+
+	now := time.Now()
+	var token *JoinToken
+	var tokenID, secret string
+	if req.ReplacesNodeID != "" {
+		replacement, replacementSecret, err := co.startReplacement(req.ReplacesNodeID, requestActor(c), "claimed device "+code, ClaimJoinTokenTTL)
+		if err != nil {
+			cm.mutex.Unlock()
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+		tokenID, secret = replacement.JoinTokenID, replacementSecret
+		labels = nil
+	} else {
+		token, secret = newJoinToken(req.SiteID, "", "", labels, 1, now.Add(ClaimJoinTokenTTL))
+		tokenID = token.ID
+	}
+	claim.Status = DeviceClaimClaimed
+	claim.SiteID = req.SiteID
+	claim.Tenant = tenant
+	claim.Labels = labels
+	claim.NodeName = req.NodeName
+	if claim.NodeName == "" {
+		claim.NodeName = claim.Name
+	}
+	claim.ClaimedBy = requestActor(c)
+	claim.ClaimedAt = &now
+	claim.ReplacesNodeID = req.ReplacesNodeID
+	claim.JoinTokenID = tokenID
+	claim.joinSecret = secret
+	view := *claim
+	cm.mutex.Unlock()
+
+	// Replacement tokens are registered when the replacement starts
+	if token != nil {
+		co.ImageBuilder.mutex.Lock()
+		co.ImageBuilder.tokens[token.ID] = token
+		co.ImageBuilder.mutex.Unlock()
+	}
+
+	co.AuditLog.RecordRequest(c, "device.claim", "claim:"+code, map[string]string{
+		"node_name": view.NodeName,
+		"site":      view.SiteID,
+		"tenant":    view.Tenant,
+		"replaces":  view.ReplacesNodeID,
+	})
+	co.Logger.Infof("Device %s claimed with code %s for site %q", view.NodeName, code, view.SiteID)
+
+	c.JSON(http.StatusOK, gin.H{"claim": view})
+}
+
+// RejectDevice refuses a device waiting to be claimed. It stops waiting and picks a new
+// code when it next starts.
+func (co *CentralOrchestrator) RejectDevice(c *gin.Context) {
+	code := normalizeClaimCode(c.Param("code"))
+
+	cm := co.ClaimManager
+	cm.mutex.Lock()
+	claim, exists := cm.claims[code]
+	if exists && claim.Status == DeviceClaimUnclaimed {
+		claim.Status = DeviceClaimRejected
+	}
+	cm.mutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No device is waiting with this claim code"})
+		return
+	}
+	if claim.Status != DeviceClaimRejected {
+		c.JSON(http.StatusConflict, gin.H{"error": "Device has already been claimed"})
+		return
+	}
+
+	co.AuditLog.RecordRequest(c, "device.reject", "claim:"+code, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Device rejected"})
+}
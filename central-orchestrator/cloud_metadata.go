@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Labels mirroring a cloud node's instance metadata, so placement constraints can
+	// select on them
+	CloudInstanceTypeLabel = "edge.io/instance-type"
+	CloudLifecycleLabel    = "edge.io/lifecycle"
+
+	// Instance purchase options
+	CloudLifecycleSpot     = "spot"
+	CloudLifecycleOnDemand = "on-demand"
+)
+
+// CloudMetadata is what a cloud node's agent read from its provider's instance metadata
+// service, with the hourly price the orchestrator looked up for it
+type CloudMetadata struct {
+	// "aws", "gcp" or "azure"
+	Provider         string `json:"provider" binding:"required"`
+	InstanceID       string `json:"instance_id"`
+	InstanceType     string `json:"instance_type"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availability_zone"`
+	// "spot" or "on-demand"
+	Lifecycle string `json:"lifecycle"`
+	// From the CLOUD_PRICING_FILE table; zero when the instance type is not listed
+	HourlyPrice float64   `json:"hourly_price"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// CloudPrice is an entry of the pricing table; empty region and lifecycle match any
+type CloudPrice struct {
+	Provider     string  `json:"provider"`
+	InstanceType string  `json:"instance_type"`
+	Region       string  `json:"region"`
+	Lifecycle    string  `json:"lifecycle"`
+	HourlyPrice  float64 `json:"hourly_price"`
+}
+
+// loadCloudPricing reads the pricing table from a JSON file of CloudPrice entries
+func loadCloudPricing(path string) ([]CloudPrice, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prices []CloudPrice
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("invalid pricing table: %v", err)
+	}
+	return prices, nil
+}
+
+// hourlyPrice returns the price of the most specific entry matching the instance, or 0
+func (cp *CloudProvisioner) hourlyPrice(metadata CloudMetadata) float64 {
+	best, bestScore := 0.0, -1
+	for _, price := range cp.pricing {
+		if price.Provider != metadata.Provider || price.InstanceType != metadata.InstanceType {
+			continue
+		}
+		if (price.Region != "" && price.Region != metadata.Region) || (price.Lifecycle != "" && price.Lifecycle != metadata.Lifecycle) {
+			continue
+		}
+		score := 0
+		if price.Region != "" {
+			score++
+		}
+		if price.Lifecycle != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = price.HourlyPrice, score
+		}
+	}
+	return best
+}
+
+// recordMetadata copies a cloud node's instance metadata onto the cloud node created for it
+func (cp *CloudProvisioner) recordMetadata(nodeID string, metadata CloudMetadata) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	for _, cloudNode := range cp.nodes {
+		if cloudNode.NodeID != nodeID {
+			continue
+		}
+		if cloudNode.InstanceID == "" {
+			cloudNode.InstanceID = metadata.InstanceID
+		}
+		cloudNode.InstanceType = metadata.InstanceType
+		cloudNode.Lifecycle = metadata.Lifecycle
+		cloudNode.HourlyPrice = metadata.HourlyPrice
+		return
+	}
+}
+
+// cloudHourlyPrice returns a node's hourly price, or ok false for edge nodes and cloud
+// nodes whose price is unknown
+func cloudHourlyPrice(node *EdgeNode) (float64, bool) {
+	if node.Cloud == nil || node.Cloud.HourlyPrice <= 0 {
+		return 0, false
+	}
+	return node.Cloud.HourlyPrice, true
+}
+
+// ReportNodeCloudMetadata stores the instance metadata a cloud node's agent read, prices
+// the instance and labels the node with its instance type and lifecycle
+func (co *CentralOrchestrator) ReportNodeCloudMetadata(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var metadata CloudMetadata
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if metadata.Lifecycle == "" {
+		metadata.Lifecycle = CloudLifecycleOnDemand
+	}
+	if metadata.Lifecycle != CloudLifecycleSpot && metadata.Lifecycle != CloudLifecycleOnDemand {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lifecycle must be spot or on-demand"})
+		return
+	}
+	if metadata.CollectedAt.IsZero() {
+		metadata.CollectedAt = time.Now()
+	}
+	metadata.HourlyPrice = co.CloudProvisioner.hourlyPrice(metadata)
+
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	node.Cloud = &metadata
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	if metadata.InstanceType != "" {
+		node.Labels[CloudInstanceTypeLabel] = metadata.InstanceType
+	}
+	node.Labels[CloudLifecycleLabel] = metadata.Lifecycle
+	if metadata.AvailabilityZone != "" && (node.Zone == "" || node.Zone == "default") {
+		node.Zone = metadata.AvailabilityZone
+	}
+	node.UpdatedAt = time.Now()
+	co.CloudProvisioner.recordMetadata(nodeID, metadata)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cloud metadata updated", "cloud": metadata})
+}
@@ -0,0 +1,326 @@
+// Command edge-image builds zero-touch provisioning images for new edge devices.
+//
+//	edge-image build --format cloud-init|ignition|raw [--site ID] [--label k=v] [-o FILE]
+//	edge-image list
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+
+	// How often a raw image build is polled until it finishes
+	BuildPollInterval = 5 * time.Second
+)
+
+// client talks to the orchestrator API
+type client struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+type imageBuild struct {
+	ID          string `json:"id"`
+	Format      string `json:"format"`
+	SiteID      string `json:"site_id"`
+	JoinTokenID string `json:"join_token_id"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	SizeBytes   int64  `json:"size_bytes"`
+	SHA256      string `json:"sha256"`
+}
+
+// stringList collects a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	flags := flag.NewFlagSet("edge-image", flag.ExitOnError)
+	orchestrator := flags.String("orchestrator", envOr("EDGECTL_ORCHESTRATOR", os.Getenv("ORCHESTRATOR_URL")), "orchestrator URL")
+	token := flags.String("token", envOr("EDGECTL_TOKEN", os.Getenv("AUTH_TOKEN")), "API bearer token")
+	insecure := flags.Bool("insecure", false, "skip orchestrator certificate verification")
+	flags.Usage = usage
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *orchestrator == "" {
+		fmt.Fprintln(os.Stderr, "edge-image: --orchestrator or EDGECTL_ORCHESTRATOR is required")
+		os.Exit(2)
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(*orchestrator, "/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "edge-image: invalid orchestrator URL: %v\n", err)
+		os.Exit(2)
+	}
+	c := &client{
+		baseURL: baseURL,
+		token:   *token,
+		httpClient: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure}},
+		},
+	}
+
+	switch args[0] {
+	case "build":
+		err = c.build(args[1:])
+	case "list":
+		err = c.list()
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "edge-image: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: edge-image [--orchestrator URL] [--token TOKEN] [--insecure] <command>
+
+Commands:
+  build --format cloud-init|ignition|raw [--site ID] [--region R] [--zone Z] [--label k=v]...
+        [--name-prefix P] [--interface IF] [--address CIDR --gateway IP] [--dns IP]...
+        [--wifi-ssid SSID --wifi-psk PSK] [--ssh-key FILE]... [--base-image PATH]
+        [--max-devices N] [--ttl 168h] [-o FILE]
+      Issue a join token and write a provisioning payload or bootable image for it
+  list
+      List provisioning images`)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (c *client) do(method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL.String()+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := *c.httpClient
+	client.Timeout = DefaultTimeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return nil
+}
+
+// apiError turns an error response into an error, preferring the API's error message
+func apiError(resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return fmt.Errorf("%s (status %d)", body.Error, resp.StatusCode)
+	}
+	return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+}
+
+func (c *client) build(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	format := flags.String("format", "cloud-init", "cloud-init, ignition or raw")
+	site := flags.String("site", "", "site the devices join")
+	region := flags.String("region", "", "region of the devices")
+	zone := flags.String("zone", "", "zone of the devices")
+	prefix := flags.String("name-prefix", "", "node name prefix; devices append their machine ID")
+	iface := flags.String("interface", "", "network interface to configure")
+	address := flags.String("address", "", "static address in CIDR notation; DHCP when unset")
+	gateway := flags.String("gateway", "", "default gateway for a static address")
+	ssid := flags.String("wifi-ssid", "", "WiFi network to join")
+	psk := flags.String("wifi-psk", "", "WiFi passphrase")
+	baseImage := flags.String("base-image", "", "base disk image on the orchestrator for raw builds")
+	maxDevices := flags.Int("max-devices", 0, "devices that may join with the image; 0 is unlimited")
+	ttl := flags.String("ttl", "", "how long devices may join with the image")
+	output := flags.String("o", "", "output file; defaults to a name derived from the image ID")
+	var labels, dns, sshKeys stringList
+	flags.Var(&labels, "label", "label applied to the devices, as key=value (repeatable)")
+	flags.Var(&dns, "dns", "DNS server (repeatable)")
+	flags.Var(&sshKeys, "ssh-key", "file holding an authorized SSH public key (repeatable)")
+	flags.Parse(args)
+
+	req := map[string]interface{}{
+		"format":           *format,
+		"site_id":          *site,
+		"region":           *region,
+		"zone":             *zone,
+		"node_name_prefix": *prefix,
+		"base_image":       *baseImage,
+		"max_devices":      *maxDevices,
+		"ttl":              *ttl,
+	}
+
+	labelMap := make(map[string]string)
+	for _, label := range labels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		labelMap[key] = value
+	}
+	req["labels"] = labelMap
+
+	var keys []string
+	for _, path := range sshKeys {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH key: %v", err)
+		}
+		keys = append(keys, strings.TrimSpace(string(data)))
+	}
+	req["ssh_authorized_keys"] = keys
+
+	if *iface != "" || *address != "" || len(dns) > 0 || *ssid != "" {
+		network := map[string]interface{}{
+			"interface": *iface,
+			"dhcp":      *address == "",
+			"address":   *address,
+			"gateway":   *gateway,
+			"dns":       []string(dns),
+		}
+		if *ssid != "" {
+			network["wifi"] = map[string]string{"ssid": *ssid, "psk": *psk}
+		}
+		req["network"] = network
+	}
+
+	var created struct {
+		Image     imageBuild `json:"image"`
+		JoinToken struct {
+			ID        string    `json:"id"`
+			ExpiresAt time.Time `json:"expires_at"`
+		} `json:"join_token"`
+	}
+	if err := c.do("POST", "/api/v1/provisioning/images", req, &created); err != nil {
+		return err
+	}
+	image := created.Image
+	fmt.Fprintf(os.Stderr, "Image %s uses join token %s, valid until %s\n",
+		image.ID, created.JoinToken.ID, created.JoinToken.ExpiresAt.Local().Format(time.RFC1123))
+
+	for image.Status == "building" {
+		fmt.Fprintln(os.Stderr, "Building image...")
+		time.Sleep(BuildPollInterval)
+		var current struct {
+			Image imageBuild `json:"image"`
+		}
+		if err := c.do("GET", "/api/v1/provisioning/images/"+image.ID, nil, &current); err != nil {
+			return err
+		}
+		image = current.Image
+	}
+	if image.Status != "ready" {
+		return fmt.Errorf("image build failed: %s", image.Error)
+	}
+
+	if *output == "" {
+		extensions := map[string]string{"cloud-init": ".user-data", "ignition": ".ign", "raw": ".img"}
+		*output = "edge-" + image.ID[:8] + extensions[image.Format]
+	}
+	if err := c.download(image, *output); err != nil {
+		return err
+	}
+	fmt.Printf("%s\nsha256 %s\n", *output, image.SHA256)
+	return nil
+}
+
+// download writes an image to a file, removing it if the transfer fails. Payloads hold
+// the join token, so the file is only readable by its owner.
+func (c *client) download(image imageBuild, path string) error {
+	req, err := http.NewRequest("GET", c.baseURL.String()+"/api/v1/provisioning/images/"+image.ID+"/download", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("download failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("download failed: %v", err)
+	}
+	return file.Close()
+}
+
+func (c *client) list() error {
+	var resp struct {
+		Images []imageBuild `json:"images"`
+	}
+	if err := c.do("GET", "/api/v1/provisioning/images", nil, &resp); err != nil {
+		return err
+	}
+
+	fmt.Printf("%-34s %-10s %-12s %-9s %s\n", "ID", "FORMAT", "SITE", "STATUS", "JOIN TOKEN")
+	for _, image := range resp.Images {
+		site := image.SiteID
+		if site == "" {
+			site = "-"
+		}
+		fmt.Printf("%-34s %-10s %-12s %-9s %s\n", image.ID, image.Format, site, image.Status, image.JoinTokenID)
+	}
+	return nil
+}
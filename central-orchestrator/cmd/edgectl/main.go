@@ -0,0 +1,575 @@
+// Command edgectl is the operator CLI for the edge orchestrator.
+//
+//	edgectl port-forward workload/<name> [local:]remote [--ttl 15m] [--node <id>]
+//	edgectl claims
+//	edgectl claim <code|url> [--site <id>] [--tenant <name>] [--label k=v]... [--name <node>]
+//	edgectl get nodes|workloads [<id>] [--fields name,status]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Protocol named in the Upgrade header of tunnel connections
+	TunnelUpgradeProtocol = "edge-tunnel"
+
+	// Header carrying the session token on tunnel connections
+	PortForwardTokenHeader = "X-Port-Forward-Token"
+
+	// Header naming the operator in audit records
+	OperatorHeader = "X-Edge-Operator"
+
+	DefaultTimeout = 30 * time.Second
+)
+
+// client talks to the orchestrator API
+type client struct {
+	baseURL    *url.URL
+	token      string
+	operator   string
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+}
+
+type portForwardSession struct {
+	ID           string    `json:"id"`
+	WorkloadName string    `json:"workload_name"`
+	NodeID       string    `json:"node_id"`
+	Port         int32     `json:"port"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func main() {
+	flags := flag.NewFlagSet("edgectl", flag.ExitOnError)
+	orchestrator := flags.String("orchestrator", envOr("EDGECTL_ORCHESTRATOR", os.Getenv("ORCHESTRATOR_URL")), "orchestrator URL")
+	token := flags.String("token", envOr("EDGECTL_TOKEN", os.Getenv("AUTH_TOKEN")), "API bearer token")
+	insecure := flags.Bool("insecure", false, "skip orchestrator certificate verification")
+	flags.Usage = usage
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *orchestrator == "" {
+		fmt.Fprintln(os.Stderr, "edgectl: --orchestrator or EDGECTL_ORCHESTRATOR is required")
+		os.Exit(2)
+	}
+
+	c, err := newClient(*orchestrator, *token, *insecure)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "edgectl: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "port-forward":
+		err = c.portForward(args[1:])
+	case "claims":
+		err = c.listClaims()
+	case "claim":
+		err = c.claim(args[1:])
+	case "replace":
+		err = c.replace(args[1:])
+	case "get":
+		err = c.get(args[1:])
+	case "token":
+		err = c.issueToken(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "edgectl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: edgectl [--orchestrator URL] [--token TOKEN] [--insecure] <command>
+
+Commands:
+  port-forward workload/<name> [local:]remote [--ttl 15m] [--node ID] [--address 127.0.0.1]
+      Forward a local port to a workload port through the node's agent
+  claims
+      List devices waiting to be claimed
+  claim <code|url> [--site ID] [--tenant NAME] [--label k=v]... [--name NODE] [--replace NODE-ID] [--reject]
+      Bind a device to a site by the code it displays, or the URL its QR code encodes
+  replace <node-id> [--reason TEXT] [--ttl 72h] [--cancel]
+      Issue the join token a replacement device registers with to take over a node
+  get nodes|workloads [ID] [--fields name,status,...]
+      Print nodes or workloads as JSON, with only the given fields when set
+  token [--ttl 8h] [--role ROLE] [--tenant NAME]... [--scope resource:verb]... [--subject USER]
+      Exchange the current token for a signed JWT, narrowed to the given role, tenants
+      and scopes; admins may issue one for another subject`)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func newClient(orchestrator, token string, insecure bool) (*client, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(orchestrator, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid orchestrator URL: %v", err)
+	}
+
+	operator := os.Getenv("USER")
+	if host, err := os.Hostname(); err == nil {
+		operator += "@" + host
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	return &client{
+		baseURL:   baseURL,
+		token:     token,
+		operator:  operator,
+		tlsConfig: tlsConfig,
+		httpClient: &http.Client{
+			Timeout:   DefaultTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (c *client) do(method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL.String()+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	c.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *client) setHeaders(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set(OperatorHeader, c.operator)
+}
+
+// apiError turns an error response into an error, preferring the API's error message
+func apiError(resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return fmt.Errorf("%s (status %d)", body.Error, resp.StatusCode)
+	}
+	return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+}
+
+// dialTunnel opens a connection to the orchestrator and upgrades it to a raw stream
+func (c *client) dialTunnel(path string, header http.Header) (net.Conn, error) {
+	host := c.baseURL.Host
+	if c.baseURL.Port() == "" {
+		if c.baseURL.Scheme == "https" {
+			host = net.JoinHostPort(c.baseURL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(c.baseURL.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: DefaultTimeout}
+	var conn net.Conn
+	var err error
+	if c.baseURL.Scheme == "https" {
+		tlsConfig := c.tlsConfig.Clone()
+		tlsConfig.ServerName = c.baseURL.Hostname()
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to orchestrator: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", c.baseURL.String()+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.setHeaders(req)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", TunnelUpgradeProtocol)
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send tunnel request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read tunnel response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer conn.Close()
+		return nil, apiError(resp)
+	}
+
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.reader.Read(p)
+}
+
+// parsePorts parses "8080:80" or "80" into local and remote ports
+func parsePorts(spec string) (int, int, error) {
+	local, remote := spec, spec
+	if parts := strings.SplitN(spec, ":", 2); len(parts) == 2 {
+		local, remote = parts[0], parts[1]
+	}
+	localPort, err := strconv.Atoi(local)
+	if err != nil || localPort < 0 || localPort > 65535 {
+		return 0, 0, fmt.Errorf("invalid local port %q", local)
+	}
+	remotePort, err := strconv.Atoi(remote)
+	if err != nil || remotePort <= 0 || remotePort > 65535 {
+		return 0, 0, fmt.Errorf("invalid remote port %q", remote)
+	}
+	return localPort, remotePort, nil
+}
+
+func (c *client) portForward(args []string) error {
+	flags := flag.NewFlagSet("port-forward", flag.ExitOnError)
+	ttl := flags.String("ttl", "", "session lifetime, e.g. 30m (orchestrator default when empty)")
+	node := flags.String("node", "", "forward through a specific node running the workload")
+	address := flags.String("address", "127.0.0.1", "local address to listen on")
+
+	// Allow flags before or after the positional arguments
+	var positional []string
+	for len(args) > 0 {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) > 0 {
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+	}
+	if len(positional) != 2 || !strings.HasPrefix(positional[0], "workload/") {
+		return fmt.Errorf("usage: edgectl port-forward workload/<name> [local:]remote")
+	}
+	workload := strings.TrimPrefix(positional[0], "workload/")
+	localPort, remotePort, err := parsePorts(positional[1])
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		Session portForwardSession `json:"session"`
+		Token   string             `json:"token"`
+	}
+	request := map[string]interface{}{"workload": workload, "port": remotePort, "ttl": *ttl, "node_id": *node}
+	if err := c.do("POST", "/api/v1/port-forwards", request, &created); err != nil {
+		return fmt.Errorf("failed to open port-forward session: %v", err)
+	}
+	session := created.Session
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(*address, strconv.Itoa(localPort)))
+	if err != nil {
+		c.do("DELETE", "/api/v1/port-forwards/"+session.ID, nil, nil)
+		return fmt.Errorf("failed to listen: %v", err)
+	}
+
+	fmt.Printf("Forwarding from %s -> %s:%d via node %s (session %s, expires %s)\n",
+		listener.Addr(), session.WorkloadName, session.Port, session.NodeID, session.ID, session.ExpiresAt.Local().Format(time.RFC3339))
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-quit
+		listener.Close()
+	}()
+
+	expired := time.AfterFunc(time.Until(session.ExpiresAt), func() {
+		fmt.Println("Session expired")
+		listener.Close()
+	})
+	defer expired.Stop()
+
+	header := http.Header{}
+	header.Set(PortForwardTokenHeader, created.Token)
+
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		go func() {
+			defer local.Close()
+			remote, err := c.dialTunnel("/api/v1/port-forwards/"+session.ID+"/connect", header)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "edgectl: %v\n", err)
+				return
+			}
+			defer remote.Close()
+
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				io.Copy(remote, local)
+				remote.Close()
+				local.Close()
+			}()
+			go func() {
+				defer wg.Done()
+				io.Copy(local, remote)
+				remote.Close()
+				local.Close()
+			}()
+			wg.Wait()
+		}()
+	}
+
+	if err := c.do("DELETE", "/api/v1/port-forwards/"+session.ID, nil, nil); err != nil && time.Now().Before(session.ExpiresAt) {
+		return fmt.Errorf("failed to close session: %v", err)
+	}
+	return nil
+}
+
+type deviceClaim struct {
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	Address    string    `json:"address"`
+	Status     string    `json:"status"`
+	SiteID     string    `json:"site_id"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+func (c *client) listClaims() error {
+	var resp struct {
+		Claims []deviceClaim `json:"claims"`
+	}
+	if err := c.do("GET", "/api/v1/claims", nil, &resp); err != nil {
+		return err
+	}
+
+	fmt.Printf("%-10s %-24s %-16s %-10s %s\n", "CODE", "NAME", "ADDRESS", "STATUS", "LAST SEEN")
+	for _, claim := range resp.Claims {
+		fmt.Printf("%-10s %-24s %-16s %-10s %s\n", claim.Code, claim.Name, claim.Address, claim.Status,
+			time.Since(claim.LastSeenAt).Round(time.Second))
+	}
+	return nil
+}
+
+func (c *client) claim(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: edgectl claim <code|url> [--site ID] [--tenant NAME] [--label k=v]... [--name NODE] [--replace NODE-ID]")
+	}
+	code := args[0]
+	// A scanned QR code gives the claim URL rather than the code
+	if parsed, err := url.Parse(code); err == nil && parsed.Query().Get("code") != "" {
+		code = parsed.Query().Get("code")
+	}
+
+	flags := flag.NewFlagSet("claim", flag.ExitOnError)
+	site := flags.String("site", "", "site to bind the device to")
+	tenant := flags.String("tenant", "", "tenant the device belongs to")
+	name := flags.String("name", "", "node name; defaults to the name the device announced")
+	replaces := flags.String("replace", "", "node the device replaces; it takes over the node's identity")
+	reject := flags.Bool("reject", false, "refuse the device instead of claiming it")
+	labels := make(map[string]string)
+	flags.Func("label", "label applied to the node, as key=value (repeatable)", func(value string) error {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("expected key=value")
+		}
+		labels[key] = val
+		return nil
+	})
+	flags.Parse(args[1:])
+
+	path := "/api/v1/claims/" + url.PathEscape(code)
+	if *reject {
+		if err := c.do("DELETE", path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Rejected device %s\n", code)
+		return nil
+	}
+
+	var resp struct {
+		Claim struct {
+			NodeName string `json:"node_name"`
+			SiteID   string `json:"site_id"`
+		} `json:"claim"`
+	}
+	req := map[string]interface{}{"site_id": *site, "tenant": *tenant, "labels": labels, "node_name": *name, "replaces_node_id": *replaces}
+	if err := c.do("POST", path+"/claim", req, &resp); err != nil {
+		return err
+	}
+	if *replaces != "" {
+		fmt.Printf("Claimed device %s to replace node %s; it takes over on its next check-in\n", code, *replaces)
+		return nil
+	}
+	fmt.Printf("Claimed device %s as %s; it registers on its next check-in\n", code, resp.Claim.NodeName)
+	return nil
+}
+
+func (c *client) replace(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: edgectl replace <node-id> [--reason TEXT] [--ttl 72h] [--cancel]")
+	}
+	nodeID := args[0]
+
+	flags := flag.NewFlagSet("replace", flag.ExitOnError)
+	reason := flags.String("reason", "", "why the hardware is being replaced")
+	ttl := flags.String("ttl", "", "how long the replacement device has to register")
+	cancel := flags.Bool("cancel", false, "cancel the node's pending replacement")
+	flags.Parse(args[1:])
+
+	path := "/api/v1/nodes/" + url.PathEscape(nodeID) + "/replace"
+	if *cancel {
+		if err := c.do("DELETE", path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Cancelled replacement of node %s\n", nodeID)
+		return nil
+	}
+
+	var resp struct {
+		Replacement struct {
+			PreviousName string    `json:"previous_name"`
+			ExpiresAt    time.Time `json:"expires_at"`
+		} `json:"replacement"`
+		JoinToken string `json:"join_token"`
+	}
+	if err := c.do("POST", path, map[string]string{"reason": *reason, "ttl": *ttl}, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Replacing node %s (%s). Configure the new device with this join token before %s:\n",
+		resp.Replacement.PreviousName, nodeID, resp.Replacement.ExpiresAt.Local().Format(time.RFC1123))
+	fmt.Println(resp.JoinToken)
+	return nil
+}
+
+func (c *client) get(args []string) error {
+	if len(args) == 0 || (args[0] != "nodes" && args[0] != "workloads") {
+		return fmt.Errorf("usage: edgectl get nodes|workloads [ID] [--fields name,status,...]")
+	}
+	resource, rest := args[0], args[1:]
+	id := ""
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		id, rest = rest[0], rest[1:]
+	}
+
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	fields := flags.String("fields", "", "comma-separated fields to return, such as name,status or resources.cpu")
+	flags.Parse(rest)
+
+	path := "/api/v1/" + resource
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	if *fields != "" {
+		path += "?fields=" + url.QueryEscape(*fields)
+	}
+
+	var resp map[string]json.RawMessage
+	if err := c.do("GET", path, nil, &resp); err != nil {
+		return err
+	}
+	// The object or list is wrapped as {"node": ...} or {"nodes": [...]}
+	key := resource
+	if id != "" {
+		key = strings.TrimSuffix(resource, "s")
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, resp[key], "", "  "); err != nil {
+		return fmt.Errorf("failed to format response: %v", err)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// issueToken prints a JWT issued for the caller's credentials, so it can be exported as
+// EDGECTL_TOKEN
+func (c *client) issueToken(args []string) error {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	ttl := flags.String("ttl", "", "token lifetime, such as 8h (default: the orchestrator's token_ttl)")
+	role := flags.String("role", "", "role of the token; no more privileged than the caller's")
+	subject := flags.String("subject", "", "user the token is for; admins only")
+	var tenants, scopes, groups []string
+	flags.Func("tenant", "tenant or organization the token is limited to (repeatable)", func(value string) error {
+		tenants = append(tenants, value)
+		return nil
+	})
+	flags.Func("scope", "resource:verb scope the token is limited to (repeatable)", func(value string) error {
+		scopes = append(scopes, value)
+		return nil
+	})
+	flags.Func("group", "group of the subject, with --subject (repeatable)", func(value string) error {
+		groups = append(groups, value)
+		return nil
+	})
+	flags.Parse(args)
+
+	var resp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	req := map[string]interface{}{"ttl": *ttl, "role": *role, "subject": *subject, "tenants": tenants, "scopes": scopes, "groups": groups}
+	if err := c.do("POST", "/api/v1/auth/token", req, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Token expires at %s\n", resp.ExpiresAt.Local().Format(time.RFC1123))
+	fmt.Println(resp.Token)
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Copying a large store can take much longer than a single sync
+const MigrationTimeout = 10 * time.Minute
+
+const usage = `Usage: central-orchestrator [command]
+
+Commands:
+  run               Run the orchestrator (default)
+  migrate-storage   Copy persisted state to another storage backend, such as from
+                    SQLite to Postgres. Stop every orchestrator replica first.
+  migrate-schema    Upgrade the store to this version's schema, backing it up first.
+                    Use -status to only report the store's schema version.
+  replay-scheduling Place the workloads of a fleet snapshot under a baseline and a
+                    candidate scheduler policy and compare the placements. Custom
+                    plugins built into this binary take part.
+`
+
+// runCommand dispatches an offline subcommand and returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate-storage":
+		return cmdMigrateStorage(args[1:])
+	case "migrate-schema":
+		return cmdMigrateSchema(args[1:])
+	case "replay-scheduling":
+		return cmdReplayScheduling(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+	return 2
+}
+
+func cmdMigrateStorage(args []string) int {
+	flags := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	from := flags.String("from", os.Getenv("STORAGE_BACKEND"), "backend to copy from (default STORAGE_BACKEND)")
+	fromDSN := flags.String("from-dsn", os.Getenv("STORAGE_DSN"), "connection string of the source (default STORAGE_DSN)")
+	to := flags.String("to", "", "backend to copy to: sqlite, postgres or etcd")
+	toDSN := flags.String("to-dsn", "", "connection string of the target")
+	overwrite := flags.Bool("overwrite", false, "replace records already in the target")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *to == "" {
+		fmt.Fprintln(os.Stderr, "-to is required")
+		return 2
+	}
+	if *from == "" || *from == "memory" || *to == "memory" {
+		fmt.Fprintln(os.Stderr, "The memory backend does not outlive the orchestrator; migrate between sqlite, postgres and etcd")
+		return 2
+	}
+	if *from == *to && *fromDSN == *toDSN {
+		fmt.Fprintln(os.Stderr, "Source and target are the same store")
+		return 2
+	}
+
+	// The source is opened read-only, so a mistyped path fails on the missing state table
+	// rather than migrating an empty store
+	source, err := openStore(*from, *fromDSN, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open source: %v\n", err)
+		return 1
+	}
+	defer source.Close()
+
+	target, err := openStore(*to, *toDSN, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open target: %v\n", err)
+		return 1
+	}
+	defer target.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), MigrationTimeout)
+	defer cancel()
+
+	copied, err := migrateStore(ctx, source, target, *overwrite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return 1
+	}
+
+	counts := make([]string, 0, len(stateKinds))
+	for _, kind := range stateKinds {
+		counts = append(counts, fmt.Sprintf("%d %s", copied[kind], kind))
+	}
+	fmt.Printf("Copied %s from the %s store to the %s store\n", strings.Join(counts, ", "), source.Backend(), target.Backend())
+	fmt.Printf("Start the orchestrator with STORAGE_BACKEND=%s and STORAGE_DSN set to the new store\n", target.Backend())
+	return 0
+}
+
+func cmdMigrateSchema(args []string) int {
+	flags := flag.NewFlagSet("migrate-schema", flag.ContinueOnError)
+	statusOnly := flags.Bool("status", false, "report the schema version without migrating")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	store, err := openStore(os.Getenv("STORAGE_BACKEND"), os.Getenv("STORAGE_DSN"), *statusOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), MigrationTimeout)
+	defer cancel()
+
+	current, err := loadSchemaVersion(ctx, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	latest := latestSchemaVersion()
+	fmt.Printf("The %s store is at schema version %d; this orchestrator writes version %d\n", store.Backend(), current, latest)
+	if *statusOnly {
+		for _, migration := range storeMigrations {
+			if migration.Version > current {
+				fmt.Printf("  pending %d: %s\n", migration.Version, migration.Description)
+			}
+		}
+		return 0
+	}
+	if current > latest {
+		fmt.Fprintln(os.Stderr, "The store was written by a newer orchestrator and cannot be downgraded")
+		return 1
+	}
+	if current == latest {
+		return 0
+	}
+
+	logger := logrus.New()
+	ss := &StateStore{store: store, logger: logger}
+	if err := ss.applyMigrations(ctx, current); err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Migrated the %s store to schema version %d\n", store.Backend(), latest)
+	return 0
+}
+
+func cmdReplayScheduling(args []string) int {
+	flags := flag.NewFlagSet("replay-scheduling", flag.ContinueOnError)
+	snapshotFile := flags.String("snapshot", "", "fleet snapshot, as returned by GET /api/v1/scheduler/snapshots/:id")
+	baselineFile := flags.String("baseline", "", "baseline scheduler policy (default: the scheduler as built)")
+	candidateFile := flags.String("candidate", "", "candidate scheduler policy (default: the scheduler as built)")
+	reschedule := flags.Bool("reschedule", false, "place every pending and running workload from scratch")
+	output := flags.String("output", "", "write the full report as JSON to this file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *snapshotFile == "" {
+		fmt.Fprintln(os.Stderr, "-snapshot is required")
+		return 2
+	}
+
+	req := SchedulingReplayRequest{Snapshot: &FleetSnapshot{}, Reschedule: *reschedule}
+	for _, input := range []struct {
+		file string
+		into interface{}
+	}{
+		{*snapshotFile, req.Snapshot},
+		{*baselineFile, &req.Baseline},
+		{*candidateFile, &req.Candidate},
+	} {
+		if input.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(input.file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if err := json.Unmarshal(data, input.into); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse %s: %v\n", input.file, err)
+			return 1
+		}
+	}
+
+	report, err := replayScheduling(req.Snapshot, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Replayed %d workloads of the snapshot captured at %s\n", report.Baseline.Workloads, report.CapturedAt.Format(time.RFC3339))
+	for _, outcome := range []struct {
+		name string
+		*ReplayOutcome
+	}{{"baseline", report.Baseline}, {"candidate", report.Candidate}} {
+		fmt.Printf("  %-9s %d scheduled, %d partially, %d unschedulable; %d replicas unplaced; %d nodes used, at most %d replicas on one\n",
+			outcome.name, outcome.ScheduledWorkloads, outcome.PartialWorkloads, outcome.UnschedulableWorkloads,
+			outcome.UnplacedReplicas, outcome.NodesUsed, outcome.MaxReplicasPerNode)
+	}
+	fmt.Printf("%d workloads placed differently\n", len(report.Changes))
+	for _, change := range report.Changes {
+		fmt.Printf("  %s: %d replicas moved, %d -> %d placed\n", change.Name, change.MovedReplicas, change.BaselinePlaced, change.CandidatePlaced)
+	}
+
+	if *output != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if err := os.WriteFile(*output, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the report: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
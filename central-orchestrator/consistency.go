@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Interval between background consistency checks
+	ConsistencyCheckInterval = 15 * time.Minute
+)
+
+// Consistency checks; each issue names the check that found it
+const (
+	ConsistencyCheckDeploymentNode      = "deployment-node"
+	ConsistencyCheckDuplicateDeployment = "duplicate-deployment"
+	ConsistencyCheckDeploymentReplicas  = "deployment-replicas"
+	ConsistencyCheckWorkloadStatus      = "workload-status"
+	ConsistencyCheckCronJob             = "cron-job"
+	ConsistencyCheckBlueGreen           = "blue-green"
+	ConsistencyCheckEnvironment         = "environment"
+	ConsistencyCheckRevisions           = "revisions"
+	ConsistencyCheckCertificateNode     = "certificate-node"
+)
+
+var consistencyChecks = []string{
+	ConsistencyCheckDeploymentNode, ConsistencyCheckDuplicateDeployment, ConsistencyCheckDeploymentReplicas,
+	ConsistencyCheckWorkloadStatus, ConsistencyCheckCronJob, ConsistencyCheckBlueGreen, ConsistencyCheckEnvironment,
+	ConsistencyCheckRevisions, ConsistencyCheckCertificateNode,
+}
+
+// ConsistencyIssue is a cross-reference in the orchestrator's state that does not hold
+type ConsistencyIssue struct {
+	Check string `json:"check"`
+	// "workload", "environment", "revisions" or "certificate"
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	// What the automatic repair does; empty when the issue needs an operator
+	Repair   string `json:"repair,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+// ConsistencyReport is the outcome of one consistency check
+type ConsistencyReport struct {
+	CheckedAt time.Time          `json:"checked_at"`
+	Issues    []ConsistencyIssue `json:"issues"`
+	Repaired  int                `json:"repaired"`
+}
+
+// ConsistencyRepairRequest limits a repair to some checks; all of them when empty
+type ConsistencyRepairRequest struct {
+	Checks []string `json:"checks"`
+}
+
+// ConsistencyChecker keeps the latest consistency report
+type ConsistencyChecker struct {
+	last *ConsistencyReport
+	// Background checks repair what they find
+	autoRepair bool
+	mutex      sync.RWMutex
+	logger     *logrus.Logger
+}
+
+// NewConsistencyChecker creates a new consistency checker
+func NewConsistencyChecker(logger *logrus.Logger) *ConsistencyChecker {
+	return &ConsistencyChecker{
+		autoRepair: os.Getenv("CONSISTENCY_AUTO_REPAIR") == "true",
+		logger:     logger,
+	}
+}
+
+func (cc *ConsistencyChecker) record(report *ConsistencyReport) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	cc.last = report
+}
+
+// consistencyRun collects the issues of one check and repairs those of the selected checks
+type consistencyRun struct {
+	report *ConsistencyReport
+	repair bool
+	only   map[string]bool
+}
+
+// found records an issue, running its repair when repairing its check
+func (run *consistencyRun) found(issue ConsistencyIssue, repair func()) {
+	if repair != nil && run.repair && (len(run.only) == 0 || run.only[issue.Check]) {
+		repair()
+		issue.Repaired = true
+		run.report.Repaired++
+	}
+	if repair == nil {
+		issue.Repair = ""
+	}
+	run.report.Issues = append(run.report.Issues, issue)
+}
+
+// checkConsistency validates the cross-references between nodes, workloads, environments,
+// revisions and certificates, and repairs what it found when repair is set, limited to the
+// given checks if any
+func (co *CentralOrchestrator) checkConsistency(repair bool, only map[string]bool, now time.Time) *ConsistencyReport {
+	run := &consistencyRun{report: &ConsistencyReport{CheckedAt: now, Issues: []ConsistencyIssue{}}, repair: repair, only: only}
+
+	co.EnvironmentManager.mutex.Lock()
+	co.WorkloadManager.mutex.Lock()
+	co.NodeManager.mutex.RLock()
+	co.checkWorkloads(run, now)
+	co.NodeManager.mutex.RUnlock()
+	co.checkEnvironments(run)
+	co.checkRevisions(run)
+	if run.report.Repaired > 0 {
+		co.AgentStreamHub.wake()
+	}
+	co.WorkloadManager.mutex.Unlock()
+	co.EnvironmentManager.mutex.Unlock()
+
+	co.checkCertificates(run)
+
+	sort.SliceStable(run.report.Issues, func(i, j int) bool {
+		a, b := run.report.Issues[i], run.report.Issues[j]
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.ID < b.ID
+	})
+	return run.report
+}
+
+// checkWorkloads checks each workload's deployments and status, and the workloads it
+// belongs to. Callers must hold the WorkloadManager lock and the NodeManager read lock.
+func (co *CentralOrchestrator) checkWorkloads(run *consistencyRun, now time.Time) {
+	for _, workload := range co.WorkloadManager.workloads {
+		workload := workload
+
+		seen := make(map[string]bool, len(workload.Deployments))
+		for i := range workload.Deployments {
+			deployment := &workload.Deployments[i]
+			if seen[deployment.NodeID] {
+				nodeID := deployment.NodeID
+				run.found(ConsistencyIssue{
+					Check: ConsistencyCheckDuplicateDeployment, Kind: "workload", ID: workload.ID,
+					Message: fmt.Sprintf("workload %s has more than one deployment on node %s", workload.Name, nodeID),
+					Repair:  "keep the first deployment on the node",
+				}, func() {
+					workload.Deployments = dropDuplicateDeployments(workload.Deployments)
+					workload.UpdatedAt = now
+				})
+				break
+			}
+			seen[deployment.NodeID] = true
+		}
+
+		for i := range workload.Deployments {
+			deployment := &workload.Deployments[i]
+			if !deployment.placed() {
+				continue
+			}
+			if _, exists := co.NodeManager.nodes[deployment.NodeID]; !exists {
+				run.found(ConsistencyIssue{
+					Check: ConsistencyCheckDeploymentNode, Kind: "workload", ID: workload.ID,
+					Message: fmt.Sprintf("workload %s is deployed on node %s, which does not exist", workload.Name, deployment.NodeID),
+					Repair:  "stop the deployment and schedule its replicas again",
+				}, func() {
+					deployment.Status = WorkloadStatusStopped
+					deployment.UpdatedAt = now
+					if workload.Status == WorkloadStatusRunning {
+						workload.Status = WorkloadStatusPending
+					}
+					workload.UpdatedAt = now
+				})
+			} else if deployment.Replicas <= 0 {
+				run.found(ConsistencyIssue{
+					Check: ConsistencyCheckDeploymentReplicas, Kind: "workload", ID: workload.ID,
+					Message: fmt.Sprintf("workload %s holds node %s with %d replicas", workload.Name, deployment.NodeID, deployment.Replicas),
+					Repair:  "stop the deployment",
+				}, func() {
+					deployment.Status = WorkloadStatusStopped
+					deployment.UpdatedAt = now
+					workload.UpdatedAt = now
+				})
+			}
+		}
+
+		co.checkWorkloadStatus(run, workload, now)
+		co.checkWorkloadOwner(run, workload, now)
+	}
+}
+
+// checkWorkloadStatus finds statuses a workload cannot be in given its deployments
+func (co *CentralOrchestrator) checkWorkloadStatus(run *consistencyRun, workload *Workload, now time.Time) {
+	issue := ConsistencyIssue{Check: ConsistencyCheckWorkloadStatus, Kind: "workload", ID: workload.ID}
+	setStatus := func(status WorkloadStatus) func() {
+		return func() {
+			workload.Status = status
+			workload.UpdatedAt = now
+		}
+	}
+
+	switch workload.Status {
+	case WorkloadStatusPending, WorkloadStatusScheduled:
+	case WorkloadStatusRunning:
+		if !workload.hasRunningDeployment() && !workload.allDeploymentsCompleted() {
+			issue.Message = fmt.Sprintf("workload %s is running without deployments", workload.Name)
+			issue.Repair = "set the workload pending so it is scheduled again"
+			run.found(issue, setStatus(WorkloadStatusPending))
+		}
+	case WorkloadStatusCompleted:
+		if workload.hasRunningDeployment() {
+			issue.Message = fmt.Sprintf("workload %s is completed but still deployed", workload.Name)
+			issue.Repair = "set the workload running"
+			run.found(issue, setStatus(WorkloadStatusRunning))
+		}
+	case WorkloadStatusFailed, WorkloadStatusStopped:
+		if workload.hasRunningDeployment() {
+			issue.Message = fmt.Sprintf("workload %s is %s but still deployed", workload.Name, workload.Status)
+			issue.Repair = "stop its deployments"
+			run.found(issue, func() {
+				for i := range workload.Deployments {
+					if workload.Deployments[i].placed() {
+						workload.Deployments[i].Status = WorkloadStatusStopped
+						workload.Deployments[i].UpdatedAt = now
+					}
+				}
+				workload.UpdatedAt = now
+			})
+		}
+	default:
+		issue.Message = fmt.Sprintf("workload %s has unknown status %q", workload.Name, workload.Status)
+		issue.Repair = "set the workload pending so it is scheduled again"
+		run.found(issue, setStatus(WorkloadStatusPending))
+	}
+}
+
+// checkWorkloadOwner finds jobs of deleted cron jobs and new versions of blue-green
+// deployments that ended
+func (co *CentralOrchestrator) checkWorkloadOwner(run *consistencyRun, workload *Workload, now time.Time) {
+	if workload.CronJobID != "" {
+		if cronJob, exists := co.WorkloadManager.workloads[workload.CronJobID]; !exists || cronJob.Type != WorkloadTypeCronJob {
+			run.found(ConsistencyIssue{
+				Check: ConsistencyCheckCronJob, Kind: "workload", ID: workload.ID,
+				Message: fmt.Sprintf("job %s belongs to cron job %s, which does not exist", workload.Name, workload.CronJobID),
+				Repair:  "delete the job",
+			}, func() { co.removeJob(workload) })
+		}
+	}
+
+	if workload.BlueGreenOf != "" {
+		parent, exists := co.WorkloadManager.workloads[workload.BlueGreenOf]
+		if !exists || parent.BlueGreen == nil || parent.BlueGreen.PreviewWorkloadID != workload.ID {
+			run.found(ConsistencyIssue{
+				Check: ConsistencyCheckBlueGreen, Kind: "workload", ID: workload.ID,
+				Message: fmt.Sprintf("workload %s is the new version of a blue-green deployment of %s that is not in progress", workload.Name, workload.BlueGreenOf),
+				Repair:  "delete the new version",
+			}, func() {
+				workload.Status = WorkloadStatusStopped
+				workload.UpdatedAt = now
+				delete(co.WorkloadManager.workloads, workload.ID)
+				co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, workload.ID)
+				co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workload.ID)
+				co.RevisionHistory.forget(workload.ID)
+			})
+		}
+	}
+}
+
+// dropDuplicateDeployments keeps the first deployment on each node
+func dropDuplicateDeployments(deployments []WorkloadDeployment) []WorkloadDeployment {
+	seen := make(map[string]bool, len(deployments))
+	kept := deployments[:0]
+	for _, deployment := range deployments {
+		if seen[deployment.NodeID] {
+			continue
+		}
+		seen[deployment.NodeID] = true
+		kept = append(kept, deployment)
+	}
+	return kept
+}
+
+// checkEnvironments finds definition instances and workload bindings that refer to what
+// no longer exists; they are reported for an operator, as rolling the definition out again
+// or deleting the instance depends on intent. Callers must hold the EnvironmentManager and
+// WorkloadManager locks.
+func (co *CentralOrchestrator) checkEnvironments(run *consistencyRun) {
+	em := co.EnvironmentManager
+	for _, definition := range em.definitions {
+		for name, instance := range definition.Instances {
+			if _, exists := co.WorkloadManager.workloads[instance.WorkloadID]; !exists {
+				run.found(ConsistencyIssue{
+					Check: ConsistencyCheckEnvironment, Kind: "environment", ID: name,
+					Message: fmt.Sprintf("workload %s of definition %s in environment %s does not exist; roll the definition out again or delete the instance", instance.WorkloadID, definition.Name, name),
+				}, nil)
+			}
+		}
+	}
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.EnvironmentBinding == nil {
+			continue
+		}
+		if _, exists := em.environments[workload.EnvironmentBinding.Name]; !exists {
+			run.found(ConsistencyIssue{
+				Check: ConsistencyCheckEnvironment, Kind: "workload", ID: workload.ID,
+				Message: fmt.Sprintf("workload %s is bound to environment %s, which does not exist", workload.Name, workload.EnvironmentBinding.Name),
+			}, nil)
+		}
+	}
+}
+
+// checkRevisions finds revision histories of deleted workloads. Callers must hold the
+// WorkloadManager lock.
+func (co *CentralOrchestrator) checkRevisions(run *consistencyRun) {
+	co.RevisionHistory.mutex.RLock()
+	var orphaned []string
+	for id := range co.RevisionHistory.revisions {
+		if _, exists := co.WorkloadManager.workloads[id]; !exists {
+			orphaned = append(orphaned, id)
+		}
+	}
+	co.RevisionHistory.mutex.RUnlock()
+
+	for _, id := range orphaned {
+		id := id
+		run.found(ConsistencyIssue{
+			Check: ConsistencyCheckRevisions, Kind: "revisions", ID: id,
+			Message: fmt.Sprintf("revisions are kept for workload %s, which does not exist", id),
+			Repair:  "delete the revisions",
+		}, func() { co.RevisionHistory.forget(id) })
+	}
+}
+
+// checkCertificates finds certificates that still authenticate nodes that no longer exist
+func (co *CentralOrchestrator) checkCertificates(run *consistencyRun) {
+	// Held throughout so a node registered meanwhile does not lose its new certificate
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	type orphan struct{ id, nodeID string }
+	var orphaned []orphan
+	co.SecurityManager.mutex.RLock()
+	for id, cert := range co.SecurityManager.certificates {
+		if cert.RevokedAt != nil {
+			continue
+		}
+		if _, exists := co.NodeManager.nodes[cert.NodeID]; !exists {
+			orphaned = append(orphaned, orphan{id: id, nodeID: cert.NodeID})
+		}
+	}
+	co.SecurityManager.mutex.RUnlock()
+
+	for _, cert := range orphaned {
+		cert := cert
+		run.found(ConsistencyIssue{
+			Check: ConsistencyCheckCertificateNode, Kind: "certificate", ID: cert.id,
+			Message: fmt.Sprintf("certificate %s is valid for node %s, which does not exist", cert.id, cert.nodeID),
+			Repair:  "revoke the certificate",
+		}, func() {
+			if err := co.SecurityManager.RevokeCertificate(cert.id); err != nil {
+				co.Logger.Warnf("Failed to revoke certificate %s: %v", cert.id, err)
+			}
+		})
+	}
+}
+
+// consistencyController periodically checks the orchestrator's state, repairing what it finds
+// when CONSISTENCY_AUTO_REPAIR is set
+func (co *CentralOrchestrator) consistencyController() {
+	ticker := time.NewTicker(ConsistencyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report := co.checkConsistency(co.ConsistencyChecker.autoRepair, nil, time.Now())
+			co.ConsistencyChecker.record(report)
+			if len(report.Issues) > 0 {
+				co.Logger.Warnf("Consistency check found %d issues, repaired %d", len(report.Issues), report.Repaired)
+			}
+		}
+	}
+}
+
+// GetConsistencyReport checks the orchestrator's state and reports the issues found with
+// the repair each would get; ?cached=true returns the latest background report instead
+func (co *CentralOrchestrator) GetConsistencyReport(c *gin.Context) {
+	if c.Query("cached") == "true" {
+		co.ConsistencyChecker.mutex.RLock()
+		report := co.ConsistencyChecker.last
+		co.ConsistencyChecker.mutex.RUnlock()
+		if report == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No consistency check has run yet"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"report": report})
+		return
+	}
+
+	report := co.checkConsistency(false, nil, time.Now())
+	co.ConsistencyChecker.record(report)
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// RepairConsistency checks the orchestrator's state and repairs the issues found, limited
+// to the requested checks
+func (co *CentralOrchestrator) RepairConsistency(c *gin.Context) {
+	var req ConsistencyRepairRequest
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	only := make(map[string]bool, len(req.Checks))
+	for _, check := range req.Checks {
+		if !contains(consistencyChecks, check) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown check %q", check)})
+			return
+		}
+		only[check] = true
+	}
+
+	report := co.checkConsistency(true, only, time.Now())
+	co.ConsistencyChecker.record(report)
+	co.Logger.Infof("Consistency repair fixed %d of %d issues", report.Repaired, len(report.Issues))
+	co.AuditLog.RecordRequest(c, "state.repair", "consistency",
+		map[string]string{"issues": fmt.Sprint(len(report.Issues)), "repaired": fmt.Sprint(report.Repaired)})
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
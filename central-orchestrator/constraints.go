@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Placement constraint operators
+const (
+	// The node's value is one of the values; the default when no operator is given
+	ConstraintOperatorIn = "In"
+	// The node has no value, or one that is not among the values
+	ConstraintOperatorNotIn = "NotIn"
+	// The node has a value for the key, whatever it is
+	ConstraintOperatorExists = "Exists"
+	// The node has no value for the key
+	ConstraintOperatorDoesNotExist = "DoesNotExist"
+	// The node's value is a number greater or less than the single value
+	ConstraintOperatorGt = "Gt"
+	ConstraintOperatorLt = "Lt"
+)
+
+// Placement preference weights range from 1 to this, as in Kubernetes
+const MaxPreferenceWeight = 100
+
+// setConstraintKeys are keys a node can have several values of; In requires every listed
+// value, NotIn none of them, and numeric comparisons do not apply
+var setConstraintKeys = map[string]bool{
+	"capability":         true,
+	DatasetConstraintKey: true,
+	"camera":             true,
+	DeviceConstraintKey:  true,
+}
+
+// operator returns the constraint's operator, In when unset
+func (pc PlacementConstraint) operator() string {
+	if pc.Operator == "" {
+		return ConstraintOperatorIn
+	}
+	return pc.Operator
+}
+
+// requires reports whether the constraint requires the node to have the listed values, as
+// opposed to excluding them or only testing for presence
+func (pc PlacementConstraint) requires() bool {
+	return pc.operator() == ConstraintOperatorIn
+}
+
+// validate checks that the operator is known and has the values it needs
+func (pc PlacementConstraint) validate() error {
+	if pc.Key == "" {
+		return fmt.Errorf("placement constraint has no key")
+	}
+	switch pc.operator() {
+	case ConstraintOperatorIn, ConstraintOperatorNotIn:
+		if len(pc.Values) == 0 {
+			return fmt.Errorf("placement constraint %s %s needs at least one value", pc.Key, pc.operator())
+		}
+	case ConstraintOperatorExists, ConstraintOperatorDoesNotExist:
+		if len(pc.Values) > 0 {
+			return fmt.Errorf("placement constraint %s %s takes no values", pc.Key, pc.operator())
+		}
+	case ConstraintOperatorGt, ConstraintOperatorLt:
+		if setConstraintKeys[pc.Key] {
+			return fmt.Errorf("placement constraint %s does not support %s", pc.Key, pc.operator())
+		}
+		if len(pc.Values) != 1 {
+			return fmt.Errorf("placement constraint %s %s needs exactly one value", pc.Key, pc.operator())
+		}
+		if _, err := strconv.ParseFloat(pc.Values[0], 64); err != nil {
+			return fmt.Errorf("placement constraint %s %s needs a number, got %q", pc.Key, pc.operator(), pc.Values[0])
+		}
+	default:
+		return fmt.Errorf("unknown placement constraint operator %q", pc.Operator)
+	}
+	return nil
+}
+
+// matchesValue evaluates the constraint against a key the node has at most one value of
+func (pc PlacementConstraint) matchesValue(value string, exists bool) bool {
+	switch pc.operator() {
+	case ConstraintOperatorIn:
+		return exists && contains(pc.Values, value)
+	case ConstraintOperatorNotIn:
+		return !exists || !contains(pc.Values, value)
+	case ConstraintOperatorExists:
+		return exists
+	case ConstraintOperatorDoesNotExist:
+		return !exists
+	case ConstraintOperatorGt, ConstraintOperatorLt:
+		if !exists || len(pc.Values) != 1 {
+			return false
+		}
+		// Values that are not numbers never match
+		actual, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		limit, err := strconv.ParseFloat(pc.Values[0], 64)
+		if err != nil {
+			return false
+		}
+		if pc.operator() == ConstraintOperatorGt {
+			return actual > limit
+		}
+		return actual < limit
+	}
+	return false
+}
+
+// matchesSet evaluates the constraint against a key the node can have several values of;
+// has reports whether the node has a value and any whether it has one at all
+func (pc PlacementConstraint) matchesSet(has func(string) bool, any bool) bool {
+	switch pc.operator() {
+	case ConstraintOperatorIn:
+		for _, value := range pc.Values {
+			if !has(value) {
+				return false
+			}
+		}
+		return true
+	case ConstraintOperatorNotIn:
+		for _, value := range pc.Values {
+			if has(value) {
+				return false
+			}
+		}
+		return true
+	case ConstraintOperatorExists:
+		return any
+	case ConstraintOperatorDoesNotExist:
+		return !any
+	}
+	return false
+}
+
+// nodeMatchesConstraint evaluates one placement constraint against a node
+func (co *CentralOrchestrator) nodeMatchesConstraint(node *EdgeNode, constraint PlacementConstraint) bool {
+	switch constraint.Key {
+	case "region":
+		return constraint.matchesValue(node.Region, node.Region != "")
+	case "zone":
+		return constraint.matchesValue(node.Zone, node.Zone != "")
+	case "site":
+		return constraint.matchesValue(node.SiteID, node.SiteID != "")
+	case "capability":
+		return constraint.matchesSet(func(capability string) bool {
+			return contains(node.Capabilities, capability)
+		}, len(node.Capabilities) > 0)
+	case DatasetConstraintKey:
+		// At the catalog's version unless pinned
+		return constraint.matchesSet(func(dataset string) bool {
+			return co.holdsDatasets(node, []string{dataset})
+		}, len(node.datasets()) > 0)
+	case "camera":
+		return constraint.matchesSet(func(cameraID string) bool {
+			return node.camera(cameraID) != nil
+		}, len(node.Cameras) > 0)
+	case DeviceConstraintKey:
+		// By name or by kind
+		return constraint.matchesSet(node.hasDevice, len(node.Devices) > 0)
+	default:
+		value, exists := node.Labels[constraint.Key]
+		return constraint.matchesValue(value, exists)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// Kubelet CPU manager policy that gives Guaranteed pods with whole CPUs exclusive cores
+	CPUManagerPolicyStatic = "static"
+	// Kubelet topology manager policy that keeps a pod's CPUs and devices on one NUMA node
+	TopologyManagerPolicySingleNUMANode = "single-numa-node"
+)
+
+// CPUPinning asks for each replica to run on CPUs of its own, for latency-critical
+// workloads that cannot tolerate noisy neighbors
+type CPUPinning struct {
+	// Whole CPUs each replica gets exclusively through the kubelet's static CPU manager
+	ExclusiveCPUs int32 `json:"exclusive_cpus"`
+	// Take the CPUs from a single NUMA node
+	SingleNUMANode bool `json:"single_numa_node,omitempty"`
+}
+
+// CPUTopology is a node's CPU layout and the kubelet policies that decide whether pinning
+// is honored there
+type CPUTopology struct {
+	CPUs      int `json:"cpus"`
+	NUMANodes int `json:"numa_nodes"`
+	// CPUs of the smallest NUMA node
+	CPUsPerNUMANode int `json:"cpus_per_numa_node"`
+	// CPUs the kubelet reserves for the system, which are never handed out exclusively
+	ReservedCPUs          int    `json:"reserved_cpus"`
+	CPUManagerPolicy      string `json:"cpu_manager_policy"`
+	TopologyManagerPolicy string `json:"topology_manager_policy"`
+}
+
+// apply checks a pinning request and sets the workload's CPU requests and limits to the
+// exclusive CPUs and its memory limit to its request, which makes its pods Guaranteed as
+// the static CPU manager requires
+func (p *CPUPinning) apply(resources *WorkloadResources, qos QoSClass) error {
+	if p.ExclusiveCPUs < 1 {
+		return fmt.Errorf("cpu_pinning requires at least one exclusive cpu")
+	}
+	if qos != "" && qos != QoSGuaranteed {
+		return fmt.Errorf("cpu_pinning requires qos_class guaranteed")
+	}
+
+	cpus := strconv.Itoa(int(p.ExclusiveCPUs))
+	for _, value := range []string{resources.Requests.CPU, resources.Limits.CPU} {
+		if value == "" {
+			continue
+		}
+		q, ok := parseQuantity(value)
+		if !ok || q.MilliValue() != int64(p.ExclusiveCPUs)*1000 {
+			return fmt.Errorf("cpu requests and limits of a pinned workload must equal its %d exclusive cpus", p.ExclusiveCPUs)
+		}
+	}
+	resources.Requests.CPU, resources.Limits.CPU = cpus, cpus
+
+	if resources.Requests.Memory == "" {
+		return fmt.Errorf("cpu_pinning requires a memory request")
+	}
+	if resources.Limits.Memory == "" {
+		resources.Limits.Memory = resources.Requests.Memory
+	}
+	if resources.Limits.Memory != resources.Requests.Memory {
+		return fmt.Errorf("memory limit of a pinned workload must equal its request")
+	}
+	return nil
+}
+
+// nodeHonorsCPUPinning reports whether a node's kubelet pins a replica's CPUs as asked.
+// Nodes that do not report their topology are assumed not to.
+func nodeHonorsCPUPinning(node *EdgeNode, pinning *CPUPinning) bool {
+	topology := node.Resources.CPUTopology
+	if topology == nil || topology.CPUManagerPolicy != CPUManagerPolicyStatic {
+		return false
+	}
+	cpus := int(pinning.ExclusiveCPUs)
+	if cpus > topology.CPUs-topology.ReservedCPUs {
+		return false
+	}
+	if pinning.SingleNUMANode && topology.NUMANodes > 1 {
+		return topology.TopologyManagerPolicy == TopologyManagerPolicySingleNUMANode && cpus <= topology.CPUsPerNUMANode
+	}
+	return true
+}
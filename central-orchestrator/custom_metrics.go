@@ -0,0 +1,32 @@
+package main
+
+import "time"
+
+const (
+	// Namespace of the metrics agents' collectors report, kept apart from built-in metrics
+	CustomMetricPrefix = "custom."
+)
+
+// CustomMetric is a value one of a node's collectors reported: a command the agent runs
+// whose JSON output covers site-specific sensors and checks
+type CustomMetric struct {
+	Collector string `json:"collector"`
+	// Key in the collector's output, nested keys joined with dots
+	Name        string    `json:"name"`
+	Value       float64   `json:"value"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// customMetricName names the stored series of a collector's metric
+func customMetricName(collector, name string) string {
+	return CustomMetricPrefix + collector + "." + name
+}
+
+// customMetricSamples returns the node's collector values as raw metric samples
+func customMetricSamples(node *EdgeNode) []MetricSample {
+	samples := make([]MetricSample, 0, len(node.CustomMetrics))
+	for _, metric := range node.CustomMetrics {
+		samples = append(samples, MetricSample{Class: MetricClassNode, Name: customMetricName(metric.Collector, metric.Name), EntityID: node.ID, Value: metric.Value})
+	}
+	return samples
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Node labels under this prefix advertise a dataset by name; the value is its version
+	DatasetLabelPrefix = "dataset.edge.io/"
+
+	// Placement constraint key restricting a workload to nodes holding named datasets
+	DatasetConstraintKey = "dataset"
+)
+
+// Dataset names are used in node labels, so they follow label name rules
+var datasetNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]{0,61}[a-z0-9])?$`)
+
+// DatasetRequest registers or updates a dataset in the catalog
+type DatasetRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Tenant      string `json:"tenant"`
+	// Version nodes must hold to satisfy an unversioned dataset constraint; empty accepts any
+	Version string            `json:"version"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Dataset is a catalog entry workloads can be placed next to
+type Dataset struct {
+	DatasetRequest
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LocalDataset is a copy of a dataset held by a node
+type LocalDataset struct {
+	Name       string     `json:"name" binding:"required"`
+	Version    string     `json:"version"`
+	Path       string     `json:"path,omitempty"`
+	SizeBytes  int64      `json:"size_bytes"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	// "agent" when reported by the node's agent, "label" when advertised by a node label
+	Source string `json:"source"`
+}
+
+// DatasetReport is the full set of datasets an agent finds on its node
+type DatasetReport struct {
+	Datasets []LocalDataset `json:"datasets" binding:"dive"`
+}
+
+// DatasetHolder is a node holding a copy of a dataset
+type DatasetHolder struct {
+	NodeID    string `json:"node_id"`
+	NodeName  string `json:"node_name"`
+	SiteID    string `json:"site_id,omitempty"`
+	Version   string `json:"version"`
+	Path      string `json:"path,omitempty"`
+	SizeBytes int64  `json:"size_bytes"`
+	Source    string `json:"source"`
+	// Whether the copy satisfies unversioned constraints on the dataset
+	Current bool `json:"current"`
+}
+
+// DatasetView is a catalog entry with the nodes it can be placed on
+type DatasetView struct {
+	Dataset
+	Holders []DatasetHolder `json:"holders"`
+}
+
+// DatasetCatalog holds the datasets workloads can be constrained to
+type DatasetCatalog struct {
+	datasets map[string]*Dataset
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewDatasetCatalog creates an empty dataset catalog
+func NewDatasetCatalog(logger *logrus.Logger) *DatasetCatalog {
+	return &DatasetCatalog{
+		datasets: make(map[string]*Dataset),
+		logger:   logger,
+	}
+}
+
+// requiredVersion returns the version a node must hold to satisfy a constraint value of
+// "name" or "name@version", and whether the dataset is in the catalog
+func (dc *DatasetCatalog) requiredVersion(value string) (string, string, bool) {
+	name, version, pinned := strings.Cut(value, "@")
+
+	dc.mutex.RLock()
+	defer dc.mutex.RUnlock()
+
+	dataset, exists := dc.datasets[name]
+	if !exists {
+		return name, "", false
+	}
+	if !pinned {
+		version = dataset.Version
+	}
+	return name, version, true
+}
+
+// datasets returns the datasets a node holds, whether reported by its agent or advertised
+// by label. A dataset reported both ways is listed once, as reported.
+func (node *EdgeNode) datasets() []LocalDataset {
+	datasets := make([]LocalDataset, 0, len(node.Datasets))
+	datasets = append(datasets, node.Datasets...)
+	for key, version := range node.Labels {
+		name := strings.TrimPrefix(key, DatasetLabelPrefix)
+		if name == key || node.dataset(name) != nil {
+			continue
+		}
+		datasets = append(datasets, LocalDataset{Name: name, Version: version, Source: "label"})
+	}
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].Name < datasets[j].Name
+	})
+	return datasets
+}
+
+// dataset returns the reported copy of a dataset on the node, or nil
+func (node *EdgeNode) dataset(name string) *LocalDataset {
+	for i := range node.Datasets {
+		if node.Datasets[i].Name == name {
+			return &node.Datasets[i]
+		}
+	}
+	return nil
+}
+
+// datasetVersion returns the version of a dataset the node holds
+func (node *EdgeNode) datasetVersion(name string) (string, bool) {
+	if dataset := node.dataset(name); dataset != nil {
+		return dataset.Version, true
+	}
+	version, ok := node.Labels[DatasetLabelPrefix+name]
+	return version, ok
+}
+
+// holdsDatasets reports whether a node holds every dataset named by a dataset constraint.
+// Datasets missing from the catalog match no node.
+func (co *CentralOrchestrator) holdsDatasets(node *EdgeNode, values []string) bool {
+	for _, value := range values {
+		name, required, cataloged := co.DatasetCatalog.requiredVersion(value)
+		if !cataloged {
+			return false
+		}
+		version, ok := node.datasetVersion(name)
+		if !ok || (required != "" && version != required) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateDatasetConstraints checks that every dataset a workload is constrained to is in
+// the catalog, so a typo fails at deploy time instead of leaving the workload pending
+func (co *CentralOrchestrator) validateDatasetConstraints(constraints []PlacementConstraint) error {
+	for _, constraint := range constraints {
+		if constraint.Key != DatasetConstraintKey || !constraint.requires() {
+			continue
+		}
+		for _, value := range constraint.Values {
+			if _, _, cataloged := co.DatasetCatalog.requiredVersion(value); !cataloged {
+				return fmt.Errorf("dataset %s is not in the catalog", value)
+			}
+		}
+	}
+	return nil
+}
+
+// datasetKeys returns "name@version" for each dataset, to detect changes between reports
+func datasetKeys(datasets []LocalDataset) []string {
+	keys := make([]string, 0, len(datasets))
+	for _, dataset := range datasets {
+		keys = append(keys, dataset.Name+"@"+dataset.Version)
+	}
+	return keys
+}
+
+// datasetView returns a catalog entry with the nodes holding it; callers must hold the
+// NodeManager lock
+func (co *CentralOrchestrator) datasetView(dataset Dataset) DatasetView {
+	view := DatasetView{Dataset: dataset, Holders: make([]DatasetHolder, 0)}
+	for _, node := range co.NodeManager.nodes {
+		for _, local := range node.datasets() {
+			if local.Name != dataset.Name {
+				continue
+			}
+			view.Holders = append(view.Holders, DatasetHolder{
+				NodeID:    node.ID,
+				NodeName:  node.Name,
+				SiteID:    node.SiteID,
+				Version:   local.Version,
+				Path:      local.Path,
+				SizeBytes: local.SizeBytes,
+				Source:    local.Source,
+				Current:   dataset.Version == "" || local.Version == dataset.Version,
+			})
+		}
+	}
+	sort.Slice(view.Holders, func(i, j int) bool {
+		return view.Holders[i].NodeName < view.Holders[j].NodeName
+	})
+	return view
+}
+
+// datasetHolderIDs returns the nodes holding any copy of a dataset
+func (co *CentralOrchestrator) datasetHolderIDs(name string) []string {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	var nodeIDs []string
+	for _, node := range co.NodeManager.nodes {
+		if _, ok := node.datasetVersion(name); ok {
+			nodeIDs = append(nodeIDs, node.ID)
+		}
+	}
+	return nodeIDs
+}
+
+// CreateDataset adds a dataset to the catalog
+func (co *CentralOrchestrator) CreateDataset(c *gin.Context) {
+	var req DatasetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !datasetNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Dataset name must be lowercase alphanumerics, '.', '_' or '-', at most 63 characters"})
+		return
+	}
+	if req.Tenant == "" {
+		req.Tenant = DefaultTenant
+	}
+
+	now := time.Now()
+	dataset := &Dataset{DatasetRequest: req, CreatedAt: now, UpdatedAt: now}
+
+	co.DatasetCatalog.mutex.Lock()
+	if _, exists := co.DatasetCatalog.datasets[req.Name]; exists {
+		co.DatasetCatalog.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Dataset already exists"})
+		return
+	}
+	co.DatasetCatalog.datasets[req.Name] = dataset
+	co.DatasetCatalog.mutex.Unlock()
+
+	co.Logger.Infof("Dataset %s added to the catalog", req.Name)
+
+	// Nodes already holding the dataset now qualify for workloads waiting on it
+	for _, nodeID := range co.datasetHolderIDs(req.Name) {
+		co.reevaluatePlacement(nodeID, map[string]bool{DatasetConstraintKey: true})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"dataset": dataset})
+}
+
+// ListDatasets lists the catalog with the nodes holding each dataset. Datasets of tenants the
+// caller may not act on are left out; those without a tenant are shared.
+func (co *CentralOrchestrator) ListDatasets(c *gin.Context) {
+	co.DatasetCatalog.mutex.RLock()
+	datasets := make([]Dataset, 0, len(co.DatasetCatalog.datasets))
+	for _, dataset := range co.DatasetCatalog.datasets {
+		if tenant := c.Query("tenant"); tenant != "" && dataset.Tenant != tenant {
+			continue
+		}
+		if dataset.Tenant != "" && !tenantAllowed(c, dataset.Tenant) {
+			continue
+		}
+		datasets = append(datasets, *dataset)
+	}
+	co.DatasetCatalog.mutex.RUnlock()
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].Name < datasets[j].Name
+	})
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	views := make([]DatasetView, 0, len(datasets))
+	for _, dataset := range datasets {
+		views = append(views, co.datasetView(dataset))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"datasets": views})
+}
+
+// GetDataset returns a catalog entry and the nodes holding it
+func (co *CentralOrchestrator) GetDataset(c *gin.Context) {
+	co.DatasetCatalog.mutex.RLock()
+	var dataset Dataset
+	entry, exists := co.DatasetCatalog.datasets[c.Param("name")]
+	if exists {
+		dataset = *entry
+	}
+	co.DatasetCatalog.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+		return
+	}
+	if dataset.Tenant != "" && !tenantAllowed(c, dataset.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", dataset.Tenant)})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"dataset": co.datasetView(dataset)})
+}
+
+// UpdateDataset changes a catalog entry. Changing the version re-evaluates placement on
+// every node holding the dataset, since stale copies no longer qualify.
+func (co *CentralOrchestrator) UpdateDataset(c *gin.Context) {
+	var req DatasetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := c.Param("name")
+	if req.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Dataset name cannot be changed"})
+		return
+	}
+
+	co.DatasetCatalog.mutex.Lock()
+	dataset, exists := co.DatasetCatalog.datasets[name]
+	if !exists {
+		co.DatasetCatalog.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+		return
+	}
+	if req.Tenant == "" {
+		req.Tenant = dataset.Tenant
+	}
+	versionChanged := req.Version != dataset.Version
+	dataset.DatasetRequest = req
+	dataset.UpdatedAt = time.Now()
+	updated := *dataset
+	co.DatasetCatalog.mutex.Unlock()
+
+	if versionChanged {
+		co.Logger.Infof("Dataset %s is now at version %q", name, req.Version)
+		for _, nodeID := range co.datasetHolderIDs(name) {
+			co.reevaluatePlacement(nodeID, map[string]bool{DatasetConstraintKey: true})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dataset": updated})
+}
+
+// DeleteDataset removes a dataset from the catalog unless a workload is constrained to it
+func (co *CentralOrchestrator) DeleteDataset(c *gin.Context) {
+	name := c.Param("name")
+
+	co.WorkloadManager.mutex.RLock()
+	var users []string
+	for _, workload := range co.WorkloadManager.workloads {
+		for _, constraint := range workload.Placement.Constraints {
+			if constraint.Key != DatasetConstraintKey || !constraint.requires() {
+				continue
+			}
+			for _, value := range constraint.Values {
+				if datasetName, _, _ := strings.Cut(value, "@"); datasetName == name {
+					users = append(users, workload.Name)
+				}
+			}
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+	if len(users) > 0 {
+		sort.Strings(users)
+		c.JSON(http.StatusConflict, gin.H{"error": "Dataset is used by workloads: " + strings.Join(users, ", ")})
+		return
+	}
+
+	co.DatasetCatalog.mutex.Lock()
+	_, exists := co.DatasetCatalog.datasets[name]
+	delete(co.DatasetCatalog.datasets, name)
+	co.DatasetCatalog.mutex.Unlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+		return
+	}
+
+	co.Logger.Infof("Dataset %s removed from the catalog", name)
+	c.JSON(http.StatusOK, gin.H{"message": "Dataset deleted"})
+}
+
+// ReportNodeDatasets replaces the datasets a node's agent found on disk. A changed set
+// re-evaluates the placement of dataset-bound workloads.
+func (co *CentralOrchestrator) ReportNodeDatasets(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req DatasetReport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range req.Datasets {
+		if !datasetNamePattern.MatchString(req.Datasets[i].Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid dataset name %q", req.Datasets[i].Name)})
+			return
+		}
+		req.Datasets[i].Source = "agent"
+	}
+
+	co.NodeManager.mutex.Lock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		co.NodeManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+	previous := node.Datasets
+	node.Datasets = req.Datasets
+	node.UpdatedAt = time.Now()
+	co.NodeManager.mutex.Unlock()
+
+	if !sameStringSet(datasetKeys(previous), datasetKeys(req.Datasets)) {
+		co.Logger.Infof("Node %s now holds %d datasets", nodeID, len(req.Datasets))
+		co.reevaluatePlacement(nodeID, map[string]bool{DatasetConstraintKey: true})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Datasets updated"})
+}
+
+// GetNodeDatasets lists the datasets a node holds, reported or advertised by label
+func (co *CentralOrchestrator) GetNodeDatasets(c *gin.Context) {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	node, exists := co.NodeManager.nodes[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"datasets": node.datasets()})
+}
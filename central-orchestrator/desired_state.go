@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Number of past desired-state versions kept per node for computing patches
+	DesiredStateHistory = 8
+)
+
+// PatchOperation is a JSON Patch (RFC 6902) operation; only add, remove and replace are produced
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// DesiredStateResponse carries a node's desired state as a full document or as a patch
+// against a version the agent already has. Agents verify the patched document against
+// Hash and request the full document on mismatch.
+type DesiredStateResponse struct {
+	Hash      string                 `json:"hash"`
+	BaseHash  string                 `json:"base_hash,omitempty"`
+	Unchanged bool                   `json:"unchanged,omitempty"`
+	Patch     []PatchOperation       `json:"patch,omitempty"`
+	Document  map[string]interface{} `json:"document,omitempty"`
+}
+
+// desiredStateVersion is one recorded version of a node's desired state
+type desiredStateVersion struct {
+	hash     string
+	document map[string]interface{}
+}
+
+// DesiredStateCache keeps recent desired-state versions per node
+type DesiredStateCache struct {
+	history map[string][]desiredStateVersion
+	mutex   sync.Mutex
+	logger  *logrus.Logger
+}
+
+// NewDesiredStateCache creates a new desired state cache
+func NewDesiredStateCache(logger *logrus.Logger) *DesiredStateCache {
+	return &DesiredStateCache{
+		history: make(map[string][]desiredStateVersion),
+		logger:  logger,
+	}
+}
+
+// record stores a version if it differs from the latest and returns the node's history
+func (dc *DesiredStateCache) record(nodeID string, version desiredStateVersion) []desiredStateVersion {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	history := dc.history[nodeID]
+	if len(history) == 0 || history[len(history)-1].hash != version.hash {
+		history = append(history, version)
+		if len(history) > DesiredStateHistory {
+			history = history[len(history)-DesiredStateHistory:]
+		}
+		dc.history[nodeID] = history
+	}
+	return history
+}
+
+// forget drops a node's history
+func (dc *DesiredStateCache) forget(nodeID string) {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	delete(dc.history, nodeID)
+}
+
+// volatileWorkloadFields change without the agent needing to act and are left out of the
+// desired state so they do not produce patches
+var volatileWorkloadFields = []string{"metadata", "autoscaling", "image_subscription", "job_status", "blue_green", "blue_green_of", "revision", "status", "scheduling", "deployments", "created_at", "updated_at"}
+
+// buildDesiredState returns the canonical desired-state document for a node, keyed by
+// workload ID; callers must hold the WorkloadManager lock
+func (co *CentralOrchestrator) buildDesiredState(nodeID string) (desiredStateVersion, error) {
+	workloads := make(map[string]interface{})
+	for _, workload := range co.WorkloadManager.workloads {
+		deployment := workload.deploymentFor(nodeID)
+		if deployment == nil || (deployment.Status != WorkloadStatusRunning && deployment.Status != WorkloadStatusPending) {
+			continue
+		}
+
+		data, err := json.Marshal(workload)
+		if err != nil {
+			return desiredStateVersion{}, fmt.Errorf("failed to marshal workload %s: %v", workload.ID, err)
+		}
+		var spec map[string]interface{}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return desiredStateVersion{}, fmt.Errorf("failed to unmarshal workload %s: %v", workload.ID, err)
+		}
+		for _, field := range volatileWorkloadFields {
+			delete(spec, field)
+		}
+		spec["node_replicas"] = float64(deployment.Replicas)
+		if workload.isJob() && deployment.Attempt > 0 {
+			spec["node_attempt"] = float64(deployment.Attempt)
+		}
+		// The service selects the new version's pods once traffic is switched to it
+		if workload.BlueGreen != nil && workload.BlueGreen.servesPreview() {
+			if preview, exists := co.WorkloadManager.workloads[workload.BlueGreen.PreviewWorkloadID]; exists {
+				selector := make(map[string]interface{}, len(preview.Selector))
+				for key, value := range preview.Selector {
+					selector[key] = value
+				}
+				spec["service_selector"] = selector
+			}
+		}
+		workloads[workload.ID] = spec
+	}
+
+	document := map[string]interface{}{"workloads": workloads}
+	hash, err := hashDocument(document)
+	if err != nil {
+		return desiredStateVersion{}, err
+	}
+	return desiredStateVersion{hash: hash, document: document}, nil
+}
+
+// hashDocument returns the SHA-256 of the document's canonical JSON (map keys sorted)
+func hashDocument(document map[string]interface{}) (string, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal desired state: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// escapePointer escapes a key for use in a JSON Pointer
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// diffDocuments appends the operations that turn from into to. Objects are diffed per key;
+// anything else is replaced whole.
+func diffDocuments(path string, from, to interface{}, ops []PatchOperation) []PatchOperation {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if !fromIsMap || !toIsMap {
+		if !reflect.DeepEqual(from, to) {
+			ops = append(ops, PatchOperation{Op: "replace", Path: path, Value: to})
+		}
+		return ops
+	}
+
+	keys := make([]string, 0, len(fromMap)+len(toMap))
+	for key := range fromMap {
+		keys = append(keys, key)
+	}
+	for key := range toMap {
+		if _, exists := fromMap[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "/" + escapePointer(key)
+		fromValue, inFrom := fromMap[key]
+		toValue, inTo := toMap[key]
+		switch {
+		case !inTo:
+			ops = append(ops, PatchOperation{Op: "remove", Path: childPath})
+		case !inFrom:
+			ops = append(ops, PatchOperation{Op: "add", Path: childPath, Value: toValue})
+		default:
+			ops = diffDocuments(childPath, fromValue, toValue, ops)
+		}
+	}
+	return ops
+}
+
+// GetNodeDesiredState returns a node's desired state. With since=<hash> of a recent
+// version it returns only a JSON Patch from that version, or unchanged when nothing moved.
+func (co *CentralOrchestrator) GetNodeDesiredState(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.NodeManager.mutex.RLock()
+	_, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	current, err := co.buildDesiredState(nodeID)
+	co.WorkloadManager.mutex.RUnlock()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	history := co.DesiredStateCache.record(nodeID, current)
+
+	since := c.Query("since")
+	if since == current.hash {
+		c.JSON(http.StatusOK, DesiredStateResponse{Hash: current.hash, Unchanged: true})
+		return
+	}
+
+	if since != "" {
+		for _, version := range history {
+			if version.hash == since {
+				c.JSON(http.StatusOK, DesiredStateResponse{
+					Hash:     current.hash,
+					BaseHash: since,
+					Patch:    diffDocuments("", version.document, current.document, make([]PatchOperation, 0)),
+				})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, DesiredStateResponse{Hash: current.hash, Document: current.document})
+}
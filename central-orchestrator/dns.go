@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DNS reconciliation interval
+	DNSReconcileInterval = 30 * time.Second
+
+	// Default TTL for managed records
+	DefaultDNSRecordTTL = 60
+)
+
+// DNSRoutingPolicy defines how a workload's endpoints are published in DNS
+type DNSRoutingPolicy string
+
+const (
+	// One record per site, e.g. api.<site ID>.example.com
+	DNSRoutingPerSite DNSRoutingPolicy = "per-site"
+	// One record per region plus a global record with region routing hints
+	DNSRoutingGeo DNSRoutingPolicy = "geo"
+	// A global record set with one member per region, for latency-based resolution
+	DNSRoutingLatency DNSRoutingPolicy = "latency"
+)
+
+// WorkloadDNS configures DNS publication of a workload's service endpoints
+type WorkloadDNS struct {
+	Hostname string           `json:"hostname"`
+	Routing  DNSRoutingPolicy `json:"routing"`
+	TTL      int              `json:"ttl"`
+}
+
+// DNSRecord is a record managed by the orchestrator in an external DNS provider
+type DNSRecord struct {
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Targets       []string  `json:"targets"`
+	TTL           int       `json:"ttl"`
+	SetIdentifier string    `json:"set_identifier,omitempty"`
+	Region        string    `json:"region,omitempty"`
+	WorkloadID    string    `json:"workload_id"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// key uniquely identifies a record within the provider
+func (r *DNSRecord) key() string {
+	return r.Name + "/" + r.Type + "/" + r.SetIdentifier
+}
+
+// equal reports whether two records would produce the same provider state
+func (r *DNSRecord) equal(other *DNSRecord) bool {
+	if r.TTL != other.TTL || r.Region != other.Region || len(r.Targets) != len(other.Targets) {
+		return false
+	}
+	for i := range r.Targets {
+		if r.Targets[i] != other.Targets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DNSProvider creates and removes records in an external DNS service
+type DNSProvider interface {
+	Name() string
+	UpsertRecord(ctx context.Context, record *DNSRecord) error
+	DeleteRecord(ctx context.Context, record *DNSRecord) error
+}
+
+// DNSManager keeps DNS records in sync with reported workload endpoints
+type DNSManager struct {
+	provider DNSProvider
+	zone     string
+	records  map[string]*DNSRecord
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewDNSManager creates a DNS manager configured from the environment.
+// DNS management is disabled when DNS_PROVIDER is unset.
+func NewDNSManager(logger *logrus.Logger) *DNSManager {
+	dm := &DNSManager{
+		zone:    strings.TrimSuffix(os.Getenv("DNS_ZONE"), "."),
+		records: make(map[string]*DNSRecord),
+		logger:  logger,
+	}
+
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+
+	switch os.Getenv("DNS_PROVIDER") {
+	case "":
+		return dm
+	case "cloudflare":
+		dm.provider = &cloudflareDNSProvider{
+			apiToken:   os.Getenv("CLOUDFLARE_API_TOKEN"),
+			zoneID:     os.Getenv("CLOUDFLARE_ZONE_ID"),
+			httpClient: httpClient,
+		}
+	case "route53":
+		dm.provider = &route53DNSProvider{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			hostedZoneID:    os.Getenv("ROUTE53_HOSTED_ZONE_ID"),
+			httpClient:      httpClient,
+		}
+	case "webhook":
+		dm.provider = &webhookDNSProvider{
+			url:        strings.TrimSuffix(os.Getenv("DNS_WEBHOOK_URL"), "/"),
+			httpClient: httpClient,
+		}
+	default:
+		logger.Warnf("Unknown DNS provider %q, DNS management disabled", os.Getenv("DNS_PROVIDER"))
+		return dm
+	}
+
+	if dm.zone == "" {
+		logger.Warn("DNS_ZONE is not set, DNS management disabled")
+		dm.provider = nil
+		return dm
+	}
+
+	logger.Infof("DNS management enabled with provider %s for zone %s", dm.provider.Name(), dm.zone)
+	return dm
+}
+
+// Enabled reports whether a DNS provider is configured
+func (dm *DNSManager) Enabled() bool {
+	return dm.provider != nil
+}
+
+// dnsReconciler periodically reconciles DNS records
+func (co *CentralOrchestrator) dnsReconciler() {
+	if !co.DNSManager.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(DNSReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.reconcileDNS()
+		}
+	}
+}
+
+// reconcileDNS creates, updates and removes records so they match live endpoints
+func (co *CentralOrchestrator) reconcileDNS() {
+	desired := co.desiredDNSRecords()
+	dm := co.DNSManager
+	ctx := context.Background()
+
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	for key, record := range desired {
+		if current, exists := dm.records[key]; exists && current.equal(record) {
+			continue
+		}
+		if err := dm.provider.UpsertRecord(ctx, record); err != nil {
+			co.Logger.Errorf("Failed to upsert DNS record %s: %v", record.Name, err)
+			continue
+		}
+		record.UpdatedAt = time.Now()
+		dm.records[key] = record
+		co.Logger.Infof("DNS record %s %s -> %s", record.Type, record.Name, strings.Join(record.Targets, ","))
+	}
+
+	for key, record := range dm.records {
+		if _, exists := desired[key]; exists {
+			continue
+		}
+		if err := dm.provider.DeleteRecord(ctx, record); err != nil {
+			co.Logger.Errorf("Failed to delete DNS record %s: %v", record.Name, err)
+			continue
+		}
+		delete(dm.records, key)
+		co.Logger.Infof("DNS record %s %s removed", record.Type, record.Name)
+	}
+}
+
+// desiredDNSRecords computes the records implied by running deployments on online nodes
+func (co *CentralOrchestrator) desiredDNSRecords() map[string]*DNSRecord {
+	co.NodeManager.mutex.RLock()
+	nodes := make(map[string]*EdgeNode, len(co.NodeManager.nodes))
+	for id, node := range co.NodeManager.nodes {
+		if node.Status == NodeStatusOnline {
+			nodes[id] = node
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	desired := make(map[string]*DNSRecord)
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.DNS == nil || workload.DNS.Hostname == "" {
+			continue
+		}
+
+		// Group endpoint addresses by site and region. Cluster IPs are only reachable inside
+		// their node's cluster and are left out.
+		bySite := make(map[string][]string)
+		byRegion := make(map[string][]string)
+		for _, deployment := range workload.Deployments {
+			node, online := nodes[deployment.NodeID]
+			if !online || deployment.Status != WorkloadStatusRunning {
+				continue
+			}
+			for _, endpoint := range deployment.Endpoints {
+				if endpoint.Address == "" || !endpoint.external() {
+					continue
+				}
+				// Nodes outside any site only take part in region records
+				if node.SiteID != "" {
+					bySite[node.SiteID] = append(bySite[node.SiteID], endpoint.Address)
+				}
+				byRegion[node.Region] = append(byRegion[node.Region], endpoint.Address)
+			}
+		}
+
+		ttl := workload.DNS.TTL
+		if ttl <= 0 {
+			ttl = DefaultDNSRecordTTL
+		}
+		host := workload.DNS.Hostname
+
+		add := func(name, setID, region string, targets []string) {
+			record := newDNSRecord(name, targets, ttl)
+			if record == nil {
+				return
+			}
+			record.SetIdentifier = setID
+			record.Region = region
+			record.WorkloadID = workload.ID
+			desired[record.key()] = record
+		}
+
+		switch workload.DNS.Routing {
+		case DNSRoutingGeo:
+			var all []string
+			for region, targets := range byRegion {
+				add(host+"."+region+"."+co.DNSManager.zone, "", "", targets)
+				all = append(all, targets...)
+			}
+			add(host+"."+co.DNSManager.zone, "", "", all)
+		case DNSRoutingLatency:
+			for region, targets := range byRegion {
+				add(host+"."+co.DNSManager.zone, region, region, targets)
+			}
+		default:
+			for site, targets := range bySite {
+				add(host+"."+site+"."+co.DNSManager.zone, "", "", targets)
+			}
+		}
+	}
+
+	return desired
+}
+
+// newDNSRecord builds an A record for IP targets or a CNAME for a single hostname target
+func newDNSRecord(name string, targets []string, ttl int) *DNSRecord {
+	var ips, hosts []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		if ip := net.ParseIP(target); ip != nil && ip.To4() != nil {
+			ips = append(ips, target)
+		} else if ip == nil {
+			hosts = append(hosts, target)
+		}
+	}
+
+	record := &DNSRecord{Name: strings.ToLower(name), TTL: ttl}
+	switch {
+	case len(ips) > 0:
+		sort.Strings(ips)
+		record.Type = "A"
+		record.Targets = ips
+	case len(hosts) > 0:
+		sort.Strings(hosts)
+		record.Type = "CNAME"
+		record.Targets = hosts[:1]
+	default:
+		return nil
+	}
+	return record
+}
+
+// ListDNSRecords returns the DNS records currently managed by the orchestrator
+func (co *CentralOrchestrator) ListDNSRecords(c *gin.Context) {
+	view := co.tenantView(c)
+	co.DNSManager.mutex.RLock()
+	defer co.DNSManager.mutex.RUnlock()
+
+	records := make([]*DNSRecord, 0, len(co.DNSManager.records))
+	for _, record := range co.DNSManager.records {
+		if workloadID := c.Query("workload_id"); workloadID != "" && record.WorkloadID != workloadID {
+			continue
+		}
+		if !view.workload(record.WorkloadID) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	provider := ""
+	if co.DNSManager.Enabled() {
+		provider = co.DNSManager.provider.Name()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"provider": provider,
+		"zone":     co.DNSManager.zone,
+		"records":  records,
+		"count":    len(records),
+	})
+}
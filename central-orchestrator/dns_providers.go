@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cloudflareDNSProvider manages records through the Cloudflare v4 API
+type cloudflareDNSProvider struct {
+	apiToken   string
+	zoneID     string
+	httpClient *http.Client
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool               `json:"success"`
+	Errors  []json.RawMessage  `json:"errors"`
+	Result  []cloudflareRecord `json:"result"`
+}
+
+func (p *cloudflareDNSProvider) Name() string {
+	return "cloudflare"
+}
+
+// UpsertRecord makes the set of records named record.Name match record.Targets
+func (p *cloudflareDNSProvider) UpsertRecord(ctx context.Context, record *DNSRecord) error {
+	existing, err := p.list(ctx, record.Name)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(record.Targets))
+	for _, target := range record.Targets {
+		wanted[target] = true
+	}
+
+	for _, current := range existing {
+		if current.Type == record.Type && wanted[current.Content] && current.TTL == record.TTL {
+			delete(wanted, current.Content)
+			continue
+		}
+		if err := p.do(ctx, "DELETE", "/dns_records/"+current.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range record.Targets {
+		if !wanted[target] {
+			continue
+		}
+		body := cloudflareRecord{Type: record.Type, Name: record.Name, Content: target, TTL: record.TTL}
+		if err := p.do(ctx, "POST", "/dns_records", body, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteRecord removes every record named record.Name
+func (p *cloudflareDNSProvider) DeleteRecord(ctx context.Context, record *DNSRecord) error {
+	existing, err := p.list(ctx, record.Name)
+	if err != nil {
+		return err
+	}
+	for _, current := range existing {
+		if err := p.do(ctx, "DELETE", "/dns_records/"+current.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareDNSProvider) list(ctx context.Context, name string) ([]cloudflareRecord, error) {
+	var resp cloudflareResponse
+	if err := p.do(ctx, "GET", "/dns_records?name="+url.QueryEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+func (p *cloudflareDNSProvider) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	endpoint := "https://api.cloudflare.com/client/v4/zones/" + p.zoneID + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cloudflare %s %s returned status %d: %s", method, path, resp.StatusCode, string(data))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode cloudflare response: %v", err)
+		}
+	}
+	return nil
+}
+
+// route53DNSProvider manages records in an AWS Route53 hosted zone, signing its requests
+// with AWS Signature Version 4
+type route53DNSProvider struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	hostedZoneID    string
+	httpClient      *http.Client
+}
+
+const (
+	route53Endpoint = "https://route53.amazonaws.com/2013-04-01"
+	route53XMLNS    = "https://route53.amazonaws.com/doc/2013-04-01/"
+	// Route53 is a global service signed for us-east-1
+	route53SigningRegion = "us-east-1"
+)
+
+type route53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+// Fields are in the order the Route53 schema requires
+type route53RecordSet struct {
+	Name            string                  `xml:"Name"`
+	Type            string                  `xml:"Type"`
+	SetIdentifier   string                  `xml:"SetIdentifier,omitempty"`
+	Region          string                  `xml:"Region,omitempty"`
+	TTL             int                     `xml:"TTL"`
+	ResourceRecords []route53ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53Change struct {
+	Action            string           `xml:"Action"`
+	ResourceRecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+func (p *route53DNSProvider) Name() string {
+	return "route53"
+}
+
+// UpsertRecord creates the record set or replaces its targets. Latency-routed records
+// carry their set identifier and AWS region.
+func (p *route53DNSProvider) UpsertRecord(ctx context.Context, record *DNSRecord) error {
+	return p.change(ctx, "UPSERT", record)
+}
+
+// DeleteRecord removes the record set; one that is already gone is not an error
+func (p *route53DNSProvider) DeleteRecord(ctx context.Context, record *DNSRecord) error {
+	err := p.change(ctx, "DELETE", record)
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return nil
+	}
+	return err
+}
+
+func (p *route53DNSProvider) change(ctx context.Context, action string, record *DNSRecord) error {
+	set := route53RecordSet{
+		Name:          record.Name,
+		Type:          record.Type,
+		SetIdentifier: record.SetIdentifier,
+		TTL:           record.TTL,
+	}
+	if record.SetIdentifier != "" {
+		set.Region = record.Region
+	}
+	for _, target := range record.Targets {
+		set.ResourceRecords = append(set.ResourceRecords, route53ResourceRecord{Value: target})
+	}
+	request := route53ChangeRequest{XMLNS: route53XMLNS, Changes: []route53Change{{Action: action, ResourceRecordSet: set}}}
+	data, err := xml.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	data = append([]byte(xml.Header), data...)
+
+	path := "/hostedzone/" + strings.TrimPrefix(p.hostedZoneID, "/hostedzone/") + "/rrset"
+	req, err := http.NewRequestWithContext(ctx, "POST", route53Endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	p.sign(req, data, time.Now().UTC())
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("route53 request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("route53 %s %s returned status %d: %s", action, record.Name, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to a Route53 request
+func (p *route53DNSProvider) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	signed := []string{"content-type", "host", "x-amz-date"}
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		headers.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + route53SigningRegion + "/route53/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := []byte("AWS4" + p.secretAccessKey)
+	for _, part := range []string{date, route53SigningRegion, "route53", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// webhookDNSProvider speaks the external-dns webhook provider protocol, which lets
+// any external-dns provider (Route53, Azure DNS, PowerDNS, ...) back the orchestrator
+type webhookDNSProvider struct {
+	url        string
+	httpClient *http.Client
+}
+
+const externalDNSMediaType = "application/external.dns.webhook+json;version=1"
+
+type externalDNSProviderSpecific struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type externalDNSEndpoint struct {
+	DNSName          string                        `json:"dnsName"`
+	Targets          []string                      `json:"targets"`
+	RecordType       string                        `json:"recordType"`
+	SetIdentifier    string                        `json:"setIdentifier,omitempty"`
+	RecordTTL        int                           `json:"recordTTL,omitempty"`
+	Labels           map[string]string             `json:"labels,omitempty"`
+	ProviderSpecific []externalDNSProviderSpecific `json:"providerSpecific,omitempty"`
+}
+
+type externalDNSChanges struct {
+	Create    []externalDNSEndpoint `json:"Create"`
+	UpdateOld []externalDNSEndpoint `json:"UpdateOld"`
+	UpdateNew []externalDNSEndpoint `json:"UpdateNew"`
+	Delete    []externalDNSEndpoint `json:"Delete"`
+}
+
+func (p *webhookDNSProvider) Name() string {
+	return "webhook"
+}
+
+func (p *webhookDNSProvider) UpsertRecord(ctx context.Context, record *DNSRecord) error {
+	existing, err := p.find(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	changes := externalDNSChanges{}
+	if existing != nil {
+		changes.UpdateOld = []externalDNSEndpoint{*existing}
+		changes.UpdateNew = []externalDNSEndpoint{toExternalDNSEndpoint(record)}
+	} else {
+		changes.Create = []externalDNSEndpoint{toExternalDNSEndpoint(record)}
+	}
+	return p.do(ctx, "POST", "/records", changes, nil)
+}
+
+func (p *webhookDNSProvider) DeleteRecord(ctx context.Context, record *DNSRecord) error {
+	existing, err := p.find(ctx, record)
+	if err != nil || existing == nil {
+		return err
+	}
+	return p.do(ctx, "POST", "/records", externalDNSChanges{Delete: []externalDNSEndpoint{*existing}}, nil)
+}
+
+func (p *webhookDNSProvider) find(ctx context.Context, record *DNSRecord) (*externalDNSEndpoint, error) {
+	var endpoints []externalDNSEndpoint
+	if err := p.do(ctx, "GET", "/records", nil, &endpoints); err != nil {
+		return nil, err
+	}
+	for i := range endpoints {
+		e := &endpoints[i]
+		if e.DNSName == record.Name && e.RecordType == record.Type && e.SetIdentifier == record.SetIdentifier {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+func toExternalDNSEndpoint(record *DNSRecord) externalDNSEndpoint {
+	endpoint := externalDNSEndpoint{
+		DNSName:       record.Name,
+		Targets:       record.Targets,
+		RecordType:    record.Type,
+		SetIdentifier: record.SetIdentifier,
+		RecordTTL:     record.TTL,
+		Labels:        map[string]string{"owner": "edge-orchestrator", "workload-id": record.WorkloadID},
+	}
+	if record.Region != "" {
+		endpoint.ProviderSpecific = []externalDNSProviderSpecific{
+			{Name: "aws/region", Value: record.Region},
+		}
+	}
+	return endpoint
+}
+
+func (p *webhookDNSProvider) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.url+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", externalDNSMediaType)
+	if payload != nil {
+		req.Header.Set("Content-Type", externalDNSMediaType)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("dns webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("dns webhook %s %s returned status %d: %s", method, path, resp.StatusCode, string(data))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode dns webhook response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestDesiredDNSRecords checks that only node port and load balancer endpoints are
+// published, grouped by site ID
+func TestDesiredDNSRecords(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	co := &CentralOrchestrator{
+		NodeManager:     NewNodeManager(logger),
+		WorkloadManager: NewWorkloadManager(logger),
+		DNSManager:      &DNSManager{zone: "example.com", records: make(map[string]*DNSRecord), logger: logger},
+		Logger:          logger,
+	}
+	co.NodeManager.nodes["node-a"] = &EdgeNode{ID: "node-a", Status: NodeStatusOnline, SiteID: "site-a", Zone: "zone-1", Region: "eu"}
+	co.NodeManager.nodes["node-b"] = &EdgeNode{ID: "node-b", Status: NodeStatusOnline, SiteID: "site-b", Zone: "zone-1", Region: "eu"}
+	co.WorkloadManager.workloads["w-1"] = &Workload{
+		ID:  "w-1",
+		DNS: &WorkloadDNS{Hostname: "api", Routing: DNSRoutingPerSite},
+		Deployments: []WorkloadDeployment{
+			{NodeID: "node-a", Status: WorkloadStatusRunning, Endpoints: []ServiceEndpoint{
+				{Address: "10.96.0.10", Port: 80, Type: "ClusterIP"},
+				{Address: "192.0.2.1", Port: 30080, Type: "NodePort"},
+			}},
+			{NodeID: "node-b", Status: WorkloadStatusRunning, Endpoints: []ServiceEndpoint{
+				{Address: "198.51.100.1", Port: 80, Type: "LoadBalancer"},
+			}},
+		},
+	}
+
+	records := co.desiredDNSRecords()
+	want := map[string]string{
+		"api.site-a.example.com": "192.0.2.1",
+		"api.site-b.example.com": "198.51.100.1",
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Got %d records, want %d", len(records), len(want))
+	}
+	for _, record := range records {
+		if len(record.Targets) != 1 || record.Targets[0] != want[record.Name] {
+			t.Errorf("Record %s targets %v, want %s", record.Name, record.Targets, want[record.Name])
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Interval between checks of a drain's migrations
+	DrainCheckInterval = 5 * time.Second
+)
+
+// DrainRequest configures a node drain
+type DrainRequest struct {
+	Reason string `json:"reason"`
+	// Time each moved replica has to become ready on its new node
+	ReadyTimeoutSeconds int32 `json:"ready_timeout_seconds"`
+}
+
+// CordonNode keeps new replicas off a node; replicas already on it keep running
+func (co *CentralOrchestrator) CordonNode(c *gin.Context) {
+	node, ok := co.setNodeUnschedulable(c, true)
+	if !ok {
+		return
+	}
+	co.Logger.Infof("Node %s cordoned", node.Name)
+	co.AuditLog.RecordRequest(c, "node.cordon", "node:"+node.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"node": node})
+}
+
+// UncordonNode returns a cordoned or drained node to scheduling
+func (co *CentralOrchestrator) UncordonNode(c *gin.Context) {
+	node, ok := co.setNodeUnschedulable(c, false)
+	if !ok {
+		return
+	}
+	co.Logger.Infof("Node %s uncordoned", node.Name)
+	co.AuditLog.RecordRequest(c, "node.uncordon", "node:"+node.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"node": node})
+}
+
+// setNodeUnschedulable cordons or uncordons the node of the request. Uncordoning also ends
+// maintenance; the health check marks the node offline again if it stays silent.
+func (co *CentralOrchestrator) setNodeUnschedulable(c *gin.Context, unschedulable bool) (*EdgeNode, bool) {
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	node, exists := co.NodeManager.nodes[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return nil, false
+	}
+	node.Unschedulable = unschedulable
+	if !unschedulable && node.Status == NodeStatusMaintenance {
+		node.Status = NodeStatusOnline
+	}
+	node.UpdatedAt = time.Now()
+	return node, true
+}
+
+// DrainNode cordons a node and migrates its replicas to the best other nodes for their
+// placement policies. The node enters maintenance once every replica has moved.
+func (co *CentralOrchestrator) DrainNode(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req DrainRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	readyTimeout := DefaultMigrationReadyTimeout
+	if req.ReadyTimeoutSeconds > 0 {
+		readyTimeout = time.Duration(req.ReadyTimeoutSeconds) * time.Second
+	}
+
+	node, ok := co.setNodeUnschedulable(c, true)
+	if !ok {
+		return
+	}
+	co.Logger.Infof("Draining node %s", node.Name)
+	co.AuditLog.RecordRequest(c, "node.drain", "node:"+nodeID, map[string]string{"reason": req.Reason})
+
+	op := co.OperationManager.Start("node-drain", "node "+nodeID)
+	migrations, unplaced := co.startDrainMigrations(nodeID, readyTimeout, op)
+	go co.finishDrain(nodeID, op, migrations, unplaced)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Node is being drained",
+		"migrations": migrations,
+		"operation":  co.localizeOperation(op, requestLocale(c)),
+	})
+}
+
+// startDrainMigrations starts a migration for each replica set placed on the node and
+// returns them, with the names of the workloads no other node can take
+func (co *CentralOrchestrator) startDrainMigrations(nodeID string, readyTimeout time.Duration, op *Operation) ([]*Migration, []string) {
+	online := co.onlineNodes(nil)
+
+	co.WorkloadManager.mutex.RLock()
+	committed := committedResources(co.WorkloadManager.workloads)
+	migrations, unplaced := co.planDrain(nodeID, online, committed, readyTimeout, op)
+	co.WorkloadManager.mutex.RUnlock()
+
+	co.launchMigrations(migrations)
+	return migrations, unplaced
+}
+
+// planDrain picks the best other node for each replica set placed on a node, most critical
+// first, recording each decision on the operation when there is one. It returns the
+// migrations with the names of the workloads no other node can take. Each move is counted
+// against committed so later plans do not count on the room it takes. Callers must hold the
+// WorkloadManager lock.
+func (co *CentralOrchestrator) planDrain(nodeID string, online map[string]*EdgeNode, committed map[string]Commitment, readyTimeout time.Duration, op *Operation) ([]*Migration, []string) {
+	workloads := make([]*Workload, 0, len(co.WorkloadManager.workloads))
+	for _, workload := range co.WorkloadManager.workloads {
+		workloads = append(workloads, workload)
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		a, b := &failoverItem{workload: workloads[i]}, &failoverItem{workload: workloads[j]}
+		if failoverLess(a, b) || failoverLess(b, a) {
+			return failoverLess(a, b)
+		}
+		return workloads[i].ID < workloads[j].ID
+	})
+
+	var migrations []*Migration
+	var unplaced []string
+	for _, workload := range workloads {
+		source := workload.deploymentFor(nodeID)
+		if source == nil || !source.placed() {
+			continue
+		}
+
+		var destination *EdgeNode
+		for _, node := range co.replacementNodes(co.WorkloadManager.workloads, workload, nodeID, online) {
+			if co.fitsOnNode(node, committed[node.ID], workload, source.Replicas) {
+				destination = node
+				break
+			}
+		}
+		if destination == nil {
+			unplaced = append(unplaced, workload.Name)
+			if op != nil {
+				co.OperationManager.AddStep(op, newOperationStep("unschedulable", workload.ID, "", false,
+					newMessage(MsgDrainNoCapacity, "replicas", source.Replicas, "workload", workload.Name, "node", nodeID)))
+			}
+			continue
+		}
+		// Later replica sets must not count on the room this one takes
+		committed[destination.ID] = committed[destination.ID].with(workload, source.Replicas)
+
+		now := time.Now()
+		migrations = append(migrations, &Migration{
+			ID:                generateID(),
+			WorkloadID:        workload.ID,
+			SourceNodeID:      nodeID,
+			DestinationNodeID: destination.ID,
+			Replicas:          source.Replicas,
+			Phase:             MigrationPhasePreparing,
+			ReadyTimeout:      readyTimeout,
+			StartedAt:         now,
+			UpdatedAt:         now,
+		})
+		if op != nil {
+			co.OperationManager.AddStep(op, newOperationStep("migrate", workload.ID, destination.ID, true,
+				newMessage(MsgPlacementMoved, "replicas", source.Replicas, "workload", workload.Name, "from_node", nodeID, "to_node", destination.ID)))
+		}
+	}
+	sort.Strings(unplaced)
+	return migrations, unplaced
+}
+
+// launchMigrations records and runs planned migrations
+func (co *CentralOrchestrator) launchMigrations(migrations []*Migration) {
+	co.MigrationManager.mutex.Lock()
+	for _, migration := range migrations {
+		co.MigrationManager.migrations[migration.ID] = migration
+	}
+	co.MigrationManager.mutex.Unlock()
+
+	for _, migration := range migrations {
+		go co.runMigration(migration)
+	}
+}
+
+// finishDrain waits for a drain's migrations and puts the node into maintenance when all of
+// them succeeded. Otherwise the node stays cordoned with the replicas that could not move.
+func (co *CentralOrchestrator) finishDrain(nodeID string, op *Operation, migrations []*Migration, unplaced []string) {
+	ticker := time.NewTicker(DrainCheckInterval)
+	defer ticker.Stop()
+
+	failed := 0
+	for len(migrations) > 0 {
+		<-ticker.C
+
+		co.MigrationManager.mutex.RLock()
+		var running []*Migration
+		for _, migration := range migrations {
+			switch migration.Phase {
+			case MigrationPhaseCompleted:
+			case MigrationPhaseFailed, MigrationPhaseRolledBack:
+				failed++
+			default:
+				running = append(running, migration)
+			}
+		}
+		co.MigrationManager.mutex.RUnlock()
+		migrations = running
+	}
+
+	if len(unplaced) > 0 || failed > 0 {
+		message := fmt.Sprintf("Node left cordoned with %d workload(s) on it", len(unplaced)+failed)
+		if len(unplaced) > 0 {
+			message += fmt.Sprintf("; no capacity for %s", strings.Join(unplaced, ", "))
+		}
+		co.OperationManager.Complete(op, OperationStatusPartial, message)
+		return
+	}
+
+	co.NodeManager.mutex.Lock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	// An operator may have uncordoned the node while the drain ran
+	cordoned := exists && node.Unschedulable
+	if cordoned {
+		node.Status = NodeStatusMaintenance
+		node.UpdatedAt = time.Now()
+	}
+	co.NodeManager.mutex.Unlock()
+
+	switch {
+	case !exists:
+		co.OperationManager.Complete(op, OperationStatusFailed, "Node was removed during the drain")
+	case !cordoned:
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "All replicas moved; node was uncordoned during the drain")
+	default:
+		co.Logger.Infof("Node %s drained and in maintenance", nodeID)
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "All replicas moved; node is in maintenance")
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Label carrying the environment a workload was instantiated in
+	EnvironmentLabel = "edge.io/environment"
+	// Rollouts kept per environment instance of a workload definition
+	MaxRolloutHistory = 20
+)
+
+// environmentNamePattern matches environment names such as dev, staging or prod-eu
+var environmentNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// Variable names, and the ${NAME} placeholders that refer to them in definitions
+var (
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	variablePlaceholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// RolloutStatus is the progress of one revision of an environment instance
+type RolloutStatus string
+
+const (
+	RolloutStatusProgressing RolloutStatus = "progressing"
+	RolloutStatusComplete    RolloutStatus = "complete"
+	// A later revision was rolled out before this one completed
+	RolloutStatusSuperseded RolloutStatus = "superseded"
+)
+
+// Environment is a stage such as dev, staging or prod that workload definitions are
+// instantiated in. Its node group binds it to nodes: its workloads are placed only there,
+// so prod workloads cannot land on lab nodes.
+type Environment struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	NodeGroup
+	// Variables of every definition instantiated here, over the definition's defaults
+	Variables map[string]string `json:"variables"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// EnvironmentRequest creates or updates an environment
+type EnvironmentRequest struct {
+	Description  string            `json:"description"`
+	NodeSelector map[string]string `json:"node_selector"`
+	SiteID       string            `json:"site_id"`
+	Variables    map[string]string `json:"variables"`
+}
+
+// EnvironmentBinding is the environment a workload was instantiated in, with a copy of the
+// environment's node group that placement checks
+type EnvironmentBinding struct {
+	Name  string    `json:"name"`
+	Nodes NodeGroup `json:"nodes"`
+}
+
+// WorkloadDefinition is a workload spec instantiated once per environment. String values
+// of the spec may hold ${NAME} placeholders, filled in from the variables of the
+// definition, then the environment, then the instance, the later ones winning.
+type WorkloadDefinition struct {
+	ID        string                    `json:"id"`
+	Name      string                    `json:"name"`
+	Spec      WorkloadDeploymentRequest `json:"spec"`
+	Variables map[string]string         `json:"variables"`
+	// Instances by environment name
+	Instances map[string]*DefinitionInstance `json:"instances"`
+	CreatedAt time.Time                      `json:"created_at"`
+	UpdatedAt time.Time                      `json:"updated_at"`
+}
+
+// WorkloadDefinitionRequest creates or updates a workload definition. Updating it does not
+// change its instances until each environment is rolled out again.
+type WorkloadDefinitionRequest struct {
+	Spec      WorkloadDeploymentRequest `json:"spec" binding:"required"`
+	Variables map[string]string         `json:"variables"`
+}
+
+// DefinitionInstance is a workload definition running in one environment. Its rollouts
+// are tracked apart from those of the other environments.
+type DefinitionInstance struct {
+	Environment string            `json:"environment"`
+	WorkloadID  string            `json:"workload_id"`
+	Variables   map[string]string `json:"variables"`
+	// Replica count of this environment; 0 for the spec's
+	Replicas int32                 `json:"replicas,omitempty"`
+	Revision int                   `json:"revision"`
+	Rollouts []*EnvironmentRollout `json:"rollouts"`
+}
+
+// InstanceRequest rolls a workload definition out to an environment
+type InstanceRequest struct {
+	Variables map[string]string `json:"variables"`
+	Replicas  int32             `json:"replicas"`
+}
+
+// EnvironmentRollout is one revision of a definition rolled out to an environment
+type EnvironmentRollout struct {
+	Revision int    `json:"revision"`
+	Image    string `json:"image"`
+	// The resolved variables the revision was rendered with
+	Variables   map[string]string `json:"variables"`
+	Status      RolloutStatus     `json:"status"`
+	StartedAt   time.Time         `json:"started_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+// EnvironmentManager manages environments and the workload definitions instantiated in
+// them. Its lock may be held while taking the WorkloadManager lock, never the reverse.
+type EnvironmentManager struct {
+	environments map[string]*Environment
+	definitions  map[string]*WorkloadDefinition
+	mutex        sync.RWMutex
+	logger       *logrus.Logger
+}
+
+// NewEnvironmentManager creates a new environment manager
+func NewEnvironmentManager(logger *logrus.Logger) *EnvironmentManager {
+	return &EnvironmentManager{
+		environments: make(map[string]*Environment),
+		definitions:  make(map[string]*WorkloadDefinition),
+		logger:       logger,
+	}
+}
+
+// restore loads persisted environments and definitions
+func (em *EnvironmentManager) restore(environments map[string]*Environment, definitions map[string]*WorkloadDefinition, replace bool) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	if replace {
+		em.environments = make(map[string]*Environment, len(environments))
+		em.definitions = make(map[string]*WorkloadDefinition, len(definitions))
+	}
+	for name, environment := range environments {
+		em.environments[name] = environment
+	}
+	for id, definition := range definitions {
+		em.definitions[id] = definition
+	}
+}
+
+// validateVariables checks variable names
+func validateVariables(variables map[string]string) error {
+	for name := range variables {
+		if !variableNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// mergeVariables layers variable maps, later ones winning
+func mergeVariables(layers ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, layer := range layers {
+		for name, value := range layer {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// renderSpec fills in the ${NAME} placeholders of a spec. Placeholders without a variable
+// are an error, so a missing prod setting is not silently rendered empty.
+func renderSpec(spec WorkloadDeploymentRequest, variables map[string]string) (WorkloadDeploymentRequest, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return spec, err
+	}
+
+	var missing []string
+	rendered := variablePlaceholder.ReplaceAllFunc(data, func(placeholder []byte) []byte {
+		name := string(variablePlaceholder.FindSubmatch(placeholder)[1])
+		value, defined := variables[name]
+		if !defined {
+			missing = append(missing, name)
+			return placeholder
+		}
+		// The value lands inside a JSON string, so it is escaped as one
+		escaped, _ := json.Marshal(value)
+		return escaped[1 : len(escaped)-1]
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return spec, fmt.Errorf("undefined variables: %s", strings.Join(missing, ", "))
+	}
+
+	var result WorkloadDeploymentRequest
+	if err := json.Unmarshal(rendered, &result); err != nil {
+		return spec, err
+	}
+	return result, nil
+}
+
+// admits reports whether a node belongs to the environment a workload was instantiated
+// in; workloads outside environments may run anywhere
+func (b *EnvironmentBinding) admits(node *EdgeNode) bool {
+	return b == nil || b.Nodes.matchesNode(node)
+}
+
+// SetEnvironment creates or updates an environment. A changed node group applies to the
+// environment's workloads right away; placement re-evaluation moves replicas off nodes
+// that left the group.
+func (co *CentralOrchestrator) SetEnvironment(c *gin.Context) {
+	var req EnvironmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := c.Param("name")
+	if !environmentNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Environment names are lowercase letters, digits and dashes"})
+		return
+	}
+	if err := validateVariables(req.Variables); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	now := time.Now()
+	environment, exists := em.environments[name]
+	if !exists {
+		environment = &Environment{Name: name, CreatedAt: now}
+		em.environments[name] = environment
+	}
+	environment.Description = req.Description
+	environment.NodeGroup = NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID}
+	environment.Variables = req.Variables
+	if environment.Variables == nil {
+		environment.Variables = make(map[string]string)
+	}
+	environment.UpdatedAt = now
+
+	co.WorkloadManager.mutex.Lock()
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.EnvironmentBinding != nil && workload.EnvironmentBinding.Name == name {
+			workload.EnvironmentBinding.Nodes = environment.NodeGroup
+		}
+	}
+	co.WorkloadManager.mutex.Unlock()
+
+	co.Logger.Infof("Environment %s set", name)
+	co.AuditLog.RecordRequest(c, "environment.set", "environment:"+name, nil)
+	c.JSON(http.StatusOK, gin.H{"environment": environment})
+}
+
+// ListEnvironments returns all environments
+func (co *CentralOrchestrator) ListEnvironments(c *gin.Context) {
+	em := co.EnvironmentManager
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	environments := make([]*Environment, 0, len(em.environments))
+	for _, environment := range em.environments {
+		environments = append(environments, environment)
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Name < environments[j].Name })
+	c.JSON(http.StatusOK, gin.H{"environments": environments})
+}
+
+// GetEnvironment returns an environment
+func (co *CentralOrchestrator) GetEnvironment(c *gin.Context) {
+	em := co.EnvironmentManager
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	environment, exists := em.environments[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"environment": environment})
+}
+
+// DeleteEnvironment removes an environment no workload definition is instantiated in
+func (co *CentralOrchestrator) DeleteEnvironment(c *gin.Context) {
+	name := c.Param("name")
+
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	if _, exists := em.environments[name]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	}
+	for _, definition := range em.definitions {
+		if _, instantiated := definition.Instances[name]; instantiated {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Workload definition %s is still instantiated in %s", definition.Name, name)})
+			return
+		}
+	}
+	delete(em.environments, name)
+
+	co.Logger.Infof("Environment %s deleted", name)
+	co.AuditLog.RecordRequest(c, "environment.delete", "environment:"+name, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Environment deleted successfully"})
+}
+
+// CreateWorkloadDefinition registers a workload definition without instantiating it
+func (co *CentralOrchestrator) CreateWorkloadDefinition(c *gin.Context) {
+	var req WorkloadDefinitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateVariables(req.Variables); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !tenantAllowed(c, req.Spec.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", req.Spec.Tenant)})
+		return
+	}
+
+	now := time.Now()
+	definition := &WorkloadDefinition{
+		ID:        generateID(),
+		Name:      req.Spec.Name,
+		Spec:      req.Spec,
+		Variables: req.Variables,
+		Instances: make(map[string]*DefinitionInstance),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if definition.Variables == nil {
+		definition.Variables = make(map[string]string)
+	}
+
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	em.definitions[definition.ID] = definition
+	em.mutex.Unlock()
+
+	co.Logger.Infof("Workload definition %s created with ID %s", definition.Name, definition.ID)
+	co.AuditLog.RecordRequest(c, "workload-definition.create", "workload-definition:"+definition.ID, nil)
+	c.JSON(http.StatusCreated, gin.H{"definition": definition})
+}
+
+// UpdateWorkloadDefinition replaces a definition's spec and default variables. Running
+// instances keep their revision until rolled out again, one environment at a time.
+func (co *CentralOrchestrator) UpdateWorkloadDefinition(c *gin.Context) {
+	var req WorkloadDefinitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateVariables(req.Variables); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	definition, exists := em.definitions[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload definition not found"})
+		return
+	}
+	for _, tenant := range []string{definition.Spec.Tenant, req.Spec.Tenant} {
+		if !tenantAllowed(c, tenant) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", tenant)})
+			return
+		}
+	}
+	if req.Spec.Name != definition.Name && len(definition.Instances) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The name of an instantiated definition cannot change"})
+		return
+	}
+
+	definition.Name = req.Spec.Name
+	definition.Spec = req.Spec
+	definition.Variables = req.Variables
+	if definition.Variables == nil {
+		definition.Variables = make(map[string]string)
+	}
+	definition.UpdatedAt = time.Now()
+
+	co.AuditLog.RecordRequest(c, "workload-definition.update", "workload-definition:"+definition.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"definition": definition})
+}
+
+// ListWorkloadDefinitions returns the workload definitions of the tenants the caller may
+// act on, with the progress of their rollouts
+func (co *CentralOrchestrator) ListWorkloadDefinitions(c *gin.Context) {
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	definitions := make([]*WorkloadDefinition, 0, len(em.definitions))
+	for _, definition := range em.definitions {
+		if tenantAllowed(c, definition.Spec.Tenant) {
+			definitions = append(definitions, definition)
+		}
+	}
+	co.refreshRollouts(definitions)
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].Name < definitions[j].Name })
+	c.JSON(http.StatusOK, gin.H{"definitions": definitions})
+}
+
+// GetWorkloadDefinition returns a workload definition with the progress of its rollouts
+func (co *CentralOrchestrator) GetWorkloadDefinition(c *gin.Context) {
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	definition, exists := em.definitions[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload definition not found"})
+		return
+	}
+	if !tenantAllowed(c, definition.Spec.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", definition.Spec.Tenant)})
+		return
+	}
+	co.refreshRollouts([]*WorkloadDefinition{definition})
+	c.JSON(http.StatusOK, gin.H{"definition": definition})
+}
+
+// DeleteWorkloadDefinition removes a definition that has no instances left
+func (co *CentralOrchestrator) DeleteWorkloadDefinition(c *gin.Context) {
+	id := c.Param("id")
+
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	definition, exists := em.definitions[id]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload definition not found"})
+		return
+	}
+	if !tenantAllowed(c, definition.Spec.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", definition.Spec.Tenant)})
+		return
+	}
+	if len(definition.Instances) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Remove the definition's environment instances first"})
+		return
+	}
+	delete(em.definitions, id)
+
+	co.AuditLog.RecordRequest(c, "workload-definition.delete", "workload-definition:"+id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Workload definition deleted successfully"})
+}
+
+// RolloutWorkloadDefinition instantiates a definition in an environment, or rolls its
+// current spec out to the environment's existing instance as a new revision. The instance
+// runs as the workload <name>-<environment>, placed only on the environment's nodes.
+func (co *CentralOrchestrator) RolloutWorkloadDefinition(c *gin.Context) {
+	var req InstanceRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := validateVariables(req.Variables); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Replicas < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "replicas must not be negative"})
+		return
+	}
+
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	definition, exists := em.definitions[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload definition not found"})
+		return
+	}
+	environment, exists := em.environments[c.Param("env")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	}
+
+	instance := definition.Instances[environment.Name]
+	if instance == nil {
+		instance = &DefinitionInstance{Environment: environment.Name}
+	}
+	// Overrides not sent again are kept from the previous rollout
+	if req.Variables != nil {
+		instance.Variables = req.Variables
+	}
+	if req.Replicas > 0 {
+		instance.Replicas = req.Replicas
+	}
+
+	variables := mergeVariables(definition.Variables, environment.Variables, instance.Variables)
+	spec, err := renderSpec(definition.Spec, variables)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	spec.Name = definition.Name + "-" + environment.Name
+	if instance.Replicas > 0 {
+		spec.Replicas = instance.Replicas
+	}
+	if spec.Labels == nil {
+		spec.Labels = make(map[string]string)
+	}
+	spec.Labels[EnvironmentLabel] = environment.Name
+
+	now := time.Now()
+	workload, err := newWorkload(spec, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, workloadSpecError(err))
+		return
+	}
+	if err := co.validateDatasetConstraints(workload.Placement.Constraints); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !tenantAllowed(c, workload.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", workloadTenant(workload))})
+		return
+	}
+	workload.EnvironmentBinding = &EnvironmentBinding{Name: environment.Name, Nodes: environment.NodeGroup}
+
+	co.WorkloadManager.mutex.Lock()
+	current, running := co.WorkloadManager.workloads[instance.WorkloadID]
+	if running {
+		workload = rollOutWorkload(current, workload, now)
+	} else {
+		current = nil
+	}
+	if err := co.checkNamespaceQuota(workload, instance.WorkloadID); err != nil {
+		co.WorkloadManager.mutex.Unlock()
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	co.recordRevision(current, workload, fmt.Sprintf("rollout %d of workload definition %s", instance.Revision+1, definition.Name), now)
+	co.WorkloadManager.workloads[workload.ID] = workload
+	co.WorkloadManager.mutex.Unlock()
+	co.AgentStreamHub.wake()
+
+	for _, rollout := range instance.Rollouts {
+		if rollout.Status == RolloutStatusProgressing {
+			rollout.Status = RolloutStatusSuperseded
+		}
+	}
+	instance.WorkloadID = workload.ID
+	instance.Revision++
+	instance.Rollouts = append(instance.Rollouts, &EnvironmentRollout{
+		Revision:  instance.Revision,
+		Image:     workload.Image,
+		Variables: variables,
+		Status:    RolloutStatusProgressing,
+		StartedAt: now,
+	})
+	if len(instance.Rollouts) > MaxRolloutHistory {
+		instance.Rollouts = instance.Rollouts[len(instance.Rollouts)-MaxRolloutHistory:]
+	}
+	definition.Instances[environment.Name] = instance
+
+	co.Logger.Infof("Workload definition %s rolled out to %s as revision %d", definition.Name, environment.Name, instance.Revision)
+	co.AuditLog.RecordRequest(c, "workload-definition.rollout", "workload-definition:"+definition.ID,
+		map[string]string{"environment": environment.Name, "revision": fmt.Sprint(instance.Revision), "image": workload.Image})
+	c.JSON(http.StatusAccepted, gin.H{"instance": instance, "workload": workload})
+}
+
+// rollOutWorkload carries a running workload's identity and placement over to its newly
+// rendered spec. Its deployments are pending until their agents report the new spec ready.
+func rollOutWorkload(current, next *Workload, now time.Time) *Workload {
+	next.ID = current.ID
+	next.Selector = current.Selector
+	next.CreatedAt = current.CreatedAt
+	next.Deployments = current.Deployments
+	next.BlueGreen = current.BlueGreen
+	next.ImageSubscription = current.ImageSubscription
+	for i := range next.Deployments {
+		if next.Deployments[i].placed() {
+			next.Deployments[i].Status = WorkloadStatusPending
+			next.Deployments[i].Observed = nil
+			next.Deployments[i].UpdatedAt = now
+		}
+	}
+	// Scheduled again so the replicas follow a changed count
+	next.Status = WorkloadStatusPending
+	return next
+}
+
+// DeleteWorkloadInstance removes a definition's instance from an environment, with its
+// workload
+func (co *CentralOrchestrator) DeleteWorkloadInstance(c *gin.Context) {
+	em := co.EnvironmentManager
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	definition, exists := em.definitions[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload definition not found"})
+		return
+	}
+	if !tenantAllowed(c, definition.Spec.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", definition.Spec.Tenant)})
+		return
+	}
+	name := c.Param("env")
+	instance, exists := definition.Instances[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload definition is not instantiated in " + name})
+		return
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	if workload, running := co.WorkloadManager.workloads[instance.WorkloadID]; running {
+		// Streaming agents remove it from their nodes once it leaves their desired state
+		workload.Status = WorkloadStatusStopped
+		workload.UpdatedAt = time.Now()
+		delete(co.WorkloadManager.workloads, workload.ID)
+		co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, workload.ID)
+		co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workload.ID)
+		co.RevisionHistory.forget(workload.ID)
+	}
+	co.WorkloadManager.mutex.Unlock()
+	co.AgentStreamHub.wake()
+	delete(definition.Instances, name)
+
+	co.Logger.Infof("Workload definition %s removed from %s", definition.Name, name)
+	co.AuditLog.RecordRequest(c, "workload-definition.remove", "workload-definition:"+definition.ID, map[string]string{"environment": name})
+	c.JSON(http.StatusOK, gin.H{"message": "Instance removed successfully"})
+}
+
+// refreshRollouts completes the latest rollout of each instance once every replica of its
+// workload reports the new revision ready. Callers must hold the EnvironmentManager lock.
+func (co *CentralOrchestrator) refreshRollouts(definitions []*WorkloadDefinition) {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	now := time.Now()
+	for _, definition := range definitions {
+		for _, instance := range definition.Instances {
+			if len(instance.Rollouts) == 0 {
+				continue
+			}
+			latest := instance.Rollouts[len(instance.Rollouts)-1]
+			workload, exists := co.WorkloadManager.workloads[instance.WorkloadID]
+			if latest.Status != RolloutStatusProgressing || !exists || !workloadReady(workload) {
+				continue
+			}
+			latest.Status = RolloutStatusComplete
+			latest.CompletedAt = &now
+		}
+	}
+}
+
+// workloadReady reports whether all of a workload's replicas are placed and reported ready
+func workloadReady(workload *Workload) bool {
+	if workload.Status != WorkloadStatusRunning || workload.runningReplicas() < expectedReplicas(workload) {
+		return false
+	}
+	for _, deployment := range workload.Deployments {
+		if deployment.placed() && deployment.Status != WorkloadStatusRunning {
+			return false
+		}
+	}
+	return true
+}
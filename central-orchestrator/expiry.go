@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Interval between checks for expired workloads
+	WorkloadExpiryCheckInterval = 30 * time.Second
+)
+
+// WorkloadExpiryRequest sets, extends or clears a workload's expiry. TTL is counted from
+// now; with neither field set the expiry is cleared.
+type WorkloadExpiryRequest struct {
+	TTL       string     `json:"ttl"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// resolveExpiry turns a TTL or an absolute expiry into an expiry timestamp
+func resolveExpiry(ttl string, expiresAt *time.Time, now time.Time) (*time.Time, error) {
+	if ttl != "" && expiresAt != nil {
+		return nil, fmt.Errorf("ttl and expires_at are mutually exclusive")
+	}
+	if ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %v", err)
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("ttl must be positive")
+		}
+		expiry := now.Add(duration)
+		return &expiry, nil
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+	return expiresAt, nil
+}
+
+// workloadExpirer removes workloads whose expiry has passed
+func (co *CentralOrchestrator) workloadExpirer() {
+	ticker := time.NewTicker(WorkloadExpiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.expireWorkloads(time.Now())
+		}
+	}
+}
+
+// expireWorkloads stops every deployment of each expired workload, removes the workload and
+// records the teardown as an operation
+func (co *CentralOrchestrator) expireWorkloads(now time.Time) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	var expired []*Workload
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.ExpiresAt != nil && !workload.ExpiresAt.After(now) {
+			expired = append(expired, workload)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ExpiresAt.Before(*expired[j].ExpiresAt)
+	})
+
+	for _, workload := range expired {
+		op := co.OperationManager.Start("workload-expiry", "workload "+workload.ID)
+		expiresAt := workload.ExpiresAt.Format(time.RFC3339)
+
+		for i := range workload.Deployments {
+			deployment := &workload.Deployments[i]
+			if deployment.Status != WorkloadStatusRunning && deployment.Status != WorkloadStatusPending {
+				continue
+			}
+			deployment.Status = WorkloadStatusStopped
+			deployment.UpdatedAt = now
+			co.OperationManager.AddStep(op, newOperationStep("stopped", workload.ID, deployment.NodeID, true,
+				newMessage(MsgWorkloadExpired, "workload", workload.Name, "expires_at", expiresAt, "node", deployment.NodeID)))
+		}
+
+		workload.Status = WorkloadStatusStopped
+		workload.UpdatedAt = now
+		delete(co.WorkloadManager.workloads, workload.ID)
+		co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, workload.ID)
+		co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workload.ID)
+		co.RevisionHistory.forget(workload.ID)
+
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "Workload expired at "+expiresAt)
+		co.Logger.Infof("Workload %s (%s) expired and was removed", workload.Name, workload.ID)
+	}
+}
+
+// SetWorkloadExpiry sets, extends or clears a workload's expiry
+func (co *CentralOrchestrator) SetWorkloadExpiry(c *gin.Context) {
+	var req WorkloadExpiryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	expiresAt, err := resolveExpiry(req.TTL, req.ExpiresAt, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	workload.ExpiresAt = expiresAt
+	workload.UpdatedAt = now
+
+	if expiresAt != nil {
+		co.Logger.Infof("Workload %s expires at %s", workload.ID, expiresAt.Format(time.RFC3339))
+	} else {
+		co.Logger.Infof("Workload %s expiry cleared", workload.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
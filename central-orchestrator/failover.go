@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Failover check interval
+	FailoverCheckInterval = 30 * time.Second
+)
+
+// failoverItem is a set of lost replicas waiting to be re-placed
+type failoverItem struct {
+	workload *Workload
+	replicas int32
+	fromNode string
+}
+
+// failoverOutcome records what happened to a failoverItem during planning
+type failoverOutcome struct {
+	item   *failoverItem
+	action string
+	node   *EdgeNode
+	// For "displaced" outcomes, the item the displaced replicas made room for
+	displacedBy *failoverItem
+}
+
+// failoverPlanner re-places lost replicas against a set of workloads and online nodes.
+// It mutates the workloads it is given, so simulations run it against copies.
+type failoverPlanner struct {
+	co        *CentralOrchestrator
+	workloads map[string]*Workload
+	online    map[string]*EdgeNode
+	// Nodes whose deployments are lost; when nil, every node not in online is lost
+	lost map[string]bool
+	// Nodes being replaced, whose deployments wait for the replacement device
+	held map[string]bool
+}
+
+// failoverController re-places replicas lost on unavailable nodes
+func (co *CentralOrchestrator) failoverController() {
+	ticker := time.NewTicker(FailoverCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.runFailover()
+		}
+	}
+}
+
+// onlineNodes returns a snapshot of the online nodes, excluding any in the given set
+func (co *CentralOrchestrator) onlineNodes(exclude map[string]bool) map[string]*EdgeNode {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	online := make(map[string]*EdgeNode)
+	for id, node := range co.NodeManager.nodes {
+		if node.Status == NodeStatusOnline && !exclude[id] {
+			online[id] = node
+		}
+	}
+	return online
+}
+
+// lostNodes returns the nodes whose deployments failover re-places: offline nodes and
+// nodes that were deleted. Degraded nodes, such as an imported cluster with one NotReady
+// Kubernetes node, still run their replicas, and nodes in maintenance are drained instead.
+// Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) lostNodes() map[string]bool {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	lost := make(map[string]bool)
+	for _, workload := range co.WorkloadManager.workloads {
+		for _, deployment := range workload.Deployments {
+			node, exists := co.NodeManager.nodes[deployment.NodeID]
+			if !exists || node.Status == NodeStatusOffline {
+				lost[deployment.NodeID] = true
+			}
+		}
+	}
+	return lost
+}
+
+// runFailover fails deployments on unavailable nodes and re-places them in order of
+// workload criticality, displacing less critical workloads when capacity is short
+func (co *CentralOrchestrator) runFailover() {
+	online := co.onlineNodes(nil)
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	planner := &failoverPlanner{co: co, workloads: co.WorkloadManager.workloads, online: online,
+		lost: co.lostNodes(), held: co.ReplacementManager.heldNodes(time.Now())}
+	queue := planner.failLostDeployments()
+	if len(queue) == 0 {
+		return
+	}
+
+	op := co.OperationManager.Start("failover", "nodes "+strings.Join(lostNodeIDs(queue), ","))
+
+	unplaced := 0
+	for _, outcome := range planner.plan(queue) {
+		item := outcome.item
+		switch outcome.action {
+		case "unschedulable":
+			unplaced++
+			// Pending even while other replicas run, so the scheduler places the missing
+			// ones once capacity frees up or new nodes join
+			item.workload.Status = WorkloadStatusPending
+			item.workload.UpdatedAt = time.Now()
+			co.AlertManager.Fire(WorkloadUnschedulableAlert, AlertSeverityCritical, AlertScopeWorkload, item.workload.ID, "",
+				newMessage(MsgWorkloadUnschedulable, "replicas", item.replicas, "workload", item.workload.Name, "from_node", item.fromNode))
+		case "replaced":
+			co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, item.workload.ID)
+		}
+		co.OperationManager.AddStep(op, outcome.step())
+	}
+
+	// Push the moves to connected agents without waiting for the next tick
+	co.AgentStreamHub.wake()
+
+	if unplaced > 0 {
+		co.OperationManager.Complete(op, OperationStatusPartial, fmt.Sprintf("%d replica sets could not be re-placed", unplaced))
+	} else {
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "All lost replicas re-placed")
+	}
+}
+
+// lostNodeIDs returns the sorted, distinct source nodes of the queued items
+func lostNodeIDs(queue []*failoverItem) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, item := range queue {
+		if !seen[item.fromNode] {
+			seen[item.fromNode] = true
+			ids = append(ids, item.fromNode)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// step converts an outcome into an operation step
+func (o *failoverOutcome) step() OperationStep {
+	item := o.item
+	switch o.action {
+	case "replaced":
+		return newOperationStep(o.action, item.workload.ID, o.node.ID, true,
+			newMessage(MsgFailoverMoved, "replicas", item.replicas, "workload", item.workload.Name,
+				"criticality", item.workload.Criticality, "from_node", item.fromNode, "to_node", o.node.ID))
+	case "displaced":
+		return newOperationStep(o.action, item.workload.ID, o.node.ID, true,
+			newMessage(MsgFailoverDisplaced, "workload", item.workload.Name, "criticality", item.workload.Criticality,
+				"node", o.node.ID, "displaced_by", o.displacedBy.workload.Name,
+				"displaced_by_criticality", o.displacedBy.workload.Criticality))
+	default:
+		return newOperationStep(o.action, item.workload.ID, "", false,
+			newMessage(MsgFailoverNoCapacity, "replicas", item.replicas, "workload", item.workload.Name,
+				"criticality", item.workload.Criticality, "from_node", item.fromNode))
+	}
+}
+
+// isLost reports whether deployments on a node must be re-placed
+func (p *failoverPlanner) isLost(nodeID string) bool {
+	if p.held[nodeID] {
+		return false
+	}
+	if p.lost != nil {
+		return p.lost[nodeID]
+	}
+	return p.online[nodeID] == nil
+}
+
+// hasUnschedulable reports whether any outcome left replicas unplaced
+func (p *failoverPlanner) hasUnschedulable(outcomes []*failoverOutcome) bool {
+	for _, outcome := range outcomes {
+		if outcome.action == "unschedulable" {
+			return true
+		}
+	}
+	return false
+}
+
+// failLostDeployments marks placed deployments on unavailable nodes failed and
+// returns their replicas, ordered for re-placement
+func (p *failoverPlanner) failLostDeployments() []*failoverItem {
+	var queue []*failoverItem
+	now := time.Now()
+
+	for _, workload := range p.workloads {
+		for i := range workload.Deployments {
+			deployment := &workload.Deployments[i]
+			if !deployment.placed() || !p.isLost(deployment.NodeID) {
+				continue
+			}
+			deployment.Status = WorkloadStatusFailed
+			deployment.UpdatedAt = now
+			queue = append(queue, &failoverItem{
+				workload: workload,
+				replicas: deployment.Replicas,
+				fromNode: deployment.NodeID,
+			})
+		}
+	}
+
+	sortFailoverQueue(queue)
+	return queue
+}
+
+// plan re-places queued replicas most critical first, displacing less critical
+// deployments when no node has room; displaced replicas are queued in turn
+func (p *failoverPlanner) plan(queue []*failoverItem) []*failoverOutcome {
+	var outcomes []*failoverOutcome
+
+	for i := 0; i < len(queue); i++ {
+		item := queue[i]
+
+		if node := p.place(item); node != nil {
+			outcomes = append(outcomes, &failoverOutcome{item: item, action: "replaced", node: node})
+			continue
+		}
+
+		if victim, node := p.displaceFor(item); victim != nil {
+			outcomes = append(outcomes,
+				&failoverOutcome{item: victim, action: "displaced", node: node, displacedBy: item},
+				&failoverOutcome{item: item, action: "replaced", node: node})
+			queue = insertFailoverItem(queue, i+1, victim)
+			continue
+		}
+
+		outcomes = append(outcomes, &failoverOutcome{item: item, action: "unschedulable"})
+	}
+
+	return outcomes
+}
+
+// sortFailoverQueue orders lost replicas by criticality, then by workload age
+func sortFailoverQueue(queue []*failoverItem) {
+	sort.SliceStable(queue, func(i, j int) bool {
+		return failoverLess(queue[i], queue[j])
+	})
+}
+
+func failoverLess(a, b *failoverItem) bool {
+	if a.workload.Criticality != b.workload.Criticality {
+		return a.workload.Criticality > b.workload.Criticality
+	}
+	return a.workload.CreatedAt.Before(b.workload.CreatedAt)
+}
+
+// insertFailoverItem inserts item into the not-yet-processed tail of the queue, keeping it sorted
+func insertFailoverItem(queue []*failoverItem, from int, item *failoverItem) []*failoverItem {
+	pos := from
+	for pos < len(queue) && !failoverLess(item, queue[pos]) {
+		pos++
+	}
+	queue = append(queue, nil)
+	copy(queue[pos+1:], queue[pos:])
+	queue[pos] = item
+	return queue
+}
+
+// candidates returns online nodes eligible to host the item's workload, best first for the
+// workload's placement policy
+func (p *failoverPlanner) candidates(item *failoverItem) []*EdgeNode {
+	return p.co.replacementNodes(p.workloads, item.workload, item.fromNode, p.online)
+}
+
+// replacementNodes returns the nodes that may take over a workload's replicas from a node,
+// best first for its placement policy. Capacity is left to the caller, which knows how many
+// replicas move. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) replacementNodes(workloads map[string]*Workload, workload *Workload, fromNode string, online map[string]*EdgeNode) []*EdgeNode {
+	state := &SchedulingState{
+		Orchestrator:   co,
+		Workload:       workload,
+		Committed:      committedResources(workloads),
+		Placed:         placedReplicas(workloads, workload.ID),
+		Request:        workloadRequests(workload),
+		Now:            time.Now(),
+		IgnoreCapacity: true,
+	}
+
+	// Sites holding one of the workload's other replicas
+	occupied := make(map[string]bool)
+	for _, deployment := range workload.Deployments {
+		if node := online[deployment.NodeID]; node != nil && deployment.placed() && node.ID != fromNode {
+			occupied[node.SiteID] = true
+		}
+	}
+
+	var candidates []*EdgeNode
+	for _, node := range online {
+		if d := workload.deploymentFor(node.ID); node.ID == fromNode || (d != nil && d.placed()) {
+			continue
+		}
+		if workload.Placement.OneReplicaPerSite && (node.SiteID == "" || occupied[node.SiteID]) {
+			continue
+		}
+		if !co.Scheduler.admits(state, node) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	co.Scheduler.rank(state, candidates)
+	return candidates
+}
+
+// place puts the item's replicas on the first eligible node with room
+func (p *failoverPlanner) place(item *failoverItem) *EdgeNode {
+	committed := committedResources(p.workloads)
+
+	for _, node := range p.candidates(item) {
+		if p.co.fitsOnNode(node, committed[node.ID], item.workload, item.replicas) {
+			item.workload.addDeployment(node.ID, item.replicas)
+			return node
+		}
+	}
+	return nil
+}
+
+// displaceFor evicts the least critical deployment whose removal makes room for the
+// item, places the item there, and returns the evicted replicas for re-placement
+func (p *failoverPlanner) displaceFor(item *failoverItem) (*failoverItem, *EdgeNode) {
+	committed := committedResources(p.workloads)
+
+	var victimWorkload *Workload
+	var victimDeployment *WorkloadDeployment
+	var victimNode *EdgeNode
+
+	for _, node := range p.candidates(item) {
+		for _, workload := range p.workloads {
+			if workload == item.workload || workload.Criticality >= item.workload.Criticality {
+				continue
+			}
+			deployment := workload.deploymentFor(node.ID)
+			if deployment == nil || !deployment.placed() {
+				continue
+			}
+			remaining := committed[node.ID].with(workload, -deployment.Replicas)
+			if !p.co.fitsOnNode(node, remaining, item.workload, item.replicas) {
+				continue
+			}
+			if victimWorkload == nil || workload.Criticality < victimWorkload.Criticality {
+				victimWorkload, victimDeployment, victimNode = workload, deployment, node
+			}
+		}
+	}
+
+	if victimWorkload == nil {
+		return nil, nil
+	}
+
+	victimDeployment.Status = WorkloadStatusFailed
+	victimDeployment.UpdatedAt = time.Now()
+	item.workload.addDeployment(victimNode.ID, item.replicas)
+
+	return &failoverItem{
+		workload: victimWorkload,
+		replicas: victimDeployment.Replicas,
+		fromNode: victimNode.ID,
+	}, victimNode
+}
+
+// addDeployment places replicas on a node, reusing an inactive entry if present. The
+// deployment is pending until the node's agent reports it available.
+func (w *Workload) addDeployment(nodeID string, replicas int32) {
+	now := time.Now()
+	w.Status = WorkloadStatusRunning
+	w.UpdatedAt = now
+
+	if deployment := w.deploymentFor(nodeID); deployment != nil {
+		// A job run placed here again is a new attempt, recreated by the agent
+		deployment.Attempt++
+		deployment.Status = WorkloadStatusPending
+		deployment.Replicas = replicas
+		deployment.Observed = nil
+		deployment.DeployedAt = now
+		deployment.UpdatedAt = now
+		return
+	}
+
+	w.Deployments = append(w.Deployments, WorkloadDeployment{
+		NodeID:     nodeID,
+		Status:     WorkloadStatusPending,
+		Replicas:   replicas,
+		DeployedAt: now,
+		UpdatedAt:  now,
+	})
+}
+
+// hasRunningDeployment reports whether any of the workload's deployments is placed
+func (w *Workload) hasRunningDeployment() bool {
+	for _, deployment := range w.Deployments {
+		if deployment.placed() {
+			return true
+		}
+	}
+	return false
+}
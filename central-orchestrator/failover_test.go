@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestFailoverKeepsDegradedNodes checks that only deployments on offline and deleted nodes
+// are failed over, and that a degraded node, such as an imported cluster with one NotReady
+// Kubernetes node, keeps its replicas
+func TestFailoverKeepsDegradedNodes(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	co := &CentralOrchestrator{
+		NodeManager:     NewNodeManager(logger),
+		WorkloadManager: NewWorkloadManager(logger),
+		Logger:          logger,
+	}
+	for id, status := range map[string]NodeStatus{
+		"online":      NodeStatusOnline,
+		"degraded":    NodeStatusDegraded,
+		"maintenance": NodeStatusMaintenance,
+		"offline":     NodeStatusOffline,
+	} {
+		co.NodeManager.nodes[id] = &EdgeNode{ID: id, Name: id, Status: status, LastHeartbeat: time.Now()}
+	}
+
+	deployedOn := []string{"online", "degraded", "maintenance", "offline", "deleted"}
+	for _, nodeID := range deployedOn {
+		co.WorkloadManager.workloads["w-"+nodeID] = &Workload{
+			ID:          "w-" + nodeID,
+			Name:        "w-" + nodeID,
+			Status:      WorkloadStatusRunning,
+			Deployments: []WorkloadDeployment{{NodeID: nodeID, Status: WorkloadStatusRunning, Replicas: 1}},
+		}
+	}
+
+	planner := &failoverPlanner{co: co, workloads: co.WorkloadManager.workloads,
+		online: co.onlineNodes(nil), lost: co.lostNodes()}
+	queue := planner.failLostDeployments()
+
+	failed := make(map[string]bool)
+	for _, item := range queue {
+		failed[item.fromNode] = true
+	}
+	for _, nodeID := range deployedOn {
+		want := nodeID == "offline" || nodeID == "deleted"
+		if failed[nodeID] != want {
+			t.Errorf("Deployment on %s node failed over: %v, want %v", nodeID, failed[nodeID], want)
+		}
+		status := co.WorkloadManager.workloads["w-"+nodeID].Deployments[0].Status
+		if want != (status == WorkloadStatusFailed) {
+			t.Errorf("Deployment on %s node has status %s", nodeID, status)
+		}
+	}
+}
+
+// TestFailoverReplacesLostReplicasLater checks that replicas failover could not re-place
+// are placed by the scheduler once new nodes join, even while the workload still runs
+// some replicas elsewhere
+func TestFailoverReplacesLostReplicasLater(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	co := &CentralOrchestrator{
+		NodeManager:        NewNodeManager(logger),
+		WorkloadManager:    NewWorkloadManager(logger),
+		NodeStateManager:   NewNodeStateManager(logger),
+		ReservationManager: NewReservationManager(logger),
+		DatasetCatalog:     NewDatasetCatalog(logger),
+		Scheduler:          NewScheduler(logger),
+		TenantScheduler:    NewTenantScheduler(logger),
+		CloudProvisioner:   NewCloudProvisioner(logger),
+		ReplacementManager: NewReplacementManager(logger),
+		OperationManager:   NewOperationManager(logger),
+		AlertManager:       NewAlertManager(logger),
+		AgentStreamHub:     NewAgentStreamHub(logger),
+		Logger:             logger,
+	}
+	addNode := func(id string) {
+		co.NodeManager.nodes[id] = &EdgeNode{ID: id, Name: id, Status: NodeStatusOnline, State: NodeStateActive, LastHeartbeat: time.Now()}
+	}
+	addNode("node-a")
+	addNode("node-b")
+
+	workload := &Workload{
+		ID:        "w-1",
+		Name:      "w-1",
+		Replicas:  4,
+		Status:    WorkloadStatusRunning,
+		Placement: PlacementPolicy{MaxReplicasPerNode: 2},
+		Deployments: []WorkloadDeployment{
+			{NodeID: "node-a", Status: WorkloadStatusRunning, Replicas: 2},
+			{NodeID: "node-b", Status: WorkloadStatusRunning, Replicas: 2},
+		},
+	}
+	co.WorkloadManager.workloads[workload.ID] = workload
+
+	// node-a already runs its maximum, so failover has nowhere to put node-b's replicas
+	co.NodeManager.nodes["node-b"].Status = NodeStatusOffline
+	co.runFailover()
+	if placed := workload.scheduledReplicas(); placed != 2 {
+		t.Fatalf("Failover placed %d replicas, want 2", placed)
+	}
+	if workload.Status != WorkloadStatusPending {
+		t.Fatalf("Under-replicated workload has status %s, want %s", workload.Status, WorkloadStatusPending)
+	}
+
+	addNode("node-c")
+	addNode("node-d")
+	co.scheduleWorkloads()
+
+	if placed := workload.scheduledReplicas(); placed != 4 {
+		t.Errorf("Workload has %d replicas placed after new nodes joined, want 4", placed)
+	}
+	if workload.Status != WorkloadStatusRunning {
+		t.Errorf("Workload has status %s, want %s", workload.Status, WorkloadStatusRunning)
+	}
+	for _, deployment := range workload.Deployments {
+		if deployment.placed() && deployment.Replicas > 2 {
+			t.Errorf("Node %s runs %d replicas, more than the maximum of 2", deployment.NodeID, deployment.Replicas)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How often federated jobs are advanced
+	FederatedControlInterval = 15 * time.Second
+
+	// Round deadline when the job sets none
+	DefaultFederatedRoundTimeout = 30 * time.Minute
+
+	// Where model artifacts are kept when FEDERATED_ARTIFACT_DIR is unset
+	DefaultFederatedArtifactDir = "/var/lib/edge-orchestrator/federated"
+
+	// Largest model artifact accepted
+	FederatedMaxArtifactBytes = 1 << 30
+)
+
+// FederatedJobStatus is the lifecycle state of a federated learning job
+type FederatedJobStatus string
+
+const (
+	// Waiting for enough capable nodes to become available
+	FederatedJobPending FederatedJobStatus = "pending"
+	// A round is in progress on the participants
+	FederatedJobTraining FederatedJobStatus = "training"
+	// A round closed; waiting for the aggregated model
+	FederatedJobAggregating FederatedJobStatus = "aggregating"
+	FederatedJobCompleted   FederatedJobStatus = "completed"
+	FederatedJobFailed      FederatedJobStatus = "failed"
+	FederatedJobCancelled   FederatedJobStatus = "cancelled"
+)
+
+// FederatedRoundStatus is the state of one training round
+type FederatedRoundStatus string
+
+const (
+	FederatedRoundTraining    FederatedRoundStatus = "training"
+	FederatedRoundAggregating FederatedRoundStatus = "aggregating"
+	FederatedRoundCompleted   FederatedRoundStatus = "completed"
+	FederatedRoundFailed      FederatedRoundStatus = "failed"
+)
+
+// ParticipationStatus is a node's progress in a round
+type ParticipationStatus string
+
+const (
+	ParticipationAssigned  ParticipationStatus = "assigned"
+	ParticipationTraining  ParticipationStatus = "training"
+	ParticipationSubmitted ParticipationStatus = "submitted"
+	ParticipationFailed    ParticipationStatus = "failed"
+	ParticipationTimedOut  ParticipationStatus = "timed_out"
+)
+
+// Accelerators a federated job can require
+const (
+	FederatedAcceleratorCPU = "cpu"
+	FederatedAcceleratorGPU = "gpu"
+)
+
+// FederatedJobRequest creates a federated learning job
+type FederatedJobRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Tenant      string            `json:"tenant"`
+	Namespace   string            `json:"namespace"`
+	Image       string            `json:"image" binding:"required"`
+	Command     []string          `json:"command,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Resources   WorkloadResources `json:"resources"`
+	// Nodes eligible to train; empty selects from the whole fleet
+	Nodes NodeGroup `json:"nodes"`
+	// "cpu" (default) or "gpu"
+	Accelerator     string `json:"accelerator"`
+	MinParticipants int    `json:"min_participants"`
+	MaxParticipants int    `json:"max_participants"`
+	TotalRounds     int    `json:"total_rounds"`
+	// Updates a round needs to be aggregated; defaults to min_participants
+	MinUpdates          int `json:"min_updates"`
+	RoundTimeoutMinutes int `json:"round_timeout_minutes"`
+}
+
+// ModelArtifact is a model or model update stored by the orchestrator
+type ModelArtifact struct {
+	path       string
+	SizeBytes  int64     `json:"size_bytes"`
+	SHA256     string    `json:"sha256"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// RoundParticipation tracks one node's part in a round
+type RoundParticipation struct {
+	Status    ParticipationStatus `json:"status"`
+	Update    *ModelArtifact      `json:"update,omitempty"`
+	Samples   int64               `json:"samples,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// FederatedRound is one round of local training followed by aggregation
+type FederatedRound struct {
+	Number    int                  `json:"number"`
+	Status    FederatedRoundStatus `json:"status"`
+	StartedAt time.Time            `json:"started_at"`
+	Deadline  time.Time            `json:"deadline"`
+	ClosedAt  *time.Time           `json:"closed_at,omitempty"`
+	// Global model participants start from; empty in the first round
+	GlobalModel     *ModelArtifact                 `json:"global_model,omitempty"`
+	Participants    map[string]*RoundParticipation `json:"participants"`
+	AggregatedModel *ModelArtifact                 `json:"aggregated_model,omitempty"`
+}
+
+// submittedUpdates counts the participants whose update arrived
+func (r *FederatedRound) submittedUpdates() int {
+	submitted := 0
+	for _, participation := range r.Participants {
+		if participation.Status == ParticipationSubmitted {
+			submitted++
+		}
+	}
+	return submitted
+}
+
+// FederatedJob trains a model across edge nodes without moving their data. Each round the
+// participants train locally from the current global model and upload an update; an
+// external aggregator combines the updates and uploads the next global model.
+type FederatedJob struct {
+	ID string `json:"id"`
+	FederatedJobRequest
+	Status       FederatedJobStatus `json:"status"`
+	Participants []string           `json:"participants"`
+	CurrentRound int                `json:"current_round"`
+	Rounds       []*FederatedRound  `json:"rounds"`
+	Error        string             `json:"error,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+}
+
+// round returns a round by number, or nil
+func (job *FederatedJob) round(number int) *FederatedRound {
+	if number < 1 || number > len(job.Rounds) {
+		return nil
+	}
+	return job.Rounds[number-1]
+}
+
+func (job *FederatedJob) roundTimeout() time.Duration {
+	if job.RoundTimeoutMinutes == 0 {
+		return DefaultFederatedRoundTimeout
+	}
+	return time.Duration(job.RoundTimeoutMinutes) * time.Minute
+}
+
+// validate checks participant, round and accelerator settings and applies defaults
+func (req *FederatedJobRequest) validate() error {
+	if req.Namespace == "" {
+		req.Namespace = DefaultNamespace
+	}
+	if req.Tenant == "" {
+		req.Tenant = DefaultTenant
+	}
+	switch req.Accelerator {
+	case "":
+		req.Accelerator = FederatedAcceleratorCPU
+	case FederatedAcceleratorCPU, FederatedAcceleratorGPU:
+	default:
+		return fmt.Errorf("accelerator must be cpu or gpu")
+	}
+	if req.MinParticipants < 1 {
+		return fmt.Errorf("min_participants must be at least 1")
+	}
+	if req.MaxParticipants == 0 {
+		req.MaxParticipants = req.MinParticipants
+	}
+	if req.MaxParticipants < req.MinParticipants {
+		return fmt.Errorf("max_participants must be at least min_participants")
+	}
+	if req.TotalRounds < 1 {
+		return fmt.Errorf("total_rounds must be at least 1")
+	}
+	if req.MinUpdates == 0 {
+		req.MinUpdates = req.MinParticipants
+	}
+	if req.MinUpdates < 1 || req.MinUpdates > req.MinParticipants {
+		return fmt.Errorf("min_updates must be between 1 and min_participants")
+	}
+	if req.RoundTimeoutMinutes < 0 {
+		return fmt.Errorf("round_timeout_minutes must not be negative")
+	}
+	return nil
+}
+
+// FederatedJobManager manages federated learning jobs and their model artifacts
+type FederatedJobManager struct {
+	jobs        map[string]*FederatedJob
+	artifactDir string
+	mutex       sync.RWMutex
+	logger      *logrus.Logger
+}
+
+// NewFederatedJobManager creates a federated job manager; FEDERATED_ARTIFACT_DIR sets
+// where models and updates are stored
+func NewFederatedJobManager(logger *logrus.Logger) *FederatedJobManager {
+	artifactDir := os.Getenv("FEDERATED_ARTIFACT_DIR")
+	if artifactDir == "" {
+		artifactDir = DefaultFederatedArtifactDir
+	}
+
+	return &FederatedJobManager{
+		jobs:        make(map[string]*FederatedJob),
+		artifactDir: artifactDir,
+		logger:      logger,
+	}
+}
+
+// storeArtifact stores an uploaded model artifact under the job's directory
+func (fm *FederatedJobManager) storeArtifact(jobID string, name string, body io.Reader) (*ModelArtifact, error) {
+	path, size, digest, err := storeUpload(filepath.Join(fm.artifactDir, jobID), name, body, FederatedMaxArtifactBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &ModelArtifact{path: path, SizeBytes: size, SHA256: digest, UploadedAt: time.Now()}, nil
+}
+
+// storeUpload streams an upload to a new file in dir, hashing it on the way. Every upload
+// gets its own file so a rejected upload never removes one already accepted.
+func storeUpload(dir, name string, body io.Reader, limit int64) (string, int64, string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, "", fmt.Errorf("failed to create upload directory: %v", err)
+	}
+
+	file, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to create upload file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(body, limit+1))
+	if err != nil {
+		os.Remove(file.Name())
+		return "", 0, "", fmt.Errorf("failed to write upload: %v", err)
+	}
+	if size > limit {
+		os.Remove(file.Name())
+		return "", 0, "", fmt.Errorf("upload exceeds %d bytes", limit)
+	}
+
+	return file.Name(), size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// federatedCandidates returns the schedulable nodes that can train for a job, most free
+// CPU first
+func (co *CentralOrchestrator) federatedCandidates(job *FederatedJob) []*EdgeNode {
+	// The training task is sized and admitted like a one-replica workload
+	task := &Workload{Name: job.Name, Tenant: job.Tenant, Resources: job.Resources}
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	committed := committedResources(co.WorkloadManager.workloads)
+	var candidates []*EdgeNode
+	for _, node := range co.NodeManager.nodes {
+		if !co.nodeSchedulable(node) || !job.Nodes.matchesNode(node) {
+			continue
+		}
+		if job.Accelerator == FederatedAcceleratorGPU && node.Resources.GPUs < 1 && !contains(node.Capabilities, "gpu") {
+			continue
+		}
+		if !co.fitsOnNode(node, committed[node.ID], task, 1) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Resources.CPU.Percentage < candidates[j].Resources.CPU.Percentage
+	})
+	return candidates
+}
+
+// startRound opens the next round for every participant; callers must hold the
+// FederatedJobManager lock
+func (fm *FederatedJobManager) startRound(job *FederatedJob, now time.Time) {
+	round := &FederatedRound{
+		Number:       len(job.Rounds) + 1,
+		Status:       FederatedRoundTraining,
+		StartedAt:    now,
+		Deadline:     now.Add(job.roundTimeout()),
+		Participants: make(map[string]*RoundParticipation, len(job.Participants)),
+	}
+	if previous := job.round(len(job.Rounds)); previous != nil {
+		round.GlobalModel = previous.AggregatedModel
+	}
+	for _, nodeID := range job.Participants {
+		round.Participants[nodeID] = &RoundParticipation{Status: ParticipationAssigned, UpdatedAt: now}
+	}
+
+	job.Rounds = append(job.Rounds, round)
+	job.CurrentRound = round.Number
+	job.Status = FederatedJobTraining
+	job.UpdatedAt = now
+	fm.logger.Infof("Federated job %s round %d started on %d nodes", job.Name, round.Number, len(job.Participants))
+}
+
+// closeRound ends a round once every participant reported or the deadline passed, and
+// hands it to aggregation if enough updates arrived; callers must hold the
+// FederatedJobManager lock
+func (fm *FederatedJobManager) closeRound(job *FederatedJob, now time.Time) {
+	round := job.round(job.CurrentRound)
+	if round == nil || round.Status != FederatedRoundTraining {
+		return
+	}
+
+	outstanding := 0
+	for _, participation := range round.Participants {
+		if participation.Status == ParticipationAssigned || participation.Status == ParticipationTraining {
+			outstanding++
+		}
+	}
+	if outstanding > 0 && now.Before(round.Deadline) {
+		return
+	}
+
+	for _, participation := range round.Participants {
+		if participation.Status == ParticipationAssigned || participation.Status == ParticipationTraining {
+			participation.Status = ParticipationTimedOut
+			participation.UpdatedAt = now
+		}
+	}
+	round.ClosedAt = &now
+	job.UpdatedAt = now
+
+	submitted := round.submittedUpdates()
+	if submitted < job.MinUpdates {
+		round.Status = FederatedRoundFailed
+		job.Status = FederatedJobFailed
+		job.Error = fmt.Sprintf("round %d received %d of %d required updates", round.Number, submitted, job.MinUpdates)
+		fm.logger.Warnf("Federated job %s failed: %s", job.Name, job.Error)
+		return
+	}
+
+	round.Status = FederatedRoundAggregating
+	job.Status = FederatedJobAggregating
+	fm.logger.Infof("Federated job %s round %d closed with %d updates, awaiting aggregation", job.Name, round.Number, submitted)
+}
+
+// completeAggregation records a round's aggregated model and starts the next round or
+// completes the job; callers must hold the FederatedJobManager lock
+func (fm *FederatedJobManager) completeAggregation(job *FederatedJob, round *FederatedRound, model *ModelArtifact, now time.Time) {
+	round.AggregatedModel = model
+	round.Status = FederatedRoundCompleted
+	job.UpdatedAt = now
+
+	if round.Number >= job.TotalRounds {
+		job.Status = FederatedJobCompleted
+		fm.logger.Infof("Federated job %s completed after %d rounds", job.Name, round.Number)
+		return
+	}
+	fm.startRound(job, now)
+}
+
+// reconcileFederatedJobs selects participants for pending jobs and closes rounds that
+// are done or past their deadline
+func (co *CentralOrchestrator) reconcileFederatedJobs(now time.Time) {
+	fm := co.FederatedJobManager
+
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	for _, job := range fm.jobs {
+		switch job.Status {
+		case FederatedJobPending:
+			candidates := co.federatedCandidates(job)
+			if len(candidates) < job.MinParticipants {
+				continue
+			}
+			if len(candidates) > job.MaxParticipants {
+				candidates = candidates[:job.MaxParticipants]
+			}
+			for _, node := range candidates {
+				job.Participants = append(job.Participants, node.ID)
+			}
+			fm.startRound(job, now)
+		case FederatedJobTraining:
+			fm.closeRound(job, now)
+		}
+	}
+}
+
+// federatedController periodically advances federated jobs
+func (co *CentralOrchestrator) federatedController() {
+	ticker := time.NewTicker(FederatedControlInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.reconcileFederatedJobs(time.Now())
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FederatedTask is a node's assignment in the current round of a federated job
+type FederatedTask struct {
+	JobID       string              `json:"job_id"`
+	JobName     string              `json:"job_name"`
+	Round       int                 `json:"round"`
+	Namespace   string              `json:"namespace"`
+	Image       string              `json:"image"`
+	Command     []string            `json:"command,omitempty"`
+	Environment map[string]string   `json:"environment,omitempty"`
+	Resources   WorkloadResources   `json:"resources"`
+	Accelerator string              `json:"accelerator"`
+	Deadline    time.Time           `json:"deadline"`
+	GlobalModel *ModelArtifact      `json:"global_model,omitempty"`
+	Status      ParticipationStatus `json:"status"`
+}
+
+// FederatedTaskStatusRequest reports a node's training progress in a round
+type FederatedTaskStatusRequest struct {
+	Status ParticipationStatus `json:"status" binding:"required"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// federatedRoundParam resolves the job and round named in the request path; callers
+// must hold the FederatedJobManager lock
+func (co *CentralOrchestrator) federatedRoundParam(c *gin.Context, jobParam string) (*FederatedJob, *FederatedRound, bool) {
+	job, exists := co.FederatedJobManager.jobs[c.Param(jobParam)]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Federated job not found"})
+		return nil, nil, false
+	}
+	number, err := strconv.Atoi(c.Param("round"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid round number"})
+		return nil, nil, false
+	}
+	round := job.round(number)
+	if round == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Round not found"})
+		return nil, nil, false
+	}
+	return job, round, true
+}
+
+// CreateFederatedJob submits a federated learning job; participants are selected once
+// enough capable nodes are available
+func (co *CentralOrchestrator) CreateFederatedJob(c *gin.Context) {
+	var req FederatedJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	job := &FederatedJob{
+		ID:                  generateID(),
+		FederatedJobRequest: req,
+		Status:              FederatedJobPending,
+		Participants:        make([]string, 0),
+		Rounds:              make([]*FederatedRound, 0),
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+
+	co.FederatedJobManager.mutex.Lock()
+	co.FederatedJobManager.jobs[job.ID] = job
+	co.FederatedJobManager.mutex.Unlock()
+
+	co.Logger.Infof("Federated job %s created: %d rounds on %d-%d %s nodes",
+		job.Name, job.TotalRounds, job.MinParticipants, job.MaxParticipants, job.Accelerator)
+
+	c.JSON(http.StatusCreated, gin.H{"job": job})
+}
+
+// ListFederatedJobs lists the federated jobs of the tenants the caller may act on, newest first
+func (co *CentralOrchestrator) ListFederatedJobs(c *gin.Context) {
+	co.FederatedJobManager.mutex.RLock()
+	defer co.FederatedJobManager.mutex.RUnlock()
+
+	jobs := make([]*FederatedJob, 0, len(co.FederatedJobManager.jobs))
+	for _, job := range co.FederatedJobManager.jobs {
+		if tenant := c.Query("tenant"); tenant != "" && job.Tenant != tenant {
+			continue
+		}
+		if !tenantAllowed(c, job.Tenant) {
+			continue
+		}
+		if namespace := c.Query("namespace"); namespace != "" && job.Namespace != namespace {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetFederatedJob returns a federated job with per-round participation
+func (co *CentralOrchestrator) GetFederatedJob(c *gin.Context) {
+	co.FederatedJobManager.mutex.RLock()
+	defer co.FederatedJobManager.mutex.RUnlock()
+
+	job, exists := co.FederatedJobManager.jobs[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Federated job not found"})
+		return
+	}
+	if !tenantAllowed(c, job.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", job.Tenant)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// CancelFederatedJob stops a federated job; agents tear down their training on the next poll
+func (co *CentralOrchestrator) CancelFederatedJob(c *gin.Context) {
+	co.FederatedJobManager.mutex.Lock()
+	defer co.FederatedJobManager.mutex.Unlock()
+
+	job, exists := co.FederatedJobManager.jobs[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Federated job not found"})
+		return
+	}
+	switch job.Status {
+	case FederatedJobCompleted, FederatedJobFailed, FederatedJobCancelled:
+		c.JSON(http.StatusConflict, gin.H{"error": "Federated job has already finished"})
+		return
+	}
+
+	job.Status = FederatedJobCancelled
+	job.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Federated job %s cancelled", job.Name)
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// GetFederatedUpdate downloads the model update a node submitted in a round, for aggregation
+func (co *CentralOrchestrator) GetFederatedUpdate(c *gin.Context) {
+	co.FederatedJobManager.mutex.RLock()
+	_, round, ok := co.federatedRoundParam(c, "id")
+	var update *ModelArtifact
+	if ok {
+		if participation, exists := round.Participants[c.Param("node")]; exists {
+			update = participation.Update
+		}
+	}
+	co.FederatedJobManager.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	if update == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No update submitted by this node"})
+		return
+	}
+
+	c.Header("X-Content-SHA256", update.SHA256)
+	c.File(update.path)
+}
+
+// UploadAggregatedModel stores the model aggregated from a round's updates. It becomes the
+// global model of the next round, or the job's final model after the last round.
+func (co *CentralOrchestrator) UploadAggregatedModel(c *gin.Context) {
+	fm := co.FederatedJobManager
+
+	// Resolve the round before accepting the body so unknown paths never reach the disk
+	fm.mutex.RLock()
+	_, round, ok := co.federatedRoundParam(c, "id")
+	fm.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	name := fmt.Sprintf("round-%d-aggregated.model", round.Number)
+	model, err := fm.storeArtifact(c.Param("id"), name, c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	job := fm.jobs[c.Param("id")]
+	if job.Status != FederatedJobAggregating || round.Status != FederatedRoundAggregating {
+		os.Remove(model.path)
+		c.JSON(http.StatusConflict, gin.H{"error": "Round is not awaiting aggregation"})
+		return
+	}
+
+	fm.completeAggregation(job, round, model, time.Now())
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// GetFederatedModel downloads the latest aggregated model of a federated job
+func (co *CentralOrchestrator) GetFederatedModel(c *gin.Context) {
+	co.FederatedJobManager.mutex.RLock()
+	var model *ModelArtifact
+	job, exists := co.FederatedJobManager.jobs[c.Param("id")]
+	if exists {
+		for _, round := range job.Rounds {
+			if round.AggregatedModel != nil {
+				model = round.AggregatedModel
+			}
+		}
+	}
+	co.FederatedJobManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Federated job not found"})
+		return
+	}
+	if model == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No round has been aggregated yet"})
+		return
+	}
+
+	c.Header("X-Content-SHA256", model.SHA256)
+	c.File(model.path)
+}
+
+// GetNodeFederatedTasks returns the rounds a node should currently be training in
+func (co *CentralOrchestrator) GetNodeFederatedTasks(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.FederatedJobManager.mutex.RLock()
+	defer co.FederatedJobManager.mutex.RUnlock()
+
+	tasks := make([]FederatedTask, 0)
+	for _, job := range co.FederatedJobManager.jobs {
+		if job.Status != FederatedJobTraining {
+			continue
+		}
+		round := job.round(job.CurrentRound)
+		participation, exists := round.Participants[nodeID]
+		if !exists {
+			continue
+		}
+		if participation.Status != ParticipationAssigned && participation.Status != ParticipationTraining {
+			continue
+		}
+		tasks = append(tasks, FederatedTask{
+			JobID:       job.ID,
+			JobName:     job.Name,
+			Round:       round.Number,
+			Namespace:   job.Namespace,
+			Image:       job.Image,
+			Command:     job.Command,
+			Environment: job.Environment,
+			Resources:   job.Resources,
+			Accelerator: job.Accelerator,
+			Deadline:    round.Deadline,
+			GlobalModel: round.GlobalModel,
+			Status:      participation.Status,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tasks": tasks})
+}
+
+// ReportFederatedTaskStatus records that a node started training or failed in a round
+func (co *CentralOrchestrator) ReportFederatedTaskStatus(c *gin.Context) {
+	var req FederatedTaskStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != ParticipationTraining && req.Status != ParticipationFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be training or failed"})
+		return
+	}
+
+	co.FederatedJobManager.mutex.Lock()
+	defer co.FederatedJobManager.mutex.Unlock()
+
+	job, round, ok := co.federatedRoundParam(c, "job")
+	if !ok {
+		return
+	}
+	participation, exists := round.Participants[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node is not participating in this round"})
+		return
+	}
+	if round.Status != FederatedRoundTraining ||
+		(participation.Status != ParticipationAssigned && participation.Status != ParticipationTraining) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Round is no longer accepting reports from this node"})
+		return
+	}
+
+	participation.Status = req.Status
+	participation.Error = req.Error
+	participation.UpdatedAt = time.Now()
+
+	if req.Status == ParticipationFailed {
+		co.Logger.Warnf("Node %s failed round %d of federated job %s: %s", c.Param("id"), round.Number, job.Name, req.Error)
+	}
+	c.JSON(http.StatusOK, gin.H{"participation": participation})
+}
+
+// UploadFederatedUpdate stores the model update a node trained in a round. The body is the
+// raw artifact; X-Sample-Count carries the number of local samples it was trained on so
+// the aggregator can weight it.
+func (co *CentralOrchestrator) UploadFederatedUpdate(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var samples int64
+	if header := c.GetHeader("X-Sample-Count"); header != "" {
+		var err error
+		if samples, err = strconv.ParseInt(header, 10, 64); err != nil || samples < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid X-Sample-Count header"})
+			return
+		}
+	}
+
+	fm := co.FederatedJobManager
+
+	fm.mutex.RLock()
+	_, round, ok := co.federatedRoundParam(c, "job")
+	fm.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	name := fmt.Sprintf("round-%d-%s.update", round.Number, nodeID)
+	update, err := fm.storeArtifact(c.Param("job"), name, c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	job := fm.jobs[c.Param("job")]
+	participation, exists := round.Participants[nodeID]
+	if !exists {
+		os.Remove(update.path)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node is not participating in this round"})
+		return
+	}
+	if round.Status != FederatedRoundTraining ||
+		(participation.Status != ParticipationAssigned && participation.Status != ParticipationTraining) {
+		os.Remove(update.path)
+		c.JSON(http.StatusConflict, gin.H{"error": "Round is no longer accepting updates from this node"})
+		return
+	}
+
+	participation.Status = ParticipationSubmitted
+	participation.Update = update
+	participation.Samples = samples
+	participation.Error = ""
+	participation.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Node %s submitted round %d update for federated job %s (%d bytes, %d samples)",
+		nodeID, round.Number, job.Name, update.SizeBytes, samples)
+
+	// Close the round straight away once the last participant reports
+	fm.closeRound(job, participation.UpdatedAt)
+
+	c.JSON(http.StatusOK, gin.H{"participation": participation})
+}
+
+// GetNodeFederatedModel downloads the global model a node should start a round from
+func (co *CentralOrchestrator) GetNodeFederatedModel(c *gin.Context) {
+	co.FederatedJobManager.mutex.RLock()
+	_, round, ok := co.federatedRoundParam(c, "job")
+	var model *ModelArtifact
+	if ok {
+		if _, participating := round.Participants[c.Param("id")]; participating {
+			model = round.GlobalModel
+		}
+	}
+	co.FederatedJobManager.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	if model == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No global model for this round"})
+		return
+	}
+
+	c.Header("X-Content-SHA256", model.SHA256)
+	c.File(model.path)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldSelection is the ?fields= projection of a response: the JSON fields to keep of each
+// object, as dotted paths such as status or resources.cpu. The id is always kept.
+type fieldSelection [][]string
+
+// parseFieldSelection reads ?fields=, checking the first field of each path against the
+// JSON fields of the kind of object projected
+func parseFieldSelection(c *gin.Context, object interface{}) (fieldSelection, error) {
+	value := c.Query("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	known := jsonFieldNames(reflect.TypeOf(object))
+	selection := fieldSelection{{"id"}}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		path := strings.Split(field, ".")
+		if !known[path[0]] {
+			return nil, fmt.Errorf("unknown field %q", path[0])
+		}
+		selection = append(selection, path)
+	}
+	return selection, nil
+}
+
+// jsonFieldNames returns the names a struct type's fields have in JSON
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// project returns an object, or each object of a slice, with only the selected fields; with
+// no selection it returns value unchanged
+func (fs fieldSelection) project(value interface{}) (interface{}, error) {
+	if fs == nil {
+		return value, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if list, isList := decoded.([]interface{}); isList {
+		for i, item := range list {
+			list[i] = fs.pick(item)
+		}
+		return list, nil
+	}
+	return fs.pick(decoded), nil
+}
+
+// pick copies the selected paths of a decoded object into a new one
+func (fs fieldSelection) pick(item interface{}) interface{} {
+	object, isObject := item.(map[string]interface{})
+	if !isObject {
+		return item
+	}
+
+	result := make(map[string]interface{})
+	for _, path := range fs {
+		var value interface{} = object
+		found := true
+		for _, key := range path {
+			parent, isObject := value.(map[string]interface{})
+			if !isObject {
+				found = false
+				break
+			}
+			if value, found = parent[key]; !found {
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		target := result
+		for _, key := range path[:len(path)-1] {
+			next, exists := target[key].(map[string]interface{})
+			if !exists {
+				next = make(map[string]interface{})
+				target[key] = next
+			}
+			target = next
+		}
+		target[path[len(path)-1]] = value
+	}
+	return result
+}
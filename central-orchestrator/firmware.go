@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Interval between firmware campaign progress checks
+	CampaignCheckInterval = 15 * time.Second
+)
+
+// HardwareInventory is the hardware and firmware an agent found on its host. Fields the
+// host does not expose are left empty.
+type HardwareInventory struct {
+	SystemVendor string            `json:"system_vendor,omitempty"`
+	ProductName  string            `json:"product_name,omitempty"`
+	BIOSVendor   string            `json:"bios_vendor,omitempty"`
+	BIOSVersion  string            `json:"bios_version,omitempty"`
+	BIOSDate     string            `json:"bios_date,omitempty"`
+	BMCVersion   string            `json:"bmc_version,omitempty"`
+	Firmware     map[string]string `json:"firmware,omitempty"`
+	CollectedAt  time.Time         `json:"collected_at"`
+}
+
+// firmwareVersion returns the version of a component: "bios", "bmc", or a device name
+// from the firmware map
+func (h *HardwareInventory) firmwareVersion(component string) string {
+	if h == nil {
+		return ""
+	}
+	switch component {
+	case "bios":
+		return h.BIOSVersion
+	case "bmc":
+		return h.BMCVersion
+	default:
+		return h.Firmware[component]
+	}
+}
+
+// CampaignStatus represents the progress of a firmware upgrade campaign
+type CampaignStatus string
+
+const (
+	CampaignRunning   CampaignStatus = "running"
+	CampaignCompleted CampaignStatus = "completed"
+	CampaignPartial   CampaignStatus = "partial"
+	CampaignCancelled CampaignStatus = "cancelled"
+)
+
+// CampaignPhase is the step a node is at within a campaign
+type CampaignPhase string
+
+const (
+	CampaignPhasePreHook  CampaignPhase = "pre-hook"
+	CampaignPhaseUpdate   CampaignPhase = "update"
+	CampaignPhasePostHook CampaignPhase = "post-hook"
+	CampaignPhaseDone     CampaignPhase = "done"
+)
+
+// CampaignNodeStatus represents a node's progress in a campaign
+type CampaignNodeStatus string
+
+const (
+	CampaignNodePending   CampaignNodeStatus = "pending"
+	CampaignNodeRunning   CampaignNodeStatus = "running"
+	CampaignNodeSucceeded CampaignNodeStatus = "succeeded"
+	CampaignNodeFailed    CampaignNodeStatus = "failed"
+)
+
+// CampaignNode tracks one node through a campaign's hooks and update
+type CampaignNode struct {
+	NodeID      string             `json:"node_id"`
+	FromVersion string             `json:"from_version"`
+	Version     string             `json:"version,omitempty"`
+	Phase       CampaignPhase      `json:"phase,omitempty"`
+	Status      CampaignNodeStatus `json:"status"`
+	CommandID   string             `json:"command_id,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// UpgradeCampaign rolls a firmware update across the nodes of a group whose component is at
+// one of the given versions, running the pre-hook, update and post-hook on each node through
+// the node command channel
+type UpgradeCampaign struct {
+	ID string `json:"id"`
+	NodeGroup
+	Name           string          `json:"name"`
+	Component      string          `json:"component"`
+	FromVersions   []string        `json:"from_versions"`
+	TargetVersion  string          `json:"target_version"`
+	PreHook        []string        `json:"pre_hook,omitempty"`
+	UpdateCommand  []string        `json:"update_command"`
+	PostHook       []string        `json:"post_hook,omitempty"`
+	TimeoutSeconds int             `json:"timeout_seconds"`
+	MaxParallel    int             `json:"max_parallel"`
+	Status         CampaignStatus  `json:"status"`
+	Nodes          []*CampaignNode `json:"nodes"`
+	CreatedAt      time.Time       `json:"created_at"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
+}
+
+// UpgradeCampaignRequest represents a firmware upgrade campaign creation request. With no
+// from_versions, every node whose component is not already at target_version is targeted.
+type UpgradeCampaignRequest struct {
+	Name           string            `json:"name" binding:"required"`
+	NodeSelector   map[string]string `json:"node_selector"`
+	SiteID         string            `json:"site_id"`
+	Component      string            `json:"component" binding:"required"`
+	FromVersions   []string          `json:"from_versions"`
+	TargetVersion  string            `json:"target_version"`
+	PreHook        []string          `json:"pre_hook"`
+	UpdateCommand  []string          `json:"update_command" binding:"required"`
+	PostHook       []string          `json:"post_hook"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	MaxParallel    int               `json:"max_parallel"`
+}
+
+// CampaignManager tracks firmware upgrade campaigns
+type CampaignManager struct {
+	campaigns map[string]*UpgradeCampaign
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewCampaignManager creates a new campaign manager
+func NewCampaignManager(logger *logrus.Logger) *CampaignManager {
+	return &CampaignManager{
+		campaigns: make(map[string]*UpgradeCampaign),
+		logger:    logger,
+	}
+}
+
+// ReportNodeHardware stores the hardware inventory an agent collected
+func (co *CentralOrchestrator) ReportNodeHardware(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var inventory HardwareInventory
+	if err := c.ShouldBindJSON(&inventory); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if inventory.CollectedAt.IsZero() {
+		inventory.CollectedAt = time.Now()
+	}
+
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	node.Hardware = &inventory
+	node.UpdatedAt = time.Now()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Hardware inventory updated"})
+}
+
+// phaseCommand returns the command for a campaign phase; hooks may be empty
+func (uc *UpgradeCampaign) phaseCommand(phase CampaignPhase) []string {
+	switch phase {
+	case CampaignPhasePreHook:
+		return uc.PreHook
+	case CampaignPhaseUpdate:
+		return uc.UpdateCommand
+	case CampaignPhasePostHook:
+		return uc.PostHook
+	default:
+		return nil
+	}
+}
+
+// nextPhase returns the first phase after the given one that has a command to run
+func (uc *UpgradeCampaign) nextPhase(phase CampaignPhase) CampaignPhase {
+	phases := []CampaignPhase{CampaignPhasePreHook, CampaignPhaseUpdate, CampaignPhasePostHook}
+	started := phase == ""
+	for _, candidate := range phases {
+		if started && len(uc.phaseCommand(candidate)) > 0 {
+			return candidate
+		}
+		if candidate == phase {
+			started = true
+		}
+	}
+	return CampaignPhaseDone
+}
+
+// campaignController advances running firmware campaigns
+func (co *CentralOrchestrator) campaignController() {
+	ticker := time.NewTicker(CampaignCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.advanceCampaigns()
+		}
+	}
+}
+
+// advanceCampaigns moves each campaign node to its next phase once the previous command
+// finishes and starts pending nodes while the campaign is under its parallelism limit
+func (co *CentralOrchestrator) advanceCampaigns() {
+	online := co.onlineNodes(nil)
+
+	co.CampaignManager.mutex.Lock()
+	defer co.CampaignManager.mutex.Unlock()
+
+	for _, campaign := range co.CampaignManager.campaigns {
+		if campaign.Status != CampaignRunning {
+			continue
+		}
+
+		active := 0
+		for _, cn := range campaign.Nodes {
+			if cn.Status == CampaignNodeRunning {
+				co.advanceCampaignNode(campaign, cn, online[cn.NodeID])
+			}
+			if cn.Status == CampaignNodeRunning {
+				active++
+			}
+		}
+
+		for _, cn := range campaign.Nodes {
+			if active >= campaign.MaxParallel {
+				break
+			}
+			if cn.Status != CampaignNodePending || online[cn.NodeID] == nil {
+				continue
+			}
+			cn.Status = CampaignNodeRunning
+			co.startCampaignPhase(campaign, cn, campaign.nextPhase(""))
+			active++
+		}
+
+		co.finishCampaignIfDone(campaign)
+	}
+}
+
+// advanceCampaignNode checks the node's current command and starts the next phase
+func (co *CentralOrchestrator) advanceCampaignNode(campaign *UpgradeCampaign, cn *CampaignNode, node *EdgeNode) {
+	cmd, exists := co.CommandManager.Get(cn.CommandID)
+	if !exists {
+		co.failCampaignNode(cn, "command record lost")
+		return
+	}
+
+	switch cmd.Status {
+	case NodeCommandSucceeded:
+		co.startCampaignPhase(campaign, cn, campaign.nextPhase(cn.Phase))
+	case NodeCommandFailed:
+		message := cmd.Error
+		if message == "" {
+			message = fmt.Sprintf("exit code %d", cmd.ExitCode)
+		}
+		co.failCampaignNode(cn, fmt.Sprintf("%s failed: %s", cn.Phase, message))
+	}
+
+	if cn.Status == CampaignNodeSucceeded && node != nil {
+		cn.Version = node.Hardware.firmwareVersion(campaign.Component)
+	}
+}
+
+// startCampaignPhase queues the phase's command, or completes the node after the last phase
+func (co *CentralOrchestrator) startCampaignPhase(campaign *UpgradeCampaign, cn *CampaignNode, phase CampaignPhase) {
+	cn.Phase = phase
+	cn.UpdatedAt = time.Now()
+
+	if phase == CampaignPhaseDone {
+		cn.Status = CampaignNodeSucceeded
+		cn.CommandID = ""
+		co.Logger.Infof("Campaign %s finished on node %s", campaign.ID, cn.NodeID)
+		return
+	}
+
+	cmd := co.CommandManager.Enqueue(cn.NodeID, "campaign:"+campaign.ID, campaign.phaseCommand(phase),
+		time.Duration(campaign.TimeoutSeconds)*time.Second)
+	cn.CommandID = cmd.ID
+}
+
+// failCampaignNode marks a campaign node failed
+func (co *CentralOrchestrator) failCampaignNode(cn *CampaignNode, message string) {
+	cn.Status = CampaignNodeFailed
+	cn.Error = message
+	cn.UpdatedAt = time.Now()
+	co.Logger.Errorf("Campaign step failed on node %s: %s", cn.NodeID, message)
+}
+
+// finishCampaignIfDone completes a campaign once every node has succeeded or failed
+func (co *CentralOrchestrator) finishCampaignIfDone(campaign *UpgradeCampaign) {
+	failed := 0
+	for _, cn := range campaign.Nodes {
+		switch cn.Status {
+		case CampaignNodePending, CampaignNodeRunning:
+			return
+		case CampaignNodeFailed:
+			failed++
+		}
+	}
+
+	now := time.Now()
+	campaign.CompletedAt = &now
+	campaign.Status = CampaignCompleted
+	if failed > 0 {
+		campaign.Status = CampaignPartial
+	}
+	co.Logger.Infof("Campaign %s %s (%d of %d nodes failed)", campaign.ID, campaign.Status, failed, len(campaign.Nodes))
+}
+
+// CreateUpgradeCampaign starts a firmware upgrade campaign against the nodes whose current
+// firmware version matches
+func (co *CentralOrchestrator) CreateUpgradeCampaign(c *gin.Context) {
+	var req UpgradeCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.UpdateCommand) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "update_command must not be empty"})
+		return
+	}
+	if len(req.FromVersions) == 0 && req.TargetVersion == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_versions or target_version is required"})
+		return
+	}
+	if req.MaxParallel <= 0 {
+		req.MaxParallel = 1
+	}
+	if req.TimeoutSeconds <= 0 {
+		req.TimeoutSeconds = int(DefaultNodeCommandTimeout.Seconds())
+	}
+
+	now := time.Now()
+	campaign := &UpgradeCampaign{
+		ID:             generateID(),
+		NodeGroup:      NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID},
+		Name:           req.Name,
+		Component:      req.Component,
+		FromVersions:   req.FromVersions,
+		TargetVersion:  req.TargetVersion,
+		PreHook:        req.PreHook,
+		UpdateCommand:  req.UpdateCommand,
+		PostHook:       req.PostHook,
+		TimeoutSeconds: req.TimeoutSeconds,
+		MaxParallel:    req.MaxParallel,
+		Status:         CampaignRunning,
+		Nodes:          make([]*CampaignNode, 0),
+		CreatedAt:      now,
+	}
+	if campaign.NodeSelector == nil {
+		campaign.NodeSelector = make(map[string]string)
+	}
+
+	co.NodeManager.mutex.RLock()
+	for _, node := range co.NodeManager.nodes {
+		version := node.Hardware.firmwareVersion(req.Component)
+		if version == "" || !campaign.matchesNode(node) {
+			continue
+		}
+		if len(req.FromVersions) > 0 && !contains(req.FromVersions, version) {
+			continue
+		}
+		if len(req.FromVersions) == 0 && version == req.TargetVersion {
+			continue
+		}
+		campaign.Nodes = append(campaign.Nodes, &CampaignNode{
+			NodeID:      node.ID,
+			FromVersion: version,
+			Status:      CampaignNodePending,
+			UpdatedAt:   now,
+		})
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	if len(campaign.Nodes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No nodes match the campaign's firmware selection"})
+		return
+	}
+	sort.Slice(campaign.Nodes, func(i, j int) bool {
+		return campaign.Nodes[i].NodeID < campaign.Nodes[j].NodeID
+	})
+
+	co.CampaignManager.mutex.Lock()
+	co.CampaignManager.campaigns[campaign.ID] = campaign
+	co.CampaignManager.mutex.Unlock()
+
+	co.Logger.Infof("Firmware campaign %s created with ID %s targeting %d nodes", campaign.Name, campaign.ID, len(campaign.Nodes))
+
+	c.JSON(http.StatusCreated, gin.H{"id": campaign.ID, "campaign": campaign})
+}
+
+// ListUpgradeCampaigns returns all firmware campaigns, newest first
+func (co *CentralOrchestrator) ListUpgradeCampaigns(c *gin.Context) {
+	co.CampaignManager.mutex.RLock()
+	defer co.CampaignManager.mutex.RUnlock()
+
+	campaigns := make([]*UpgradeCampaign, 0, len(co.CampaignManager.campaigns))
+	for _, campaign := range co.CampaignManager.campaigns {
+		campaigns = append(campaigns, campaign)
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].CreatedAt.After(campaigns[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"campaigns": campaigns})
+}
+
+// GetUpgradeCampaign returns a specific firmware campaign
+func (co *CentralOrchestrator) GetUpgradeCampaign(c *gin.Context) {
+	co.CampaignManager.mutex.RLock()
+	defer co.CampaignManager.mutex.RUnlock()
+
+	campaign, exists := co.CampaignManager.campaigns[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"campaign": campaign})
+}
+
+// CancelUpgradeCampaign stops a campaign; commands already queued on nodes are failed
+func (co *CentralOrchestrator) CancelUpgradeCampaign(c *gin.Context) {
+	co.CampaignManager.mutex.Lock()
+	defer co.CampaignManager.mutex.Unlock()
+
+	campaign, exists := co.CampaignManager.campaigns[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	if campaign.Status != CampaignRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Campaign is not running"})
+		return
+	}
+
+	now := time.Now()
+	for _, cn := range campaign.Nodes {
+		if cn.Status == CampaignNodeRunning && cn.CommandID != "" {
+			co.CommandManager.Cancel(cn.CommandID, "campaign cancelled")
+		}
+	}
+	campaign.Status = CampaignCancelled
+	campaign.CompletedAt = &now
+
+	co.Logger.Infof("Firmware campaign %s cancelled", campaign.ID)
+
+	c.JSON(http.StatusOK, gin.H{"campaign": campaign})
+}
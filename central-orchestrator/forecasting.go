@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How often forecasts are retrained from the metrics store
+	ForecastInterval = time.Hour
+
+	// Hourly history forecasts are trained on
+	ForecastTrainingWindow = 28 * 24 * time.Hour
+
+	// Default number of hours predicted ahead
+	DefaultForecastHorizonHours = 6
+
+	// Daily seasonality of edge site load, in hourly points
+	ForecastSeasonLength = 24
+
+	// Model names reported with a forecast
+	ForecastModelHoltWinters = "holt-winters"
+	ForecastModelHolt        = "holt"
+)
+
+// Node metrics that are forecast; site forecasts average them over the site's nodes
+var forecastMetrics = []string{"cpu_percent", "memory_percent"}
+
+// Smoothing parameters searched when fitting a model
+var (
+	forecastAlphas = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+	forecastBetas  = []float64{0.01, 0.05, 0.1, 0.2}
+	forecastGammas = []float64{0.05, 0.1, 0.3, 0.5}
+)
+
+// ForecastPoint is a predicted hourly value with a 95% interval
+type ForecastPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+	Lower     float64   `json:"lower"`
+	Upper     float64   `json:"upper"`
+}
+
+// LoadForecast predicts one metric of a node or site for the next few hours
+type LoadForecast struct {
+	Metric         string          `json:"metric"`
+	Model          string          `json:"model"`
+	TrainedAt      time.Time       `json:"trained_at"`
+	TrainingPoints int             `json:"training_points"`
+	RMSE           float64         `json:"rmse"`
+	Points         []ForecastPoint `json:"points"`
+}
+
+// Peak returns the highest predicted value between now and now+within
+func (f *LoadForecast) Peak(now time.Time, within time.Duration) (float64, bool) {
+	peak, found := 0.0, false
+	for _, point := range f.Points {
+		// Points are hour buckets, so the one in progress counts too
+		if point.Timestamp.Add(time.Hour).Before(now) || point.Timestamp.After(now.Add(within)) {
+			continue
+		}
+		if !found || point.Value > peak {
+			peak, found = point.Value, true
+		}
+	}
+	return peak, found
+}
+
+// SiteForecast is the predicted load of a site, averaged over its nodes
+type SiteForecast struct {
+	SiteID    string                   `json:"site_id"`
+	Nodes     int                      `json:"nodes"`
+	Forecasts map[string]*LoadForecast `json:"forecasts"`
+}
+
+// LoadForecaster trains per-node Holt-Winters models on the metrics store's hourly history
+// and keeps the latest node and site forecasts
+type LoadForecaster struct {
+	nodes   map[string]map[string]*LoadForecast
+	sites   map[string]*SiteForecast
+	horizon int
+	mutex   sync.RWMutex
+	logger  *logrus.Logger
+}
+
+// NewLoadForecaster creates a load forecaster; FORECAST_HORIZON_HOURS sets how far ahead
+// it predicts
+func NewLoadForecaster(logger *logrus.Logger) *LoadForecaster {
+	horizon := DefaultForecastHorizonHours
+	if value := os.Getenv("FORECAST_HORIZON_HOURS"); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours < 1 || hours > ForecastSeasonLength {
+			logger.Warnf("Invalid FORECAST_HORIZON_HOURS %q, using %d", value, horizon)
+		} else {
+			horizon = hours
+		}
+	}
+
+	return &LoadForecaster{
+		nodes:   make(map[string]map[string]*LoadForecast),
+		sites:   make(map[string]*SiteForecast),
+		horizon: horizon,
+		logger:  logger,
+	}
+}
+
+// NodeForecast returns a node's latest forecast of a metric
+func (lf *LoadForecaster) NodeForecast(nodeID, metric string) (*LoadForecast, bool) {
+	lf.mutex.RLock()
+	defer lf.mutex.RUnlock()
+
+	forecast, exists := lf.nodes[nodeID][metric]
+	return forecast, exists
+}
+
+// SiteForecast returns a site's latest forecast of a metric
+func (lf *LoadForecaster) SiteForecast(siteID, metric string) (*LoadForecast, bool) {
+	lf.mutex.RLock()
+	defer lf.mutex.RUnlock()
+
+	site, exists := lf.sites[siteID]
+	if !exists {
+		return nil, false
+	}
+	forecast, exists := site.Forecasts[metric]
+	return forecast, exists
+}
+
+// CapacityForecast is the predicted peak utilization reported with a node's capacity
+type CapacityForecast struct {
+	HorizonHours      int      `json:"horizon_hours"`
+	PeakCPUPercent    *float64 `json:"peak_cpu_percent,omitempty"`
+	PeakMemoryPercent *float64 `json:"peak_memory_percent,omitempty"`
+}
+
+// capacityForecast returns a node's predicted peaks over the forecast horizon, or nil
+// before its first forecast
+func (co *CentralOrchestrator) capacityForecast(nodeID string) *CapacityForecast {
+	lf := co.LoadForecaster
+	now := time.Now()
+	horizon := time.Duration(lf.horizon) * time.Hour
+
+	forecast := &CapacityForecast{HorizonHours: lf.horizon}
+	for metric, target := range map[string]**float64{
+		"cpu_percent":    &forecast.PeakCPUPercent,
+		"memory_percent": &forecast.PeakMemoryPercent,
+	} {
+		if nodeForecast, exists := lf.NodeForecast(nodeID, metric); exists {
+			if peak, ok := nodeForecast.Peak(now, horizon); ok {
+				*target = &peak
+			}
+		}
+	}
+
+	if forecast.PeakCPUPercent == nil && forecast.PeakMemoryPercent == nil {
+		return nil
+	}
+	return forecast
+}
+
+// hourlySeries resamples hourly history onto a gapless grid, carrying the last value
+// across missing hours
+func hourlySeries(points []MetricHistoryPoint) ([]float64, time.Time) {
+	if len(points) == 0 {
+		return nil, time.Time{}
+	}
+
+	start := points[0].Timestamp
+	hours := int(points[len(points)-1].Timestamp.Sub(start)/time.Hour) + 1
+	values := make([]float64, hours)
+	filled := make([]bool, hours)
+	for _, point := range points {
+		i := int(point.Timestamp.Sub(start) / time.Hour)
+		values[i], filled[i] = point.Avg, true
+	}
+	for i := 1; i < hours; i++ {
+		if !filled[i] {
+			values[i] = values[i-1]
+		}
+	}
+	return values, start
+}
+
+// holtWinters runs additive triple exponential smoothing over values and returns the
+// one-step-ahead squared error and the next horizon predictions
+func holtWinters(values []float64, season int, alpha, beta, gamma float64, horizon int) (float64, []float64) {
+	var first, second float64
+	for i := 0; i < season; i++ {
+		first += values[i]
+		second += values[season+i]
+	}
+	first /= float64(season)
+	second /= float64(season)
+
+	level := first
+	trend := (second - first) / float64(season)
+	seasonal := make([]float64, season)
+	for i := 0; i < season; i++ {
+		seasonal[i] = values[i] - first
+	}
+
+	sse := 0.0
+	for t := season; t < len(values); t++ {
+		s := seasonal[t%season]
+		predicted := level + trend + s
+		sse += (values[t] - predicted) * (values[t] - predicted)
+
+		previous := level
+		level = alpha*(values[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+		seasonal[t%season] = gamma*(values[t]-level) + (1-gamma)*s
+	}
+
+	predictions := make([]float64, horizon)
+	for h := 1; h <= horizon; h++ {
+		predictions[h-1] = level + float64(h)*trend + seasonal[(len(values)+h-1)%season]
+	}
+	return sse / float64(len(values)-season), predictions
+}
+
+// holt runs double exponential smoothing for series too short to show a season
+func holt(values []float64, alpha, beta float64, horizon int) (float64, []float64) {
+	level, trend := values[0], values[1]-values[0]
+
+	sse := 0.0
+	for t := 1; t < len(values); t++ {
+		predicted := level + trend
+		sse += (values[t] - predicted) * (values[t] - predicted)
+
+		previous := level
+		level = alpha*values[t] + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+	}
+
+	predictions := make([]float64, horizon)
+	for h := 1; h <= horizon; h++ {
+		predictions[h-1] = level + float64(h)*trend
+	}
+	return sse / float64(len(values)-1), predictions
+}
+
+// fitForecast picks the smoothing parameters with the lowest one-step-ahead error. Two
+// full days are needed for the seasonal model; shorter history falls back to Holt.
+func fitForecast(metric string, values []float64, start time.Time, horizon int, now time.Time) (*LoadForecast, error) {
+	if len(values) < 3 {
+		return nil, fmt.Errorf("not enough history to forecast %s", metric)
+	}
+
+	model := ForecastModelHolt
+	if len(values) >= 2*ForecastSeasonLength {
+		model = ForecastModelHoltWinters
+	}
+
+	bestMSE := math.Inf(1)
+	var best []float64
+	for _, alpha := range forecastAlphas {
+		for _, beta := range forecastBetas {
+			if model == ForecastModelHolt {
+				if mse, predictions := holt(values, alpha, beta, horizon); mse < bestMSE {
+					bestMSE, best = mse, predictions
+				}
+				continue
+			}
+			for _, gamma := range forecastGammas {
+				if mse, predictions := holtWinters(values, ForecastSeasonLength, alpha, beta, gamma, horizon); mse < bestMSE {
+					bestMSE, best = mse, predictions
+				}
+			}
+		}
+	}
+
+	rmse := math.Sqrt(bestMSE)
+	forecast := &LoadForecast{
+		Metric:         metric,
+		Model:          model,
+		TrainedAt:      now,
+		TrainingPoints: len(values),
+		RMSE:           rmse,
+		Points:         make([]ForecastPoint, 0, horizon),
+	}
+	next := start.Add(time.Duration(len(values)) * time.Hour)
+	for h, value := range best {
+		forecast.Points = append(forecast.Points, ForecastPoint{
+			Timestamp: next.Add(time.Duration(h) * time.Hour),
+			Value:     clampPercent(value),
+			Lower:     clampPercent(value - 1.96*rmse),
+			Upper:     clampPercent(value + 1.96*rmse),
+		})
+	}
+	return forecast, nil
+}
+
+func clampPercent(value float64) float64 {
+	return math.Max(0, math.Min(100, value))
+}
+
+// averageForecasts combines node forecasts of one metric hour by hour
+func averageForecasts(metric string, forecasts []*LoadForecast, now time.Time) *LoadForecast {
+	type bucket struct {
+		value, lower, upper float64
+		count               int
+	}
+	buckets := make(map[int64]*bucket)
+	trainingPoints := 0
+	rmse := 0.0
+	for _, forecast := range forecasts {
+		trainingPoints += forecast.TrainingPoints
+		rmse += forecast.RMSE
+		for _, point := range forecast.Points {
+			b, exists := buckets[point.Timestamp.Unix()]
+			if !exists {
+				b = &bucket{}
+				buckets[point.Timestamp.Unix()] = b
+			}
+			b.value += point.Value
+			b.lower += point.Lower
+			b.upper += point.Upper
+			b.count++
+		}
+	}
+
+	site := &LoadForecast{
+		Metric:         metric,
+		Model:          forecasts[0].Model,
+		TrainedAt:      now,
+		TrainingPoints: trainingPoints,
+		RMSE:           rmse / float64(len(forecasts)),
+	}
+	for _, forecast := range forecasts {
+		if forecast.Model != site.Model {
+			site.Model = "mixed"
+		}
+	}
+	for at, b := range buckets {
+		n := float64(b.count)
+		site.Points = append(site.Points, ForecastPoint{
+			Timestamp: time.Unix(at, 0).UTC(),
+			Value:     b.value / n,
+			Lower:     b.lower / n,
+			Upper:     b.upper / n,
+		})
+	}
+	sort.Slice(site.Points, func(i, j int) bool {
+		return site.Points[i].Timestamp.Before(site.Points[j].Timestamp)
+	})
+	return site
+}
+
+// refreshForecasts retrains every node's models and rebuilds the site forecasts
+func (co *CentralOrchestrator) refreshForecasts() {
+	now := time.Now()
+	lf := co.LoadForecaster
+
+	co.NodeManager.mutex.RLock()
+	nodeSites := make(map[string]string, len(co.NodeManager.nodes))
+	for _, node := range co.NodeManager.nodes {
+		if node.Status != NodeStatusOffline {
+			nodeSites[node.ID] = node.SiteID
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	nodes := make(map[string]map[string]*LoadForecast, len(nodeSites))
+	siteForecasts := make(map[string]map[string][]*LoadForecast)
+	siteNodes := make(map[string]int)
+	for nodeID, siteID := range nodeSites {
+		for _, metric := range forecastMetrics {
+			history, _, err := co.MetricsStore.History(MetricClassNode, metric, nodeID, "1h", now.Add(-ForecastTrainingWindow), now)
+			if err != nil {
+				lf.logger.Warnf("Failed to read %s history for node %s: %v", metric, nodeID, err)
+				continue
+			}
+			values, start := hourlySeries(history)
+			forecast, err := fitForecast(metric, values, start, lf.horizon, now)
+			if err != nil {
+				continue
+			}
+
+			if nodes[nodeID] == nil {
+				nodes[nodeID] = make(map[string]*LoadForecast)
+			}
+			nodes[nodeID][metric] = forecast
+
+			if siteID != "" {
+				if siteForecasts[siteID] == nil {
+					siteForecasts[siteID] = make(map[string][]*LoadForecast)
+				}
+				siteForecasts[siteID][metric] = append(siteForecasts[siteID][metric], forecast)
+			}
+		}
+		if _, trained := nodes[nodeID]; trained && siteID != "" {
+			siteNodes[siteID]++
+		}
+	}
+
+	sites := make(map[string]*SiteForecast, len(siteForecasts))
+	for siteID, metrics := range siteForecasts {
+		site := &SiteForecast{SiteID: siteID, Nodes: siteNodes[siteID], Forecasts: make(map[string]*LoadForecast)}
+		for metric, forecasts := range metrics {
+			site.Forecasts[metric] = averageForecasts(metric, forecasts, now)
+		}
+		sites[siteID] = site
+	}
+
+	lf.mutex.Lock()
+	lf.nodes = nodes
+	lf.sites = sites
+	lf.mutex.Unlock()
+
+	lf.logger.Debugf("Load forecasts refreshed for %d nodes and %d sites", len(nodes), len(sites))
+}
+
+// loadForecaster periodically retrains load forecasts
+func (co *CentralOrchestrator) loadForecaster() {
+	co.refreshForecasts()
+
+	ticker := time.NewTicker(ForecastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.refreshForecasts()
+		}
+	}
+}
+
+// GetNodeForecast returns a node's predicted CPU and memory load
+func (co *CentralOrchestrator) GetNodeForecast(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.NodeManager.mutex.RLock()
+	_, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	co.LoadForecaster.mutex.RLock()
+	forecasts := co.LoadForecaster.nodes[nodeID]
+	co.LoadForecaster.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"node_id": nodeID, "forecasts": forecasts})
+}
+
+// GetSiteForecast returns a site's predicted CPU and memory load
+func (co *CentralOrchestrator) GetSiteForecast(c *gin.Context) {
+	siteID := c.Param("id")
+
+	co.SiteManager.mutex.RLock()
+	_, exists := co.SiteManager.sites[siteID]
+	co.SiteManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	co.LoadForecaster.mutex.RLock()
+	forecast, exists := co.LoadForecaster.sites[siteID]
+	co.LoadForecaster.mutex.RUnlock()
+
+	if !exists {
+		forecast = &SiteForecast{SiteID: siteID, Forecasts: map[string]*LoadForecast{}}
+	}
+	c.JSON(http.StatusOK, gin.H{"forecast": forecast})
+}
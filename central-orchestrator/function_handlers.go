@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FunctionRuntime is the runtime workload serving functions at a site
+type FunctionRuntime struct {
+	SiteID     string         `json:"site_id"`
+	WorkloadID string         `json:"workload_id"`
+	Status     WorkloadStatus `json:"status"`
+	Version    string         `json:"config_version"`
+	Functions  int            `json:"functions"`
+}
+
+// CreateFunction registers a function. OCI functions are served straight away; bundle
+// functions once their code is uploaded.
+func (co *CentralOrchestrator) CreateFunction(c *gin.Context) {
+	var req FunctionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	siteIDs := co.siteIDs()
+	for _, siteID := range req.Sites {
+		if !contains(siteIDs, siteID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown site: " + siteID})
+			return
+		}
+	}
+
+	now := time.Now()
+	function := &Function{
+		ID:              generateID(),
+		FunctionRequest: req,
+		Status:          FunctionStatusPending,
+		SiteMetrics:     make(map[string]*FunctionMetrics),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if req.Source.Type == FunctionSourceOCI {
+		function.Status = FunctionStatusReady
+	}
+
+	co.FunctionManager.mutex.Lock()
+	if conflict := co.FunctionManager.httpRouteConflict(function, siteIDs); conflict != nil {
+		co.FunctionManager.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "HTTP trigger path is already served by function " + conflict.Name})
+		return
+	}
+	co.FunctionManager.functions[function.ID] = function
+	co.FunctionManager.mutex.Unlock()
+
+	co.Logger.Infof("Function %s created (%s, %d triggers)", function.Name, function.Source.Type, len(function.Triggers))
+
+	if function.Status == FunctionStatusReady {
+		co.syncFunctionRuntimes()
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"function": function})
+}
+
+// ListFunctions lists the functions of the tenants the caller may act on with their
+// rolled-up invocation metrics
+func (co *CentralOrchestrator) ListFunctions(c *gin.Context) {
+	co.FunctionManager.mutex.RLock()
+	defer co.FunctionManager.mutex.RUnlock()
+
+	functions := make([]*Function, 0, len(co.FunctionManager.functions))
+	for _, function := range co.FunctionManager.functions {
+		if tenant := c.Query("tenant"); tenant != "" && function.Tenant != tenant {
+			continue
+		}
+		if !tenantAllowed(c, function.Tenant) {
+			continue
+		}
+		if site := c.Query("site"); site != "" && !function.servesSite(site) {
+			continue
+		}
+		functions = append(functions, function)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Name < functions[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{"functions": functions})
+}
+
+// GetFunction returns a function with its per-site metrics
+func (co *CentralOrchestrator) GetFunction(c *gin.Context) {
+	co.FunctionManager.mutex.RLock()
+	defer co.FunctionManager.mutex.RUnlock()
+
+	function, exists := co.FunctionManager.functions[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if !tenantAllowed(c, function.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", function.Tenant)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"function": function})
+}
+
+// DeleteFunction removes a function; runtimes drop it on their next configuration poll
+func (co *CentralOrchestrator) DeleteFunction(c *gin.Context) {
+	co.FunctionManager.mutex.Lock()
+	function, exists := co.FunctionManager.functions[c.Param("id")]
+	if exists {
+		delete(co.FunctionManager.functions, function.ID)
+	}
+	co.FunctionManager.mutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if function.Bundle != nil {
+		os.Remove(function.Bundle.path)
+	}
+
+	co.Logger.Infof("Function %s deleted", function.Name)
+	co.syncFunctionRuntimes()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Function deleted"})
+}
+
+// UploadFunctionBundle stores a function's code archive. The body is the raw archive;
+// uploading again replaces the code and runtimes reload it on their next poll.
+func (co *CentralOrchestrator) UploadFunctionBundle(c *gin.Context) {
+	fm := co.FunctionManager
+
+	fm.mutex.RLock()
+	function, exists := fm.functions[c.Param("id")]
+	fm.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if function.Source.Type != FunctionSourceBundle {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Function is packaged as an OCI image"})
+		return
+	}
+
+	path, size, digest, err := storeUpload(fm.bundleDir, function.ID, c.Request.Body, FunctionMaxBundleBytes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fm.mutex.Lock()
+	if _, exists := fm.functions[function.ID]; !exists {
+		fm.mutex.Unlock()
+		os.Remove(path)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	previous := function.Bundle
+	now := time.Now()
+	function.Bundle = &FunctionBundle{path: path, SizeBytes: size, SHA256: digest, UploadedAt: now}
+	function.Status = FunctionStatusReady
+	function.UpdatedAt = now
+	fm.mutex.Unlock()
+
+	if previous != nil {
+		os.Remove(previous.path)
+	}
+
+	co.Logger.Infof("Function %s bundle uploaded (%d bytes, sha256 %s)", function.Name, size, digest)
+	co.syncFunctionRuntimes()
+
+	c.JSON(http.StatusOK, gin.H{"function": function})
+}
+
+// GetFunctionBundle downloads a function's code archive
+func (co *CentralOrchestrator) GetFunctionBundle(c *gin.Context) {
+	co.FunctionManager.mutex.RLock()
+	var bundle *FunctionBundle
+	function, exists := co.FunctionManager.functions[c.Param("id")]
+	if exists {
+		bundle = function.Bundle
+	}
+	co.FunctionManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if bundle == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No bundle uploaded for this function"})
+		return
+	}
+
+	c.Header("X-Content-SHA256", bundle.SHA256)
+	c.File(bundle.path)
+}
+
+// ListFunctionRuntimes lists the runtime workload at each site and what it serves
+func (co *CentralOrchestrator) ListFunctionRuntimes(c *gin.Context) {
+	co.FunctionManager.mutex.RLock()
+	defer co.FunctionManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	runtimes := make([]FunctionRuntime, 0, len(co.FunctionManager.runtimes))
+	for siteID, workloadID := range co.FunctionManager.runtimes {
+		config := co.FunctionManager.runtimeConfig(siteID)
+		runtime := FunctionRuntime{
+			SiteID:     siteID,
+			WorkloadID: workloadID,
+			Version:    config.Version,
+			Functions:  len(config.Functions),
+		}
+		if workload, exists := co.WorkloadManager.workloads[workloadID]; exists {
+			runtime.Status = workload.Status
+		}
+		runtimes = append(runtimes, runtime)
+	}
+	sort.Slice(runtimes, func(i, j int) bool {
+		return runtimes[i].SiteID < runtimes[j].SiteID
+	})
+
+	c.JSON(http.StatusOK, gin.H{"runtimes": runtimes})
+}
+
+// GetFunctionRuntimeConfig returns the functions and triggers a site's runtime should
+// serve. Runtimes pass their current version in If-None-Match and get 304 if unchanged.
+func (co *CentralOrchestrator) GetFunctionRuntimeConfig(c *gin.Context) {
+	co.FunctionManager.mutex.RLock()
+	config := co.FunctionManager.runtimeConfig(c.Param("site"))
+	co.FunctionManager.mutex.RUnlock()
+
+	c.Header("ETag", config.Version)
+	if c.GetHeader("If-None-Match") == config.Version {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// ReportFunctionMetrics rolls a site runtime's invocation counters into each function's
+// totals. Runtimes report counts since their previous report, not running totals.
+func (co *CentralOrchestrator) ReportFunctionMetrics(c *gin.Context) {
+	var report FunctionMetricsReport
+	if err := c.ShouldBindJSON(&report); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, stats := range report.Functions {
+		if stats.Invocations < 0 || stats.Errors < 0 || stats.ColdStarts < 0 || stats.DurationMsTotal < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invocation counts must not be negative"})
+			return
+		}
+	}
+
+	siteID := c.Param("site")
+	now := time.Now()
+
+	co.FunctionManager.mutex.Lock()
+	defer co.FunctionManager.mutex.Unlock()
+
+	accepted := 0
+	for _, stats := range report.Functions {
+		function, exists := co.FunctionManager.functions[stats.FunctionID]
+		if !exists {
+			// Deleted since the runtime loaded it
+			continue
+		}
+		site, exists := function.SiteMetrics[siteID]
+		if !exists {
+			site = &FunctionMetrics{}
+			function.SiteMetrics[siteID] = site
+		}
+		site.add(stats, now)
+		function.Metrics.add(stats, now)
+		accepted++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accepted": accepted})
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How often per-site function runtimes are reconciled with the functions deployed
+	FunctionRuntimeSyncInterval = 30 * time.Second
+
+	// Runtime image when FUNCTION_RUNTIME_IMAGE is unset
+	DefaultFunctionRuntimeImage = "edge-framework/function-runtime:latest"
+
+	// Where code bundles are kept when FUNCTION_BUNDLE_DIR is unset
+	DefaultFunctionBundleDir = "/var/lib/edge-orchestrator/functions"
+
+	// Largest code bundle accepted
+	FunctionMaxBundleBytes = 64 << 20
+
+	// Port the runtime serves HTTP triggers on
+	FunctionRuntimePort = 8080
+
+	// Label identifying the site a function runtime workload serves
+	FunctionRuntimeLabel = "edge.io/function-runtime"
+)
+
+// Runtimes code bundles can be written for; OCI functions bring their own
+var functionRuntimes = []string{"python3", "nodejs", "go"}
+
+// FunctionSourceType is how a function's code is packaged
+type FunctionSourceType string
+
+const (
+	// Archive uploaded to the orchestrator and loaded by the runtime
+	FunctionSourceBundle FunctionSourceType = "bundle"
+	// Container image the runtime starts for the function
+	FunctionSourceOCI FunctionSourceType = "oci"
+)
+
+// FunctionTriggerType is what invokes a function
+type FunctionTriggerType string
+
+const (
+	FunctionTriggerHTTP FunctionTriggerType = "http"
+	FunctionTriggerMQTT FunctionTriggerType = "mqtt"
+)
+
+// FunctionStatus is whether a function can be served
+type FunctionStatus string
+
+const (
+	// Waiting for its code bundle
+	FunctionStatusPending FunctionStatus = "pending"
+	FunctionStatusReady   FunctionStatus = "ready"
+)
+
+// FunctionSource says where a function's code comes from
+type FunctionSource struct {
+	Type FunctionSourceType `json:"type" binding:"required"`
+	// Image reference for OCI functions
+	Image string `json:"image,omitempty"`
+}
+
+// FunctionBundle is an uploaded code archive
+type FunctionBundle struct {
+	path       string
+	SizeBytes  int64     `json:"size_bytes"`
+	SHA256     string    `json:"sha256"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// FunctionTrigger routes events to a function. HTTP triggers are served by the site's
+// runtime at Path; MQTT triggers subscribe to Topic on Broker.
+type FunctionTrigger struct {
+	Type    FunctionTriggerType `json:"type" binding:"required"`
+	Path    string              `json:"path,omitempty"`
+	Methods []string            `json:"methods,omitempty"`
+	Broker  string              `json:"broker,omitempty"`
+	Topic   string              `json:"topic,omitempty"`
+	QoS     int                 `json:"qos,omitempty"`
+}
+
+// validate checks the fields a trigger type needs
+func (t *FunctionTrigger) validate() error {
+	switch t.Type {
+	case FunctionTriggerHTTP:
+		if !strings.HasPrefix(t.Path, "/") {
+			return fmt.Errorf("http trigger path must start with /")
+		}
+		if len(t.Methods) == 0 {
+			t.Methods = []string{"POST"}
+		}
+	case FunctionTriggerMQTT:
+		if t.Broker == "" || t.Topic == "" {
+			return fmt.Errorf("mqtt trigger requires broker and topic")
+		}
+		if t.QoS < 0 || t.QoS > 2 {
+			return fmt.Errorf("mqtt trigger qos must be 0, 1 or 2")
+		}
+	default:
+		return fmt.Errorf("trigger type must be http or mqtt")
+	}
+	return nil
+}
+
+// FunctionRequest deploys a function
+type FunctionRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Tenant      string            `json:"tenant"`
+	Runtime     string            `json:"runtime"`
+	Handler     string            `json:"handler"`
+	Source      FunctionSource    `json:"source" binding:"required"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Triggers    []FunctionTrigger `json:"triggers" binding:"required,min=1"`
+	// Sites to serve the function at; empty means every site
+	Sites          []string `json:"sites,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+	MemoryMB       int      `json:"memory_mb"`
+	MaxConcurrency int      `json:"max_concurrency"`
+}
+
+// validate checks the source and triggers and applies defaults
+func (req *FunctionRequest) validate() error {
+	if req.Tenant == "" {
+		req.Tenant = DefaultTenant
+	}
+	switch req.Source.Type {
+	case FunctionSourceBundle:
+		if !contains(functionRuntimes, req.Runtime) {
+			return fmt.Errorf("runtime must be one of %s", strings.Join(functionRuntimes, ", "))
+		}
+		if req.Handler == "" {
+			return fmt.Errorf("handler is required for bundle functions")
+		}
+	case FunctionSourceOCI:
+		if req.Source.Image == "" {
+			return fmt.Errorf("source image is required for oci functions")
+		}
+	default:
+		return fmt.Errorf("source type must be bundle or oci")
+	}
+	for i := range req.Triggers {
+		if err := req.Triggers[i].validate(); err != nil {
+			return err
+		}
+	}
+	if req.TimeoutSeconds == 0 {
+		req.TimeoutSeconds = 30
+	}
+	if req.MemoryMB == 0 {
+		req.MemoryMB = 128
+	}
+	if req.MaxConcurrency == 0 {
+		req.MaxConcurrency = 10
+	}
+	if req.TimeoutSeconds < 0 || req.MemoryMB < 0 || req.MaxConcurrency < 0 {
+		return fmt.Errorf("timeout_seconds, memory_mb and max_concurrency must not be negative")
+	}
+	return nil
+}
+
+// FunctionMetrics are invocation counters rolled up from the runtimes
+type FunctionMetrics struct {
+	Invocations       int64      `json:"invocations"`
+	Errors            int64      `json:"errors"`
+	ColdStarts        int64      `json:"cold_starts"`
+	DurationMsTotal   float64    `json:"-"`
+	AverageDurationMs float64    `json:"average_duration_ms"`
+	LastInvokedAt     *time.Time `json:"last_invoked_at,omitempty"`
+}
+
+// add folds a runtime's counters since its last report into the totals
+func (m *FunctionMetrics) add(stats FunctionInvocationStats, at time.Time) {
+	m.Invocations += stats.Invocations
+	m.Errors += stats.Errors
+	m.ColdStarts += stats.ColdStarts
+	m.DurationMsTotal += stats.DurationMsTotal
+	if m.Invocations > 0 {
+		m.AverageDurationMs = m.DurationMsTotal / float64(m.Invocations)
+	}
+	if stats.Invocations > 0 {
+		m.LastInvokedAt = &at
+	}
+}
+
+// Function is a piece of code served by the function runtime at each of its sites
+type Function struct {
+	ID string `json:"id"`
+	FunctionRequest
+	Bundle      *FunctionBundle             `json:"bundle,omitempty"`
+	Status      FunctionStatus              `json:"status"`
+	Metrics     FunctionMetrics             `json:"metrics"`
+	SiteMetrics map[string]*FunctionMetrics `json:"site_metrics"`
+	CreatedAt   time.Time                   `json:"created_at"`
+	UpdatedAt   time.Time                   `json:"updated_at"`
+}
+
+// servesSite reports whether the function is deployed to a site
+func (f *Function) servesSite(siteID string) bool {
+	return len(f.Sites) == 0 || contains(f.Sites, siteID)
+}
+
+// FunctionManager manages functions and the per-site runtimes that serve them
+type FunctionManager struct {
+	functions    map[string]*Function
+	runtimes     map[string]string // site ID -> runtime workload ID
+	runtimeImage string
+	bundleDir    string
+	mutex        sync.RWMutex
+	logger       *logrus.Logger
+}
+
+// NewFunctionManager creates a function manager; FUNCTION_RUNTIME_IMAGE sets the runtime
+// deployed to each site and FUNCTION_BUNDLE_DIR where code bundles are stored
+func NewFunctionManager(logger *logrus.Logger) *FunctionManager {
+	runtimeImage := os.Getenv("FUNCTION_RUNTIME_IMAGE")
+	if runtimeImage == "" {
+		runtimeImage = DefaultFunctionRuntimeImage
+	}
+	bundleDir := os.Getenv("FUNCTION_BUNDLE_DIR")
+	if bundleDir == "" {
+		bundleDir = DefaultFunctionBundleDir
+	}
+
+	return &FunctionManager{
+		functions:    make(map[string]*Function),
+		runtimes:     make(map[string]string),
+		runtimeImage: runtimeImage,
+		bundleDir:    bundleDir,
+		logger:       logger,
+	}
+}
+
+// httpRouteConflict returns a function already serving one of the function's HTTP routes
+// at a site they share; callers must hold the FunctionManager lock
+func (fm *FunctionManager) httpRouteConflict(function *Function, siteIDs []string) *Function {
+	for _, other := range fm.functions {
+		if other.ID == function.ID {
+			continue
+		}
+		shared := false
+		for _, siteID := range siteIDs {
+			if function.servesSite(siteID) && other.servesSite(siteID) {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			continue
+		}
+		for _, trigger := range function.Triggers {
+			for _, existing := range other.Triggers {
+				if trigger.Type == FunctionTriggerHTTP && existing.Type == FunctionTriggerHTTP && trigger.Path == existing.Path {
+					return other
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// siteIDs returns the IDs of every site
+func (co *CentralOrchestrator) siteIDs() []string {
+	co.SiteManager.mutex.RLock()
+	defer co.SiteManager.mutex.RUnlock()
+
+	ids := make([]string, 0, len(co.SiteManager.sites))
+	for id := range co.SiteManager.sites {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// syncFunctionRuntimes runs one function runtime workload at every site serving a ready
+// function and removes runtimes from sites that no longer serve any
+func (co *CentralOrchestrator) syncFunctionRuntimes() {
+	siteIDs := co.siteIDs()
+	fm := co.FunctionManager
+
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	needed := make(map[string]bool)
+	for _, function := range fm.functions {
+		if function.Status != FunctionStatusReady {
+			continue
+		}
+		for _, siteID := range siteIDs {
+			if function.servesSite(siteID) {
+				needed[siteID] = true
+			}
+		}
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	now := time.Now()
+	for siteID, workloadID := range fm.runtimes {
+		if _, exists := co.WorkloadManager.workloads[workloadID]; !exists {
+			// Deleted by an operator; recreate it below if still needed
+			delete(fm.runtimes, siteID)
+			continue
+		}
+		if needed[siteID] {
+			continue
+		}
+		workload := co.WorkloadManager.workloads[workloadID]
+		workload.Status = WorkloadStatusStopped
+		workload.UpdatedAt = now
+		delete(co.WorkloadManager.workloads, workloadID)
+		co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, workloadID)
+		co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workloadID)
+		delete(fm.runtimes, siteID)
+		fm.logger.Infof("Removed function runtime from site %s", siteID)
+	}
+
+	for siteID := range needed {
+		if _, exists := fm.runtimes[siteID]; exists {
+			continue
+		}
+		workload, err := newWorkload(WorkloadDeploymentRequest{
+			Name:  "function-runtime-" + nameSlug(siteID),
+			Type:  WorkloadTypeDeployment,
+			Image: fm.runtimeImage,
+			Environment: map[string]string{
+				"FUNCTION_SITE_ID":     siteID,
+				"FUNCTION_CONFIG_URL":  fmt.Sprintf("/api/v1/function-runtimes/%s/config", siteID),
+				"FUNCTION_METRICS_URL": fmt.Sprintf("/api/v1/function-runtimes/%s/metrics", siteID),
+			},
+			Labels: map[string]string{FunctionRuntimeLabel: siteID},
+			Placement: PlacementPolicy{
+				Constraints: []PlacementConstraint{{Key: "site", Operator: "In", Values: []string{siteID}}},
+			},
+			Ports: []WorkloadPort{{Name: "http", Port: FunctionRuntimePort}},
+		}, now)
+		if err != nil {
+			fm.logger.Errorf("Failed to create function runtime for site %s: %v", siteID, err)
+			continue
+		}
+		co.WorkloadManager.workloads[workload.ID] = workload
+		fm.runtimes[siteID] = workload.ID
+		fm.logger.Infof("Deploying function runtime to site %s as workload %s", siteID, workload.ID)
+	}
+}
+
+// functionRuntimeController periodically reconciles function runtimes, picking up new sites
+func (co *CentralOrchestrator) functionRuntimeController() {
+	ticker := time.NewTicker(FunctionRuntimeSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.syncFunctionRuntimes()
+		}
+	}
+}
+
+// RuntimeFunction is a function as the site runtime loads it
+type RuntimeFunction struct {
+	ID             string             `json:"id"`
+	Name           string             `json:"name"`
+	Runtime        string             `json:"runtime,omitempty"`
+	Handler        string             `json:"handler,omitempty"`
+	Source         FunctionSourceType `json:"source"`
+	Image          string             `json:"image,omitempty"`
+	BundleURL      string             `json:"bundle_url,omitempty"`
+	BundleSHA256   string             `json:"bundle_sha256,omitempty"`
+	Environment    map[string]string  `json:"environment,omitempty"`
+	Triggers       []FunctionTrigger  `json:"triggers"`
+	TimeoutSeconds int                `json:"timeout_seconds"`
+	MemoryMB       int                `json:"memory_mb"`
+	MaxConcurrency int                `json:"max_concurrency"`
+}
+
+// FunctionRuntimeConfig is everything a site's runtime serves. Version changes whenever
+// the configuration does, so runtimes can poll cheaply.
+type FunctionRuntimeConfig struct {
+	SiteID    string            `json:"site_id"`
+	Version   string            `json:"version"`
+	Functions []RuntimeFunction `json:"functions"`
+}
+
+// runtimeConfig builds the configuration for a site's runtime; callers must hold the
+// FunctionManager lock
+func (fm *FunctionManager) runtimeConfig(siteID string) FunctionRuntimeConfig {
+	functions := make([]RuntimeFunction, 0)
+	for _, function := range fm.functions {
+		if function.Status != FunctionStatusReady || !function.servesSite(siteID) {
+			continue
+		}
+		runtimeFunction := RuntimeFunction{
+			ID:             function.ID,
+			Name:           function.Name,
+			Runtime:        function.Runtime,
+			Handler:        function.Handler,
+			Source:         function.Source.Type,
+			Image:          function.Source.Image,
+			Environment:    function.Environment,
+			Triggers:       function.Triggers,
+			TimeoutSeconds: function.TimeoutSeconds,
+			MemoryMB:       function.MemoryMB,
+			MaxConcurrency: function.MaxConcurrency,
+		}
+		if function.Bundle != nil {
+			runtimeFunction.BundleURL = fmt.Sprintf("/api/v1/functions/%s/bundle", function.ID)
+			runtimeFunction.BundleSHA256 = function.Bundle.SHA256
+		}
+		functions = append(functions, runtimeFunction)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].ID < functions[j].ID
+	})
+
+	data, _ := json.Marshal(functions)
+	sum := sha256.Sum256(data)
+	return FunctionRuntimeConfig{
+		SiteID:    siteID,
+		Version:   hex.EncodeToString(sum[:8]),
+		Functions: functions,
+	}
+}
+
+// FunctionInvocationStats are a runtime's counters for one function since its last report
+type FunctionInvocationStats struct {
+	FunctionID      string  `json:"function_id" binding:"required"`
+	Invocations     int64   `json:"invocations"`
+	Errors          int64   `json:"errors"`
+	ColdStarts      int64   `json:"cold_starts"`
+	DurationMsTotal float64 `json:"duration_ms_total"`
+}
+
+// FunctionMetricsReport is sent periodically by each site runtime
+type FunctionMetricsReport struct {
+	Functions []FunctionInvocationStats `json:"functions" binding:"dive"`
+}
+
+// functionMetricSamples returns the stored series for every function's rolled-up counters
+func (co *CentralOrchestrator) functionMetricSamples() []MetricSample {
+	co.FunctionManager.mutex.RLock()
+	defer co.FunctionManager.mutex.RUnlock()
+
+	var samples []MetricSample
+	for _, function := range co.FunctionManager.functions {
+		samples = append(samples,
+			MetricSample{Class: MetricClassFunction, Name: "invocations_total", EntityID: function.ID, Value: float64(function.Metrics.Invocations)},
+			MetricSample{Class: MetricClassFunction, Name: "errors_total", EntityID: function.ID, Value: float64(function.Metrics.Errors)},
+			MetricSample{Class: MetricClassFunction, Name: "average_duration_ms", EntityID: function.ID, Value: function.Metrics.AverageDurationMs})
+	}
+	return samples
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// GangPolicy makes scheduling all-or-nothing: either every replica is placed at once or
+// none is, so distributed jobs never run partially deployed
+type GangPolicy struct {
+	// Workloads of a tenant sharing a group are placed together as one bundle; without a
+	// group only the workload's own replicas form the gang
+	Group string `json:"group,omitempty"`
+	// Workloads in the group; nothing is placed until all of them have been submitted
+	Members int `json:"members,omitempty"`
+}
+
+// validate checks that a member count comes with a group
+func (g *GangPolicy) validate() error {
+	if g.Members < 0 {
+		return fmt.Errorf("gang members must not be negative")
+	}
+	if g.Members > 0 && g.Group == "" {
+		return fmt.Errorf("gang members requires a gang group")
+	}
+	return nil
+}
+
+// gangKey identifies the gang a workload is scheduled with
+func gangKey(workload *Workload) string {
+	if gang := workload.Placement.Gang; gang != nil && gang.Group != "" {
+		return workloadTenant(workload) + "/" + gang.Group
+	}
+	return workload.ID
+}
+
+// takeGangMembers removes the other pending members of a workload's gang from the
+// scheduling queues and returns the whole gang
+func takeGangMembers(workload *Workload, queues map[string][]*Workload) []*Workload {
+	members := []*Workload{workload}
+	if workload.Placement.Gang == nil || workload.Placement.Gang.Group == "" {
+		return members
+	}
+
+	key := gangKey(workload)
+	tenant := workloadTenant(workload)
+	remaining := queues[tenant][:0]
+	for _, queued := range queues[tenant] {
+		if queued.Placement.Gang != nil && gangKey(queued) == key {
+			members = append(members, queued)
+		} else {
+			remaining = append(remaining, queued)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(queues, tenant)
+	} else {
+		queues[tenant] = remaining
+	}
+	return members
+}
+
+// gangSize counts the submitted workloads of a gang; callers must hold the
+// WorkloadManager lock
+func (co *CentralOrchestrator) gangSize(key string) int {
+	size := 0
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.Placement.Gang != nil && gangKey(workload) == key {
+			size++
+		}
+	}
+	return size
+}
+
+// scheduleGang places every replica of every member or none of them. Members are
+// scheduled in turn, so later members see the capacity taken by earlier ones, and all
+// are rolled back if any replica can't be placed. Callers must hold the WorkloadManager
+// lock.
+func (co *CentralOrchestrator) scheduleGang(members []*Workload) error {
+	key := gangKey(members[0])
+	if want := members[0].Placement.Gang.Members; want > 0 {
+		if submitted := co.gangSize(key); submitted < want {
+			return fmt.Errorf("gang %s has %d of %d workloads submitted", key, submitted, want)
+		}
+	}
+
+	type snapshot struct {
+		deployments []WorkloadDeployment
+		status      WorkloadStatus
+		updatedAt   time.Time
+	}
+	snapshots := make([]snapshot, len(members))
+	for i, member := range members {
+		snapshots[i] = snapshot{
+			deployments: append([]WorkloadDeployment(nil), member.Deployments...),
+			status:      member.Status,
+			updatedAt:   member.UpdatedAt,
+		}
+	}
+
+	var err error
+	for _, member := range members {
+		before := member.runningReplicas()
+		needed := expectedReplicas(member) - before
+		if err = co.scheduleWorkload(member); err != nil {
+			break
+		}
+		if placed := member.runningReplicas() - before; placed < needed {
+			err = fmt.Errorf("only %d of %d replicas of workload %s can be placed", placed, needed, member.Name)
+			break
+		}
+	}
+
+	if err != nil {
+		for i, member := range members {
+			member.Deployments = snapshots[i].deployments
+			member.Status = snapshots[i].status
+			member.UpdatedAt = snapshots[i].updatedAt
+		}
+		return fmt.Errorf("gang %s not placed: %v", key, err)
+	}
+
+	co.Logger.Infof("Gang %s placed %d workloads together", key, len(members))
+	return nil
+}
@@ -5,10 +5,18 @@ go 1.21
 require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/gorilla/websocket v1.5.0
+	github.com/ishaqelkhalifa/kubernetes-edge-framework/api v0.0.0
 	k8s.io/api v0.28.4
 	k8s.io/apimachinery v0.28.4
 	k8s.io/client-go v0.28.4
 	github.com/sirupsen/logrus v1.9.3
 	gopkg.in/yaml.v2 v2.4.0
 	github.com/prometheus/client_golang v1.17.0
+	github.com/lib/pq v1.10.9
+	go.etcd.io/etcd/client/v3 v3.5.10
+	modernc.org/sqlite v1.27.0
+	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
 )
+
+replace github.com/ishaqelkhalifa/kubernetes-edge-framework/api => ../api
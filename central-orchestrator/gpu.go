@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// How a GPU lets several replicas use a share of it
+	GPUSharingTimeSlicing = "time-slicing"
+	GPUSharingMPS         = "mps"
+
+	// Shares adding up to within this of a whole card still fit on it
+	gpuShareEpsilon = 1e-6
+)
+
+// GPURequest is what each replica of a workload needs of a node's GPUs. Exactly one field
+// is set: whole cards, a share of one card used alongside other replicas, or one instance
+// of a MIG profile.
+type GPURequest struct {
+	Count int32 `json:"count,omitempty"`
+	// Fraction of one card, above 0 and below 1, on a card shared by time-slicing or MPS
+	Share float64 `json:"share,omitempty"`
+	// MIG profile such as "1g.5gb", on a card partitioned with MIG
+	MIGProfile string `json:"mig_profile,omitempty"`
+}
+
+// validate checks that a GPU request asks for exactly one kind of GPU
+func (r *GPURequest) validate() error {
+	kinds := 0
+	if r.Count != 0 {
+		if r.Count < 0 {
+			return fmt.Errorf("gpu count must not be negative")
+		}
+		kinds++
+	}
+	if r.Share != 0 {
+		if r.Share < 0 || r.Share >= 1 {
+			return fmt.Errorf("gpu share must be above 0 and below 1; request whole GPUs with count")
+		}
+		kinds++
+	}
+	if r.MIGProfile != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return fmt.Errorf("gpu requires exactly one of count, share or mig_profile")
+	}
+	return nil
+}
+
+// GPUDevice is a GPU an agent reports and how replicas may share it
+type GPUDevice struct {
+	Index    int    `json:"index"`
+	UUID     string `json:"uuid,omitempty"`
+	Model    string `json:"model,omitempty"`
+	MemoryMB int64  `json:"memory_mb,omitempty"`
+	// "time-slicing" or "mps" when replicas may request a share of the card; empty when
+	// it is only handed out whole
+	Sharing string `json:"sharing,omitempty"`
+	// Instances of each profile on a card partitioned with MIG, which is only used through them
+	MIGInstances map[string]int `json:"mig_instances,omitempty"`
+}
+
+// GPUClaim is a workload's GPU request for its replicas placed on a node
+type GPUClaim struct {
+	WorkloadID string     `json:"workload_id"`
+	Request    GPURequest `json:"request"`
+	Replicas   int32      `json:"replicas"`
+}
+
+// GPUAllocation is what the scheduler has allocated of one of a node's GPUs
+type GPUAllocation struct {
+	Device GPUDevice `json:"device"`
+	// Share of the card allocated; 1 when it is taken whole
+	Allocated float64 `json:"allocated"`
+	// MIG instances allocated by profile
+	MIGAllocated map[string]int `json:"mig_allocated,omitempty"`
+	Uses         []GPUUse       `json:"uses"`
+}
+
+// GPUUse is one replica's use of a GPU
+type GPUUse struct {
+	WorkloadID string  `json:"workload_id"`
+	Share      float64 `json:"share,omitempty"`
+	MIGProfile string  `json:"mig_profile,omitempty"`
+}
+
+// withGPUClaim returns the claims after adding (n > 0) or removing (n < 0) replicas of a
+// workload, leaving the given claims untouched so commitments can be copied freely
+func withGPUClaim(claims []GPUClaim, workload *Workload, n int32) []GPUClaim {
+	updated := make([]GPUClaim, 0, len(claims)+1)
+	found := false
+	for _, claim := range claims {
+		if claim.WorkloadID == workload.ID {
+			claim.Replicas += n
+			found = true
+		}
+		if claim.Replicas > 0 {
+			updated = append(updated, claim)
+		}
+	}
+	if !found && n > 0 {
+		updated = append(updated, GPUClaim{WorkloadID: workload.ID, Request: *workload.Resources.GPU, Replicas: n})
+	}
+	return updated
+}
+
+// nodeGPUDevices returns a node's GPUs. Nodes that only report a count, such as imported
+// clusters, have that many cards handed out whole.
+func nodeGPUDevices(node *EdgeNode) []GPUDevice {
+	if len(node.Resources.GPUDevices) > 0 {
+		return node.Resources.GPUDevices
+	}
+	devices := make([]GPUDevice, node.Resources.GPUs)
+	for i := range devices {
+		devices[i].Index = i
+	}
+	return devices
+}
+
+// packGPUs allocates claimed GPUs on a node's devices, each replica separately: whole cards
+// first, on cards that cannot be shared where possible, then MIG instances, then shares,
+// largest first, on the shareable card with the least room that fits them. ok is false when
+// some replica found no room; the allocations then leave it out.
+func packGPUs(devices []GPUDevice, claims []GPUClaim) ([]GPUAllocation, bool) {
+	allocations := make([]GPUAllocation, len(devices))
+	for i, device := range devices {
+		allocations[i] = GPUAllocation{Device: device, Uses: make([]GPUUse, 0)}
+	}
+
+	sorted := append([]GPUClaim(nil), claims...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].Request, sorted[j].Request
+		if a.Share != b.Share {
+			return a.Share > b.Share
+		}
+		return sorted[i].WorkloadID < sorted[j].WorkloadID
+	})
+
+	ok := true
+	for _, claim := range sorted {
+		if claim.Request.Count == 0 {
+			continue
+		}
+		for r := int32(0); r < claim.Replicas; r++ {
+			for c := int32(0); c < claim.Request.Count; c++ {
+				if !allocateWholeGPU(allocations, claim.WorkloadID) {
+					ok = false
+				}
+			}
+		}
+	}
+	for _, claim := range sorted {
+		if claim.Request.MIGProfile == "" {
+			continue
+		}
+		for r := int32(0); r < claim.Replicas; r++ {
+			if !allocateMIGInstance(allocations, claim.WorkloadID, claim.Request.MIGProfile) {
+				ok = false
+			}
+		}
+	}
+	for _, claim := range sorted {
+		if claim.Request.Share == 0 {
+			continue
+		}
+		for r := int32(0); r < claim.Replicas; r++ {
+			if !allocateGPUShare(allocations, claim.WorkloadID, claim.Request.Share) {
+				ok = false
+			}
+		}
+	}
+	return allocations, ok
+}
+
+// allocateWholeGPU takes a free card, preferring one that cannot be shared
+func allocateWholeGPU(allocations []GPUAllocation, workloadID string) bool {
+	var best *GPUAllocation
+	for i := range allocations {
+		a := &allocations[i]
+		if len(a.Device.MIGInstances) > 0 || len(a.Uses) > 0 {
+			continue
+		}
+		if best == nil || (best.Device.Sharing != "" && a.Device.Sharing == "") {
+			best = a
+		}
+	}
+	if best == nil {
+		return false
+	}
+	best.Allocated = 1
+	best.Uses = append(best.Uses, GPUUse{WorkloadID: workloadID, Share: 1})
+	return true
+}
+
+// allocateMIGInstance takes a free instance of a MIG profile
+func allocateMIGInstance(allocations []GPUAllocation, workloadID, profile string) bool {
+	for i := range allocations {
+		a := &allocations[i]
+		if a.MIGAllocated[profile] >= a.Device.MIGInstances[profile] {
+			continue
+		}
+		if a.MIGAllocated == nil {
+			a.MIGAllocated = make(map[string]int)
+		}
+		a.MIGAllocated[profile]++
+		a.Uses = append(a.Uses, GPUUse{WorkloadID: workloadID, MIGProfile: profile})
+		return true
+	}
+	return false
+}
+
+// allocateGPUShare takes a share of the shareable card with the least room that fits it
+func allocateGPUShare(allocations []GPUAllocation, workloadID string, share float64) bool {
+	var best *GPUAllocation
+	for i := range allocations {
+		a := &allocations[i]
+		if a.Device.Sharing == "" || len(a.Device.MIGInstances) > 0 || a.Allocated+share > 1+gpuShareEpsilon {
+			continue
+		}
+		if best == nil || a.Allocated > best.Allocated {
+			best = a
+		}
+	}
+	if best == nil {
+		return false
+	}
+	best.Allocated += share
+	best.Uses = append(best.Uses, GPUUse{WorkloadID: workloadID, Share: share})
+	return true
+}
+
+// gpusFitOnNode reports whether replicas of a workload requesting GPUs fit next to what is
+// committed on the node
+func gpusFitOnNode(node *EdgeNode, committed Commitment, workload *Workload, replicas int32) bool {
+	_, ok := packGPUs(nodeGPUDevices(node), committed.with(workload, replicas).GPUs)
+	return ok
+}
+
+// GetNodeGPUs returns a node's GPUs and the replicas the scheduler has allocated on each
+func (co *CentralOrchestrator) GetNodeGPUs(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	claims := committedResources(co.WorkloadManager.workloads)[nodeID].GPUs
+	if claims == nil {
+		claims = make([]GPUClaim, 0)
+	}
+	allocations, fits := packGPUs(nodeGPUDevices(node), claims)
+
+	sharing := make([]string, 0)
+	for _, device := range nodeGPUDevices(node) {
+		mode := device.Sharing
+		if len(device.MIGInstances) > 0 {
+			mode = "mig"
+		}
+		if mode != "" && !contains(sharing, mode) {
+			sharing = append(sharing, mode)
+		}
+	}
+	sort.Strings(sharing)
+
+	c.JSON(http.StatusOK, gin.H{
+		"node_id": nodeID,
+		"sharing": sharing,
+		"devices": allocations,
+		"claims":  claims,
+		// The node reports fewer or different GPUs than its placed replicas claim
+		"overcommitted": !fits,
+	})
+}
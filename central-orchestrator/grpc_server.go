@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	agentv1 "github.com/ishaqelkhalifa/kubernetes-edge-framework/api/agent/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// agentService serves the agent gRPC API. Each call is translated into the equivalent
+// REST request and served by the Gin router, so authentication, node identity checks,
+// forwarding to the leader and auditing behave exactly as they do for REST agents.
+type agentService struct {
+	agentv1.UnimplementedAgentServiceServer
+	co      *CentralOrchestrator
+	handler http.Handler
+}
+
+// newAgentGRPCServer creates the gRPC server for the agent API with the REST server's
+// TLS configuration and certificate, so agents present the same client certificates
+func newAgentGRPCServer(co *CentralOrchestrator, handler http.Handler, tlsConfig *tls.Config) (*grpc.Server, error) {
+	cert, err := tls.LoadX509KeyPair(CertPath, KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	config := tlsConfig.Clone()
+	config.Certificates = []tls.Certificate{cert}
+
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	agentv1.RegisterAgentServiceServer(server, &agentService{co: co, handler: handler})
+	return server, nil
+}
+
+// serveAgentGRPC serves the agent gRPC API on port until the server is stopped
+func serveAgentGRPC(server *grpc.Server, port string, co *CentralOrchestrator) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		co.Logger.Fatalf("Failed to listen for gRPC on port %s: %v", port, err)
+	}
+
+	co.Logger.Infof("Starting gRPC server on port %s", port)
+	if err := server.Serve(listener); err != nil {
+		co.Logger.Fatalf("Failed to start gRPC server: %v", err)
+	}
+}
+
+// Register registers a node, as POST /api/v1/nodes/register
+func (s *agentService) Register(ctx context.Context, req *agentv1.RegisterRequest) (*agentv1.RegisterResponse, error) {
+	payload := NodeRegistrationRequest{
+		Name:              req.Name,
+		Address:           req.Address,
+		Labels:            req.Labels,
+		Capabilities:      req.Capabilities,
+		Region:            req.Region,
+		Zone:              req.Zone,
+		SiteID:            req.SiteId,
+		KubernetesVersion: req.KubernetesVersion,
+		ContainerRuntime:  req.ContainerRuntime,
+	}
+	for _, taint := range req.Taints {
+		payload.Taints = append(payload.Taints, NodeTaint{Key: taint.Key, Value: taint.Value, Effect: TaintEffect(taint.Effect)})
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := s.dispatch(ctx, "POST", "/api/v1/nodes/register", payload, &resp); err != nil {
+		return nil, err
+	}
+	return &agentv1.RegisterResponse{NodeId: resp.ID}, nil
+}
+
+// Heartbeat records a heartbeat, as POST /api/v1/nodes/:id/heartbeat, and returns the
+// hash of the node's desired state
+func (s *agentService) Heartbeat(ctx context.Context, req *agentv1.HeartbeatRequest) (*agentv1.HeartbeatResponse, error) {
+	payload := HeartbeatRequest{
+		Status:        NodeStatus(req.Status),
+		Resources:     nodeResourcesFromProto(req.Resources),
+		Timestamp:     req.Timestamp.AsTime(),
+		Latency:       latencyFromProto(req.Latency),
+		LogSummaries:  logSummariesFromProto(req.LogSummaries),
+		CustomMetrics: customMetricsFromProto(req.CustomMetrics),
+	}
+	if req.Timestamp == nil {
+		payload.Timestamp = time.Now()
+	}
+
+	path := fmt.Sprintf("/api/v1/nodes/%s/heartbeat", url.PathEscape(req.NodeId))
+	if err := s.dispatch(ctx, "POST", path, payload, nil); err != nil {
+		return nil, err
+	}
+
+	// The hash is a hint; agents fetch the desired state when it is missing or differs
+	resp := &agentv1.HeartbeatResponse{}
+	s.co.WorkloadManager.mutex.RLock()
+	current, err := s.co.buildDesiredState(req.NodeId)
+	s.co.WorkloadManager.mutex.RUnlock()
+	if err == nil {
+		resp.DesiredStateHash = current.hash
+	}
+	return resp, nil
+}
+
+// SyncWorkloads returns the node's desired state, as GET /api/v1/nodes/:id/desired-state
+func (s *agentService) SyncWorkloads(ctx context.Context, req *agentv1.SyncWorkloadsRequest) (*agentv1.SyncWorkloadsResponse, error) {
+	path := fmt.Sprintf("/api/v1/nodes/%s/desired-state", url.PathEscape(req.NodeId))
+	if req.Since != "" {
+		path += "?since=" + url.QueryEscape(req.Since)
+	}
+
+	var desired DesiredStateResponse
+	if err := s.dispatch(ctx, "GET", path, nil, &desired); err != nil {
+		return nil, err
+	}
+
+	resp := &agentv1.SyncWorkloadsResponse{
+		Hash:      desired.Hash,
+		BaseHash:  desired.BaseHash,
+		Unchanged: desired.Unchanged,
+	}
+	for _, op := range desired.Patch {
+		value, err := structpb.NewValue(op.Value)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to encode patch value at %s: %v", op.Path, err)
+		}
+		resp.Patch = append(resp.Patch, &agentv1.PatchOperation{Op: op.Op, Path: op.Path, Value: value})
+	}
+	if desired.Document != nil {
+		document, err := structpb.NewStruct(desired.Document)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to encode desired state: %v", err)
+		}
+		resp.Document = document
+	}
+	return resp, nil
+}
+
+// dispatch serves a REST request on behalf of a gRPC call, carrying over its bearer
+// token and client certificate, and decodes a successful response into out
+func (s *agentService) dispatch(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return status.Errorf(codes.Internal, "failed to marshal request: %v", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, path, &body)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			req.Header.Set("Authorization", values[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+
+	w := newBufferedResponseWriter()
+	s.handler.ServeHTTP(w, req)
+
+	if w.status < 200 || w.status >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		message := w.body.String()
+		if json.Unmarshal(w.body.Bytes(), &failure) == nil && failure.Error != "" {
+			message = failure.Error
+		}
+		return status.Error(grpcCode(w.status), message)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(w.body.Bytes(), out); err != nil {
+			return status.Errorf(codes.Internal, "failed to decode response: %v", err)
+		}
+	}
+	return nil
+}
+
+// grpcCode maps the HTTP status of a REST response to a gRPC status code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// nodeResourcesFromProto converts reported resources; missing sections stay zero
+func nodeResourcesFromProto(resources *agentv1.NodeResources) NodeResources {
+	var converted NodeResources
+	if resources == nil {
+		return converted
+	}
+	if cpu := resources.Cpu; cpu != nil {
+		converted.CPU.Capacity, converted.CPU.Usage, converted.CPU.Percentage = cpu.Capacity, cpu.Usage, cpu.Percentage
+		converted.CPU.CapacityMillicores, converted.CPU.UsageMillicores = cpu.CapacityValue, cpu.UsageValue
+	}
+	if memory := resources.Memory; memory != nil {
+		converted.Memory.Capacity, converted.Memory.Usage, converted.Memory.Percentage = memory.Capacity, memory.Usage, memory.Percentage
+		converted.Memory.CapacityBytes, converted.Memory.UsageBytes = memory.CapacityValue, memory.UsageValue
+	}
+	if storage := resources.Storage; storage != nil {
+		converted.Storage.Capacity, converted.Storage.Usage, converted.Storage.Percentage = storage.Capacity, storage.Usage, storage.Percentage
+		converted.Storage.CapacityBytes, converted.Storage.UsageBytes = storage.CapacityValue, storage.UsageValue
+	}
+	converted.NetworkBandwidth = resources.NetworkBandwidth
+	converted.GPUs = int(resources.Gpus)
+	if topology := resources.CpuTopology; topology != nil {
+		converted.CPUTopology = &CPUTopology{
+			CPUs:                  int(topology.Cpus),
+			NUMANodes:             int(topology.NumaNodes),
+			CPUsPerNUMANode:       int(topology.CpusPerNumaNode),
+			ReservedCPUs:          int(topology.ReservedCpus),
+			CPUManagerPolicy:      topology.CpuManagerPolicy,
+			TopologyManagerPolicy: topology.TopologyManagerPolicy,
+		}
+	}
+	if resources.BootTime != nil {
+		bootTime := resources.BootTime.AsTime()
+		converted.BootTime = &bootTime
+	}
+	for _, device := range resources.GpuDevices {
+		var instances map[string]int
+		if len(device.MigInstances) > 0 {
+			instances = make(map[string]int, len(device.MigInstances))
+			for profile, count := range device.MigInstances {
+				instances[profile] = int(count)
+			}
+		}
+		converted.GPUDevices = append(converted.GPUDevices, GPUDevice{
+			Index:        int(device.Index),
+			UUID:         device.Uuid,
+			Model:        device.Model,
+			MemoryMB:     device.MemoryMb,
+			Sharing:      device.Sharing,
+			MIGInstances: instances,
+		})
+	}
+	return converted
+}
+
+// latencyFromProto converts reported latency measurements; nil when none were reported
+func latencyFromProto(measurements []*agentv1.LatencyMeasurement) []LatencyMeasurement {
+	if len(measurements) == 0 {
+		return nil
+	}
+	converted := make([]LatencyMeasurement, 0, len(measurements))
+	for _, measurement := range measurements {
+		converted = append(converted, LatencyMeasurement{
+			Target:            measurement.Target,
+			RTTMillis:         measurement.RttMs,
+			PacketLossPercent: measurement.PacketLossPercent,
+			JitterMillis:      measurement.JitterMs,
+			MeasuredAt:        measurement.MeasuredAt.AsTime(),
+		})
+	}
+	return converted
+}
+
+// logSummariesFromProto converts reported log summaries; nil when none were reported
+func logSummariesFromProto(summaries []*agentv1.WorkloadLogSummary) []WorkloadLogSummary {
+	if len(summaries) == 0 {
+		return nil
+	}
+	converted := make([]WorkloadLogSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		samples := make([]LogSample, 0, len(summary.Samples))
+		for _, sample := range summary.Samples {
+			samples = append(samples, LogSample{Level: sample.Level, Message: sample.Message, Count: sample.Count})
+		}
+		converted = append(converted, WorkloadLogSummary{
+			WorkloadID:    summary.WorkloadId,
+			WindowSeconds: summary.WindowSeconds,
+			Lines:         summary.Lines,
+			Errors:        summary.Errors,
+			Warnings:      summary.Warnings,
+			Samples:       samples,
+			CollectedAt:   summary.CollectedAt.AsTime(),
+		})
+	}
+	return converted
+}
+
+func customMetricsFromProto(metrics []*agentv1.CustomMetric) []CustomMetric {
+	if len(metrics) == 0 {
+		return nil
+	}
+	converted := make([]CustomMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		converted = append(converted, CustomMetric{
+			Collector:   metric.Collector,
+			Name:        metric.Name,
+			Value:       metric.Value,
+			CollectedAt: metric.CollectedAt.AsTime(),
+		})
+	}
+	return converted
+}
+
+// bufferedResponseWriter collects a REST response served for a gRPC call
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// Flush and CloseNotify are no-ops; Gin's writer calls them when a follower proxies
+// the request to the leader
+func (w *bufferedResponseWriter) Flush() {}
+
+func (w *bufferedResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers an admin token sends to act as another user, limited to that user's role bindings
+const (
+	ImpersonateUserHeader  = "Impersonate-User"
+	ImpersonateGroupHeader = "Impersonate-Group"
+)
+
+// ImpersonationMiddleware lets admins, typically CI pipelines and the GitOps controller,
+// make a request as another user and groups. The request gets the role and tenants of their
+// role bindings, while the real caller is kept for the audit log. Admins limited to tenants
+// may only impersonate users bound to tenants among theirs.
+func (sm *SecurityManager) ImpersonationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := strings.TrimSpace(c.GetHeader(ImpersonateUserHeader))
+		var groups []string
+		for _, value := range c.Request.Header.Values(ImpersonateGroupHeader) {
+			for _, group := range strings.Split(value, ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					groups = append(groups, group)
+				}
+			}
+		}
+		if user == "" && len(groups) == 0 {
+			c.Next()
+			return
+		}
+
+		if sm.policy == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Impersonation requires an access policy"})
+			c.Abort()
+			return
+		}
+		if c.GetString("role") != RoleAdmin {
+			sm.logger.Warnf("%s attempted to impersonate %q", c.GetString("user"), user)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins may impersonate"})
+			c.Abort()
+			return
+		}
+		if user == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ImpersonateUserHeader + " header required with " + ImpersonateGroupHeader})
+			c.Abort()
+			return
+		}
+
+		role, tenants, bound := sm.policy.resolveBindings(user, groups)
+		if !bound {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No role bindings for " + user})
+			c.Abort()
+			return
+		}
+
+		// The impersonator's own tenant limits always carry over
+		if value, limited := c.Get(ContextKeyTenants); limited {
+			allowed := sm.expandTenantNames(value.([]string))
+			requested := sm.expandTenantNames(tenants)
+			narrowed := intersectTenants(requested, allowed)
+			if tenants == nil || len(narrowed) < len(requested) {
+				sm.logger.Warnf("%s attempted to impersonate %q beyond its tenants", c.GetString("user"), user)
+				c.JSON(http.StatusForbidden, gin.H{"error": "Impersonated user is bound to tenants the caller may not act on"})
+				c.Abort()
+				return
+			}
+			tenants = narrowed
+		}
+
+		c.Set(ContextKeyImpersonator, c.GetString("user"))
+		c.Set("user", user)
+		c.Set("role", role)
+		c.Set(ContextKeyGroups, groups)
+		if tenants != nil {
+			c.Set(ContextKeyTenants, tenants)
+		}
+		c.Next()
+	}
+}
+
+// expandTenantNames adds the projects of the organizations among tenants
+func (sm *SecurityManager) expandTenantNames(tenants []string) []string {
+	if sm.expandTenants == nil {
+		return tenants
+	}
+	return sm.expandTenants(tenants)
+}
+
+// intersectTenants returns the requested tenants that are also allowed
+func intersectTenants(requested, allowed []string) []string {
+	tenants := make([]string, 0, len(requested))
+	for _, tenant := range requested {
+		if contains(allowed, tenant) {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants
+}
+
+// resolveBindings returns the role and tenants the bindings of a user and its groups grant:
+// the most privileged role, with the tenants of the bindings granting it, or nil tenants
+// when one of them covers every tenant. Less privileged bindings add nothing, so a viewer
+// binding on every tenant does not widen an operator binding on one.
+func (p *AccessPolicy) resolveBindings(user string, groups []string) (string, []string, bool) {
+	member := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		member[group] = true
+	}
+
+	var matched []RoleBinding
+	role := ""
+	for _, binding := range p.Bindings {
+		if binding.User != user && !(binding.Group != "" && member[binding.Group]) {
+			continue
+		}
+		matched = append(matched, binding)
+		if roleRanks[binding.Role] > roleRanks[role] {
+			role = binding.Role
+		}
+	}
+	if role == "" {
+		return "", nil, false
+	}
+
+	var tenants []string
+	seen := make(map[string]bool)
+	for _, binding := range matched {
+		if binding.Role != role {
+			continue
+		}
+		if len(binding.Tenants) == 0 {
+			return role, nil, true
+		}
+		for _, tenant := range binding.Tenants {
+			if !seen[tenant] {
+				seen[tenant] = true
+				tenants = append(tenants, tenant)
+			}
+		}
+	}
+	return role, tenants, true
+}
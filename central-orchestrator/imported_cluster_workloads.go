@@ -0,0 +1,749 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Labels on the objects the orchestrator creates in imported clusters; the workload ID
+	// label is the one agents use, so pods are selected the same way on either kind of node
+	ClusterManagedByLabel     = "app.kubernetes.io/managed-by"
+	ClusterManagedByValue     = "edge-orchestrator"
+	ClusterWorkloadIDLabel    = "workload-id"
+	ClusterSpecHashAnnotation = "edge-orchestrator/spec-hash"
+
+	// Label on objects pinned to one node of a cluster, holding the orchestrator node ID
+	ClusterNodeIDLabel = "edge.io/node-id"
+)
+
+// clusterFailingReasons are container waiting reasons that will not clear on their own
+var clusterFailingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// clusterTarget is where in a cluster a workload's objects go: the whole cluster for an
+// imported cluster, or a single node of it for nodes imported through an interop adapter,
+// in which case each node gets its own objects pinned to it
+type clusterTarget struct {
+	NodeID      string
+	NodeName    string
+	Tolerations []corev1.Toleration
+	// Devices on the node; pods request the extended resource of the devices their
+	// workload's device constraint names
+	Devices []InteropDevice
+}
+
+// objectName names the workload's objects; a node's copy is suffixed so copies for
+// different nodes do not collide
+func (t clusterTarget) objectName(workload clusterWorkload) string {
+	if t.NodeName == "" {
+		return workload.Name
+	}
+	sum := sha256.Sum256([]byte(t.NodeName))
+	return workload.Name + "-" + hex.EncodeToString(sum[:4])
+}
+
+// objectLabels are the labels of a workload's objects and pods
+func (t clusterTarget) objectLabels(workloadID string) map[string]string {
+	objectLabels := map[string]string{ClusterManagedByLabel: ClusterManagedByValue, ClusterWorkloadIDLabel: workloadID}
+	if t.NodeID != "" {
+		objectLabels[ClusterNodeIDLabel] = t.NodeID
+	}
+	return objectLabels
+}
+
+// baseSelector selects every object the orchestrator created for the target
+func (t clusterTarget) baseSelector() string {
+	set := labels.Set{ClusterManagedByLabel: ClusterManagedByValue}
+	if t.NodeID != "" {
+		set[ClusterNodeIDLabel] = t.NodeID
+		return labels.SelectorFromSet(set).String()
+	}
+	return labels.SelectorFromSet(set).String() + ",!" + ClusterNodeIDLabel
+}
+
+// selector selects the objects the orchestrator created for a workload on the target
+func (t clusterTarget) selector(workloadID string) string {
+	return t.baseSelector() + "," + ClusterWorkloadIDLabel + "=" + workloadID
+}
+
+// clusterWorkload is a workload as scheduled to an imported cluster, decoded from the
+// node's desired state like an agent decodes its assignments
+type clusterWorkload struct {
+	Workload
+	NodeReplicas int32 `json:"node_replicas"`
+}
+
+// clusterAssignments returns the workloads scheduled to an imported cluster
+func (co *CentralOrchestrator) clusterAssignments(nodeID string) ([]clusterWorkload, error) {
+	co.WorkloadManager.mutex.RLock()
+	version, err := co.buildDesiredState(nodeID)
+	co.WorkloadManager.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(version.document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal desired state: %v", err)
+	}
+	var document struct {
+		Workloads map[string]clusterWorkload `json:"workloads"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to decode desired state: %v", err)
+	}
+
+	workloads := make([]clusterWorkload, 0, len(document.Workloads))
+	for _, workload := range document.Workloads {
+		if workload.Namespace == "" {
+			workload.Namespace = DefaultNamespace
+		}
+		workloads = append(workloads, workload)
+	}
+	sort.Slice(workloads, func(i, j int) bool { return workloads[i].ID < workloads[j].ID })
+	return workloads, nil
+}
+
+// reconcileClusterWorkloads applies the workloads scheduled to an imported cluster, records
+// how each is doing and deletes the ones no longer scheduled there
+func (co *CentralOrchestrator) reconcileClusterWorkloads(ctx context.Context, nodeID string, client kubernetes.Interface, target clusterTarget) error {
+	workloads, err := co.clusterAssignments(nodeID)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool, len(workloads))
+	for _, workload := range workloads {
+		desired[workload.ID] = true
+
+		var observed ObservedWorkloadStatus
+		if err := applyClusterWorkload(ctx, client, workload, target); err != nil {
+			co.Logger.Errorf("Failed to apply workload %s to imported cluster %s: %v", workload.Name, nodeID, err)
+			observed = ObservedWorkloadStatus{Phase: ObservedPhaseFailed, DesiredReplicas: workload.NodeReplicas, Reason: "ApplyFailed", Message: err.Error()}
+		} else if observed, err = clusterWorkloadStatus(ctx, client, workload, target); err != nil {
+			co.Logger.Errorf("Failed to read status of workload %s in imported cluster %s: %v", workload.Name, nodeID, err)
+			continue
+		}
+		observed.ObservedAt = time.Now()
+		if err := co.recordWorkloadStatus(nodeID, workload.ID, observed); err != nil {
+			// Rescheduled since the assignments were read
+			co.Logger.Debugf("Dropped status of workload %s on node %s: %v", workload.Name, nodeID, err)
+		}
+
+		if len(workload.Ports) == 0 {
+			continue
+		}
+		service, err := ensureClusterService(ctx, client, workload, target)
+		if err != nil {
+			co.Logger.Errorf("Failed to apply service of workload %s to imported cluster %s: %v", workload.Name, nodeID, err)
+			continue
+		}
+		endpoints, err := clusterServiceEndpoints(ctx, client, service, target)
+		if err != nil {
+			co.Logger.Errorf("Failed to read endpoints of workload %s in imported cluster %s: %v", workload.Name, nodeID, err)
+			continue
+		}
+		co.recordWorkloadEndpoints(nodeID, workload.ID, endpoints)
+	}
+
+	running, err := clusterWorkloadIDs(ctx, client, target)
+	if err != nil {
+		return fmt.Errorf("failed to list workloads: %v", err)
+	}
+	for _, id := range running {
+		if desired[id] {
+			continue
+		}
+		co.Logger.Infof("Removing workload %s from imported cluster %s, no longer scheduled there", id, nodeID)
+		if err := deleteClusterObjects(ctx, client, target.selector(id)); err != nil {
+			co.Logger.Errorf("Failed to remove workload %s from imported cluster %s: %v", id, nodeID, err)
+		}
+	}
+	return nil
+}
+
+// clusterWorkloadObject builds the object that runs the workload on the target, the same
+// object an agent would create, annotated with the hash of the spec it was built from
+func clusterWorkloadObject(workload clusterWorkload, target clusterTarget) (runtime.Object, error) {
+	data, err := json.Marshal(workload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workload spec: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+
+	template, err := clusterPodTemplate(workload, target)
+	if err != nil {
+		return nil, err
+	}
+	meta := metav1.ObjectMeta{
+		Name:        target.objectName(workload),
+		Namespace:   workload.Namespace,
+		Labels:      target.objectLabels(workload.ID),
+		Annotations: map[string]string{ClusterSpecHashAnnotation: hash},
+	}
+	matchLabels := map[string]string{ClusterWorkloadIDLabel: workload.ID}
+	if target.NodeID != "" {
+		matchLabels[ClusterNodeIDLabel] = target.NodeID
+	}
+	selector := &metav1.LabelSelector{MatchLabels: matchLabels}
+	replicas := workload.NodeReplicas
+
+	switch workload.Type {
+	case "", WorkloadTypeDeployment:
+		return &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: meta,
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Selector: selector, Template: template},
+		}, nil
+	case WorkloadTypeStatefulSet:
+		return &appsv1.StatefulSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+			ObjectMeta: meta,
+			Spec: appsv1.StatefulSetSpec{
+				Replicas:    &replicas,
+				Selector:    selector,
+				Template:    template,
+				ServiceName: meta.Name,
+			},
+		}, nil
+	case WorkloadTypeDaemonSet:
+		return &appsv1.DaemonSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+			ObjectMeta: meta,
+			Spec:       appsv1.DaemonSetSpec{Selector: selector, Template: template},
+		}, nil
+	case WorkloadTypeJob:
+		template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+		return &batchv1.Job{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			ObjectMeta: meta,
+			Spec:       batchv1.JobSpec{Completions: &replicas, Parallelism: &replicas, Template: template},
+		}, nil
+	}
+	return nil, fmt.Errorf("workload type %q is not supported on imported clusters", workload.Type)
+}
+
+// applyClusterWorkload creates or updates the object that runs the workload; objects whose
+// spec hash matches are left alone
+func applyClusterWorkload(ctx context.Context, client kubernetes.Interface, workload clusterWorkload, target clusterTarget) error {
+	object, err := clusterWorkloadObject(workload, target)
+	if err != nil {
+		return err
+	}
+
+	switch desired := object.(type) {
+	case *appsv1.Deployment:
+		hash := desired.Annotations[ClusterSpecHashAnnotation]
+		deployments := client.AppsV1().Deployments(workload.Namespace)
+		existing, err := deployments.Get(ctx, desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = deployments.Create(ctx, desired, metav1.CreateOptions{})
+		} else if err == nil && existing.Annotations[ClusterSpecHashAnnotation] != hash {
+			existing.Labels, existing.Annotations = desired.Labels, desired.Annotations
+			existing.Spec.Replicas = desired.Spec.Replicas
+			existing.Spec.Template = desired.Spec.Template
+			_, err = deployments.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply deployment: %v", err)
+		}
+	case *appsv1.StatefulSet:
+		hash := desired.Annotations[ClusterSpecHashAnnotation]
+		statefulSets := client.AppsV1().StatefulSets(workload.Namespace)
+		existing, err := statefulSets.Get(ctx, desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = statefulSets.Create(ctx, desired, metav1.CreateOptions{})
+		} else if err == nil && existing.Annotations[ClusterSpecHashAnnotation] != hash {
+			existing.Labels, existing.Annotations = desired.Labels, desired.Annotations
+			existing.Spec.Replicas = desired.Spec.Replicas
+			existing.Spec.Template = desired.Spec.Template
+			_, err = statefulSets.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply statefulset: %v", err)
+		}
+	case *appsv1.DaemonSet:
+		hash := desired.Annotations[ClusterSpecHashAnnotation]
+		daemonSets := client.AppsV1().DaemonSets(workload.Namespace)
+		existing, err := daemonSets.Get(ctx, desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = daemonSets.Create(ctx, desired, metav1.CreateOptions{})
+		} else if err == nil && existing.Annotations[ClusterSpecHashAnnotation] != hash {
+			existing.Labels, existing.Annotations = desired.Labels, desired.Annotations
+			existing.Spec.Template = desired.Spec.Template
+			_, err = daemonSets.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply daemonset: %v", err)
+		}
+	case *batchv1.Job:
+		hash := desired.Annotations[ClusterSpecHashAnnotation]
+		jobs := client.BatchV1().Jobs(workload.Namespace)
+		existing, err := jobs.Get(ctx, desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = jobs.Create(ctx, desired, metav1.CreateOptions{})
+		} else if err == nil && existing.Annotations[ClusterSpecHashAnnotation] != hash {
+			// A job's pod template cannot change; it is recreated on the next poll once
+			// the old one is gone
+			err = jobs.Delete(ctx, desired.Name, clusterDeleteOptions())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply job: %v", err)
+		}
+	}
+	return nil
+}
+
+// clusterPodTemplate runs the workload image as a single container, labelled so the
+// workload's Service selects it and pinned to the target's node if it has one
+func clusterPodTemplate(workload clusterWorkload, target clusterTarget) (corev1.PodTemplateSpec, error) {
+	requirements := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	quantities := []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{requirements.Requests, corev1.ResourceCPU, workload.Resources.Requests.CPU},
+		{requirements.Requests, corev1.ResourceMemory, workload.Resources.Requests.Memory},
+		{requirements.Limits, corev1.ResourceCPU, workload.Resources.Limits.CPU},
+		{requirements.Limits, corev1.ResourceMemory, workload.Resources.Limits.Memory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, ok := parseQuantity(q.value)
+		if !ok {
+			return corev1.PodTemplateSpec{}, fmt.Errorf("invalid %s quantity %q", q.name, q.value)
+		}
+		q.list[q.name] = quantity
+	}
+	// Imported clusters only report whole GPUs
+	if gpu := workload.Resources.GPU; gpu != nil {
+		if gpu.Count == 0 {
+			return corev1.PodTemplateSpec{}, fmt.Errorf("imported clusters only support whole GPUs")
+		}
+		requirements.Limits[ImportedClusterGPUResource] = *resource.NewQuantity(int64(gpu.Count), resource.DecimalSI)
+	}
+	for _, name := range target.deviceResources(workload) {
+		requirements.Requests[name] = resource.MustParse("1")
+		requirements.Limits[name] = resource.MustParse("1")
+	}
+
+	podLabels := map[string]string{}
+	for key, value := range workload.Labels {
+		podLabels[key] = value
+	}
+	for key, value := range workload.Selector {
+		podLabels[key] = value
+	}
+	for key, value := range target.objectLabels(workload.ID) {
+		podLabels[key] = value
+	}
+
+	names := make([]string, 0, len(workload.Environment))
+	for name := range workload.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]corev1.EnvVar, 0, len(names))
+	for _, name := range names {
+		env = append(env, corev1.EnvVar{Name: name, Value: workload.Environment[name]})
+	}
+
+	ports := make([]corev1.ContainerPort, 0, len(workload.Ports))
+	for _, p := range workload.Ports {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          p.Name,
+			ContainerPort: p.TargetPort,
+			Protocol:      corev1.Protocol(p.Protocol),
+		})
+	}
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:           workload.Name,
+				Image:          workload.Image,
+				Env:            env,
+				Ports:          ports,
+				Resources:      requirements,
+				ReadinessProbe: readinessProbe(workload.ReadinessProbe),
+			}},
+			Tolerations: target.Tolerations,
+		},
+	}
+	if target.NodeName != "" {
+		template.Spec.NodeSelector = map[string]string{corev1.LabelHostname: target.NodeName}
+	}
+	return template, nil
+}
+
+// readinessProbe renders a workload's readiness probe for its container: an HTTP GET when
+// it has a path, a TCP connect otherwise
+func readinessProbe(probe *Probe) *corev1.Probe {
+	if probe == nil {
+		return nil
+	}
+	port := intstr.FromInt(int(probe.Port))
+	handler := corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: port}}
+	if probe.HTTPPath != "" {
+		handler = corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: probe.HTTPPath, Port: port}}
+	}
+	return &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		FailureThreshold:    probe.FailureThreshold,
+	}
+}
+
+// deviceResources returns the extended resources of the node's devices that the workload's
+// device constraint names
+func (t clusterTarget) deviceResources(workload clusterWorkload) []corev1.ResourceName {
+	var names []corev1.ResourceName
+	for _, constraint := range workload.Placement.Constraints {
+		if constraint.Key != DeviceConstraintKey || !constraint.requires() {
+			continue
+		}
+		for _, value := range constraint.Values {
+			for _, device := range t.Devices {
+				if device.Resource != "" && device.matches(value) {
+					names = append(names, corev1.ResourceName(device.Resource))
+					break
+				}
+			}
+		}
+	}
+	return names
+}
+
+// clusterWorkloadStatus reads back the workload's object, and its pods for image pull
+// failures and crash loops, as an agent reports them
+func clusterWorkloadStatus(ctx context.Context, client kubernetes.Interface, workload clusterWorkload, target clusterTarget) (ObservedWorkloadStatus, error) {
+	observed := ObservedWorkloadStatus{DesiredReplicas: workload.NodeReplicas}
+	name := target.objectName(workload)
+
+	switch workload.Type {
+	case "", WorkloadTypeDeployment:
+		deployment, err := client.AppsV1().Deployments(workload.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return observed, err
+		}
+		observed.ReadyReplicas = deployment.Status.ReadyReplicas
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse {
+				observed.Phase, observed.Reason, observed.Message = ObservedPhaseFailed, condition.Reason, condition.Message
+			}
+		}
+	case WorkloadTypeStatefulSet:
+		statefulSet, err := client.AppsV1().StatefulSets(workload.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return observed, err
+		}
+		observed.ReadyReplicas = statefulSet.Status.ReadyReplicas
+	case WorkloadTypeDaemonSet:
+		daemonSet, err := client.AppsV1().DaemonSets(workload.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return observed, err
+		}
+		observed.DesiredReplicas = daemonSet.Status.DesiredNumberScheduled
+		observed.ReadyReplicas = daemonSet.Status.NumberReady
+	case WorkloadTypeJob:
+		job, err := client.BatchV1().Jobs(workload.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return observed, err
+		}
+		observed.ReadyReplicas = job.Status.Succeeded
+		observed.Phase = ObservedPhaseProgressing
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				observed.Phase = ObservedPhaseCompleted
+				return observed, nil
+			case batchv1.JobFailed:
+				observed.Phase, observed.Reason, observed.Message = ObservedPhaseFailed, condition.Reason, condition.Message
+			}
+		}
+	}
+
+	if observed.Phase == "" {
+		if observed.ReadyReplicas >= observed.DesiredReplicas {
+			observed.Phase = ObservedPhaseAvailable
+		} else {
+			observed.Phase = ObservedPhaseProgressing
+		}
+	}
+
+	pods, err := client.CoreV1().Pods(workload.Namespace).List(ctx, metav1.ListOptions{LabelSelector: target.selector(workload.ID)})
+	if err != nil {
+		return observed, fmt.Errorf("failed to list pods: %v", err)
+	}
+	for _, pod := range pods.Items {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+				observed.Phase, observed.Reason, observed.Message = ObservedPhaseFailed, condition.Reason, condition.Message
+			}
+		}
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			observed.Restarts += status.RestartCount
+			if waiting := status.State.Waiting; waiting != nil && clusterFailingReasons[waiting.Reason] {
+				observed.Phase, observed.Reason, observed.Message = ObservedPhaseFailed, waiting.Reason, waiting.Message
+			}
+		}
+	}
+	return observed, nil
+}
+
+// clusterServiceObject builds the Service exposing the workload's ports on the target
+func clusterServiceObject(workload clusterWorkload, target clusterTarget) *corev1.Service {
+	serviceType := corev1.ServiceType(workload.ServiceType)
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+	ports := make([]corev1.ServicePort, 0, len(workload.Ports))
+	for _, p := range workload.Ports {
+		port := corev1.ServicePort{
+			Name:       p.Name,
+			Port:       p.Port,
+			TargetPort: intstr.FromInt(int(p.TargetPort)),
+			Protocol:   corev1.Protocol(p.Protocol),
+		}
+		if serviceType != corev1.ServiceTypeClusterIP {
+			port.NodePort = p.NodePort
+		}
+		ports = append(ports, port)
+	}
+
+	// A node's copy only selects the pods pinned to that node
+	selector := map[string]string{}
+	for key, value := range workload.Selector {
+		selector[key] = value
+	}
+	if target.NodeID != "" {
+		selector[ClusterNodeIDLabel] = target.NodeID
+	}
+
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      target.objectName(workload),
+			Namespace: workload.Namespace,
+			Labels:    target.objectLabels(workload.ID),
+		},
+		Spec: corev1.ServiceSpec{Type: serviceType, Selector: selector, Ports: ports},
+	}
+}
+
+// ensureClusterService creates or updates the Service exposing the workload's ports
+func ensureClusterService(ctx context.Context, client kubernetes.Interface, workload clusterWorkload, target clusterTarget) (*corev1.Service, error) {
+	desired := clusterServiceObject(workload, target)
+
+	services := client.CoreV1().Services(workload.Namespace)
+	existing, err := services.Get(ctx, desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return services.Create(ctx, desired, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	existing.Spec.Type = desired.Spec.Type
+	existing.Spec.Selector = desired.Spec.Selector
+	existing.Spec.Ports = desired.Spec.Ports
+	return services.Update(ctx, existing, metav1.UpdateOptions{})
+}
+
+// clusterServiceEndpoints derives where a service is reachable from outside the cluster:
+// load balancer ingress addresses, or the node port on the cluster's ready nodes (only the
+// target's node when it has one)
+func clusterServiceEndpoints(ctx context.Context, client kubernetes.Interface, service *corev1.Service, target clusterTarget) ([]ServiceEndpoint, error) {
+	var endpoints []ServiceEndpoint
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		for _, port := range service.Spec.Ports {
+			for _, ingress := range service.Status.LoadBalancer.Ingress {
+				address := ingress.IP
+				if address == "" {
+					address = ingress.Hostname
+				}
+				endpoints = append(endpoints, ServiceEndpoint{Name: port.Name, Address: address, Port: port.Port, Protocol: string(port.Protocol), Type: string(service.Spec.Type)})
+			}
+		}
+	case corev1.ServiceTypeNodePort:
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %v", err)
+		}
+		for _, node := range nodes.Items {
+			if target.NodeName != "" && node.Name != target.NodeName {
+				continue
+			}
+			address := nodeAddress(node)
+			if address == "" {
+				continue
+			}
+			for _, port := range service.Spec.Ports {
+				endpoints = append(endpoints, ServiceEndpoint{Name: port.Name, Address: address, Port: port.NodePort, Protocol: string(port.Protocol), Type: string(service.Spec.Type)})
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// nodeAddress returns a ready node's external address, or its internal one
+func nodeAddress(node corev1.Node) string {
+	if !nodeReady(node) {
+		return ""
+	}
+	internal := ""
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case corev1.NodeExternalIP:
+			return address.Address
+		case corev1.NodeInternalIP:
+			internal = address.Address
+		}
+	}
+	return internal
+}
+
+// clusterDeleteOptions also removes the pods of deleted jobs, which are otherwise orphaned
+func clusterDeleteOptions() metav1.DeleteOptions {
+	propagation := metav1.DeletePropagationBackground
+	return metav1.DeleteOptions{PropagationPolicy: &propagation}
+}
+
+// deleteClusterObjects removes the objects matching the selector
+func deleteClusterObjects(ctx context.Context, client kubernetes.Interface, selector string) error {
+	options := metav1.ListOptions{LabelSelector: selector}
+	apps := client.AppsV1()
+
+	deployments, err := apps.Deployments("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for _, deployment := range deployments.Items {
+		if err := apps.Deployments(deployment.Namespace).Delete(ctx, deployment.Name, clusterDeleteOptions()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete deployment %s/%s: %v", deployment.Namespace, deployment.Name, err)
+		}
+	}
+
+	statefulSets, err := apps.StatefulSets("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list statefulsets: %v", err)
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if err := apps.StatefulSets(statefulSet.Namespace).Delete(ctx, statefulSet.Name, clusterDeleteOptions()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete statefulset %s/%s: %v", statefulSet.Namespace, statefulSet.Name, err)
+		}
+	}
+
+	daemonSets, err := apps.DaemonSets("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list daemonsets: %v", err)
+	}
+	for _, daemonSet := range daemonSets.Items {
+		if err := apps.DaemonSets(daemonSet.Namespace).Delete(ctx, daemonSet.Name, clusterDeleteOptions()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete daemonset %s/%s: %v", daemonSet.Namespace, daemonSet.Name, err)
+		}
+	}
+
+	jobs, err := client.BatchV1().Jobs("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %v", err)
+	}
+	for _, job := range jobs.Items {
+		if err := client.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, clusterDeleteOptions()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete job %s/%s: %v", job.Namespace, job.Name, err)
+		}
+	}
+
+	services, err := client.CoreV1().Services("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, service := range services.Items {
+		if err := client.CoreV1().Services(service.Namespace).Delete(ctx, service.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service %s/%s: %v", service.Namespace, service.Name, err)
+		}
+	}
+	return nil
+}
+
+// clusterWorkloadIDs returns the IDs of the workloads the orchestrator runs on a target
+func clusterWorkloadIDs(ctx context.Context, client kubernetes.Interface, target clusterTarget) ([]string, error) {
+	selector := target.baseSelector() + "," + ClusterWorkloadIDLabel
+	options := metav1.ListOptions{LabelSelector: selector}
+	ids := make(map[string]bool)
+
+	deployments, err := client.AppsV1().Deployments("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		ids[deployment.Labels[ClusterWorkloadIDLabel]] = true
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		ids[statefulSet.Labels[ClusterWorkloadIDLabel]] = true
+	}
+
+	daemonSets, err := client.AppsV1().DaemonSets("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, daemonSet := range daemonSets.Items {
+		ids[daemonSet.Labels[ClusterWorkloadIDLabel]] = true
+	}
+
+	jobs, err := client.BatchV1().Jobs("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs.Items {
+		ids[job.Labels[ClusterWorkloadIDLabel]] = true
+	}
+
+	running := make([]string, 0, len(ids))
+	for id := range ids {
+		running = append(running, id)
+	}
+	sort.Strings(running)
+	return running, nil
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// Interval between polls of each imported cluster, matching the agent heartbeat interval
+	ImportedClusterSyncInterval = 30 * time.Second
+
+	// Bound on a single request to an imported cluster's API server, and on one poll
+	ImportedClusterRequestTimeout = 15 * time.Second
+	ImportedClusterSyncTimeout    = 2 * time.Minute
+
+	// Extended resource counted as GPUs in an imported cluster's capacity
+	ImportedClusterGPUResource = "nvidia.com/gpu"
+)
+
+// ClusterImportRequest imports a cluster as an agentless edge node. Credentials are either a
+// kubeconfig or a server address with a service-account token.
+type ClusterImportRequest struct {
+	Name   string            `json:"name" binding:"required"`
+	SiteID string            `json:"site_id"`
+	Region string            `json:"region"`
+	Zone   string            `json:"zone"`
+	Labels map[string]string `json:"labels"`
+	ClusterCredentials
+}
+
+// ClusterCredentials is how the orchestrator reaches and authenticates to an imported
+// cluster's API server
+type ClusterCredentials struct {
+	// Kubeconfig YAML with embedded credentials, and the context to use from it (default
+	// its current context)
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	// API server URL, service-account token and the PEM CA bundle that signed the server
+	// certificate, used when no kubeconfig is given
+	Server        string `json:"server,omitempty"`
+	Token         string `json:"token,omitempty"`
+	CACertificate string `json:"ca_certificate,omitempty"`
+	Insecure      bool   `json:"insecure_skip_tls_verify,omitempty"`
+	// HTTP(S) or SOCKS5 proxy the API server is reached through, such as a bastion host or
+	// an SSH tunnel ("socks5://bastion.site-a:1080")
+	ProxyURL string `json:"proxy_url,omitempty"`
+}
+
+// ImportedCluster is a Kubernetes cluster the orchestrator manages directly through its API
+// rather than through an agent. It appears in the fleet as the edge node NodeID: the
+// orchestrator polls the cluster for heartbeats, applies the workloads scheduled to the
+// node and reads back their status.
+type ImportedCluster struct {
+	NodeID string `json:"node_id"`
+	Name   string `json:"name"`
+	ClusterCredentials
+	// "kubeconfig" or "token"
+	AuthMethod string `json:"auth_method"`
+	// Host of the API server, resolved from the kubeconfig when one is given
+	Host         string    `json:"host"`
+	ImportedBy   string    `json:"imported_by"`
+	ImportedAt   time.Time `json:"imported_at"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// redacted returns a copy of the cluster without its credentials, for API responses
+func (ic *ImportedCluster) redacted() ImportedCluster {
+	view := *ic
+	view.Kubeconfig = ""
+	view.Token = ""
+	return view
+}
+
+// ImportedClusterManager keeps imported clusters and a client for each
+type ImportedClusterManager struct {
+	clusters map[string]*ImportedCluster
+	clients  map[string]kubernetes.Interface
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewImportedClusterManager creates a new imported cluster manager
+func NewImportedClusterManager(logger *logrus.Logger) *ImportedClusterManager {
+	return &ImportedClusterManager{
+		clusters: make(map[string]*ImportedCluster),
+		clients:  make(map[string]kubernetes.Interface),
+		logger:   logger,
+	}
+}
+
+// restore loads persisted clusters; clients are rebuilt on first use
+func (cm *ImportedClusterManager) restore(clusters map[string]*ImportedCluster, replace bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if replace {
+		cm.clusters = make(map[string]*ImportedCluster, len(clusters))
+	}
+	for id, cluster := range clusters {
+		cm.clusters[id] = cluster
+	}
+	cm.clients = make(map[string]kubernetes.Interface)
+}
+
+// remove forgets a cluster and returns it, or nil if the node is not an imported cluster
+func (cm *ImportedClusterManager) remove(nodeID string) *ImportedCluster {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cluster := cm.clusters[nodeID]
+	delete(cm.clusters, nodeID)
+	delete(cm.clients, nodeID)
+	return cluster
+}
+
+// client returns the cluster's client, building it from the stored credentials
+func (cm *ImportedClusterManager) client(nodeID string) (kubernetes.Interface, error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if client, ok := cm.clients[nodeID]; ok {
+		return client, nil
+	}
+	cluster, ok := cm.clusters[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("node %s is not an imported cluster", nodeID)
+	}
+	client, _, err := cluster.ClusterCredentials.connect()
+	if err != nil {
+		return nil, err
+	}
+	cm.clients[nodeID] = client
+	return client, nil
+}
+
+// recordSync notes the outcome of a poll
+func (cm *ImportedClusterManager) recordSync(nodeID string, err error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cluster, ok := cm.clusters[nodeID]
+	if !ok {
+		return
+	}
+	if err != nil {
+		cluster.LastError = err.Error()
+		return
+	}
+	cluster.LastError = ""
+	cluster.LastSyncedAt = time.Now()
+}
+
+// authMethod reports which credentials were given, or an error if they are incomplete
+func (cc ClusterCredentials) authMethod() (string, error) {
+	switch {
+	case cc.Kubeconfig != "" && (cc.Server != "" || cc.Token != ""):
+		return "", fmt.Errorf("give either a kubeconfig or a server and token, not both")
+	case cc.Kubeconfig != "":
+		return "kubeconfig", nil
+	case cc.Server != "" && cc.Token != "":
+		return "token", nil
+	}
+	return "", fmt.Errorf("a kubeconfig, or a server and service-account token, is required")
+}
+
+// restConfig builds the client configuration for the credentials. Kubeconfigs may only
+// embed their credentials: exec plugins, auth providers and file references would run
+// commands or read files on the orchestrator host.
+func (cc ClusterCredentials) restConfig() (*rest.Config, error) {
+	var config *rest.Config
+	if cc.Kubeconfig != "" {
+		raw, err := clientcmd.Load([]byte(cc.Kubeconfig))
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig: %v", err)
+		}
+		for name, auth := range raw.AuthInfos {
+			if auth.Exec != nil || auth.AuthProvider != nil {
+				return nil, fmt.Errorf("kubeconfig user %q uses an exec plugin or auth provider; use a service-account token", name)
+			}
+			if auth.ClientCertificate != "" || auth.ClientKey != "" || auth.TokenFile != "" {
+				return nil, fmt.Errorf("kubeconfig user %q references files; embed the credentials instead", name)
+			}
+		}
+		for name, cluster := range raw.Clusters {
+			if cluster.CertificateAuthority != "" {
+				return nil, fmt.Errorf("kubeconfig cluster %q references a CA file; embed certificate-authority-data instead", name)
+			}
+		}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: cc.Context}
+		if config, err = clientcmd.NewNonInteractiveClientConfig(*raw, cc.Context, overrides, nil).ClientConfig(); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig: %v", err)
+		}
+	} else {
+		config = &rest.Config{
+			Host:        cc.Server,
+			BearerToken: cc.Token,
+			TLSClientConfig: rest.TLSClientConfig{
+				CAData:   []byte(cc.CACertificate),
+				Insecure: cc.Insecure,
+			},
+		}
+	}
+
+	if cc.ProxyURL != "" {
+		proxy, err := url.Parse(cc.ProxyURL)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy_url %q", cc.ProxyURL)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("proxy_url must be an http, https or socks5 URL")
+		}
+		config.Proxy = http.ProxyURL(proxy)
+	}
+	config.Timeout = ImportedClusterRequestTimeout
+	config.UserAgent = "edge-orchestrator"
+	return config, nil
+}
+
+// connect builds a client for the credentials and returns it with the API server host
+func (cc ClusterCredentials) connect() (kubernetes.Interface, string, error) {
+	config, err := cc.restConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create client: %v", err)
+	}
+	return client, config.Host, nil
+}
+
+// clusterInventory is what a poll learns about an imported cluster's nodes
+type clusterInventory struct {
+	Resources        NodeResources
+	Nodes            int
+	ReadyNodes       int
+	ContainerRuntime string
+}
+
+// status maps node readiness to the status an agent would report
+func (inv clusterInventory) status() NodeStatus {
+	switch {
+	case inv.ReadyNodes == 0:
+		return NodeStatusOffline
+	case inv.ReadyNodes < inv.Nodes:
+		return NodeStatusDegraded
+	}
+	return NodeStatusOnline
+}
+
+// collectClusterInventory reports the cluster's allocatable capacity and the requests of its
+// running pods, like a multi-cluster agent does for the clusters it manages
+func collectClusterInventory(ctx context.Context, client kubernetes.Interface) (clusterInventory, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return clusterInventory{}, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "status.phase=Running"})
+	if err != nil {
+		return clusterInventory{}, fmt.Errorf("failed to list pods: %v", err)
+	}
+	return summarizeClusterInventory(nodes.Items, pods.Items), nil
+}
+
+// summarizeClusterInventory totals the allocatable capacity of the nodes and the requests
+// of the pods running on them
+func summarizeClusterInventory(nodes []corev1.Node, pods []corev1.Pod) clusterInventory {
+	var inv clusterInventory
+
+	var cpuCapacity, memoryCapacity, storageCapacity resource.Quantity
+	var gpus int64
+	for _, node := range nodes {
+		inv.Nodes++
+		if nodeReady(node) {
+			inv.ReadyNodes++
+		}
+		if inv.ContainerRuntime == "" {
+			inv.ContainerRuntime = node.Status.NodeInfo.ContainerRuntimeVersion
+		}
+		allocatable := node.Status.Allocatable
+		cpuCapacity.Add(allocatable[corev1.ResourceCPU])
+		memoryCapacity.Add(allocatable[corev1.ResourceMemory])
+		storageCapacity.Add(allocatable[corev1.ResourceEphemeralStorage])
+		if gpu, ok := allocatable[ImportedClusterGPUResource]; ok {
+			gpus += gpu.Value()
+		}
+	}
+
+	var cpuRequests, memoryRequests resource.Quantity
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			cpuRequests.Add(container.Resources.Requests[corev1.ResourceCPU])
+			memoryRequests.Add(container.Resources.Requests[corev1.ResourceMemory])
+		}
+	}
+
+	resources := &inv.Resources
+	resources.CPU.Capacity = cpuCapacity.String()
+	resources.CPU.Usage = cpuRequests.String()
+	resources.CPU.Percentage = quantityPercent(cpuRequests.MilliValue(), cpuCapacity.MilliValue())
+	resources.Memory.Capacity = memoryCapacity.String()
+	resources.Memory.Usage = memoryRequests.String()
+	resources.Memory.Percentage = quantityPercent(memoryRequests.Value(), memoryCapacity.Value())
+	resources.Storage.Capacity = storageCapacity.String()
+	resources.GPUs = int(gpus)
+	return inv
+}
+
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func quantityPercent(used, capacity int64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(used) / float64(capacity) * 100
+}
+
+// ImportCluster registers a cluster as an agentless edge node after checking the
+// orchestrator can reach it with the given credentials
+func (co *CentralOrchestrator) ImportCluster(c *gin.Context) {
+	var req ClusterImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	authMethod, err := req.authMethod()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, host, err := req.ClusterCredentials.connect()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to reach cluster at %s: %v", host, err)})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ImportedClusterRequestTimeout)
+	defer cancel()
+	inventory, err := collectClusterInventory(ctx, client)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to read cluster at %s: %v", host, err)})
+		return
+	}
+
+	now := time.Now()
+	node := &EdgeNode{
+		ID:                 generateID(),
+		Name:               req.Name,
+		Address:            host,
+		Status:             inventory.status(),
+		LastHeartbeat:      now,
+		Resources:          inventory.Resources,
+		Labels:             req.Labels,
+		Region:             req.Region,
+		Zone:               req.Zone,
+		SiteID:             req.SiteID,
+		State:              co.NodeStateManager.InitialState(),
+		StateChangedAt:     now,
+		HeartbeatTransport: HeartbeatTransportKubernetesAPI,
+		Agentless:          true,
+		KubernetesVersion:  version.GitVersion,
+		ContainerRuntime:   inventory.ContainerRuntime,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	if node.Region == "" {
+		node.Region = "default"
+	}
+	if node.Zone == "" {
+		node.Zone = "default"
+	}
+
+	cluster := &ImportedCluster{
+		NodeID:             node.ID,
+		Name:               req.Name,
+		ClusterCredentials: req.ClusterCredentials,
+		AuthMethod:         authMethod,
+		Host:               host,
+		ImportedBy:         requestActor(c),
+		ImportedAt:         now,
+		LastSyncedAt:       now,
+	}
+
+	co.NodeManager.mutex.Lock()
+	co.NodeManager.nodes[node.ID] = node
+	co.NodeManager.mutex.Unlock()
+	co.UptimeTracker.RecordHeartbeat(node.ID, now)
+
+	co.ImportedClusters.mutex.Lock()
+	co.ImportedClusters.clusters[node.ID] = cluster
+	co.ImportedClusters.clients[node.ID] = client
+	co.ImportedClusters.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "cluster.import", "node:"+node.ID, map[string]string{
+		"name":        cluster.Name,
+		"host":        host,
+		"auth_method": authMethod,
+		"proxy":       req.ProxyURL,
+	})
+	co.Logger.Infof("Imported cluster %s at %s as agentless node %s (%d nodes, Kubernetes %s)",
+		cluster.Name, host, node.ID, inventory.Nodes, version.GitVersion)
+
+	c.JSON(http.StatusCreated, gin.H{"cluster": cluster.redacted(), "node": node})
+}
+
+// ListImportedClusters returns imported clusters, without their credentials
+func (co *CentralOrchestrator) ListImportedClusters(c *gin.Context) {
+	co.ImportedClusters.mutex.RLock()
+	defer co.ImportedClusters.mutex.RUnlock()
+
+	clusters := make([]ImportedCluster, 0, len(co.ImportedClusters.clusters))
+	for _, cluster := range co.ImportedClusters.clusters {
+		clusters = append(clusters, cluster.redacted())
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"clusters": clusters})
+}
+
+// GetImportedCluster returns one imported cluster, without its credentials
+func (co *CentralOrchestrator) GetImportedCluster(c *gin.Context) {
+	co.ImportedClusters.mutex.RLock()
+	defer co.ImportedClusters.mutex.RUnlock()
+
+	cluster, exists := co.ImportedClusters.clusters[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cluster": cluster.redacted()})
+}
+
+// UpdateClusterCredentials replaces an imported cluster's credentials, such as when its
+// service-account token is rotated; the new credentials must reach the cluster
+func (co *CentralOrchestrator) UpdateClusterCredentials(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req ClusterCredentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	authMethod, err := req.authMethod()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	client, host, err := req.connect()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to reach cluster at %s: %v", host, err)})
+		return
+	}
+
+	var view ImportedCluster
+	co.ImportedClusters.mutex.Lock()
+	cluster, exists := co.ImportedClusters.clusters[nodeID]
+	if exists {
+		cluster.ClusterCredentials = req
+		cluster.AuthMethod = authMethod
+		cluster.Host = host
+		cluster.LastError = ""
+		co.ImportedClusters.clients[nodeID] = client
+		view = cluster.redacted()
+	}
+	co.ImportedClusters.mutex.Unlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+		return
+	}
+
+	co.AuditLog.RecordRequest(c, "cluster.credentials", "node:"+nodeID, map[string]string{
+		"host":        host,
+		"auth_method": authMethod,
+		"proxy":       req.ProxyURL,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"cluster": view})
+}
+
+// RemoveImportedCluster stops managing a cluster: the objects the orchestrator created in
+// it are deleted when it is reachable, and its node leaves the fleet
+func (co *CentralOrchestrator) RemoveImportedCluster(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	client, err := co.ImportedClusters.client(nodeID)
+	cluster := co.ImportedClusters.remove(nodeID)
+	if cluster == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+		return
+	}
+
+	cleanup := "deleted"
+	if err == nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), ImportedClusterSyncTimeout)
+		defer cancel()
+		err = deleteClusterObjects(ctx, client, clusterTarget{}.baseSelector())
+	}
+	if err != nil {
+		cleanup = "left in place: " + err.Error()
+		co.Logger.Warnf("Removed cluster %s without deleting its workloads: %v", cluster.Name, err)
+	}
+
+	co.NodeManager.mutex.Lock()
+	delete(co.NodeManager.nodes, nodeID)
+	co.NodeManager.mutex.Unlock()
+	co.DesiredStateCache.forget(nodeID)
+
+	co.AuditLog.RecordRequest(c, "cluster.remove", "node:"+nodeID, map[string]string{
+		"name":      cluster.Name,
+		"workloads": cleanup,
+	})
+	co.Logger.Infof("Removed imported cluster %s (node %s)", cluster.Name, nodeID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cluster removed", "workloads": cleanup})
+}
+
+// importedClusterController polls every imported cluster in place of its agent
+func (co *CentralOrchestrator) importedClusterController() {
+	ticker := time.NewTicker(ImportedClusterSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.syncImportedClusters()
+		}
+	}
+}
+
+// syncImportedClusters polls the clusters concurrently so an unreachable one does not hold
+// up the rest
+func (co *CentralOrchestrator) syncImportedClusters() {
+	co.ImportedClusters.mutex.RLock()
+	nodeIDs := make([]string, 0, len(co.ImportedClusters.clusters))
+	for nodeID := range co.ImportedClusters.clusters {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	co.ImportedClusters.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, nodeID := range nodeIDs {
+		wg.Add(1)
+		go func(nodeID string) {
+			defer wg.Done()
+			err := co.syncImportedCluster(nodeID)
+			co.ImportedClusters.recordSync(nodeID, err)
+			if err != nil {
+				co.Logger.Warnf("Failed to sync imported cluster %s: %v", nodeID, err)
+			}
+		}(nodeID)
+	}
+	wg.Wait()
+}
+
+// syncImportedCluster does for one cluster what its agent would: sends a heartbeat, applies
+// the workloads scheduled to it and records their status. A cluster that cannot be reached
+// sends no heartbeat, so its node goes offline and its workloads fail over as usual.
+func (co *CentralOrchestrator) syncImportedCluster(nodeID string) error {
+	client, err := co.ImportedClusters.client(nodeID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ImportedClusterSyncTimeout)
+	defer cancel()
+
+	inventory, err := collectClusterInventory(ctx, client)
+	if err != nil {
+		return err
+	}
+	heartbeat := HeartbeatRequest{Status: inventory.status(), Resources: inventory.Resources, Timestamp: time.Now()}
+	if !co.applyHeartbeat(nodeID, heartbeat, HeartbeatTransportKubernetesAPI) {
+		return fmt.Errorf("node %s no longer exists", nodeID)
+	}
+
+	return co.reconcileClusterWorkloads(ctx, nodeID, client, clusterTarget{})
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Incident platforms alerts are synced with
+	IncidentPlatformPagerDuty = "pagerduty"
+	IncidentPlatformOpsgenie  = "opsgenie"
+
+	// How often alert changes are pushed to the incident platforms
+	IncidentSyncInterval = 30 * time.Second
+
+	// Operations on an alert's node or workload attached to its incident, newest first
+	MaxIncidentRecentEvents    = 10
+	IncidentRecentEventsWindow = 24 * time.Hour
+
+	// Largest incident webhook accepted
+	MaxIncidentWebhookBody = 1 << 20
+
+	DefaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	DefaultOpsgenieAPIURL     = "https://api.opsgenie.com"
+
+	// Opsgenie truncates longer alert messages
+	MaxOpsgenieMessage = 130
+)
+
+// IncidentSync opens PagerDuty and Opsgenie incidents for firing alerts, with the context
+// responders need, and keeps both sides in step: alerts acknowledged or resolved in the
+// orchestrator are acknowledged or resolved on the platforms, and incidents acknowledged
+// or resolved there are acknowledged or resolved here
+type IncidentSync struct {
+	pagerDutyRoutingKey    string
+	pagerDutyWebhookSecret string
+	pagerDutyEventsURL     string
+	opsgenieAPIKey         string
+	opsgenieWebhookSecret  string
+	opsgenieAPIURL         string
+	// Runbook link for alerts whose workload has none; {name} and {code} are replaced
+	runbookURL string
+	// What each platform was last told of each alert, by platform and alert ID
+	synced     map[string]map[string]syncedAlert
+	httpClient *http.Client
+	mutex      sync.Mutex
+	logger     *logrus.Logger
+}
+
+// syncedAlert is what a platform was last told of an alert
+type syncedAlert struct {
+	status       AlertStatus
+	acknowledged bool
+}
+
+// NewIncidentSync creates the incident sync. PAGERDUTY_ROUTING_KEY, the integration key of
+// an Events API v2 service, enables PagerDuty and OPSGENIE_API_KEY enables Opsgenie.
+// PAGERDUTY_WEBHOOK_SECRET and OPSGENIE_WEBHOOK_SECRET accept their webhooks back.
+func NewIncidentSync(logger *logrus.Logger) *IncidentSync {
+	is := &IncidentSync{
+		pagerDutyRoutingKey:    os.Getenv("PAGERDUTY_ROUTING_KEY"),
+		pagerDutyWebhookSecret: os.Getenv("PAGERDUTY_WEBHOOK_SECRET"),
+		pagerDutyEventsURL:     os.Getenv("PAGERDUTY_EVENTS_URL"),
+		opsgenieAPIKey:         os.Getenv("OPSGENIE_API_KEY"),
+		opsgenieWebhookSecret:  os.Getenv("OPSGENIE_WEBHOOK_SECRET"),
+		opsgenieAPIURL:         strings.TrimSuffix(os.Getenv("OPSGENIE_API_URL"), "/"),
+		runbookURL:             os.Getenv("INCIDENT_RUNBOOK_URL"),
+		synced:                 make(map[string]map[string]syncedAlert),
+		httpClient:             &http.Client{Timeout: 10 * time.Second},
+		logger:                 logger,
+	}
+	if is.pagerDutyEventsURL == "" {
+		is.pagerDutyEventsURL = DefaultPagerDutyEventsURL
+	}
+	if is.opsgenieAPIURL == "" {
+		is.opsgenieAPIURL = DefaultOpsgenieAPIURL
+	}
+	return is
+}
+
+// platforms returns the platforms incidents are opened on
+func (is *IncidentSync) platforms() []string {
+	var platforms []string
+	if is.pagerDutyRoutingKey != "" {
+		platforms = append(platforms, IncidentPlatformPagerDuty)
+	}
+	if is.opsgenieAPIKey != "" {
+		platforms = append(platforms, IncidentPlatformOpsgenie)
+	}
+	return platforms
+}
+
+// Enabled reports whether incidents are opened on any platform
+func (is *IncidentSync) Enabled() bool {
+	return len(is.platforms()) > 0
+}
+
+// WebhookEnabled reports whether a platform's webhooks are accepted
+func (is *IncidentSync) WebhookEnabled(platform string) bool {
+	switch platform {
+	case IncidentPlatformPagerDuty:
+		return is.pagerDutyWebhookSecret != ""
+	case IncidentPlatformOpsgenie:
+		return is.opsgenieWebhookSecret != ""
+	}
+	return false
+}
+
+// IncidentContext is the fleet context attached to an incident
+type IncidentContext struct {
+	Alert   string      `json:"alert"`
+	Code    MessageCode `json:"code"`
+	Scope   AlertScope  `json:"scope"`
+	ScopeID string      `json:"scope_id"`
+	SiteID  string      `json:"site_id,omitempty"`
+	// ID of the alert in the orchestrator
+	AlertID    string            `json:"alert_id"`
+	Node       *IncidentNode     `json:"node,omitempty"`
+	Workload   *IncidentWorkload `json:"workload,omitempty"`
+	RunbookURL string            `json:"runbook_url,omitempty"`
+	// Operations on the node or workload, such as failovers and drains, newest first
+	RecentEvents []string `json:"recent_events,omitempty"`
+}
+
+// IncidentNode is what an incident shows of the node an alert is about
+type IncidentNode struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Address       string            `json:"address,omitempty"`
+	Status        NodeStatus        `json:"status"`
+	Region        string            `json:"region,omitempty"`
+	Zone          string            `json:"zone,omitempty"`
+	SiteID        string            `json:"site_id,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	LastHeartbeat time.Time         `json:"last_heartbeat"`
+}
+
+// IncidentWorkload is what an incident shows of the workload an alert is about
+type IncidentWorkload struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Namespace string         `json:"namespace"`
+	Tenant    string         `json:"tenant,omitempty"`
+	Image     string         `json:"image"`
+	Status    WorkloadStatus `json:"status"`
+	OwnerTeam string         `json:"owner_team,omitempty"`
+}
+
+// details flattens the context into the string map Opsgenie accepts
+func (ic *IncidentContext) details() map[string]string {
+	details := map[string]string{
+		"alert":    ic.Alert,
+		"alert_id": ic.AlertID,
+		"code":     string(ic.Code),
+		"scope":    string(ic.Scope) + " " + ic.ScopeID,
+	}
+	if ic.SiteID != "" {
+		details["site_id"] = ic.SiteID
+	}
+	if node := ic.Node; node != nil {
+		details["node"] = node.Name
+		details["node_status"] = string(node.Status)
+		details["node_address"] = node.Address
+		details["node_region"] = node.Region
+		if !node.LastHeartbeat.IsZero() {
+			details["node_last_heartbeat"] = node.LastHeartbeat.UTC().Format(time.RFC3339)
+		}
+	}
+	if workload := ic.Workload; workload != nil {
+		details["workload"] = workload.Namespace + "/" + workload.Name
+		details["workload_image"] = workload.Image
+		details["workload_status"] = string(workload.Status)
+		details["owner_team"] = workload.OwnerTeam
+	}
+	if ic.RunbookURL != "" {
+		details["runbook_url"] = ic.RunbookURL
+	}
+	for key, value := range details {
+		if value == "" {
+			delete(details, key)
+		}
+	}
+	return details
+}
+
+// description renders the context as text, for Opsgenie's alert description
+func (ic *IncidentContext) description(message string) string {
+	var b strings.Builder
+	b.WriteString(message)
+	if ic.RunbookURL != "" {
+		fmt.Fprintf(&b, "\n\nRunbook: %s", ic.RunbookURL)
+	}
+	if len(ic.RecentEvents) > 0 {
+		b.WriteString("\n\nRecent events:")
+		for _, event := range ic.RecentEvents {
+			b.WriteString("\n- " + event)
+		}
+	}
+	return b.String()
+}
+
+// incidentContexts gathers the fleet context of alerts, by alert ID
+func (co *CentralOrchestrator) incidentContexts(alerts []*Alert, now time.Time) map[string]*IncidentContext {
+	contexts := make(map[string]*IncidentContext, len(alerts))
+	for _, alert := range alerts {
+		contexts[alert.ID] = &IncidentContext{
+			Alert:   alert.Name,
+			Code:    alert.Code,
+			Scope:   alert.Scope,
+			ScopeID: alert.ScopeID,
+			SiteID:  alert.SiteID,
+			AlertID: alert.ID,
+		}
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	co.NodeManager.mutex.RLock()
+	for _, alert := range alerts {
+		context := contexts[alert.ID]
+		if node, exists := co.NodeManager.nodes[alertNodeID(alert)]; exists {
+			context.Node = &IncidentNode{
+				ID:            node.ID,
+				Name:          node.Name,
+				Address:       node.Address,
+				Status:        node.Status,
+				Region:        node.Region,
+				Zone:          node.Zone,
+				SiteID:        node.SiteID,
+				Labels:        node.Labels,
+				LastHeartbeat: node.LastHeartbeat,
+			}
+			if context.SiteID == "" {
+				context.SiteID = node.SiteID
+			}
+		}
+		if workload, exists := co.WorkloadManager.workloads[alertWorkloadID(alert)]; exists {
+			context.Workload = &IncidentWorkload{
+				ID:        workload.ID,
+				Name:      workload.Name,
+				Namespace: workload.Namespace,
+				Tenant:    workload.Tenant,
+				Image:     workload.Image,
+				Status:    workload.Status,
+				OwnerTeam: workload.Metadata.OwnerTeam,
+			}
+			context.RunbookURL = workload.Metadata.RunbookURL
+		}
+		if context.RunbookURL == "" && co.IncidentSync.runbookURL != "" {
+			context.RunbookURL = strings.NewReplacer("{name}", url.PathEscape(alert.Name), "{code}", url.PathEscape(string(alert.Code))).
+				Replace(co.IncidentSync.runbookURL)
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RUnlock()
+
+	for _, alert := range alerts {
+		contexts[alert.ID].RecentEvents = co.OperationManager.recentEvents(alertNodeID(alert), alertWorkloadID(alert), now)
+	}
+	return contexts
+}
+
+// recentEvents describes the latest operation steps on a node or workload
+func (om *OperationManager) recentEvents(nodeID, workloadID string, now time.Time) []string {
+	if nodeID == "" && workloadID == "" {
+		return nil
+	}
+
+	om.mutex.RLock()
+	defer om.mutex.RUnlock()
+
+	type event struct {
+		at   time.Time
+		text string
+	}
+	var events []event
+	for _, op := range om.operations {
+		for _, step := range op.Steps {
+			if now.Sub(step.Timestamp) > IncidentRecentEventsWindow {
+				continue
+			}
+			if (nodeID == "" || step.NodeID != nodeID) && (workloadID == "" || step.WorkloadID != workloadID) {
+				continue
+			}
+			events = append(events, event{
+				at:   step.Timestamp,
+				text: fmt.Sprintf("%s %s: %s", step.Timestamp.UTC().Format(time.RFC3339), op.Type, step.Message),
+			})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].at.After(events[j].at)
+	})
+	if len(events) > MaxIncidentRecentEvents {
+		events = events[:MaxIncidentRecentEvents]
+	}
+	texts := make([]string, len(events))
+	for i, e := range events {
+		texts[i] = e.text
+	}
+	return texts
+}
+
+// incidentController pushes alert changes to the incident platforms
+func (co *CentralOrchestrator) incidentController() {
+	if !co.IncidentSync.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(IncidentSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.syncIncidents(time.Now())
+		}
+	}
+}
+
+// syncIncidents opens incidents for new firing alerts that are not silenced and
+// acknowledges or resolves the incidents of alerts acknowledged or resolved since. Changes
+// that came from a platform are not sent back to it. Failed calls are retried on the
+// next pass.
+func (co *CentralOrchestrator) syncIncidents(now time.Time) {
+	is := co.IncidentSync
+	alerts := co.localizeAlerts(co.AlertManager.List(AlertFilter{}), DefaultLocale)
+
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	var opening []*Alert
+	for _, alert := range alerts {
+		if alert.Status != AlertStatusFiring || len(alert.SilencedBy) > 0 {
+			continue
+		}
+		for _, platform := range is.platforms() {
+			if _, known := is.synced[platform][alert.ID]; !known {
+				opening = append(opening, alert)
+				break
+			}
+		}
+	}
+	contexts := co.incidentContexts(opening, now)
+
+	for _, platform := range is.platforms() {
+		if is.synced[platform] == nil {
+			is.synced[platform] = make(map[string]syncedAlert)
+		}
+		synced := is.synced[platform]
+		current := make(map[string]bool, len(alerts))
+		for _, alert := range alerts {
+			current[alert.ID] = true
+			previous, known := synced[alert.ID]
+			acknowledged := alert.AcknowledgedBy != ""
+			fromPlatform := func(by string) bool { return strings.HasPrefix(by, platform+":") }
+
+			var err error
+			switch {
+			case !known:
+				if contexts[alert.ID] == nil {
+					continue
+				}
+				err = is.trigger(platform, alert, contexts[alert.ID])
+				if err == nil && acknowledged {
+					err = is.acknowledge(platform, alert)
+				}
+			case previous.status == AlertStatusFiring && alert.Status == AlertStatusResolved:
+				if !fromPlatform(alert.ResolvedBy) {
+					err = is.resolve(platform, alert)
+				}
+			case alert.Status == AlertStatusFiring && acknowledged && !previous.acknowledged:
+				if !fromPlatform(alert.AcknowledgedBy) {
+					err = is.acknowledge(platform, alert)
+				}
+			default:
+				continue
+			}
+			if err != nil {
+				is.logger.Warnf("Failed to sync alert %s with %s: %v", alert.ID, platform, err)
+				continue
+			}
+			synced[alert.ID] = syncedAlert{status: alert.Status, acknowledged: acknowledged}
+		}
+		// Alerts pruned from the history are forgotten
+		for id := range synced {
+			if !current[id] {
+				delete(synced, id)
+			}
+		}
+	}
+}
+
+// pagerDutySeverity maps an alert severity to a PagerDuty event severity
+func pagerDutySeverity(severity AlertSeverity) string {
+	switch severity {
+	case AlertSeverityCritical, AlertSeverityWarning, AlertSeverityInfo:
+		return string(severity)
+	}
+	return "error"
+}
+
+// opsgeniePriority maps an alert severity to an Opsgenie priority
+func opsgeniePriority(severity AlertSeverity) string {
+	switch severity {
+	case AlertSeverityCritical:
+		return "P1"
+	case AlertSeverityWarning:
+		return "P3"
+	}
+	return "P5"
+}
+
+// trigger opens an incident for an alert. The alert ID deduplicates it on both platforms,
+// as PagerDuty's dedup key and Opsgenie's alias.
+func (is *IncidentSync) trigger(platform string, alert *Alert, context *IncidentContext) error {
+	switch platform {
+	case IncidentPlatformPagerDuty:
+		payload := map[string]interface{}{
+			"summary":        alert.Message,
+			"source":         string(alert.Scope) + " " + alert.ScopeID,
+			"severity":       pagerDutySeverity(alert.Severity),
+			"timestamp":      alert.StartsAt.UTC().Format(time.RFC3339),
+			"class":          alert.Name,
+			"component":      alert.ScopeID,
+			"custom_details": context,
+		}
+		if context.SiteID != "" {
+			payload["group"] = context.SiteID
+		}
+		event := map[string]interface{}{
+			"event_action": "trigger",
+			"payload":      payload,
+			"client":       "Edge orchestrator",
+		}
+		if context.RunbookURL != "" {
+			event["links"] = []map[string]string{{"href": context.RunbookURL, "text": "Runbook"}}
+		}
+		return is.sendPagerDuty(alert.ID, event)
+	case IncidentPlatformOpsgenie:
+		message := alert.Message
+		if len(message) > MaxOpsgenieMessage {
+			message = message[:MaxOpsgenieMessage]
+		}
+		tags := []string{alert.Name, string(alert.Scope)}
+		if context.SiteID != "" {
+			tags = append(tags, "site:"+context.SiteID)
+		}
+		return is.sendOpsgenie("/v2/alerts", map[string]interface{}{
+			"message":     message,
+			"alias":       alert.ID,
+			"description": context.description(alert.Message),
+			"details":     context.details(),
+			"entity":      alert.ScopeID,
+			"source":      "edge-orchestrator",
+			"priority":    opsgeniePriority(alert.Severity),
+			"tags":        tags,
+		})
+	}
+	return fmt.Errorf("unknown incident platform %s", platform)
+}
+
+// acknowledge acknowledges an alert's incident
+func (is *IncidentSync) acknowledge(platform string, alert *Alert) error {
+	switch platform {
+	case IncidentPlatformPagerDuty:
+		return is.sendPagerDuty(alert.ID, map[string]interface{}{"event_action": "acknowledge"})
+	case IncidentPlatformOpsgenie:
+		return is.sendOpsgenie("/v2/alerts/"+url.PathEscape(alert.ID)+"/acknowledge?identifierType=alias", map[string]interface{}{
+			"source": "edge-orchestrator",
+			"note":   "Acknowledged in the orchestrator by " + alert.AcknowledgedBy,
+		})
+	}
+	return fmt.Errorf("unknown incident platform %s", platform)
+}
+
+// resolve resolves an alert's incident
+func (is *IncidentSync) resolve(platform string, alert *Alert) error {
+	note := "Resolved in the orchestrator"
+	if alert.ResolvedBy != "" {
+		note += " by " + alert.ResolvedBy
+	}
+	switch platform {
+	case IncidentPlatformPagerDuty:
+		return is.sendPagerDuty(alert.ID, map[string]interface{}{"event_action": "resolve"})
+	case IncidentPlatformOpsgenie:
+		return is.sendOpsgenie("/v2/alerts/"+url.PathEscape(alert.ID)+"/close?identifierType=alias", map[string]interface{}{
+			"source": "edge-orchestrator",
+			"note":   note,
+		})
+	}
+	return fmt.Errorf("unknown incident platform %s", platform)
+}
+
+// sendPagerDuty sends an event to the PagerDuty Events API v2
+func (is *IncidentSync) sendPagerDuty(dedupKey string, event map[string]interface{}) error {
+	event["routing_key"] = is.pagerDutyRoutingKey
+	event["dedup_key"] = dedupKey
+	return is.post(is.pagerDutyEventsURL, "", event)
+}
+
+// sendOpsgenie calls the Opsgenie alert API
+func (is *IncidentSync) sendOpsgenie(path string, body map[string]interface{}) error {
+	return is.post(is.opsgenieAPIURL+path, "GenieKey "+is.opsgenieAPIKey, body)
+}
+
+// post sends a JSON request to an incident platform
+func (is *IncidentSync) post(target, authorization string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := is.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// verifyPagerDuty checks a PagerDuty v3 webhook signature, an HMAC of the body. The
+// header lists a signature per secret while a secret is being rotated.
+func (is *IncidentSync) verifyPagerDuty(c *gin.Context, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(is.pagerDutyWebhookSecret))
+	mac.Write(body)
+	expected := "v1=" + hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range strings.Split(c.GetHeader("X-PagerDuty-Signature"), ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(signature)), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyOpsgenie checks the bearer token configured as a custom header of the Opsgenie
+// webhook integration
+func (is *IncidentSync) verifyOpsgenie(c *gin.Context) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(is.opsgenieWebhookSecret)) == 1
+}
+
+// pagerDutyWebhook is the part of a PagerDuty v3 webhook the sync reads
+type pagerDutyWebhook struct {
+	Event struct {
+		EventType string `json:"event_type"`
+		Agent     *struct {
+			Summary string `json:"summary"`
+		} `json:"agent"`
+		Data struct {
+			IncidentKey string `json:"incident_key"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// opsgenieWebhook is the part of an Opsgenie webhook the sync reads
+type opsgenieWebhook struct {
+	Action string `json:"action"`
+	Alert  struct {
+		Alias    string `json:"alias"`
+		Username string `json:"username"`
+	} `json:"alert"`
+	Source struct {
+		Name string `json:"name"`
+	} `json:"source"`
+}
+
+// ReceiveIncidentWebhook applies the acknowledgement or resolution of an incident on
+// PagerDuty or Opsgenie to its alert. Other events are accepted and ignored.
+func (co *CentralOrchestrator) ReceiveIncidentWebhook(c *gin.Context) {
+	platform := c.Param("platform")
+	if !co.IncidentSync.WebhookEnabled(platform) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown incident platform"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxIncidentWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var alertID, action, user string
+	switch platform {
+	case IncidentPlatformPagerDuty:
+		if !co.IncidentSync.verifyPagerDuty(c, body) {
+			co.Logger.Warnf("Rejected PagerDuty webhook from %s", c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+			return
+		}
+		var webhook pagerDutyWebhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		alertID = webhook.Event.Data.IncidentKey
+		switch webhook.Event.EventType {
+		case "incident.acknowledged":
+			action = "acknowledge"
+		case "incident.resolved":
+			action = "resolve"
+		}
+		if webhook.Event.Agent != nil {
+			user = webhook.Event.Agent.Summary
+		}
+	case IncidentPlatformOpsgenie:
+		if !co.IncidentSync.verifyOpsgenie(c) {
+			co.Logger.Warnf("Rejected Opsgenie webhook from %s", c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook token"})
+			return
+		}
+		var webhook opsgenieWebhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		alertID = webhook.Alert.Alias
+		switch webhook.Action {
+		case "Acknowledge":
+			action = "acknowledge"
+		case "Close":
+			action = "resolve"
+		}
+		user = webhook.Alert.Username
+		if user == "" {
+			user = webhook.Source.Name
+		}
+	}
+	if action == "" || alertID == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	// Recorded as the platform's user, which also keeps the change from being sent back
+	by := platform + ":" + user
+	if user == "" {
+		by = platform + ":unknown"
+	}
+	now := time.Now()
+	var alert Alert
+	var exists bool
+	if action == "acknowledge" {
+		alert, exists = co.AlertManager.Acknowledge(alertID, by, now)
+	} else {
+		alert, exists = co.AlertManager.ResolveByID(alertID, by, now)
+	}
+	if !exists {
+		// Incidents opened by other sources share the service
+		c.JSON(http.StatusOK, gin.H{"message": "No matching alert"})
+		return
+	}
+
+	co.AuditLog.Record(by, c.ClientIP(), "alert."+action, "alert:"+alert.ID, map[string]string{"name": alert.Name})
+	c.JSON(http.StatusOK, gin.H{"alert_id": alert.ID, "status": alert.Status})
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Labels on nodes imported through an adapter, naming the adapter and the project the
+	// node is managed by
+	InteropAdapterLabel = "interop.edge.io/adapter"
+	InteropSourceLabel  = "interop.edge.io/source"
+
+	// Taint on imported nodes of adapters that only import inventory, so nothing is
+	// scheduled to nodes the orchestrator cannot deliver to
+	InteropInventoryOnlyTaint = "interop.edge.io/inventory-only"
+
+	// Role label KubeEdge puts on its edge nodes, which installations commonly also taint
+	// them with to keep cloud workloads off
+	KubeEdgeEdgeNodeLabel = "node-role.kubernetes.io/edge"
+
+	// Placement constraint key restricting a workload to nodes that reach named devices
+	DeviceConstraintKey = "device"
+
+	// Prefix of the extended resource Akri advertises for each device instance
+	AkriResourcePrefix = "akri.sh/"
+)
+
+// InteropKind is the project an adapter imports from
+type InteropKind string
+
+const (
+	InteropKindKubeEdge InteropKind = "kubeedge"
+	InteropKindAkri     InteropKind = "akri"
+)
+
+var (
+	// KubeEdge device APIs, newest first
+	kubeEdgeDeviceResources = []schema.GroupVersionResource{
+		{Group: "devices.kubeedge.io", Version: "v1beta1", Resource: "devices"},
+		{Group: "devices.kubeedge.io", Version: "v1alpha2", Resource: "devices"},
+	}
+	akriInstanceResource      = schema.GroupVersionResource{Group: "akri.sh", Version: "v0", Resource: "instances"}
+	akriConfigurationResource = schema.GroupVersionResource{Group: "akri.sh", Version: "v0", Resource: "configurations"}
+)
+
+// InteropAdapterRequest connects an adapter to the cluster running KubeEdge's cloud side or
+// Akri
+type InteropAdapterRequest struct {
+	Name   string      `json:"name" binding:"required"`
+	Kind   InteropKind `json:"kind" binding:"required"`
+	SiteID string      `json:"site_id"`
+	Region string      `json:"region"`
+	Zone   string      `json:"zone"`
+	// Label selector of the nodes to import; defaults to KubeEdge's edge nodes, or every
+	// node for Akri
+	NodeSelector string `json:"node_selector"`
+	// Deliver the workloads scheduled to the imported nodes through the cluster; otherwise
+	// only inventory is imported and the nodes are tainted inventory-only
+	DeliverWorkloads bool `json:"deliver_workloads"`
+	ClusterCredentials
+}
+
+// InteropAdapter imports the nodes of an existing KubeEdge or Akri installation, and the
+// devices they reach, as agentless edge nodes. Each imported node keeps its place in the
+// source cluster; when the adapter delivers workloads, the ones scheduled to a node are
+// applied through the cluster's API pinned to it, so they reach the edge through
+// KubeEdge's cloud-edge channel or get Akri's devices allocated as they would natively.
+type InteropAdapter struct {
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Kind   InteropKind `json:"kind"`
+	SiteID string      `json:"site_id"`
+	Region string      `json:"region"`
+	Zone   string      `json:"zone"`
+	ClusterCredentials
+	AuthMethod       string `json:"auth_method"`
+	Host             string `json:"host"`
+	NodeSelector     string `json:"node_selector"`
+	DeliverWorkloads bool   `json:"deliver_workloads"`
+	// IDs of the nodes imported on the last sync, and how many devices they reach
+	Nodes        []string  `json:"nodes"`
+	Devices      int       `json:"devices"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// InteropDevice is a device an imported node reaches, as KubeEdge or Akri describes it
+type InteropDevice struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Device model (KubeEdge) or configuration (Akri) the device is an instance of
+	Kind     string      `json:"kind"`
+	Protocol string      `json:"protocol,omitempty"`
+	Source   InteropKind `json:"source"`
+	// Extended resource a pod requests to be given the device, for Akri instances
+	Resource string `json:"resource,omitempty"`
+}
+
+// matches reports whether a device constraint value names the device or its kind
+func (d InteropDevice) matches(value string) bool {
+	return d.Name == value || d.Kind == value
+}
+
+// hasDevice reports whether the node reaches a device with the name or kind
+func (node *EdgeNode) hasDevice(value string) bool {
+	for _, device := range node.Devices {
+		if device.matches(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns a copy of the adapter without its credentials, for API responses
+func (a *InteropAdapter) redacted() InteropAdapter {
+	view := *a
+	view.Kubeconfig = ""
+	view.Token = ""
+	view.Nodes = append([]string(nil), a.Nodes...)
+	return view
+}
+
+// nodeSelector returns the selector of the nodes to import
+func (a *InteropAdapter) nodeSelector() string {
+	if a.NodeSelector == "" && a.Kind == InteropKindKubeEdge {
+		return KubeEdgeEdgeNodeLabel
+	}
+	return a.NodeSelector
+}
+
+// nodeID is the ID a source node is imported under: the adapter's ID suffixed with the
+// node's name, so it is stable across syncs and restarts
+func (a *InteropAdapter) nodeID(nodeName string) string {
+	return a.ID + "-" + nodeName
+}
+
+// target places a workload's objects on the source node
+func (a *InteropAdapter) target(nodeName string, devices []InteropDevice) clusterTarget {
+	target := clusterTarget{NodeID: a.nodeID(nodeName), NodeName: nodeName, Devices: devices}
+	if a.Kind == InteropKindKubeEdge {
+		target.Tolerations = []corev1.Toleration{{Key: KubeEdgeEdgeNodeLabel, Operator: corev1.TolerationOpExists}}
+	}
+	return target
+}
+
+// interopClients reach an adapter's cluster; the dynamic client reads device resources
+type interopClients struct {
+	kube    kubernetes.Interface
+	dynamic dynamic.Interface
+}
+
+// connectInterop builds clients for the credentials and returns them with the API server host
+func connectInterop(cc ClusterCredentials) (interopClients, string, error) {
+	config, err := cc.restConfig()
+	if err != nil {
+		return interopClients{}, "", err
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return interopClients{}, "", fmt.Errorf("failed to create client: %v", err)
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return interopClients{}, "", fmt.Errorf("failed to create client: %v", err)
+	}
+	return interopClients{kube: kube, dynamic: dyn}, config.Host, nil
+}
+
+// InteropManager keeps interop adapters and clients for their clusters
+type InteropManager struct {
+	adapters map[string]*InteropAdapter
+	clients  map[string]interopClients
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewInteropManager creates a new interop manager
+func NewInteropManager(logger *logrus.Logger) *InteropManager {
+	return &InteropManager{
+		adapters: make(map[string]*InteropAdapter),
+		clients:  make(map[string]interopClients),
+		logger:   logger,
+	}
+}
+
+// restore loads persisted adapters; clients are rebuilt on first use
+func (im *InteropManager) restore(adapters map[string]*InteropAdapter, replace bool) {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	if replace {
+		im.adapters = make(map[string]*InteropAdapter, len(adapters))
+	}
+	for id, adapter := range adapters {
+		im.adapters[id] = adapter
+	}
+	im.clients = make(map[string]interopClients)
+}
+
+// remove forgets an adapter and returns it, or nil if there is none
+func (im *InteropManager) remove(id string) *InteropAdapter {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	adapter := im.adapters[id]
+	delete(im.adapters, id)
+	delete(im.clients, id)
+	return adapter
+}
+
+// adapter returns a copy of an adapter and its clients, building them from the stored
+// credentials
+func (im *InteropManager) adapter(id string) (InteropAdapter, interopClients, error) {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	adapter, ok := im.adapters[id]
+	if !ok {
+		return InteropAdapter{}, interopClients{}, fmt.Errorf("adapter %s does not exist", id)
+	}
+	clients, ok := im.clients[id]
+	if !ok {
+		var err error
+		if clients, _, err = connectInterop(adapter.ClusterCredentials); err != nil {
+			return *adapter, interopClients{}, err
+		}
+		im.clients[id] = clients
+	}
+	return *adapter, clients, nil
+}
+
+// recordSync notes the outcome of a sync and the nodes it imported
+func (im *InteropManager) recordSync(id string, nodeIDs []string, devices int, err error) {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	adapter, ok := im.adapters[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		adapter.LastError = err.Error()
+		return
+	}
+	adapter.Nodes = nodeIDs
+	adapter.Devices = devices
+	adapter.LastError = ""
+	adapter.LastSyncedAt = time.Now()
+}
+
+// interopInventory is what a sync learns about an adapter's cluster, keyed by node name
+type interopInventory struct {
+	Nodes   []corev1.Node
+	Pods    map[string][]corev1.Pod
+	Devices map[string][]InteropDevice
+}
+
+// deviceCount returns how many devices the imported nodes reach
+func (inv interopInventory) deviceCount() int {
+	count := 0
+	for _, node := range inv.Nodes {
+		count += len(inv.Devices[node.Name])
+	}
+	return count
+}
+
+// collectInteropInventory lists the nodes to import, the pods running on them and the
+// devices they reach
+func collectInteropInventory(ctx context.Context, adapter InteropAdapter, clients interopClients) (interopInventory, error) {
+	inv := interopInventory{Pods: make(map[string][]corev1.Pod), Devices: make(map[string][]InteropDevice)}
+
+	nodes, err := clients.kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: adapter.nodeSelector()})
+	if err != nil {
+		return inv, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	inv.Nodes = nodes.Items
+	sort.Slice(inv.Nodes, func(i, j int) bool { return inv.Nodes[i].Name < inv.Nodes[j].Name })
+
+	pods, err := clients.kube.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "status.phase=Running"})
+	if err != nil {
+		return inv, fmt.Errorf("failed to list pods: %v", err)
+	}
+	for _, pod := range pods.Items {
+		inv.Pods[pod.Spec.NodeName] = append(inv.Pods[pod.Spec.NodeName], pod)
+	}
+
+	switch adapter.Kind {
+	case InteropKindKubeEdge:
+		err = collectKubeEdgeDevices(ctx, clients.dynamic, inv.Devices)
+	case InteropKindAkri:
+		err = collectAkriDevices(ctx, clients.dynamic, inv.Devices)
+	}
+	if err != nil {
+		return inv, fmt.Errorf("failed to list devices: %v", err)
+	}
+	return inv, nil
+}
+
+// collectKubeEdgeDevices reads KubeEdge's Device resources from the newest device API the
+// cluster serves; installations without device management have none
+func collectKubeEdgeDevices(ctx context.Context, client dynamic.Interface, devices map[string][]InteropDevice) error {
+	for _, gvr := range kubeEdgeDeviceResources {
+		list, err := client.Resource(gvr).Namespace("").List(ctx, metav1.ListOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, item := range list.Items {
+			nodeName, _, _ := unstructured.NestedString(item.Object, "spec", "nodeName")
+			if nodeName == "" {
+				// v1alpha2 binds devices to nodes with a node selector on the hostname
+				terms, _, _ := unstructured.NestedSlice(item.Object, "spec", "nodeSelector", "nodeSelectorTerms")
+				nodeName = kubeEdgeSelectedNode(terms)
+			}
+			if nodeName == "" {
+				continue
+			}
+			model, _, _ := unstructured.NestedString(item.Object, "spec", "deviceModelRef", "name")
+			devices[nodeName] = append(devices[nodeName], InteropDevice{
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
+				Kind:      model,
+				Protocol:  kubeEdgeProtocol(item),
+				Source:    InteropKindKubeEdge,
+			})
+		}
+		return nil
+	}
+	return nil
+}
+
+// kubeEdgeSelectedNode returns the node a v1alpha2 device's node selector names
+func kubeEdgeSelectedNode(terms []interface{}) string {
+	for _, term := range terms {
+		term, _ := term.(map[string]interface{})
+		expressions, _, _ := unstructured.NestedSlice(term, "matchExpressions")
+		for _, expression := range expressions {
+			expression, _ := expression.(map[string]interface{})
+			values, _, _ := unstructured.NestedStringSlice(expression, "values")
+			if len(values) > 0 {
+				return values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// kubeEdgeProtocol returns a device's protocol: v1beta1 names it, v1alpha2 has one key per
+// protocol
+func kubeEdgeProtocol(item unstructured.Unstructured) string {
+	if name, _, _ := unstructured.NestedString(item.Object, "spec", "protocol", "protocolName"); name != "" {
+		return name
+	}
+	protocol, _, _ := unstructured.NestedMap(item.Object, "spec", "protocol")
+	keys := make([]string, 0, len(protocol))
+	for key := range protocol {
+		if key != "common" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// collectAkriDevices reads Akri's Instances, one per discovered device, with the discovery
+// handler of the Configuration that found each
+func collectAkriDevices(ctx context.Context, client dynamic.Interface, devices map[string][]InteropDevice) error {
+	configurations, err := client.Resource(akriConfigurationResource).Namespace("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	handlers := make(map[string]string, len(configurations.Items))
+	for _, item := range configurations.Items {
+		handler, _, _ := unstructured.NestedString(item.Object, "spec", "discoveryHandler", "name")
+		handlers[item.GetNamespace()+"/"+item.GetName()] = handler
+	}
+
+	instances, err := client.Resource(akriInstanceResource).Namespace("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, item := range instances.Items {
+		configuration, _, _ := unstructured.NestedString(item.Object, "spec", "configurationName")
+		nodes, _, _ := unstructured.NestedStringSlice(item.Object, "spec", "nodes")
+		for _, nodeName := range nodes {
+			devices[nodeName] = append(devices[nodeName], InteropDevice{
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
+				Kind:      configuration,
+				Protocol:  handlers[item.GetNamespace()+"/"+configuration],
+				Source:    InteropKindAkri,
+				Resource:  AkriResourcePrefix + item.GetName(),
+			})
+		}
+	}
+	return nil
+}
+
+// applyInteropInventory imports each source node as an agentless edge node, or refreshes
+// it, and sends its heartbeat; it returns the IDs of the imported nodes
+func (co *CentralOrchestrator) applyInteropInventory(adapter InteropAdapter, inv interopInventory) []string {
+	now := time.Now()
+	nodeIDs := make([]string, 0, len(inv.Nodes))
+	heartbeats := make(map[string]HeartbeatRequest, len(inv.Nodes))
+
+	co.NodeManager.mutex.Lock()
+	for _, source := range inv.Nodes {
+		id := adapter.nodeID(source.Name)
+		node, exists := co.NodeManager.nodes[id]
+		if !exists {
+			node = &EdgeNode{
+				ID:                 id,
+				Name:               source.Name,
+				Labels:             make(map[string]string),
+				Region:             adapter.Region,
+				Zone:               adapter.Zone,
+				SiteID:             adapter.SiteID,
+				State:              co.NodeStateManager.InitialState(),
+				StateChangedAt:     now,
+				HeartbeatTransport: HeartbeatTransportKubernetesAPI,
+				Agentless:          true,
+				CreatedAt:          now,
+			}
+			if region := source.Labels[corev1.LabelTopologyRegion]; region != "" {
+				node.Region = region
+			}
+			if zone := source.Labels[corev1.LabelTopologyZone]; zone != "" {
+				node.Zone = zone
+			}
+			if node.Region == "" {
+				node.Region = "default"
+			}
+			if node.Zone == "" {
+				node.Zone = "default"
+			}
+			co.NodeManager.nodes[id] = node
+			co.Logger.Infof("Imported %s node %s from adapter %s as node %s", adapter.Kind, source.Name, adapter.Name, id)
+		}
+
+		// Labels set through the node attributes API are kept
+		for key, value := range source.Labels {
+			node.Labels[key] = value
+		}
+		node.Labels[InteropAdapterLabel] = adapter.ID
+		node.Labels[InteropSourceLabel] = string(adapter.Kind)
+		if address := nodeAddress(source); address != "" {
+			node.Address = address
+		}
+		node.KubernetesVersion = source.Status.NodeInfo.KubeletVersion
+		node.ContainerRuntime = source.Status.NodeInfo.ContainerRuntimeVersion
+		node.Devices = inv.Devices[source.Name]
+		node.Taints = interopTaints(node.Taints, adapter.DeliverWorkloads)
+		node.UpdatedAt = now
+
+		inventory := summarizeClusterInventory([]corev1.Node{source}, inv.Pods[source.Name])
+		heartbeats[id] = HeartbeatRequest{Status: inventory.status(), Resources: inventory.Resources, Timestamp: now}
+		nodeIDs = append(nodeIDs, id)
+	}
+	co.NodeManager.mutex.Unlock()
+
+	for id, heartbeat := range heartbeats {
+		co.applyHeartbeat(id, heartbeat, HeartbeatTransportKubernetesAPI)
+	}
+	return nodeIDs
+}
+
+// interopTaints adds the inventory-only taint to a node's taints, or removes it once the
+// adapter delivers workloads; other taints are kept
+func interopTaints(taints []NodeTaint, deliver bool) []NodeTaint {
+	kept := make([]NodeTaint, 0, len(taints)+1)
+	for _, taint := range taints {
+		if taint.Key != InteropInventoryOnlyTaint {
+			kept = append(kept, taint)
+		}
+	}
+	if !deliver {
+		kept = append(kept, NodeTaint{Key: InteropInventoryOnlyTaint, Effect: TaintEffectNoSchedule})
+	}
+	return kept
+}
+
+// CreateInteropAdapter connects an adapter to a KubeEdge or Akri cluster and imports its
+// nodes and devices
+func (co *CentralOrchestrator) CreateInteropAdapter(c *gin.Context) {
+	var req InteropAdapterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Kind != InteropKindKubeEdge && req.Kind != InteropKindAkri {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be kubeedge or akri"})
+		return
+	}
+	if _, err := labels.Parse(req.NodeSelector); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid node_selector: %v", err)})
+		return
+	}
+	authMethod, err := req.authMethod()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clients, host, err := connectInterop(req.ClusterCredentials)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := clients.kube.Discovery().ServerVersion(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to reach cluster at %s: %v", host, err)})
+		return
+	}
+
+	now := time.Now()
+	adapter := &InteropAdapter{
+		ID:                 generateID(),
+		Name:               req.Name,
+		Kind:               req.Kind,
+		SiteID:             req.SiteID,
+		Region:             req.Region,
+		Zone:               req.Zone,
+		ClusterCredentials: req.ClusterCredentials,
+		AuthMethod:         authMethod,
+		Host:               host,
+		NodeSelector:       req.NodeSelector,
+		DeliverWorkloads:   req.DeliverWorkloads,
+		CreatedBy:          requestActor(c),
+		CreatedAt:          now,
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ImportedClusterSyncTimeout)
+	defer cancel()
+	inventory, err := collectInteropInventory(ctx, *adapter, clients)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to read %s inventory at %s: %v", req.Kind, host, err)})
+		return
+	}
+	adapter.Nodes = co.applyInteropInventory(*adapter, inventory)
+	adapter.Devices = inventory.deviceCount()
+	adapter.LastSyncedAt = now
+
+	co.Interop.mutex.Lock()
+	co.Interop.adapters[adapter.ID] = adapter
+	co.Interop.clients[adapter.ID] = clients
+	view := adapter.redacted()
+	co.Interop.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "interop.create", "interop:"+adapter.ID, map[string]string{
+		"name":    adapter.Name,
+		"kind":    string(adapter.Kind),
+		"host":    host,
+		"deliver": fmt.Sprintf("%t", adapter.DeliverWorkloads),
+	})
+	co.Logger.Infof("Created %s adapter %s at %s: %d nodes, %d devices", adapter.Kind, adapter.Name, host, len(view.Nodes), view.Devices)
+
+	c.JSON(http.StatusCreated, gin.H{"adapter": view})
+}
+
+// ListInteropAdapters returns interop adapters, without their credentials
+func (co *CentralOrchestrator) ListInteropAdapters(c *gin.Context) {
+	co.Interop.mutex.RLock()
+	defer co.Interop.mutex.RUnlock()
+
+	adapters := make([]InteropAdapter, 0, len(co.Interop.adapters))
+	for _, adapter := range co.Interop.adapters {
+		adapters = append(adapters, adapter.redacted())
+	}
+	sort.Slice(adapters, func(i, j int) bool { return adapters[i].Name < adapters[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"adapters": adapters})
+}
+
+// GetInteropAdapter returns one interop adapter, without its credentials
+func (co *CentralOrchestrator) GetInteropAdapter(c *gin.Context) {
+	co.Interop.mutex.RLock()
+	defer co.Interop.mutex.RUnlock()
+
+	adapter, exists := co.Interop.adapters[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adapter not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"adapter": adapter.redacted()})
+}
+
+// SyncInteropAdapter syncs an adapter now rather than on its next poll
+func (co *CentralOrchestrator) SyncInteropAdapter(c *gin.Context) {
+	id := c.Param("id")
+
+	co.Interop.mutex.RLock()
+	_, exists := co.Interop.adapters[id]
+	co.Interop.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adapter not found"})
+		return
+	}
+
+	if err := co.syncInteropAdapter(id); err != nil {
+		co.Interop.recordSync(id, nil, 0, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	var view InteropAdapter
+	co.Interop.mutex.RLock()
+	if adapter, exists := co.Interop.adapters[id]; exists {
+		view = adapter.redacted()
+	}
+	co.Interop.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"adapter": view})
+}
+
+// DeleteInteropAdapter removes an adapter and the nodes it imported. Objects it delivered
+// are deleted from the cluster when it is reachable; the source installation is otherwise
+// left as it was.
+func (co *CentralOrchestrator) DeleteInteropAdapter(c *gin.Context) {
+	id := c.Param("id")
+
+	_, clients, err := co.Interop.adapter(id)
+	adapter := co.Interop.remove(id)
+	if adapter == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adapter not found"})
+		return
+	}
+
+	cleanup := "deleted"
+	if err == nil && adapter.DeliverWorkloads {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), ImportedClusterSyncTimeout)
+		defer cancel()
+		for _, nodeID := range adapter.Nodes {
+			if err = deleteClusterObjects(ctx, clients.kube, clusterTarget{NodeID: nodeID}.baseSelector()); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		cleanup = "left in place: " + err.Error()
+		co.Logger.Warnf("Removed adapter %s without deleting its workloads: %v", adapter.Name, err)
+	}
+
+	co.NodeManager.mutex.Lock()
+	for nodeID, node := range co.NodeManager.nodes {
+		if node.Agentless && node.Labels[InteropAdapterLabel] == id {
+			delete(co.NodeManager.nodes, nodeID)
+			co.DesiredStateCache.forget(nodeID)
+		}
+	}
+	co.NodeManager.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "interop.remove", "interop:"+id, map[string]string{
+		"name":      adapter.Name,
+		"workloads": cleanup,
+	})
+	co.Logger.Infof("Removed %s adapter %s and its %d nodes", adapter.Kind, adapter.Name, len(adapter.Nodes))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Adapter removed", "workloads": cleanup})
+}
+
+// interopController syncs every interop adapter on the imported cluster interval
+func (co *CentralOrchestrator) interopController() {
+	ticker := time.NewTicker(ImportedClusterSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.syncInteropAdapters()
+		}
+	}
+}
+
+// syncInteropAdapters syncs the adapters concurrently so an unreachable cluster does not
+// hold up the rest
+func (co *CentralOrchestrator) syncInteropAdapters() {
+	co.Interop.mutex.RLock()
+	ids := make([]string, 0, len(co.Interop.adapters))
+	for id := range co.Interop.adapters {
+		ids = append(ids, id)
+	}
+	co.Interop.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := co.syncInteropAdapter(id); err != nil {
+				co.Interop.recordSync(id, nil, 0, err)
+				co.Logger.Warnf("Failed to sync interop adapter %s: %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// syncInteropAdapter refreshes an adapter's nodes and devices and, when it delivers
+// workloads, applies the ones scheduled to each node. Nodes that leave the cluster or stop
+// matching the selector get no more heartbeats and go offline, and the objects delivered to
+// them are deleted.
+func (co *CentralOrchestrator) syncInteropAdapter(id string) error {
+	adapter, clients, err := co.Interop.adapter(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ImportedClusterSyncTimeout)
+	defer cancel()
+
+	inventory, err := collectInteropInventory(ctx, adapter, clients)
+	if err != nil {
+		return err
+	}
+	nodeIDs := co.applyInteropInventory(adapter, inventory)
+
+	if adapter.DeliverWorkloads {
+		for _, source := range inventory.Nodes {
+			target := adapter.target(source.Name, inventory.Devices[source.Name])
+			if err := co.reconcileClusterWorkloads(ctx, target.NodeID, clients.kube, target); err != nil {
+				co.Logger.Warnf("Failed to deliver workloads to %s node %s: %v", adapter.Kind, source.Name, err)
+			}
+		}
+		for _, nodeID := range adapter.Nodes {
+			if contains(nodeIDs, nodeID) {
+				continue
+			}
+			if err := deleteClusterObjects(ctx, clients.kube, clusterTarget{NodeID: nodeID}.baseSelector()); err != nil {
+				co.Logger.Warnf("Failed to remove workloads of departed node %s: %v", nodeID, err)
+			}
+		}
+	}
+
+	co.Interop.recordSync(id, nodeIDs, inventory.deviceCount(), nil)
+	return nil
+}
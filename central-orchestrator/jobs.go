@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// Interval between checks of cron job schedules
+	CronCheckInterval = 15 * time.Second
+	// Label linking a job to the cron job that created it
+	CronJobLabel = "edge.io/cron-job"
+
+	DefaultJobBackoffLimit            = 3
+	DefaultSuccessfulJobsHistoryLimit = 3
+	DefaultFailedJobsHistoryLimit     = 1
+)
+
+// ConcurrencyPolicy decides what a cron job does when its previous job is still running
+type ConcurrencyPolicy string
+
+const (
+	ConcurrencyAllow   ConcurrencyPolicy = "allow"
+	ConcurrencyForbid  ConcurrencyPolicy = "forbid"
+	ConcurrencyReplace ConcurrencyPolicy = "replace"
+)
+
+// JobPolicy configures how jobs run, and for cron jobs when
+type JobPolicy struct {
+	// Failed runs retried before the job fails, on the same node or another
+	BackoffLimit *int32 `json:"backoff_limit,omitempty"`
+	// Cron expression of a cron job, such as "*/15 * * * *" or "@daily"
+	Schedule string `json:"schedule,omitempty"`
+	// Time zone the schedule is read in; UTC by default
+	TimeZone          string            `json:"time_zone,omitempty"`
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrency_policy,omitempty"`
+	// Stops a cron job from creating jobs; running ones continue
+	Suspend bool `json:"suspend,omitempty"`
+	// Finished jobs a cron job keeps, by outcome
+	SuccessfulJobsHistoryLimit *int32 `json:"successful_jobs_history_limit,omitempty"`
+	FailedJobsHistoryLimit     *int32 `json:"failed_jobs_history_limit,omitempty"`
+}
+
+// JobStatus counts a job's runs; for a cron job it tracks the schedule and the jobs created
+type JobStatus struct {
+	// Replicas that ran to completion
+	Succeeded int32 `json:"succeeded"`
+	// Runs that failed on a node
+	Failed      int32      `json:"failed"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	LastScheduleTime *time.Time `json:"last_schedule_time,omitempty"`
+	NextScheduleTime *time.Time `json:"next_schedule_time,omitempty"`
+	// IDs of the cron job's jobs still running
+	Active []string `json:"active,omitempty"`
+}
+
+// validate checks a job policy for a workload type and fills in its defaults
+func (p *JobPolicy) validate(workloadType WorkloadType) error {
+	if workloadType == WorkloadTypeCronJob {
+		if p.Schedule == "" {
+			return fmt.Errorf("cron jobs require job.schedule")
+		}
+		if _, err := p.schedule(); err != nil {
+			return err
+		}
+	} else if p.Schedule != "" {
+		return fmt.Errorf("job.schedule is only valid for cron jobs")
+	}
+
+	switch p.ConcurrencyPolicy {
+	case "":
+		p.ConcurrencyPolicy = ConcurrencyAllow
+	case ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return fmt.Errorf("job.concurrency_policy must be allow, forbid or replace")
+	}
+
+	defaults := []struct {
+		value        **int32
+		name         string
+		defaultValue int32
+	}{
+		{&p.BackoffLimit, "backoff_limit", DefaultJobBackoffLimit},
+		{&p.SuccessfulJobsHistoryLimit, "successful_jobs_history_limit", DefaultSuccessfulJobsHistoryLimit},
+		{&p.FailedJobsHistoryLimit, "failed_jobs_history_limit", DefaultFailedJobsHistoryLimit},
+	}
+	for _, d := range defaults {
+		if *d.value == nil {
+			value := d.defaultValue
+			*d.value = &value
+		} else if **d.value < 0 {
+			return fmt.Errorf("job.%s must not be negative", d.name)
+		}
+	}
+	return nil
+}
+
+// schedule parses the policy's cron expression in its time zone
+func (p *JobPolicy) schedule() (*cronSchedule, error) {
+	location := time.UTC
+	if p.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(p.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid job.time_zone: %v", err)
+		}
+	}
+	return parseCronSchedule(p.Schedule, location)
+}
+
+// isJob reports whether the workload runs to completion rather than staying up
+func (w *Workload) isJob() bool {
+	return w.Type == WorkloadTypeJob
+}
+
+// jobStatus returns the workload's job status, creating it for jobs persisted without one
+func (w *Workload) jobStatus() *JobStatus {
+	if w.JobStatus == nil {
+		w.JobStatus = &JobStatus{}
+	}
+	return w.JobStatus
+}
+
+// backoffLimit returns the failed runs retried before the job fails
+func (w *Workload) backoffLimit() int32 {
+	if w.Job == nil || w.Job.BackoffLimit == nil {
+		return DefaultJobBackoffLimit
+	}
+	return *w.Job.BackoffLimit
+}
+
+// completedReplicas returns the replicas of a job that ran to completion
+func (w *Workload) completedReplicas() int32 {
+	var replicas int32
+	for _, deployment := range w.Deployments {
+		if deployment.Status == WorkloadStatusCompleted {
+			replicas += deployment.Replicas
+		}
+	}
+	return replicas
+}
+
+// scheduledReplicas returns the replicas placed or, for jobs, already run to completion;
+// completed runs are not placed again
+func (w *Workload) scheduledReplicas() int32 {
+	return w.runningReplicas() + w.completedReplicas()
+}
+
+// failJobRun records a job run that failed on a node. The run is retried through the
+// scheduler until the backoff limit is spent; then the job fails and its other runs stop.
+// Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) failJobRun(workload *Workload, deployment *WorkloadDeployment, now time.Time) {
+	status := workload.jobStatus()
+	status.Failed++
+	deployment.Status = WorkloadStatusFailed
+	workload.UpdatedAt = now
+
+	if status.Failed <= workload.backoffLimit() {
+		co.Logger.Warnf("Job %s failed on node %s; retrying (%d of %d retries)", workload.Name, deployment.NodeID, status.Failed, workload.backoffLimit())
+		workload.Status = WorkloadStatusPending
+		return
+	}
+
+	for i := range workload.Deployments {
+		if workload.Deployments[i].placed() {
+			workload.Deployments[i].Status = WorkloadStatusStopped
+			workload.Deployments[i].UpdatedAt = now
+		}
+	}
+	workload.Status = WorkloadStatusFailed
+	status.CompletedAt = &now
+	co.Logger.Errorf("Job %s failed after %d failed runs", workload.Name, status.Failed)
+}
+
+// cronController creates the jobs of cron jobs as their schedules come due
+func (co *CentralOrchestrator) cronController() {
+	ticker := time.NewTicker(CronCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.runCronJobs(time.Now())
+		}
+	}
+}
+
+// runCronJobs creates a job for each cron job whose schedule came due and prunes their
+// finished jobs. Runs missed while no orchestrator was leading collapse into one.
+func (co *CentralOrchestrator) runCronJobs(now time.Time) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	var cronJobs []*Workload
+	children := make(map[string][]*Workload)
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.Type == WorkloadTypeCronJob {
+			cronJobs = append(cronJobs, workload)
+		}
+		if workload.CronJobID != "" {
+			children[workload.CronJobID] = append(children[workload.CronJobID], workload)
+		}
+	}
+
+	created := false
+	for _, cronJob := range cronJobs {
+		jobs := children[cronJob.ID]
+		if cronJob.Job != nil && !cronJob.Job.Suspend {
+			if job := co.runCronSchedule(cronJob, jobs, now); job != nil {
+				jobs = append(jobs, job)
+				created = true
+			}
+		}
+		co.pruneCronJobs(cronJob, jobs)
+	}
+
+	if created {
+		co.AgentStreamHub.wake()
+	}
+}
+
+// runCronSchedule creates the cron job's next job if it is due, and returns it
+func (co *CentralOrchestrator) runCronSchedule(cronJob *Workload, jobs []*Workload, now time.Time) *Workload {
+	schedule, err := cronJob.Job.schedule()
+	if err != nil {
+		co.Logger.Errorf("Cron job %s has an invalid schedule: %v", cronJob.Name, err)
+		return nil
+	}
+	status := cronJob.jobStatus()
+
+	last := cronJob.CreatedAt
+	if status.LastScheduleTime != nil {
+		last = *status.LastScheduleTime
+	}
+	due := schedule.next(last)
+	if due.IsZero() || due.After(now) {
+		status.NextScheduleTime = nonZeroTime(due)
+		return nil
+	}
+	for next := schedule.next(due); !next.IsZero() && !next.After(now); next = schedule.next(due) {
+		due = next
+	}
+	status.LastScheduleTime = &due
+	status.NextScheduleTime = nonZeroTime(schedule.next(due))
+
+	var active []*Workload
+	for _, job := range jobs {
+		if !jobFinished(job) {
+			active = append(active, job)
+		}
+	}
+	if len(active) > 0 {
+		switch cronJob.Job.ConcurrencyPolicy {
+		case ConcurrencyForbid:
+			co.Logger.Infof("Cron job %s skipped the run due at %s: its previous job is still running", cronJob.Name, due.Format(time.RFC3339))
+			return nil
+		case ConcurrencyReplace:
+			for _, job := range active {
+				co.Logger.Infof("Cron job %s replaces its running job %s", cronJob.Name, job.Name)
+				co.removeJob(job)
+			}
+		}
+	}
+
+	job, err := newCronJobRun(cronJob, due, now)
+	if err != nil {
+		co.Logger.Errorf("Cron job %s could not create its job: %v", cronJob.Name, err)
+		return nil
+	}
+	co.WorkloadManager.workloads[job.ID] = job
+	cronJob.UpdatedAt = now
+	co.Logger.Infof("Cron job %s created job %s for %s", cronJob.Name, job.Name, due.Format(time.RFC3339))
+	return job
+}
+
+// newCronJobRun makes the pending job a cron job runs at a scheduled time
+func newCronJobRun(cronJob *Workload, scheduled, now time.Time) (*Workload, error) {
+	data, err := json.Marshal(cronJob)
+	if err != nil {
+		return nil, err
+	}
+	job := &Workload{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+
+	job.ID = generateID()
+	// Named after the scheduled minute, as Kubernetes names the jobs of its cron jobs
+	job.Name = fmt.Sprintf("%s-%d", cronJob.Name, scheduled.Unix()/60)
+	job.Type = WorkloadTypeJob
+	job.CronJobID = cronJob.ID
+	if job.Labels == nil {
+		job.Labels = make(map[string]string)
+	}
+	job.Labels[CronJobLabel] = cronJob.ID
+	job.Selector = map[string]string{"app": job.Name, "workload-id": job.ID}
+	job.Job.Schedule = ""
+	job.JobStatus = &JobStatus{}
+	job.Status = WorkloadStatusPending
+	job.Deployments = make([]WorkloadDeployment, 0)
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return job, nil
+}
+
+// jobFinished reports whether a job completed or failed for good
+func jobFinished(job *Workload) bool {
+	return job.Status == WorkloadStatusCompleted || job.Status == WorkloadStatusFailed
+}
+
+// pruneCronJobs lists the cron job's running jobs and removes the finished ones beyond
+// its history limits, oldest first
+func (co *CentralOrchestrator) pruneCronJobs(cronJob *Workload, jobs []*Workload) {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+
+	successfulLimit, failedLimit := int32(DefaultSuccessfulJobsHistoryLimit), int32(DefaultFailedJobsHistoryLimit)
+	if policy := cronJob.Job; policy != nil {
+		if policy.SuccessfulJobsHistoryLimit != nil {
+			successfulLimit = *policy.SuccessfulJobsHistoryLimit
+		}
+		if policy.FailedJobsHistoryLimit != nil {
+			failedLimit = *policy.FailedJobsHistoryLimit
+		}
+	}
+
+	var active []string
+	var succeeded, failed int32
+	for _, job := range jobs {
+		switch job.Status {
+		case WorkloadStatusCompleted:
+			if succeeded++; succeeded > successfulLimit {
+				co.removeJob(job)
+			}
+		case WorkloadStatusFailed:
+			if failed++; failed > failedLimit {
+				co.removeJob(job)
+			}
+		default:
+			if _, exists := co.WorkloadManager.workloads[job.ID]; exists {
+				active = append(active, job.ID)
+			}
+		}
+	}
+	cronJob.jobStatus().Active = active
+}
+
+// removeJob stops and forgets a job; callers must hold the WorkloadManager lock
+func (co *CentralOrchestrator) removeJob(job *Workload) {
+	// Streaming agents remove it from their nodes once it leaves their desired state
+	job.Status = WorkloadStatusStopped
+	job.UpdatedAt = time.Now()
+	delete(co.WorkloadManager.workloads, job.ID)
+	co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, job.ID)
+	co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, job.ID)
+	co.RevisionHistory.forget(job.ID)
+}
+
+// nonZeroTime returns a pointer to t, or nil for the zero time
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
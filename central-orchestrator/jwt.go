@@ -0,0 +1,669 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Issuer and audience of tokens when the keys file names none
+	DefaultJWTIssuer = "edge-orchestrator"
+
+	// Lifetime of issued tokens when the request and the keys file name none
+	DefaultJWTTokenTTL = time.Hour
+
+	// Longest lifetime a token may be issued with when the keys file names none
+	DefaultJWTMaxTokenTTL = 24 * time.Hour
+
+	// Allowed difference between the clocks of the issuer and this replica
+	JWTClockSkew = time.Minute
+
+	// How often the keys file is checked for changes
+	JWTKeyReloadInterval = time.Minute
+
+	// Shortest HMAC secret accepted, as RFC 7518 §3.2 requires for HS256
+	minJWTSecretBytes = 32
+)
+
+// JWKSPath publishes the RSA keys tokens are verified with
+const JWKSPath = "/.well-known/jwks.json"
+
+// Context key set by AuthMiddleware to when a JWT-authenticated caller's token expires
+const ContextKeyTokenExpiry = "token_expiry"
+
+// jwtHashes are the hashes of the supported signing algorithms. "none" and the ECDSA
+// algorithms are not accepted.
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// JWTKey is a key tokens are signed or verified with. HMAC keys take a secret; RSA keys a
+// private key to sign with, or only a public key to verify tokens issued elsewhere.
+type JWTKey struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// HMAC secret, inline or read from a file, of at least 32 bytes
+	Secret     string `json:"secret,omitempty"`
+	SecretFile string `json:"secret_file,omitempty"`
+	// PEM RSA keys
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+	// When the key starts signing. A new key is listed with a future time so every
+	// replica and verifier has it before tokens signed with it appear.
+	NotBefore time.Time `json:"not_before,omitempty"`
+
+	secret     []byte
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+}
+
+// JWTConfig is the signing keys and token settings read from JWT_KEYS_FILE. Every listed
+// key verifies tokens; the one that started signing last signs new ones. Keys are rotated
+// by adding the next key with a future not_before, and removing the previous one once
+// the tokens it signed have expired.
+type JWTConfig struct {
+	Issuer string `json:"issuer,omitempty"`
+	// Audience issued tokens are for and verified tokens must include
+	Audience string `json:"audience,omitempty"`
+	// Go durations, such as 1h
+	TokenTTL    string   `json:"token_ttl,omitempty"`
+	MaxTokenTTL string   `json:"max_token_ttl,omitempty"`
+	Keys        []JWTKey `json:"keys"`
+
+	tokenTTL    time.Duration
+	maxTokenTTL time.Duration
+	keys        map[string]*JWTKey
+}
+
+// JWTClaims are the registered claims of a token and the identity it authenticates
+type JWTClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Role      string      `json:"role"`
+	Groups    []string    `json:"groups,omitempty"`
+	// Tenants and organizations the token may act on; empty for all
+	Tenants []string `json:"tenants,omitempty"`
+	// resource:verb pairs narrowing the role, as for access policy tokens
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// jwtAudience is the aud claim, a single string or an array of them (RFC 7519 §4.1.3)
+type jwtAudience []string
+
+func (a jwtAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// loadJWTConfig reads and validates a keys file
+func loadJWTConfig(path string) (*JWTConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config JWTConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid JWT keys file: %v", err)
+	}
+
+	if config.Issuer == "" {
+		config.Issuer = DefaultJWTIssuer
+	}
+	if config.Audience == "" {
+		config.Audience = DefaultJWTIssuer
+	}
+	config.tokenTTL, config.maxTokenTTL = DefaultJWTTokenTTL, DefaultJWTMaxTokenTTL
+	if config.TokenTTL != "" {
+		if config.tokenTTL, err = time.ParseDuration(config.TokenTTL); err != nil || config.tokenTTL <= 0 {
+			return nil, fmt.Errorf("invalid token_ttl %q", config.TokenTTL)
+		}
+	}
+	if config.MaxTokenTTL != "" {
+		if config.maxTokenTTL, err = time.ParseDuration(config.MaxTokenTTL); err != nil || config.maxTokenTTL <= 0 {
+			return nil, fmt.Errorf("invalid max_token_ttl %q", config.MaxTokenTTL)
+		}
+	}
+	if config.tokenTTL > config.maxTokenTTL {
+		return nil, fmt.Errorf("token_ttl %s exceeds max_token_ttl %s", config.tokenTTL, config.maxTokenTTL)
+	}
+
+	if len(config.Keys) == 0 {
+		return nil, fmt.Errorf("JWT keys file lists no keys")
+	}
+	config.keys = make(map[string]*JWTKey, len(config.Keys))
+	for i := range config.Keys {
+		key := &config.Keys[i]
+		if key.ID == "" {
+			return nil, fmt.Errorf("key %d has no kid", i)
+		}
+		if _, duplicate := config.keys[key.ID]; duplicate {
+			return nil, fmt.Errorf("kid %s is listed twice", key.ID)
+		}
+		if err := key.load(); err != nil {
+			return nil, fmt.Errorf("key %s: %v", key.ID, err)
+		}
+		config.keys[key.ID] = key
+	}
+	return &config, nil
+}
+
+// load reads and checks the key material for the key's algorithm
+func (key *JWTKey) load() error {
+	if _, supported := jwtHashes[key.Algorithm]; !supported {
+		return fmt.Errorf("unsupported alg %q", key.Algorithm)
+	}
+
+	if strings.HasPrefix(key.Algorithm, "HS") {
+		secret := []byte(key.Secret)
+		if key.SecretFile != "" {
+			data, err := os.ReadFile(key.SecretFile)
+			if err != nil {
+				return err
+			}
+			secret = []byte(strings.TrimRight(string(data), "\r\n"))
+		}
+		if len(secret) < minJWTSecretBytes {
+			return fmt.Errorf("HMAC secrets must be at least %d bytes", minJWTSecretBytes)
+		}
+		key.secret = secret
+		return nil
+	}
+
+	switch {
+	case key.PrivateKeyFile != "":
+		block, err := readPEMFile(key.PrivateKeyFile)
+		if err != nil {
+			return err
+		}
+		if key.privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			rsaKey, isRSA := parsed.(*rsa.PrivateKey)
+			if pkcs8Err != nil || !isRSA {
+				return fmt.Errorf("%s is not an RSA private key", key.PrivateKeyFile)
+			}
+			key.privateKey = rsaKey
+		}
+		key.publicKey = &key.privateKey.PublicKey
+	case key.PublicKeyFile != "":
+		block, err := readPEMFile(key.PublicKeyFile)
+		if err != nil {
+			return err
+		}
+		if key.publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			parsed, pkixErr := x509.ParsePKIXPublicKey(block.Bytes)
+			rsaKey, isRSA := parsed.(*rsa.PublicKey)
+			if pkixErr != nil || !isRSA {
+				return fmt.Errorf("%s is not an RSA public key", key.PublicKeyFile)
+			}
+			key.publicKey = rsaKey
+		}
+	default:
+		return fmt.Errorf("RSA keys need a private_key_file or a public_key_file")
+	}
+	if key.publicKey.N.BitLen() < RSAKeySize {
+		return fmt.Errorf("RSA keys must be at least %d bits", RSAKeySize)
+	}
+	return nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM block", path)
+	}
+	return block, nil
+}
+
+// canSign reports whether the key holds what signing needs, rather than only verifying
+func (key *JWTKey) canSign() bool {
+	return key.secret != nil || key.privateKey != nil
+}
+
+// signingKey returns the key new tokens are signed with: of the keys able to sign that
+// have started, the one that started last, or the one listed last among equals
+func (config *JWTConfig) signingKey(now time.Time) *JWTKey {
+	var signing *JWTKey
+	for i := range config.Keys {
+		key := &config.Keys[i]
+		if !key.canSign() || key.NotBefore.After(now) {
+			continue
+		}
+		if signing == nil || !key.NotBefore.Before(signing.NotBefore) {
+			signing = key
+		}
+	}
+	return signing
+}
+
+// signature computes a token's signature over its signing input
+func (key *JWTKey) signature(signingInput string) ([]byte, error) {
+	hash := jwtHashes[key.Algorithm]
+	if key.secret != nil {
+		mac := hmac.New(hash.New, key.secret)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil), nil
+	}
+	digest := hash.New()
+	digest.Write([]byte(signingInput))
+	return rsa.SignPKCS1v15(rand.Reader, key.privateKey, hash, digest.Sum(nil))
+}
+
+// verifySignature checks a token's signature with the key
+func (key *JWTKey) verifySignature(signingInput string, signature []byte) error {
+	hash := jwtHashes[key.Algorithm]
+	if key.secret != nil {
+		expected, _ := key.signature(signingInput)
+		if !hmac.Equal(signature, expected) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	digest := hash.New()
+	digest.Write([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(key.publicKey, hash, digest.Sum(nil), signature); err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// sign issues a token for the claims with the current signing key
+func (config *JWTConfig) sign(claims JWTClaims, now time.Time) (string, *JWTKey, error) {
+	key := config.signingKey(now)
+	if key == nil {
+		return "", nil, fmt.Errorf("no JWT signing key is active")
+	}
+
+	header, err := json.Marshal(jwtHeader{Alg: key.Algorithm, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", nil, err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := key.signature(signingInput)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), key, nil
+}
+
+// verify checks a token's signature, lifetime, issuer, audience and role and returns its
+// claims. The algorithm must be the one its key was configured with, so an RSA public key
+// can never be used as an HMAC secret.
+func (config *JWTConfig) verify(token string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("header is not base64url")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("header is not JSON")
+	}
+	key, exists := config.keys[header.Kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown signing key %q", header.Kid)
+	}
+	if header.Alg != key.Algorithm {
+		return nil, fmt.Errorf("alg %q does not match key %s", header.Alg, key.ID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature is not base64url")
+	}
+	if err := key.verifySignature(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("payload is not base64url")
+	}
+	var claims JWTClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+
+	switch {
+	case claims.ExpiresAt == 0:
+		return nil, fmt.Errorf("token has no expiry")
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(JWTClockSkew)):
+		return nil, fmt.Errorf("token expired")
+	case claims.NotBefore != 0 && now.Add(JWTClockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, fmt.Errorf("token is not valid yet")
+	case claims.Issuer != config.Issuer:
+		return nil, fmt.Errorf("token was issued by %q", claims.Issuer)
+	case !contains(claims.Audience, config.Audience):
+		return nil, fmt.Errorf("token is not for audience %q", config.Audience)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("token has no subject")
+	}
+	// Nodes are bound to their identity by certificate, which a token cannot carry
+	if claims.Role == RoleNode {
+		return nil, fmt.Errorf("tokens of role node are not accepted")
+	}
+	if _, known := roleRanks[claims.Role]; !known {
+		return nil, fmt.Errorf("token has unknown role %q", claims.Role)
+	}
+	return &claims, nil
+}
+
+// looksLikeJWT reports whether a bearer token has the three segments of a compact JWS
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// jwtConfig returns the current keys, or nil when JWT authentication is not configured
+func (sm *SecurityManager) jwtConfig() *JWTConfig {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.jwt
+}
+
+// enforcesRoles reports whether callers are limited to the roles their credentials carry;
+// without an access policy or JWT keys every caller has full access
+func (sm *SecurityManager) enforcesRoles() bool {
+	return sm.policy != nil || sm.jwtConfig() != nil
+}
+
+// loadJWTKeys reads JWT_KEYS_FILE, keeping the current keys when it fails to load
+func (sm *SecurityManager) loadJWTKeys() error {
+	info, err := os.Stat(sm.jwtKeysFile)
+	if err != nil {
+		return err
+	}
+	config, err := loadJWTConfig(sm.jwtKeysFile)
+	if err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	sm.jwt = config
+	sm.jwtKeysModTime = info.ModTime()
+	sm.mutex.Unlock()
+
+	signing := "none"
+	if key := config.signingKey(time.Now()); key != nil {
+		signing = key.ID
+	}
+	sm.logger.Infof("Loaded %d JWT keys, signing with %s", len(config.Keys), signing)
+	return nil
+}
+
+// watchJWTKeys reloads the keys file when it changes, so keys are rotated without a
+// restart. Every replica runs it, since each verifies tokens itself.
+func (sm *SecurityManager) watchJWTKeys() {
+	if sm.jwtKeysFile == "" {
+		return
+	}
+
+	ticker := time.NewTicker(JWTKeyReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(sm.jwtKeysFile)
+		if err != nil {
+			sm.logger.Warnf("Failed to check JWT keys file: %v", err)
+			continue
+		}
+		sm.mutex.RLock()
+		unchanged := info.ModTime().Equal(sm.jwtKeysModTime)
+		sm.mutex.RUnlock()
+		if unchanged {
+			continue
+		}
+		if err := sm.loadJWTKeys(); err != nil {
+			sm.logger.Errorf("Failed to reload JWT keys, keeping the current ones: %v", err)
+		}
+	}
+}
+
+// TokenRequest asks for a token. Callers get one for their own identity, narrowed by
+// what they request; admins may name another subject and any role.
+type TokenRequest struct {
+	// Go duration, such as 8h; the keys file's token_ttl when empty
+	TTL     string   `json:"ttl,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	Role    string   `json:"role,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Tenants []string `json:"tenants,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+}
+
+// TokenResponse carries an issued token
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+	KeyID     string    `json:"key_id"`
+}
+
+// tokenClaims builds the claims of a requested token. A caller's own token may narrow its
+// role, tenants and scopes but never widen them; admins naming another subject choose its
+// role and groups, within their own tenants and scopes.
+func tokenClaims(c *gin.Context, req *TokenRequest) (JWTClaims, int, error) {
+	user, role := c.GetString("user"), c.GetString("role")
+	claims := JWTClaims{Subject: user, Role: role}
+	if value, exists := c.Get(ContextKeyGroups); exists {
+		claims.Groups, _ = value.([]string)
+	}
+
+	for _, scope := range req.Scopes {
+		if resource, verb, found := strings.Cut(scope, ":"); scope != "*" && (!found || resource == "" || verb == "") {
+			return claims, http.StatusBadRequest, fmt.Errorf("Invalid scope %q, want resource:verb", scope)
+		}
+	}
+	if req.Role != "" {
+		if _, known := roleRanks[req.Role]; !known && req.Role != RoleNode {
+			return claims, http.StatusBadRequest, fmt.Errorf("Unknown role %q", req.Role)
+		}
+	}
+	// A token carries no node identity, so one of role node could act for every node
+	if role == RoleNode || req.Role == RoleNode {
+		return claims, http.StatusForbidden, fmt.Errorf("Tokens are not issued for role node; nodes authenticate with their certificates")
+	}
+
+	switch {
+	case req.Subject != "" && req.Subject != user:
+		if role != RoleAdmin {
+			return claims, http.StatusForbidden, fmt.Errorf("Only admins may issue tokens for other subjects")
+		}
+		if req.Role == "" {
+			return claims, http.StatusBadRequest, fmt.Errorf("Role is required with subject")
+		}
+		claims.Subject, claims.Role, claims.Groups = req.Subject, req.Role, req.Groups
+	case len(req.Groups) > 0:
+		return claims, http.StatusBadRequest, fmt.Errorf("Groups may only be set with subject")
+	case req.Role != "" && req.Role != role:
+		if roleRanks[req.Role] > roleRanks[role] {
+			return claims, http.StatusForbidden, fmt.Errorf("Role %s may not issue %s tokens", role, req.Role)
+		}
+		claims.Role = req.Role
+	}
+
+	// Tenant and scope limits of the caller carry over, also to tokens for other subjects
+
+	claims.Tenants = req.Tenants
+	if value, limited := c.Get(ContextKeyTenants); limited {
+		allowed := value.([]string)
+		for _, tenant := range req.Tenants {
+			if !contains(allowed, tenant) {
+				return claims, http.StatusForbidden, fmt.Errorf("Not allowed to act on tenant %s", tenant)
+			}
+		}
+		if len(req.Tenants) == 0 {
+			claims.Tenants = allowed
+		}
+	}
+
+	claims.Scopes = req.Scopes
+	if value, scoped := c.Get(ContextKeyScopes); scoped {
+		allowed := value.([]string)
+		for _, scope := range req.Scopes {
+			if !scopeAllows(allowed, scope) {
+				return claims, http.StatusForbidden, fmt.Errorf("Token lacks scope %s", scope)
+			}
+		}
+		if len(req.Scopes) == 0 {
+			claims.Scopes = allowed
+		}
+	}
+	return claims, http.StatusOK, nil
+}
+
+// IssueToken exchanges the caller's credentials for a signed token. A token issued to a
+// caller authenticated with another token never outlives it.
+func (co *CentralOrchestrator) IssueToken(c *gin.Context) {
+	config := co.SecurityManager.jwtConfig()
+	if config == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "JWT_KEYS_FILE is not configured"})
+		return
+	}
+	if c.GetString(ContextKeyImpersonator) != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Tokens cannot be issued while impersonating; name the subject instead"})
+		return
+	}
+
+	var req TokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ttl := config.tokenTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a duration such as 8h"})
+			return
+		}
+	}
+	if ttl > config.maxTokenTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ttl exceeds the maximum of %s", config.maxTokenTTL)})
+		return
+	}
+
+	claims, status, err := tokenClaims(c, &req)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+	if value, exists := c.Get(ContextKeyTokenExpiry); exists && value.(time.Time).Before(expiresAt) {
+		expiresAt = value.(time.Time)
+	}
+	claims.Issuer = config.Issuer
+	claims.Audience = jwtAudience{config.Audience}
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.ExpiresAt = expiresAt.Unix()
+	claims.ID = generateID()
+
+	token, key, err := config.sign(claims, now)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.Logger.Infof("Issued token %s for %s with role %s until %s", claims.ID, claims.Subject, claims.Role, expiresAt.Format(time.RFC3339))
+	co.AuditLog.RecordRequest(c, "token.issue", "user:"+claims.Subject, map[string]string{
+		"role":       claims.Role,
+		"jti":        claims.ID,
+		"key_id":     key.ID,
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+	c.JSON(http.StatusOK, TokenResponse{Token: token, TokenType: "Bearer", ExpiresAt: expiresAt, KeyID: key.ID})
+}
+
+// jwksKey is an RSA verification key as published in the key set (RFC 7517)
+type jwksKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// GetJWKS publishes the RSA keys tokens are verified with, so other services can verify
+// the orchestrator's tokens. HMAC secrets are never published.
+func (co *CentralOrchestrator) GetJWKS(c *gin.Context) {
+	keys := make([]jwksKey, 0)
+	if config := co.SecurityManager.jwtConfig(); config != nil {
+		for _, key := range config.Keys {
+			if key.publicKey == nil {
+				continue
+			}
+			keys = append(keys, jwksKey{
+				Kty: "RSA",
+				Kid: key.ID,
+				Alg: key.Algorithm,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.publicKey.E)).Bytes()),
+			})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
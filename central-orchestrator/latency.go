@@ -0,0 +1,153 @@
+package main
+
+import "time"
+
+const (
+	// Latency target every agent measures: the orchestrator itself
+	LatencyTargetOrchestrator = "orchestrator"
+
+	// Measurements older than this are ignored by latency-aware placement
+	LatencyMeasurementMaxAge = 10 * time.Minute
+
+	// Link quality is graded over the measurements of this window, so a single bad probe
+	// does not regrade a node
+	LinkQualityWindow = 15 * time.Minute
+	// Measurements needed in the window for a grade
+	LinkQualityMinSamples = 3
+
+	// A node missing heartbeats this long is offline
+	NodeOfflineTimeout = 2 * time.Minute
+	// A node on a poor link loses heartbeats now and then; it is given longer, so it does not
+	// flap between online and offline and have its workloads failed over each time
+	PoorLinkOfflineTimeout = 5 * time.Minute
+)
+
+// LinkQuality grades the link between a node and the orchestrator
+type LinkQuality string
+
+const (
+	LinkQualityGood    LinkQuality = "good"
+	LinkQualityFair    LinkQuality = "fair"
+	LinkQualityPoor    LinkQuality = "poor"
+	LinkQualityUnknown LinkQuality = "unknown"
+)
+
+// linkQualityThresholds are the worst average packet loss and jitter of each grade, best
+// grade first; links worse than all of them are poor
+var linkQualityThresholds = []struct {
+	quality           LinkQuality
+	packetLossPercent float64
+	jitterMillis      float64
+}{
+	{LinkQualityGood, 1, 20},
+	{LinkQualityFair, 5, 50},
+}
+
+// LatencyMeasurement is the round-trip time and link quality an agent measured from its
+// node to a target
+type LatencyMeasurement struct {
+	// "orchestrator" or the name of a probe target configured on the agent
+	Target string `json:"target"`
+	// 0 when every connection attempt was lost
+	RTTMillis         float64   `json:"rtt_ms"`
+	PacketLossPercent float64   `json:"packet_loss_percent"`
+	JitterMillis      float64   `json:"jitter_ms"`
+	MeasuredAt        time.Time `json:"measured_at"`
+}
+
+// latencyTo returns the node's latest round-trip time to target, or ok false when the node
+// has not measured it recently or could not reach it
+func (node *EdgeNode) latencyTo(target string, now time.Time) (float64, bool) {
+	for _, measurement := range node.Latency {
+		if measurement.Target != target {
+			continue
+		}
+		if now.Sub(measurement.MeasuredAt) > LatencyMeasurementMaxAge || measurement.PacketLossPercent >= 100 {
+			return 0, false
+		}
+		return measurement.RTTMillis, true
+	}
+	return 0, false
+}
+
+// offlineTimeout returns how long the node may miss heartbeats before it is offline
+func (node *EdgeNode) offlineTimeout() time.Duration {
+	if node.LinkQuality == LinkQualityPoor {
+		return PoorLinkOfflineTimeout
+	}
+	return NodeOfflineTimeout
+}
+
+// linkMetricName names the stored series of one link metric to one target
+func linkMetricName(metric, target string) string {
+	return "link_" + metric + ":" + target
+}
+
+// linkMetricSamples returns the node's recent link measurements as raw metric samples
+func linkMetricSamples(node *EdgeNode, now time.Time) []MetricSample {
+	var samples []MetricSample
+	for _, measurement := range node.Latency {
+		if now.Sub(measurement.MeasuredAt) > LatencyMeasurementMaxAge {
+			continue
+		}
+		samples = append(samples,
+			MetricSample{Class: MetricClassNode, Name: linkMetricName("packet_loss_percent", measurement.Target), EntityID: node.ID, Value: measurement.PacketLossPercent},
+			MetricSample{Class: MetricClassNode, Name: linkMetricName("jitter_ms", measurement.Target), EntityID: node.ID, Value: measurement.JitterMillis})
+		// A target that could not be reached has no round-trip time
+		if measurement.PacketLossPercent < 100 {
+			samples = append(samples,
+				MetricSample{Class: MetricClassNode, Name: linkMetricName("rtt_ms", measurement.Target), EntityID: node.ID, Value: measurement.RTTMillis})
+		}
+	}
+	return samples
+}
+
+// gradeLinks grades every node's link to the orchestrator from the stored packet loss and
+// jitter of the last LinkQualityWindow
+func (co *CentralOrchestrator) gradeLinks(now time.Time) {
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	for _, node := range co.NodeManager.nodes {
+		quality := co.linkQuality(node.ID, now)
+		if quality == node.LinkQuality {
+			continue
+		}
+		if quality == LinkQualityPoor {
+			co.Logger.Warnf("Link quality of node %s (%s) is poor", node.Name, node.ID)
+		} else if node.LinkQuality != "" {
+			co.Logger.Infof("Link quality of node %s (%s) changed from %s to %s", node.Name, node.ID, node.LinkQuality, quality)
+		}
+		node.LinkQuality = quality
+	}
+}
+
+// linkQuality grades one node's link to the orchestrator
+func (co *CentralOrchestrator) linkQuality(nodeID string, now time.Time) LinkQuality {
+	average := func(metric string) (float64, bool) {
+		points, _, err := co.MetricsStore.History(MetricClassNode, linkMetricName(metric, LatencyTargetOrchestrator), nodeID, "raw", now.Add(-LinkQualityWindow), now)
+		if err != nil || len(points) < LinkQualityMinSamples {
+			return 0, false
+		}
+		var sum float64
+		for _, point := range points {
+			sum += point.Avg
+		}
+		return sum / float64(len(points)), true
+	}
+
+	packetLoss, known := average("packet_loss_percent")
+	if !known {
+		return LinkQualityUnknown
+	}
+	jitter, known := average("jitter_ms")
+	if !known {
+		return LinkQualityUnknown
+	}
+	for _, threshold := range linkQualityThresholds {
+		if packetLoss <= threshold.packetLossPercent && jitter <= threshold.jitterMillis {
+			return threshold.quality
+		}
+	}
+	return LinkQualityPoor
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Lease the orchestrator replicas compete for when LEADER_ELECTION_LEASE_NAME is unset
+	DefaultLeaderLeaseName = "edge-orchestrator"
+
+	// How often a follower looks up the current leader
+	LeaderObserveInterval = 5 * time.Second
+
+	// Replication lag beyond which a follower or read replica reports itself stale when
+	// REPLICA_MAX_LAG is unset
+	DefaultReplicaMaxLag = time.Minute
+
+	// Headers a follower uses to pass a caller's client certificate on to the leader
+	HeaderForwardedClientCert = "X-Forwarded-Client-Cert"
+	HeaderReplicaToken        = "X-Orchestrator-Replica-Token"
+)
+
+// LeaderElector is implemented by backends orchestrator replicas elect a leader through,
+// such as etcd or a Kubernetes Lease. Candidates are identified by the URL other
+// replicas reach them at.
+type LeaderElector interface {
+	// Campaign blocks until the candidate is leader and returns a channel closed when
+	// leadership is lost
+	Campaign(ctx context.Context, candidate string) (<-chan struct{}, error)
+	// Leader returns the current leader, or "" when there is none
+	Leader(ctx context.Context) (string, error)
+}
+
+// LeaderElection decides which orchestrator replica runs the schedulers and
+// controllers. Every replica serves the API: followers answer reads from state they
+// refresh from the shared store and forward everything else to the leader.
+//
+// A read replica is a follower that never leads. It serves reads in another region
+// from a replicated store and forwards writes to a fixed primary.
+type LeaderElection struct {
+	// nil when running a single replica, which always leads, or a read replica
+	elector     LeaderElector
+	readReplica bool
+	// Lag beyond which state served by a follower counts as stale
+	maxLag time.Duration
+	// URL other replicas forward requests to
+	identity string
+	leader   string
+	leading  bool
+	// Verifies the leader's server certificate when forwarding
+	transport    *http.Transport
+	replicaToken string
+	mutex        sync.RWMutex
+	logger       *logrus.Logger
+}
+
+// NewLeaderElection configures leader election from LEADER_ELECTION: "kubernetes" uses
+// a Lease named LEADER_ELECTION_LEASE_NAME, "etcd" uses the etcd state store, and
+// "none" runs a single replica. Unset, it elects through the state store when the
+// store supports it. ORCHESTRATOR_PRIMARY_URL instead makes this a read replica of
+// that primary.
+func NewLeaderElection(logger *logrus.Logger, store Store) (*LeaderElection, error) {
+	le := &LeaderElection{
+		identity:     os.Getenv("LEADER_ELECTION_ADVERTISE_URL"),
+		maxLag:       DefaultReplicaMaxLag,
+		replicaToken: os.Getenv("LEADER_ELECTION_REPLICA_TOKEN"),
+		logger:       logger,
+	}
+	if value := os.Getenv("REPLICA_MAX_LAG"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid REPLICA_MAX_LAG %q", value)
+		}
+		le.maxLag = parsed
+	}
+	if le.identity == "" {
+		hostname, _ := os.Hostname()
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = DefaultPort
+		}
+		le.identity = "https://" + hostname + ":" + port
+	}
+
+	storeElector, storeElects := store.(LeaderElector)
+	mode := os.Getenv("LEADER_ELECTION")
+	primary := os.Getenv("ORCHESTRATOR_PRIMARY_URL")
+	if primary != "" {
+		// Read replicas follow the primary and take no part in elections
+		le.readReplica = true
+		le.leader = primary
+		mode = "none"
+	}
+	switch mode {
+	case "":
+		if storeElects {
+			le.elector = storeElector
+		}
+	case "none":
+	case "etcd":
+		if !storeElects {
+			return nil, fmt.Errorf("etcd leader election requires the etcd storage backend")
+		}
+		le.elector = storeElector
+	case "kubernetes":
+		name := os.Getenv("LEADER_ELECTION_LEASE_NAME")
+		if name == "" {
+			name = DefaultLeaderLeaseName
+		}
+		elector, err := newKubernetesElector(os.Getenv("LEADER_ELECTION_NAMESPACE"), name, le.identity)
+		if err != nil {
+			return nil, err
+		}
+		le.elector = elector
+	default:
+		return nil, fmt.Errorf("unknown leader election mode %q", mode)
+	}
+
+	if le.elector == nil && !le.readReplica {
+		le.leading = true
+		le.leader = le.identity
+		return le, nil
+	}
+
+	// Followers serve state the leader wrote, so replicas must share the store
+	if backend := store.Backend(); backend != "postgres" && backend != "etcd" {
+		return nil, fmt.Errorf("leader election requires a shared storage backend, not %s", backend)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv("LEADER_ELECTION_CA_FILE"); caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read leader CA: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	le.transport = &http.Transport{TLSClientConfig: tlsConfig}
+
+	if le.readReplica {
+		logger.Infof("Serving as a read replica of %s", primary)
+	} else {
+		logger.Infof("Electing a leader among orchestrator replicas as %s", le.identity)
+	}
+	return le, nil
+}
+
+// IsLeader reports whether this replica runs the schedulers and controllers
+func (le *LeaderElection) IsLeader() bool {
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+	return le.leading
+}
+
+// runLeaderElection campaigns for leadership while tracking the current leader, then
+// starts the leader's background services. Those services cannot be handed over while
+// running, so a replica that loses leadership exits and rejoins as a follower.
+func (co *CentralOrchestrator) runLeaderElection() {
+	le := co.LeaderElection
+
+	// Every replica syncs state: the leader writes it and followers read it back
+	go co.stateSyncLoop()
+
+	if le.readReplica {
+		return
+	}
+	if le.elector == nil {
+		co.StartBackgroundServices()
+		return
+	}
+
+	ctx, stopObserving := context.WithCancel(context.Background())
+	go le.observeLeader(ctx)
+
+	lost, err := le.elector.Campaign(context.Background(), le.identity)
+	stopObserving()
+	if err != nil {
+		co.Logger.Fatalf("Failed to campaign for leadership: %v", err)
+	}
+
+	// Pick up what the previous leader wrote before taking over
+	if err := co.refreshState(); err != nil {
+		co.Logger.Fatalf("Failed to load state on becoming leader: %v", err)
+	}
+	le.mutex.Lock()
+	le.leading = true
+	le.leader = le.identity
+	le.mutex.Unlock()
+	co.Logger.Infof("Elected leader as %s", le.identity)
+
+	co.StartBackgroundServices()
+
+	<-lost
+	co.Logger.Fatal("Lost leadership, exiting")
+}
+
+// observeLeader keeps track of the leader followers forward requests to
+func (le *LeaderElection) observeLeader(ctx context.Context) {
+	ticker := time.NewTicker(LeaderObserveInterval)
+	defer ticker.Stop()
+
+	for {
+		lookup, cancel := context.WithTimeout(ctx, StorageTimeout)
+		leader, err := le.elector.Leader(lookup)
+		cancel()
+		if err != nil {
+			le.logger.Warnf("Failed to look up the leader: %v", err)
+		} else {
+			le.mutex.Lock()
+			if leader != le.leader {
+				le.logger.Infof("Orchestrator leader is now %q", leader)
+			}
+			le.leader = leader
+			le.mutex.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ForwardMiddleware sends requests that change state from a follower to the leader,
+// which authenticates them. Reads are answered locally; a client certificate the caller
+// presented is passed on with the request.
+func (co *CentralOrchestrator) ForwardMiddleware() gin.HandlerFunc {
+	le := co.LeaderElection
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		le.mutex.RLock()
+		leading, leader := le.leading, le.leader
+		le.mutex.RUnlock()
+		if leading {
+			c.Next()
+			return
+		}
+		if leader == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No orchestrator leader is elected"})
+			c.Abort()
+			return
+		}
+
+		target, err := url.Parse(leader)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Invalid leader address %q", leader)})
+			c.Abort()
+			return
+		}
+
+		c.Request.Header.Del(HeaderForwardedClientCert)
+		c.Request.Header.Del(HeaderReplicaToken)
+		if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+			if le.replicaToken == "" {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Client certificates cannot be forwarded to the leader"})
+				c.Abort()
+				return
+			}
+			c.Request.Header.Set(HeaderForwardedClientCert, base64.StdEncoding.EncodeToString(c.Request.TLS.PeerCertificates[0].Raw))
+			c.Request.Header.Set(HeaderReplicaToken, le.replicaToken)
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = le.transport
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			co.Logger.Warnf("Failed to forward %s %s to leader %s: %v", r.Method, r.URL.Path, leader, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"error":"Failed to reach the orchestrator leader"}`))
+		}
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// forwardedClientCertificate returns the client certificate a follower forwarded with
+// a request, or nil when the request was not forwarded by a replica
+func (sm *SecurityManager) forwardedClientCertificate(c *gin.Context) *x509.Certificate {
+	encoded := c.GetHeader(HeaderForwardedClientCert)
+	token := c.GetHeader(HeaderReplicaToken)
+	if encoded == "" || sm.replicaToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(sm.replicaToken)) != 1 {
+		return nil
+	}
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// role names what this replica does: leader, follower or read-replica
+func (le *LeaderElection) role() string {
+	switch {
+	case le.readReplica:
+		return "read-replica"
+	case le.leading:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// LeaderStatus reports which replica leads
+type LeaderStatus struct {
+	Identity string `json:"identity"`
+	Role     string `json:"role"`
+	Leader   string `json:"leader"`
+	Leading  bool   `json:"leading"`
+	Elected  bool   `json:"elected"`
+}
+
+// GetLeaderStatus reports whether this replica leads and which replica does
+func (co *CentralOrchestrator) GetLeaderStatus(c *gin.Context) {
+	le := co.LeaderElection
+	le.mutex.RLock()
+	status := LeaderStatus{
+		Identity: le.identity,
+		Role:     le.role(),
+		Leader:   le.leader,
+		Leading:  le.leading,
+		Elected:  le.elector != nil,
+	}
+	le.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"leader": status})
+}
+
+// ReplicationStatus reports how far the state a follower serves is behind the leader
+type ReplicationStatus struct {
+	Role   string `json:"role"`
+	Leader string `json:"leader"`
+	// When the state was last read from the store, and when the leader had last
+	// written it at that point
+	LastRefresh    *time.Time `json:"last_refresh,omitempty"`
+	LeaderSyncedAt *time.Time `json:"leader_synced_at,omitempty"`
+	// Age of the served state; unknown until the leader has synced once
+	LagSeconds *float64 `json:"lag_seconds,omitempty"`
+	// Whether the lag exceeds REPLICA_MAX_LAG or is unknown
+	Stale bool `json:"stale"`
+}
+
+// replicationStatus returns nil on the leader, which serves its own state
+func (co *CentralOrchestrator) replicationStatus(now time.Time) *ReplicationStatus {
+	le := co.LeaderElection
+	le.mutex.RLock()
+	if le.leading {
+		le.mutex.RUnlock()
+		return nil
+	}
+	status := &ReplicationStatus{Role: le.role(), Leader: le.leader}
+	maxLag := le.maxLag
+	le.mutex.RUnlock()
+
+	ss := co.StateStore
+	ss.mutex.RLock()
+	lastRefresh, leaderSyncedAt := ss.lastRefresh, ss.leaderSyncedAt
+	ss.mutex.RUnlock()
+
+	if !lastRefresh.IsZero() {
+		status.LastRefresh = &lastRefresh
+	}
+	if leaderSyncedAt.IsZero() {
+		status.Stale = true
+		return status
+	}
+	status.LeaderSyncedAt = &leaderSyncedAt
+	lag := now.Sub(leaderSyncedAt).Seconds()
+	status.LagSeconds = &lag
+	status.Stale = now.Sub(leaderSyncedAt) > maxLag
+	return status
+}
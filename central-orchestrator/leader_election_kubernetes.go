@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// Namespace the pod runs in, mounted with its service account
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// Lease timings; a standby takes over within LeaderLeaseDuration of the leader failing
+	LeaderLeaseDuration = 15 * time.Second
+	LeaderRenewDeadline = 10 * time.Second
+	LeaderRetryPeriod   = 2 * time.Second
+)
+
+// kubernetesElector elects a leader through a coordination.k8s.io Lease, for replicas
+// running in the cluster that do not share an etcd store
+type kubernetesElector struct {
+	elector *leaderelection.LeaderElector
+	started chan struct{}
+	stopped chan struct{}
+}
+
+// newKubernetesElector competes for the named Lease as identity. The namespace defaults
+// to the pod's own.
+func newKubernetesElector(namespace, name, identity string) (*kubernetesElector, error) {
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("LEADER_ELECTION_NAMESPACE is required outside a pod: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	ke := &kubernetesElector{
+		started: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	ke.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   LeaderLeaseDuration,
+		RenewDeadline:   LeaderRenewDeadline,
+		RetryPeriod:     LeaderRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) { close(ke.started) },
+			OnStoppedLeading: func() { close(ke.stopped) },
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure leader election: %v", err)
+	}
+	return ke, nil
+}
+
+// Campaign runs the elector until it leads. The candidate is fixed when the elector is
+// created, and a Campaign can only be run once.
+func (ke *kubernetesElector) Campaign(ctx context.Context, _ string) (<-chan struct{}, error) {
+	go ke.elector.Run(ctx)
+
+	select {
+	case <-ke.started:
+		return ke.stopped, nil
+	case <-ke.stopped:
+		return nil, fmt.Errorf("leader election stopped before this replica led")
+	}
+}
+
+// Leader returns the holder of the Lease as last observed
+func (ke *kubernetesElector) Leader(_ context.Context) (string, error) {
+	return ke.elector.GetLeader(), nil
+}
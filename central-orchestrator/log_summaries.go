@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Fired while a workload logs errors far above its usual rate
+	WorkloadLogErrorSpikeAlert = "WorkloadLogErrorSpike"
+
+	// Summaries older than this no longer describe a workload's logs; agents analyze them
+	// every minute
+	LogSummaryMaxAge = 3 * time.Minute
+
+	// A workload's error rate is compared with its average over this window, leaving out
+	// the latest minutes so a spike does not raise its own baseline
+	LogErrorBaselineWindow = time.Hour
+	LogErrorBaselineGap    = 5 * time.Minute
+
+	// Errors per minute below this never count as a spike
+	LogErrorSpikeMinRate = 10
+	// How many times its baseline a workload's error rate must reach to spike
+	LogErrorSpikeFactor = 5
+)
+
+// LogSample is a message that recurred in a workload's logs
+type LogSample struct {
+	// "error" or "warning"
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	// Lines that differ from message only in numbers and IDs
+	Count int64 `json:"count"`
+}
+
+// WorkloadLogSummary is an agent's analysis of one workload's logs over a window, reported
+// with its heartbeats in place of the logs themselves
+type WorkloadLogSummary struct {
+	WorkloadID    string      `json:"workload_id"`
+	WindowSeconds int64       `json:"window_seconds"`
+	Lines         int64       `json:"lines"`
+	Errors        int64       `json:"errors"`
+	Warnings      int64       `json:"warnings"`
+	Samples       []LogSample `json:"samples,omitempty"`
+	CollectedAt   time.Time   `json:"collected_at"`
+}
+
+// perMinute converts a count over the summary's window to a rate
+func (s *WorkloadLogSummary) perMinute(count int64) float64 {
+	if s.WindowSeconds <= 0 {
+		return 0
+	}
+	return float64(count) * 60 / float64(s.WindowSeconds)
+}
+
+// WorkloadLogRates are a workload's log rates across its nodes
+type WorkloadLogRates struct {
+	ErrorsPerMinute   float64     `json:"errors_per_minute"`
+	WarningsPerMinute float64     `json:"warnings_per_minute"`
+	Samples           []LogSample `json:"samples"`
+}
+
+// LogSummaryStore keeps the latest log summary of each workload on each node
+type LogSummaryStore struct {
+	// Workload ID to node ID to summary
+	summaries map[string]map[string]*WorkloadLogSummary
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewLogSummaryStore creates a new log summary store
+func NewLogSummaryStore(logger *logrus.Logger) *LogSummaryStore {
+	return &LogSummaryStore{
+		summaries: make(map[string]map[string]*WorkloadLogSummary),
+		logger:    logger,
+	}
+}
+
+// Record keeps the summaries a node reported. Heartbeats repeat a summary until the agent
+// analyzes its logs again, so older summaries never replace newer ones.
+func (ls *LogSummaryStore) Record(nodeID string, summaries []WorkloadLogSummary) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	for i := range summaries {
+		summary := summaries[i]
+		nodes, exists := ls.summaries[summary.WorkloadID]
+		if !exists {
+			nodes = make(map[string]*WorkloadLogSummary)
+			ls.summaries[summary.WorkloadID] = nodes
+		}
+		if current, exists := nodes[nodeID]; exists && !summary.CollectedAt.After(current.CollectedAt) {
+			continue
+		}
+		nodes[nodeID] = &summary
+	}
+}
+
+// Rates sums the recent summaries of every workload across its nodes, dropping the stale
+// ones. Samples of the same message on several nodes are merged.
+func (ls *LogSummaryStore) Rates(now time.Time) map[string]*WorkloadLogRates {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	rates := make(map[string]*WorkloadLogRates)
+	for workloadID, nodes := range ls.summaries {
+		samples := make(map[string]*LogSample)
+		for nodeID, summary := range nodes {
+			if now.Sub(summary.CollectedAt) > LogSummaryMaxAge {
+				delete(nodes, nodeID)
+				continue
+			}
+			rate, exists := rates[workloadID]
+			if !exists {
+				rate = &WorkloadLogRates{}
+				rates[workloadID] = rate
+			}
+			rate.ErrorsPerMinute += summary.perMinute(summary.Errors)
+			rate.WarningsPerMinute += summary.perMinute(summary.Warnings)
+			for _, sample := range summary.Samples {
+				key := sample.Level + " " + sample.Message
+				if merged, exists := samples[key]; exists {
+					merged.Count += sample.Count
+				} else {
+					copied := sample
+					samples[key] = &copied
+				}
+			}
+		}
+		if len(nodes) == 0 {
+			delete(ls.summaries, workloadID)
+			continue
+		}
+
+		rate := rates[workloadID]
+		for _, sample := range samples {
+			rate.Samples = append(rate.Samples, *sample)
+		}
+		sort.Slice(rate.Samples, func(i, j int) bool {
+			a, b := rate.Samples[i], rate.Samples[j]
+			if a.Level != b.Level {
+				return a.Level == "error"
+			}
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Message < b.Message
+		})
+	}
+	return rates
+}
+
+// nodeSummaries returns copies of the latest summaries of a workload by node
+func (ls *LogSummaryStore) nodeSummaries(workloadID string) map[string]WorkloadLogSummary {
+	ls.mutex.RLock()
+	defer ls.mutex.RUnlock()
+
+	result := make(map[string]WorkloadLogSummary, len(ls.summaries[workloadID]))
+	for nodeID, summary := range ls.summaries[workloadID] {
+		result[nodeID] = *summary
+	}
+	return result
+}
+
+// logMetricSamples returns each workload's error and warning rates as raw metric samples
+func (co *CentralOrchestrator) logMetricSamples(now time.Time) []MetricSample {
+	var samples []MetricSample
+	for workloadID, rate := range co.LogSummaryStore.Rates(now) {
+		samples = append(samples,
+			MetricSample{Class: MetricClassWorkload, Name: "log_errors_per_minute", EntityID: workloadID, Value: rate.ErrorsPerMinute},
+			MetricSample{Class: MetricClassWorkload, Name: "log_warnings_per_minute", EntityID: workloadID, Value: rate.WarningsPerMinute})
+	}
+	return samples
+}
+
+// checkLogErrorSpikes fires the error spike alert of every workload logging errors at
+// LogErrorSpikeFactor times its usual rate or more, and resolves it once the rate drops
+func (co *CentralOrchestrator) checkLogErrorSpikes(now time.Time) {
+	rates := co.LogSummaryStore.Rates(now)
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	for _, workload := range co.WorkloadManager.workloads {
+		rate, reported := rates[workload.ID]
+		if !reported {
+			co.AlertManager.Resolve(WorkloadLogErrorSpikeAlert, AlertScopeWorkload, workload.ID)
+			continue
+		}
+
+		baseline := co.logErrorBaseline(workload.ID, now)
+		if rate.ErrorsPerMinute < LogErrorSpikeMinRate || rate.ErrorsPerMinute < LogErrorSpikeFactor*baseline {
+			co.AlertManager.Resolve(WorkloadLogErrorSpikeAlert, AlertScopeWorkload, workload.ID)
+			continue
+		}
+
+		sample := ""
+		if len(rate.Samples) > 0 && rate.Samples[0].Level == "error" {
+			sample = rate.Samples[0].Message
+		}
+		co.AlertManager.Fire(WorkloadLogErrorSpikeAlert, AlertSeverityWarning, AlertScopeWorkload, workload.ID, "",
+			newMessage(MsgWorkloadLogErrorSpike, "workload", workload.Name, "rate", fmt.Sprintf("%.1f", rate.ErrorsPerMinute),
+				"baseline", fmt.Sprintf("%.1f", baseline), "sample", sample))
+	}
+}
+
+// logErrorBaseline returns a workload's average errors per minute over the baseline window,
+// 0 without history
+func (co *CentralOrchestrator) logErrorBaseline(workloadID string, now time.Time) float64 {
+	points, _, err := co.MetricsStore.History(MetricClassWorkload, "log_errors_per_minute", workloadID, "auto",
+		now.Add(-LogErrorBaselineWindow), now.Add(-LogErrorBaselineGap))
+	if err != nil || len(points) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, point := range points {
+		sum += point.Avg
+	}
+	return sum / float64(len(points))
+}
+
+// GetWorkloadLogSummary returns a workload's log rates with its most frequent errors and
+// warnings, and the latest summary from each node
+func (co *CentralOrchestrator) GetWorkloadLogSummary(c *gin.Context) {
+	workloadID := c.Param("id")
+
+	co.WorkloadManager.mutex.RLock()
+	_, exists := co.WorkloadManager.workloads[workloadID]
+	co.WorkloadManager.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	now := time.Now()
+	rate, reported := co.LogSummaryStore.Rates(now)[workloadID]
+	if !reported {
+		rate = &WorkloadLogRates{Samples: []LogSample{}}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"workload_id":                workloadID,
+		"errors_per_minute":          rate.ErrorsPerMinute,
+		"warnings_per_minute":        rate.WarningsPerMinute,
+		"baseline_errors_per_minute": co.logErrorBaseline(workloadID, now),
+		"samples":                    rate.Samples,
+		"nodes":                      co.LogSummaryStore.nodeSummaries(workloadID),
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultLogMaxSizeMB  = 50
+	DefaultLogMaxBackups = 3
+)
+
+// LogConfig controls logger level, format and output
+type LogConfig struct {
+	Level      string
+	Format     string
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// LogLevelRequest changes the log level at runtime
+type LogLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// logConfigFromEnv reads LOG_LEVEL, LOG_FORMAT, LOG_FILE, LOG_MAX_SIZE_MB and LOG_MAX_BACKUPS
+func logConfigFromEnv() LogConfig {
+	config := LogConfig{
+		Level:      os.Getenv("LOG_LEVEL"),
+		Format:     os.Getenv("LOG_FORMAT"),
+		File:       os.Getenv("LOG_FILE"),
+		MaxSizeMB:  DefaultLogMaxSizeMB,
+		MaxBackups: DefaultLogMaxBackups,
+	}
+	if size, err := strconv.Atoi(os.Getenv("LOG_MAX_SIZE_MB")); err == nil && size > 0 {
+		config.MaxSizeMB = size
+	}
+	if backups, err := strconv.Atoi(os.Getenv("LOG_MAX_BACKUPS")); err == nil && backups >= 0 {
+		config.MaxBackups = backups
+	}
+	return config
+}
+
+// configureLogger applies a LogConfig to the logger
+func configureLogger(logger *logrus.Logger, config LogConfig) error {
+	level := logrus.InfoLevel
+	if config.Level != "" {
+		parsed, err := logrus.ParseLevel(config.Level)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q: %v", config.Level, err)
+		}
+		level = parsed
+	}
+	logger.SetLevel(level)
+
+	switch config.Format {
+	case "", "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("invalid log format %q, expected json or text", config.Format)
+	}
+
+	if config.File != "" {
+		writer, err := newRotatingFile(config.File, int64(config.MaxSizeMB)*1024*1024, config.MaxBackups)
+		if err != nil {
+			return err
+		}
+		logger.SetOutput(writer)
+	}
+
+	return nil
+}
+
+// watchLogLevelSignal toggles debug logging on SIGUSR1, restoring the previous level on the next signal
+func watchLogLevelSignal(logger *logrus.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	previous := logger.GetLevel()
+	for range signals {
+		if logger.GetLevel() == logrus.DebugLevel {
+			logger.SetLevel(previous)
+		} else {
+			previous = logger.GetLevel()
+			logger.SetLevel(logrus.DebugLevel)
+		}
+		logger.Warnf("Log level changed to %s by SIGUSR1", logger.GetLevel())
+	}
+}
+
+// rotatingFile is a log file that is rotated once it exceeds maxSize, keeping maxBackups
+// old files as path.1 (newest) through path.N
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %v", err)
+	}
+
+	if rf.maxBackups == 0 {
+		os.Remove(rf.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		os.Rename(rf.path, rf.path+".1")
+	}
+
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// GetLogLevel returns the current log level
+func (co *CentralOrchestrator) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": co.Logger.GetLevel().String()})
+}
+
+// SetLogLevel changes the log level at runtime
+func (co *CentralOrchestrator) SetLogLevel(c *gin.Context) {
+	var req LogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.Logger.SetLevel(level)
+	co.Logger.Warnf("Log level changed to %s via API", level)
+
+	c.JSON(http.StatusOK, gin.H{"level": level.String()})
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -21,10 +22,17 @@ const (
 )
 
 func main() {
+	// Offline subcommands run without starting the orchestrator
+	if len(os.Args) > 1 && os.Args[1] != "run" {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Initialize logger
 	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.InfoLevel)
+	if err := configureLogger(logger, logConfigFromEnv()); err != nil {
+		logger.Fatalf("Failed to configure logging: %v", err)
+	}
+	go watchLogLevelSignal(logger)
 
 	logger.Info("Starting Kubernetes Edge Computing Central Orchestrator")
 
@@ -33,14 +41,130 @@ func main() {
 	workloadManager := NewWorkloadManager(logger)
 	securityManager := NewSecurityManager(logger)
 	monitoringService := NewMonitoringService(logger)
+	metricsStore := NewMetricsStore(logger)
+	dnsManager := NewDNSManager(logger)
+	siteManager := NewSiteManager(logger)
+	alertManager := NewAlertManager(logger)
+	operationManager := NewOperationManager(logger)
+	cloudProvisioner := NewCloudProvisioner(logger)
+	migrationManager := NewMigrationManager(logger)
+	snapshotManager := NewSnapshotManager(logger)
+	uptimeTracker := NewUptimeTracker(logger)
+	nodeStateManager := NewNodeStateManager(logger)
+	messageCatalog := NewMessageCatalog(logger)
+	reservationManager := NewReservationManager(logger)
+	summaryCache := NewSummaryCache(logger)
+	logSummaryStore := NewLogSummaryStore(logger)
+	revisionHistory := NewWorkloadRevisionHistory(logger)
+	registryWebhooks := NewRegistryWebhooks(logger)
+	chatOps := NewChatOps(logger)
+	incidentSync := NewIncidentSync(logger)
+	billingManager := NewBillingManager(logger)
+	consistencyChecker := NewConsistencyChecker(logger)
+	silenceManager := NewSilenceManager(logger)
+	siteGatewayManager := NewSiteGatewayManager(logger)
+	udpHeartbeatServer := NewUDPHeartbeatServer(logger)
+	desiredStateCache := NewDesiredStateCache(logger)
+	placementReevaluator := NewPlacementReevaluator(logger)
+	tenantScheduler := NewTenantScheduler(logger)
+	namespaceManager := NewNamespaceManager(logger)
+	organizationManager := NewOrganizationManager(logger)
+	scheduler := NewScheduler(logger)
+	fleetSnapshots := NewFleetSnapshotManager(logger)
+	commandManager := NewCommandManager(logger)
+	campaignManager := NewCampaignManager(logger)
+	patchManager := NewPatchManager(logger)
+	auditLog := NewAuditLog(logger)
+	tunnelBroker := NewTunnelBroker(logger)
+	acmeServer := NewACMEServer(logger)
+	loadForecaster := NewLoadForecaster(logger)
+	federatedJobManager := NewFederatedJobManager(logger)
+	functionManager := NewFunctionManager(logger)
+	datasetCatalog := NewDatasetCatalog(logger)
+	offloadManager := NewOffloadManager(logger)
+	tsdbManager := NewTSDBManager(logger)
+	imageBuilder := NewImageBuilder(logger)
+	claimManager := NewClaimManager(logger)
+	replacementManager := NewReplacementManager(logger)
+	agentStreamHub := NewAgentStreamHub(logger)
+	importedClusters := NewImportedClusterManager(logger)
+	interop := NewInteropManager(logger)
+	ocmHubs := NewOCMHubManager(logger)
+	stateStore, err := NewStateStore(logger)
+	if err != nil {
+		logger.Fatalf("Failed to open state store: %v", err)
+	}
+	leaderElection, err := NewLeaderElection(logger, stateStore.store)
+	if err != nil {
+		logger.Fatalf("Failed to configure leader election: %v", err)
+	}
 
 	// Initialize orchestrator
 	orchestrator := &CentralOrchestrator{
-		NodeManager:        nodeManager,
-		WorkloadManager:    workloadManager,
-		SecurityManager:    securityManager,
-		MonitoringService:  monitoringService,
-		Logger:             logger,
+		NodeManager:          nodeManager,
+		WorkloadManager:      workloadManager,
+		SecurityManager:      securityManager,
+		MonitoringService:    monitoringService,
+		MetricsStore:         metricsStore,
+		DNSManager:           dnsManager,
+		SiteManager:          siteManager,
+		AlertManager:         alertManager,
+		OperationManager:     operationManager,
+		CloudProvisioner:     cloudProvisioner,
+		MigrationManager:     migrationManager,
+		SnapshotManager:      snapshotManager,
+		UptimeTracker:        uptimeTracker,
+		NodeStateManager:     nodeStateManager,
+		MessageCatalog:       messageCatalog,
+		ReservationManager:   reservationManager,
+		SummaryCache:         summaryCache,
+		LogSummaryStore:      logSummaryStore,
+		RevisionHistory:      revisionHistory,
+		RegistryWebhooks:     registryWebhooks,
+		ChatOps:              chatOps,
+		IncidentSync:         incidentSync,
+		BillingManager:       billingManager,
+		ConsistencyChecker:   consistencyChecker,
+		SilenceManager:       silenceManager,
+		SiteGatewayManager:   siteGatewayManager,
+		UDPHeartbeatServer:   udpHeartbeatServer,
+		DesiredStateCache:    desiredStateCache,
+		PlacementReevaluator: placementReevaluator,
+		TenantScheduler:      tenantScheduler,
+		NamespaceManager:     namespaceManager,
+		OrganizationManager:  organizationManager,
+		Scheduler:            scheduler,
+		FleetSnapshots:       fleetSnapshots,
+		CommandManager:       commandManager,
+		CampaignManager:      campaignManager,
+		PatchManager:         patchManager,
+		AuditLog:             auditLog,
+		TunnelBroker:         tunnelBroker,
+		ACMEServer:           acmeServer,
+		LoadForecaster:       loadForecaster,
+		FederatedJobManager:  federatedJobManager,
+		FunctionManager:      functionManager,
+		DatasetCatalog:       datasetCatalog,
+		OffloadManager:       offloadManager,
+		TSDBManager:          tsdbManager,
+		StateStore:           stateStore,
+		ImageBuilder:         imageBuilder,
+		ClaimManager:         claimManager,
+		ReplacementManager:   replacementManager,
+		AgentStreamHub:       agentStreamHub,
+		ImportedClusters:     importedClusters,
+		Interop:              interop,
+		OCMHubs:              ocmHubs,
+		LeaderElection:       leaderElection,
+		Logger:               logger,
+	}
+	migrationManager.RegisterVolumeHook(&snapshotVolumeHook{co: orchestrator})
+	securityManager.lookupDevice = orchestrator.lookupDeviceCredential
+	securityManager.expandTenants = organizationManager.expandTenants
+
+	// Restore persisted state before serving requests
+	if err := orchestrator.restoreState(); err != nil {
+		logger.Fatalf("Failed to restore state: %v", err)
 	}
 
 	// Setup HTTP router
@@ -49,6 +173,9 @@ func main() {
 	// Configure TLS
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
+		// Client certificates are optional at the TLS layer and verified
+		// against issued certificates in AuthMiddleware
+		ClientAuth: tls.RequestClientCert,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
@@ -79,8 +206,25 @@ func main() {
 		}
 	}()
 
-	// Start background services
-	go orchestrator.StartBackgroundServices()
+	// Serve the agent gRPC API alongside the REST API when GRPC_PORT is set
+	var grpcServer *grpc.Server
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		var err error
+		grpcServer, err = newAgentGRPCServer(orchestrator, router, tlsConfig)
+		if err != nil {
+			logger.Fatalf("Failed to create gRPC server: %v", err)
+		}
+		go serveAgentGRPC(grpcServer, grpcPort, orchestrator)
+	}
+
+	// Start background services once this replica leads
+	go orchestrator.runLeaderElection()
+
+	// Push workload commands to agents streaming from this replica; every replica serves streams
+	go orchestrator.pushAgentStreams()
+
+	// Pick up rotated JWT keys; every replica verifies tokens itself
+	go orchestrator.SecurityManager.watchJWTKeys()
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -96,6 +240,17 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+
+	// Write changes made since the last sync; followers never write
+	if orchestrator.LeaderElection.IsLeader() {
+		if err := orchestrator.persistState(); err != nil {
+			logger.Errorf("Failed to persist state: %v", err)
+		}
+	}
+	stateStore.store.Close()
 
 	logger.Info("Server exited")
 }
@@ -104,25 +259,158 @@ func setupRouter(orchestrator *CentralOrchestrator) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.Use(gin.Recovery())
+	// Followers hand writes to the leader, which authenticates them itself
+	router.Use(orchestrator.ForwardMiddleware())
 	router.Use(orchestrator.SecurityManager.AuthMiddleware())
+	// Admins may act as another user within that user's role bindings
+	router.Use(orchestrator.SecurityManager.ImpersonationMiddleware())
+	router.Use(orchestrator.AuthorizeMiddleware())
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
+		now := time.Now()
+		response := gin.H{
 			"status": "healthy",
-			"timestamp": time.Now(),
-		})
+			"timestamp": now,
+		}
+		// Followers and read replicas report how far behind the leader they are
+		if replication := orchestrator.replicationStatus(now); replication != nil {
+			response["replication"] = replication
+		}
+		c.JSON(http.StatusOK, response)
 	})
 
+	// ACME certificate issuance for edge clusters
+	if orchestrator.ACMEServer.Enabled() {
+		acme := router.Group("/acme")
+		acme.GET("/directory", orchestrator.GetACMEDirectory)
+		acme.HEAD("/new-nonce", orchestrator.NewACMENonce)
+		acme.GET("/new-nonce", orchestrator.NewACMENonce)
+		acme.POST("/new-account", orchestrator.NewACMEAccount)
+		acme.POST("/account/:id", orchestrator.UpdateACMEAccount)
+		acme.POST("/new-order", orchestrator.NewACMEOrder)
+		acme.POST("/order/:id", orchestrator.GetACMEOrder)
+		acme.POST("/order/:id/finalize", orchestrator.FinalizeACMEOrder)
+		acme.POST("/authz/:id", orchestrator.GetACMEAuthorization)
+		acme.POST("/challenge/:id", orchestrator.RespondACMEChallenge)
+		acme.POST("/certificate/:id", orchestrator.GetACMECertificate)
+		acme.POST("/revoke-cert", orchestrator.RevokeACMECertificate)
+	}
+
+	// Image push webhooks from container registries
+	if orchestrator.RegistryWebhooks.Enabled() {
+		router.POST("/webhooks/registry/:format", orchestrator.ReceiveRegistryWebhook)
+	}
+
+	// Chatops commands from Slack and Teams, run with the role of the mapped user
+	if orchestrator.ChatOps.SlackEnabled() {
+		router.POST("/chatops/slack", orchestrator.ReceiveSlackCommand)
+	}
+	if orchestrator.ChatOps.TeamsEnabled() {
+		router.POST("/chatops/teams", orchestrator.ReceiveTeamsCommand)
+	}
+
+	// Incident acknowledgements and resolutions from PagerDuty and Opsgenie
+	if orchestrator.IncidentSync.WebhookEnabled(IncidentPlatformPagerDuty) || orchestrator.IncidentSync.WebhookEnabled(IncidentPlatformOpsgenie) {
+		router.POST("/webhooks/incidents/:platform", orchestrator.ReceiveIncidentWebhook)
+	}
+
+	// Public keys of the JWT signing keys, for services verifying the orchestrator's tokens
+	router.GET(JWKSPath, orchestrator.GetJWKS)
+
 	// Node management endpoints
 	v1 := router.Group("/api/v1")
 	{
 		// Node registration and management
 		v1.POST("/nodes/register", orchestrator.RegisterNode)
+		v1.POST("/nodes/heartbeats", orchestrator.BatchNodeHeartbeat)
 		v1.GET("/nodes", orchestrator.ListNodes)
 		v1.GET("/nodes/:id", orchestrator.GetNode)
 		v1.DELETE("/nodes/:id", orchestrator.UnregisterNode)
-		v1.POST("/nodes/:id/heartbeat", orchestrator.NodeHeartbeat)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/heartbeat", orchestrator.NodeHeartbeat)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/heartbeat-transport", orchestrator.NegotiateHeartbeatTransport)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/workloads", orchestrator.GetNodeWorkloads)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/desired-state", orchestrator.GetNodeDesiredState)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/assignments", orchestrator.GetNodeAssignments)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/site-gateway", orchestrator.GetNodeSiteGateway)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/stream", orchestrator.StreamAgent)
+		v1.GET("/agent-streams", orchestrator.ListAgentStreams)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/workloads/:wid/endpoints", orchestrator.ReportWorkloadEndpoints)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/workloads/:wid/status", orchestrator.ReportWorkloadStatus)
+		v1.POST("/nodes/:id/state", orchestrator.TransitionNodeState)
+		v1.POST("/nodes/:id/cordon", orchestrator.CordonNode)
+		v1.POST("/nodes/:id/uncordon", orchestrator.UncordonNode)
+		v1.POST("/nodes/:id/drain", orchestrator.DrainNode)
+		v1.PUT("/nodes/:id/attributes", orchestrator.UpdateNodeAttributes)
+		v1.POST("/nodes/:id/replace", orchestrator.ReplaceNode)
+		v1.DELETE("/nodes/:id/replace", orchestrator.CancelNodeReplacement)
+		v1.GET("/replacements", orchestrator.ListNodeReplacements)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/volume-tasks", orchestrator.GetNodeVolumeTasks)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/volume-tasks/:tid/status", orchestrator.ReportVolumeTaskStatus)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/offload-tasks", orchestrator.GetNodeOffloadTasks)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/offload-runs", orchestrator.ReportOffloadRun)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/offload-recalls/:rid/status", orchestrator.ReportOffloadRecallStatus)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/hardware", orchestrator.ReportNodeHardware)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/cloud-metadata", orchestrator.ReportNodeCloudMetadata)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/preemption", orchestrator.ReportNodePreemption)
+		orchestrator.nodeRoute(v1, http.MethodPut, "/nodes/:id/cameras", orchestrator.ReportNodeCameras)
+		v1.GET("/nodes/:id/cameras", orchestrator.GetNodeCameras)
+		orchestrator.nodeRoute(v1, http.MethodPut, "/nodes/:id/datasets", orchestrator.ReportNodeDatasets)
+		v1.GET("/nodes/:id/datasets", orchestrator.GetNodeDatasets)
+		v1.GET("/nodes/:id/gpus", orchestrator.GetNodeGPUs)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/commands", orchestrator.GetNodeCommands)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/commands/:cid/status", orchestrator.ReportNodeCommandStatus)
+		v1.GET("/node-commands/:id", orchestrator.GetNodeCommand)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/tunnel-streams", orchestrator.GetTunnelStreams)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/tunnel-streams/:sid/attach", orchestrator.AttachTunnelStream)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/tunnel-streams/:sid/reject", orchestrator.RejectTunnelStream)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/federated-tasks", orchestrator.GetNodeFederatedTasks)
+		orchestrator.nodeRoute(v1, http.MethodPost, "/nodes/:id/federated-tasks/:job/rounds/:round/status", orchestrator.ReportFederatedTaskStatus)
+		orchestrator.nodeRoute(v1, http.MethodPut, "/nodes/:id/federated-tasks/:job/rounds/:round/update", orchestrator.UploadFederatedUpdate)
+		orchestrator.nodeRoute(v1, http.MethodGet, "/nodes/:id/federated-tasks/:job/rounds/:round/model", orchestrator.GetNodeFederatedModel)
+
+		// Node lifecycle states
+		v1.POST("/node-states", orchestrator.CreateNodeState)
+		v1.GET("/node-states", orchestrator.ListNodeStates)
+		v1.PUT("/node-states/:name", orchestrator.UpdateNodeState)
+		v1.DELETE("/node-states/:name", orchestrator.DeleteNodeState)
+
+		// Site management
+		v1.POST("/sites", orchestrator.CreateSite)
+		v1.GET("/sites", orchestrator.ListSites)
+		v1.GET("/sites/:id", orchestrator.GetSite)
+		v1.DELETE("/sites/:id", orchestrator.DeleteSite)
+		v1.POST("/sites/:id/nodes", orchestrator.AssignSiteNodes)
+		v1.GET("/sites/:id/alerts", orchestrator.GetSiteAlerts)
+		v1.GET("/sites/:id/gateway", orchestrator.GetSiteGateway)
+
+		// Clusters imported as agentless nodes
+		v1.POST("/clusters/import", orchestrator.ImportCluster)
+		v1.GET("/clusters", orchestrator.ListImportedClusters)
+		v1.GET("/clusters/:id", orchestrator.GetImportedCluster)
+		v1.PUT("/clusters/:id/credentials", orchestrator.UpdateClusterCredentials)
+		v1.DELETE("/clusters/:id", orchestrator.RemoveImportedCluster)
+
+		// KubeEdge and Akri installations imported through interop adapters
+		v1.POST("/interop/adapters", orchestrator.CreateInteropAdapter)
+		v1.GET("/interop/adapters", orchestrator.ListInteropAdapters)
+		v1.GET("/interop/adapters/:id", orchestrator.GetInteropAdapter)
+		v1.POST("/interop/adapters/:id/sync", orchestrator.SyncInteropAdapter)
+		v1.DELETE("/interop/adapters/:id", orchestrator.DeleteInteropAdapter)
+
+		// Open Cluster Management hubs whose managed clusters get workloads as ManifestWorks
+		v1.POST("/ocm/hubs", orchestrator.CreateOCMHub)
+		v1.GET("/ocm/hubs", orchestrator.ListOCMHubs)
+		v1.GET("/ocm/hubs/:id", orchestrator.GetOCMHub)
+		v1.POST("/ocm/hubs/:id/sync", orchestrator.SyncOCMHub)
+		v1.DELETE("/ocm/hubs/:id", orchestrator.DeleteOCMHub)
+
+		// Site time-series databases
+		v1.GET("/tsdb", orchestrator.ListSiteTSDBs)
+		v1.PUT("/sites/:id/tsdb", orchestrator.PutSiteTSDB)
+		v1.GET("/sites/:id/tsdb", orchestrator.GetSiteTSDB)
+		v1.DELETE("/sites/:id/tsdb", orchestrator.DeleteSiteTSDB)
+		v1.GET("/sites/:id/tsdb/config", orchestrator.GetSiteTSDBConfig)
 
 		// Workload management
 		v1.POST("/workloads", orchestrator.DeployWorkload)
@@ -130,15 +418,216 @@ func setupRouter(orchestrator *CentralOrchestrator) *gin.Engine {
 		v1.GET("/workloads/:id", orchestrator.GetWorkload)
 		v1.DELETE("/workloads/:id", orchestrator.DeleteWorkload)
 		v1.POST("/workloads/:id/scale", orchestrator.ScaleWorkload)
+		v1.PUT("/workloads/:id/expiry", orchestrator.SetWorkloadExpiry)
+		v1.PUT("/workloads/:id/metadata", orchestrator.SetWorkloadMetadata)
+		v1.PUT("/workloads/:id/autoscaling", orchestrator.SetWorkloadAutoscaling)
+		v1.DELETE("/workloads/:id/autoscaling", orchestrator.DeleteWorkloadAutoscaling)
+		v1.PUT("/workloads/:id/image-subscription", orchestrator.SetWorkloadImageSubscription)
+		v1.DELETE("/workloads/:id/image-subscription", orchestrator.DeleteWorkloadImageSubscription)
+		v1.GET("/image-pushes", orchestrator.ListImagePushEvents)
+		v1.GET("/workloads/:id/endpoints", orchestrator.GetWorkloadEndpoints)
+		v1.POST("/workloads/:id/migrate", orchestrator.MigrateWorkload)
+		v1.GET("/workloads/:id/migrations", orchestrator.ListWorkloadMigrations)
+		v1.GET("/workloads/:id/revisions", orchestrator.ListWorkloadRevisions)
+		v1.POST("/workloads/:id/rollback", orchestrator.RollbackWorkload)
+		v1.POST("/workloads/:id/blue-green", orchestrator.StartBlueGreenDeployment)
+		v1.POST("/workloads/:id/blue-green/switch", orchestrator.SwitchBlueGreenTraffic)
+		v1.POST("/workloads/:id/blue-green/promote", orchestrator.PromoteBlueGreenDeployment)
+		v1.POST("/workloads/:id/blue-green/rollback", orchestrator.RollbackBlueGreenDeployment)
+		v1.GET("/migrations/:id", orchestrator.GetMigration)
+		v1.POST("/workloads/:id/snapshots", orchestrator.CreateWorkloadSnapshot)
+		v1.GET("/snapshots", orchestrator.ListSnapshots)
+		v1.POST("/snapshots/:id/restore", orchestrator.RestoreSnapshotHandler)
+		v1.GET("/workloads/:id/offload", orchestrator.GetWorkloadOffload)
+		v1.GET("/workloads/:id/offload/files", orchestrator.ListOffloadedFiles)
+		v1.POST("/workloads/:id/offload/recall", orchestrator.RecallOffloadedFiles)
+
+		// Cameras and video analytics
+		v1.GET("/cameras", orchestrator.ListCameras)
+		v1.POST("/workload-templates/video-analytics", orchestrator.CreateVideoAnalyticsPipeline)
+
+		// Environments and the workload definitions rolled out to them
+		v1.PUT("/environments/:name", orchestrator.SetEnvironment)
+		v1.GET("/environments", orchestrator.ListEnvironments)
+		v1.GET("/environments/:name", orchestrator.GetEnvironment)
+		v1.DELETE("/environments/:name", orchestrator.DeleteEnvironment)
+		v1.POST("/workload-definitions", orchestrator.CreateWorkloadDefinition)
+		v1.GET("/workload-definitions", orchestrator.ListWorkloadDefinitions)
+		v1.GET("/workload-definitions/:id", orchestrator.GetWorkloadDefinition)
+		v1.PUT("/workload-definitions/:id", orchestrator.UpdateWorkloadDefinition)
+		v1.DELETE("/workload-definitions/:id", orchestrator.DeleteWorkloadDefinition)
+		v1.PUT("/workload-definitions/:id/environments/:env", orchestrator.RolloutWorkloadDefinition)
+		v1.DELETE("/workload-definitions/:id/environments/:env", orchestrator.DeleteWorkloadInstance)
 
 		// Monitoring and metrics
+		v1.GET("/summary", orchestrator.GetSummary)
 		v1.GET("/metrics", orchestrator.GetMetrics)
 		v1.GET("/nodes/:id/metrics", orchestrator.GetNodeMetrics)
 		v1.GET("/workloads/:id/metrics", orchestrator.GetWorkloadMetrics)
+		v1.GET("/workloads/:id/log-summary", orchestrator.GetWorkloadLogSummary)
+		v1.GET("/metrics/history", orchestrator.GetMetricHistory)
+		v1.GET("/metrics/store", orchestrator.GetMetricsStoreStats)
+		v1.PUT("/metrics/retention/:class", orchestrator.SetMetricsRetention)
+		v1.GET("/metrics/prometheus/api/v1/query", orchestrator.PrometheusQuery)
+		v1.GET("/metrics/prometheus/api/v1/query_range", orchestrator.PrometheusQueryRange)
+		v1.GET("/metrics/prometheus/api/v1/series", orchestrator.PrometheusSeries)
+		v1.GET("/metrics/prometheus/api/v1/labels", orchestrator.PrometheusLabels)
+		v1.GET("/metrics/prometheus/api/v1/label/:name/values", orchestrator.PrometheusLabelValues)
+		v1.GET("/metrics/prometheus/api/v1/metadata", orchestrator.PrometheusMetadata)
+		v1.GET("/metrics/prometheus/api/v1/status/buildinfo", orchestrator.PrometheusBuildInfo)
+		v1.GET("/alerts", orchestrator.ListAlerts)
+		v1.POST("/alerts/:id/acknowledge", orchestrator.AcknowledgeAlert)
+		v1.POST("/silences", orchestrator.CreateSilence)
+		v1.GET("/silences", orchestrator.ListSilences)
+		v1.GET("/silences/:id", orchestrator.GetSilence)
+		v1.DELETE("/silences/:id", orchestrator.ExpireSilence)
+		v1.POST("/maintenance-windows", orchestrator.CreateMaintenanceWindow)
+		v1.GET("/maintenance-windows", orchestrator.ListMaintenanceWindows)
+		v1.DELETE("/maintenance-windows/:id", orchestrator.DeleteMaintenanceWindow)
+		v1.GET("/messages", orchestrator.ListMessages)
+		v1.GET("/operations", orchestrator.ListOperations)
+		v1.GET("/operations/:id", orchestrator.GetOperation)
+		v1.GET("/nodes/:id/uptime", orchestrator.GetNodeUptime)
+		v1.GET("/reports/uptime", orchestrator.GetUptimeReport)
+		v1.GET("/reports/chargeback", orchestrator.GetChargebackReport)
+		v1.POST("/billing/rate-cards", orchestrator.CreateRateCard)
+		v1.GET("/billing/rate-cards", orchestrator.ListRateCards)
+		v1.DELETE("/billing/rate-cards/:id", orchestrator.DeleteRateCard)
+		v1.POST("/sla-policies", orchestrator.CreateSLAPolicy)
+		v1.GET("/sla-policies", orchestrator.ListSLAPolicies)
+		v1.DELETE("/sla-policies/:id", orchestrator.DeleteSLAPolicy)
+
+		// Capacity planning
+		v1.GET("/nodes/:id/capacity", orchestrator.GetNodeCapacity)
+		v1.GET("/nodes/:id/forecast", orchestrator.GetNodeForecast)
+		v1.GET("/sites/:id/forecast", orchestrator.GetSiteForecast)
+		v1.POST("/resource-reservations", orchestrator.CreateResourceReservation)
+		v1.GET("/resource-reservations", orchestrator.ListResourceReservations)
+		v1.DELETE("/resource-reservations/:id", orchestrator.DeleteResourceReservation)
+		v1.POST("/overcommit-policies", orchestrator.CreateOvercommitPolicy)
+		v1.GET("/overcommit-policies", orchestrator.ListOvercommitPolicies)
+		v1.DELETE("/overcommit-policies/:id", orchestrator.DeleteOvercommitPolicy)
+		v1.POST("/simulate/node-failure", orchestrator.SimulateNodeFailure)
+		v1.GET("/cloud-nodes", orchestrator.ListCloudNodes)
+
+		// Zero-touch provisioning
+		v1.POST("/provisioning/images", orchestrator.CreateProvisioningImage)
+		v1.GET("/provisioning/images", orchestrator.ListProvisioningImages)
+		v1.GET("/provisioning/images/:id", orchestrator.GetProvisioningImage)
+		v1.GET("/provisioning/images/:id/download", orchestrator.DownloadProvisioningImage)
+		v1.DELETE("/provisioning/images/:id", orchestrator.DeleteProvisioningImage)
+		v1.GET("/provisioning/join-tokens", orchestrator.ListJoinTokens)
+		v1.DELETE("/provisioning/join-tokens/:id", orchestrator.RevokeJoinToken)
+
+		// Device claims
+		v1.POST("/claims", orchestrator.AnnounceDevice)
+		v1.GET("/claims", orchestrator.ListDeviceClaims)
+		v1.GET("/claims/:code/status", orchestrator.GetClaimStatus)
+		v1.POST("/claims/:code/claim", orchestrator.ClaimDevice)
+		v1.DELETE("/claims/:code", orchestrator.RejectDevice)
+
+		// Scheduler plugins
+		v1.GET("/scheduler/plugins", orchestrator.ListSchedulerPlugins)
+
+		// Scheduling replay
+		v1.POST("/scheduler/snapshots", orchestrator.CaptureFleetSnapshot)
+		v1.GET("/scheduler/snapshots", orchestrator.ListFleetSnapshots)
+		v1.GET("/scheduler/snapshots/:id", orchestrator.GetFleetSnapshot)
+		v1.DELETE("/scheduler/snapshots/:id", orchestrator.DeleteFleetSnapshot)
+		v1.POST("/scheduler/replay", orchestrator.ReplayScheduling)
+
+		// Tenant quotas
+		v1.PUT("/tenant-quotas/:tenant", orchestrator.SetTenantQuota)
+		v1.GET("/tenant-quotas", orchestrator.ListTenantQuotas)
+		v1.DELETE("/tenant-quotas/:tenant", orchestrator.DeleteTenantQuota)
+
+		// Namespaces and their quotas
+		v1.POST("/namespaces", orchestrator.CreateNamespace)
+		v1.GET("/namespaces", orchestrator.ListNamespaces)
+		v1.GET("/namespaces/:name", orchestrator.GetNamespace)
+		v1.PUT("/namespaces/:name", orchestrator.UpdateNamespace)
+		v1.DELETE("/namespaces/:name", orchestrator.DeleteNamespace)
+
+		// Organizations and projects
+		v1.POST("/organizations", orchestrator.CreateOrganization)
+		v1.GET("/organizations", orchestrator.ListOrganizations)
+		v1.GET("/organizations/:name", orchestrator.GetOrganization)
+		v1.PUT("/organizations/:name", orchestrator.UpdateOrganization)
+		v1.DELETE("/organizations/:name", orchestrator.DeleteOrganization)
+		v1.POST("/projects", orchestrator.CreateProject)
+		v1.GET("/projects", orchestrator.ListProjects)
+		v1.GET("/projects/:name", orchestrator.GetProject)
+		v1.PUT("/projects/:name", orchestrator.UpdateProject)
+		v1.DELETE("/projects/:name", orchestrator.DeleteProject)
+
+		// JWT issuance
+		v1.POST("/auth/token", orchestrator.IssueToken)
+
+		// Firmware upgrade campaigns
+		v1.POST("/upgrade-campaigns", orchestrator.CreateUpgradeCampaign)
+		v1.GET("/upgrade-campaigns", orchestrator.ListUpgradeCampaigns)
+		v1.GET("/upgrade-campaigns/:id", orchestrator.GetUpgradeCampaign)
+		v1.POST("/upgrade-campaigns/:id/cancel", orchestrator.CancelUpgradeCampaign)
+
+		// OS patch campaigns
+		v1.POST("/patch-campaigns", orchestrator.CreatePatchCampaign)
+		v1.GET("/patch-campaigns", orchestrator.ListPatchCampaigns)
+		v1.GET("/patch-campaigns/:id", orchestrator.GetPatchCampaign)
+		v1.POST("/patch-campaigns/:id/cancel", orchestrator.CancelPatchCampaign)
+
+		// Federated learning
+		v1.POST("/federated-jobs", orchestrator.CreateFederatedJob)
+		v1.GET("/federated-jobs", orchestrator.ListFederatedJobs)
+		v1.GET("/federated-jobs/:id", orchestrator.GetFederatedJob)
+		v1.POST("/federated-jobs/:id/cancel", orchestrator.CancelFederatedJob)
+		v1.GET("/federated-jobs/:id/rounds/:round/updates/:node", orchestrator.GetFederatedUpdate)
+		v1.PUT("/federated-jobs/:id/rounds/:round/model", orchestrator.UploadAggregatedModel)
+		v1.GET("/federated-jobs/:id/model", orchestrator.GetFederatedModel)
+
+		// Edge functions
+		v1.POST("/functions", orchestrator.CreateFunction)
+		v1.GET("/functions", orchestrator.ListFunctions)
+		v1.GET("/functions/:id", orchestrator.GetFunction)
+		v1.DELETE("/functions/:id", orchestrator.DeleteFunction)
+		v1.PUT("/functions/:id/bundle", orchestrator.UploadFunctionBundle)
+		v1.GET("/functions/:id/bundle", orchestrator.GetFunctionBundle)
+		v1.GET("/function-runtimes", orchestrator.ListFunctionRuntimes)
+		v1.GET("/function-runtimes/:site/config", orchestrator.GetFunctionRuntimeConfig)
+		v1.POST("/function-runtimes/:site/metrics", orchestrator.ReportFunctionMetrics)
+
+		// Dataset catalog
+		v1.POST("/datasets", orchestrator.CreateDataset)
+		v1.GET("/datasets", orchestrator.ListDatasets)
+		v1.GET("/datasets/:name", orchestrator.GetDataset)
+		v1.PUT("/datasets/:name", orchestrator.UpdateDataset)
+		v1.DELETE("/datasets/:name", orchestrator.DeleteDataset)
+
+		// Debugging
+		v1.POST("/port-forwards", orchestrator.CreatePortForward)
+		v1.GET("/port-forwards", orchestrator.ListPortForwards)
+		v1.DELETE("/port-forwards/:id", orchestrator.ClosePortForward)
+		v1.GET("/port-forwards/:id/connect", orchestrator.ConnectPortForward)
+		v1.GET("/audit", orchestrator.ListAuditRecords)
+
+		// Administration
+		v1.GET("/admin/log-level", orchestrator.GetLogLevel)
+		v1.GET("/admin/storage", orchestrator.GetStorageStatus)
+		v1.GET("/admin/storage/backup", orchestrator.BackupState)
+		v1.GET("/admin/leader", orchestrator.GetLeaderStatus)
+		v1.GET("/admin/consistency", orchestrator.GetConsistencyReport)
+		v1.POST("/admin/consistency/repair", orchestrator.RepairConsistency)
+		v1.PUT("/admin/log-level", orchestrator.SetLogLevel)
 
 		// Security management
 		v1.POST("/certificates/issue", orchestrator.IssueCertificate)
 		v1.POST("/certificates/revoke", orchestrator.RevokeCertificate)
+		v1.GET("/certificates", orchestrator.ListCertificates)
+		v1.GET("/certificates/summary", orchestrator.GetCertificateSummary)
+		v1.GET("/certificates/ca", orchestrator.GetCAChain)
+		v1.GET("/certificates/:id", orchestrator.GetCertificate)
+
+		// DNS management
+		v1.GET("/dns/records", orchestrator.ListDNSRecords)
 	}
 
 	return router
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Locale of the built-in catalog and the fallback for missing translations
+	DefaultLocale = "en"
+)
+
+// MessageCode is a stable identifier for an alert or event message. Codes never change
+// meaning once released, so downstream systems can map them to their own text.
+type MessageCode string
+
+const (
+	MsgSiteDown              MessageCode = "EDGE-ALERT-0001"
+	MsgSiteDegraded          MessageCode = "EDGE-ALERT-0002"
+	MsgSLABreach             MessageCode = "EDGE-ALERT-0003"
+	MsgWorkloadUnschedulable MessageCode = "EDGE-ALERT-0004"
+	MsgCameraStreamDown      MessageCode = "EDGE-ALERT-0005"
+	MsgVolumeNearlyFull      MessageCode = "EDGE-ALERT-0006"
+	MsgWorkloadFailing       MessageCode = "EDGE-ALERT-0007"
+	MsgWorkloadLogErrorSpike MessageCode = "EDGE-ALERT-0008"
+	MsgFailoverMoved         MessageCode = "EDGE-EVENT-0001"
+	MsgFailoverDisplaced     MessageCode = "EDGE-EVENT-0002"
+	MsgFailoverNoCapacity    MessageCode = "EDGE-EVENT-0003"
+	MsgPlacementAdded        MessageCode = "EDGE-EVENT-0004"
+	MsgPlacementRemoved      MessageCode = "EDGE-EVENT-0005"
+	MsgPlacementMoved        MessageCode = "EDGE-EVENT-0006"
+	MsgWorkloadExpired       MessageCode = "EDGE-EVENT-0007"
+	MsgNodePreempted         MessageCode = "EDGE-EVENT-0008"
+	MsgDrainNoCapacity       MessageCode = "EDGE-EVENT-0009"
+)
+
+// defaultCatalog holds the English templates; {name} placeholders are replaced with params
+var defaultCatalog = map[MessageCode]string{
+	MsgSiteDown:              "All {node_count} nodes at site {site} are unavailable",
+	MsgSiteDegraded:          "{online_nodes} of {node_count} nodes at site {site} are online",
+	MsgSLABreach:             "Node {node} {window} availability {availability}% is below SLA {policy} target of {target}%",
+	MsgWorkloadUnschedulable: "{replicas} replica(s) of {workload} lost on {from_node} could not be re-placed",
+	MsgCameraStreamDown:      "Camera {camera} on {node} is {status}",
+	MsgVolumeNearlyFull:      "Volume {volume} of {workload} on {node} is {usage}% full with no cold data left to offload",
+	MsgWorkloadFailing:       "{workload} is failing on {failing_nodes} of {node_count} node(s): {reason}",
+	MsgWorkloadLogErrorSpike: "{workload} logs {rate} errors per minute against a usual {baseline}; most frequent: {sample}",
+	MsgFailoverMoved:         "{replicas} replica(s) of {workload} (criticality {criticality}) moved from {from_node} to {to_node}",
+	MsgFailoverDisplaced:     "{workload} (criticality {criticality}) displaced from {node} to make room for {displaced_by} (criticality {displaced_by_criticality})",
+	MsgFailoverNoCapacity:    "No capacity for {replicas} replica(s) of {workload} (criticality {criticality}) lost on {from_node}",
+	MsgPlacementAdded:        "{workload} placed on {node} after its {keys} changed",
+	MsgPlacementRemoved:      "{workload} removed from {node}, which no longer satisfies its placement after its {keys} changed",
+	MsgPlacementMoved:        "{replicas} replica(s) of {workload} moved from {from_node} to {to_node}",
+	MsgWorkloadExpired:       "{workload} expired at {expires_at} and was stopped on {node}",
+	MsgNodePreempted:         "{node} received a preemption notice from {provider} to {action} it at {terminate_at}",
+	MsgDrainNoCapacity:       "No node can take {replicas} replica(s) of {workload} drained from {node}",
+}
+
+// Message is a coded, parameterized message that can be rendered in any catalog locale
+type Message struct {
+	Code   MessageCode       `json:"code"`
+	Params map[string]string `json:"params"`
+}
+
+// newMessage builds a message from alternating parameter names and values
+func newMessage(code MessageCode, keyValues ...interface{}) Message {
+	params := make(map[string]string, len(keyValues)/2)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		params[fmt.Sprint(keyValues[i])] = fmt.Sprint(keyValues[i+1])
+	}
+	return Message{Code: code, Params: params}
+}
+
+// String renders the message with the built-in English catalog
+func (m Message) String() string {
+	return renderTemplate(defaultCatalog[m.Code], m)
+}
+
+func renderTemplate(template string, m Message) string {
+	if template == "" {
+		return string(m.Code)
+	}
+	pairs := make([]string, 0, len(m.Params)*2)
+	for key, value := range m.Params {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+// MessageCatalog holds message templates per locale
+type MessageCatalog struct {
+	locales map[string]map[MessageCode]string
+	mutex   sync.RWMutex
+	logger  *logrus.Logger
+}
+
+// NewMessageCatalog creates a catalog with the built-in English messages and any
+// <locale>.json files found in MESSAGE_CATALOG_DIR
+func NewMessageCatalog(logger *logrus.Logger) *MessageCatalog {
+	mc := &MessageCatalog{
+		locales: map[string]map[MessageCode]string{DefaultLocale: defaultCatalog},
+		logger:  logger,
+	}
+
+	if dir := os.Getenv("MESSAGE_CATALOG_DIR"); dir != "" {
+		if err := mc.loadDir(dir); err != nil {
+			logger.Errorf("Failed to load message catalogs: %v", err)
+		}
+	}
+
+	return mc
+}
+
+// loadDir loads every <locale>.json file in dir
+func (mc *MessageCatalog) loadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list catalog directory: %v", err)
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read catalog %s: %v", file, err)
+		}
+
+		var templates map[MessageCode]string
+		if err := json.Unmarshal(data, &templates); err != nil {
+			return fmt.Errorf("failed to parse catalog %s: %v", file, err)
+		}
+
+		locale := strings.TrimSuffix(filepath.Base(file), ".json")
+		if locale == DefaultLocale {
+			// Overrides for the built-in templates
+			merged := make(map[MessageCode]string, len(defaultCatalog))
+			for code, template := range defaultCatalog {
+				merged[code] = template
+			}
+			for code, template := range templates {
+				merged[code] = template
+			}
+			templates = merged
+		}
+		mc.locales[locale] = templates
+		mc.logger.Infof("Loaded %d messages for locale %s", len(templates), locale)
+	}
+
+	return nil
+}
+
+// Render renders a message in the given locale, falling back to English
+func (mc *MessageCatalog) Render(m Message, locale string) string {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	if template, exists := mc.locales[locale][m.Code]; exists {
+		return renderTemplate(template, m)
+	}
+	if template, exists := mc.locales[DefaultLocale][m.Code]; exists {
+		return renderTemplate(template, m)
+	}
+	return string(m.Code)
+}
+
+// requestLocale returns the locale from the locale query parameter or Accept-Language header
+func requestLocale(c *gin.Context) string {
+	if locale := c.Query("locale"); locale != "" {
+		return locale
+	}
+	header := c.GetHeader("Accept-Language")
+	if header == "" {
+		return DefaultLocale
+	}
+	tag := strings.TrimSpace(strings.SplitN(strings.SplitN(header, ",", 2)[0], ";", 2)[0])
+	if tag == "" || tag == "*" {
+		return DefaultLocale
+	}
+	// Use the language part of tags like de-CH
+	return strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+}
+
+// ListMessages returns the message catalog for a locale
+func (co *CentralOrchestrator) ListMessages(c *gin.Context) {
+	locale := requestLocale(c)
+
+	co.MessageCatalog.mutex.RLock()
+	defer co.MessageCatalog.mutex.RUnlock()
+
+	messages := make(map[MessageCode]string, len(defaultCatalog))
+	for code := range defaultCatalog {
+		if template, exists := co.MessageCatalog.locales[locale][code]; exists {
+			messages[code] = template
+		} else {
+			messages[code] = defaultCatalog[code]
+		}
+	}
+
+	locales := make([]string, 0, len(co.MessageCatalog.locales))
+	for name := range co.MessageCatalog.locales {
+		locales = append(locales, name)
+	}
+	sort.Strings(locales)
+
+	c.JSON(http.StatusOK, gin.H{
+		"locale":   locale,
+		"locales":  locales,
+		"messages": messages,
+	})
+}
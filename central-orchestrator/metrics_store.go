@@ -0,0 +1,624 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How often completed buckets are rolled up and expired points dropped
+	MetricsCompactionInterval = 5 * time.Minute
+
+	// How often the metrics collector takes raw samples
+	MetricsCollectionInterval = time.Minute
+
+	// Downsampled resolutions; raw samples are taken by the metrics collector
+	MetricsResolutionFiveMinute = 5 * time.Minute
+	MetricsResolutionHourly     = time.Hour
+
+	// In-memory sizes of a raw point and an aggregate, for footprint estimates
+	metricPointBytes     = 16
+	metricAggregateBytes = 40
+)
+
+// MetricClass groups series that share a retention policy
+type MetricClass string
+
+const (
+	MetricClassFleet    MetricClass = "fleet"
+	MetricClassNode     MetricClass = "node"
+	MetricClassWorkload MetricClass = "workload"
+	MetricClassFunction MetricClass = "function"
+	MetricClassBilling  MetricClass = "billing"
+)
+
+// RetentionPolicy is how long each resolution of a metric class is kept
+type RetentionPolicy struct {
+	RawHours        int `json:"raw_retention_hours"`
+	FiveMinuteHours int `json:"five_minute_retention_hours"`
+	HourlyHours     int `json:"hourly_retention_hours"`
+}
+
+// defaultRetentionPolicies keep a year of fleet-wide history and shorter per-node and
+// per-workload history, which is where the series count grows with the fleet
+var defaultRetentionPolicies = map[MetricClass]RetentionPolicy{
+	MetricClassFleet:    {RawHours: 7 * 24, FiveMinuteHours: 90 * 24, HourlyHours: 365 * 24},
+	MetricClassNode:     {RawHours: 24, FiveMinuteHours: 7 * 24, HourlyHours: 90 * 24},
+	MetricClassWorkload: {RawHours: 24, FiveMinuteHours: 7 * 24, HourlyHours: 30 * 24},
+	MetricClassFunction: {RawHours: 24, FiveMinuteHours: 7 * 24, HourlyHours: 30 * 24},
+	// Hourly usage outlives a year so last year's month can still be charged back
+	MetricClassBilling: {RawHours: 2, FiveMinuteHours: 48, HourlyHours: 400 * 24},
+}
+
+// validate checks that every tier outlives the bucket rolled up from it
+func (p RetentionPolicy) validate() error {
+	if p.RawHours < 1 || p.FiveMinuteHours < 1 || p.HourlyHours < 1 {
+		return fmt.Errorf("every retention must be at least 1 hour")
+	}
+	return nil
+}
+
+// MetricSample is one raw observation handed to the store
+type MetricSample struct {
+	Class    MetricClass
+	Name     string
+	EntityID string
+	Value    float64
+}
+
+// metricPoint is a raw sample; timestamps are unix seconds to keep points small
+type metricPoint struct {
+	at    int64
+	value float64
+}
+
+// metricAggregate summarizes the raw samples of one bucket
+type metricAggregate struct {
+	at    int64
+	min   float64
+	max   float64
+	sum   float64
+	count int32
+}
+
+// metricSeries holds one metric of one entity at every resolution
+type metricSeries struct {
+	class      MetricClass
+	name       string
+	entityID   string
+	raw        []metricPoint
+	fiveMinute []metricAggregate
+	hourly     []metricAggregate
+	// Start of the next bucket to roll up at each downsampled resolution
+	fiveMinuteFrom int64
+	hourlyFrom     int64
+}
+
+// MetricHistoryPoint is a point returned by the history API
+type MetricHistoryPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Avg       float64   `json:"avg"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Count     int32     `json:"count"`
+}
+
+// MetricClassStats describes the store's footprint for one class
+type MetricClassStats struct {
+	Policy           RetentionPolicy `json:"policy"`
+	Series           int             `json:"series"`
+	RawPoints        int             `json:"raw_points"`
+	FiveMinutePoints int             `json:"five_minute_points"`
+	HourlyPoints     int             `json:"hourly_points"`
+	EstimatedBytes   int64           `json:"estimated_bytes"`
+}
+
+// MetricsStore is the orchestrator's embedded time-series store. Raw samples are rolled up
+// into 5 minute and hourly aggregates, and each resolution is expired per metric class.
+type MetricsStore struct {
+	series   map[string]*metricSeries
+	policies map[MetricClass]RetentionPolicy
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewMetricsStore creates a metrics store. METRICS_RETENTION_<CLASS> overrides a class's
+// policy as "raw,5m,1h" durations, e.g. METRICS_RETENTION_NODE=48h,336h,2160h.
+func NewMetricsStore(logger *logrus.Logger) *MetricsStore {
+	policies := make(map[MetricClass]RetentionPolicy, len(defaultRetentionPolicies))
+	for class, policy := range defaultRetentionPolicies {
+		policies[class] = policy
+
+		variable := "METRICS_RETENTION_" + strings.ToUpper(string(class))
+		value := os.Getenv(variable)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseRetentionPolicy(value)
+		if err != nil {
+			logger.Warnf("Invalid %s %q, using the default: %v", variable, value, err)
+			continue
+		}
+		policies[class] = parsed
+	}
+
+	return &MetricsStore{
+		series:   make(map[string]*metricSeries),
+		policies: policies,
+		logger:   logger,
+	}
+}
+
+// parseRetentionPolicy parses "raw,5m,1h" retention durations
+func parseRetentionPolicy(value string) (RetentionPolicy, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 3 {
+		return RetentionPolicy{}, fmt.Errorf("expected three comma-separated durations")
+	}
+
+	hours := make([]int, len(fields))
+	for i, field := range fields {
+		duration, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil {
+			return RetentionPolicy{}, err
+		}
+		hours[i] = int(duration / time.Hour)
+	}
+
+	policy := RetentionPolicy{RawHours: hours[0], FiveMinuteHours: hours[1], HourlyHours: hours[2]}
+	return policy, policy.validate()
+}
+
+func metricSeriesKey(class MetricClass, name, entityID string) string {
+	return string(class) + "/" + name + "/" + entityID
+}
+
+// Record appends raw samples taken at the given time
+func (ms *MetricsStore) Record(at time.Time, samples []MetricSample) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	unix := at.Unix()
+	for _, sample := range samples {
+		key := metricSeriesKey(sample.Class, sample.Name, sample.EntityID)
+		series, exists := ms.series[key]
+		if !exists {
+			series = &metricSeries{class: sample.Class, name: sample.Name, entityID: sample.EntityID}
+			series.fiveMinuteFrom = bucketStart(unix, MetricsResolutionFiveMinute)
+			series.hourlyFrom = bucketStart(unix, MetricsResolutionHourly)
+			ms.series[key] = series
+		}
+		series.raw = append(series.raw, metricPoint{at: unix, value: sample.Value})
+	}
+}
+
+func bucketStart(unix int64, resolution time.Duration) int64 {
+	seconds := int64(resolution / time.Second)
+	return unix - unix%seconds
+}
+
+// Compact rolls completed buckets up to the next resolution and drops points past their
+// class's retention. Series with no points left are removed.
+func (ms *MetricsStore) Compact(now time.Time) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	unix := now.Unix()
+	removed := 0
+	for key, series := range ms.series {
+		series.rollUp(unix)
+
+		policy := ms.policies[series.class]
+		series.raw = prunePoints(series.raw, unix-int64(policy.RawHours)*3600)
+		series.fiveMinute = pruneAggregates(series.fiveMinute, unix-int64(policy.FiveMinuteHours)*3600)
+		series.hourly = pruneAggregates(series.hourly, unix-int64(policy.HourlyHours)*3600)
+
+		if len(series.raw) == 0 && len(series.fiveMinute) == 0 && len(series.hourly) == 0 {
+			delete(ms.series, key)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		ms.logger.Infof("Metrics store compaction removed %d expired series", removed)
+	}
+}
+
+// rollUp aggregates every raw bucket that ended by now into 5 minute points, then every
+// completed hour of 5 minute points into hourly points
+func (s *metricSeries) rollUp(now int64) {
+	step := int64(MetricsResolutionFiveMinute / time.Second)
+	for _, point := range s.raw {
+		if point.at < s.fiveMinuteFrom {
+			continue
+		}
+		bucket := bucketStart(point.at, MetricsResolutionFiveMinute)
+		if bucket+step > now {
+			break
+		}
+		s.fiveMinute = mergeInto(s.fiveMinute, metricAggregate{at: bucket, min: point.value, max: point.value, sum: point.value, count: 1})
+	}
+	if completed := bucketStart(now, MetricsResolutionFiveMinute); completed > s.fiveMinuteFrom {
+		s.fiveMinuteFrom = completed
+	}
+
+	step = int64(MetricsResolutionHourly / time.Second)
+	for _, aggregate := range s.fiveMinute {
+		if aggregate.at < s.hourlyFrom {
+			continue
+		}
+		bucket := bucketStart(aggregate.at, MetricsResolutionHourly)
+		if bucket+step > now {
+			break
+		}
+		aggregate.at = bucket
+		s.hourly = mergeInto(s.hourly, aggregate)
+	}
+	if completed := bucketStart(now, MetricsResolutionHourly); completed > s.hourlyFrom {
+		s.hourlyFrom = completed
+	}
+}
+
+// mergeInto folds an aggregate into the last point when it covers the same bucket, or
+// appends it otherwise
+func mergeInto(aggregates []metricAggregate, next metricAggregate) []metricAggregate {
+	if n := len(aggregates); n > 0 && aggregates[n-1].at == next.at {
+		last := &aggregates[n-1]
+		if next.min < last.min {
+			last.min = next.min
+		}
+		if next.max > last.max {
+			last.max = next.max
+		}
+		last.sum += next.sum
+		last.count += next.count
+		return aggregates
+	}
+	return append(aggregates, next)
+}
+
+// prunePoints drops points before cutoff, copying the rest so the old array can be freed
+func prunePoints(points []metricPoint, cutoff int64) []metricPoint {
+	i := sort.Search(len(points), func(i int) bool { return points[i].at >= cutoff })
+	if i == 0 {
+		return points
+	}
+	return append([]metricPoint(nil), points[i:]...)
+}
+
+func pruneAggregates(aggregates []metricAggregate, cutoff int64) []metricAggregate {
+	i := sort.Search(len(aggregates), func(i int) bool { return aggregates[i].at >= cutoff })
+	if i == 0 {
+		return aggregates
+	}
+	return append([]metricAggregate(nil), aggregates[i:]...)
+}
+
+// resolutionFor picks the finest resolution still retained at from
+func (p RetentionPolicy) resolutionFor(from, now time.Time) string {
+	switch age := now.Sub(from); {
+	case age <= time.Duration(p.RawHours)*time.Hour:
+		return "raw"
+	case age <= time.Duration(p.FiveMinuteHours)*time.Hour:
+		return "5m"
+	default:
+		return "1h"
+	}
+}
+
+// History returns a series' points between from and to at the given resolution ("raw",
+// "5m", "1h" or "auto")
+func (ms *MetricsStore) History(class MetricClass, name, entityID, resolution string, from, to time.Time) ([]MetricHistoryPoint, string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	policy, known := ms.policies[class]
+	if !known {
+		return nil, "", fmt.Errorf("unknown metric class %q", class)
+	}
+	if resolution == "" || resolution == "auto" {
+		resolution = policy.resolutionFor(from, time.Now())
+	}
+
+	points := make([]MetricHistoryPoint, 0)
+	series, exists := ms.series[metricSeriesKey(class, name, entityID)]
+	if !exists {
+		return points, resolution, nil
+	}
+
+	fromUnix, toUnix := from.Unix(), to.Unix()
+	switch resolution {
+	case "raw":
+		for _, point := range series.raw {
+			if point.at >= fromUnix && point.at <= toUnix {
+				points = append(points, MetricHistoryPoint{
+					Timestamp: time.Unix(point.at, 0).UTC(),
+					Avg:       point.value,
+					Min:       point.value,
+					Max:       point.value,
+					Count:     1,
+				})
+			}
+		}
+	case "5m", "1h":
+		aggregates := series.fiveMinute
+		if resolution == "1h" {
+			aggregates = series.hourly
+		}
+		for _, aggregate := range aggregates {
+			if aggregate.at >= fromUnix && aggregate.at <= toUnix {
+				points = append(points, MetricHistoryPoint{
+					Timestamp: time.Unix(aggregate.at, 0).UTC(),
+					Avg:       aggregate.sum / float64(aggregate.count),
+					Min:       aggregate.min,
+					Max:       aggregate.max,
+					Count:     aggregate.count,
+				})
+			}
+		}
+	default:
+		return nil, "", fmt.Errorf("resolution must be raw, 5m, 1h or auto")
+	}
+
+	return points, resolution, nil
+}
+
+// Sum returns the sum of a series' samples taken from from up to to. Each period is read
+// from the finest resolution that still covers it, so no sample counts twice.
+func (ms *MetricsStore) Sum(class MetricClass, name, entityID string, from, to time.Time) float64 {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	series, exists := ms.series[metricSeriesKey(class, name, entityID)]
+	if !exists {
+		return 0
+	}
+
+	fromUnix, toUnix := from.Unix(), to.Unix()
+	sum := 0.0
+	for _, aggregate := range series.hourly {
+		if aggregate.at >= fromUnix && aggregate.at < toUnix && aggregate.at < series.hourlyFrom {
+			sum += aggregate.sum
+		}
+	}
+	for _, aggregate := range series.fiveMinute {
+		if aggregate.at >= fromUnix && aggregate.at < toUnix && aggregate.at >= series.hourlyFrom && aggregate.at < series.fiveMinuteFrom {
+			sum += aggregate.sum
+		}
+	}
+	for _, point := range series.raw {
+		if point.at >= fromUnix && point.at < toUnix && point.at >= series.fiveMinuteFrom {
+			sum += point.value
+		}
+	}
+	return sum
+}
+
+// Stats returns the policy and footprint of every metric class
+func (ms *MetricsStore) Stats() map[MetricClass]*MetricClassStats {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	stats := make(map[MetricClass]*MetricClassStats, len(ms.policies))
+	for class, policy := range ms.policies {
+		stats[class] = &MetricClassStats{Policy: policy}
+	}
+
+	for key, series := range ms.series {
+		classStats, exists := stats[series.class]
+		if !exists {
+			continue
+		}
+		classStats.Series++
+		classStats.RawPoints += len(series.raw)
+		classStats.FiveMinutePoints += len(series.fiveMinute)
+		classStats.HourlyPoints += len(series.hourly)
+		classStats.EstimatedBytes += int64(len(key)) + int64(cap(series.raw))*metricPointBytes +
+			int64(cap(series.fiveMinute)+cap(series.hourly))*metricAggregateBytes
+	}
+	return stats
+}
+
+// Policy returns a class's retention policy
+func (ms *MetricsStore) Policy(class MetricClass) RetentionPolicy {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.policies[class]
+}
+
+// SetPolicy replaces a class's retention policy; it applies at the next compaction
+func (ms *MetricsStore) SetPolicy(class MetricClass, policy RetentionPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, known := ms.policies[class]; !known {
+		return fmt.Errorf("unknown metric class %q", class)
+	}
+	ms.policies[class] = policy
+	ms.logger.Infof("Retention for %s metrics set to raw %dh, 5m %dh, 1h %dh",
+		class, policy.RawHours, policy.FiveMinuteHours, policy.HourlyHours)
+	return nil
+}
+
+// metricsCompactor periodically downsamples and expires stored metrics
+func (co *CentralOrchestrator) metricsCompactor() {
+	ticker := time.NewTicker(MetricsCompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.MetricsStore.Compact(time.Now())
+		}
+	}
+}
+
+// metricSamples returns the raw samples for the fleet, every node and every workload
+func (co *CentralOrchestrator) metricSamples() []MetricSample {
+	var samples []MetricSample
+	onlineNodes, runningWorkloads := 0, 0
+	now := time.Now()
+
+	co.NodeManager.mutex.RLock()
+	nodeCount := len(co.NodeManager.nodes)
+	for _, node := range co.NodeManager.nodes {
+		if node.Status == NodeStatusOnline {
+			onlineNodes++
+		}
+		samples = append(samples,
+			MetricSample{Class: MetricClassNode, Name: "cpu_percent", EntityID: node.ID, Value: node.Resources.CPU.Percentage},
+			MetricSample{Class: MetricClassNode, Name: "memory_percent", EntityID: node.ID, Value: node.Resources.Memory.Percentage},
+			MetricSample{Class: MetricClassNode, Name: "storage_percent", EntityID: node.ID, Value: node.Resources.Storage.Percentage})
+		samples = append(samples, linkMetricSamples(node, now)...)
+		samples = append(samples, customMetricSamples(node)...)
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	co.WorkloadManager.mutex.RLock()
+	workloadCount := len(co.WorkloadManager.workloads)
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.Status == WorkloadStatusRunning {
+			runningWorkloads++
+		}
+		var replicas int32
+		for _, deployment := range workload.Deployments {
+			if deployment.Status == WorkloadStatusRunning {
+				replicas += deployment.Replicas
+			}
+		}
+		samples = append(samples, MetricSample{Class: MetricClassWorkload, Name: "running_replicas", EntityID: workload.ID, Value: float64(replicas)})
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	samples = append(samples, co.functionMetricSamples()...)
+	samples = append(samples, co.logMetricSamples(now)...)
+	samples = append(samples, co.billingMetricSamples(now)...)
+
+	certificates := summarizeCertificates(co.certificateInventory(now), now)
+
+	return append(samples,
+		MetricSample{Class: MetricClassFleet, Name: "certificates_active", Value: float64(certificates.ByStatus[string(CertificateStatusActive)])},
+		MetricSample{Class: MetricClassFleet, Name: "certificates_expiring_30d", Value: float64(certificates.ExpiringWithin["30d"])},
+		MetricSample{Class: MetricClassFleet, Name: "nodes_total", Value: float64(nodeCount)},
+		MetricSample{Class: MetricClassFleet, Name: "nodes_online", Value: float64(onlineNodes)},
+		MetricSample{Class: MetricClassFleet, Name: "workloads_total", Value: float64(workloadCount)},
+		MetricSample{Class: MetricClassFleet, Name: "workloads_running", Value: float64(runningWorkloads)})
+}
+
+// metricSeriesFilter returns whether the caller may see a stored series. Callers limited to
+// tenants see the series of the nodes, workloads and functions they may see, and none of
+// the fleet series, which count every tenant's.
+func (co *CentralOrchestrator) metricSeriesFilter(c *gin.Context) func(class MetricClass, entityID string) bool {
+	view := co.tenantView(c)
+	if view == nil {
+		return func(MetricClass, string) bool { return true }
+	}
+
+	billed := make(map[string]bool)
+	co.BillingManager.mutex.RLock()
+	for id, workload := range co.BillingManager.workloads {
+		if tenantAllowed(c, workload.Tenant) {
+			billed[id] = true
+		}
+	}
+	co.BillingManager.mutex.RUnlock()
+
+	functions := make(map[string]bool)
+	co.FunctionManager.mutex.RLock()
+	for id, function := range co.FunctionManager.functions {
+		if tenantAllowed(c, function.Tenant) {
+			functions[id] = true
+		}
+	}
+	co.FunctionManager.mutex.RUnlock()
+
+	return func(class MetricClass, entityID string) bool {
+		switch class {
+		case MetricClassNode:
+			return view.node(entityID)
+		case MetricClassWorkload:
+			return view.workload(entityID)
+		case MetricClassBilling:
+			return billed[entityID]
+		case MetricClassFunction:
+			return functions[entityID]
+		}
+		return false
+	}
+}
+
+// GetMetricHistory returns stored history for one metric. Fleet metrics take no id.
+func (co *CentralOrchestrator) GetMetricHistory(c *gin.Context) {
+	name := c.Query("metric")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric is required"})
+		return
+	}
+	class := MetricClass(c.DefaultQuery("class", string(MetricClassFleet)))
+
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	for param, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 time", param)})
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	if !co.metricSeriesFilter(c)(class, c.Query("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Metric not found"})
+		return
+	}
+
+	points, resolution, err := co.MetricsStore.History(class, name, c.Query("id"), c.Query("resolution"), from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"class":      class,
+		"metric":     name,
+		"id":         c.Query("id"),
+		"resolution": resolution,
+		"points":     points,
+	})
+}
+
+// GetMetricsStoreStats returns retention policies and the store's footprint per class
+func (co *CentralOrchestrator) GetMetricsStoreStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"classes": co.MetricsStore.Stats()})
+}
+
+// SetMetricsRetention updates a metric class's retention policy
+func (co *CentralOrchestrator) SetMetricsRetention(c *gin.Context) {
+	var req RetentionPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	class := MetricClass(c.Param("class"))
+	if err := co.MetricsStore.SetPolicy(class, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"class": class, "policy": req})
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Default time to wait for a replacement to become ready
+	DefaultMigrationReadyTimeout = 10 * time.Minute
+
+	// How often a migration checks whether the agent reports its replacement available
+	MigrationReadyPollInterval = 2 * time.Second
+)
+
+// Probe describes how to check that a workload replica is ready. Agents add it to the
+// workload's container, against the container port Port.
+type Probe struct {
+	HTTPPath            string `json:"http_path,omitempty"`
+	Port                int32  `json:"port"`
+	InitialDelaySeconds int32  `json:"initial_delay_seconds"`
+	PeriodSeconds       int32  `json:"period_seconds"`
+	TimeoutSeconds      int32  `json:"timeout_seconds"`
+	FailureThreshold    int32  `json:"failure_threshold"`
+}
+
+// MigrationPhase represents the progress of a workload migration
+type MigrationPhase string
+
+const (
+	MigrationPhasePreparing  MigrationPhase = "preparing-volumes"
+	MigrationPhaseStarting   MigrationPhase = "starting"
+	MigrationPhaseWaiting    MigrationPhase = "waiting-ready"
+	MigrationPhaseTearDown   MigrationPhase = "tearing-down"
+	MigrationPhaseCompleted  MigrationPhase = "completed"
+	MigrationPhaseFailed     MigrationPhase = "failed"
+	MigrationPhaseRolledBack MigrationPhase = "rolled-back"
+)
+
+// Migration moves a workload's replicas from one node to another
+type Migration struct {
+	ID                string         `json:"id"`
+	WorkloadID        string         `json:"workload_id"`
+	SourceNodeID      string         `json:"source_node_id"`
+	DestinationNodeID string         `json:"destination_node_id"`
+	Replicas          int32          `json:"replicas"`
+	Phase             MigrationPhase `json:"phase"`
+	Message           string         `json:"message,omitempty"`
+	ReadyTimeout      time.Duration  `json:"ready_timeout"`
+	StartedAt         time.Time      `json:"started_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	CompletedAt       *time.Time     `json:"completed_at,omitempty"`
+}
+
+// VolumeHook moves persistent data alongside a migrating workload
+type VolumeHook interface {
+	// PrepareVolumes runs before the replacement starts, e.g. to snapshot and
+	// restore the source's data on the destination
+	PrepareVolumes(ctx context.Context, migration *Migration, workload *Workload) error
+	// FinalizeVolumes runs after the original has been torn down
+	FinalizeVolumes(ctx context.Context, migration *Migration, workload *Workload) error
+}
+
+// MigrationManager tracks workload migrations
+type MigrationManager struct {
+	migrations map[string]*Migration
+	hooks      []VolumeHook
+	mutex      sync.RWMutex
+	logger     *logrus.Logger
+}
+
+// MigrationRequest represents a workload migration request
+type MigrationRequest struct {
+	SourceNodeID        string            `json:"source_node_id" binding:"required"`
+	DestinationNodeID   string            `json:"destination_node_id"`
+	DestinationSelector map[string]string `json:"destination_selector"`
+	ReadyTimeoutSeconds int32             `json:"ready_timeout_seconds"`
+}
+
+// NewMigrationManager creates a new migration manager
+func NewMigrationManager(logger *logrus.Logger) *MigrationManager {
+	return &MigrationManager{
+		migrations: make(map[string]*Migration),
+		logger:     logger,
+	}
+}
+
+// RegisterVolumeHook adds a hook that runs for every migration
+func (mm *MigrationManager) RegisterVolumeHook(hook VolumeHook) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	mm.hooks = append(mm.hooks, hook)
+}
+
+// setPhase updates a migration's phase
+func (mm *MigrationManager) setPhase(m *Migration, phase MigrationPhase, message string) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	now := time.Now()
+	m.Phase = phase
+	m.Message = message
+	m.UpdatedAt = now
+	if phase == MigrationPhaseCompleted || phase == MigrationPhaseFailed || phase == MigrationPhaseRolledBack {
+		m.CompletedAt = &now
+	}
+	mm.logger.Infof("Migration %s of workload %s: %s %s", m.ID, m.WorkloadID, phase, message)
+}
+
+// MigrateWorkload starts moving a workload's replicas from a source node to a destination
+// node (or the first eligible node matching a selector)
+func (co *CentralOrchestrator) MigrateWorkload(c *gin.Context) {
+	workloadID := c.Param("id")
+
+	var req MigrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DestinationNodeID == "" && len(req.DestinationSelector) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "destination_node_id or destination_selector is required"})
+		return
+	}
+
+	online := co.onlineNodes(nil)
+
+	co.WorkloadManager.mutex.RLock()
+	workload, exists := co.WorkloadManager.workloads[workloadID]
+	if !exists {
+		co.WorkloadManager.mutex.RUnlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	source := workload.deploymentFor(req.SourceNodeID)
+	if source == nil || source.Status != WorkloadStatusRunning {
+		co.WorkloadManager.mutex.RUnlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Workload is not running on the source node"})
+		return
+	}
+
+	destination, err := co.selectMigrationDestination(workload, req, online, committedResources(co.WorkloadManager.workloads))
+	replicas := source.Replicas
+	co.WorkloadManager.mutex.RUnlock()
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	readyTimeout := DefaultMigrationReadyTimeout
+	if req.ReadyTimeoutSeconds > 0 {
+		readyTimeout = time.Duration(req.ReadyTimeoutSeconds) * time.Second
+	}
+
+	now := time.Now()
+	migration := &Migration{
+		ID:                generateID(),
+		WorkloadID:        workloadID,
+		SourceNodeID:      req.SourceNodeID,
+		DestinationNodeID: destination.ID,
+		Replicas:          replicas,
+		Phase:             MigrationPhasePreparing,
+		ReadyTimeout:      readyTimeout,
+		StartedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	co.MigrationManager.mutex.Lock()
+	co.MigrationManager.migrations[migration.ID] = migration
+	co.MigrationManager.mutex.Unlock()
+
+	go co.runMigration(migration)
+
+	c.JSON(http.StatusAccepted, gin.H{"migration": migration})
+}
+
+// selectMigrationDestination validates or picks the destination node; callers must hold the
+// WorkloadManager lock
+func (co *CentralOrchestrator) selectMigrationDestination(workload *Workload, req MigrationRequest, online map[string]*EdgeNode, committed map[string]Commitment) (*EdgeNode, error) {
+	eligible := func(node *EdgeNode) error {
+		if node.ID == req.SourceNodeID {
+			return fmt.Errorf("destination must differ from source")
+		}
+		if !co.NodeStateManager.Schedulable(node.State) {
+			return fmt.Errorf("node %s is in state %s, which is not schedulable", node.ID, node.State)
+		}
+		if node.Unschedulable {
+			return fmt.Errorf("node %s is cordoned", node.ID)
+		}
+		if !co.nodeAdmitsWorkload(node, workload, false) {
+			return fmt.Errorf("node %s does not satisfy the workload's placement constraints or tolerations", node.ID)
+		}
+		if d := workload.deploymentFor(node.ID); d != nil && (d.Status == WorkloadStatusRunning || d.Status == WorkloadStatusPending) {
+			return fmt.Errorf("workload is already deployed on node %s", node.ID)
+		}
+		source := workload.deploymentFor(req.SourceNodeID)
+		if !co.fitsOnNode(node, committed[node.ID], workload, source.Replicas) {
+			return fmt.Errorf("node %s does not have enough capacity", node.ID)
+		}
+		return nil
+	}
+
+	if req.DestinationNodeID != "" {
+		node, isOnline := online[req.DestinationNodeID]
+		if !isOnline {
+			return nil, fmt.Errorf("destination node %s is not online", req.DestinationNodeID)
+		}
+		if err := eligible(node); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	var matches []*EdgeNode
+	for _, node := range online {
+		matched := true
+		for key, value := range req.DestinationSelector {
+			if node.Labels[key] != value {
+				matched = false
+				break
+			}
+		}
+		if matched && eligible(node) == nil {
+			matches = append(matches, node)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no eligible node matches the destination selector")
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].ID < matches[j].ID
+	})
+	return matches[0], nil
+}
+
+// runMigration drives a migration: prepare volumes, start the replacement, wait for it to
+// become ready, then tear down the original. The replacement is removed on failure.
+// Rollbacks and image updates replace the stored workload while a migration runs, so it
+// is looked up again each time the lock is taken.
+func (co *CentralOrchestrator) runMigration(m *Migration) {
+	mm := co.MigrationManager
+	ctx := context.Background()
+
+	co.WorkloadManager.mutex.RLock()
+	workload, exists := co.WorkloadManager.workloads[m.WorkloadID]
+	co.WorkloadManager.mutex.RUnlock()
+	if !exists {
+		mm.setPhase(m, MigrationPhaseFailed, "workload was deleted")
+		return
+	}
+
+	mm.mutex.RLock()
+	hooks := append([]VolumeHook(nil), mm.hooks...)
+	mm.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook.PrepareVolumes(ctx, m, workload); err != nil {
+			mm.setPhase(m, MigrationPhaseFailed, fmt.Sprintf("volume preparation failed: %v", err))
+			return
+		}
+	}
+
+	mm.setPhase(m, MigrationPhaseStarting, "")
+	co.WorkloadManager.mutex.Lock()
+	workload, exists = co.WorkloadManager.workloads[m.WorkloadID]
+	if !exists {
+		co.WorkloadManager.mutex.Unlock()
+		mm.setPhase(m, MigrationPhaseFailed, "workload was deleted")
+		return
+	}
+	now := time.Now()
+	if d := workload.deploymentFor(m.DestinationNodeID); d != nil {
+		d.Status = WorkloadStatusPending
+		d.Replicas = m.Replicas
+		d.Endpoints = nil
+		d.Observed = nil
+		d.DeployedAt = now
+		d.UpdatedAt = now
+	} else {
+		workload.Deployments = append(workload.Deployments, WorkloadDeployment{
+			NodeID:     m.DestinationNodeID,
+			Status:     WorkloadStatusPending,
+			Replicas:   m.Replicas,
+			DeployedAt: now,
+			UpdatedAt:  now,
+		})
+	}
+	co.WorkloadManager.mutex.Unlock()
+
+	mm.setPhase(m, MigrationPhaseWaiting, "")
+	if err := co.waitForDeploymentReady(m.WorkloadID, m.DestinationNodeID, m.ReadyTimeout); err != nil {
+		co.WorkloadManager.mutex.Lock()
+		if workload, exists := co.WorkloadManager.workloads[m.WorkloadID]; exists {
+			if d := workload.deploymentFor(m.DestinationNodeID); d != nil && d.placed() {
+				d.Status = WorkloadStatusStopped
+				d.UpdatedAt = time.Now()
+			}
+		}
+		co.WorkloadManager.mutex.Unlock()
+		mm.setPhase(m, MigrationPhaseRolledBack, fmt.Sprintf("replacement did not become ready: %v", err))
+		return
+	}
+
+	mm.setPhase(m, MigrationPhaseTearDown, "")
+	co.WorkloadManager.mutex.Lock()
+	workload, exists = co.WorkloadManager.workloads[m.WorkloadID]
+	if !exists {
+		co.WorkloadManager.mutex.Unlock()
+		mm.setPhase(m, MigrationPhaseFailed, "workload was deleted")
+		return
+	}
+	now = time.Now()
+	if d := workload.deploymentFor(m.SourceNodeID); d != nil {
+		d.Status = WorkloadStatusStopped
+		d.UpdatedAt = now
+	}
+	workload.UpdatedAt = now
+	co.WorkloadManager.mutex.Unlock()
+
+	for _, hook := range hooks {
+		if err := hook.FinalizeVolumes(ctx, m, workload); err != nil {
+			mm.setPhase(m, MigrationPhaseFailed, fmt.Sprintf("volume finalization failed: %v", err))
+			return
+		}
+	}
+
+	mm.setPhase(m, MigrationPhaseCompleted, "")
+}
+
+// waitForDeploymentReady waits until the node's agent reports the deployment available,
+// which marks it running. The workload's readiness probe is part of its pod template, so
+// replicas only count as available once they pass it. It gives up when the agent reports
+// the deployment failed, such as on ImagePullBackOff.
+func (co *CentralOrchestrator) waitForDeploymentReady(workloadID, nodeID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		co.WorkloadManager.mutex.RLock()
+		workload, exists := co.WorkloadManager.workloads[workloadID]
+		var deployment WorkloadDeployment
+		if exists {
+			if d := workload.deploymentFor(nodeID); d != nil {
+				deployment = *d
+			}
+		}
+		co.WorkloadManager.mutex.RUnlock()
+
+		switch {
+		case !exists:
+			return fmt.Errorf("workload was deleted")
+		case !deployment.placed():
+			return fmt.Errorf("deployment was removed from node %s", nodeID)
+		case deployment.Observed != nil && deployment.Observed.Phase == ObservedPhaseFailed:
+			return fmt.Errorf("agent reports the deployment failed: %s", strings.TrimSpace(deployment.Observed.Reason+" "+deployment.Observed.Message))
+		case deployment.Status == WorkloadStatusRunning:
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for the agent to report the deployment available", timeout)
+		}
+		time.Sleep(MigrationReadyPollInterval)
+	}
+}
+
+// GetMigration returns a specific migration
+func (co *CentralOrchestrator) GetMigration(c *gin.Context) {
+	view := co.tenantView(c)
+	co.MigrationManager.mutex.RLock()
+	defer co.MigrationManager.mutex.RUnlock()
+
+	migration, exists := co.MigrationManager.migrations[c.Param("id")]
+	if !exists || !view.workload(migration.WorkloadID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Migration not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"migration": migration})
+}
+
+// ListWorkloadMigrations returns the migrations of a workload
+func (co *CentralOrchestrator) ListWorkloadMigrations(c *gin.Context) {
+	workloadID := c.Param("id")
+
+	co.MigrationManager.mutex.RLock()
+	defer co.MigrationManager.mutex.RUnlock()
+
+	migrations := make([]*Migration, 0)
+	for _, migration := range co.MigrationManager.migrations {
+		if migration.WorkloadID == workloadID {
+			migrations = append(migrations, migration)
+		}
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].StartedAt.After(migrations[j].StartedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"migrations": migrations})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeHeartbeatEntry is one logical node's heartbeat within a batch
+type NodeHeartbeatEntry struct {
+	NodeID    string        `json:"node_id" binding:"required"`
+	Status    NodeStatus    `json:"status"`
+	Resources NodeResources `json:"resources"`
+	Timestamp time.Time     `json:"timestamp"`
+	// Details site gateways forward for their peers
+	Latency       []LatencyMeasurement `json:"latency,omitempty"`
+	LogSummaries  []WorkloadLogSummary `json:"log_summaries,omitempty"`
+	CustomMetrics []CustomMetric       `json:"custom_metrics,omitempty"`
+}
+
+// BatchHeartbeatRequest carries the heartbeats of every cluster a multi-cluster agent
+// manages, or of a site gateway and the peers it forwards for
+type BatchHeartbeatRequest struct {
+	Heartbeats []NodeHeartbeatEntry `json:"heartbeats" binding:"required,dive"`
+	// Set by a site gateway to its own node ID
+	SiteGatewayID string `json:"site_gateway_id,omitempty"`
+}
+
+// BatchHeartbeatResult is the outcome of one heartbeat in a batch. DesiredStateHash lets the
+// agent skip its desired-state request for nodes whose assignments have not changed.
+type BatchHeartbeatResult struct {
+	Accepted         bool   `json:"accepted"`
+	Error            string `json:"error,omitempty"`
+	DesiredStateHash string `json:"desired_state_hash,omitempty"`
+}
+
+// certificateNamesFromContext returns the CN and DNS SANs of the caller's client certificate
+func certificateNamesFromContext(c *gin.Context) []string {
+	names, _ := c.Get(ContextKeyCertNames)
+	certNames, _ := names.([]string)
+	return certNames
+}
+
+// logicalNodeID returns the ID a certificate-authenticated multi-cluster agent registers a
+// cluster under: the certificate's node ID suffixed with the cluster's node name. Only names
+// listed as DNS SANs (not the CN, which is the agent's own identity) qualify.
+func logicalNodeID(c *gin.Context, name string) (string, bool) {
+	certNodeID := c.GetString(ContextKeyNodeID)
+	names := certificateNamesFromContext(c)
+	if certNodeID == "" || name == "" || len(names) < 2 || name == names[0] || !contains(names[1:], name) {
+		return "", false
+	}
+	return certNodeID + "-" + name, true
+}
+
+// certificateMayActAs reports whether the caller's client certificate covers a node, either
+// as the node it was issued for or as one of its logical cluster nodes
+func certificateMayActAs(c *gin.Context, node *EdgeNode) bool {
+	if node.ID == c.GetString(ContextKeyNodeID) {
+		names := certificateNamesFromContext(c)
+		return contains(names, node.ID) || contains(names, node.Name)
+	}
+	logicalID, ok := logicalNodeID(c, node.Name)
+	return ok && logicalID == node.ID
+}
+
+// BatchNodeHeartbeat records heartbeats for several logical nodes in one request, so an
+// agent managing multiple clusters does not need a connection per cluster, and a site's
+// gateway agent can forward the heartbeats of the site's other nodes
+func (co *CentralOrchestrator) BatchNodeHeartbeat(c *gin.Context) {
+	var req BatchHeartbeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	certNodeID := c.GetString(ContextKeyNodeID)
+	if certNodeID == "" && co.SecurityManager.requireClientCerts {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Client certificate required"})
+		return
+	}
+
+	results := make(map[string]BatchHeartbeatResult, len(req.Heartbeats))
+	for _, entry := range req.Heartbeats {
+		co.NodeManager.mutex.RLock()
+		node, exists := co.NodeManager.nodes[entry.NodeID]
+		co.NodeManager.mutex.RUnlock()
+
+		if !exists {
+			results[entry.NodeID] = BatchHeartbeatResult{Error: "Node not found"}
+			continue
+		}
+		transport := HeartbeatTransportHTTPS
+		if req.SiteGatewayID != "" && entry.NodeID != req.SiteGatewayID {
+			if !co.SiteGatewayManager.actsFor(req.SiteGatewayID, node) || (certNodeID != "" && certNodeID != req.SiteGatewayID) {
+				co.Logger.Warnf("Node %s is not the heartbeat gateway of node %s", req.SiteGatewayID, entry.NodeID)
+				results[entry.NodeID] = BatchHeartbeatResult{Error: "Not the site gateway of this node"}
+				continue
+			}
+			transport = HeartbeatTransportSiteGateway
+		} else if certNodeID != "" && !certificateMayActAs(c, node) {
+			co.Logger.Warnf("Node %s attempted to send a heartbeat for node %s", certNodeID, entry.NodeID)
+			results[entry.NodeID] = BatchHeartbeatResult{Error: "Certificate does not belong to this node"}
+			continue
+		}
+
+		heartbeat := HeartbeatRequest{
+			Status:        entry.Status,
+			Resources:     entry.Resources,
+			Timestamp:     entry.Timestamp,
+			Latency:       entry.Latency,
+			LogSummaries:  entry.LogSummaries,
+			CustomMetrics: entry.CustomMetrics,
+		}
+		if !co.applyHeartbeat(entry.NodeID, heartbeat, transport) {
+			results[entry.NodeID] = BatchHeartbeatResult{Error: "Node not found"}
+			continue
+		}
+
+		result := BatchHeartbeatResult{Accepted: true}
+		co.WorkloadManager.mutex.RLock()
+		current, err := co.buildDesiredState(entry.NodeID)
+		co.WorkloadManager.mutex.RUnlock()
+		if err == nil {
+			result.DesiredStateHash = current.hash
+		}
+		results[entry.NodeID] = result
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultNamespace is the namespace of workloads deployed without one
+const DefaultNamespace = "default"
+
+// NamespaceQuota caps what a namespace's workloads may ask for; zero and empty limits are
+// not enforced
+type NamespaceQuota struct {
+	// Total CPU and memory requested by the workloads' replicas, as Kubernetes quantities
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	// Total replicas of the workloads
+	Replicas  int32 `json:"replicas,omitempty"`
+	Workloads int   `json:"workloads,omitempty"`
+}
+
+// Namespace groups workloads under a shared quota. Workloads may still use namespaces
+// that were never created, without a quota.
+type Namespace struct {
+	Name string `json:"name"`
+	// Only workloads of this tenant may be deployed to the namespace, when set
+	Tenant    string            `json:"tenant,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Quota     NamespaceQuota    `json:"quota"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// NamespaceRequest creates a namespace or replaces its tenant, labels and quota
+type NamespaceRequest struct {
+	Name   string            `json:"name"`
+	Tenant string            `json:"tenant"`
+	Labels map[string]string `json:"labels"`
+	Quota  NamespaceQuota    `json:"quota"`
+}
+
+// NamespaceUsage is what a namespace's workloads ask for
+type NamespaceUsage struct {
+	CPU       string `json:"cpu"`
+	Memory    string `json:"memory"`
+	Replicas  int32  `json:"replicas"`
+	Workloads int    `json:"workloads"`
+}
+
+// NamespaceView is a namespace with its usage. Namespaces only used by workloads are
+// listed unmanaged, with no quota.
+type NamespaceView struct {
+	*Namespace
+	Managed bool           `json:"managed"`
+	Usage   NamespaceUsage `json:"usage"`
+}
+
+// NamespaceQuotaError is a change refused for taking a namespace over its quota or
+// deploying into another tenant's namespace
+type NamespaceQuotaError struct {
+	Namespace string
+	Reason    string
+}
+
+func (e *NamespaceQuotaError) Error() string {
+	return fmt.Sprintf("namespace %s: %s", e.Namespace, e.Reason)
+}
+
+// namespaceUsage accumulates the requests of a namespace's workloads
+type namespaceUsage struct {
+	requested ResourceAmounts
+	replicas  int32
+	workloads int
+}
+
+// add counts a workload at a replica count
+func (u namespaceUsage) add(workload *Workload, replicas int32) namespaceUsage {
+	u.requested = u.requested.Add(workloadRequests(workload).Scale(replicas))
+	u.replicas += replicas
+	u.workloads++
+	return u
+}
+
+func (u namespaceUsage) view() NamespaceUsage {
+	return NamespaceUsage{
+		CPU:       resource.NewMilliQuantity(u.requested.MilliCPU, resource.DecimalSI).String(),
+		Memory:    resource.NewQuantity(u.requested.MemoryBytes, resource.BinarySI).String(),
+		Replicas:  u.replicas,
+		Workloads: u.workloads,
+	}
+}
+
+// limits returns the quota's CPU and memory limits; zero fields are not enforced
+func (q NamespaceQuota) limits() ResourceAmounts {
+	var limits ResourceAmounts
+	if quantity, ok := parseQuantity(q.CPU); ok {
+		limits.MilliCPU = quantity.MilliValue()
+	}
+	if quantity, ok := parseQuantity(q.Memory); ok {
+		limits.MemoryBytes = quantity.Value()
+	}
+	return limits
+}
+
+// exceededBy returns why a usage goes over the quota, or ""
+func (q NamespaceQuota) exceededBy(usage namespaceUsage) string {
+	switch exceeded(q.limits(), usage.requested, ResourceAmounts{}) {
+	case "cpu":
+		return fmt.Sprintf("requested CPU %s exceeds the quota of %s", usage.view().CPU, q.CPU)
+	case "memory":
+		return fmt.Sprintf("requested memory %s exceeds the quota of %s", usage.view().Memory, q.Memory)
+	}
+	if q.Replicas > 0 && usage.replicas > q.Replicas {
+		return fmt.Sprintf("%d replicas exceed the quota of %d", usage.replicas, q.Replicas)
+	}
+	if q.Workloads > 0 && usage.workloads > q.Workloads {
+		return fmt.Sprintf("%d workloads exceed the quota of %d", usage.workloads, q.Workloads)
+	}
+	return ""
+}
+
+func (q NamespaceQuota) validate() error {
+	for _, value := range []string{q.CPU, q.Memory} {
+		if _, err := parseWorkloadQuantity(value); err != nil {
+			return fmt.Errorf("invalid quota %q: %v", value, err)
+		}
+	}
+	if q.Replicas < 0 || q.Workloads < 0 {
+		return fmt.Errorf("quota replicas and workloads must not be negative")
+	}
+	return nil
+}
+
+// NamespaceManager keeps the namespaces created through the API
+type NamespaceManager struct {
+	namespaces map[string]*Namespace
+	mutex      sync.RWMutex
+	logger     *logrus.Logger
+}
+
+// NewNamespaceManager creates a new namespace manager
+func NewNamespaceManager(logger *logrus.Logger) *NamespaceManager {
+	return &NamespaceManager{
+		namespaces: make(map[string]*Namespace),
+		logger:     logger,
+	}
+}
+
+// restore loads persisted namespaces
+func (nm *NamespaceManager) restore(namespaces map[string]*Namespace, replace bool) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if replace {
+		nm.namespaces = make(map[string]*Namespace, len(namespaces))
+	}
+	for name, namespace := range namespaces {
+		nm.namespaces[name] = namespace
+	}
+}
+
+// get returns a copy of a namespace, or nil
+func (nm *NamespaceManager) get(name string) *Namespace {
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+
+	namespace, exists := nm.namespaces[name]
+	if !exists {
+		return nil
+	}
+	copied := *namespace
+	return &copied
+}
+
+// workloadNamespace returns the workload's namespace, falling back to the default one
+func workloadNamespace(workload *Workload) string {
+	if workload.Namespace == "" {
+		return DefaultNamespace
+	}
+	return workload.Namespace
+}
+
+// namespaceUsages sums the requests of every namespace's workloads, leaving out the
+// workloads of the given IDs and finished jobs. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) namespaceUsages(excluding ...string) map[string]namespaceUsage {
+	usages := make(map[string]namespaceUsage)
+	for id, workload := range co.WorkloadManager.workloads {
+		if contains(excluding, id) || workload.Status == WorkloadStatusCompleted {
+			continue
+		}
+		namespace := workloadNamespace(workload)
+		usages[namespace] = usages[namespace].add(workload, expectedReplicas(workload))
+	}
+	return usages
+}
+
+// checkNamespaceQuota refuses a workload that would take its namespace over its quota,
+// or that belongs to another tenant than the namespace. The workload is counted in place
+// of those of the replaced IDs, such as the version it rolls out over. Callers must hold
+// the WorkloadManager lock.
+func (co *CentralOrchestrator) checkNamespaceQuota(workload *Workload, replaced ...string) error {
+	name := workloadNamespace(workload)
+	namespace := co.NamespaceManager.get(name)
+	if namespace == nil {
+		return nil
+	}
+	if namespace.Tenant != "" && workloadTenant(workload) != namespace.Tenant {
+		return &NamespaceQuotaError{Namespace: name, Reason: fmt.Sprintf("belongs to tenant %s", namespace.Tenant)}
+	}
+
+	usage := co.namespaceUsages(append(replaced, workload.ID)...)[name].add(workload, expectedReplicas(workload))
+	if reason := namespace.Quota.exceededBy(usage); reason != "" {
+		return &NamespaceQuotaError{Namespace: name, Reason: reason}
+	}
+	return nil
+}
+
+// checkNamespaceScale refuses growing a workload to more replicas than its namespace's
+// quota allows; scaling down is always allowed. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) checkNamespaceScale(workload *Workload, replicas int32) error {
+	if replicas <= workload.Replicas {
+		return nil
+	}
+	scaled := *workload
+	scaled.Replicas = replicas
+	return co.checkNamespaceQuota(&scaled)
+}
+
+// namespaceReplicaLimit returns the most replicas, up to desired, a workload may grow to
+// under its namespace's quota, and never fewer than it has. Callers must hold the
+// WorkloadManager lock.
+func (co *CentralOrchestrator) namespaceReplicaLimit(workload *Workload, desired int32) int32 {
+	for replicas := desired; replicas > workload.Replicas; replicas-- {
+		if co.checkNamespaceScale(workload, replicas) == nil {
+			return replicas
+		}
+	}
+	return workload.Replicas
+}
+
+// workloadErrorStatus is the response status of a refused workload change
+func workloadErrorStatus(err error) int {
+	var quotaErr *NamespaceQuotaError
+	if errors.As(err, &quotaErr) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// namespaceView returns a namespace with its usage
+func namespaceView(namespace *Namespace, usages map[string]namespaceUsage) NamespaceView {
+	return NamespaceView{Namespace: namespace, Managed: !namespace.CreatedAt.IsZero(), Usage: usages[namespace.Name].view()}
+}
+
+// validateNamespaceRequest checks the fields of a namespace request other than its name
+func validateNamespaceRequest(req NamespaceRequest) error {
+	if err := validateLabels(req.Labels); err != nil {
+		return err
+	}
+	return req.Quota.validate()
+}
+
+// validateLabels checks that labels are valid Kubernetes label keys and values
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if problems := validation.IsQualifiedName(key); len(problems) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(problems, "; "))
+		}
+		if problems := validation.IsValidLabelValue(value); len(problems) > 0 {
+			return fmt.Errorf("invalid label value %q: %s", value, strings.Join(problems, "; "))
+		}
+	}
+	return nil
+}
+
+// CreateNamespace creates a namespace, optionally with a quota
+func (co *CentralOrchestrator) CreateNamespace(c *gin.Context) {
+	var req NamespaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if problems := validation.IsDNS1123Label(req.Name); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid namespace name: " + strings.Join(problems, "; ")})
+		return
+	}
+	if err := validateNamespaceRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !tenantAllowed(c, req.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", req.Tenant)})
+		return
+	}
+
+	co.NamespaceManager.mutex.Lock()
+	defer co.NamespaceManager.mutex.Unlock()
+
+	if _, exists := co.NamespaceManager.namespaces[req.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Namespace %s already exists", req.Name)})
+		return
+	}
+	now := time.Now()
+	namespace := &Namespace{
+		Name:      req.Name,
+		Tenant:    req.Tenant,
+		Labels:    req.Labels,
+		Quota:     req.Quota,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	co.NamespaceManager.namespaces[namespace.Name] = namespace
+
+	co.Logger.Infof("Namespace %s created", namespace.Name)
+	co.AuditLog.RecordRequest(c, "namespace.create", "namespace:"+namespace.Name, nil)
+	c.JSON(http.StatusCreated, gin.H{"namespace": namespace})
+}
+
+// ListNamespaces lists created namespaces and those only used by workloads, with what
+// their workloads request
+func (co *CentralOrchestrator) ListNamespaces(c *gin.Context) {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+	co.NamespaceManager.mutex.RLock()
+	defer co.NamespaceManager.mutex.RUnlock()
+
+	usages := co.namespaceUsages()
+	namespaces := make([]NamespaceView, 0, len(co.NamespaceManager.namespaces))
+	for _, namespace := range co.NamespaceManager.namespaces {
+		if namespace.Tenant != "" && !tenantAllowed(c, namespace.Tenant) {
+			continue
+		}
+		namespaces = append(namespaces, namespaceView(namespace, usages))
+	}
+	for name := range usages {
+		if _, managed := co.NamespaceManager.namespaces[name]; !managed {
+			namespaces = append(namespaces, namespaceView(&Namespace{Name: name}, usages))
+		}
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{"namespaces": namespaces})
+}
+
+// GetNamespace returns a namespace with what its workloads request
+func (co *CentralOrchestrator) GetNamespace(c *gin.Context) {
+	name := c.Param("name")
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	usages := co.namespaceUsages()
+	namespace := co.NamespaceManager.get(name)
+	if namespace == nil {
+		if _, used := usages[name]; !used {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Namespace not found"})
+			return
+		}
+		namespace = &Namespace{Name: name}
+	}
+	if namespace.Tenant != "" && !tenantAllowed(c, namespace.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", namespace.Tenant)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"namespace": namespaceView(namespace, usages)})
+}
+
+// UpdateNamespace replaces a namespace's tenant, labels and quota. A quota lowered below
+// the namespace's usage leaves its workloads running but refuses new ones and scale-ups.
+func (co *CentralOrchestrator) UpdateNamespace(c *gin.Context) {
+	var req NamespaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateNamespaceRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.NamespaceManager.mutex.Lock()
+	defer co.NamespaceManager.mutex.Unlock()
+
+	namespace, exists := co.NamespaceManager.namespaces[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Namespace not found"})
+		return
+	}
+	for _, tenant := range []string{namespace.Tenant, req.Tenant} {
+		if !tenantAllowed(c, tenant) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", tenant)})
+			return
+		}
+	}
+	namespace.Tenant = req.Tenant
+	namespace.Labels = req.Labels
+	namespace.Quota = req.Quota
+	namespace.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Namespace %s updated", namespace.Name)
+	co.AuditLog.RecordRequest(c, "namespace.update", "namespace:"+namespace.Name, nil)
+	c.JSON(http.StatusOK, gin.H{"namespace": namespace})
+}
+
+// DeleteNamespace deletes a namespace that no longer has workloads
+func (co *CentralOrchestrator) DeleteNamespace(c *gin.Context) {
+	name := c.Param("name")
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+	co.NamespaceManager.mutex.Lock()
+	defer co.NamespaceManager.mutex.Unlock()
+
+	namespace, exists := co.NamespaceManager.namespaces[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Namespace not found"})
+		return
+	}
+	if !tenantAllowed(c, namespace.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", namespace.Tenant)})
+		return
+	}
+	remaining := 0
+	for _, workload := range co.WorkloadManager.workloads {
+		if workloadNamespace(workload) == name {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Namespace %s still has %d workloads", name, remaining)})
+		return
+	}
+
+	delete(co.NamespaceManager.namespaces, name)
+	co.Logger.Infof("Namespace %s deleted", name)
+	co.AuditLog.RecordRequest(c, "namespace.delete", "namespace:"+name, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Namespace deleted successfully"})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Timeout applied to node commands that do not set one
+	DefaultNodeCommandTimeout = 30 * time.Minute
+)
+
+// NodeCommandStatus represents the progress of a command on a node
+type NodeCommandStatus string
+
+const (
+	NodeCommandPending   NodeCommandStatus = "pending"
+	NodeCommandRunning   NodeCommandStatus = "running"
+	NodeCommandSucceeded NodeCommandStatus = "succeeded"
+	NodeCommandFailed    NodeCommandStatus = "failed"
+)
+
+// NodeCommand is a command queued for an agent to execute on its host
+type NodeCommand struct {
+	ID             string            `json:"id"`
+	NodeID         string            `json:"node_id"`
+	Source         string            `json:"source"`
+	Command        []string          `json:"command"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	Status         NodeCommandStatus `json:"status"`
+	ExitCode       int               `json:"exit_code"`
+	Output         string            `json:"output,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	CompletedAt    *time.Time        `json:"completed_at,omitempty"`
+}
+
+// NodeCommandStatusRequest is an agent's progress report for a node command
+type NodeCommandStatusRequest struct {
+	Status   NodeCommandStatus `json:"status" binding:"required"`
+	ExitCode int               `json:"exit_code"`
+	Output   string            `json:"output"`
+	Error    string            `json:"error"`
+}
+
+// CommandManager is the channel for commands agents run on their hosts
+type CommandManager struct {
+	commands map[string]*NodeCommand
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewCommandManager creates a new command manager
+func NewCommandManager(logger *logrus.Logger) *CommandManager {
+	return &CommandManager{
+		commands: make(map[string]*NodeCommand),
+		logger:   logger,
+	}
+}
+
+// Enqueue queues a command for a node
+func (cm *CommandManager) Enqueue(nodeID, source string, command []string, timeout time.Duration) *NodeCommand {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultNodeCommandTimeout
+	}
+
+	now := time.Now()
+	cmd := &NodeCommand{
+		ID:             generateID(),
+		NodeID:         nodeID,
+		Source:         source,
+		Command:        command,
+		TimeoutSeconds: int(timeout.Seconds()),
+		Status:         NodeCommandPending,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	cm.commands[cmd.ID] = cmd
+
+	cm.logger.Infof("Command %s queued for node %s by %s", cmd.ID, nodeID, source)
+	return cmd
+}
+
+// Get returns a copy of a command
+func (cm *CommandManager) Get(commandID string) (NodeCommand, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	cmd, exists := cm.commands[commandID]
+	if !exists {
+		return NodeCommand{}, false
+	}
+	return *cmd, true
+}
+
+// Cancel fails a command that has not finished
+func (cm *CommandManager) Cancel(commandID, reason string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cmd, exists := cm.commands[commandID]
+	if !exists || cmd.Status == NodeCommandSucceeded || cmd.Status == NodeCommandFailed {
+		return
+	}
+	now := time.Now()
+	cmd.Status = NodeCommandFailed
+	cmd.Error = reason
+	cmd.UpdatedAt = now
+	cmd.CompletedAt = &now
+}
+
+// GetNodeCommands returns the unfinished commands queued for a node, oldest first
+func (co *CentralOrchestrator) GetNodeCommands(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.CommandManager.mutex.RLock()
+	defer co.CommandManager.mutex.RUnlock()
+
+	commands := make([]*NodeCommand, 0)
+	for _, cmd := range co.CommandManager.commands {
+		if cmd.NodeID == nodeID && (cmd.Status == NodeCommandPending || cmd.Status == NodeCommandRunning) {
+			commands = append(commands, cmd)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].CreatedAt.Before(commands[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"commands": commands})
+}
+
+// ReportNodeCommandStatus records an agent's progress on a node command
+func (co *CentralOrchestrator) ReportNodeCommandStatus(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req NodeCommandStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cm := co.CommandManager
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cmd, exists := cm.commands[c.Param("cid")]
+	if !exists || cmd.NodeID != nodeID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node command not found"})
+		return
+	}
+	if cmd.Status == NodeCommandSucceeded || cmd.Status == NodeCommandFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Node command already finished"})
+		return
+	}
+
+	now := time.Now()
+	cmd.Status = req.Status
+	cmd.ExitCode = req.ExitCode
+	cmd.Output = req.Output
+	cmd.Error = req.Error
+	cmd.UpdatedAt = now
+	if req.Status == NodeCommandSucceeded || req.Status == NodeCommandFailed {
+		cmd.CompletedAt = &now
+	}
+
+	if req.Status == NodeCommandFailed {
+		co.Logger.Errorf("Command %s failed on node %s (exit code %d): %s", cmd.ID, nodeID, req.ExitCode, req.Error)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Node command updated"})
+}
+
+// GetNodeCommand returns a specific node command, including its output
+func (co *CentralOrchestrator) GetNodeCommand(c *gin.Context) {
+	cmd, exists := co.CommandManager.Get(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node command not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"command": cmd})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long the replacement device has to register when the request sets no TTL
+	DefaultReplacementTTL = 72 * time.Hour
+)
+
+// NodeReplacementStatus is where a hardware swap is
+type NodeReplacementStatus string
+
+const (
+	NodeReplacementPending   NodeReplacementStatus = "pending"
+	NodeReplacementCompleted NodeReplacementStatus = "completed"
+	NodeReplacementCancelled NodeReplacementStatus = "cancelled"
+)
+
+// NodeReplacementRequest starts swapping a node's hardware
+type NodeReplacementRequest struct {
+	Reason string `json:"reason"`
+	// How long the replacement device has to register, such as "72h"
+	TTL string `json:"ttl"`
+}
+
+// NodeReplacement hands a node's identity to a new device. While it is pending the
+// node's workloads stay assigned to it rather than failing over, and the device that
+// registers with its token takes over the node ID, labels, taints and assignments.
+type NodeReplacement struct {
+	ID     string                `json:"id"`
+	NodeID string                `json:"node_id"`
+	Status NodeReplacementStatus `json:"status"`
+	Reason string                `json:"reason,omitempty"`
+	// Name and address of the device being replaced
+	PreviousName    string `json:"previous_name"`
+	PreviousAddress string `json:"previous_address"`
+	// Name and address of the device that took over
+	ReplacedByName    string `json:"replaced_by_name,omitempty"`
+	ReplacedByAddress string `json:"replaced_by_address,omitempty"`
+	JoinTokenID       string `json:"join_token_id"`
+	// Certificates of the previous device revoked when the replacement completed
+	RevokedCertificates []string   `json:"revoked_certificates,omitempty"`
+	RequestedBy         string     `json:"requested_by"`
+	CreatedAt           time.Time  `json:"created_at"`
+	ExpiresAt           time.Time  `json:"expires_at"`
+	CompletedAt         *time.Time `json:"completed_at,omitempty"`
+}
+
+// ReplacementManager tracks hardware swaps
+type ReplacementManager struct {
+	replacements map[string]*NodeReplacement
+	mutex        sync.RWMutex
+	logger       *logrus.Logger
+}
+
+// NewReplacementManager creates a replacement manager
+func NewReplacementManager(logger *logrus.Logger) *ReplacementManager {
+	return &ReplacementManager{
+		replacements: make(map[string]*NodeReplacement),
+		logger:       logger,
+	}
+}
+
+// pendingFor returns the node's pending replacement; callers must hold the lock
+func (rm *ReplacementManager) pendingFor(nodeID string) *NodeReplacement {
+	for _, replacement := range rm.replacements {
+		if replacement.NodeID == nodeID && replacement.Status == NodeReplacementPending {
+			return replacement
+		}
+	}
+	return nil
+}
+
+// heldNodes returns the nodes whose workloads wait for a replacement device, so
+// failover leaves them in place
+func (rm *ReplacementManager) heldNodes(now time.Time) map[string]bool {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	held := make(map[string]bool)
+	for _, replacement := range rm.replacements {
+		if replacement.Status == NodeReplacementPending && now.Before(replacement.ExpiresAt) {
+			held[replacement.NodeID] = true
+		}
+	}
+	return held
+}
+
+// startReplacement issues a single-use join token that hands the node's identity to the
+// device registering with it. A pending replacement of the node is cancelled, and its
+// token revoked, so only the latest token is valid.
+func (co *CentralOrchestrator) startReplacement(nodeID, actor, reason string, ttl time.Duration) (*NodeReplacement, string, error) {
+	co.NodeManager.mutex.RLock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	var name, address, siteID, region, zone string
+	labels := make(map[string]string)
+	if exists {
+		name, address = node.Name, node.Address
+		siteID, region, zone = node.SiteID, node.Region, node.Zone
+		for key, value := range node.Labels {
+			labels[key] = value
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+	if !exists {
+		return nil, "", fmt.Errorf("node not found")
+	}
+
+	now := time.Now()
+	token, secret := newJoinToken(siteID, region, zone, labels, 1, now.Add(ttl))
+	token.ReplacesNodeID = nodeID
+
+	replacement := &NodeReplacement{
+		ID:              generateID(),
+		NodeID:          nodeID,
+		Status:          NodeReplacementPending,
+		Reason:          reason,
+		PreviousName:    name,
+		PreviousAddress: address,
+		JoinTokenID:     token.ID,
+		RequestedBy:     actor,
+		CreatedAt:       now,
+		ExpiresAt:       token.ExpiresAt,
+	}
+
+	rm := co.ReplacementManager
+	rm.mutex.Lock()
+	previous := rm.pendingFor(nodeID)
+	if previous != nil {
+		previous.Status = NodeReplacementCancelled
+	}
+	rm.replacements[replacement.ID] = replacement
+	rm.mutex.Unlock()
+
+	co.ImageBuilder.mutex.Lock()
+	if previous != nil {
+		if superseded, exists := co.ImageBuilder.tokens[previous.JoinTokenID]; exists && superseded.RevokedAt == nil {
+			superseded.RevokedAt = &now
+		}
+	}
+	co.ImageBuilder.tokens[token.ID] = token
+	co.ImageBuilder.mutex.Unlock()
+
+	co.Logger.Infof("Replacement of node %s (%s) started, valid until %s", name, nodeID, token.ExpiresAt.Format(time.RFC3339))
+	return replacement, secret, nil
+}
+
+// completeReplacement records that a device took over a node and revokes the
+// certificates issued to the device it replaced
+func (co *CentralOrchestrator) completeReplacement(c *gin.Context, node *EdgeNode) {
+	rm := co.ReplacementManager
+	rm.mutex.Lock()
+	replacement := rm.pendingFor(node.ID)
+	if replacement == nil {
+		// The device is re-registering after the replacement completed
+		rm.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	replacement.Status = NodeReplacementCompleted
+	replacement.ReplacedByName = node.Name
+	replacement.ReplacedByAddress = node.Address
+	replacement.CompletedAt = &now
+	startedAt := replacement.CreatedAt
+	rm.mutex.Unlock()
+
+	// Only the previous device holds the keys of certificates issued before the swap
+	var revoke []string
+	co.SecurityManager.mutex.RLock()
+	for id, cert := range co.SecurityManager.certificates {
+		if cert.NodeID == node.ID && cert.RevokedAt == nil && cert.IssuedAt.Before(startedAt) {
+			revoke = append(revoke, id)
+		}
+	}
+	co.SecurityManager.mutex.RUnlock()
+	sort.Strings(revoke)
+
+	var revoked []string
+	for _, id := range revoke {
+		if err := co.SecurityManager.RevokeCertificate(id); err != nil {
+			co.Logger.Warnf("Failed to revoke certificate %s of replaced node %s: %v", id, node.ID, err)
+			continue
+		}
+		revoked = append(revoked, id)
+	}
+
+	rm.mutex.Lock()
+	replacement.RevokedCertificates = revoked
+	view := *replacement
+	rm.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "node.replace.complete", "node:"+node.ID, map[string]string{
+		"previous_name": view.PreviousName,
+		"new_name":      view.ReplacedByName,
+		"replacement":   view.ID,
+	})
+	co.Logger.Infof("Node %s (%s) replaced by device %s, %d certificates revoked",
+		view.PreviousName, node.ID, view.ReplacedByName, len(revoked))
+}
+
+// ReplaceNode starts a hardware swap, returning the join token the replacement device
+// registers with. The token is shown once.
+func (co *CentralOrchestrator) ReplaceNode(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req NodeReplacementRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	ttl := DefaultReplacementTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid ttl %q", req.TTL)})
+			return
+		}
+		ttl = parsed
+	}
+
+	replacement, secret, err := co.startReplacement(nodeID, requestActor(c), req.Reason, ttl)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	co.AuditLog.RecordRequest(c, "node.replace", "node:"+nodeID, map[string]string{
+		"reason":      req.Reason,
+		"replacement": replacement.ID,
+		"join_token":  replacement.JoinTokenID,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"replacement": replacement,
+		"join_token":  secret,
+	})
+}
+
+// CancelNodeReplacement cancels a node's pending replacement and revokes its token;
+// failover resumes for the node's workloads if it is still unavailable
+func (co *CentralOrchestrator) CancelNodeReplacement(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	rm := co.ReplacementManager
+	rm.mutex.Lock()
+	replacement := rm.pendingFor(nodeID)
+	if replacement != nil {
+		replacement.Status = NodeReplacementCancelled
+	}
+	rm.mutex.Unlock()
+
+	if replacement == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node has no pending replacement"})
+		return
+	}
+
+	now := time.Now()
+	co.ImageBuilder.mutex.Lock()
+	if token, exists := co.ImageBuilder.tokens[replacement.JoinTokenID]; exists && token.RevokedAt == nil {
+		token.RevokedAt = &now
+	}
+	co.ImageBuilder.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "node.replace.cancel", "node:"+nodeID, map[string]string{
+		"replacement": replacement.ID,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Replacement cancelled"})
+}
+
+// ListNodeReplacements lists hardware swaps newest first, optionally for one node
+func (co *CentralOrchestrator) ListNodeReplacements(c *gin.Context) {
+	rm := co.ReplacementManager
+	rm.mutex.RLock()
+	replacements := make([]NodeReplacement, 0, len(rm.replacements))
+	for _, replacement := range rm.replacements {
+		if nodeID := c.Query("node"); nodeID != "" && replacement.NodeID != nodeID {
+			continue
+		}
+		if status := c.Query("status"); status != "" && string(replacement.Status) != status {
+			continue
+		}
+		replacements = append(replacements, *replacement)
+	}
+	rm.mutex.RUnlock()
+
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].CreatedAt.After(replacements[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"replacements": replacements})
+}
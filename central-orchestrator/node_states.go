@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Built-in lifecycle state for nodes that take part in normal scheduling
+	NodeStateActive = "active"
+
+	// Timeout for transition webhook calls
+	NodeStateWebhookTimeout = 10 * time.Second
+)
+
+// NodeStateDefinition is an operator-defined node lifecycle state. Lifecycle states are
+// separate from the heartbeat-driven NodeStatus: a node can be online but still awaiting
+// a site survey.
+type NodeStateDefinition struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Schedulable bool     `json:"schedulable"`
+	Transitions []string `json:"transitions"`
+	// Called before a node enters this state; a non-2xx response rejects the transition
+	WebhookURL string    `json:"webhook_url,omitempty"`
+	BuiltIn    bool      `json:"built_in"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// NodeStateRequest creates or updates a node state definition
+type NodeStateRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Schedulable bool     `json:"schedulable"`
+	Transitions []string `json:"transitions"`
+	WebhookURL  string   `json:"webhook_url"`
+}
+
+// NodeStateTransitionRequest moves a node to another state
+type NodeStateTransitionRequest struct {
+	State  string `json:"state" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// nodeStateWebhookPayload is sent to a state's webhook before a node enters it
+type nodeStateWebhookPayload struct {
+	NodeID   string    `json:"node_id"`
+	NodeName string    `json:"node_name"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+}
+
+// NodeStateManager holds the node state definitions
+type NodeStateManager struct {
+	states       map[string]*NodeStateDefinition
+	initialState string
+	httpClient   *http.Client
+	mutex        sync.RWMutex
+	logger       *logrus.Logger
+}
+
+// NewNodeStateManager creates a new node state manager with the built-in active state
+func NewNodeStateManager(logger *logrus.Logger) *NodeStateManager {
+	now := time.Now()
+	nsm := &NodeStateManager{
+		states: map[string]*NodeStateDefinition{
+			NodeStateActive: {
+				Name:        NodeStateActive,
+				Description: "Node takes part in normal scheduling",
+				Schedulable: true,
+				Transitions: []string{},
+				BuiltIn:     true,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			},
+		},
+		initialState: NodeStateActive,
+		httpClient:   &http.Client{Timeout: NodeStateWebhookTimeout},
+		logger:       logger,
+	}
+
+	// Custom states are defined at runtime, so an unknown initial state falls back to active
+	// until it is created
+	if initial := os.Getenv("NODE_INITIAL_STATE"); initial != "" {
+		nsm.initialState = initial
+	}
+
+	return nsm
+}
+
+// InitialState returns the state newly registered nodes start in
+func (nsm *NodeStateManager) InitialState() string {
+	nsm.mutex.RLock()
+	defer nsm.mutex.RUnlock()
+
+	if _, exists := nsm.states[nsm.initialState]; !exists {
+		return NodeStateActive
+	}
+	return nsm.initialState
+}
+
+// Schedulable reports whether nodes in the given state may receive new workloads
+func (nsm *NodeStateManager) Schedulable(state string) bool {
+	nsm.mutex.RLock()
+	defer nsm.mutex.RUnlock()
+
+	definition, exists := nsm.states[state]
+	return exists && definition.Schedulable
+}
+
+// validate checks that a definition's transitions refer to known states; callers must hold the lock
+func (nsm *NodeStateManager) validate(definition *NodeStateDefinition) error {
+	for _, target := range definition.Transitions {
+		if target == definition.Name {
+			return fmt.Errorf("state %s cannot transition to itself", target)
+		}
+		if _, exists := nsm.states[target]; !exists {
+			return fmt.Errorf("transition target %s is not a defined state", target)
+		}
+	}
+	return nil
+}
+
+// callWebhook asks the target state's webhook to approve a transition
+func (nsm *NodeStateManager) callWebhook(definition *NodeStateDefinition, payload nodeStateWebhookPayload) error {
+	if definition.WebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %v", err)
+	}
+
+	resp, err := nsm.httpClient.Post(definition.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to call state webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("state webhook rejected transition with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// nodeSchedulable reports whether a node may receive new workloads
+func (co *CentralOrchestrator) nodeSchedulable(node *EdgeNode) bool {
+	return co.unschedulableReason(node) == ""
+}
+
+// unschedulableReason returns why a node may not receive new workloads, or "" when it may
+func (co *CentralOrchestrator) unschedulableReason(node *EdgeNode) string {
+	switch {
+	case node.Status != NodeStatusOnline:
+		return "node " + string(node.Status)
+	case node.Unschedulable:
+		return "node cordoned"
+	case !co.NodeStateManager.Schedulable(node.State):
+		return "node state " + node.State
+	}
+	return ""
+}
+
+// CreateNodeState defines a new node state
+func (co *CentralOrchestrator) CreateNodeState(c *gin.Context) {
+	var req NodeStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	definition := &NodeStateDefinition{
+		Name:        req.Name,
+		Description: req.Description,
+		Schedulable: req.Schedulable,
+		Transitions: req.Transitions,
+		WebhookURL:  req.WebhookURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if definition.Transitions == nil {
+		definition.Transitions = []string{}
+	}
+
+	nsm := co.NodeStateManager
+	nsm.mutex.Lock()
+	defer nsm.mutex.Unlock()
+
+	if _, exists := nsm.states[definition.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Node state already exists"})
+		return
+	}
+	if err := nsm.validate(definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nsm.states[definition.Name] = definition
+	co.Logger.Infof("Node state %s defined", definition.Name)
+
+	c.JSON(http.StatusCreated, gin.H{"state": definition})
+}
+
+// ListNodeStates returns all node state definitions
+func (co *CentralOrchestrator) ListNodeStates(c *gin.Context) {
+	co.NodeStateManager.mutex.RLock()
+	defer co.NodeStateManager.mutex.RUnlock()
+
+	states := make([]*NodeStateDefinition, 0, len(co.NodeStateManager.states))
+	for _, definition := range co.NodeStateManager.states {
+		states = append(states, definition)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{"states": states})
+}
+
+// UpdateNodeState replaces a node state's description, schedulability, transitions and webhook
+func (co *CentralOrchestrator) UpdateNodeState(c *gin.Context) {
+	var req NodeStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nsm := co.NodeStateManager
+	nsm.mutex.Lock()
+	defer nsm.mutex.Unlock()
+
+	definition, exists := nsm.states[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node state not found"})
+		return
+	}
+	if req.Name != definition.Name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Node states cannot be renamed"})
+		return
+	}
+
+	updated := *definition
+	updated.Description = req.Description
+	updated.Transitions = req.Transitions
+	updated.WebhookURL = req.WebhookURL
+	if !definition.BuiltIn {
+		updated.Schedulable = req.Schedulable
+	}
+	if updated.Transitions == nil {
+		updated.Transitions = []string{}
+	}
+	if err := nsm.validate(&updated); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated.UpdatedAt = time.Now()
+	nsm.states[definition.Name] = &updated
+
+	co.Logger.Infof("Node state %s updated", definition.Name)
+
+	c.JSON(http.StatusOK, gin.H{"state": &updated})
+}
+
+// DeleteNodeState removes a node state that no node is in and no other state transitions to
+func (co *CentralOrchestrator) DeleteNodeState(c *gin.Context) {
+	name := c.Param("name")
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	nsm := co.NodeStateManager
+	nsm.mutex.Lock()
+	defer nsm.mutex.Unlock()
+
+	definition, exists := nsm.states[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node state not found"})
+		return
+	}
+	if definition.BuiltIn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in node states cannot be deleted"})
+		return
+	}
+	for _, node := range co.NodeManager.nodes {
+		if node.State == name {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Node %s is in state %s", node.Name, name)})
+			return
+		}
+	}
+	for _, other := range nsm.states {
+		if contains(other.Transitions, name) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("State %s transitions to %s", other.Name, name)})
+			return
+		}
+	}
+
+	delete(nsm.states, name)
+	co.Logger.Infof("Node state %s deleted", name)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Node state deleted successfully"})
+}
+
+// TransitionNodeState moves a node to another state if the current state allows it
+func (co *CentralOrchestrator) TransitionNodeState(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req NodeStateTransitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	var from, name string
+	if exists {
+		from, name = node.State, node.Name
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	nsm := co.NodeStateManager
+	nsm.mutex.RLock()
+	current, currentExists := nsm.states[from]
+	target, targetExists := nsm.states[req.State]
+	nsm.mutex.RUnlock()
+
+	if !targetExists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("State %s is not defined", req.State)})
+		return
+	}
+	if currentExists && !contains(current.Transitions, req.State) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Transition from %s to %s is not allowed", from, req.State)})
+		return
+	}
+
+	payload := nodeStateWebhookPayload{
+		NodeID:   nodeID,
+		NodeName: name,
+		From:     from,
+		To:       req.State,
+		Reason:   req.Reason,
+		Time:     time.Now(),
+	}
+	if err := nsm.callWebhook(target, payload); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	if node.State != from {
+		c.JSON(http.StatusConflict, gin.H{"error": "Node state changed concurrently"})
+		return
+	}
+
+	now := time.Now()
+	node.State = req.State
+	node.StateReason = req.Reason
+	node.StateChangedAt = now
+	node.UpdatedAt = now
+
+	co.Logger.Infof("Node %s moved from state %s to %s", node.Name, from, req.State)
+
+	c.JSON(http.StatusOK, gin.H{"node": node})
+}
@@ -0,0 +1,749 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// Label on nodes imported from an OCM hub, naming the hub
+	OCMHubLabel = "ocm.edge.io/hub"
+
+	// Cluster claims OCM's cluster claim controller reports for a cluster's location
+	OCMRegionClaim = "region.open-cluster-management.io"
+	OCMZoneClaim   = "zone.open-cluster-management.io"
+)
+
+var (
+	ocmManagedClusterResource = schema.GroupVersionResource{Group: "cluster.open-cluster-management.io", Version: "v1", Resource: "managedclusters"}
+	ocmManifestWorkResource   = schema.GroupVersionResource{Group: "work.open-cluster-management.io", Version: "v1", Resource: "manifestworks"}
+)
+
+// OCMHubRequest connects the orchestrator to an Open Cluster Management hub
+type OCMHubRequest struct {
+	Name   string `json:"name" binding:"required"`
+	SiteID string `json:"site_id"`
+	// Label selector of the managed clusters to import; defaults to every cluster
+	ClusterSelector string `json:"cluster_selector"`
+	ClusterCredentials
+}
+
+// OCMHub is an Open Cluster Management hub whose managed clusters join the fleet as
+// agentless edge nodes. Placement and policy are decided here as for any node; the
+// workloads scheduled to a managed cluster are delivered as ManifestWorks in its namespace
+// on the hub, which OCM's work agent applies, and their status is read back from the
+// ManifestWork's status feedback.
+type OCMHub struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	SiteID string `json:"site_id"`
+	ClusterCredentials
+	AuthMethod      string `json:"auth_method"`
+	Host            string `json:"host"`
+	ClusterSelector string `json:"cluster_selector"`
+	// IDs of the nodes imported on the last sync
+	Nodes        []string  `json:"nodes"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// redacted returns a copy of the hub without its credentials, for API responses
+func (h *OCMHub) redacted() OCMHub {
+	view := *h
+	view.Kubeconfig = ""
+	view.Token = ""
+	view.Nodes = append([]string(nil), h.Nodes...)
+	return view
+}
+
+// nodeID is the ID a managed cluster is imported under: the hub's ID suffixed with the
+// cluster's name, which is also its namespace on the hub
+func (h *OCMHub) nodeID(clusterName string) string {
+	return h.ID + "-" + clusterName
+}
+
+// clusterName returns the name of the managed cluster imported as a node
+func (h *OCMHub) clusterName(nodeID string) string {
+	return strings.TrimPrefix(nodeID, h.ID+"-")
+}
+
+// OCMHubManager keeps OCM hubs and clients for them
+type OCMHubManager struct {
+	hubs    map[string]*OCMHub
+	clients map[string]dynamic.Interface
+	mutex   sync.RWMutex
+	logger  *logrus.Logger
+}
+
+// NewOCMHubManager creates a new OCM hub manager
+func NewOCMHubManager(logger *logrus.Logger) *OCMHubManager {
+	return &OCMHubManager{
+		hubs:    make(map[string]*OCMHub),
+		clients: make(map[string]dynamic.Interface),
+		logger:  logger,
+	}
+}
+
+// restore loads persisted hubs; clients are rebuilt on first use
+func (hm *OCMHubManager) restore(hubs map[string]*OCMHub, replace bool) {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	if replace {
+		hm.hubs = make(map[string]*OCMHub, len(hubs))
+	}
+	for id, hub := range hubs {
+		hm.hubs[id] = hub
+	}
+	hm.clients = make(map[string]dynamic.Interface)
+}
+
+// remove forgets a hub and returns it, or nil if there is none
+func (hm *OCMHubManager) remove(id string) *OCMHub {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	hub := hm.hubs[id]
+	delete(hm.hubs, id)
+	delete(hm.clients, id)
+	return hub
+}
+
+// hub returns a copy of a hub and its client, building it from the stored credentials
+func (hm *OCMHubManager) hub(id string) (OCMHub, dynamic.Interface, error) {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	hub, ok := hm.hubs[id]
+	if !ok {
+		return OCMHub{}, nil, fmt.Errorf("hub %s does not exist", id)
+	}
+	client, ok := hm.clients[id]
+	if !ok {
+		var err error
+		if client, _, err = connectOCMHub(hub.ClusterCredentials); err != nil {
+			return *hub, nil, err
+		}
+		hm.clients[id] = client
+	}
+	return *hub, client, nil
+}
+
+// recordSync notes the outcome of a sync and the nodes it imported
+func (hm *OCMHubManager) recordSync(id string, nodeIDs []string, err error) {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	hub, ok := hm.hubs[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		hub.LastError = err.Error()
+		return
+	}
+	hub.Nodes = nodeIDs
+	hub.LastError = ""
+	hub.LastSyncedAt = time.Now()
+}
+
+// connectOCMHub builds a client for the hub and returns it with the API server host
+func connectOCMHub(cc ClusterCredentials) (dynamic.Interface, string, error) {
+	config, err := cc.restConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create client: %v", err)
+	}
+	return client, config.Host, nil
+}
+
+// listManagedClusters returns the hub's managed clusters matching the selector
+func listManagedClusters(ctx context.Context, client dynamic.Interface, selector string) ([]unstructured.Unstructured, error) {
+	list, err := client.Resource(ocmManagedClusterResource).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list managed clusters: %v", err)
+	}
+	clusters := list.Items
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].GetName() < clusters[j].GetName() })
+	return clusters, nil
+}
+
+// managedClusterStatus maps the cluster's availability, as the hub last heard from its
+// registration agent, to the status an agent would report
+func managedClusterStatus(cluster unstructured.Unstructured) NodeStatus {
+	conditions, _, _ := unstructured.NestedSlice(cluster.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, _ := condition.(map[string]interface{})
+		if condition["type"] == "ManagedClusterConditionAvailable" {
+			if condition["status"] == string(metav1.ConditionTrue) {
+				return NodeStatusOnline
+			}
+			return NodeStatusOffline
+		}
+	}
+	return NodeStatusOffline
+}
+
+// managedClusterResources reports the cluster's allocatable CPU and memory; the hub does
+// not know what is in use
+func managedClusterResources(cluster unstructured.Unstructured) NodeResources {
+	var resources NodeResources
+	allocatable, _, _ := unstructured.NestedStringMap(cluster.Object, "status", "allocatable")
+	if cpu, err := resource.ParseQuantity(allocatable[string(corev1.ResourceCPU)]); err == nil {
+		resources.CPU.Capacity = cpu.String()
+	}
+	if memory, err := resource.ParseQuantity(allocatable[string(corev1.ResourceMemory)]); err == nil {
+		resources.Memory.Capacity = memory.String()
+	}
+	if gpu, err := resource.ParseQuantity(allocatable[ImportedClusterGPUResource]); err == nil {
+		resources.GPUs = int(gpu.Value())
+	}
+	return resources
+}
+
+// managedClusterClaims returns the cluster claims the cluster reports
+func managedClusterClaims(cluster unstructured.Unstructured) map[string]string {
+	claims := make(map[string]string)
+	items, _, _ := unstructured.NestedSlice(cluster.Object, "status", "clusterClaims")
+	for _, item := range items {
+		item, _ := item.(map[string]interface{})
+		name, _ := item["name"].(string)
+		value, _ := item["value"].(string)
+		if name != "" {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// applyManagedClusters imports each managed cluster as an agentless edge node, or
+// refreshes it, and sends its heartbeat; it returns the IDs of the imported nodes
+func (co *CentralOrchestrator) applyManagedClusters(hub OCMHub, clusters []unstructured.Unstructured) []string {
+	now := time.Now()
+	nodeIDs := make([]string, 0, len(clusters))
+	heartbeats := make(map[string]HeartbeatRequest, len(clusters))
+
+	co.NodeManager.mutex.Lock()
+	for _, cluster := range clusters {
+		id := hub.nodeID(cluster.GetName())
+		claims := managedClusterClaims(cluster)
+		node, exists := co.NodeManager.nodes[id]
+		if !exists {
+			node = &EdgeNode{
+				ID:                 id,
+				Name:               cluster.GetName(),
+				Labels:             make(map[string]string),
+				Region:             claims[OCMRegionClaim],
+				Zone:               claims[OCMZoneClaim],
+				SiteID:             hub.SiteID,
+				State:              co.NodeStateManager.InitialState(),
+				StateChangedAt:     now,
+				HeartbeatTransport: HeartbeatTransportKubernetesAPI,
+				Agentless:          true,
+				CreatedAt:          now,
+			}
+			if node.Region == "" {
+				node.Region = "default"
+			}
+			if node.Zone == "" {
+				node.Zone = "default"
+			}
+			co.NodeManager.nodes[id] = node
+			co.Logger.Infof("Imported managed cluster %s from OCM hub %s as node %s", cluster.GetName(), hub.Name, id)
+		}
+
+		// Labels set through the node attributes API are kept
+		for key, value := range cluster.GetLabels() {
+			node.Labels[key] = value
+		}
+		node.Labels[OCMHubLabel] = hub.ID
+		if urls, _, _ := unstructured.NestedSlice(cluster.Object, "spec", "managedClusterClientConfigs"); len(urls) > 0 {
+			if config, ok := urls[0].(map[string]interface{}); ok {
+				node.Address, _ = config["url"].(string)
+			}
+		}
+		node.KubernetesVersion, _, _ = unstructured.NestedString(cluster.Object, "status", "version", "kubernetes")
+		node.UpdatedAt = now
+
+		heartbeats[id] = HeartbeatRequest{Status: managedClusterStatus(cluster), Resources: managedClusterResources(cluster), Timestamp: now}
+		nodeIDs = append(nodeIDs, id)
+	}
+	co.NodeManager.mutex.Unlock()
+
+	for id, heartbeat := range heartbeats {
+		co.applyHeartbeat(id, heartbeat, HeartbeatTransportKubernetesAPI)
+	}
+	return nodeIDs
+}
+
+// manifestWorkName names the ManifestWork delivering a workload; the ID suffix keeps
+// same-named workloads in different namespaces apart
+func manifestWorkName(workload clusterWorkload) string {
+	id := workload.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return workload.Name + "-" + id
+}
+
+// manifestWorkObject wraps the objects an imported cluster would get for the workload in a
+// ManifestWork, with feedback rules reporting the workload object's readiness back to the hub
+func manifestWorkObject(clusterName string, workload clusterWorkload) (*unstructured.Unstructured, error) {
+	object, err := clusterWorkloadObject(workload, clusterTarget{})
+	if err != nil {
+		return nil, err
+	}
+	objects := []runtime.Object{object}
+	if len(workload.Ports) > 0 {
+		objects = append(objects, clusterServiceObject(workload, clusterTarget{}))
+	}
+
+	manifests := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode manifest: %v", err)
+		}
+		manifests = append(manifests, content)
+	}
+
+	meta := object.(metav1.Object)
+	group := object.GetObjectKind().GroupVersionKind().Group
+	resourceName, paths := "deployments", []interface{}{
+		map[string]interface{}{"name": "readyReplicas", "path": ".status.readyReplicas"},
+	}
+	switch workload.Type {
+	case WorkloadTypeStatefulSet:
+		resourceName = "statefulsets"
+	case WorkloadTypeDaemonSet:
+		resourceName, paths = "daemonsets", []interface{}{
+			map[string]interface{}{"name": "desiredReplicas", "path": ".status.desiredNumberScheduled"},
+			map[string]interface{}{"name": "readyReplicas", "path": ".status.numberReady"},
+		}
+	case WorkloadTypeJob:
+		resourceName, paths = "jobs", []interface{}{
+			map[string]interface{}{"name": "succeeded", "path": ".status.succeeded"},
+			map[string]interface{}{"name": "failed", "path": ".status.failed"},
+		}
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": ocmManifestWorkResource.GroupVersion().String(),
+		"kind":       "ManifestWork",
+		"metadata": map[string]interface{}{
+			"name":        manifestWorkName(workload),
+			"namespace":   clusterName,
+			"labels":      map[string]interface{}{ClusterManagedByLabel: ClusterManagedByValue, ClusterWorkloadIDLabel: workload.ID},
+			"annotations": map[string]interface{}{ClusterSpecHashAnnotation: meta.GetAnnotations()[ClusterSpecHashAnnotation]},
+		},
+		"spec": map[string]interface{}{
+			"workload": map[string]interface{}{"manifests": manifests},
+			"manifestConfigs": []interface{}{map[string]interface{}{
+				"resourceIdentifier": map[string]interface{}{
+					"group":     group,
+					"resource":  resourceName,
+					"name":      meta.GetName(),
+					"namespace": meta.GetNamespace(),
+				},
+				"feedbackRules": []interface{}{map[string]interface{}{"type": "JSONPaths", "jsonPaths": paths}},
+			}},
+		},
+	}}, nil
+}
+
+// applyManifestWork creates or updates the workload's ManifestWork; ones whose spec hash
+// matches are left alone
+func applyManifestWork(ctx context.Context, client dynamic.Interface, clusterName string, workload clusterWorkload) (*unstructured.Unstructured, error) {
+	desired, err := manifestWorkObject(clusterName, workload)
+	if err != nil {
+		return nil, err
+	}
+
+	works := client.Resource(ocmManifestWorkResource).Namespace(clusterName)
+	existing, err := works.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return works.Create(ctx, desired, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing.GetAnnotations()[ClusterSpecHashAnnotation] == desired.GetAnnotations()[ClusterSpecHashAnnotation] {
+		return existing, nil
+	}
+	existing.SetLabels(desired.GetLabels())
+	existing.SetAnnotations(desired.GetAnnotations())
+	existing.Object["spec"] = desired.Object["spec"]
+	return works.Update(ctx, existing, metav1.UpdateOptions{})
+}
+
+// manifestWorkStatus derives how the workload is doing from the ManifestWork's conditions
+// and the status feedback of its workload object
+func manifestWorkStatus(work *unstructured.Unstructured, workload clusterWorkload) ObservedWorkloadStatus {
+	observed := ObservedWorkloadStatus{DesiredReplicas: workload.NodeReplicas}
+
+	feedback := make(map[string]int64)
+	manifests, _, _ := unstructured.NestedSlice(work.Object, "status", "resourceStatus", "manifests")
+	for _, manifest := range manifests {
+		manifest, _ := manifest.(map[string]interface{})
+		values, _, _ := unstructured.NestedSlice(manifest, "statusFeedback", "values")
+		for _, value := range values {
+			value, _ := value.(map[string]interface{})
+			name, _ := value["name"].(string)
+			if integer, ok, _ := unstructured.NestedInt64(value, "fieldValue", "integer"); ok {
+				feedback[name] = integer
+			}
+		}
+	}
+	if desired, ok := feedback["desiredReplicas"]; ok {
+		observed.DesiredReplicas = int32(desired)
+	}
+	observed.ReadyReplicas = int32(feedback["readyReplicas"])
+
+	conditions, _, _ := unstructured.NestedSlice(work.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, _ := condition.(map[string]interface{})
+		status, _ := condition["status"].(string)
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		switch {
+		case condition["type"] == "Applied" && status == string(metav1.ConditionFalse):
+			observed.Phase, observed.Reason, observed.Message = ObservedPhaseFailed, "ApplyFailed", message
+		case condition["type"] == "Degraded" && status == string(metav1.ConditionTrue):
+			observed.Phase, observed.Reason, observed.Message = ObservedPhaseFailed, reason, message
+		}
+	}
+	if observed.Phase != "" {
+		return observed
+	}
+
+	if workload.Type == WorkloadTypeJob {
+		observed.ReadyReplicas = int32(feedback["succeeded"])
+		switch {
+		case observed.ReadyReplicas >= observed.DesiredReplicas:
+			observed.Phase = ObservedPhaseCompleted
+		case feedback["failed"] > 0:
+			observed.Phase, observed.Reason = ObservedPhaseFailed, "BackoffLimitExceeded"
+		default:
+			observed.Phase = ObservedPhaseProgressing
+		}
+		return observed
+	}
+	if observed.ReadyReplicas >= observed.DesiredReplicas {
+		observed.Phase = ObservedPhaseAvailable
+	} else {
+		observed.Phase = ObservedPhaseProgressing
+	}
+	return observed
+}
+
+// reconcileManifestWorks delivers the workloads scheduled to a managed cluster as
+// ManifestWorks, records how each is doing and deletes the ones no longer scheduled there;
+// OCM's work agent removes their objects from the cluster
+func (co *CentralOrchestrator) reconcileManifestWorks(ctx context.Context, client dynamic.Interface, nodeID, clusterName string) error {
+	workloads, err := co.clusterAssignments(nodeID)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool, len(workloads))
+	for _, workload := range workloads {
+		desired[workload.ID] = true
+
+		var observed ObservedWorkloadStatus
+		work, err := applyManifestWork(ctx, client, clusterName, workload)
+		if err != nil {
+			co.Logger.Errorf("Failed to apply ManifestWork of workload %s to managed cluster %s: %v", workload.Name, clusterName, err)
+			observed = ObservedWorkloadStatus{Phase: ObservedPhaseFailed, DesiredReplicas: workload.NodeReplicas, Reason: "ApplyFailed", Message: err.Error()}
+		} else {
+			observed = manifestWorkStatus(work, workload)
+		}
+		observed.ObservedAt = time.Now()
+		if err := co.recordWorkloadStatus(nodeID, workload.ID, observed); err != nil {
+			// Rescheduled since the assignments were read
+			co.Logger.Debugf("Dropped status of workload %s on node %s: %v", workload.Name, nodeID, err)
+		}
+	}
+
+	works := client.Resource(ocmManifestWorkResource).Namespace(clusterName)
+	selector := labels.SelectorFromSet(labels.Set{ClusterManagedByLabel: ClusterManagedByValue}).String()
+	list, err := works.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list ManifestWorks: %v", err)
+	}
+	for _, work := range list.Items {
+		id := work.GetLabels()[ClusterWorkloadIDLabel]
+		if desired[id] {
+			continue
+		}
+		co.Logger.Infof("Removing workload %s from managed cluster %s, no longer scheduled there", id, clusterName)
+		if err := works.Delete(ctx, work.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			co.Logger.Errorf("Failed to remove ManifestWork %s from managed cluster %s: %v", work.GetName(), clusterName, err)
+		}
+	}
+	return nil
+}
+
+// deleteManifestWorks deletes every ManifestWork the orchestrator created for a cluster
+func deleteManifestWorks(ctx context.Context, client dynamic.Interface, clusterName string) error {
+	selector := labels.SelectorFromSet(labels.Set{ClusterManagedByLabel: ClusterManagedByValue}).String()
+	err := client.Resource(ocmManifestWorkResource).Namespace(clusterName).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: selector})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// CreateOCMHub connects the orchestrator to an OCM hub and imports its managed clusters
+func (co *CentralOrchestrator) CreateOCMHub(c *gin.Context) {
+	var req OCMHubRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := labels.Parse(req.ClusterSelector); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid cluster_selector: %v", err)})
+		return
+	}
+	authMethod, err := req.authMethod()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, host, err := connectOCMHub(req.ClusterCredentials)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ImportedClusterSyncTimeout)
+	defer cancel()
+	clusters, err := listManagedClusters(ctx, client, req.ClusterSelector)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to read OCM hub at %s: %v", host, err)})
+		return
+	}
+
+	now := time.Now()
+	hub := &OCMHub{
+		ID:                 generateID(),
+		Name:               req.Name,
+		SiteID:             req.SiteID,
+		ClusterCredentials: req.ClusterCredentials,
+		AuthMethod:         authMethod,
+		Host:               host,
+		ClusterSelector:    req.ClusterSelector,
+		CreatedBy:          requestActor(c),
+		CreatedAt:          now,
+		LastSyncedAt:       now,
+	}
+	hub.Nodes = co.applyManagedClusters(*hub, clusters)
+
+	co.OCMHubs.mutex.Lock()
+	co.OCMHubs.hubs[hub.ID] = hub
+	co.OCMHubs.clients[hub.ID] = client
+	view := hub.redacted()
+	co.OCMHubs.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "ocm.create", "ocm:"+hub.ID, map[string]string{
+		"name":        hub.Name,
+		"host":        host,
+		"auth_method": authMethod,
+	})
+	co.Logger.Infof("Connected OCM hub %s at %s with %d managed clusters", hub.Name, host, len(view.Nodes))
+
+	c.JSON(http.StatusCreated, gin.H{"hub": view})
+}
+
+// ListOCMHubs returns OCM hubs, without their credentials
+func (co *CentralOrchestrator) ListOCMHubs(c *gin.Context) {
+	co.OCMHubs.mutex.RLock()
+	defer co.OCMHubs.mutex.RUnlock()
+
+	hubs := make([]OCMHub, 0, len(co.OCMHubs.hubs))
+	for _, hub := range co.OCMHubs.hubs {
+		hubs = append(hubs, hub.redacted())
+	}
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].Name < hubs[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"hubs": hubs})
+}
+
+// GetOCMHub returns one OCM hub, without its credentials
+func (co *CentralOrchestrator) GetOCMHub(c *gin.Context) {
+	co.OCMHubs.mutex.RLock()
+	defer co.OCMHubs.mutex.RUnlock()
+
+	hub, exists := co.OCMHubs.hubs[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hub not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hub": hub.redacted()})
+}
+
+// SyncOCMHub syncs a hub now rather than on its next poll
+func (co *CentralOrchestrator) SyncOCMHub(c *gin.Context) {
+	id := c.Param("id")
+
+	co.OCMHubs.mutex.RLock()
+	_, exists := co.OCMHubs.hubs[id]
+	co.OCMHubs.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hub not found"})
+		return
+	}
+
+	if err := co.syncOCMHub(id); err != nil {
+		co.OCMHubs.recordSync(id, nil, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	var view OCMHub
+	co.OCMHubs.mutex.RLock()
+	if hub, exists := co.OCMHubs.hubs[id]; exists {
+		view = hub.redacted()
+	}
+	co.OCMHubs.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"hub": view})
+}
+
+// DeleteOCMHub disconnects a hub: its ManifestWorks are deleted when it is reachable, so
+// OCM removes the delivered workloads, and its managed clusters leave the fleet
+func (co *CentralOrchestrator) DeleteOCMHub(c *gin.Context) {
+	id := c.Param("id")
+
+	_, client, err := co.OCMHubs.hub(id)
+	hub := co.OCMHubs.remove(id)
+	if hub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hub not found"})
+		return
+	}
+
+	cleanup := "deleted"
+	if err == nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), ImportedClusterSyncTimeout)
+		defer cancel()
+		for _, nodeID := range hub.Nodes {
+			if err = deleteManifestWorks(ctx, client, hub.clusterName(nodeID)); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		cleanup = "left in place: " + err.Error()
+		co.Logger.Warnf("Removed OCM hub %s without deleting its ManifestWorks: %v", hub.Name, err)
+	}
+
+	co.NodeManager.mutex.Lock()
+	for nodeID, node := range co.NodeManager.nodes {
+		if node.Agentless && node.Labels[OCMHubLabel] == id {
+			delete(co.NodeManager.nodes, nodeID)
+			co.DesiredStateCache.forget(nodeID)
+		}
+	}
+	co.NodeManager.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "ocm.remove", "ocm:"+id, map[string]string{
+		"name":      hub.Name,
+		"workloads": cleanup,
+	})
+	co.Logger.Infof("Removed OCM hub %s and its %d managed clusters", hub.Name, len(hub.Nodes))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Hub removed", "workloads": cleanup})
+}
+
+// ocmController syncs every OCM hub on the imported cluster interval
+func (co *CentralOrchestrator) ocmController() {
+	ticker := time.NewTicker(ImportedClusterSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.syncOCMHubs()
+		}
+	}
+}
+
+// syncOCMHubs syncs the hubs concurrently so an unreachable hub does not hold up the rest
+func (co *CentralOrchestrator) syncOCMHubs() {
+	co.OCMHubs.mutex.RLock()
+	ids := make([]string, 0, len(co.OCMHubs.hubs))
+	for id := range co.OCMHubs.hubs {
+		ids = append(ids, id)
+	}
+	co.OCMHubs.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := co.syncOCMHub(id); err != nil {
+				co.OCMHubs.recordSync(id, nil, err)
+				co.Logger.Warnf("Failed to sync OCM hub %s: %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// syncOCMHub refreshes a hub's managed clusters and delivers the workloads scheduled to
+// each. Clusters that are removed from the hub or stop matching the selector get no more
+// heartbeats and go offline, and their ManifestWorks are deleted.
+func (co *CentralOrchestrator) syncOCMHub(id string) error {
+	hub, client, err := co.OCMHubs.hub(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ImportedClusterSyncTimeout)
+	defer cancel()
+
+	clusters, err := listManagedClusters(ctx, client, hub.ClusterSelector)
+	if err != nil {
+		return err
+	}
+	nodeIDs := co.applyManagedClusters(hub, clusters)
+
+	for _, cluster := range clusters {
+		if err := co.reconcileManifestWorks(ctx, client, hub.nodeID(cluster.GetName()), cluster.GetName()); err != nil {
+			co.Logger.Warnf("Failed to deliver workloads to managed cluster %s: %v", cluster.GetName(), err)
+		}
+	}
+	for _, nodeID := range hub.Nodes {
+		if contains(nodeIDs, nodeID) {
+			continue
+		}
+		if err := deleteManifestWorks(ctx, client, hub.clusterName(nodeID)); err != nil {
+			co.Logger.Warnf("Failed to remove ManifestWorks of departed cluster %s: %v", nodeID, err)
+		}
+	}
+
+	co.OCMHubs.recordSync(id, nodeIDs, nil)
+	return nil
+}
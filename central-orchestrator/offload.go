@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Fired while a volume stays above its high watermark after cold data was offloaded
+	VolumeNearlyFullAlert = "VolumeNearlyFull"
+
+	// Defaults for offload policies that leave them unset
+	DefaultOffloadColdAfterHours   = 72
+	DefaultOffloadHighWatermark    = 80
+	DefaultOffloadIntervalMinutes  = 60
+	DefaultOffloadMinFileSizeBytes = 1 << 20
+)
+
+// OffloadPolicy tiers cold files on a workload's volumes to object storage. A run moves
+// every file untouched for ColdAfterHours once a volume is above HighWatermarkPercent.
+type OffloadPolicy struct {
+	// Volumes to manage; empty means every volume of the workload
+	Volumes              []string     `json:"volumes,omitempty"`
+	Target               BackupTarget `json:"target"`
+	ColdAfterHours       int          `json:"cold_after_hours"`
+	HighWatermarkPercent int          `json:"high_watermark_percent"`
+	MinFileSizeBytes     int64        `json:"min_file_size_bytes"`
+	IntervalMinutes      int          `json:"interval_minutes"`
+}
+
+// validate checks the policy against the workload's volumes and applies defaults
+func (p *OffloadPolicy) validate(volumes []WorkloadVolume) error {
+	if len(volumes) == 0 {
+		return fmt.Errorf("offload requires the workload to have volumes")
+	}
+	if p.Target.Type != "s3" || !strings.HasPrefix(p.Target.Location, "s3://") {
+		return fmt.Errorf("offload target must be an s3:// location")
+	}
+	names := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		names = append(names, volume.Name)
+	}
+	for _, name := range p.Volumes {
+		if !contains(names, name) {
+			return fmt.Errorf("offload volume %s is not a volume of the workload", name)
+		}
+	}
+	if len(p.Volumes) == 0 {
+		p.Volumes = names
+	}
+	if p.ColdAfterHours == 0 {
+		p.ColdAfterHours = DefaultOffloadColdAfterHours
+	}
+	if p.HighWatermarkPercent == 0 {
+		p.HighWatermarkPercent = DefaultOffloadHighWatermark
+	}
+	if p.MinFileSizeBytes == 0 {
+		p.MinFileSizeBytes = DefaultOffloadMinFileSizeBytes
+	}
+	if p.IntervalMinutes == 0 {
+		p.IntervalMinutes = DefaultOffloadIntervalMinutes
+	}
+	if p.ColdAfterHours < 0 || p.MinFileSizeBytes < 0 || p.IntervalMinutes < 0 {
+		return fmt.Errorf("offload cold_after_hours, min_file_size_bytes and interval_minutes must not be negative")
+	}
+	if p.HighWatermarkPercent < 1 || p.HighWatermarkPercent > 100 {
+		return fmt.Errorf("offload high_watermark_percent must be between 1 and 100")
+	}
+	return nil
+}
+
+// OffloadedFile is a file moved from an edge volume to object storage
+type OffloadedFile struct {
+	// Relative to the volume root
+	Path        string    `json:"path"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	OffloadedAt time.Time `json:"offloaded_at"`
+	// Object the file can be read back from
+	Location string `json:"location"`
+}
+
+// OffloadManifest tracks what one node has offloaded from one volume of a workload
+type OffloadManifest struct {
+	WorkloadID     string     `json:"workload_id"`
+	WorkloadName   string     `json:"workload_name"`
+	NodeID         string     `json:"node_id"`
+	Volume         string     `json:"volume"`
+	Location       string     `json:"location"`
+	FileCount      int        `json:"file_count"`
+	OffloadedBytes int64      `json:"offloaded_bytes"`
+	UsagePercent   int        `json:"usage_percent"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	files          map[string]*OffloadedFile
+	// Run handed to the agent and not yet reported, so polls return the same run
+	pendingRun string
+}
+
+// recordFiles adds offloaded files to the manifest and refreshes its totals
+func (m *OffloadManifest) recordFiles(files []OffloadedFileReport, now time.Time) {
+	for _, file := range files {
+		m.files[file.Path] = &OffloadedFile{
+			Path:        file.Path,
+			SizeBytes:   file.SizeBytes,
+			ModifiedAt:  file.ModifiedAt,
+			OffloadedAt: now,
+			Location:    m.Location + "/" + file.Path,
+		}
+	}
+	m.refreshTotals()
+}
+
+func (m *OffloadManifest) refreshTotals() {
+	m.FileCount = len(m.files)
+	m.OffloadedBytes = 0
+	for _, file := range m.files {
+		m.OffloadedBytes += file.SizeBytes
+	}
+}
+
+// OffloadRecall brings offloaded files back onto a node's volume
+type OffloadRecall struct {
+	ID         string           `json:"id"`
+	WorkloadID string           `json:"workload_id"`
+	NodeID     string           `json:"node_id"`
+	Volume     string           `json:"volume"`
+	Paths      []string         `json:"paths"`
+	Status     VolumeTaskStatus `json:"status"`
+	Error      string           `json:"error,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// OffloadRecallRequest asks for offloaded files to be restored to a node's volume
+type OffloadRecallRequest struct {
+	NodeID string   `json:"node_id" binding:"required"`
+	Volume string   `json:"volume" binding:"required"`
+	Paths  []string `json:"paths" binding:"required,min=1"`
+}
+
+// OffloadRun is a volume due for an offload run on the polling node
+type OffloadRun struct {
+	RunID                string       `json:"run_id"`
+	WorkloadID           string       `json:"workload_id"`
+	WorkloadName         string       `json:"workload_name"`
+	Namespace            string       `json:"namespace"`
+	Volume               string       `json:"volume"`
+	Target               BackupTarget `json:"target"`
+	Location             string       `json:"location"`
+	ColdAfterHours       int          `json:"cold_after_hours"`
+	HighWatermarkPercent int          `json:"high_watermark_percent"`
+	MinFileSizeBytes     int64        `json:"min_file_size_bytes"`
+}
+
+// OffloadRecallTask is a recall handed to the agent with what it needs to run it
+type OffloadRecallTask struct {
+	*OffloadRecall
+	WorkloadName string       `json:"workload_name"`
+	Namespace    string       `json:"namespace"`
+	Target       BackupTarget `json:"target"`
+	Location     string       `json:"location"`
+}
+
+// OffloadedFileReport is a file an agent moved to object storage
+type OffloadedFileReport struct {
+	Path       string    `json:"path" binding:"required"`
+	SizeBytes  int64     `json:"size_bytes"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// OffloadReport is the outcome of an offload run
+type OffloadReport struct {
+	RunID        string                `json:"run_id" binding:"required"`
+	WorkloadID   string                `json:"workload_id" binding:"required"`
+	Volume       string                `json:"volume" binding:"required"`
+	UsagePercent int                   `json:"usage_percent"`
+	Files        []OffloadedFileReport `json:"files" binding:"dive"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// OffloadManager tracks offload manifests and recalls
+type OffloadManager struct {
+	manifests map[string]*OffloadManifest
+	recalls   map[string]*OffloadRecall
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewOffloadManager creates a new offload manager
+func NewOffloadManager(logger *logrus.Logger) *OffloadManager {
+	return &OffloadManager{
+		manifests: make(map[string]*OffloadManifest),
+		recalls:   make(map[string]*OffloadRecall),
+		logger:    logger,
+	}
+}
+
+// offloadKey identifies one node's copy of a workload volume
+func offloadKey(workloadID, nodeID, volume string) string {
+	return workloadID + "/" + nodeID + "/" + volume
+}
+
+// offloadLocation is where a node's offloaded files for a volume are stored. Every node
+// gets its own prefix since each holds its own copy of the volume.
+func offloadLocation(workload *Workload, nodeID, volume string) string {
+	location := strings.TrimSuffix(workload.Offload.Target.Location, "/")
+	return fmt.Sprintf("%s/offload/%s/%s/%s/%s", location, workload.Namespace, workload.Name, nodeID, volume)
+}
+
+// manifest returns the manifest for a node's copy of a workload volume, creating it;
+// callers must hold the lock
+func (om *OffloadManager) manifest(workload *Workload, nodeID, volume string) *OffloadManifest {
+	key := offloadKey(workload.ID, nodeID, volume)
+	manifest, exists := om.manifests[key]
+	if !exists {
+		manifest = &OffloadManifest{
+			WorkloadID:   workload.ID,
+			WorkloadName: workload.Name,
+			NodeID:       nodeID,
+			Volume:       volume,
+			Location:     offloadLocation(workload, nodeID, volume),
+			files:        make(map[string]*OffloadedFile),
+		}
+		om.manifests[key] = manifest
+	}
+	return manifest
+}
+
+// offloadWorkloads returns copies of the offload-enabled workloads running on a node
+func (co *CentralOrchestrator) offloadWorkloads(nodeID string) []Workload {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	var workloads []Workload
+	for _, workload := range co.WorkloadManager.workloads {
+		if workload.Offload == nil || workload.Status != WorkloadStatusRunning {
+			continue
+		}
+		if deployment := workload.deploymentFor(nodeID); deployment != nil && deployment.Status == WorkloadStatusRunning {
+			workloads = append(workloads, *workload)
+		}
+	}
+	return workloads
+}
+
+// GetNodeOffloadTasks returns the volumes due for an offload run on a node and any
+// pending recalls
+func (co *CentralOrchestrator) GetNodeOffloadTasks(c *gin.Context) {
+	nodeID := c.Param("id")
+	workloads := co.offloadWorkloads(nodeID)
+	now := time.Now()
+
+	om := co.OffloadManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	runs := make([]OffloadRun, 0)
+	byID := make(map[string]*Workload, len(workloads))
+	for i := range workloads {
+		workload := &workloads[i]
+		byID[workload.ID] = workload
+		policy := workload.Offload
+		for _, volume := range policy.Volumes {
+			manifest := om.manifest(workload, nodeID, volume)
+			interval := time.Duration(policy.IntervalMinutes) * time.Minute
+			if manifest.pendingRun == "" && manifest.LastRunAt != nil && now.Sub(*manifest.LastRunAt) < interval {
+				continue
+			}
+			if manifest.pendingRun == "" {
+				manifest.pendingRun = generateID()
+			}
+			runs = append(runs, OffloadRun{
+				RunID:                manifest.pendingRun,
+				WorkloadID:           workload.ID,
+				WorkloadName:         workload.Name,
+				Namespace:            workload.Namespace,
+				Volume:               volume,
+				Target:               policy.Target,
+				Location:             manifest.Location,
+				ColdAfterHours:       policy.ColdAfterHours,
+				HighWatermarkPercent: policy.HighWatermarkPercent,
+				MinFileSizeBytes:     policy.MinFileSizeBytes,
+			})
+		}
+	}
+
+	recalls := make([]OffloadRecallTask, 0)
+	for _, recall := range om.recalls {
+		if recall.NodeID != nodeID || (recall.Status != VolumeTaskPending && recall.Status != VolumeTaskRunning) {
+			continue
+		}
+		workload, exists := byID[recall.WorkloadID]
+		if !exists {
+			continue
+		}
+		recalls = append(recalls, OffloadRecallTask{
+			OffloadRecall: recall,
+			WorkloadName:  workload.Name,
+			Namespace:     workload.Namespace,
+			Target:        workload.Offload.Target,
+			Location:      om.manifest(workload, nodeID, recall.Volume).Location,
+		})
+	}
+	sort.Slice(recalls, func(i, j int) bool {
+		return recalls[i].CreatedAt.Before(recalls[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs, "recalls": recalls})
+}
+
+// ReportOffloadRun records the files an offload run moved and the volume usage it left.
+// A volume still above its high watermark has too little cold data to offload, which
+// raises an alert.
+func (co *CentralOrchestrator) ReportOffloadRun(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req OffloadReport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	var policy OffloadPolicy
+	var workloadName string
+	workload, exists := co.WorkloadManager.workloads[req.WorkloadID]
+	if exists && workload.Offload != nil {
+		policy, workloadName = *workload.Offload, workload.Name
+	}
+	co.WorkloadManager.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	om := co.OffloadManager
+	om.mutex.Lock()
+	manifest, exists := om.manifests[offloadKey(req.WorkloadID, nodeID, req.Volume)]
+	if !exists || manifest.pendingRun != req.RunID {
+		om.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offload run not found"})
+		return
+	}
+	now := time.Now()
+	manifest.pendingRun = ""
+	manifest.LastRunAt = &now
+	manifest.LastError = req.Error
+	manifest.UsagePercent = req.UsagePercent
+	manifest.recordFiles(req.Files, now)
+	om.mutex.Unlock()
+
+	if len(req.Files) > 0 {
+		co.Logger.Infof("Node %s offloaded %d files from volume %s of workload %s", nodeID, len(req.Files), req.Volume, workloadName)
+	}
+
+	scopeID := offloadKey(req.WorkloadID, nodeID, req.Volume)
+	if req.Error == "" && policy.HighWatermarkPercent > 0 && req.UsagePercent >= policy.HighWatermarkPercent {
+		co.AlertManager.Fire(VolumeNearlyFullAlert, AlertSeverityWarning, AlertScopeVolume, scopeID, "",
+			newMessage(MsgVolumeNearlyFull, "volume", req.Volume, "workload", workloadName, "node", nodeID, "usage", req.UsagePercent))
+	} else if req.Error == "" {
+		co.AlertManager.Resolve(VolumeNearlyFullAlert, AlertScopeVolume, scopeID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Offload run recorded"})
+}
+
+// ReportOffloadRecallStatus records an agent's progress on a recall. Recalled files are
+// back on the volume and leave the manifest.
+func (co *CentralOrchestrator) ReportOffloadRecallStatus(c *gin.Context) {
+	var req VolumeTaskStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	om := co.OffloadManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	recall, exists := om.recalls[c.Param("rid")]
+	if !exists || recall.NodeID != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recall not found"})
+		return
+	}
+	recall.Status = req.Status
+	recall.Error = req.Error
+	recall.UpdatedAt = time.Now()
+
+	if req.Status == VolumeTaskCompleted {
+		if manifest, exists := om.manifests[offloadKey(recall.WorkloadID, recall.NodeID, recall.Volume)]; exists {
+			for _, path := range recall.Paths {
+				delete(manifest.files, path)
+			}
+			manifest.refreshTotals()
+		}
+		om.logger.Infof("Recalled %d files to volume %s on node %s", len(recall.Paths), recall.Volume, recall.NodeID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recall": recall})
+}
+
+// GetWorkloadOffload summarizes what each node has offloaded from a workload's volumes
+func (co *CentralOrchestrator) GetWorkloadOffload(c *gin.Context) {
+	workloadID := c.Param("id")
+
+	co.WorkloadManager.mutex.RLock()
+	workload, exists := co.WorkloadManager.workloads[workloadID]
+	var policy *OffloadPolicy
+	if exists {
+		policy = workload.Offload
+	}
+	co.WorkloadManager.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	co.OffloadManager.mutex.RLock()
+	defer co.OffloadManager.mutex.RUnlock()
+
+	manifests := make([]OffloadManifest, 0)
+	for _, manifest := range co.OffloadManager.manifests {
+		if manifest.WorkloadID == workloadID {
+			manifests = append(manifests, *manifest)
+		}
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		if manifests[i].NodeID != manifests[j].NodeID {
+			return manifests[i].NodeID < manifests[j].NodeID
+		}
+		return manifests[i].Volume < manifests[j].Volume
+	})
+	recalls := make([]*OffloadRecall, 0)
+	for _, recall := range co.OffloadManager.recalls {
+		if recall.WorkloadID == workloadID {
+			recalls = append(recalls, recall)
+		}
+	}
+	sort.Slice(recalls, func(i, j int) bool {
+		return recalls[i].CreatedAt.After(recalls[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"policy": policy, "manifests": manifests, "recalls": recalls})
+}
+
+// ListOffloadedFiles lists the files offloaded from a workload's volumes with the object
+// each can be read from, optionally limited to a node, volume and path prefix
+func (co *CentralOrchestrator) ListOffloadedFiles(c *gin.Context) {
+	workloadID := c.Param("id")
+	nodeID, volume, prefix := c.Query("node"), c.Query("volume"), c.Query("prefix")
+
+	type fileView struct {
+		NodeID string `json:"node_id"`
+		Volume string `json:"volume"`
+		*OffloadedFile
+	}
+
+	co.OffloadManager.mutex.RLock()
+	defer co.OffloadManager.mutex.RUnlock()
+
+	files := make([]fileView, 0)
+	for _, manifest := range co.OffloadManager.manifests {
+		if manifest.WorkloadID != workloadID || (nodeID != "" && manifest.NodeID != nodeID) || (volume != "" && manifest.Volume != volume) {
+			continue
+		}
+		for _, file := range manifest.files {
+			if strings.HasPrefix(file.Path, prefix) {
+				files = append(files, fileView{NodeID: manifest.NodeID, Volume: manifest.Volume, OffloadedFile: file})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Location < files[j].Location
+	})
+
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// RecallOffloadedFiles queues offloaded files to be copied back onto a node's volume,
+// for data a workload needs locally again
+func (co *CentralOrchestrator) RecallOffloadedFiles(c *gin.Context) {
+	workloadID := c.Param("id")
+
+	var req OffloadRecallRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	om := co.OffloadManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	manifest, exists := om.manifests[offloadKey(workloadID, req.NodeID, req.Volume)]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nothing has been offloaded from this volume on this node"})
+		return
+	}
+	for _, path := range req.Paths {
+		if _, offloaded := manifest.files[path]; !offloaded {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File is not offloaded: " + path})
+			return
+		}
+	}
+
+	now := time.Now()
+	recall := &OffloadRecall{
+		ID:         generateID(),
+		WorkloadID: workloadID,
+		NodeID:     req.NodeID,
+		Volume:     req.Volume,
+		Paths:      req.Paths,
+		Status:     VolumeTaskPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	om.recalls[recall.ID] = recall
+	om.logger.Infof("Recall of %d files to volume %s queued on node %s", len(req.Paths), req.Volume, req.NodeID)
+
+	c.JSON(http.StatusAccepted, gin.H{"recall": recall})
+}
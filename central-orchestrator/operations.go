@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// OperationStatus represents the status of a long-running operation
+type OperationStatus string
+
+const (
+	OperationStatusRunning   OperationStatus = "running"
+	OperationStatusSucceeded OperationStatus = "succeeded"
+	OperationStatusPartial   OperationStatus = "partial"
+	OperationStatusFailed    OperationStatus = "failed"
+)
+
+// Operation records an orchestrator-driven action and the ordered steps it took
+type Operation struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Target      string          `json:"target"`
+	Status      OperationStatus `json:"status"`
+	Message     string          `json:"message,omitempty"`
+	Steps       []OperationStep `json:"steps"`
+	StartedAt   time.Time       `json:"started_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// OperationStep is a single action taken as part of an operation
+type OperationStep struct {
+	Order      int               `json:"order"`
+	Action     string            `json:"action"`
+	WorkloadID string            `json:"workload_id,omitempty"`
+	NodeID     string            `json:"node_id,omitempty"`
+	Code       MessageCode       `json:"code,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Message    string            `json:"message"`
+	Success    bool              `json:"success"`
+	Timestamp  time.Time         `json:"timestamp"`
+}
+
+// OperationManager keeps a history of operations
+type OperationManager struct {
+	operations map[string]*Operation
+	mutex      sync.RWMutex
+	logger     *logrus.Logger
+}
+
+// NewOperationManager creates a new operation manager
+func NewOperationManager(logger *logrus.Logger) *OperationManager {
+	return &OperationManager{
+		operations: make(map[string]*Operation),
+		logger:     logger,
+	}
+}
+
+// Start records a new running operation
+func (om *OperationManager) Start(opType, target string) *Operation {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	op := &Operation{
+		ID:        generateID(),
+		Type:      opType,
+		Target:    target,
+		Status:    OperationStatusRunning,
+		Steps:     make([]OperationStep, 0),
+		StartedAt: time.Now(),
+	}
+	om.operations[op.ID] = op
+
+	om.logger.Infof("Operation %s (%s) started for %s", op.ID, opType, target)
+	return op
+}
+
+// newOperationStep builds a step whose message is rendered from a coded message
+func newOperationStep(action, workloadID, nodeID string, success bool, message Message) OperationStep {
+	return OperationStep{
+		Action:     action,
+		WorkloadID: workloadID,
+		NodeID:     nodeID,
+		Code:       message.Code,
+		Params:     message.Params,
+		Message:    message.String(),
+		Success:    success,
+	}
+}
+
+// localizeOperation returns a copy of an operation with step messages rendered in the given
+// locale; callers must hold the lock
+func (co *CentralOrchestrator) localizeOperation(op *Operation, locale string) *Operation {
+	copied := *op
+	copied.Steps = make([]OperationStep, len(op.Steps))
+	for i, step := range op.Steps {
+		if step.Code != "" {
+			step.Message = co.MessageCatalog.Render(Message{Code: step.Code, Params: step.Params}, locale)
+		}
+		copied.Steps[i] = step
+	}
+	return &copied
+}
+
+// AddStep appends a step to an operation
+func (om *OperationManager) AddStep(op *Operation, step OperationStep) {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	step.Order = len(op.Steps) + 1
+	step.Timestamp = time.Now()
+	op.Steps = append(op.Steps, step)
+}
+
+// Complete marks an operation finished with the given status
+func (om *OperationManager) Complete(op *Operation, status OperationStatus, message string) {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	now := time.Now()
+	op.Status = status
+	op.Message = message
+	op.CompletedAt = &now
+
+	om.logger.Infof("Operation %s (%s) completed with status %s", op.ID, op.Type, status)
+}
+
+// visibleOperation returns the part of an operation the caller may see: nil when its target
+// node or workload is hidden, and otherwise the operation without steps about hidden ones.
+// The operation is already a copy.
+func (v *tenantView) visibleOperation(op *Operation) *Operation {
+	if v == nil {
+		return op
+	}
+	kind, ids, _ := strings.Cut(op.Target, " ")
+	visible := false
+	for _, id := range strings.Split(ids, ",") {
+		if (kind == "workload" && v.workload(id)) || ((kind == "node" || kind == "nodes") && v.node(id)) {
+			visible = true
+		}
+	}
+	if !visible {
+		return nil
+	}
+
+	steps := make([]OperationStep, 0, len(op.Steps))
+	for _, step := range op.Steps {
+		if (step.WorkloadID == "" || v.workload(step.WorkloadID)) && (step.NodeID == "" || v.node(step.NodeID)) {
+			steps = append(steps, step)
+		}
+	}
+	op.Steps = steps
+	return op
+}
+
+// ListOperations returns operations, optionally filtered by type and status
+func (co *CentralOrchestrator) ListOperations(c *gin.Context) {
+	view := co.tenantView(c)
+	co.OperationManager.mutex.RLock()
+	defer co.OperationManager.mutex.RUnlock()
+
+	operations := make([]*Operation, 0)
+	for _, op := range co.OperationManager.operations {
+		if opType := c.Query("type"); opType != "" && op.Type != opType {
+			continue
+		}
+		if status := c.Query("status"); status != "" && string(op.Status) != status {
+			continue
+		}
+		if visible := view.visibleOperation(co.localizeOperation(op, requestLocale(c))); visible != nil {
+			operations = append(operations, visible)
+		}
+	}
+
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].StartedAt.After(operations[j].StartedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"operations": operations})
+}
+
+// GetOperation returns a specific operation
+func (co *CentralOrchestrator) GetOperation(c *gin.Context) {
+	view := co.tenantView(c)
+	co.OperationManager.mutex.RLock()
+	defer co.OperationManager.mutex.RUnlock()
+
+	var visible *Operation
+	if op, exists := co.OperationManager.operations[c.Param("id")]; exists {
+		visible = view.visibleOperation(co.localizeOperation(op, requestLocale(c)))
+	}
+	if visible == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Operation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"operation": visible})
+}
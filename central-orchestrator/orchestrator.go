@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -29,10 +30,39 @@ func NewWorkloadManager(logger *logrus.Logger) *WorkloadManager {
 
 // NewSecurityManager creates a new security manager
 func NewSecurityManager(logger *logrus.Logger) *SecurityManager {
-	return &SecurityManager{
-		certificates: make(map[string]*Certificate),
-		logger:       logger,
+	sm := &SecurityManager{
+		certificates:       make(map[string]*Certificate),
+		fingerprints:       make(map[string]string),
+		logger:             logger,
+		requireClientCerts: os.Getenv("REQUIRE_CLIENT_CERTIFICATES") == "true",
+		replicaToken:       os.Getenv("LEADER_ELECTION_REPLICA_TOKEN"),
 	}
+
+	sm.signer, sm.signerErr = newCertificateSigner(logger)
+	if sm.signerErr != nil {
+		logger.Errorf("Certificate issuance disabled: %v", sm.signerErr)
+	} else {
+		logger.Infof("Issuing node certificates with the %s signer", sm.signer.Name())
+	}
+
+	if path := os.Getenv("ACCESS_POLICY_FILE"); path != "" {
+		policy, err := loadAccessPolicy(path)
+		if err != nil {
+			logger.Fatalf("Failed to load access policy from %s: %v", path, err)
+		}
+		sm.policy = policy
+		logger.Infof("Loaded access policy with %d tokens and %d role bindings", len(policy.Tokens), len(policy.Bindings))
+	}
+
+	if sm.jwtKeysFile = os.Getenv("JWT_KEYS_FILE"); sm.jwtKeysFile != "" {
+		if err := sm.loadJWTKeys(); err != nil {
+			logger.Fatalf("Failed to load JWT keys from %s: %v", sm.jwtKeysFile, err)
+		}
+	}
+	if !sm.enforcesRoles() {
+		logger.Warn("Neither ACCESS_POLICY_FILE nor JWT_KEYS_FILE is set; any bearer token gets full access")
+	}
+	return sm
 }
 
 // NewMonitoringService creates a new monitoring service
@@ -53,6 +83,87 @@ func (co *CentralOrchestrator) StartBackgroundServices() {
 	
 	// Start metrics collector
 	go co.metricsCollector()
+
+	// Start metrics store compactor
+	go co.metricsCompactor()
+
+	// Start DNS reconciler
+	go co.dnsReconciler()
+
+	// Start site health monitor
+	go co.siteHealthMonitor()
+
+	// Start failover controller
+	go co.failoverController()
+
+	// Start cloud node controller
+	go co.cloudNodeController()
+
+	// Start backup scheduler
+	go co.backupScheduler()
+
+	// Start SLA monitor
+	go co.slaMonitor()
+
+	// Start UDP heartbeat listener
+	go co.serveUDPHeartbeats()
+
+	// Start workload expirer
+	go co.workloadExpirer()
+
+	// Start firmware campaign controller
+	go co.campaignController()
+
+	// Start OS patch campaign controller
+	go co.patchController()
+
+	// Start port-forward session reaper
+	go co.portForwardReaper()
+
+	// Start ACME janitor
+	go co.acmeJanitor()
+
+	// Start load forecaster
+	go co.loadForecaster()
+
+	// Start predictive autoscaler
+	go co.predictiveAutoscaler()
+
+	// Start federated job controller
+	go co.federatedController()
+
+	// Start function runtime controller
+	go co.functionRuntimeController()
+
+	// Start site TSDB controller
+	go co.tsdbController()
+
+	// Start imported cluster controller
+	go co.importedClusterController()
+
+	// Start KubeEdge and Akri interop controller
+	go co.interopController()
+
+	// Start OCM hub controller
+	go co.ocmController()
+
+	// Start cron job controller
+	go co.cronController()
+
+	// Start blue-green deployment controller
+	go co.blueGreenController()
+
+	// Start state consistency checker
+	go co.consistencyController()
+
+	// Start site gateway election
+	go co.siteGatewayController()
+
+	// Start heartbeat lease renewal
+	go co.heartbeatLeaseLoop()
+
+	// Start incident sync
+	go co.incidentController()
 }
 
 // nodeHealthChecker checks node health periodically
@@ -74,8 +185,9 @@ func (co *CentralOrchestrator) checkNodeHealth() {
 	defer co.NodeManager.mutex.RUnlock()
 
 	for _, node := range co.NodeManager.nodes {
-		if time.Since(node.LastHeartbeat) > 2*time.Minute {
-			if node.Status != NodeStatusOffline {
+		if time.Since(node.LastHeartbeat) > node.offlineTimeout() {
+			// Nodes in maintenance may be switched off
+			if node.Status != NodeStatusOffline && node.Status != NodeStatusMaintenance {
 				co.Logger.Warnf("Node %s (%s) is offline", node.Name, node.ID)
 				node.Status = NodeStatusOffline
 				node.UpdatedAt = time.Now()
@@ -102,129 +214,173 @@ func (co *CentralOrchestrator) scheduleWorkloads() {
 	co.WorkloadManager.mutex.Lock()
 	defer co.WorkloadManager.mutex.Unlock()
 
-	for _, workload := range co.WorkloadManager.workloads {
-		if workload.Status == WorkloadStatusPending {
-			co.Logger.Infof("Scheduling workload %s", workload.Name)
-			if err := co.scheduleWorkload(workload); err != nil {
-				co.Logger.Errorf("Failed to schedule workload %s: %v", workload.Name, err)
-			}
-		}
-	}
+	// Serve tenants by weighted fair share rather than map iteration order
+	co.scheduleFairly(time.Now())
+
+	// Push new assignments to connected agents without waiting for the next tick
+	co.AgentStreamHub.wake()
 }
 
-// scheduleWorkload schedules a specific workload based on placement policy
+// scheduleWorkload brings a workload to its replica count, spreading the replicas over the
+// nodes its placement policy selects. Replicas already placed stay where they are.
 func (co *CentralOrchestrator) scheduleWorkload(workload *Workload) error {
-	nodes := co.selectNodesForWorkload(workload)
-	if len(nodes) == 0 {
-		return fmt.Errorf("no suitable nodes found for workload %s", workload.Name)
-	}
-
-	// Deploy to selected nodes
-	for _, node := range nodes {
-		deployment := WorkloadDeployment{
-			NodeID:     node.ID,
-			Status:     WorkloadStatusRunning,
-			Replicas:   1, // For now, deploy 1 replica per node
-			DeployedAt: time.Now(),
-			UpdatedAt:  time.Now(),
+	plan := co.planReplicas(workload)
+
+	now := time.Now()
+	for nodeID, replicas := range plan {
+		deployment := workload.deploymentFor(nodeID)
+		switch {
+		case deployment != nil && deployment.placed():
+			if replicas == deployment.Replicas {
+				continue
+			}
+			switch {
+			case replicas == 0:
+				deployment.Status = WorkloadStatusStopped
+			case replicas > deployment.Replicas:
+				// Pending again until the agent reports the added replicas ready
+				deployment.Status = WorkloadStatusPending
+			}
+			deployment.Replicas = replicas
+			deployment.UpdatedAt = now
+		case replicas > 0:
+			// Each new deployment stays pending until its agent reports it available
+			workload.addDeployment(nodeID, replicas)
 		}
-		workload.Deployments = append(workload.Deployments, deployment)
+	}
+	workload.UpdatedAt = now
+
+	desired, placed := expectedReplicas(workload), workload.scheduledReplicas()
+	if placed == 0 {
+		workload.Status = WorkloadStatusPending
+		summary := co.setSchedulingCondition(workload, placed, desired, now)
+		return fmt.Errorf("no suitable nodes found for workload %s: %s", workload.Name, summary)
+	}
+	if placed < desired {
+		// Stays pending so later passes place the rest
+		workload.Status = WorkloadStatusPending
+		summary := co.setSchedulingCondition(workload, placed, desired, now)
+		return fmt.Errorf("only %d of %d replicas of workload %s could be placed: %s", placed, desired, workload.Name, summary)
 	}
 
+	workload.Scheduling = nil
 	workload.Status = WorkloadStatusRunning
-	workload.UpdatedAt = time.Now()
-	
-	co.Logger.Infof("Workload %s scheduled to %d nodes", workload.Name, len(nodes))
+	nodes := 0
+	for _, replicas := range plan {
+		if replicas > 0 {
+			nodes++
+		}
+	}
+	co.Logger.Infof("Workload %s scheduled with %d replicas on %d nodes", workload.Name, placed, nodes)
 	return nil
 }
 
-// selectNodesForWorkload selects appropriate nodes based on placement policy
-func (co *CentralOrchestrator) selectNodesForWorkload(workload *Workload) []*EdgeNode {
+// planReplicas returns the replicas of a workload each node should run. Missing replicas
+// are added one at a time to the selected node running the fewest, the best ranked first,
+// so they spread evenly; surplus ones are removed from the node running the most, the
+// worst ranked first. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) planReplicas(workload *Workload) map[string]int32 {
 	co.NodeManager.mutex.RLock()
 	defer co.NodeManager.mutex.RUnlock()
 
-	var candidates []*EdgeNode
-	
-	// Filter nodes based on constraints
-	for _, node := range co.NodeManager.nodes {
-		if node.Status == NodeStatusOnline && co.nodeMatchesConstraints(node, workload.Placement.Constraints) {
-			candidates = append(candidates, node)
+	state := co.newSchedulingState(workload)
+	candidates := co.Scheduler.selectNodes(state, co.NodeManager.nodes)
+	rank := make(map[string]int, len(candidates))
+	for i, node := range candidates {
+		rank[node.ID] = i
+	}
+
+	plan := make(map[string]int32)
+	sites := make(map[string]bool)
+	var running int32
+	for _, deployment := range workload.Deployments {
+		// Completed job runs count, but their nodes are free to run more
+		if deployment.Status == WorkloadStatusCompleted {
+			running += deployment.Replicas
+			continue
+		}
+		if !deployment.placed() {
+			continue
+		}
+		plan[deployment.NodeID] = deployment.Replicas
+		running += deployment.Replicas
+		if node, exists := co.NodeManager.nodes[deployment.NodeID]; exists {
+			sites[node.SiteID] = true
 		}
 	}
 
-	// Apply placement strategy
-	switch workload.Placement.Strategy {
-	case PlacementStrategyEdgeFirst:
-		return co.selectEdgeFirstNodes(candidates, workload)
-	case PlacementStrategyLoadBalance:
-		return co.selectLoadBalancedNodes(candidates, workload)
-	case PlacementStrategyResource:
-		return co.selectResourceAwareNodes(candidates, workload)
-	default:
-		// Default to edge-first
-		return co.selectEdgeFirstNodes(candidates, workload)
+	maxPerNode := workload.Placement.MaxReplicasPerNode
+	if workload.Placement.OneReplicaPerSite || workload.antiAffineToItself() {
+		maxPerNode = 1
 	}
-}
 
-// nodeMatchesConstraints checks if a node matches placement constraints
-func (co *CentralOrchestrator) nodeMatchesConstraints(node *EdgeNode, constraints []PlacementConstraint) bool {
-	for _, constraint := range constraints {
-		switch constraint.Key {
-		case "region":
-			if !contains(constraint.Values, node.Region) {
-				return false
+	desired := expectedReplicas(workload)
+	for running < desired {
+		var best *EdgeNode
+		for _, node := range candidates {
+			replicas := plan[node.ID]
+			if maxPerNode > 0 && replicas >= maxPerNode {
+				continue
+			}
+			if workload.Placement.OneReplicaPerSite && sites[node.SiteID] && replicas == 0 {
+				continue
 			}
-		case "zone":
-			if !contains(constraint.Values, node.Zone) {
-				return false
+			if !co.fitsOnNode(node, state.Committed[node.ID], workload, 1) {
+				continue
 			}
-		default:
-			if labelValue, exists := node.Labels[constraint.Key]; exists {
-				if !contains(constraint.Values, labelValue) {
-					return false
-				}
-			} else {
-				return false
+			if best == nil || replicas < plan[best.ID] {
+				best = node
 			}
 		}
+		if best == nil {
+			break
+		}
+		plan[best.ID]++
+		running++
+		state.Committed[best.ID] = state.Committed[best.ID].with(workload, 1)
+		sites[best.SiteID] = true
 	}
-	return true
-}
 
-// selectEdgeFirstNodes selects nodes with edge-first strategy
-func (co *CentralOrchestrator) selectEdgeFirstNodes(candidates []*EdgeNode, workload *Workload) []*EdgeNode {
-	if len(candidates) == 0 {
-		return nil
-	}
-	
-	// For simplicity, select up to replicas count of nodes
-	maxNodes := int(workload.Replicas)
-	if maxNodes == 0 {
-		maxNodes = 1
+	// Nodes no longer selected rank last
+	rankOf := func(nodeID string) int {
+		if r, selected := rank[nodeID]; selected {
+			return r
+		}
+		return len(candidates)
 	}
-	
-	if len(candidates) <= maxNodes {
-		return candidates
+	for running > desired {
+		worst := ""
+		for nodeID, replicas := range plan {
+			if replicas == 0 {
+				continue
+			}
+			if worst == "" || replicas > plan[worst] ||
+				(replicas == plan[worst] && (rankOf(nodeID) > rankOf(worst) || (rankOf(nodeID) == rankOf(worst) && nodeID > worst))) {
+				worst = nodeID
+			}
+		}
+		if worst == "" {
+			break
+		}
+		plan[worst]--
+		running--
 	}
-	
-	return candidates[:maxNodes]
+	return plan
 }
 
-// selectLoadBalancedNodes selects nodes with load balancing
-func (co *CentralOrchestrator) selectLoadBalancedNodes(candidates []*EdgeNode, workload *Workload) []*EdgeNode {
-	// TODO: Implement proper load balancing based on current workloads
-	return co.selectEdgeFirstNodes(candidates, workload)
-}
-
-// selectResourceAwareNodes selects nodes based on resource availability
-func (co *CentralOrchestrator) selectResourceAwareNodes(candidates []*EdgeNode, workload *Workload) []*EdgeNode {
-	// TODO: Implement resource-aware selection
-	return co.selectEdgeFirstNodes(candidates, workload)
+// nodeMatchesConstraints checks if a node matches placement constraints
+func (co *CentralOrchestrator) nodeMatchesConstraints(node *EdgeNode, constraints []PlacementConstraint) bool {
+	for _, constraint := range constraints {
+		if !co.nodeMatchesConstraint(node, constraint) {
+			return false
+		}
+	}
+	return true
 }
 
 // metricsCollector collects metrics from nodes and workloads
 func (co *CentralOrchestrator) metricsCollector() {
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(MetricsCollectionInterval)
 	defer ticker.Stop()
 
 	for {
@@ -243,12 +399,14 @@ func (co *CentralOrchestrator) collectMetrics() {
 	// Collect node metrics
 	nodeCount := len(co.NodeManager.nodes)
 	onlineNodes := 0
+	nodesByState := make(map[string]int)
 	
 	co.NodeManager.mutex.RLock()
 	for _, node := range co.NodeManager.nodes {
 		if node.Status == NodeStatusOnline {
 			onlineNodes++
 		}
+		nodesByState[node.State]++
 	}
 	co.NodeManager.mutex.RUnlock()
 
@@ -268,10 +426,17 @@ func (co *CentralOrchestrator) collectMetrics() {
 	co.MonitoringService.metrics = map[string]interface{}{
 		"nodes_total":        nodeCount,
 		"nodes_online":       onlineNodes,
+		"nodes_by_state":     nodesByState,
 		"workloads_total":    workloadCount,
 		"workloads_running":  runningWorkloads,
+		"tenant_scheduling":  co.TenantScheduler.Stats(time.Now()),
 		"last_updated":       time.Now(),
 	}
+
+	// Keep history in the metrics store
+	co.MetricsStore.Record(time.Now(), co.metricSamples())
+	co.gradeLinks(time.Now())
+	co.checkLogErrorSpikes(time.Now())
 }
 
 // RegisterNode registers a new edge node
@@ -281,9 +446,31 @@ func (co *CentralOrchestrator) RegisterNode(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateTaints(req.Taints); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Devices provisioned from an image register with its join token
+	replaces, err := co.admitJoin(c, &req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 
 	nodeID := generateID()
 	now := time.Now()
+
+	// A replacement device takes over the identity of the node it replaces.
+	// Certificate-authenticated nodes register under the identity they were issued;
+	// the clusters of a multi-cluster agent get a logical ID derived from it
+	if replaces != "" {
+		nodeID = replaces
+	} else if logicalID, ok := logicalNodeID(c, req.Name); ok {
+		nodeID = logicalID
+	} else if certNodeID := c.GetString(ContextKeyNodeID); certNodeID != "" {
+		nodeID = certNodeID
+	}
 	
 	node := &EdgeNode{
 		ID:               nodeID,
@@ -293,8 +480,12 @@ func (co *CentralOrchestrator) RegisterNode(c *gin.Context) {
 		LastHeartbeat:    now,
 		Labels:           req.Labels,
 		Capabilities:     req.Capabilities,
+		Taints:           req.Taints,
 		Region:           req.Region,
 		Zone:             req.Zone,
+		SiteID:           req.SiteID,
+		State:            co.NodeStateManager.InitialState(),
+		StateChangedAt:   now,
 		KubernetesVersion: req.KubernetesVersion,
 		ContainerRuntime: req.ContainerRuntime,
 		CreatedAt:        now,
@@ -311,9 +502,40 @@ func (co *CentralOrchestrator) RegisterNode(c *gin.Context) {
 		node.Zone = "default"
 	}
 
+	co.CloudProvisioner.NodeRegistered(node)
+
 	co.NodeManager.mutex.Lock()
+	previous, reregistered := co.NodeManager.nodes[nodeID]
+	if reregistered {
+		// Keep the taints operators set; the agent's declared taints only add keys not set
+		node.Taints = withDeclaredTaints(previous.Taints, req.Taints)
+		// Cameras and datasets are reported separately and survive re-registration
+		node.Cameras = previous.Cameras
+		node.Datasets = previous.Datasets
+		// So do cordons and maintenance, such as across a reboot while the node is patched
+		node.Unschedulable = previous.Unschedulable
+		if previous.Status == NodeStatusMaintenance {
+			node.Status = NodeStatusMaintenance
+		}
+	}
+	if reregistered && replaces != "" {
+		// The logical node keeps its lifecycle state across a hardware swap
+		node.State = previous.State
+		node.StateReason = previous.StateReason
+		node.StateChangedAt = previous.StateChangedAt
+		node.CreatedAt = previous.CreatedAt
+	}
 	co.NodeManager.nodes[nodeID] = node
 	co.NodeManager.mutex.Unlock()
+	co.UptimeTracker.RecordHeartbeat(nodeID, node.LastHeartbeat)
+
+	if replaces != "" {
+		co.completeReplacement(c, node)
+	}
+
+	if reregistered {
+		co.reevaluatePlacement(nodeID, changedAttributeKeys(previous, node))
+	}
 
 	co.Logger.Infof("Node %s registered with ID %s", req.Name, nodeID)
 	
@@ -323,22 +545,48 @@ func (co *CentralOrchestrator) RegisterNode(c *gin.Context) {
 	})
 }
 
-// ListNodes returns all registered nodes
+// ListNodes returns the shared nodes and those dedicated to the tenants the caller may act
+// on, with only the ?fields= requested
 func (co *CentralOrchestrator) ListNodes(c *gin.Context) {
+	fields, err := parseFieldSelection(c, EdgeNode{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	co.NodeManager.mutex.RLock()
 	defer co.NodeManager.mutex.RUnlock()
 
 	nodes := make([]*EdgeNode, 0, len(co.NodeManager.nodes))
 	for _, node := range co.NodeManager.nodes {
+		if status := c.Query("status"); status != "" && string(node.Status) != status {
+			continue
+		}
+		if state := c.Query("state"); state != "" && node.State != state {
+			continue
+		}
+		if !nodeVisible(c, node) {
+			continue
+		}
 		nodes = append(nodes, node)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"nodes": nodes})
+	projected, err := fields.project(nodes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"nodes": projected})
 }
 
-// GetNode returns a specific node
+// GetNode returns a specific node, with only the ?fields= requested
 func (co *CentralOrchestrator) GetNode(c *gin.Context) {
 	nodeID := c.Param("id")
+	fields, err := parseFieldSelection(c, EdgeNode{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
 	co.NodeManager.mutex.RLock()
 	node, exists := co.NodeManager.nodes[nodeID]
@@ -349,7 +597,17 @@ func (co *CentralOrchestrator) GetNode(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"node": node})
+	projected, err := fields.project(node)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	committed := committedResources(co.WorkloadManager.workloads)[node.ID]
+	co.WorkloadManager.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"node": projected, "allocation": co.nodeAllocation(node, committed)})
 }
 
 // UnregisterNode removes a node from the cluster
@@ -365,6 +623,9 @@ func (co *CentralOrchestrator) UnregisterNode(c *gin.Context) {
 	}
 
 	delete(co.NodeManager.nodes, nodeID)
+	co.DesiredStateCache.forget(nodeID)
+	// An imported cluster stops being polled; its objects are left in place
+	co.ImportedClusters.remove(nodeID)
 	co.Logger.Infof("Node %s unregistered", nodeID)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Node unregistered successfully"})
@@ -380,21 +641,45 @@ func (co *CentralOrchestrator) NodeHeartbeat(c *gin.Context) {
 		return
 	}
 
+	if !co.applyHeartbeat(nodeID, req, HeartbeatTransportHTTPS) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Heartbeat received"})
+}
+
+// applyHeartbeat records a heartbeat received over any transport; it returns false for
+// unknown nodes
+func (co *CentralOrchestrator) applyHeartbeat(nodeID string, req HeartbeatRequest, transport HeartbeatTransport) bool {
 	co.NodeManager.mutex.Lock()
 	defer co.NodeManager.mutex.Unlock()
 
 	node, exists := co.NodeManager.nodes[nodeID]
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
-		return
+		return false
 	}
 
-	node.Status = req.Status
-	node.Resources = req.Resources
+	// A drained node stays in maintenance until it is uncordoned
+	if node.Status != NodeStatusMaintenance {
+		node.Status = req.Status
+	}
+	node.Resources = req.Resources.normalized()
 	node.LastHeartbeat = time.Now()
 	node.UpdatedAt = time.Now()
+	node.HeartbeatTransport = transport
+	if req.Latency != nil {
+		node.Latency = req.Latency
+	}
+	if req.LogSummaries != nil {
+		co.LogSummaryStore.Record(nodeID, req.LogSummaries)
+	}
+	// Replaced even when empty: a collector that failed drops its values
+	node.CustomMetrics = req.CustomMetrics
+	co.UptimeTracker.RecordHeartbeat(nodeID, node.LastHeartbeat)
+	co.StateStore.recordHeartbeatLease(nodeID)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Heartbeat received"})
+	return true
 }
 
 // generateID generates a random ID
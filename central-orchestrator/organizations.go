@@ -0,0 +1,584 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Organization groups the projects of one team or customer. Access policy tokens and
+// bindings listing an organization among their tenants act on all of its projects.
+type Organization struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// OrganizationRequest creates an organization or replaces its display name and labels
+type OrganizationRequest struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// OrganizationView is an organization with the names of its projects
+type OrganizationView struct {
+	*Organization
+	Projects []string `json:"projects"`
+}
+
+// Project is a tenant of an organization. Workloads, namespaces, tenant quotas and nodes
+// dedicated through the edge.io/tenant label name the project as their tenant.
+type Project struct {
+	Name         string            `json:"name"`
+	Organization string            `json:"organization"`
+	DisplayName  string            `json:"display_name,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// ProjectRequest creates a project or replaces its display name and labels
+type ProjectRequest struct {
+	Name         string            `json:"name"`
+	Organization string            `json:"organization"`
+	DisplayName  string            `json:"display_name"`
+	Labels       map[string]string `json:"labels"`
+}
+
+// ProjectView is a project with what it owns
+type ProjectView struct {
+	*Project
+	Workloads  int `json:"workloads"`
+	Nodes      int `json:"nodes"`
+	Namespaces int `json:"namespaces"`
+}
+
+// OrganizationManager keeps organizations and their projects
+type OrganizationManager struct {
+	organizations map[string]*Organization
+	projects      map[string]*Project
+	mutex         sync.RWMutex
+	logger        *logrus.Logger
+}
+
+// NewOrganizationManager creates a new organization manager
+func NewOrganizationManager(logger *logrus.Logger) *OrganizationManager {
+	return &OrganizationManager{
+		organizations: make(map[string]*Organization),
+		projects:      make(map[string]*Project),
+		logger:        logger,
+	}
+}
+
+// restore loads persisted organizations and projects
+func (om *OrganizationManager) restore(organizations map[string]*Organization, projects map[string]*Project, replace bool) {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	if replace {
+		om.organizations = make(map[string]*Organization, len(organizations))
+		om.projects = make(map[string]*Project, len(projects))
+	}
+	for name, organization := range organizations {
+		om.organizations[name] = organization
+	}
+	for name, project := range projects {
+		om.projects[name] = project
+	}
+}
+
+// expandTenants adds the projects of the organizations among a caller's tenants
+func (om *OrganizationManager) expandTenants(tenants []string) []string {
+	om.mutex.RLock()
+	defer om.mutex.RUnlock()
+
+	expanded := append([]string(nil), tenants...)
+	for _, project := range om.projects {
+		if contains(tenants, project.Organization) && !contains(expanded, project.Name) {
+			expanded = append(expanded, project.Name)
+		}
+	}
+	return expanded
+}
+
+// expandTenantScope widens the tenants a caller is limited to by the projects of the
+// organizations among them
+func (co *CentralOrchestrator) expandTenantScope(c *gin.Context) {
+	if value, limited := c.Get(ContextKeyTenants); limited {
+		c.Set(ContextKeyTenants, co.OrganizationManager.expandTenants(value.([]string)))
+	}
+}
+
+// organizationProjects returns the sorted names of an organization's projects; callers
+// must hold the OrganizationManager lock
+func (om *OrganizationManager) organizationProjects(organization string) []string {
+	projects := make([]string, 0)
+	for _, project := range om.projects {
+		if project.Organization == organization {
+			projects = append(projects, project.Name)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+// nodeTenant returns the tenant a node is dedicated to, or "" for a node shared by all
+func nodeTenant(node *EdgeNode) string {
+	return node.Labels[NodeTenantLabel]
+}
+
+// nodeVisible reports whether the caller may see a node: shared nodes and those dedicated
+// to a tenant it may act on
+func nodeVisible(c *gin.Context, node *EdgeNode) bool {
+	tenant := nodeTenant(node)
+	return tenant == "" || tenantAllowed(c, tenant)
+}
+
+// tenantView is the workloads and nodes a caller limited to tenants may see, for handlers
+// returning records that refer to them by ID. A nil view sees everything.
+type tenantView struct {
+	workloads map[string]bool
+	nodes     map[string]bool
+}
+
+// tenantView snapshots the workloads and nodes the caller may see, or returns nil when the
+// caller is not limited to tenants. Callers must not hold the WorkloadManager or NodeManager
+// locks.
+func (co *CentralOrchestrator) tenantView(c *gin.Context) *tenantView {
+	if _, limited := c.Get(ContextKeyTenants); !limited {
+		return nil
+	}
+	view := &tenantView{workloads: make(map[string]bool), nodes: make(map[string]bool)}
+
+	co.WorkloadManager.mutex.RLock()
+	for id, workload := range co.WorkloadManager.workloads {
+		if tenantAllowed(c, workloadTenant(workload)) {
+			view.workloads[id] = true
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	co.NodeManager.mutex.RLock()
+	for id, node := range co.NodeManager.nodes {
+		if nodeVisible(c, node) {
+			view.nodes[id] = true
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+	return view
+}
+
+// workload reports whether the caller may see a workload; deleted workloads are hidden
+func (v *tenantView) workload(id string) bool {
+	return v == nil || v.workloads[id]
+}
+
+// node reports whether the caller may see a node; deleted nodes are hidden
+func (v *tenantView) node(id string) bool {
+	return v == nil || v.nodes[id]
+}
+
+// alert reports whether the caller may see an alert: site alerts, and those about
+// workloads and nodes it may see
+func (v *tenantView) alert(alert *Alert) bool {
+	if workloadID := alertWorkloadID(alert); workloadID != "" && !v.workload(workloadID) {
+		return false
+	}
+	if nodeID := alertNodeID(alert); nodeID != "" && !v.node(nodeID) {
+		return false
+	}
+	return true
+}
+
+// validateTenantName checks the name of an organization or project, which tenants share
+func validateTenantName(kind, name string) error {
+	if problems := validation.IsDNS1123Label(name); len(problems) > 0 {
+		return fmt.Errorf("invalid %s name: %s", kind, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// projectView counts what a project owns; callers must hold the WorkloadManager,
+// NodeManager and NamespaceManager locks
+func (co *CentralOrchestrator) projectView(project *Project) ProjectView {
+	view := ProjectView{Project: project}
+	for _, workload := range co.WorkloadManager.workloads {
+		if workloadTenant(workload) == project.Name {
+			view.Workloads++
+		}
+	}
+	for _, node := range co.NodeManager.nodes {
+		if nodeTenant(node) == project.Name {
+			view.Nodes++
+		}
+	}
+	for _, namespace := range co.NamespaceManager.namespaces {
+		if namespace.Tenant == project.Name {
+			view.Namespaces++
+		}
+	}
+	return view
+}
+
+// CreateOrganization creates an organization
+func (co *CentralOrchestrator) CreateOrganization(c *gin.Context) {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateTenantName("organization", req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !tenantAllowed(c, req.Name) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", req.Name)})
+		return
+	}
+
+	om := co.OrganizationManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	if _, exists := om.organizations[req.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Organization %s already exists", req.Name)})
+		return
+	}
+	if _, exists := om.projects[req.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is already the name of a project", req.Name)})
+		return
+	}
+	now := time.Now()
+	organization := &Organization{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Labels:      req.Labels,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	om.organizations[organization.Name] = organization
+
+	co.Logger.Infof("Organization %s created", organization.Name)
+	co.AuditLog.RecordRequest(c, "organization.create", "organization:"+organization.Name, nil)
+	c.JSON(http.StatusCreated, gin.H{"organization": organization})
+}
+
+// ListOrganizations lists the organizations the caller may act on or has projects in
+func (co *CentralOrchestrator) ListOrganizations(c *gin.Context) {
+	om := co.OrganizationManager
+	om.mutex.RLock()
+	defer om.mutex.RUnlock()
+
+	organizations := make([]OrganizationView, 0, len(om.organizations))
+	for _, organization := range om.organizations {
+		projects := om.organizationProjects(organization.Name)
+		if !tenantAllowed(c, organization.Name) {
+			visible := projects[:0]
+			for _, project := range projects {
+				if tenantAllowed(c, project) {
+					visible = append(visible, project)
+				}
+			}
+			if len(visible) == 0 {
+				continue
+			}
+			projects = visible
+		}
+		organizations = append(organizations, OrganizationView{Organization: organization, Projects: projects})
+	}
+	sort.Slice(organizations, func(i, j int) bool {
+		return organizations[i].Name < organizations[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{"organizations": organizations})
+}
+
+// GetOrganization returns an organization with its projects
+func (co *CentralOrchestrator) GetOrganization(c *gin.Context) {
+	om := co.OrganizationManager
+	om.mutex.RLock()
+	defer om.mutex.RUnlock()
+
+	organization, exists := om.organizations[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	projects := om.organizationProjects(organization.Name)
+	if !tenantAllowed(c, organization.Name) {
+		visible := projects[:0]
+		for _, project := range projects {
+			if tenantAllowed(c, project) {
+				visible = append(visible, project)
+			}
+		}
+		if len(visible) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", organization.Name)})
+			return
+		}
+		projects = visible
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organization": OrganizationView{Organization: organization, Projects: projects}})
+}
+
+// UpdateOrganization replaces an organization's display name and labels
+func (co *CentralOrchestrator) UpdateOrganization(c *gin.Context) {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	om := co.OrganizationManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	organization, exists := om.organizations[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if !tenantAllowed(c, organization.Name) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", organization.Name)})
+		return
+	}
+	organization.DisplayName = req.DisplayName
+	organization.Labels = req.Labels
+	organization.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Organization %s updated", organization.Name)
+	co.AuditLog.RecordRequest(c, "organization.update", "organization:"+organization.Name, nil)
+	c.JSON(http.StatusOK, gin.H{"organization": organization})
+}
+
+// DeleteOrganization deletes an organization that no longer has projects
+func (co *CentralOrchestrator) DeleteOrganization(c *gin.Context) {
+	name := c.Param("name")
+
+	om := co.OrganizationManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	if _, exists := om.organizations[name]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if !tenantAllowed(c, name) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", name)})
+		return
+	}
+	if projects := om.organizationProjects(name); len(projects) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Organization %s still has %d projects", name, len(projects))})
+		return
+	}
+
+	delete(om.organizations, name)
+	co.Logger.Infof("Organization %s deleted", name)
+	co.AuditLog.RecordRequest(c, "organization.delete", "organization:"+name, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted successfully"})
+}
+
+// CreateProject creates a project in an organization
+func (co *CentralOrchestrator) CreateProject(c *gin.Context) {
+	var req ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateTenantName("project", req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Organization == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organization is required"})
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Adding a project widens what the organization's tokens reach
+	if !tenantAllowed(c, req.Organization) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", req.Organization)})
+		return
+	}
+
+	om := co.OrganizationManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	if _, exists := om.organizations[req.Organization]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if _, exists := om.projects[req.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Project %s already exists", req.Name)})
+		return
+	}
+	if _, exists := om.organizations[req.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is already the name of an organization", req.Name)})
+		return
+	}
+	now := time.Now()
+	project := &Project{
+		Name:         req.Name,
+		Organization: req.Organization,
+		DisplayName:  req.DisplayName,
+		Labels:       req.Labels,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	om.projects[project.Name] = project
+
+	co.Logger.Infof("Project %s created in organization %s", project.Name, project.Organization)
+	co.AuditLog.RecordRequest(c, "project.create", "project:"+project.Name, map[string]string{"organization": project.Organization})
+	c.JSON(http.StatusCreated, gin.H{"project": project})
+}
+
+// ListProjects lists the projects the caller may act on, optionally of one organization
+func (co *CentralOrchestrator) ListProjects(c *gin.Context) {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+	co.NamespaceManager.mutex.RLock()
+	defer co.NamespaceManager.mutex.RUnlock()
+	om := co.OrganizationManager
+	om.mutex.RLock()
+	defer om.mutex.RUnlock()
+
+	projects := make([]ProjectView, 0, len(om.projects))
+	for _, project := range om.projects {
+		if !tenantAllowed(c, project.Name) {
+			continue
+		}
+		if organization := c.Query("organization"); organization != "" && project.Organization != organization {
+			continue
+		}
+		projects = append(projects, co.projectView(project))
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Name < projects[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{"projects": projects})
+}
+
+// GetProject returns a project with what it owns
+func (co *CentralOrchestrator) GetProject(c *gin.Context) {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+	co.NamespaceManager.mutex.RLock()
+	defer co.NamespaceManager.mutex.RUnlock()
+	om := co.OrganizationManager
+	om.mutex.RLock()
+	defer om.mutex.RUnlock()
+
+	project, exists := om.projects[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if !tenantAllowed(c, project.Name) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", project.Name)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"project": co.projectView(project)})
+}
+
+// UpdateProject replaces a project's display name and labels. Projects do not move
+// between organizations.
+func (co *CentralOrchestrator) UpdateProject(c *gin.Context) {
+	var req ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	om := co.OrganizationManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	project, exists := om.projects[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if !tenantAllowed(c, project.Name) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", project.Name)})
+		return
+	}
+	if req.Organization != "" && req.Organization != project.Organization {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Projects cannot move to another organization"})
+		return
+	}
+	project.DisplayName = req.DisplayName
+	project.Labels = req.Labels
+	project.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Project %s updated", project.Name)
+	co.AuditLog.RecordRequest(c, "project.update", "project:"+project.Name, nil)
+	c.JSON(http.StatusOK, gin.H{"project": project})
+}
+
+// DeleteProject deletes a project that no longer owns workloads, nodes or namespaces
+func (co *CentralOrchestrator) DeleteProject(c *gin.Context) {
+	name := c.Param("name")
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+	co.NamespaceManager.mutex.RLock()
+	defer co.NamespaceManager.mutex.RUnlock()
+	om := co.OrganizationManager
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	project, exists := om.projects[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	// Deleting a project is up to its organization
+	if !tenantAllowed(c, project.Organization) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", project.Organization)})
+		return
+	}
+	if view := co.projectView(project); view.Workloads > 0 || view.Nodes > 0 || view.Namespaces > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Project %s still owns %d workloads, %d nodes and %d namespaces",
+			name, view.Workloads, view.Nodes, view.Namespaces)})
+		return
+	}
+
+	delete(om.projects, name)
+	co.Logger.Infof("Project %s deleted", name)
+	co.AuditLog.RecordRequest(c, "project.delete", "project:"+name, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
+}
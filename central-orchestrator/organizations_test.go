@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TestTenantScopedReads checks that a caller limited to a tenant sees only the alerts,
+// snapshots and quotas of its own workloads and of the nodes it may see, and cannot act on
+// another tenant's records by ID
+func TestTenantScopedReads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	co := &CentralOrchestrator{
+		NodeManager:     NewNodeManager(logger),
+		WorkloadManager: NewWorkloadManager(logger),
+		AlertManager:    NewAlertManager(logger),
+		SilenceManager:  NewSilenceManager(logger),
+		MessageCatalog:  NewMessageCatalog(logger),
+		SnapshotManager: NewSnapshotManager(logger),
+		TenantScheduler: NewTenantScheduler(logger),
+		AuditLog:        NewAuditLog(logger),
+		Logger:          logger,
+	}
+	co.NodeManager.nodes["shared"] = &EdgeNode{ID: "shared", Name: "shared"}
+	co.NodeManager.nodes["node-b"] = &EdgeNode{ID: "node-b", Name: "node-b", Labels: map[string]string{NodeTenantLabel: "team-b"}}
+	co.WorkloadManager.workloads["w-a"] = &Workload{ID: "w-a", Name: "w-a", Tenant: "team-a"}
+	co.WorkloadManager.workloads["w-b"] = &Workload{ID: "w-b", Name: "w-b", Tenant: "team-b"}
+
+	co.AlertManager.Fire("WorkloadFailed", AlertSeverityCritical, AlertScopeWorkload, "w-a", "", Message{})
+	alertB := co.AlertManager.Fire("WorkloadFailed", AlertSeverityCritical, AlertScopeWorkload, "w-b", "", Message{})
+	co.AlertManager.Fire("NodeOffline", AlertSeverityWarning, AlertScopeNode, "shared", "", Message{})
+	co.AlertManager.Fire("NodeOffline", AlertSeverityWarning, AlertScopeNode, "node-b", "", Message{})
+	co.SnapshotManager.snapshots["s-a"] = &Snapshot{ID: "s-a", WorkloadID: "w-a"}
+	co.SnapshotManager.snapshots["s-b"] = &Snapshot{ID: "s-b", WorkloadID: "w-b"}
+	co.TenantScheduler.quotas["team-a"] = &TenantQuota{Tenant: "team-a"}
+	co.TenantScheduler.quotas["team-b"] = &TenantQuota{Tenant: "team-b"}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(ContextKeyTenants, []string{"team-a"}) })
+	router.GET("/api/v1/alerts", co.ListAlerts)
+	router.POST("/api/v1/alerts/:id/acknowledge", co.AcknowledgeAlert)
+	router.GET("/api/v1/snapshots", co.ListSnapshots)
+	router.POST("/api/v1/snapshots/:id/restore", co.RestoreSnapshotHandler)
+	router.GET("/api/v1/tenant-quotas", co.ListTenantQuotas)
+	router.DELETE("/api/v1/tenant-quotas/:tenant", co.DeleteTenantQuota)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	var alerts struct {
+		Alerts []Alert `json:"alerts"`
+	}
+	if err := json.Unmarshal(serve("GET", "/api/v1/alerts", "").Body.Bytes(), &alerts); err != nil {
+		t.Fatal(err)
+	}
+	scopes := make(map[string]bool)
+	for _, alert := range alerts.Alerts {
+		scopes[alert.ScopeID] = true
+	}
+	if len(alerts.Alerts) != 2 || !scopes["w-a"] || !scopes["shared"] {
+		t.Errorf("Listed alerts about %v, want w-a and shared", scopes)
+	}
+
+	var snapshots struct {
+		Snapshots []Snapshot `json:"snapshots"`
+	}
+	if err := json.Unmarshal(serve("GET", "/api/v1/snapshots", "").Body.Bytes(), &snapshots); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots.Snapshots) != 1 || snapshots.Snapshots[0].ID != "s-a" {
+		t.Errorf("Listed snapshots %+v, want only s-a", snapshots.Snapshots)
+	}
+
+	var quotas struct {
+		Quotas []TenantQuota `json:"quotas"`
+	}
+	if err := json.Unmarshal(serve("GET", "/api/v1/tenant-quotas", "").Body.Bytes(), &quotas); err != nil {
+		t.Fatal(err)
+	}
+	if len(quotas.Quotas) != 1 || quotas.Quotas[0].Tenant != "team-a" {
+		t.Errorf("Listed quotas %+v, want only team-a", quotas.Quotas)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"acknowledge another tenant's alert", "POST", "/api/v1/alerts/" + alertB.ID + "/acknowledge", "", http.StatusNotFound},
+		{"restore another tenant's snapshot", "POST", "/api/v1/snapshots/s-b/restore", `{"node_id": "shared"}`, http.StatusNotFound},
+		{"restore onto another tenant's node", "POST", "/api/v1/snapshots/s-a/restore", `{"node_id": "node-b"}`, http.StatusNotFound},
+		{"delete another tenant's quota", "DELETE", "/api/v1/tenant-quotas/team-b", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if recorder := serve(test.method, test.path, test.body); recorder.Code != test.want {
+				t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.path, recorder.Code, test.want, recorder.Body.String())
+			}
+		})
+	}
+
+	if roleAllows(RoleOperator, http.MethodPut, "/api/v1/tenant-quotas/team-a") {
+		t.Error("Operators may change tenant quotas")
+	}
+	if !roleAllows(RoleOperator, http.MethodGet, "/api/v1/tenant-quotas") || !roleAllows(RoleAdmin, http.MethodPut, "/api/v1/tenant-quotas/team-a") {
+		t.Error("Tenant quotas are not readable by operators or writable by admins")
+	}
+}
+
+// TestTenantLabelChanges checks that a caller limited to a tenant cannot move nodes or
+// claimed devices to another tenant, and that labels sent without the tenant label keep it
+func TestTenantLabelChanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	co := &CentralOrchestrator{
+		NodeManager:          NewNodeManager(logger),
+		WorkloadManager:      NewWorkloadManager(logger),
+		ClaimManager:         NewClaimManager(logger),
+		PlacementReevaluator: &PlacementReevaluator{mode: ReevaluationDisabled, logger: logger},
+		Logger:               logger,
+	}
+	node := &EdgeNode{ID: "node-a", Name: "node-a", Labels: map[string]string{NodeTenantLabel: "team-a"}}
+	co.NodeManager.nodes["node-a"] = node
+	co.ClaimManager.claims["AAAA-AAAA"] = &DeviceClaim{Status: DeviceClaimUnclaimed, LastSeenAt: time.Now()}
+	co.ClaimManager.claims["BBBB-BBBB"] = &DeviceClaim{Status: DeviceClaimClaimed, Tenant: "team-b", LastSeenAt: time.Now()}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(ContextKeyTenants, []string{"team-a"}) })
+	router.PUT("/api/v1/nodes/:id/attributes", co.UpdateNodeAttributes)
+	router.GET("/api/v1/claims", co.ListDeviceClaims)
+	router.POST("/api/v1/claims/:code/claim", co.ClaimDevice)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if code := serve("PUT", "/api/v1/nodes/node-a/attributes", `{"labels": {"zone": "z1"}}`).Code; code != http.StatusOK {
+		t.Errorf("Updating labels got %d, want 200", code)
+	}
+	if tenant := nodeTenant(node); tenant != "team-a" || node.Labels["zone"] != "z1" {
+		t.Errorf("Node has labels %v, want zone z1 on tenant team-a", node.Labels)
+	}
+	for _, labels := range []string{`{"edge.io/tenant": "team-b"}`, `{"edge.io/tenant": ""}`} {
+		if code := serve("PUT", "/api/v1/nodes/node-a/attributes", `{"labels": `+labels+`}`).Code; code != http.StatusForbidden {
+			t.Errorf("Setting labels %s got %d, want 403", labels, code)
+		}
+	}
+	if tenant := nodeTenant(node); tenant != "team-a" {
+		t.Errorf("Node moved to tenant %q", tenant)
+	}
+
+	for _, body := range []string{`{"tenant": "team-b"}`, `{"labels": {"edge.io/tenant": "team-b"}}`, `{}`} {
+		if code := serve("POST", "/api/v1/claims/AAAA-AAAA/claim", body).Code; code != http.StatusForbidden {
+			t.Errorf("Claiming with %s got %d, want 403", body, code)
+		}
+	}
+
+	var claims struct {
+		Claims []DeviceClaim `json:"claims"`
+	}
+	if err := json.Unmarshal(serve("GET", "/api/v1/claims", "").Body.Bytes(), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Claims) != 1 || claims.Claims[0].Status != DeviceClaimUnclaimed {
+		t.Errorf("Listed claims %+v, want only the unclaimed one", claims.Claims)
+	}
+}
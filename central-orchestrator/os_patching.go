@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Interval between patch campaign progress checks
+	PatchCheckInterval = 15 * time.Second
+	// Time a patched node has to report healthy again, including its reboot
+	DefaultPatchHealthTimeout = 15 * time.Minute
+	// Time an agent has to pick up a queued patch command before the node is failed
+	PatchCommandPickupTimeout = 10 * time.Minute
+)
+
+// A campaign stops starting nodes once more of them failed than it allows
+const CampaignHalted CampaignStatus = "halted"
+
+// PatchPhase is the step a node is at within a patch campaign
+type PatchPhase string
+
+const (
+	PatchPhaseDrain  PatchPhase = "drain"
+	PatchPhasePatch  PatchPhase = "patch"
+	PatchPhaseReboot PatchPhase = "reboot"
+	PatchPhaseVerify PatchPhase = "verify"
+	PatchPhaseDone   PatchPhase = "done"
+)
+
+// PatchNode tracks one node through a patch campaign
+type PatchNode struct {
+	NodeID string             `json:"node_id"`
+	SiteID string             `json:"site_id,omitempty"`
+	Phase  PatchPhase         `json:"phase,omitempty"`
+	Status CampaignNodeStatus `json:"status"`
+	// Why a pending node has not started yet, such as replicas no other node can take
+	Waiting          string    `json:"waiting,omitempty"`
+	DrainOperationID string    `json:"drain_operation_id,omitempty"`
+	CommandID        string    `json:"command_id,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	PhaseStartedAt   time.Time `json:"phase_started_at,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	drain *Operation
+	// Boot time the node reported before its reboot
+	bootTime *time.Time
+}
+
+// PatchCampaign rolls OS patches across the nodes of a group. Each node is only drained once
+// the scheduler has found room elsewhere for all its replicas; it is then patched, and
+// rebooted, through the node command channel, and returned to scheduling once healthy.
+type PatchCampaign struct {
+	ID string `json:"id"`
+	NodeGroup
+	Name         string   `json:"name"`
+	PatchCommand []string `json:"patch_command"`
+	// Reboot scheduled by a command that returns at once, such as "shutdown -r +1"
+	RebootCommand []string `json:"reboot_command,omitempty"`
+	// Run once the node is back, to check it is healthy
+	HealthCommand        []string       `json:"health_command,omitempty"`
+	TimeoutSeconds       int            `json:"timeout_seconds"`
+	HealthTimeoutSeconds int            `json:"health_timeout_seconds"`
+	MaxParallel          int            `json:"max_parallel"`
+	MaxParallelPerSite   int            `json:"max_parallel_per_site"`
+	MaxFailures          int            `json:"max_failures"`
+	Status               CampaignStatus `json:"status"`
+	Nodes                []*PatchNode   `json:"nodes"`
+	CreatedAt            time.Time      `json:"created_at"`
+	CompletedAt          *time.Time     `json:"completed_at,omitempty"`
+}
+
+// PatchCampaignRequest represents a patch campaign creation request
+type PatchCampaignRequest struct {
+	Name                 string            `json:"name" binding:"required"`
+	NodeSelector         map[string]string `json:"node_selector"`
+	SiteID               string            `json:"site_id"`
+	PatchCommand         []string          `json:"patch_command" binding:"required"`
+	RebootCommand        []string          `json:"reboot_command"`
+	HealthCommand        []string          `json:"health_command"`
+	TimeoutSeconds       int               `json:"timeout_seconds"`
+	HealthTimeoutSeconds int               `json:"health_timeout_seconds"`
+	MaxParallel          int               `json:"max_parallel"`
+	MaxParallelPerSite   int               `json:"max_parallel_per_site"`
+	MaxFailures          int               `json:"max_failures"`
+}
+
+// PatchManager tracks OS patch campaigns
+type PatchManager struct {
+	campaigns map[string]*PatchCampaign
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewPatchManager creates a new patch manager
+func NewPatchManager(logger *logrus.Logger) *PatchManager {
+	return &PatchManager{
+		campaigns: make(map[string]*PatchCampaign),
+		logger:    logger,
+	}
+}
+
+// patchController advances running patch campaigns
+func (co *CentralOrchestrator) patchController() {
+	ticker := time.NewTicker(PatchCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.advancePatchCampaigns()
+		}
+	}
+}
+
+// advancePatchCampaigns moves each patching node to its next phase and starts pending nodes
+// while their campaign is under its parallelism limits
+func (co *CentralOrchestrator) advancePatchCampaigns() {
+	online := co.onlineNodes(nil)
+
+	co.PatchManager.mutex.Lock()
+	defer co.PatchManager.mutex.Unlock()
+
+	var starting []*patchStart
+	for _, campaign := range co.PatchManager.campaigns {
+		if campaign.Status != CampaignRunning {
+			continue
+		}
+
+		active, failed := 0, 0
+		perSite := make(map[string]int)
+		for _, pn := range campaign.Nodes {
+			if pn.Status == CampaignNodeRunning {
+				co.advancePatchNode(campaign, pn)
+			}
+			switch pn.Status {
+			case CampaignNodeRunning:
+				active++
+				perSite[pn.SiteID]++
+			case CampaignNodeFailed:
+				failed++
+			}
+		}
+		if failed > campaign.MaxFailures {
+			continue
+		}
+
+		for _, pn := range campaign.Nodes {
+			if active >= campaign.MaxParallel {
+				break
+			}
+			if pn.Status != CampaignNodePending || online[pn.NodeID] == nil {
+				continue
+			}
+			if pn.SiteID != "" && perSite[pn.SiteID] >= campaign.MaxParallelPerSite {
+				continue
+			}
+			starting = append(starting, &patchStart{campaign: campaign, node: pn})
+			active++
+			perSite[pn.SiteID]++
+		}
+	}
+
+	co.startPatchDrains(starting, online)
+
+	for _, campaign := range co.PatchManager.campaigns {
+		if campaign.Status == CampaignRunning {
+			co.finishPatchCampaignIfDone(campaign)
+		}
+	}
+}
+
+// patchStart is a pending node picked to start patching
+type patchStart struct {
+	campaign   *PatchCampaign
+	node       *PatchNode
+	migrations []*Migration
+}
+
+// startPatchDrains cordons the picked nodes whose replicas all fit elsewhere and drains
+// them. Nodes whose replicas do not fit are uncordoned and stay pending until there is room.
+// Plans count on each other's moves and on migrations still preparing, so concurrent drains
+// never count on the same room, and nodes replicas are moving to are not drained.
+func (co *CentralOrchestrator) startPatchDrains(starting []*patchStart, online map[string]*EdgeNode) {
+	if len(starting) == 0 {
+		return
+	}
+
+	co.MigrationManager.mutex.RLock()
+	var preparing []Migration
+	receiving := make(map[string]bool)
+	for _, migration := range co.MigrationManager.migrations {
+		switch migration.Phase {
+		case MigrationPhaseCompleted, MigrationPhaseFailed, MigrationPhaseRolledBack:
+			continue
+		case MigrationPhasePreparing:
+			preparing = append(preparing, *migration)
+		}
+		receiving[migration.DestinationNodeID] = true
+	}
+	co.MigrationManager.mutex.RUnlock()
+
+	co.WorkloadManager.mutex.RLock()
+	committed := committedResources(co.WorkloadManager.workloads)
+	for _, migration := range preparing {
+		if workload, exists := co.WorkloadManager.workloads[migration.WorkloadID]; exists {
+			committed[migration.DestinationNodeID] = committed[migration.DestinationNodeID].with(workload, migration.Replicas)
+		}
+	}
+
+	var drained []*patchStart
+	for _, start := range starting {
+		pn := start.node
+		if receiving[pn.NodeID] {
+			pn.Waiting = "replicas are moving to the node"
+			continue
+		}
+		if waiting := co.cordonForPatch(pn.NodeID); waiting != "" {
+			pn.Waiting = waiting
+			continue
+		}
+
+		simulated := make(map[string]Commitment, len(committed))
+		for nodeID, commitment := range committed {
+			simulated[nodeID] = commitment
+		}
+		if _, unplaced := co.planDrain(pn.NodeID, online, simulated, DefaultMigrationReadyTimeout, nil); len(unplaced) > 0 {
+			co.NodeManager.mutex.Lock()
+			if node, exists := co.NodeManager.nodes[pn.NodeID]; exists {
+				node.Unschedulable = false
+				node.UpdatedAt = time.Now()
+			}
+			co.NodeManager.mutex.Unlock()
+			pn.Waiting = "no capacity elsewhere for " + strings.Join(unplaced, ", ")
+			continue
+		}
+
+		pn.drain = co.OperationManager.Start("node-drain", "node "+pn.NodeID)
+		pn.DrainOperationID = pn.drain.ID
+		start.migrations, _ = co.planDrain(pn.NodeID, online, committed, DefaultMigrationReadyTimeout, pn.drain)
+		for _, migration := range start.migrations {
+			receiving[migration.DestinationNodeID] = true
+		}
+		drained = append(drained, start)
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	for _, start := range drained {
+		pn := start.node
+		pn.Status = CampaignNodeRunning
+		pn.Waiting = ""
+		co.startPatchPhase(start.campaign, pn, PatchPhaseDrain)
+		co.Logger.Infof("Patch campaign %s draining node %s", start.campaign.ID, pn.NodeID)
+
+		co.launchMigrations(start.migrations)
+		go co.finishDrain(pn.NodeID, pn.drain, start.migrations, nil)
+	}
+}
+
+// cordonForPatch cordons a node before it is drained for patching, returning why the node
+// cannot start when an operator already cordoned it
+func (co *CentralOrchestrator) cordonForPatch(nodeID string) string {
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		return "node was removed"
+	}
+	if node.Unschedulable {
+		return "node is cordoned"
+	}
+	node.Unschedulable = true
+	node.UpdatedAt = time.Now()
+	return ""
+}
+
+// advancePatchNode checks the node's current phase and starts the next one once it is done
+func (co *CentralOrchestrator) advancePatchNode(campaign *PatchCampaign, pn *PatchNode) {
+	co.NodeManager.mutex.RLock()
+	var node EdgeNode
+	stored, exists := co.NodeManager.nodes[pn.NodeID]
+	if exists {
+		node = *stored
+	}
+	co.NodeManager.mutex.RUnlock()
+	if !exists {
+		co.failPatchNode(pn, "node was removed")
+		return
+	}
+
+	switch pn.Phase {
+	case PatchPhaseDrain:
+		co.OperationManager.mutex.RLock()
+		status, message := pn.drain.Status, pn.drain.Message
+		co.OperationManager.mutex.RUnlock()
+
+		switch {
+		case status == OperationStatusRunning:
+		case status != OperationStatusSucceeded:
+			co.failPatchNode(pn, "drain did not complete: "+message)
+		case !node.Unschedulable:
+			co.failPatchNode(pn, "node was uncordoned during the drain")
+		default:
+			pn.bootTime = node.Resources.BootTime
+			co.startPatchPhase(campaign, pn, PatchPhasePatch)
+		}
+
+	case PatchPhasePatch, PatchPhaseReboot:
+		cmd, exists := co.CommandManager.Get(pn.CommandID)
+		if !exists {
+			co.failPatchNode(pn, "command record lost")
+			return
+		}
+		switch cmd.Status {
+		case NodeCommandSucceeded:
+			if pn.Phase == PatchPhasePatch && len(campaign.RebootCommand) > 0 {
+				co.startPatchPhase(campaign, pn, PatchPhaseReboot)
+			} else {
+				co.startPatchPhase(campaign, pn, PatchPhaseVerify)
+			}
+		case NodeCommandFailed:
+			message := cmd.Error
+			if message == "" {
+				message = fmt.Sprintf("exit code %d", cmd.ExitCode)
+			}
+			co.failPatchNode(pn, fmt.Sprintf("%s failed: %s", pn.Phase, message))
+		case NodeCommandPending:
+			// Agents that do not allow node commands never pick it up
+			if time.Since(pn.PhaseStartedAt) > PatchCommandPickupTimeout {
+				co.CommandManager.Cancel(pn.CommandID, "not picked up by the agent")
+				co.failPatchNode(pn, fmt.Sprintf("%s command was not picked up; is allow_node_commands set?", pn.Phase))
+			}
+		}
+
+	case PatchPhaseVerify:
+		co.verifyPatchedNode(campaign, pn, &node)
+	}
+}
+
+// verifyPatchedNode waits for a patched node to heartbeat again, after booting anew when it
+// was rebooted, then runs the campaign's health command, and returns the node to scheduling
+func (co *CentralOrchestrator) verifyPatchedNode(campaign *PatchCampaign, pn *PatchNode, node *EdgeNode) {
+	if time.Since(pn.PhaseStartedAt) > time.Duration(campaign.HealthTimeoutSeconds)*time.Second {
+		if pn.CommandID != "" {
+			co.CommandManager.Cancel(pn.CommandID, "patch health check timed out")
+		}
+		co.failPatchNode(pn, "node did not report healthy in time")
+		return
+	}
+
+	if pn.CommandID == "" {
+		if !node.LastHeartbeat.After(pn.PhaseStartedAt) {
+			return
+		}
+		if len(campaign.RebootCommand) > 0 {
+			boot := node.Resources.BootTime
+			if boot == nil || (pn.bootTime != nil && !boot.After(*pn.bootTime)) {
+				return
+			}
+		}
+		if len(campaign.HealthCommand) > 0 {
+			cmd := co.CommandManager.Enqueue(pn.NodeID, "patch:"+campaign.ID, campaign.HealthCommand,
+				time.Duration(campaign.TimeoutSeconds)*time.Second)
+			pn.CommandID = cmd.ID
+			pn.UpdatedAt = time.Now()
+			return
+		}
+	} else {
+		cmd, exists := co.CommandManager.Get(pn.CommandID)
+		if !exists {
+			co.failPatchNode(pn, "command record lost")
+			return
+		}
+		switch cmd.Status {
+		case NodeCommandFailed:
+			co.failPatchNode(pn, "health check failed: "+cmd.Error)
+			return
+		case NodeCommandPending, NodeCommandRunning:
+			return
+		}
+	}
+
+	co.NodeManager.mutex.Lock()
+	if stored, exists := co.NodeManager.nodes[pn.NodeID]; exists {
+		stored.Unschedulable = false
+		if stored.Status == NodeStatusMaintenance {
+			stored.Status = NodeStatusOnline
+		}
+		stored.UpdatedAt = time.Now()
+	}
+	co.NodeManager.mutex.Unlock()
+
+	co.startPatchPhase(campaign, pn, PatchPhaseDone)
+}
+
+// startPatchPhase queues the phase's command, or completes the node after the last phase
+func (co *CentralOrchestrator) startPatchPhase(campaign *PatchCampaign, pn *PatchNode, phase PatchPhase) {
+	pn.Phase = phase
+	pn.PhaseStartedAt = time.Now()
+	pn.UpdatedAt = pn.PhaseStartedAt
+	pn.CommandID = ""
+
+	var command []string
+	switch phase {
+	case PatchPhasePatch:
+		command = campaign.PatchCommand
+	case PatchPhaseReboot:
+		command = campaign.RebootCommand
+	case PatchPhaseDone:
+		pn.Status = CampaignNodeSucceeded
+		co.Logger.Infof("Patch campaign %s finished on node %s", campaign.ID, pn.NodeID)
+	}
+	if command != nil {
+		cmd := co.CommandManager.Enqueue(pn.NodeID, "patch:"+campaign.ID, command,
+			time.Duration(campaign.TimeoutSeconds)*time.Second)
+		pn.CommandID = cmd.ID
+	}
+}
+
+// failPatchNode marks a patch campaign node failed. The node stays cordoned for operators
+// to look at.
+func (co *CentralOrchestrator) failPatchNode(pn *PatchNode, message string) {
+	pn.Status = CampaignNodeFailed
+	pn.Error = message
+	pn.UpdatedAt = time.Now()
+	co.Logger.Errorf("Patching failed on node %s: %s", pn.NodeID, message)
+}
+
+// finishPatchCampaignIfDone completes a campaign once no node is patching and either every
+// node is done or too many have failed
+func (co *CentralOrchestrator) finishPatchCampaignIfDone(campaign *PatchCampaign) {
+	pending, failed := 0, 0
+	for _, pn := range campaign.Nodes {
+		switch pn.Status {
+		case CampaignNodeRunning:
+			return
+		case CampaignNodePending:
+			pending++
+		case CampaignNodeFailed:
+			failed++
+		}
+	}
+
+	halted := failed > campaign.MaxFailures
+	if pending > 0 && !halted {
+		return
+	}
+
+	now := time.Now()
+	campaign.CompletedAt = &now
+	switch {
+	case halted && pending > 0:
+		campaign.Status = CampaignHalted
+	case failed > 0:
+		campaign.Status = CampaignPartial
+	default:
+		campaign.Status = CampaignCompleted
+	}
+	co.Logger.Infof("Patch campaign %s %s (%d of %d nodes failed)", campaign.ID, campaign.Status, failed, len(campaign.Nodes))
+}
+
+// CreatePatchCampaign starts an OS patch campaign against the nodes of a group. Agentless
+// nodes are left out, since they have no command channel.
+func (co *CentralOrchestrator) CreatePatchCampaign(c *gin.Context) {
+	var req PatchCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.PatchCommand) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "patch_command must not be empty"})
+		return
+	}
+	if req.MaxFailures < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_failures must not be negative"})
+		return
+	}
+	if req.MaxParallel <= 0 {
+		req.MaxParallel = 1
+	}
+	if req.MaxParallelPerSite <= 0 {
+		req.MaxParallelPerSite = 1
+	}
+	if req.TimeoutSeconds <= 0 {
+		req.TimeoutSeconds = int(DefaultNodeCommandTimeout.Seconds())
+	}
+	if req.HealthTimeoutSeconds <= 0 {
+		req.HealthTimeoutSeconds = int(DefaultPatchHealthTimeout.Seconds())
+	}
+
+	now := time.Now()
+	campaign := &PatchCampaign{
+		ID:                   generateID(),
+		NodeGroup:            NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID},
+		Name:                 req.Name,
+		PatchCommand:         req.PatchCommand,
+		RebootCommand:        req.RebootCommand,
+		HealthCommand:        req.HealthCommand,
+		TimeoutSeconds:       req.TimeoutSeconds,
+		HealthTimeoutSeconds: req.HealthTimeoutSeconds,
+		MaxParallel:          req.MaxParallel,
+		MaxParallelPerSite:   req.MaxParallelPerSite,
+		MaxFailures:          req.MaxFailures,
+		Status:               CampaignRunning,
+		Nodes:                make([]*PatchNode, 0),
+		CreatedAt:            now,
+	}
+	if campaign.NodeSelector == nil {
+		campaign.NodeSelector = make(map[string]string)
+	}
+
+	co.NodeManager.mutex.RLock()
+	for _, node := range co.NodeManager.nodes {
+		if node.Agentless || !campaign.matchesNode(node) {
+			continue
+		}
+		campaign.Nodes = append(campaign.Nodes, &PatchNode{
+			NodeID:    node.ID,
+			SiteID:    node.SiteID,
+			Status:    CampaignNodePending,
+			UpdatedAt: now,
+		})
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	if len(campaign.Nodes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No nodes match the campaign's node selection"})
+		return
+	}
+	sort.Slice(campaign.Nodes, func(i, j int) bool {
+		return campaign.Nodes[i].NodeID < campaign.Nodes[j].NodeID
+	})
+
+	co.PatchManager.mutex.Lock()
+	co.PatchManager.campaigns[campaign.ID] = campaign
+	co.PatchManager.mutex.Unlock()
+
+	co.Logger.Infof("Patch campaign %s created with ID %s targeting %d nodes", campaign.Name, campaign.ID, len(campaign.Nodes))
+	co.AuditLog.RecordRequest(c, "patch-campaign.create", "patch-campaign:"+campaign.ID, map[string]string{"name": campaign.Name})
+
+	c.JSON(http.StatusCreated, gin.H{"id": campaign.ID, "campaign": campaign})
+}
+
+// ListPatchCampaigns returns all patch campaigns, newest first
+func (co *CentralOrchestrator) ListPatchCampaigns(c *gin.Context) {
+	co.PatchManager.mutex.RLock()
+	defer co.PatchManager.mutex.RUnlock()
+
+	campaigns := make([]*PatchCampaign, 0, len(co.PatchManager.campaigns))
+	for _, campaign := range co.PatchManager.campaigns {
+		campaigns = append(campaigns, campaign)
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].CreatedAt.After(campaigns[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"campaigns": campaigns})
+}
+
+// GetPatchCampaign returns a specific patch campaign
+func (co *CentralOrchestrator) GetPatchCampaign(c *gin.Context) {
+	co.PatchManager.mutex.RLock()
+	defer co.PatchManager.mutex.RUnlock()
+
+	campaign, exists := co.PatchManager.campaigns[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"campaign": campaign})
+}
+
+// CancelPatchCampaign stops a campaign. Queued commands are failed; nodes being patched stay
+// cordoned for operators to uncordon once they have checked them.
+func (co *CentralOrchestrator) CancelPatchCampaign(c *gin.Context) {
+	co.PatchManager.mutex.Lock()
+	defer co.PatchManager.mutex.Unlock()
+
+	campaign, exists := co.PatchManager.campaigns[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	if campaign.Status != CampaignRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Campaign is not running"})
+		return
+	}
+
+	now := time.Now()
+	for _, pn := range campaign.Nodes {
+		if pn.Status == CampaignNodeRunning && pn.CommandID != "" {
+			co.CommandManager.Cancel(pn.CommandID, "campaign cancelled")
+		}
+	}
+	campaign.Status = CampaignCancelled
+	campaign.CompletedAt = &now
+
+	co.Logger.Infof("Patch campaign %s cancelled", campaign.ID)
+	co.AuditLog.RecordRequest(c, "patch-campaign.cancel", "patch-campaign:"+campaign.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"campaign": campaign})
+}
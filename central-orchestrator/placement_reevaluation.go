@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ReevaluationMode controls what happens to placements when a node's attributes change
+type ReevaluationMode string
+
+const (
+	// Add deployments to nodes that now qualify and remove them from nodes that no longer do
+	ReevaluationFull ReevaluationMode = "full"
+	// Only add deployments; existing ones stay until operators move them
+	ReevaluationAddOnly ReevaluationMode = "add-only"
+	// Leave placements alone
+	ReevaluationDisabled ReevaluationMode = "disabled"
+)
+
+// TaintEffect describes how a taint treats workloads that do not tolerate it
+type TaintEffect string
+
+const (
+	// New deployments are not placed on the node
+	TaintEffectNoSchedule TaintEffect = "NoSchedule"
+	// New deployments are not placed and existing ones are removed
+	TaintEffectNoExecute TaintEffect = "NoExecute"
+	// New deployments go to other nodes when any qualify
+	TaintEffectPreferNoSchedule TaintEffect = "PreferNoSchedule"
+)
+
+// TolerationOperator is how a toleration matches a taint's value
+type TolerationOperator string
+
+const (
+	// The values are equal; an empty value matches any, as before operators existed
+	TolerationOpEqual TolerationOperator = "Equal"
+	// Any value; with an empty key, any taint
+	TolerationOpExists TolerationOperator = "Exists"
+)
+
+// NodeTaint repels workloads that do not tolerate it
+type NodeTaint struct {
+	Key    string      `json:"key" binding:"required"`
+	Value  string      `json:"value"`
+	Effect TaintEffect `json:"effect" binding:"required"`
+}
+
+// Toleration lets a workload run on nodes with a matching taint; an empty value matches any
+type Toleration struct {
+	Key      string             `json:"key"`
+	Operator TolerationOperator `json:"operator,omitempty"`
+	Value    string             `json:"value"`
+	// Only taints with this effect are tolerated; empty tolerates all effects
+	Effect TaintEffect `json:"effect,omitempty"`
+}
+
+// NodeAttributesRequest updates a node's scheduling attributes; omitted fields are unchanged
+type NodeAttributesRequest struct {
+	Labels       map[string]string `json:"labels"`
+	Capabilities []string          `json:"capabilities"`
+	Taints       []NodeTaint       `json:"taints"`
+}
+
+// PlacementReevaluator holds the re-evaluation settings
+type PlacementReevaluator struct {
+	mode   ReevaluationMode
+	logger *logrus.Logger
+}
+
+// NewPlacementReevaluator creates a new placement reevaluator configured from PLACEMENT_REEVALUATION
+func NewPlacementReevaluator(logger *logrus.Logger) *PlacementReevaluator {
+	mode := ReevaluationMode(os.Getenv("PLACEMENT_REEVALUATION"))
+	switch mode {
+	case ReevaluationFull, ReevaluationAddOnly, ReevaluationDisabled:
+	case "":
+		mode = ReevaluationFull
+	default:
+		logger.Warnf("Unknown PLACEMENT_REEVALUATION mode %q, using %s", mode, ReevaluationFull)
+		mode = ReevaluationFull
+	}
+
+	return &PlacementReevaluator{
+		mode:   mode,
+		logger: logger,
+	}
+}
+
+// validTaintEffect reports whether a taint effect is known
+func validTaintEffect(effect TaintEffect) bool {
+	switch effect {
+	case TaintEffectNoSchedule, TaintEffectNoExecute, TaintEffectPreferNoSchedule:
+		return true
+	}
+	return false
+}
+
+// validateTaints checks node taints
+func validateTaints(taints []NodeTaint) error {
+	for _, taint := range taints {
+		if taint.Key == "" {
+			return fmt.Errorf("taint key is required")
+		}
+		if !validTaintEffect(taint.Effect) {
+			return fmt.Errorf("taint effect must be NoSchedule, PreferNoSchedule or NoExecute")
+		}
+	}
+	return nil
+}
+
+// validateTolerations checks a placement policy's tolerations
+func validateTolerations(placement PlacementPolicy) error {
+	for _, toleration := range placement.Tolerations {
+		switch toleration.Operator {
+		case "", TolerationOpEqual:
+			if toleration.Key == "" {
+				return fmt.Errorf("toleration key is required unless the operator is Exists")
+			}
+		case TolerationOpExists:
+			if toleration.Value != "" {
+				return fmt.Errorf("toleration %q with operator Exists must not have a value", toleration.Key)
+			}
+		default:
+			return fmt.Errorf("toleration %q has unknown operator %q", toleration.Key, toleration.Operator)
+		}
+		if toleration.Effect != "" && !validTaintEffect(toleration.Effect) {
+			return fmt.Errorf("toleration %q has unknown effect %q", toleration.Key, toleration.Effect)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the toleration matches the taint
+func (t Toleration) matches(taint NodeTaint) bool {
+	if t.Effect != "" && t.Effect != taint.Effect {
+		return false
+	}
+	if t.Operator == TolerationOpExists {
+		return t.Key == "" || t.Key == taint.Key
+	}
+	return t.Key == taint.Key && (t.Value == "" || t.Value == taint.Value)
+}
+
+// tolerates reports whether any toleration matches the taint
+func tolerates(tolerations []Toleration, taint NodeTaint) bool {
+	for _, toleration := range tolerations {
+		if toleration.matches(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// withDeclaredTaints adds the taints an agent declares to a node's current taints, keeping
+// the current taint of any key both have
+func withDeclaredTaints(current, declared []NodeTaint) []NodeTaint {
+	taints := append([]NodeTaint(nil), current...)
+	for _, taint := range declared {
+		set := false
+		for _, existing := range current {
+			set = set || existing.Key == taint.Key
+		}
+		if !set {
+			taints = append(taints, taint)
+		}
+	}
+	return taints
+}
+
+// untoleratedPreferNoSchedule counts a node's PreferNoSchedule taints the workload does not
+// tolerate
+func untoleratedPreferNoSchedule(node *EdgeNode, workload *Workload) int {
+	count := 0
+	for _, taint := range node.Taints {
+		if taint.Effect == TaintEffectPreferNoSchedule && !tolerates(workload.Placement.Tolerations, taint) {
+			count++
+		}
+	}
+	return count
+}
+
+// nodeAdmitsWorkload checks a node against a workload's constraints, tolerations, workload
+// affinity, CPU pinning and real-time kernel. For a deployment already on the node only NoExecute taints apply;
+// PreferNoSchedule taints only lower the node's rank.
+func (co *CentralOrchestrator) nodeAdmitsWorkload(node *EdgeNode, workload *Workload, existing bool) bool {
+	return co.workloadRejection(node, workload, existing) == ""
+}
+
+// workloadRejection returns why a node does not admit a workload, such as "region
+// constraint", or "" when it does
+func (co *CentralOrchestrator) workloadRejection(node *EdgeNode, workload *Workload, existing bool) string {
+	for _, constraint := range workload.Placement.Constraints {
+		if !co.nodeMatchesConstraint(node, constraint) {
+			return constraint.Key + " constraint"
+		}
+	}
+	if !workload.EnvironmentBinding.admits(node) {
+		return "environment"
+	}
+	for _, taint := range node.Taints {
+		if taint.Effect == TaintEffectPreferNoSchedule || (existing && taint.Effect != TaintEffectNoExecute) {
+			continue
+		}
+		if !tolerates(workload.Placement.Tolerations, taint) {
+			return "untolerated taint " + taint.Key
+		}
+	}
+	if workload.Realtime != nil && !nodeRunsRealtime(node) {
+		return "no real-time kernel"
+	}
+	// Like taints without NoExecute, workload affinity and CPU pinning are not enforced on
+	// running replicas
+	if !existing && !co.nodeAdmitsWorkloadAffinity(node, workload) {
+		return "workload affinity"
+	}
+	if pinning := workload.Resources.CPUPinning; !existing && pinning != nil && !nodeHonorsCPUPinning(node, pinning) {
+		return "cpu pinning not honored"
+	}
+	return ""
+}
+
+// changedAttributeKeys returns the constraint keys whose values differ between two versions
+// of a node: label keys, "capability", "dataset", and "taint:<key>" for taints
+func changedAttributeKeys(before, after *EdgeNode) map[string]bool {
+	changed := make(map[string]bool)
+
+	for key, value := range before.Labels {
+		if after.Labels[key] != value {
+			changed[key] = true
+		}
+	}
+	for key, value := range after.Labels {
+		if before.Labels[key] != value {
+			changed[key] = true
+		}
+	}
+
+	// Dataset labels also satisfy dataset constraints
+	for key := range changed {
+		if strings.HasPrefix(key, DatasetLabelPrefix) {
+			changed[DatasetConstraintKey] = true
+			break
+		}
+	}
+
+	if !sameStringSet(before.Capabilities, after.Capabilities) {
+		changed["capability"] = true
+	}
+
+	taints := func(node *EdgeNode) map[string]NodeTaint {
+		byKey := make(map[string]NodeTaint, len(node.Taints))
+		for _, taint := range node.Taints {
+			byKey[taint.Key] = taint
+		}
+		return byKey
+	}
+	beforeTaints, afterTaints := taints(before), taints(after)
+	for key, taint := range beforeTaints {
+		if afterTaints[key] != taint {
+			changed["taint:"+key] = true
+		}
+	}
+	for key, taint := range afterTaints {
+		if beforeTaints[key] != taint {
+			changed["taint:"+key] = true
+		}
+	}
+
+	return changed
+}
+
+// sameStringSet reports whether two slices hold the same distinct strings
+func sameStringSet(a, b []string) bool {
+	for _, s := range a {
+		if !contains(b, s) {
+			return false
+		}
+	}
+	for _, s := range b {
+		if !contains(a, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// referencesAttributes reports whether the workload's constraints or tolerations use any of
+// the changed keys. Real-time workloads depend on the node's capabilities.
+func (w *Workload) referencesAttributes(changed map[string]bool) bool {
+	if w.Realtime != nil && changed["capability"] {
+		return true
+	}
+	for _, constraint := range w.Placement.Constraints {
+		if changed[constraint.Key] {
+			return true
+		}
+	}
+	for _, toleration := range w.Placement.Tolerations {
+		if toleration.Key == "" {
+			// Tolerates every taint, so any taint change may matter
+			for key := range changed {
+				if strings.HasPrefix(key, "taint:") {
+					return true
+				}
+			}
+		}
+		if changed["taint:"+toleration.Key] {
+			return true
+		}
+	}
+	return false
+}
+
+// runsAtSite reports whether the workload has a placed deployment on another node at the site
+func (co *CentralOrchestrator) runsAtSite(workload *Workload, siteID string, online map[string]*EdgeNode) bool {
+	for _, deployment := range workload.Deployments {
+		if node := online[deployment.NodeID]; node != nil && deployment.placed() && node.SiteID == siteID {
+			return true
+		}
+	}
+	return false
+}
+
+// reevaluatePlacement re-checks the workloads affected by a change to a node's attributes.
+// Running workloads short of their replicas gain a deployment on the node when it now
+// qualifies; in full mode deployments on a node that no longer qualifies are removed and
+// re-placed elsewhere. Returns the recorded operation, or nil when nothing changed.
+func (co *CentralOrchestrator) reevaluatePlacement(nodeID string, changed map[string]bool) *Operation {
+	if co.PlacementReevaluator.mode == ReevaluationDisabled || len(changed) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(changed))
+	taintChanged := false
+	for key := range changed {
+		keys = append(keys, key)
+		taintChanged = taintChanged || strings.HasPrefix(key, "taint:")
+	}
+	sort.Strings(keys)
+	changedKeys := strings.Join(keys, ",")
+
+	online := co.onlineNodes(nil)
+	node := online[nodeID]
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workloads := make([]*Workload, 0, len(co.WorkloadManager.workloads))
+	for _, workload := range co.WorkloadManager.workloads {
+		workloads = append(workloads, workload)
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		return failoverLess(&failoverItem{workload: workloads[i]}, &failoverItem{workload: workloads[j]})
+	})
+
+	var op *Operation
+	addStep := func(step OperationStep) {
+		if op == nil {
+			op = co.OperationManager.Start("placement-reevaluation", "node "+nodeID)
+		}
+		co.OperationManager.AddStep(op, step)
+	}
+
+	committed := committedResources(co.WorkloadManager.workloads)
+	for _, workload := range workloads {
+		if workload.Status != WorkloadStatusRunning {
+			continue
+		}
+		deployment := workload.deploymentFor(nodeID)
+		running := deployment != nil && deployment.placed()
+		if !workload.referencesAttributes(changed) && !(running && taintChanged) {
+			continue
+		}
+
+		switch {
+		// Deployments on offline nodes are left to the failover controller
+		case running && node != nil && co.PlacementReevaluator.mode == ReevaluationFull && !co.nodeAdmitsWorkload(node, workload, true):
+			deployment.Status = WorkloadStatusStopped
+			deployment.UpdatedAt = time.Now()
+			workload.UpdatedAt = deployment.UpdatedAt
+			addStep(newOperationStep("removed", workload.ID, nodeID, true,
+				newMessage(MsgPlacementRemoved, "workload", workload.Name, "node", nodeID, "keys", changedKeys)))
+
+			planner := &failoverPlanner{co: co, workloads: co.WorkloadManager.workloads, online: online}
+			item := &failoverItem{workload: workload, replicas: deployment.Replicas, fromNode: nodeID}
+			if target := planner.place(item); target != nil {
+				addStep(newOperationStep("replaced", workload.ID, target.ID, true,
+					newMessage(MsgPlacementMoved, "replicas", item.replicas, "workload", workload.Name, "from_node", nodeID, "to_node", target.ID)))
+			} else {
+				// Leave the missing replicas to the scheduler, which can also request cloud capacity
+				workload.Status = WorkloadStatusPending
+			}
+			committed = committedResources(co.WorkloadManager.workloads)
+
+		case !running && node != nil && co.nodeSchedulable(node) && co.nodeAdmitsWorkload(node, workload, false):
+			if workload.scheduledReplicas() >= expectedReplicas(workload) || !co.fitsOnNode(node, committed[nodeID], workload, 1) {
+				continue
+			}
+			if workload.Placement.OneReplicaPerSite && (node.SiteID == "" || co.runsAtSite(workload, node.SiteID, online)) {
+				continue
+			}
+			workload.addDeployment(nodeID, 1)
+			committed[nodeID] = committed[nodeID].with(workload, 1)
+			addStep(newOperationStep("added", workload.ID, nodeID, true,
+				newMessage(MsgPlacementAdded, "workload", workload.Name, "node", nodeID, "keys", changedKeys)))
+		}
+	}
+
+	if op != nil {
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "Placements updated after node attributes changed: "+changedKeys)
+	}
+	return op
+}
+
+// UpdateNodeAttributes replaces a node's labels, capabilities or taints and re-evaluates
+// the placement of workloads that depend on the changed keys. Only callers allowed on both
+// the old and the new tenant may change the node's tenant label.
+func (co *CentralOrchestrator) UpdateNodeAttributes(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req NodeAttributesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateTaints(req.Taints); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.NodeManager.mutex.Lock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		co.NodeManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	before := *node
+	if req.Labels != nil {
+		labels := make(map[string]string, len(req.Labels)+1)
+		for key, value := range req.Labels {
+			labels[key] = value
+		}
+		// Labels sent without the tenant label keep the node's tenant; an empty one shares it
+		tenant, set := labels[NodeTenantLabel]
+		if !set {
+			tenant = nodeTenant(node)
+		}
+		if tenant != nodeTenant(node) && (!tenantAllowed(c, nodeTenant(node)) || !tenantAllowed(c, tenant)) {
+			co.NodeManager.mutex.Unlock()
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to move node %s to tenant %q", nodeID, tenant)})
+			return
+		}
+		delete(labels, NodeTenantLabel)
+		if tenant != "" {
+			labels[NodeTenantLabel] = tenant
+		}
+		node.Labels = labels
+	}
+	if req.Capabilities != nil {
+		node.Capabilities = req.Capabilities
+	}
+	if req.Taints != nil {
+		node.Taints = req.Taints
+	}
+	node.UpdatedAt = time.Now()
+	changed := changedAttributeKeys(&before, node)
+	updated := *node
+	co.NodeManager.mutex.Unlock()
+
+	co.Logger.Infof("Node %s attributes updated", nodeID)
+
+	response := gin.H{"node": &updated}
+	if op := co.reevaluatePlacement(nodeID, changed); op != nil {
+		response["operation_id"] = op.ID
+	}
+	c.JSON(http.StatusOK, response)
+}
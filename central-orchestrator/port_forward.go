@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Session lifetime when the operator does not ask for one, and the longest allowed
+	DefaultPortForwardTTL = 15 * time.Minute
+	MaxPortForwardTTL     = 2 * time.Hour
+
+	// How long an operator connection waits for the agent to attach
+	TunnelAttachTimeout = 30 * time.Second
+
+	// Longest an agent poll for streams is held open; kept below the server write timeout
+	TunnelMaxPollWait = 10 * time.Second
+
+	// Interval between checks for expired port-forward sessions
+	PortForwardCheckInterval = 10 * time.Second
+
+	// Protocol named in the Upgrade header of tunnel connections
+	TunnelUpgradeProtocol = "edge-tunnel"
+
+	// Header carrying the session token on operator connections
+	PortForwardTokenHeader = "X-Port-Forward-Token"
+)
+
+// PortForwardStatus represents the state of a port-forward session
+type PortForwardStatus string
+
+const (
+	PortForwardActive  PortForwardStatus = "active"
+	PortForwardClosed  PortForwardStatus = "closed"
+	PortForwardExpired PortForwardStatus = "expired"
+)
+
+// PortForwardSession lets an operator open streams to a workload port on one node until it
+// expires. Streams run operator -> orchestrator -> agent -> pod; the agent dials out, so
+// nodes behind NAT need no inbound access.
+type PortForwardSession struct {
+	ID           string            `json:"id"`
+	WorkloadID   string            `json:"workload_id"`
+	WorkloadName string            `json:"workload_name"`
+	Namespace    string            `json:"namespace"`
+	Selector     map[string]string `json:"selector"`
+	NodeID       string            `json:"node_id"`
+	Port         int32             `json:"port"`
+	Actor        string            `json:"actor"`
+	Status       PortForwardStatus `json:"status"`
+	Streams      int               `json:"streams"`
+	BytesToPod   int64             `json:"bytes_to_pod"`
+	BytesFromPod int64             `json:"bytes_from_pod"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ExpiresAt    time.Time         `json:"expires_at"`
+	ClosedAt     *time.Time        `json:"closed_at,omitempty"`
+	token        string
+}
+
+// PortForwardRequest represents a port-forward session request; workload is an ID or name
+type PortForwardRequest struct {
+	Workload string `json:"workload" binding:"required"`
+	Port     int32  `json:"port" binding:"required"`
+	NodeID   string `json:"node_id"`
+	TTL      string `json:"ttl"`
+}
+
+// TunnelStream is a single operator connection waiting for the agent to attach
+type TunnelStream struct {
+	ID        string            `json:"id"`
+	SessionID string            `json:"session_id"`
+	Namespace string            `json:"namespace"`
+	Selector  map[string]string `json:"selector"`
+	Port      int32             `json:"port"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// TunnelStreamRejectRequest is an agent's report that it could not reach the pod
+type TunnelStreamRejectRequest struct {
+	Error string `json:"error" binding:"required"`
+}
+
+// pendingStream is a stream the orchestrator is holding until the agent attaches or rejects it
+type pendingStream struct {
+	TunnelStream
+	nodeID   string
+	attached chan net.Conn
+	rejected chan string
+}
+
+// TunnelBroker pairs operator connections with agent connections for port-forward sessions
+type TunnelBroker struct {
+	sessions map[string]*PortForwardSession
+	pending  map[string]*pendingStream
+	conns    map[string]map[net.Conn]bool
+	notify   map[string]chan struct{}
+	mutex    sync.Mutex
+	logger   *logrus.Logger
+}
+
+// NewTunnelBroker creates a new tunnel broker
+func NewTunnelBroker(logger *logrus.Logger) *TunnelBroker {
+	return &TunnelBroker{
+		sessions: make(map[string]*PortForwardSession),
+		pending:  make(map[string]*pendingStream),
+		conns:    make(map[string]map[net.Conn]bool),
+		notify:   make(map[string]chan struct{}),
+		logger:   logger,
+	}
+}
+
+// nodeSignal returns the channel closed when a stream is queued for the node; callers must
+// hold the lock
+func (tb *TunnelBroker) nodeSignal(nodeID string) chan struct{} {
+	ch, exists := tb.notify[nodeID]
+	if !exists {
+		ch = make(chan struct{})
+		tb.notify[nodeID] = ch
+	}
+	return ch
+}
+
+// streamsFor returns the streams waiting for a node; callers must hold the lock
+func (tb *TunnelBroker) streamsFor(nodeID string) []TunnelStream {
+	streams := make([]TunnelStream, 0)
+	for _, stream := range tb.pending {
+		if stream.nodeID == nodeID {
+			streams = append(streams, stream.TunnelStream)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].CreatedAt.Before(streams[j].CreatedAt)
+	})
+	return streams
+}
+
+// closeSession ends a session and drops its open connections; callers must hold the lock
+func (tb *TunnelBroker) closeSession(session *PortForwardSession, status PortForwardStatus) {
+	now := time.Now()
+	session.Status = status
+	session.ClosedAt = &now
+	for conn := range tb.conns[session.ID] {
+		conn.Close()
+	}
+	delete(tb.conns, session.ID)
+}
+
+// bufferedConn reads through the buffer filled while parsing the upgrade request
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.reader.Read(p)
+}
+
+// upgradeTunnel switches the request's connection to a raw tunnel stream
+func upgradeTunnel(c *gin.Context) (net.Conn, error) {
+	if !strings.EqualFold(c.GetHeader("Upgrade"), TunnelUpgradeProtocol) {
+		return nil, fmt.Errorf("expected Upgrade: %s", TunnelUpgradeProtocol)
+	}
+
+	conn, rw, err := c.Writer.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack connection: %v", err)
+	}
+	// Server read/write timeouts would otherwise cut long-lived streams
+	conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: " + TunnelUpgradeProtocol + "\r\nConnection: Upgrade\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write upgrade response: %v", err)
+	}
+	return &bufferedConn{Conn: conn, reader: rw.Reader}, nil
+}
+
+// splice copies between the operator and agent connections until either side closes and
+// returns the bytes sent to and received from the pod
+func splice(operator, agent net.Conn) (int64, int64) {
+	var toPod, fromPod int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		toPod, _ = io.Copy(agent, operator)
+		agent.Close()
+		operator.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		fromPod, _ = io.Copy(operator, agent)
+		agent.Close()
+		operator.Close()
+	}()
+
+	wg.Wait()
+	return toPod, fromPod
+}
+
+// CreatePortForward opens a port-forward session to a workload port on a node running it
+func (co *CentralOrchestrator) CreatePortForward(c *gin.Context) {
+	var req PortForwardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ttl := DefaultPortForwardTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration"})
+			return
+		}
+		ttl = parsed
+	}
+	if ttl > MaxPortForwardTTL {
+		ttl = MaxPortForwardTTL
+	}
+
+	// Workloads of tenants the caller may not act on are not found
+	co.WorkloadManager.mutex.RLock()
+	workload, exists := co.WorkloadManager.workloads[req.Workload]
+	if !exists {
+		for _, candidate := range co.WorkloadManager.workloads {
+			if candidate.Name == req.Workload && tenantAllowed(c, workloadTenant(candidate)) {
+				workload = candidate
+				break
+			}
+		}
+	} else if !tenantAllowed(c, workloadTenant(workload)) {
+		workload = nil
+	}
+	var session *PortForwardSession
+	if workload != nil {
+		var nodeIDs []string
+		for _, deployment := range workload.Deployments {
+			if deployment.Status == WorkloadStatusRunning && (req.NodeID == "" || deployment.NodeID == req.NodeID) {
+				nodeIDs = append(nodeIDs, deployment.NodeID)
+			}
+		}
+		sort.Strings(nodeIDs)
+		if len(nodeIDs) > 0 {
+			now := time.Now()
+			session = &PortForwardSession{
+				ID:           generateID(),
+				WorkloadID:   workload.ID,
+				WorkloadName: workload.Name,
+				Namespace:    workload.Namespace,
+				Selector:     workload.Selector,
+				NodeID:       nodeIDs[0],
+				Port:         req.Port,
+				Actor:        requestActor(c),
+				Status:       PortForwardActive,
+				CreatedAt:    now,
+				ExpiresAt:    now.Add(ttl),
+				token:        generateID(),
+			}
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	if workload == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+	if session == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Workload is not running on any matching node"})
+		return
+	}
+
+	co.TunnelBroker.mutex.Lock()
+	co.TunnelBroker.sessions[session.ID] = session
+	co.TunnelBroker.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "port-forward.open", "workload:"+session.WorkloadID, map[string]string{
+		"session_id": session.ID,
+		"node_id":    session.NodeID,
+		"port":       strconv.Itoa(int(session.Port)),
+		"expires_at": session.ExpiresAt.Format(time.RFC3339),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"session": session, "token": session.token})
+}
+
+// ListPortForwards returns port-forward sessions to the workloads the caller may see, newest
+// first
+func (co *CentralOrchestrator) ListPortForwards(c *gin.Context) {
+	view := co.tenantView(c)
+	co.TunnelBroker.mutex.Lock()
+	defer co.TunnelBroker.mutex.Unlock()
+
+	sessions := make([]*PortForwardSession, 0, len(co.TunnelBroker.sessions))
+	for _, session := range co.TunnelBroker.sessions {
+		if status := c.Query("status"); status != "" && string(session.Status) != status {
+			continue
+		}
+		if !view.workload(session.WorkloadID) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// ClosePortForward ends a session and its open streams
+func (co *CentralOrchestrator) ClosePortForward(c *gin.Context) {
+	view := co.tenantView(c)
+	co.TunnelBroker.mutex.Lock()
+	session, exists := co.TunnelBroker.sessions[c.Param("id")]
+	if !exists || !view.workload(session.WorkloadID) {
+		co.TunnelBroker.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Port-forward session not found"})
+		return
+	}
+	if session.Status != PortForwardActive {
+		co.TunnelBroker.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Port-forward session is not active"})
+		return
+	}
+	co.TunnelBroker.closeSession(session, PortForwardClosed)
+	co.TunnelBroker.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "port-forward.close", "workload:"+session.WorkloadID, map[string]string{
+		"session_id":     session.ID,
+		"streams":        strconv.Itoa(session.Streams),
+		"bytes_to_pod":   strconv.FormatInt(session.BytesToPod, 10),
+		"bytes_from_pod": strconv.FormatInt(session.BytesFromPod, 10),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Port-forward session closed"})
+}
+
+// ConnectPortForward upgrades an operator connection into a stream and holds it until the
+// node's agent attaches the other end
+func (co *CentralOrchestrator) ConnectPortForward(c *gin.Context) {
+	tb := co.TunnelBroker
+
+	tb.mutex.Lock()
+	session, exists := tb.sessions[c.Param("id")]
+	var failure string
+	status := http.StatusOK
+	switch {
+	case !exists:
+		failure, status = "Port-forward session not found", http.StatusNotFound
+	case subtle.ConstantTimeCompare([]byte(c.GetHeader(PortForwardTokenHeader)), []byte(session.token)) != 1:
+		failure, status = "Invalid port-forward token", http.StatusForbidden
+	case session.Status != PortForwardActive || time.Now().After(session.ExpiresAt):
+		failure, status = "Port-forward session has ended", http.StatusGone
+	}
+	tb.mutex.Unlock()
+
+	if failure != "" {
+		c.JSON(status, gin.H{"error": failure})
+		return
+	}
+
+	operator, err := upgradeTunnel(c)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	stream := &pendingStream{
+		TunnelStream: TunnelStream{
+			ID:        generateID(),
+			SessionID: session.ID,
+			Namespace: session.Namespace,
+			Selector:  session.Selector,
+			Port:      session.Port,
+			CreatedAt: time.Now(),
+		},
+		nodeID:   session.NodeID,
+		attached: make(chan net.Conn, 1),
+		rejected: make(chan string, 1),
+	}
+
+	tb.mutex.Lock()
+	tb.pending[stream.ID] = stream
+	if tb.conns[session.ID] == nil {
+		tb.conns[session.ID] = make(map[net.Conn]bool)
+	}
+	tb.conns[session.ID][operator] = true
+	close(tb.nodeSignal(session.NodeID))
+	delete(tb.notify, session.NodeID)
+	tb.mutex.Unlock()
+
+	var agent net.Conn
+	select {
+	case agent = <-stream.attached:
+	case reason := <-stream.rejected:
+		co.Logger.Warnf("Agent on node %s rejected stream %s: %s", session.NodeID, stream.ID, reason)
+	case <-time.After(TunnelAttachTimeout):
+		co.Logger.Warnf("Agent on node %s did not attach stream %s", session.NodeID, stream.ID)
+	}
+
+	tb.mutex.Lock()
+	delete(tb.pending, stream.ID)
+	if agent == nil {
+		// The agent may have attached just as the wait gave up
+		select {
+		case late := <-stream.attached:
+			late.Close()
+		default:
+		}
+	} else if session.Status != PortForwardActive {
+		agent.Close()
+		agent = nil
+	} else {
+		session.Streams++
+		tb.conns[session.ID][agent] = true
+	}
+	tb.mutex.Unlock()
+
+	if agent == nil {
+		operator.Close()
+		return
+	}
+
+	toPod, fromPod := splice(operator, agent)
+
+	tb.mutex.Lock()
+	session.BytesToPod += toPod
+	session.BytesFromPod += fromPod
+	delete(tb.conns[session.ID], operator)
+	delete(tb.conns[session.ID], agent)
+	tb.mutex.Unlock()
+}
+
+// GetTunnelStreams returns the streams waiting for a node's agent. With wait=<seconds> the
+// request is held until a stream arrives or the wait elapses.
+func (co *CentralOrchestrator) GetTunnelStreams(c *gin.Context) {
+	nodeID := c.Param("id")
+	tb := co.TunnelBroker
+
+	wait := time.Duration(0)
+	if value := c.Query("wait"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a non-negative number of seconds"})
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > TunnelMaxPollWait {
+		wait = TunnelMaxPollWait
+	}
+
+	tb.mutex.Lock()
+	streams := tb.streamsFor(nodeID)
+	signal := tb.nodeSignal(nodeID)
+	tb.mutex.Unlock()
+
+	if len(streams) == 0 && wait > 0 {
+		select {
+		case <-signal:
+		case <-time.After(wait):
+		case <-c.Request.Context().Done():
+			return
+		}
+		tb.mutex.Lock()
+		streams = tb.streamsFor(nodeID)
+		tb.mutex.Unlock()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"streams": streams})
+}
+
+// takeStream removes a stream queued for the node, or returns nil
+func (tb *TunnelBroker) takeStream(nodeID, streamID string) *pendingStream {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	stream, exists := tb.pending[streamID]
+	if !exists || stream.nodeID != nodeID {
+		return nil
+	}
+	delete(tb.pending, streamID)
+	return stream
+}
+
+// AttachTunnelStream upgrades an agent connection and joins it to the waiting operator stream
+func (co *CentralOrchestrator) AttachTunnelStream(c *gin.Context) {
+	stream := co.TunnelBroker.takeStream(c.Param("id"), c.Param("sid"))
+	if stream == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tunnel stream not found"})
+		return
+	}
+
+	conn, err := upgradeTunnel(c)
+	if err != nil {
+		stream.rejected <- err.Error()
+		if !c.Writer.Written() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	stream.attached <- conn
+}
+
+// RejectTunnelStream records that the agent could not reach the pod for a stream
+func (co *CentralOrchestrator) RejectTunnelStream(c *gin.Context) {
+	var req TunnelStreamRejectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stream := co.TunnelBroker.takeStream(c.Param("id"), c.Param("sid"))
+	if stream == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tunnel stream not found"})
+		return
+	}
+	stream.rejected <- req.Error
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tunnel stream rejected"})
+}
+
+// portForwardReaper expires port-forward sessions past their TTL
+func (co *CentralOrchestrator) portForwardReaper() {
+	ticker := time.NewTicker(PortForwardCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.expirePortForwards(time.Now())
+		}
+	}
+}
+
+// expirePortForwards closes active sessions whose TTL has passed
+func (co *CentralOrchestrator) expirePortForwards(now time.Time) {
+	co.TunnelBroker.mutex.Lock()
+	var expired []*PortForwardSession
+	for _, session := range co.TunnelBroker.sessions {
+		if session.Status == PortForwardActive && now.After(session.ExpiresAt) {
+			co.TunnelBroker.closeSession(session, PortForwardExpired)
+			expired = append(expired, session)
+		}
+	}
+	co.TunnelBroker.mutex.Unlock()
+
+	for _, session := range expired {
+		co.AuditLog.Record("system", "", "port-forward.expire", "workload:"+session.WorkloadID, map[string]string{
+			"session_id":     session.ID,
+			"streams":        strconv.Itoa(session.Streams),
+			"bytes_to_pod":   strconv.FormatInt(session.BytesToPod, 10),
+			"bytes_from_pod": strconv.FormatInt(session.BytesFromPod, 10),
+		})
+	}
+}
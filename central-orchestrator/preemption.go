@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Taint keeping new replicas off a node whose instance is being preempted
+	PreemptedTaint = "edge.io/preempted"
+)
+
+// PreemptionNotice is a spot instance's notice that the provider is reclaiming it, as read
+// by its agent from the instance metadata service
+type PreemptionNotice struct {
+	Provider string `json:"provider" binding:"required"`
+	// What the provider will do to the instance: "terminate", "stop" or "hibernate"
+	Action string `json:"action"`
+	// When the provider reclaims the instance, if it announced it
+	TerminateAt *time.Time `json:"terminate_at,omitempty"`
+	DetectedAt  time.Time  `json:"detected_at"`
+	ReceivedAt  time.Time  `json:"received_at"`
+}
+
+// ReportNodePreemption records a node's preemption notice, keeps new replicas off the node
+// and moves its workloads to other nodes before the instance goes away
+func (co *CentralOrchestrator) ReportNodePreemption(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var notice PreemptionNotice
+	if err := c.ShouldBindJSON(&notice); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if notice.Action == "" {
+		notice.Action = "terminate"
+	}
+	if notice.DetectedAt.IsZero() {
+		notice.DetectedAt = time.Now()
+	}
+	notice.ReceivedAt = time.Now()
+
+	co.NodeManager.mutex.Lock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		co.NodeManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+	// Agents repeat the notice until it is acknowledged; evacuate only once
+	repeated := node.Preemption != nil
+	if !repeated {
+		node.Preemption = &notice
+		node.Taints = append(node.Taints, NodeTaint{Key: PreemptedTaint, Value: notice.Action, Effect: TaintEffectNoSchedule})
+		node.UpdatedAt = time.Now()
+		co.CloudProvisioner.markPreempted(nodeID)
+	}
+	co.NodeManager.mutex.Unlock()
+
+	if repeated {
+		c.JSON(http.StatusOK, gin.H{"message": "Preemption already recorded"})
+		return
+	}
+
+	co.Logger.Warnf("Node %s received a preemption notice from %s", nodeID, notice.Provider)
+	op := co.evacuatePreemptedNode(nodeID, notice)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Node is being evacuated", "operation": co.localizeOperation(op, requestLocale(c))})
+}
+
+// evacuatePreemptedNode re-places the replicas on a preempted node, most critical first,
+// and records the preemption and every move as an operation
+func (co *CentralOrchestrator) evacuatePreemptedNode(nodeID string, notice PreemptionNotice) *Operation {
+	online := co.onlineNodes(map[string]bool{nodeID: true})
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	terminateAt := "an unannounced time"
+	if notice.TerminateAt != nil {
+		terminateAt = notice.TerminateAt.Format(time.RFC3339)
+	}
+
+	op := co.OperationManager.Start("preemption-evacuation", "node "+nodeID)
+	co.OperationManager.AddStep(op, newOperationStep("preempted", "", nodeID, true,
+		newMessage(MsgNodePreempted, "node", nodeID, "provider", notice.Provider, "action", notice.Action, "terminate_at", terminateAt)))
+
+	planner := &failoverPlanner{co: co, workloads: co.WorkloadManager.workloads, online: online,
+		lost: map[string]bool{nodeID: true}}
+	queue := planner.failLostDeployments()
+	if len(queue) == 0 {
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "No workloads to evacuate")
+		return op
+	}
+
+	var unplaced []string
+	for _, outcome := range planner.plan(queue) {
+		item := outcome.item
+		switch outcome.action {
+		case "unschedulable":
+			unplaced = append(unplaced, item.workload.Name)
+			// The scheduler places the missing replicas once there is room
+			item.workload.Status = WorkloadStatusPending
+			item.workload.UpdatedAt = time.Now()
+			co.AlertManager.Fire(WorkloadUnschedulableAlert, AlertSeverityCritical, AlertScopeWorkload, item.workload.ID, "",
+				newMessage(MsgWorkloadUnschedulable, "replicas", item.replicas, "workload", item.workload.Name, "from_node", item.fromNode))
+		case "replaced":
+			co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, item.workload.ID)
+		}
+		co.OperationManager.AddStep(op, outcome.step())
+	}
+
+	// Push the moves to connected agents without waiting for the next tick
+	co.AgentStreamHub.wake()
+
+	if len(unplaced) > 0 {
+		co.OperationManager.Complete(op, OperationStatusPartial, fmt.Sprintf("No capacity for %s", strings.Join(unplaced, ", ")))
+	} else {
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "All replicas moved off the preempted node")
+	}
+	return op
+}
+
+// markPreempted records that a cloud node's instance is being reclaimed by its provider
+func (cp *CloudProvisioner) markPreempted(nodeID string) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	for _, cloudNode := range cp.nodes {
+		if cloudNode.NodeID == nodeID && cloudNode.Status == CloudNodeReady {
+			cloudNode.Status = CloudNodePreempted
+			return
+		}
+	}
+}
@@ -0,0 +1,744 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Prefix of the Prometheus names of stored metrics, as in edge_node_cpu_percent
+	PrometheusMetricPrefix = "edge_"
+
+	// How far back an instant looks for a sample, as in Prometheus
+	PrometheusLookback = 5 * time.Minute
+
+	// Most points a range query returns per series, as in Prometheus
+	MaxPrometheusPoints = 11000
+
+	// Prometheus version reported to clients that adapt their queries to it
+	PrometheusCompatibleVersion = "2.40.0"
+)
+
+// promAggregations are the aggregation operators queries may use
+var promAggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// promInvalidNameChars are replaced in the Prometheus names of stored metrics
+var promInvalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// promMatcher is a label matcher of a series selector
+type promMatcher struct {
+	label string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// matches reports whether a label value satisfies the matcher; missing labels are empty
+func (m promMatcher) matches(value string) bool {
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+// promQuery is a query of the supported PromQL subset: a series selector, optionally
+// aggregated by labels, or arithmetic on two numbers, which Grafana sends to test a
+// datasource
+type promQuery struct {
+	aggregation string
+	by          []string
+	matchers    []promMatcher
+	scalar      *float64
+}
+
+// promParser reads a query left to right
+type promParser struct {
+	input string
+	pos   int
+}
+
+func (p *promParser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *promParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *promParser) expect(ch byte) error {
+	if p.peek() != ch {
+		return fmt.Errorf("expected %q at position %d", ch, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// identifier reads a metric or label name; "" when none follows
+func (p *promParser) identifier() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		if ch == '_' || ch == ':' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (p.pos > start && ch >= '0' && ch <= '9') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+// stringLiteral reads a single or double quoted string
+func (p *promParser) stringLiteral() (string, error) {
+	quote := p.peek()
+	if quote != '"' && quote != '\'' {
+		return "", fmt.Errorf("expected a quoted string at position %d", p.pos)
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.input) && p.input[p.pos] != quote; p.pos++ {
+		if p.input[p.pos] == '\\' {
+			p.pos++
+		}
+	}
+	if p.pos >= len(p.input) {
+		return "", fmt.Errorf("unterminated string at position %d", start)
+	}
+	p.pos++
+	literal := p.input[start:p.pos]
+	if quote == '\'' {
+		literal = `"` + strings.ReplaceAll(strings.ReplaceAll(literal[1:len(literal)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(literal)
+}
+
+// labelList reads a parenthesized list of label names
+func (p *promParser) labelList() ([]string, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for p.peek() != ')' {
+		label := p.identifier()
+		if label == "" {
+			return nil, fmt.Errorf("expected a label name at position %d", p.pos)
+		}
+		labels = append(labels, label)
+		if p.peek() == ',' {
+			p.pos++
+		}
+	}
+	p.pos++
+	return labels, nil
+}
+
+// selector reads a series selector such as edge_node_cpu_percent{site="store-1"}
+func (p *promParser) selector() ([]promMatcher, error) {
+	var matchers []promMatcher
+	if name := p.identifier(); name != "" {
+		matchers = append(matchers, promMatcher{label: "__name__", op: "=", value: name})
+	}
+	if p.peek() == '{' {
+		p.pos++
+		for p.peek() != '}' {
+			label := p.identifier()
+			if label == "" {
+				return nil, fmt.Errorf("expected a label name at position %d", p.pos)
+			}
+			p.skipSpace()
+			var op string
+			for _, candidate := range []string{"=~", "!~", "!=", "="} {
+				if strings.HasPrefix(p.input[p.pos:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("expected a matcher operator at position %d", p.pos)
+			}
+			p.pos += len(op)
+			value, err := p.stringLiteral()
+			if err != nil {
+				return nil, err
+			}
+			matcher := promMatcher{label: label, op: op, value: value}
+			if op == "=~" || op == "!~" {
+				// Regular expressions match whole values, as in Prometheus
+				if matcher.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+					return nil, fmt.Errorf("invalid regular expression %q: %v", value, err)
+				}
+			}
+			matchers = append(matchers, matcher)
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+		p.pos++
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("expected a series selector at position %d", p.pos)
+	}
+	return matchers, nil
+}
+
+// parsePromQuery parses a query of the supported PromQL subset
+func parsePromQuery(query string) (*promQuery, error) {
+	if value, ok := parsePromArithmetic(query); ok {
+		return &promQuery{scalar: &value}, nil
+	}
+
+	p := &promParser{input: query}
+	q := &promQuery{}
+	start := p.pos
+	if name := p.identifier(); promAggregations[name] && (p.peek() == '(' || strings.HasPrefix(p.input[p.pos:], "by")) {
+		q.aggregation = name
+		if p.identifier() == "by" {
+			labels, err := p.labelList()
+			if err != nil {
+				return nil, err
+			}
+			q.by = labels
+		}
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		matchers, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		q.matchers = matchers
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		if mark := p.pos; p.identifier() == "by" && q.by == nil {
+			labels, err := p.labelList()
+			if err != nil {
+				return nil, err
+			}
+			q.by = labels
+		} else {
+			p.pos = mark
+		}
+	} else {
+		p.pos = start
+		matchers, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		q.matchers = matchers
+	}
+
+	if p.peek() != 0 {
+		return nil, fmt.Errorf("unsupported expression at position %d: only series selectors, sum, avg, min, max and count by labels are supported", p.pos)
+	}
+	return q, nil
+}
+
+// parsePromArithmetic evaluates a number or two numbers joined by +, -, * or /
+func parsePromArithmetic(query string) (float64, bool) {
+	query = strings.TrimSpace(query)
+	if value, err := strconv.ParseFloat(query, 64); err == nil {
+		return value, true
+	}
+	for i := 1; i < len(query); i++ {
+		op := query[i]
+		if !strings.ContainsRune("+-*/", rune(op)) {
+			continue
+		}
+		a, errA := strconv.ParseFloat(strings.TrimSpace(query[:i]), 64)
+		b, errB := strconv.ParseFloat(strings.TrimSpace(query[i+1:]), 64)
+		if errA != nil || errB != nil {
+			continue
+		}
+		switch op {
+		case '+':
+			return a + b, true
+		case '-':
+			return a - b, true
+		case '*':
+			return a * b, true
+		default:
+			return a / b, true
+		}
+	}
+	return 0, false
+}
+
+// promSeries is a stored series with its Prometheus labels
+type promSeries struct {
+	id     metricSeriesID
+	labels map[string]string
+}
+
+// metricSeriesID identifies a stored series
+type metricSeriesID struct {
+	class    MetricClass
+	name     string
+	entityID string
+}
+
+// seriesIDs lists the stored series
+func (ms *MetricsStore) seriesIDs() []metricSeriesID {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	ids := make([]metricSeriesID, 0, len(ms.series))
+	for _, series := range ms.series {
+		ids = append(ids, metricSeriesID{class: series.class, name: series.name, entityID: series.entityID})
+	}
+	return ids
+}
+
+// promSeriesList returns the stored series with their Prometheus labels. A series is named
+// edge_<class>_<metric>; link metrics carry their target as a label. Node, workload and
+// function series are labeled with their entity's current inventory. Only the series the
+// caller may see are listed.
+func (co *CentralOrchestrator) promSeriesList(c *gin.Context) []promSeries {
+	visible := co.metricSeriesFilter(c)
+	ids := co.MetricsStore.seriesIDs()
+
+	entities := make(map[string]map[string]string)
+	co.NodeManager.mutex.RLock()
+	for _, node := range co.NodeManager.nodes {
+		entities[string(MetricClassNode)+"/"+node.ID] = map[string]string{
+			"id": node.ID, "name": node.Name, "site": node.SiteID, "region": node.Region, "zone": node.Zone,
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RLock()
+	for _, workload := range co.WorkloadManager.workloads {
+		entities[string(MetricClassWorkload)+"/"+workload.ID] = map[string]string{
+			"id": workload.ID, "name": workload.Name, "namespace": workload.Namespace, "tenant": workload.Tenant,
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	list := make([]promSeries, 0, len(ids))
+	for _, id := range ids {
+		if !visible(id.class, id.entityID) {
+			continue
+		}
+		labels := map[string]string{}
+		if id.entityID != "" {
+			labels["id"] = id.entityID
+		}
+		// Billing series are per workload and site
+		entityClass, qualifier := id.class, "target"
+		if id.class == MetricClassBilling {
+			entityClass, qualifier = MetricClassWorkload, "site"
+		}
+		for key, value := range entities[string(entityClass)+"/"+id.entityID] {
+			if value != "" {
+				labels[key] = value
+			}
+		}
+		name := id.name
+		if metric, value, qualified := strings.Cut(name, ":"); qualified {
+			name = metric
+			labels[qualifier] = value
+		}
+		labels["__name__"] = PrometheusMetricPrefix + string(id.class) + "_" + promInvalidNameChars.ReplaceAllString(name, "_")
+		list = append(list, promSeries{id: id, labels: labels})
+	}
+	return list
+}
+
+// promSelect returns the series every matcher selects
+func (co *CentralOrchestrator) promSelect(c *gin.Context, matchers []promMatcher) []promSeries {
+	var selected []promSeries
+	for _, series := range co.promSeriesList(c) {
+		matched := true
+		for _, matcher := range matchers {
+			if !matcher.matches(series.labels[matcher.label]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			selected = append(selected, series)
+		}
+	}
+	return selected
+}
+
+// promSample is a series' value at one instant
+type promSample struct {
+	at    time.Time
+	value float64
+}
+
+// promEvaluate returns the labels and samples of each result series of a query at the
+// given instants
+func (co *CentralOrchestrator) promEvaluate(c *gin.Context, q *promQuery, instants []time.Time) ([]map[string]string, [][]promSample, error) {
+	if len(instants) == 0 {
+		return nil, nil, nil
+	}
+	from, to := instants[0], instants[len(instants)-1]
+
+	var labels []map[string]string
+	var samples [][]promSample
+	for _, series := range co.promSelect(c, q.matchers) {
+		points, resolution, err := co.MetricsStore.History(series.id.class, series.id.name, series.id.entityID, "auto", from.Add(-PrometheusLookback-time.Hour), to)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Downsampled points stand for the bucket they start
+		lookback := PrometheusLookback
+		switch resolution {
+		case "5m":
+			lookback += MetricsResolutionFiveMinute
+		case "1h":
+			lookback += MetricsResolutionHourly
+		}
+
+		var values []promSample
+		next := 0
+		for _, at := range instants {
+			for next < len(points) && !points[next].Timestamp.After(at) {
+				next++
+			}
+			if next > 0 && at.Sub(points[next-1].Timestamp) < lookback {
+				values = append(values, promSample{at: at, value: points[next-1].Avg})
+			}
+		}
+		if len(values) > 0 {
+			labels = append(labels, series.labels)
+			samples = append(samples, values)
+		}
+	}
+
+	if q.aggregation == "" {
+		return labels, samples, nil
+	}
+	return promAggregate(q, labels, samples)
+}
+
+// promAggregate combines series that share the values of the query's by labels
+func promAggregate(q *promQuery, labels []map[string]string, samples [][]promSample) ([]map[string]string, [][]promSample, error) {
+	type group struct {
+		labels map[string]string
+		values map[int64][]float64
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for i, seriesLabels := range labels {
+		groupLabels := make(map[string]string, len(q.by))
+		parts := make([]string, len(q.by))
+		for j, label := range q.by {
+			if value := seriesLabels[label]; value != "" {
+				groupLabels[label] = value
+			}
+			parts[j] = seriesLabels[label]
+		}
+		key := strings.Join(parts, "\x00")
+		g, exists := groups[key]
+		if !exists {
+			g = &group{labels: groupLabels, values: make(map[int64][]float64)}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		for _, sample := range samples[i] {
+			g.values[sample.at.UnixNano()] = append(g.values[sample.at.UnixNano()], sample.value)
+		}
+	}
+	sort.Strings(keys)
+
+	aggregatedLabels := make([]map[string]string, 0, len(keys))
+	aggregated := make([][]promSample, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		instants := make([]int64, 0, len(g.values))
+		for at := range g.values {
+			instants = append(instants, at)
+		}
+		sort.Slice(instants, func(i, j int) bool { return instants[i] < instants[j] })
+
+		values := make([]promSample, 0, len(instants))
+		for _, at := range instants {
+			values = append(values, promSample{at: time.Unix(0, at), value: aggregateValues(q.aggregation, g.values[at])})
+		}
+		aggregatedLabels = append(aggregatedLabels, g.labels)
+		aggregated = append(aggregated, values)
+	}
+	return aggregatedLabels, aggregated, nil
+}
+
+// aggregateValues applies an aggregation operator to the values of one instant
+func aggregateValues(aggregation string, values []float64) float64 {
+	switch aggregation {
+	case "count":
+		return float64(len(values))
+	case "min":
+		result := math.Inf(1)
+		for _, value := range values {
+			result = math.Min(result, value)
+		}
+		return result
+	case "max":
+		result := math.Inf(-1)
+		for _, value := range values {
+			result = math.Max(result, value)
+		}
+		return result
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	if aggregation == "avg" {
+		return sum / float64(len(values))
+	}
+	return sum
+}
+
+// parsePromTime parses a Prometheus API time, in unix seconds or RFC 3339
+func parsePromTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9)), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", value)
+}
+
+// parsePromStep parses a query step, in seconds or as a duration such as "30s"
+func parsePromStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return parsePromDuration(value)
+}
+
+// promValue formats a sample as the Prometheus API does, a time and a string value
+func promValue(sample promSample) []interface{} {
+	return []interface{}{float64(sample.at.UnixNano()) / 1e9, strconv.FormatFloat(sample.value, 'f', -1, 64)}
+}
+
+// promError responds with a Prometheus API error
+func promError(c *gin.Context, status int, errorType string, err error) {
+	c.JSON(status, gin.H{"status": "error", "errorType": errorType, "error": err.Error()})
+}
+
+// promSuccess responds with Prometheus API data
+func promSuccess(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+}
+
+// PrometheusQuery evaluates an instant query of the supported PromQL subset
+func (co *CentralOrchestrator) PrometheusQuery(c *gin.Context) {
+	at, err := parsePromTime(c.Query("time"), time.Now())
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	q, err := parsePromQuery(c.Query("query"))
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	if q.scalar != nil {
+		promSuccess(c, gin.H{"resultType": "scalar", "result": promValue(promSample{at: at, value: *q.scalar})})
+		return
+	}
+
+	labels, samples, err := co.promEvaluate(c, q, []time.Time{at})
+	if err != nil {
+		promError(c, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	result := make([]gin.H, len(labels))
+	for i := range labels {
+		result[i] = gin.H{"metric": labels[i], "value": promValue(samples[i][0])}
+	}
+	promSuccess(c, gin.H{"resultType": "vector", "result": result})
+}
+
+// PrometheusQueryRange evaluates a range query of the supported PromQL subset
+func (co *CentralOrchestrator) PrometheusQueryRange(c *gin.Context) {
+	start, err := parsePromTime(c.Query("start"), time.Time{})
+	if err == nil && start.IsZero() {
+		err = fmt.Errorf("start is required")
+	}
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	end, err := parsePromTime(c.Query("end"), time.Now())
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	step, err := parsePromStep(c.Query("step"))
+	if err != nil || step <= 0 {
+		promError(c, http.StatusBadRequest, "bad_data", fmt.Errorf("step must be a positive duration"))
+		return
+	}
+	if end.Before(start) {
+		promError(c, http.StatusBadRequest, "bad_data", fmt.Errorf("end timestamp must not be before start time"))
+		return
+	}
+	if end.Sub(start)/step >= MaxPrometheusPoints {
+		promError(c, http.StatusBadRequest, "bad_data", fmt.Errorf("exceeded maximum resolution of %d points per timeseries; try increasing the step", MaxPrometheusPoints))
+		return
+	}
+	q, err := parsePromQuery(c.Query("query"))
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+
+	var instants []time.Time
+	for at := start; !at.After(end); at = at.Add(step) {
+		instants = append(instants, at)
+	}
+
+	var labels []map[string]string
+	var samples [][]promSample
+	if q.scalar != nil {
+		values := make([]promSample, len(instants))
+		for i, at := range instants {
+			values[i] = promSample{at: at, value: *q.scalar}
+		}
+		labels, samples = []map[string]string{{}}, [][]promSample{values}
+	} else if labels, samples, err = co.promEvaluate(c, q, instants); err != nil {
+		promError(c, http.StatusInternalServerError, "internal", err)
+		return
+	}
+
+	result := make([]gin.H, len(labels))
+	for i := range labels {
+		values := make([][]interface{}, len(samples[i]))
+		for j, sample := range samples[i] {
+			values[j] = promValue(sample)
+		}
+		result[i] = gin.H{"metric": labels[i], "values": values}
+	}
+	promSuccess(c, gin.H{"resultType": "matrix", "result": result})
+}
+
+// promMatched returns the series selected by any of the request's match[] selectors, or
+// every series without one
+func (co *CentralOrchestrator) promMatched(c *gin.Context) ([]promSeries, error) {
+	selectors := c.QueryArray("match[]")
+	if len(selectors) == 0 {
+		return co.promSeriesList(c), nil
+	}
+	seen := make(map[metricSeriesID]bool)
+	var matched []promSeries
+	for _, selector := range selectors {
+		q, err := parsePromQuery(selector)
+		if err != nil {
+			return nil, err
+		}
+		if q.aggregation != "" || q.scalar != nil {
+			return nil, fmt.Errorf("match[] must be a series selector")
+		}
+		for _, series := range co.promSelect(c, q.matchers) {
+			if !seen[series.id] {
+				seen[series.id] = true
+				matched = append(matched, series)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// PrometheusSeries lists the label sets of the series the match[] selectors select
+func (co *CentralOrchestrator) PrometheusSeries(c *gin.Context) {
+	series, err := co.promMatched(c)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	labels := make([]map[string]string, len(series))
+	for i, s := range series {
+		labels[i] = s.labels
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i]["__name__"] != labels[j]["__name__"] {
+			return labels[i]["__name__"] < labels[j]["__name__"]
+		}
+		return labels[i]["id"] < labels[j]["id"]
+	})
+	promSuccess(c, labels)
+}
+
+// PrometheusLabels lists the label names of the matched series
+func (co *CentralOrchestrator) PrometheusLabels(c *gin.Context) {
+	series, err := co.promMatched(c)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	names := make(map[string]bool)
+	for _, s := range series {
+		for name := range s.labels {
+			names[name] = true
+		}
+	}
+	promSuccess(c, sortedKeys(names))
+}
+
+// PrometheusLabelValues lists the values a label takes in the matched series
+func (co *CentralOrchestrator) PrometheusLabelValues(c *gin.Context) {
+	series, err := co.promMatched(c)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	values := make(map[string]bool)
+	for _, s := range series {
+		if value, exists := s.labels[c.Param("name")]; exists {
+			values[value] = true
+		}
+	}
+	promSuccess(c, sortedKeys(values))
+}
+
+// PrometheusMetadata describes every stored metric as a gauge
+func (co *CentralOrchestrator) PrometheusMetadata(c *gin.Context) {
+	metadata := make(map[string][]gin.H)
+	for _, s := range co.promSeriesList(c) {
+		metadata[s.labels["__name__"]] = []gin.H{{"type": "gauge", "help": "", "unit": ""}}
+	}
+	promSuccess(c, metadata)
+}
+
+// PrometheusBuildInfo reports the Prometheus version whose API is served
+func (co *CentralOrchestrator) PrometheusBuildInfo(c *gin.Context) {
+	promSuccess(c, gin.H{"version": PrometheusCompatibleVersion, "application": "edge-orchestrator"})
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
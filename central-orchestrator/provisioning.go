@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Cloud node controller interval
+	CloudNodeCheckInterval = 1 * time.Minute
+
+	// How long a provisioned instance may take to register
+	CloudNodeRegistrationTimeout = 10 * time.Minute
+
+	// How long a cloud node must be idle before it is scaled down
+	DefaultCloudNodeIdleTimeout = 15 * time.Minute
+
+	// Default maximum number of cloud nodes
+	DefaultMaxCloudNodes = 5
+
+	// Label identifying nodes provisioned in the cloud
+	CloudNodeLabel = "edge.io/cloud-node"
+)
+
+// CloudNodeStatus represents the lifecycle state of a provisioned cloud node
+type CloudNodeStatus string
+
+const (
+	CloudNodeProvisioning CloudNodeStatus = "provisioning"
+	CloudNodeReady        CloudNodeStatus = "ready"
+	CloudNodeTerminating  CloudNodeStatus = "terminating"
+	CloudNodeTerminated   CloudNodeStatus = "terminated"
+	CloudNodeFailed       CloudNodeStatus = "failed"
+	// The provider is reclaiming the spot instance; it is deleted once evacuated
+	CloudNodePreempted CloudNodeStatus = "preempted"
+)
+
+// ProvisionSpec describes a cloud instance to create
+type ProvisionSpec struct {
+	Name         string            `json:"name"`
+	Region       string            `json:"region"`
+	InstanceType string            `json:"instance_type"`
+	Labels       map[string]string `json:"labels"`
+	UserData     string            `json:"user_data"`
+}
+
+// NodeProvisioner creates and deletes cloud instances pre-baked with the edge agent
+type NodeProvisioner interface {
+	Name() string
+	CreateInstance(ctx context.Context, spec ProvisionSpec) (string, error)
+	DeleteInstance(ctx context.Context, instanceID string) error
+}
+
+// CloudNode tracks an instance created to burst workloads into the cloud
+type CloudNode struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	InstanceID  string          `json:"instance_id"`
+	NodeID      string          `json:"node_id,omitempty"`
+	Status      CloudNodeStatus `json:"status"`
+	Reason      string          `json:"reason"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ReadyAt     *time.Time      `json:"ready_at,omitempty"`
+	IdleSince   *time.Time      `json:"idle_since,omitempty"`
+	TerminateAt *time.Time      `json:"terminated_at,omitempty"`
+	// Reported by the node's agent from the instance metadata service
+	InstanceType string  `json:"instance_type,omitempty"`
+	Lifecycle    string  `json:"lifecycle,omitempty"`
+	HourlyPrice  float64 `json:"hourly_price,omitempty"`
+}
+
+// CloudProvisioner scales cloud burst capacity up and down
+type CloudProvisioner struct {
+	provisioner  NodeProvisioner
+	nodes        map[string]*CloudNode
+	pending      map[string]bool
+	maxNodes     int
+	idleTimeout  time.Duration
+	region       string
+	instanceType string
+	agentURL     string
+	agentToken   string
+	pricing      []CloudPrice
+	mutex        sync.RWMutex
+	logger       *logrus.Logger
+}
+
+// NewCloudProvisioner creates a cloud provisioner configured from the environment.
+// Cloud bursting is disabled when CLOUD_PROVISIONER is unset.
+func NewCloudProvisioner(logger *logrus.Logger) *CloudProvisioner {
+	cp := &CloudProvisioner{
+		nodes:        make(map[string]*CloudNode),
+		pending:      make(map[string]bool),
+		maxNodes:     DefaultMaxCloudNodes,
+		idleTimeout:  DefaultCloudNodeIdleTimeout,
+		region:       os.Getenv("CLOUD_REGION"),
+		instanceType: os.Getenv("CLOUD_INSTANCE_TYPE"),
+		agentURL:     os.Getenv("CLOUD_AGENT_ORCHESTRATOR_URL"),
+		agentToken:   os.Getenv("CLOUD_AGENT_TOKEN"),
+		logger:       logger,
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("CLOUD_MAX_NODES")); err == nil && n > 0 {
+		cp.maxNodes = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("CLOUD_IDLE_TIMEOUT")); err == nil && d > 0 {
+		cp.idleTimeout = d
+	}
+	if path := os.Getenv("CLOUD_PRICING_FILE"); path != "" {
+		pricing, err := loadCloudPricing(path)
+		if err != nil {
+			logger.Warnf("Failed to load cloud pricing from %s: %v", path, err)
+		} else {
+			cp.pricing = pricing
+		}
+	}
+
+	switch os.Getenv("CLOUD_PROVISIONER") {
+	case "":
+		return cp
+	case "exec":
+		cp.provisioner = &execProvisioner{
+			createCommand: os.Getenv("CLOUD_PROVISION_COMMAND"),
+			deleteCommand: os.Getenv("CLOUD_DEPROVISION_COMMAND"),
+		}
+	case "webhook":
+		cp.provisioner = &webhookProvisioner{
+			url:        strings.TrimSuffix(os.Getenv("CLOUD_PROVISIONER_URL"), "/"),
+			httpClient: &http.Client{Timeout: 5 * time.Minute},
+		}
+	default:
+		logger.Warnf("Unknown cloud provisioner %q, cloud bursting disabled", os.Getenv("CLOUD_PROVISIONER"))
+		return cp
+	}
+
+	logger.Infof("Cloud bursting enabled with provisioner %s (max %d nodes)", cp.provisioner.Name(), cp.maxNodes)
+	return cp
+}
+
+// Enabled reports whether a provisioner is configured
+func (cp *CloudProvisioner) Enabled() bool {
+	return cp.provisioner != nil
+}
+
+// wantsCloudBurst reports whether a workload may trigger cloud provisioning
+func wantsCloudBurst(workload *Workload) bool {
+	return workload.Placement.Strategy == PlacementStrategyCloudFirst || workload.Placement.AllowCloudBurst
+}
+
+// isCloudNode reports whether a node was provisioned for cloud bursting
+func isCloudNode(node *EdgeNode) bool {
+	return node.Labels[CloudNodeLabel] == "true"
+}
+
+// RequestCapacity provisions a cloud node for a workload that could not be scheduled,
+// unless one is already on its way or the cloud node limit has been reached
+func (cp *CloudProvisioner) RequestCapacity(workload *Workload) {
+	if !cp.Enabled() || !wantsCloudBurst(workload) {
+		return
+	}
+
+	cp.mutex.Lock()
+	if cp.pending[workload.ID] {
+		cp.mutex.Unlock()
+		return
+	}
+	active := 0
+	for _, node := range cp.nodes {
+		if node.Status == CloudNodeProvisioning || node.Status == CloudNodeReady {
+			active++
+		}
+	}
+	if active >= cp.maxNodes {
+		cp.mutex.Unlock()
+		cp.logger.Warnf("Cloud node limit (%d) reached, cannot burst workload %s", cp.maxNodes, workload.Name)
+		return
+	}
+
+	id := generateID()
+	node := &CloudNode{
+		ID:        id,
+		Name:      "cloud-" + id[:8],
+		Status:    CloudNodeProvisioning,
+		Reason:    fmt.Sprintf("workload %s (%s) could not be scheduled", workload.Name, workload.ID),
+		CreatedAt: time.Now(),
+	}
+	cp.nodes[id] = node
+	cp.pending[workload.ID] = true
+	cp.mutex.Unlock()
+
+	spec := ProvisionSpec{
+		Name:         node.Name,
+		Region:       cp.region,
+		InstanceType: cp.instanceType,
+		Labels:       map[string]string{CloudNodeLabel: "true"},
+	}
+	spec.UserData = cp.agentUserData(spec)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		instanceID, err := cp.provisioner.CreateInstance(ctx, spec)
+
+		cp.mutex.Lock()
+		defer cp.mutex.Unlock()
+		delete(cp.pending, workload.ID)
+
+		if err != nil {
+			cp.logger.Errorf("Failed to provision cloud node %s: %v", node.Name, err)
+			node.Status = CloudNodeFailed
+			node.Error = err.Error()
+			return
+		}
+		node.InstanceID = instanceID
+		cp.logger.Infof("Provisioned cloud instance %s for node %s", instanceID, node.Name)
+	}()
+}
+
+// agentUserData renders a cloud-init payload that configures and starts the edge agent
+func (cp *CloudProvisioner) agentUserData(spec ProvisionSpec) string {
+	var labels strings.Builder
+	keys := make([]string, 0, len(spec.Labels))
+	for k := range spec.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&labels, "          %s: %q\n", k, spec.Labels[k])
+	}
+
+	region := spec.Region
+	if region == "" {
+		region = "cloud"
+	}
+
+	return fmt.Sprintf(`#cloud-config
+write_files:
+  - path: /etc/edge-agent/config.yaml
+    permissions: "0600"
+    content: |
+      orchestrator_url: %q
+      node_name: %q
+      auth_token: %q
+      region: %q
+      labels:
+%sruncmd:
+  - [systemctl, enable, --now, edge-agent]
+`, cp.agentURL, spec.Name, cp.agentToken, region, labels.String())
+}
+
+// NodeRegistered links a newly registered node to the cloud instance created for it
+func (cp *CloudProvisioner) NodeRegistered(node *EdgeNode) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	for _, cloudNode := range cp.nodes {
+		if cloudNode.Status != CloudNodeProvisioning || cloudNode.Name != node.Name {
+			continue
+		}
+		now := time.Now()
+		cloudNode.NodeID = node.ID
+		cloudNode.Status = CloudNodeReady
+		cloudNode.ReadyAt = &now
+		node.Labels[CloudNodeLabel] = "true"
+		cp.logger.Infof("Cloud node %s registered as node %s", cloudNode.Name, node.ID)
+		return
+	}
+}
+
+// cloudNodeController times out stuck provisioning and scales down idle cloud nodes
+func (co *CentralOrchestrator) cloudNodeController() {
+	if !co.CloudProvisioner.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(CloudNodeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.consolidateCloudNodes()
+			co.scaleDownCloudNodes()
+		}
+	}
+}
+
+// consolidateCloudNodes moves workloads off cloud nodes once edge capacity can hold them again
+func (co *CentralOrchestrator) consolidateCloudNodes() {
+	co.NodeManager.mutex.RLock()
+	edge := make(map[string]*EdgeNode)
+	var cloud []*EdgeNode
+	for _, node := range co.NodeManager.nodes {
+		if node.Status != NodeStatusOnline {
+			continue
+		}
+		if isCloudNode(node) {
+			cloud = append(cloud, node)
+		} else {
+			edge[node.ID] = node
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	// The most expensive nodes are drained first
+	sort.SliceStable(cloud, func(i, j int) bool {
+		priceI, _ := cloudHourlyPrice(cloud[i])
+		priceJ, _ := cloudHourlyPrice(cloud[j])
+		return priceI > priceJ
+	})
+
+	for _, node := range cloud {
+		lost := map[string]bool{node.ID: true}
+
+		// Dry run against a copy first so nothing moves unless everything fits
+		co.WorkloadManager.mutex.RLock()
+		trial := &failoverPlanner{co: co, workloads: cloneWorkloads(co.WorkloadManager.workloads), online: edge, lost: lost}
+		co.WorkloadManager.mutex.RUnlock()
+
+		queue := trial.failLostDeployments()
+		if len(queue) == 0 || trial.hasUnschedulable(trial.plan(queue)) {
+			continue
+		}
+
+		co.WorkloadManager.mutex.Lock()
+		planner := &failoverPlanner{co: co, workloads: co.WorkloadManager.workloads, online: edge, lost: lost}
+		op := co.OperationManager.Start("cloud-consolidation", "node "+node.ID)
+		for _, outcome := range planner.plan(planner.failLostDeployments()) {
+			co.OperationManager.AddStep(op, outcome.step())
+		}
+		co.WorkloadManager.mutex.Unlock()
+
+		co.OperationManager.Complete(op, OperationStatusSucceeded, "Workloads returned to edge capacity")
+	}
+}
+
+// scaleDownCloudNodes deletes cloud nodes that failed to register or have been idle too long
+func (co *CentralOrchestrator) scaleDownCloudNodes() {
+	co.WorkloadManager.mutex.RLock()
+	busy := make(map[string]bool)
+	for _, workload := range co.WorkloadManager.workloads {
+		for _, deployment := range workload.Deployments {
+			if deployment.placed() {
+				busy[deployment.NodeID] = true
+			}
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	cp := co.CloudProvisioner
+	now := time.Now()
+	var terminate []*CloudNode
+
+	cp.mutex.Lock()
+	for _, node := range cp.nodes {
+		switch node.Status {
+		case CloudNodeProvisioning:
+			if node.InstanceID != "" && now.Sub(node.CreatedAt) > CloudNodeRegistrationTimeout {
+				node.Error = "instance did not register in time"
+				node.Status = CloudNodeTerminating
+				terminate = append(terminate, node)
+			}
+		case CloudNodeReady:
+			if busy[node.NodeID] {
+				node.IdleSince = nil
+				continue
+			}
+			if node.IdleSince == nil {
+				node.IdleSince = &now
+				continue
+			}
+			if now.Sub(*node.IdleSince) > cp.idleTimeout {
+				node.Status = CloudNodeTerminating
+				terminate = append(terminate, node)
+			}
+		case CloudNodePreempted:
+			if !busy[node.NodeID] {
+				node.Status = CloudNodeTerminating
+				terminate = append(terminate, node)
+			}
+		}
+	}
+	cp.mutex.Unlock()
+
+	for _, node := range terminate {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		err := cp.provisioner.DeleteInstance(ctx, node.InstanceID)
+		cancel()
+
+		cp.mutex.Lock()
+		if err != nil {
+			co.Logger.Errorf("Failed to delete cloud instance %s: %v", node.InstanceID, err)
+			node.Status = CloudNodeFailed
+			node.Error = err.Error()
+			cp.mutex.Unlock()
+			continue
+		}
+		terminatedAt := time.Now()
+		node.Status = CloudNodeTerminated
+		node.TerminateAt = &terminatedAt
+		cp.mutex.Unlock()
+
+		if node.NodeID != "" {
+			co.NodeManager.mutex.Lock()
+			delete(co.NodeManager.nodes, node.NodeID)
+			co.DesiredStateCache.forget(node.NodeID)
+			co.NodeManager.mutex.Unlock()
+		}
+		co.Logger.Infof("Cloud node %s (instance %s) scaled down", node.Name, node.InstanceID)
+	}
+}
+
+// ListCloudNodes returns cloud nodes created for bursting
+func (co *CentralOrchestrator) ListCloudNodes(c *gin.Context) {
+	co.CloudProvisioner.mutex.RLock()
+	defer co.CloudProvisioner.mutex.RUnlock()
+
+	nodes := make([]*CloudNode, 0, len(co.CloudProvisioner.nodes))
+	hourlyCost := 0.0
+	for _, node := range co.CloudProvisioner.nodes {
+		if status := c.Query("status"); status != "" && string(node.Status) != status {
+			continue
+		}
+		nodes = append(nodes, node)
+		if node.Status == CloudNodeReady {
+			hourlyCost += node.HourlyPrice
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cloud_nodes": nodes, "hourly_cost": hourlyCost})
+}
+
+// execProvisioner runs operator-supplied commands (e.g. wrappers around the aws, gcloud,
+// or az CLIs). The create command receives the spec as JSON on stdin and must print
+// the instance ID; the delete command receives the instance ID as its last argument.
+type execProvisioner struct {
+	createCommand string
+	deleteCommand string
+}
+
+func (p *execProvisioner) Name() string {
+	return "exec"
+}
+
+func (p *execProvisioner) CreateInstance(ctx context.Context, spec ProvisionSpec) (string, error) {
+	input, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal provision spec: %v", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", p.createCommand)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("provision command failed: %v", err)
+	}
+
+	instanceID := strings.TrimSpace(string(output))
+	if instanceID == "" {
+		return "", fmt.Errorf("provision command returned no instance ID")
+	}
+	return instanceID, nil
+}
+
+func (p *execProvisioner) DeleteInstance(ctx context.Context, instanceID string) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", p.deleteCommand+` "$0"`, instanceID)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("deprovision command failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// webhookProvisioner delegates instance lifecycle to an HTTP service
+type webhookProvisioner struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (p *webhookProvisioner) Name() string {
+	return "webhook"
+}
+
+func (p *webhookProvisioner) CreateInstance(ctx context.Context, spec ProvisionSpec) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal provision spec: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url+"/instances", bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("provisioner request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("provisioner returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		InstanceID string `json:"instance_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode provisioner response: %v", err)
+	}
+	return result.InstanceID, nil
+}
+
+func (p *webhookProvisioner) DeleteInstance(ctx context.Context, instanceID string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", p.url+"/instances/"+instanceID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("provisioner request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("provisioner returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
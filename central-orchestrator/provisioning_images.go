@@ -0,0 +1,846 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// How long a join token is valid when the request sets no TTL
+	DefaultJoinTokenTTL = 7 * 24 * time.Hour
+
+	// Upper bound on an image build
+	ImageBuildTimeout = 30 * time.Minute
+
+	// Where built images are written when IMAGE_OUTPUT_DIR is unset
+	DefaultImageOutputDir = "/var/lib/edge-orchestrator/images"
+
+	// Paths on the provisioned device
+	agentConfigPath = "/etc/edge-agent/config.yaml"
+	agentBinaryPath = "/usr/local/bin/edge-agent"
+)
+
+// ImageFormat is what a provisioning image request produces
+type ImageFormat string
+
+const (
+	// cloud-init user-data, for images that run cloud-init on first boot
+	ImageFormatCloudInit ImageFormat = "cloud-init"
+	// Ignition config, for Flatcar and Fedora CoreOS
+	ImageFormatIgnition ImageFormat = "ignition"
+	// A bootable disk image with the cloud-init payload baked in
+	ImageFormatRaw ImageFormat = "raw"
+)
+
+// ImageBuildStatus is the state of a provisioning image
+type ImageBuildStatus string
+
+const (
+	ImageBuildBuilding ImageBuildStatus = "building"
+	ImageBuildReady    ImageBuildStatus = "ready"
+	ImageBuildFailed   ImageBuildStatus = "failed"
+)
+
+// ImageNetworkConfig configures the device's network on first boot. Devices use DHCP
+// on their first ethernet interface when it is unset.
+type ImageNetworkConfig struct {
+	Interface string `json:"interface"`
+	DHCP      bool   `json:"dhcp"`
+	// Static address in CIDR notation, used when DHCP is false
+	Address string   `json:"address"`
+	Gateway string   `json:"gateway"`
+	DNS     []string `json:"dns"`
+	WiFi    *struct {
+		SSID string `json:"ssid" binding:"required"`
+		PSK  string `json:"psk"`
+	} `json:"wifi"`
+}
+
+// ProvisioningImageRequest describes the devices an image provisions
+type ProvisioningImageRequest struct {
+	Format ImageFormat       `json:"format" binding:"required"`
+	SiteID string            `json:"site_id"`
+	Region string            `json:"region"`
+	Zone   string            `json:"zone"`
+	Labels map[string]string `json:"labels"`
+	// Devices are named <prefix>-<machine ID>; defaults to the site ID or "edge"
+	NodeNamePrefix    string              `json:"node_name_prefix"`
+	Network           *ImageNetworkConfig `json:"network"`
+	SSHAuthorizedKeys []string            `json:"ssh_authorized_keys"`
+	// Base disk image for raw builds; defaults to IMAGE_BASE
+	BaseImage string `json:"base_image"`
+	// Devices that may register with the image's join token; 0 is unlimited
+	MaxDevices int    `json:"max_devices"`
+	TTL        string `json:"ttl"`
+}
+
+// validate applies defaults and checks the network configuration
+func (req *ProvisioningImageRequest) validate() (time.Duration, error) {
+	switch req.Format {
+	case ImageFormatCloudInit, ImageFormatIgnition, ImageFormatRaw:
+	default:
+		return 0, fmt.Errorf("format must be cloud-init, ignition or raw")
+	}
+	if req.MaxDevices < 0 {
+		return 0, fmt.Errorf("max_devices must not be negative")
+	}
+	if req.NodeNamePrefix == "" {
+		req.NodeNamePrefix = req.SiteID
+	}
+	if req.NodeNamePrefix == "" {
+		req.NodeNamePrefix = "edge"
+	}
+
+	ttl := DefaultJoinTokenTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("invalid ttl %q", req.TTL)
+		}
+		ttl = parsed
+	}
+
+	if network := req.Network; network != nil {
+		if network.Interface == "" {
+			network.Interface = "eth0"
+			if network.WiFi != nil {
+				network.Interface = "wlan0"
+			}
+		}
+		if !network.DHCP {
+			if _, _, err := net.ParseCIDR(network.Address); err != nil {
+				return 0, fmt.Errorf("network address must be in CIDR notation when dhcp is false")
+			}
+			if net.ParseIP(network.Gateway) == nil {
+				return 0, fmt.Errorf("network gateway must be an IP address when dhcp is false")
+			}
+		}
+		for _, server := range network.DNS {
+			if net.ParseIP(server) == nil {
+				return 0, fmt.Errorf("invalid DNS server %q", server)
+			}
+		}
+	}
+	return ttl, nil
+}
+
+// JoinToken lets devices flashed with a provisioning image register without an
+// operator. Registrations using it are pinned to its site and labels.
+type JoinToken struct {
+	ID         string            `json:"id"`
+	SiteID     string            `json:"site_id,omitempty"`
+	Region     string            `json:"region,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	MaxDevices int               `json:"max_devices"`
+	// Node whose identity the device registering with the token takes over
+	ReplacesNodeID string `json:"replaces_node_id,omitempty"`
+	// Names of the devices that registered with the token
+	Devices   []string   `json:"devices"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	hash      string
+}
+
+// admits reports why a device may not register with the token, if it may not.
+// Devices that already registered can always re-register.
+func (token *JoinToken) admits(name string, now time.Time) error {
+	if contains(token.Devices, name) {
+		return nil
+	}
+	if token.RevokedAt != nil {
+		return fmt.Errorf("join token has been revoked")
+	}
+	if now.After(token.ExpiresAt) {
+		return fmt.Errorf("join token has expired")
+	}
+	if token.MaxDevices > 0 && len(token.Devices) >= token.MaxDevices {
+		return fmt.Errorf("join token has already been used by %d devices", len(token.Devices))
+	}
+	return nil
+}
+
+// ImageBuild is a provisioning image and the join token baked into it
+type ImageBuild struct {
+	ID          string           `json:"id"`
+	Format      ImageFormat      `json:"format"`
+	SiteID      string           `json:"site_id,omitempty"`
+	JoinTokenID string           `json:"join_token_id"`
+	Status      ImageBuildStatus `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	SizeBytes   int64            `json:"size_bytes,omitempty"`
+	SHA256      string           `json:"sha256,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	// Rendered cloud-init or ignition payload; holds the join token
+	payload []byte
+	// Built disk image for raw builds
+	path string
+}
+
+// ImageBuilder renders zero-touch provisioning payloads and builds bootable images
+type ImageBuilder struct {
+	tokens map[string]*JoinToken
+	builds map[string]*ImageBuild
+	// Orchestrator URL devices register with
+	orchestratorURL string
+	// Where devices download the agent binary; images must ship it when unset
+	agentDownloadURL string
+	buildCommand     string
+	baseImage        string
+	outputDir        string
+	mutex            sync.RWMutex
+	logger           *logrus.Logger
+}
+
+// NewImageBuilder creates an image builder. PROVISIONING_ORCHESTRATOR_URL sets the URL
+// devices register with; IMAGE_BUILD_COMMAND enables raw image builds.
+func NewImageBuilder(logger *logrus.Logger) *ImageBuilder {
+	ib := &ImageBuilder{
+		tokens:           make(map[string]*JoinToken),
+		builds:           make(map[string]*ImageBuild),
+		orchestratorURL:  os.Getenv("PROVISIONING_ORCHESTRATOR_URL"),
+		agentDownloadURL: os.Getenv("PROVISIONING_AGENT_DOWNLOAD_URL"),
+		buildCommand:     os.Getenv("IMAGE_BUILD_COMMAND"),
+		baseImage:        os.Getenv("IMAGE_BASE"),
+		outputDir:        os.Getenv("IMAGE_OUTPUT_DIR"),
+		logger:           logger,
+	}
+	if ib.orchestratorURL == "" {
+		ib.orchestratorURL = os.Getenv("CLOUD_AGENT_ORCHESTRATOR_URL")
+	}
+	if ib.outputDir == "" {
+		ib.outputDir = DefaultImageOutputDir
+	}
+	return ib
+}
+
+// newJoinToken creates a join token and the secret devices present
+func newJoinToken(siteID, region, zone string, labels map[string]string, maxDevices int, expiresAt time.Time) (*JoinToken, string) {
+	secret := generateID() + generateID()
+	return &JoinToken{
+		ID:         generateID(),
+		SiteID:     siteID,
+		Region:     region,
+		Zone:       zone,
+		Labels:     labels,
+		MaxDevices: maxDevices,
+		Devices:    []string{},
+		ExpiresAt:  expiresAt,
+		CreatedAt:  time.Now(),
+		hash:       hashJoinToken(secret),
+	}, secret
+}
+
+// hashJoinToken returns the form join tokens are stored and looked up in
+func hashJoinToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// admitJoin pins registrations made with a join token to the token's site and labels,
+// and rejects them once the token is expired, revoked or used up. Registrations with
+// any other credential are left alone. It returns the node a replacement token hands
+// to the device, if any.
+func (co *CentralOrchestrator) admitJoin(c *gin.Context, req *NodeRegistrationRequest) (string, error) {
+	secret := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if secret == "" || c.GetString(ContextKeyAuthMethod) != "token" {
+		return "", nil
+	}
+	hash := hashJoinToken(secret)
+
+	ib := co.ImageBuilder
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+
+	var token *JoinToken
+	for _, candidate := range ib.tokens {
+		if candidate.hash == hash {
+			token = candidate
+			break
+		}
+	}
+	if token == nil {
+		return "", nil
+	}
+	if err := token.admits(req.Name, time.Now()); err != nil {
+		return "", err
+	}
+
+	if token.SiteID != "" {
+		req.SiteID = token.SiteID
+	}
+	if token.Region != "" {
+		req.Region = token.Region
+	}
+	if token.Zone != "" {
+		req.Zone = token.Zone
+	}
+	if req.Labels == nil {
+		req.Labels = make(map[string]string)
+	}
+	for key, value := range token.Labels {
+		req.Labels[key] = value
+	}
+	if !contains(token.Devices, req.Name) {
+		token.Devices = append(token.Devices, req.Name)
+		ib.logger.Infof("Device %s joined with join token %s (%d registered)", req.Name, token.ID, len(token.Devices))
+	}
+	return token.ReplacesNodeID, nil
+}
+
+// lookupDeviceCredential recognizes the bearer tokens of devices joining the fleet when an
+// access policy or JWT keys reject unknown tokens: the join tokens devices register and
+// keep authenticating with, and the secrets of devices waiting to be claimed. Devices
+// announce themselves with a secret of their own making, so any secret may announce.
+func (co *CentralOrchestrator) lookupDeviceCredential(c *gin.Context, secret string) (string, string, bool) {
+	hash := hashJoinToken(secret)
+
+	co.ImageBuilder.mutex.RLock()
+	for _, token := range co.ImageBuilder.tokens {
+		if token.hash == hash {
+			co.ImageBuilder.mutex.RUnlock()
+			return "join-token:" + token.ID, token.ID, true
+		}
+	}
+	co.ImageBuilder.mutex.RUnlock()
+
+	switch {
+	case c.Request.Method == http.MethodPost && c.FullPath() == "/api/v1/claims":
+		return "device", "", true
+	case c.Request.Method == http.MethodGet && c.FullPath() == "/api/v1/claims/:code/status":
+		co.ClaimManager.mutex.RLock()
+		claim, exists := co.ClaimManager.claims[normalizeClaimCode(c.Param("code"))]
+		co.ClaimManager.mutex.RUnlock()
+		if exists && claim.secretHash == hash {
+			return "device", "", true
+		}
+	}
+	return "", "", false
+}
+
+// joinTokenCovers reports whether a node was registered by a device with the join token
+func (co *CentralOrchestrator) joinTokenCovers(tokenID, nodeID string) bool {
+	co.NodeManager.mutex.RLock()
+	node, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	co.ImageBuilder.mutex.RLock()
+	defer co.ImageBuilder.mutex.RUnlock()
+	token, exists := co.ImageBuilder.tokens[tokenID]
+	return exists && contains(token.Devices, node.Name)
+}
+
+// agentConfig is the edge agent configuration written to provisioned devices
+type agentConfig struct {
+	OrchestratorURL string            `yaml:"orchestrator_url"`
+	NodeNamePrefix  string            `yaml:"node_name_prefix"`
+	AuthToken       string            `yaml:"auth_token"`
+	SiteID          string            `yaml:"site_id,omitempty"`
+	Region          string            `yaml:"region,omitempty"`
+	Zone            string            `yaml:"zone,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+}
+
+const agentUnit = `[Unit]
+Description=Kubernetes Edge Agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Environment=EDGE_AGENT_CONFIG=` + agentConfigPath + `
+ExecStart=` + agentBinaryPath + `
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// cloudInitFile is an entry of cloud-init's write_files
+type cloudInitFile struct {
+	Path        string `yaml:"path"`
+	Permissions string `yaml:"permissions"`
+	Content     string `yaml:"content"`
+}
+
+type cloudInitConfig struct {
+	SSHAuthorizedKeys []string        `yaml:"ssh_authorized_keys,omitempty"`
+	WriteFiles        []cloudInitFile `yaml:"write_files"`
+	RunCmd            [][]string      `yaml:"runcmd"`
+}
+
+// renderCloudInit renders user-data that configures the network, installs the agent
+// unless the image already has it, and starts it
+func (ib *ImageBuilder) renderCloudInit(req *ProvisioningImageRequest, config []byte) ([]byte, error) {
+	userData := cloudInitConfig{
+		SSHAuthorizedKeys: req.SSHAuthorizedKeys,
+		WriteFiles:        []cloudInitFile{{Path: agentConfigPath, Permissions: "0600", Content: string(config)}},
+	}
+
+	if req.Network != nil {
+		netplan, err := renderNetplan(req.Network)
+		if err != nil {
+			return nil, err
+		}
+		userData.WriteFiles = append(userData.WriteFiles, cloudInitFile{Path: "/etc/netplan/90-edge.yaml", Permissions: "0600", Content: string(netplan)})
+		userData.RunCmd = append(userData.RunCmd, []string{"netplan", "apply"})
+	}
+	if ib.agentDownloadURL != "" {
+		userData.WriteFiles = append(userData.WriteFiles, cloudInitFile{Path: "/etc/systemd/system/edge-agent.service", Permissions: "0644", Content: agentUnit})
+		userData.RunCmd = append(userData.RunCmd,
+			[]string{"curl", "-fsSL", "--retry", "10", "-o", agentBinaryPath, ib.agentDownloadURL},
+			[]string{"chmod", "0755", agentBinaryPath},
+			[]string{"systemctl", "daemon-reload"})
+	}
+	userData.RunCmd = append(userData.RunCmd, []string{"systemctl", "enable", "--now", "edge-agent"})
+
+	data, err := yaml.Marshal(userData)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), data...), nil
+}
+
+// renderNetplan renders the device's network as a netplan configuration
+func renderNetplan(network *ImageNetworkConfig) ([]byte, error) {
+	iface := map[string]interface{}{"dhcp4": network.DHCP}
+	if !network.DHCP {
+		iface["addresses"] = []string{network.Address}
+		iface["routes"] = []map[string]string{{"to": "default", "via": network.Gateway}}
+	}
+	if len(network.DNS) > 0 {
+		iface["nameservers"] = map[string][]string{"addresses": network.DNS}
+	}
+
+	section := "ethernets"
+	if network.WiFi != nil {
+		section = "wifis"
+		accessPoint := map[string]string{}
+		if network.WiFi.PSK != "" {
+			accessPoint["password"] = network.WiFi.PSK
+		}
+		iface["access-points"] = map[string]interface{}{network.WiFi.SSID: accessPoint}
+	}
+
+	return yaml.Marshal(map[string]interface{}{
+		"network": map[string]interface{}{
+			"version": 2,
+			section:   map[string]interface{}{network.Interface: iface},
+		},
+	})
+}
+
+type ignitionFile struct {
+	Path     string `json:"path"`
+	Mode     int    `json:"mode"`
+	Contents struct {
+		Source string `json:"source"`
+	} `json:"contents"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents,omitempty"`
+}
+
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Passwd struct {
+		Users []map[string]interface{} `json:"users,omitempty"`
+	} `json:"passwd"`
+	Storage struct {
+		Files []ignitionFile `json:"files"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []ignitionUnit `json:"units"`
+	} `json:"systemd"`
+}
+
+// dataSource embeds file contents in an ignition config
+func dataSource(contents []byte) string {
+	return "data:;base64," + base64.StdEncoding.EncodeToString(contents)
+}
+
+// renderIgnition renders an Ignition v3 config, configuring the network through
+// systemd-networkd since Ignition cannot run commands
+func (ib *ImageBuilder) renderIgnition(req *ProvisioningImageRequest, config []byte) ([]byte, error) {
+	var ignition ignitionConfig
+	ignition.Ignition.Version = "3.3.0"
+	if len(req.SSHAuthorizedKeys) > 0 {
+		ignition.Passwd.Users = []map[string]interface{}{{"name": "core", "sshAuthorizedKeys": req.SSHAuthorizedKeys}}
+	}
+
+	addFile := func(path string, mode int, source string) {
+		file := ignitionFile{Path: path, Mode: mode}
+		file.Contents.Source = source
+		ignition.Storage.Files = append(ignition.Storage.Files, file)
+	}
+	addFile(agentConfigPath, 0600, dataSource(config))
+	if ib.agentDownloadURL != "" {
+		addFile(agentBinaryPath, 0755, ib.agentDownloadURL)
+	}
+
+	if network := req.Network; network != nil {
+		var unit strings.Builder
+		fmt.Fprintf(&unit, "[Match]\nName=%s\n\n[Network]\n", network.Interface)
+		if network.DHCP {
+			unit.WriteString("DHCP=yes\n")
+		} else {
+			fmt.Fprintf(&unit, "Address=%s\nGateway=%s\n", network.Address, network.Gateway)
+		}
+		for _, server := range network.DNS {
+			fmt.Fprintf(&unit, "DNS=%s\n", server)
+		}
+		addFile("/etc/systemd/network/10-edge.network", 0644, dataSource([]byte(unit.String())))
+
+		if network.WiFi != nil {
+			supplicant := fmt.Sprintf("network={\n  ssid=%q\n", network.WiFi.SSID)
+			if network.WiFi.PSK != "" {
+				supplicant += fmt.Sprintf("  psk=%q\n", network.WiFi.PSK)
+			} else {
+				supplicant += "  key_mgmt=NONE\n"
+			}
+			supplicant += "}\n"
+			addFile(fmt.Sprintf("/etc/wpa_supplicant/wpa_supplicant-%s.conf", network.Interface), 0600, dataSource([]byte(supplicant)))
+			ignition.Systemd.Units = append(ignition.Systemd.Units, ignitionUnit{Name: fmt.Sprintf("wpa_supplicant@%s.service", network.Interface), Enabled: true})
+		}
+		ignition.Systemd.Units = append(ignition.Systemd.Units, ignitionUnit{Name: "systemd-networkd.service", Enabled: true})
+	}
+	ignition.Systemd.Units = append(ignition.Systemd.Units, ignitionUnit{Name: "edge-agent.service", Enabled: true, Contents: agentUnit})
+
+	return json.MarshalIndent(ignition, "", "  ")
+}
+
+// buildImage runs IMAGE_BUILD_COMMAND to bake the cloud-init payload into a copy of the
+// base image. The command gets the paths in EDGE_IMAGE_BASE, EDGE_IMAGE_USER_DATA and
+// EDGE_IMAGE_OUTPUT and must write the bootable image to the output path.
+func (ib *ImageBuilder) buildImage(build *ImageBuild, baseImage string) {
+	err := func() error {
+		if err := os.MkdirAll(ib.outputDir, 0700); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+		userData := filepath.Join(ib.outputDir, build.ID+".user-data")
+		defer os.Remove(userData)
+		if err := os.WriteFile(userData, build.payload, 0600); err != nil {
+			return fmt.Errorf("failed to write user-data: %v", err)
+		}
+		output := filepath.Join(ib.outputDir, build.ID+".img")
+
+		ctx, cancel := context.WithTimeout(context.Background(), ImageBuildTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", ib.buildCommand)
+		cmd.Env = append(os.Environ(),
+			"EDGE_IMAGE_BASE="+baseImage,
+			"EDGE_IMAGE_USER_DATA="+userData,
+			"EDGE_IMAGE_OUTPUT="+output)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			os.Remove(output)
+			return fmt.Errorf("image build command failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+
+		file, err := os.Open(output)
+		if err != nil {
+			return fmt.Errorf("image build command wrote no image: %v", err)
+		}
+		defer file.Close()
+		hash := sha256.New()
+		size, err := io.Copy(hash, file)
+		if err != nil {
+			return fmt.Errorf("failed to read image: %v", err)
+		}
+
+		ib.mutex.Lock()
+		build.path = output
+		build.SizeBytes = size
+		build.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		ib.mutex.Unlock()
+		return nil
+	}()
+
+	now := time.Now()
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+	build.CompletedAt = &now
+	if err != nil {
+		build.Status = ImageBuildFailed
+		build.Error = err.Error()
+		ib.logger.Errorf("Provisioning image %s failed: %v", build.ID, err)
+		return
+	}
+	build.Status = ImageBuildReady
+	// The image carries the payload now
+	build.payload = nil
+	ib.logger.Infof("Provisioning image %s built (%d bytes)", build.ID, build.SizeBytes)
+}
+
+// CreateProvisioningImage issues a join token and renders a provisioning payload for
+// it, or starts building a bootable image. The token is only returned here.
+func (co *CentralOrchestrator) CreateProvisioningImage(c *gin.Context) {
+	var req ProvisioningImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ttl, err := req.validate()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ib := co.ImageBuilder
+	if ib.orchestratorURL == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "PROVISIONING_ORCHESTRATOR_URL is not configured"})
+		return
+	}
+	baseImage := req.BaseImage
+	if baseImage == "" {
+		baseImage = ib.baseImage
+	}
+	if req.Format == ImageFormatRaw {
+		if ib.buildCommand == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Raw image builds require IMAGE_BUILD_COMMAND"})
+			return
+		}
+		if baseImage == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "base_image is required when IMAGE_BASE is unset"})
+			return
+		}
+	}
+
+	if req.SiteID != "" {
+		co.SiteManager.mutex.RLock()
+		_, exists := co.SiteManager.sites[req.SiteID]
+		co.SiteManager.mutex.RUnlock()
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+			return
+		}
+	}
+
+	now := time.Now()
+	token, secret := newJoinToken(req.SiteID, req.Region, req.Zone, req.Labels, req.MaxDevices, now.Add(ttl))
+
+	config, err := yaml.Marshal(agentConfig{
+		OrchestratorURL: ib.orchestratorURL,
+		NodeNamePrefix:  req.NodeNamePrefix,
+		AuthToken:       secret,
+		SiteID:          req.SiteID,
+		Region:          req.Region,
+		Zone:            req.Zone,
+		Labels:          req.Labels,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to render agent configuration: %v", err)})
+		return
+	}
+
+	var payload []byte
+	if req.Format == ImageFormatIgnition {
+		payload, err = ib.renderIgnition(&req, config)
+	} else {
+		payload, err = ib.renderCloudInit(&req, config)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to render provisioning payload: %v", err)})
+		return
+	}
+
+	build := &ImageBuild{
+		ID:          generateID(),
+		Format:      req.Format,
+		SiteID:      req.SiteID,
+		JoinTokenID: token.ID,
+		Status:      ImageBuildReady,
+		CreatedAt:   now,
+		payload:     payload,
+	}
+	if req.Format == ImageFormatRaw {
+		build.Status = ImageBuildBuilding
+	} else {
+		sum := sha256.Sum256(payload)
+		build.SizeBytes = int64(len(payload))
+		build.SHA256 = hex.EncodeToString(sum[:])
+		build.CompletedAt = &now
+	}
+
+	ib.mutex.Lock()
+	ib.tokens[token.ID] = token
+	ib.builds[build.ID] = build
+	view := *build
+	tokenView := *token
+	ib.mutex.Unlock()
+
+	if req.Format == ImageFormatRaw {
+		go ib.buildImage(build, baseImage)
+	}
+
+	co.AuditLog.RecordRequest(c, "provisioning.image.create", "image:"+build.ID, map[string]string{
+		"format":     string(req.Format),
+		"site":       req.SiteID,
+		"join_token": token.ID,
+	})
+	co.Logger.Infof("Provisioning image %s (%s) created for site %q", build.ID, req.Format, req.SiteID)
+
+	c.JSON(http.StatusCreated, gin.H{"image": view, "join_token": tokenView, "token": secret})
+}
+
+// ListProvisioningImages lists provisioning images, newest first
+func (co *CentralOrchestrator) ListProvisioningImages(c *gin.Context) {
+	co.ImageBuilder.mutex.RLock()
+	defer co.ImageBuilder.mutex.RUnlock()
+
+	builds := make([]ImageBuild, 0, len(co.ImageBuilder.builds))
+	for _, build := range co.ImageBuilder.builds {
+		builds = append(builds, *build)
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].CreatedAt.After(builds[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"images": builds})
+}
+
+// GetProvisioningImage returns a provisioning image and its build status
+func (co *CentralOrchestrator) GetProvisioningImage(c *gin.Context) {
+	co.ImageBuilder.mutex.RLock()
+	defer co.ImageBuilder.mutex.RUnlock()
+
+	build, exists := co.ImageBuilder.builds[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provisioning image not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"image": *build})
+}
+
+// DownloadProvisioningImage returns the payload or built disk image
+func (co *CentralOrchestrator) DownloadProvisioningImage(c *gin.Context) {
+	co.ImageBuilder.mutex.RLock()
+	build, exists := co.ImageBuilder.builds[c.Param("id")]
+	var view ImageBuild
+	if exists {
+		view = *build
+	}
+	co.ImageBuilder.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provisioning image not found"})
+		return
+	}
+	if view.Status != ImageBuildReady {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Provisioning image is %s", view.Status)})
+		return
+	}
+
+	co.AuditLog.RecordRequest(c, "provisioning.image.download", "image:"+view.ID, nil)
+
+	switch view.Format {
+	case ImageFormatRaw:
+		c.FileAttachment(view.path, fmt.Sprintf("edge-%s.img", view.ID[:8]))
+	case ImageFormatIgnition:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=edge-%s.ign", view.ID[:8]))
+		c.Data(http.StatusOK, "application/json", view.payload)
+	default:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=edge-%s.user-data", view.ID[:8]))
+		c.Data(http.StatusOK, "text/cloud-config", view.payload)
+	}
+}
+
+// DeleteProvisioningImage removes a provisioning image and revokes its join token, so
+// devices not yet registered from it are rejected
+func (co *CentralOrchestrator) DeleteProvisioningImage(c *gin.Context) {
+	ib := co.ImageBuilder
+	ib.mutex.Lock()
+	build, exists := ib.builds[c.Param("id")]
+	if !exists {
+		ib.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provisioning image not found"})
+		return
+	}
+	if build.Status == ImageBuildBuilding {
+		ib.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Provisioning image is still building"})
+		return
+	}
+	delete(ib.builds, build.ID)
+	if token, exists := ib.tokens[build.JoinTokenID]; exists && token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+	}
+	path := build.path
+	ib.mutex.Unlock()
+
+	if path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			co.Logger.Warnf("Failed to remove provisioning image %s: %v", path, err)
+		}
+	}
+
+	co.AuditLog.RecordRequest(c, "provisioning.image.delete", "image:"+build.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Provisioning image deleted and join token revoked"})
+}
+
+// ListJoinTokens lists join tokens and the devices that registered with them
+func (co *CentralOrchestrator) ListJoinTokens(c *gin.Context) {
+	co.ImageBuilder.mutex.RLock()
+	defer co.ImageBuilder.mutex.RUnlock()
+
+	tokens := make([]JoinToken, 0, len(co.ImageBuilder.tokens))
+	for _, token := range co.ImageBuilder.tokens {
+		view := *token
+		view.Devices = append([]string{}, token.Devices...)
+		tokens = append(tokens, view)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"join_tokens": tokens})
+}
+
+// RevokeJoinToken stops a join token admitting new devices; devices that already
+// registered with it keep working
+func (co *CentralOrchestrator) RevokeJoinToken(c *gin.Context) {
+	co.ImageBuilder.mutex.Lock()
+	token, exists := co.ImageBuilder.tokens[c.Param("id")]
+	if exists && token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+	}
+	co.ImageBuilder.mutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Join token not found"})
+		return
+	}
+
+	co.AuditLog.RecordRequest(c, "provisioning.join-token.revoke", "join-token:"+token.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Join token revoked"})
+}
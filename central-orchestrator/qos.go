@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QoSClass determines how a workload's requests are counted against node capacity
+type QoSClass string
+
+const (
+	// Requests are backed by allocatable capacity, never by overcommit
+	QoSGuaranteed QoSClass = "guaranteed"
+	// Requests may be packed onto overcommitted capacity
+	QoSBurstable QoSClass = "burstable"
+	// No requests are accounted; runs on whatever is left
+	QoSBestEffort QoSClass = "best-effort"
+)
+
+// workloadQoS returns the workload's QoS class, deriving it Kubernetes-style from requests
+// and limits when not set explicitly
+func workloadQoS(workload *Workload) QoSClass {
+	if workload.QoSClass != "" {
+		return workload.QoSClass
+	}
+
+	requests := workload.Resources.Requests
+	limits := workload.Resources.Limits
+	switch {
+	case requests.CPU == "" && requests.Memory == "":
+		return QoSBestEffort
+	case requests.CPU != "" && requests.Memory != "" && requests.CPU == limits.CPU && requests.Memory == limits.Memory:
+		return QoSGuaranteed
+	default:
+		return QoSBurstable
+	}
+}
+
+// Commitment is what running deployments have committed on a node
+type Commitment struct {
+	Total      ResourceAmounts `json:"total"`
+	Guaranteed ResourceAmounts `json:"guaranteed"`
+	// GPUs claimed by the placed replicas, allocated on the node's devices when more are placed
+	GPUs []GPUClaim `json:"gpus,omitempty"`
+}
+
+// with returns the commitment after adding (n > 0) or removing (n < 0) replicas of a workload
+func (c Commitment) with(workload *Workload, n int32) Commitment {
+	if workload.Resources.GPU != nil {
+		c.GPUs = withGPUClaim(c.GPUs, workload, n)
+	}
+	class := workloadQoS(workload)
+	if class == QoSBestEffort {
+		return c
+	}
+	amounts := workloadRequests(workload).Scale(n)
+	c.Total = c.Total.Add(amounts)
+	if class == QoSGuaranteed {
+		c.Guaranteed = c.Guaranteed.Add(amounts)
+	}
+	return c
+}
+
+// OvercommitPolicy lets burstable workloads commit more than a node group's allocatable capacity
+type OvercommitPolicy struct {
+	ID string `json:"id"`
+	NodeGroup
+	Name        string    `json:"name"`
+	CPURatio    float64   `json:"cpu_ratio"`
+	MemoryRatio float64   `json:"memory_ratio"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OvercommitPolicyRequest represents an overcommit policy creation request
+type OvercommitPolicyRequest struct {
+	Name         string            `json:"name" binding:"required"`
+	NodeSelector map[string]string `json:"node_selector"`
+	SiteID       string            `json:"site_id"`
+	CPURatio     float64           `json:"cpu_ratio"`
+	MemoryRatio  float64           `json:"memory_ratio"`
+}
+
+// OvercommitFor returns the CPU and memory overcommit ratios for a node. When several
+// policies match, the most conservative ratio per dimension applies, and no ratio
+// exceeds the configured maximum.
+func (rm *ReservationManager) OvercommitFor(node *EdgeNode) (float64, float64) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	cpuRatio, memoryRatio := 0.0, 0.0
+	for _, policy := range rm.overcommits {
+		if !policy.matchesNode(node) {
+			continue
+		}
+		if cpuRatio == 0 || policy.CPURatio < cpuRatio {
+			cpuRatio = policy.CPURatio
+		}
+		if memoryRatio == 0 || policy.MemoryRatio < memoryRatio {
+			memoryRatio = policy.MemoryRatio
+		}
+	}
+	if cpuRatio == 0 {
+		cpuRatio = 1
+	}
+	if memoryRatio == 0 {
+		memoryRatio = 1
+	}
+	return math.Min(cpuRatio, rm.maxOvercommit), math.Min(memoryRatio, rm.maxOvercommit)
+}
+
+// overcommittedCapacity scales allocatable capacity by the node's overcommit ratios
+func (co *CentralOrchestrator) overcommittedCapacity(node *EdgeNode, allocatable ResourceAmounts) ResourceAmounts {
+	cpuRatio, memoryRatio := co.ReservationManager.OvercommitFor(node)
+	return ResourceAmounts{
+		MilliCPU:    int64(float64(allocatable.MilliCPU) * cpuRatio),
+		MemoryBytes: int64(float64(allocatable.MemoryBytes) * memoryRatio),
+	}
+}
+
+// NodeAllocation compares what scheduled workloads request on a node with what the node
+// reports using. Requests are accounted when replicas are placed, whether or not they
+// have started, so they can exceed usage or fall short of it.
+type NodeAllocation struct {
+	Capacity ResourceAmounts `json:"capacity"`
+	// Held back for system components by resource reservations
+	SystemReserved ResourceAmounts `json:"system_reserved"`
+	Allocatable    ResourceAmounts `json:"allocatable"`
+	// Allocatable capacity scaled by the node's overcommit ratios, the most burstable
+	// requests may add up to
+	Overcommitted    ResourceAmounts `json:"overcommitted"`
+	CPUOvercommit    float64         `json:"cpu_overcommit_ratio"`
+	MemoryOvercommit float64         `json:"memory_overcommit_ratio"`
+	Requested        Commitment      `json:"requested"`
+	Available        ResourceAmounts `json:"available"`
+	Usage            ResourceAmounts `json:"usage"`
+}
+
+// nodeAllocation accounts a node's capacity against the requests committed on it. A zero
+// field means the capacity or usage is unknown.
+func (co *CentralOrchestrator) nodeAllocation(node *EdgeNode, committed Commitment) NodeAllocation {
+	allocatable := co.allocatableCapacity(node)
+	overcommitted := co.overcommittedCapacity(node, allocatable)
+	cpuRatio, memoryRatio := co.ReservationManager.OvercommitFor(node)
+	available := ResourceAmounts{
+		MilliCPU:    overcommitted.MilliCPU - committed.Total.MilliCPU,
+		MemoryBytes: overcommitted.MemoryBytes - committed.Total.MemoryBytes,
+	}
+	if overcommitted.MilliCPU == 0 || available.MilliCPU < 0 {
+		available.MilliCPU = 0
+	}
+	if overcommitted.MemoryBytes == 0 || available.MemoryBytes < 0 {
+		available.MemoryBytes = 0
+	}
+	return NodeAllocation{
+		Capacity:         nodeCapacity(node),
+		SystemReserved:   co.ReservationManager.ReservedFor(node),
+		Allocatable:      allocatable,
+		Overcommitted:    overcommitted,
+		CPUOvercommit:    cpuRatio,
+		MemoryOvercommit: memoryRatio,
+		Requested:        committed,
+		Available:        available,
+		Usage:            nodeUsage(node),
+	}
+}
+
+// fitsOnNode reports whether replicas of a workload fit on a node given its commitment.
+// Guaranteed requests must fit in allocatable capacity; all requests together may use
+// the overcommitted capacity; best-effort workloads always fit.
+func (co *CentralOrchestrator) fitsOnNode(node *EdgeNode, committed Commitment, workload *Workload, replicas int32) bool {
+	return co.shortResource(node, committed, workload, replicas) == ""
+}
+
+// shortResource returns the resource a node lacks for the replicas, "gpu", "cpu" or
+// "memory", or "" when they fit
+func (co *CentralOrchestrator) shortResource(node *EdgeNode, committed Commitment, workload *Workload, replicas int32) string {
+	// GPUs are never overcommitted, whatever the workload's QoS class
+	if workload.Resources.GPU != nil && !gpusFitOnNode(node, committed, workload, replicas) {
+		return "gpu"
+	}
+
+	class := workloadQoS(workload)
+	if class == QoSBestEffort {
+		return ""
+	}
+
+	needed := workloadRequests(workload).Scale(replicas)
+	allocatable := co.allocatableCapacity(node)
+
+	if short := exceeded(co.overcommittedCapacity(node, allocatable), committed.Total, needed); short != "" {
+		return short
+	}
+	if class == QoSGuaranteed {
+		return exceeded(allocatable, committed.Guaranteed, needed)
+	}
+	return ""
+}
+
+// CreateOvercommitPolicy sets overcommit ratios for a node group
+func (co *CentralOrchestrator) CreateOvercommitPolicy(c *gin.Context) {
+	var req OvercommitPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.CPURatio == 0 {
+		req.CPURatio = 1
+	}
+	if req.MemoryRatio == 0 {
+		req.MemoryRatio = 1
+	}
+	if req.CPURatio < 1 || req.MemoryRatio < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "overcommit ratios must be at least 1"})
+		return
+	}
+	if maxRatio := co.ReservationManager.maxOvercommit; req.CPURatio > maxRatio || req.MemoryRatio > maxRatio {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("overcommit ratios must not exceed %g", maxRatio)})
+		return
+	}
+
+	policy := &OvercommitPolicy{
+		ID:          generateID(),
+		NodeGroup:   NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID},
+		Name:        req.Name,
+		CPURatio:    req.CPURatio,
+		MemoryRatio: req.MemoryRatio,
+		CreatedAt:   time.Now(),
+	}
+	if policy.NodeSelector == nil {
+		policy.NodeSelector = make(map[string]string)
+	}
+
+	co.ReservationManager.mutex.Lock()
+	co.ReservationManager.overcommits[policy.ID] = policy
+	co.ReservationManager.mutex.Unlock()
+
+	co.Logger.Infof("Overcommit policy %s created with ID %s", policy.Name, policy.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"id": policy.ID, "policy": policy})
+}
+
+// ListOvercommitPolicies returns all overcommit policies
+func (co *CentralOrchestrator) ListOvercommitPolicies(c *gin.Context) {
+	co.ReservationManager.mutex.RLock()
+	defer co.ReservationManager.mutex.RUnlock()
+
+	policies := make([]*OvercommitPolicy, 0, len(co.ReservationManager.overcommits))
+	for _, policy := range co.ReservationManager.overcommits {
+		policies = append(policies, policy)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// DeleteOvercommitPolicy removes an overcommit policy
+func (co *CentralOrchestrator) DeleteOvercommitPolicy(c *gin.Context) {
+	policyID := c.Param("id")
+
+	co.ReservationManager.mutex.Lock()
+	defer co.ReservationManager.mutex.Unlock()
+
+	if _, exists := co.ReservationManager.overcommits[policyID]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Overcommit policy not found"})
+		return
+	}
+
+	delete(co.ReservationManager.overcommits, policyID)
+	co.Logger.Infof("Overcommit policy %s deleted", policyID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Overcommit policy deleted successfully"})
+}
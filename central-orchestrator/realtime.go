@@ -0,0 +1,53 @@
+package main
+
+import "fmt"
+
+const (
+	// Capability agents advertise on nodes running a PREEMPT_RT kernel
+	RealtimeCapability = "realtime"
+
+	// Real-time scheduling policies, SCHED_FIFO and SCHED_RR
+	RealtimePolicyFIFO = "fifo"
+	RealtimePolicyRR   = "rr"
+
+	// Priority of real-time workloads that do not set one
+	DefaultRealtimePriority = 50
+	// Highest priority a workload may ask for; 99 is left to the kernel's own threads
+	MaxRealtimePriority = 98
+)
+
+// RealtimePolicy makes a workload real-time: its replicas only run on PREEMPT_RT nodes, on
+// exclusive CPUs, with their threads scheduled at a fixed real-time priority
+type RealtimePolicy struct {
+	// "fifo" (default) or "rr"
+	Policy string `json:"policy"`
+	// 1 to 98; higher preempts lower
+	Priority int32 `json:"priority"`
+}
+
+// apply checks a real-time policy and fills in its defaults. Real-time threads starve
+// anything else on their CPUs, so the workload must pin its own.
+func (p *RealtimePolicy) apply(resources *WorkloadResources) error {
+	switch p.Policy {
+	case "":
+		p.Policy = RealtimePolicyFIFO
+	case RealtimePolicyFIFO, RealtimePolicyRR:
+	default:
+		return fmt.Errorf("realtime policy must be %s or %s", RealtimePolicyFIFO, RealtimePolicyRR)
+	}
+	if p.Priority == 0 {
+		p.Priority = DefaultRealtimePriority
+	}
+	if p.Priority < 1 || p.Priority > MaxRealtimePriority {
+		return fmt.Errorf("realtime priority must be between 1 and %d", MaxRealtimePriority)
+	}
+	if resources.CPUPinning == nil {
+		return fmt.Errorf("realtime workloads require cpu_pinning")
+	}
+	return nil
+}
+
+// nodeRunsRealtime reports whether a node runs a PREEMPT_RT kernel
+func nodeRunsRealtime(node *EdgeNode) bool {
+	return contains(node.Capabilities, RealtimeCapability)
+}
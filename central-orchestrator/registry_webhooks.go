@@ -0,0 +1,490 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Push events kept for GET /api/v1/image-pushes
+	MaxImagePushEvents = 100
+
+	// Largest registry webhook payload accepted
+	MaxRegistryWebhookBody = 1 << 20
+)
+
+// Registry webhook payload formats, the :format of /webhooks/registry/:format
+const (
+	RegistryFormatHarbor    = "harbor"
+	RegistryFormatDockerHub = "dockerhub"
+	RegistryFormatGHCR      = "ghcr"
+)
+
+// ImageRolloutStrategy is how a workload moves to a newly pushed image
+type ImageRolloutStrategy string
+
+const (
+	// The workload's replicas are updated in place to a new revision
+	ImageRolloutRolling ImageRolloutStrategy = "rolling"
+	// The new image starts next to the current one as a blue-green deployment
+	ImageRolloutBlueGreen ImageRolloutStrategy = "blue-green"
+)
+
+// ImageSubscription rolls a workload out to new tags of its image as its registry reports
+// them pushed
+type ImageSubscription struct {
+	// Repository such as ghcr.io/acme/detector; defaults to that of the workload's image
+	Repository string `json:"repository"`
+	// Glob the pushed tag must match, such as "v1.*"
+	TagPattern string `json:"tag_pattern" binding:"required"`
+	// "rolling" (default) or "blue-green"
+	Strategy ImageRolloutStrategy `json:"strategy"`
+	// Blue-green rollouts wait for the traffic switch to be requested
+	ManualSwitch bool `json:"manual_switch,omitempty"`
+
+	// Image of the last push that rolled the workload out
+	LastImage    string     `json:"last_image,omitempty"`
+	LastPushedAt *time.Time `json:"last_pushed_at,omitempty"`
+}
+
+// ImagePush is an image tag a registry reports pushed
+type ImagePush struct {
+	// Normalized, as by normalizeRepository
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest,omitempty"`
+}
+
+// ImageRollout is what a push did to one subscribed workload
+type ImageRollout struct {
+	WorkloadID   string               `json:"workload_id"`
+	WorkloadName string               `json:"workload_name"`
+	Strategy     ImageRolloutStrategy `json:"strategy"`
+	Image        string               `json:"image"`
+	// Revision the workload rolls out to, for rolling rollouts
+	Revision int64 `json:"revision,omitempty"`
+	// Preview workload of the new image, for blue-green rollouts
+	PreviewWorkloadID string `json:"preview_workload_id,omitempty"`
+	// Why the workload was not rolled out
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// ImagePushEvent is a received push and the rollouts it started
+type ImagePushEvent struct {
+	ID         string         `json:"id"`
+	Format     string         `json:"format"`
+	Push       ImagePush      `json:"push"`
+	Rollouts   []ImageRollout `json:"rollouts"`
+	ReceivedAt time.Time      `json:"received_at"`
+}
+
+// RegistryWebhooks receives image push webhooks from container registries and keeps the
+// latest push events
+type RegistryWebhooks struct {
+	// Shared with the registries; the receiver is disabled without one
+	secret string
+	events []*ImagePushEvent
+	mutex  sync.RWMutex
+	logger *logrus.Logger
+}
+
+// NewRegistryWebhooks creates a new registry webhook receiver
+func NewRegistryWebhooks(logger *logrus.Logger) *RegistryWebhooks {
+	return &RegistryWebhooks{
+		secret: os.Getenv("REGISTRY_WEBHOOK_SECRET"),
+		logger: logger,
+	}
+}
+
+// Enabled reports whether registry webhooks are received
+func (rw *RegistryWebhooks) Enabled() bool {
+	return rw.secret != ""
+}
+
+// record keeps a push event, dropping the oldest beyond MaxImagePushEvents
+func (rw *RegistryWebhooks) record(event *ImagePushEvent) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	rw.events = append(rw.events, event)
+	if len(rw.events) > MaxImagePushEvents {
+		rw.events = rw.events[len(rw.events)-MaxImagePushEvents:]
+	}
+}
+
+// authenticate checks a webhook carries the shared secret the way its registry can send
+// it: GHCR signs the payload, Harbor sends a configured Authorization header, and Docker
+// Hub, which can do neither, is given it in the webhook URL's token parameter
+func (rw *RegistryWebhooks) authenticate(c *gin.Context, format string, body []byte) bool {
+	switch format {
+	case RegistryFormatGHCR:
+		mac := hmac.New(sha256.New, []byte(rw.secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(c.GetHeader("X-Hub-Signature-256")), []byte(expected))
+	case RegistryFormatHarbor:
+		header := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(header), []byte(rw.secret)) == 1
+	case RegistryFormatDockerHub:
+		return subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(rw.secret)) == 1
+	}
+	return false
+}
+
+// validate checks the subscription of a workload running image and fills in its defaults
+func (s *ImageSubscription) validate(image string) error {
+	if s.Repository == "" {
+		s.Repository, _, _ = splitImageReference(image)
+	}
+	if _, err := path.Match(s.TagPattern, ""); err != nil {
+		return fmt.Errorf("invalid tag_pattern %q: %v", s.TagPattern, err)
+	}
+	switch s.Strategy {
+	case "":
+		s.Strategy = ImageRolloutRolling
+	case ImageRolloutRolling, ImageRolloutBlueGreen:
+	default:
+		return fmt.Errorf("strategy must be %s or %s", ImageRolloutRolling, ImageRolloutBlueGreen)
+	}
+	return nil
+}
+
+// splitImageReference splits an image reference into its repository, tag and digest
+func splitImageReference(image string) (repository, tag, digest string) {
+	repository, digest, _ = strings.Cut(image, "@")
+	// A colon after the last slash starts the tag; one before it is the registry's port
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
+
+// normalizeRepository names a repository with its registry, as "nginx" and
+// "docker.io/library/nginx" name the same one
+func normalizeRepository(repository string) string {
+	repository = strings.ToLower(repository)
+	registry, name, found := strings.Cut(repository, "/")
+	if !found || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		registry, name = "docker.io", repository
+	}
+	if registry == "index.docker.io" || registry == "registry-1.docker.io" {
+		registry = "docker.io"
+	}
+	if registry == "docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return registry + "/" + name
+}
+
+// harborEvent is the part of a Harbor webhook naming the pushed artifacts
+type harborEvent struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Digest      string `json:"digest"`
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+	} `json:"event_data"`
+}
+
+// dockerHubEvent is the part of a Docker Hub webhook naming the pushed tag
+type dockerHubEvent struct {
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+// ghcrEvent is the part of a GitHub package webhook naming the pushed container tag
+type ghcrEvent struct {
+	Action  string `json:"action"`
+	Package struct {
+		Name        string `json:"name"`
+		PackageType string `json:"package_type"`
+		Owner       struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		PackageVersion struct {
+			Version           string `json:"version"`
+			ContainerMetadata struct {
+				Tag struct {
+					Name   string `json:"name"`
+					Digest string `json:"digest"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	} `json:"package"`
+}
+
+// parseImagePushes returns the pushes a registry webhook reports; other events yield none
+func parseImagePushes(format, githubEvent string, body []byte) ([]ImagePush, error) {
+	var pushes []ImagePush
+	switch format {
+	case RegistryFormatHarbor:
+		var event harborEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		// PUSH_ARTIFACT since Harbor 2.0, pushImage before
+		if event.Type != "PUSH_ARTIFACT" && event.Type != "pushImage" {
+			return nil, nil
+		}
+		for _, resource := range event.EventData.Resources {
+			repository, _, _ := splitImageReference(resource.ResourceURL)
+			if resource.Tag != "" && repository != "" {
+				pushes = append(pushes, ImagePush{Repository: normalizeRepository(repository), Tag: resource.Tag, Digest: resource.Digest})
+			}
+		}
+	case RegistryFormatDockerHub:
+		var event dockerHubEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		if event.PushData.Tag != "" && event.Repository.RepoName != "" {
+			pushes = append(pushes, ImagePush{Repository: normalizeRepository(event.Repository.RepoName), Tag: event.PushData.Tag})
+		}
+	case RegistryFormatGHCR:
+		if githubEvent != "package" && githubEvent != "registry_package" {
+			return nil, nil
+		}
+		var event ghcrEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		pkg := event.Package
+		tag := pkg.PackageVersion.ContainerMetadata.Tag
+		if (event.Action != "published" && event.Action != "updated") || !strings.EqualFold(pkg.PackageType, "container") || tag.Name == "" {
+			return nil, nil
+		}
+		digest := tag.Digest
+		if digest == "" && strings.HasPrefix(pkg.PackageVersion.Version, "sha256:") {
+			digest = pkg.PackageVersion.Version
+		}
+		repository := "ghcr.io/" + pkg.Owner.Login + "/" + pkg.Name
+		pushes = append(pushes, ImagePush{Repository: normalizeRepository(repository), Tag: tag.Name, Digest: digest})
+	default:
+		return nil, fmt.Errorf("unknown registry format %q", format)
+	}
+	return pushes, nil
+}
+
+// rollOutImagePush rolls the workloads subscribed to a pushed tag out to it, each with its
+// subscription's strategy. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) rollOutImagePush(push ImagePush, now time.Time) []ImageRollout {
+	var subscribed []*Workload
+	for _, workload := range co.WorkloadManager.workloads {
+		sub := workload.ImageSubscription
+		if sub == nil || normalizeRepository(sub.Repository) != push.Repository {
+			continue
+		}
+		if matched, _ := path.Match(sub.TagPattern, push.Tag); matched {
+			subscribed = append(subscribed, workload)
+		}
+	}
+	sort.Slice(subscribed, func(i, j int) bool { return subscribed[i].Name < subscribed[j].Name })
+
+	rollouts := make([]ImageRollout, 0, len(subscribed))
+	for _, workload := range subscribed {
+		sub := workload.ImageSubscription
+		// The digest pins the pushed image, so pushing a moving tag again rolls out again
+		image := sub.Repository + ":" + push.Tag
+		if push.Digest != "" {
+			image += "@" + push.Digest
+		}
+		rollout := ImageRollout{WorkloadID: workload.ID, WorkloadName: workload.Name, Strategy: sub.Strategy, Image: image}
+
+		switch {
+		case workload.Image == image:
+			rollout.Skipped = "workload already runs the image"
+		case workload.BlueGreen != nil:
+			rollout.Skipped = fmt.Sprintf("workload has a blue-green deployment %s", workload.BlueGreen.Phase)
+		case sub.Strategy == ImageRolloutBlueGreen:
+			spec, err := workloadRequest(workload)
+			if err != nil {
+				rollout.Skipped = err.Error()
+				break
+			}
+			spec.Image = image
+			preview, err := co.startBlueGreenDeployment(workload, spec, sub.ManualSwitch, now)
+			if err != nil {
+				rollout.Skipped = err.Error()
+				break
+			}
+			rollout.PreviewWorkloadID = preview.ID
+		default:
+			next := *workload
+			next.Image = image
+			rolled := rollOutWorkload(workload, &next, now)
+			rolled.UpdatedAt = now
+			co.recordRevision(workload, rolled, "image push "+image, now)
+			co.WorkloadManager.workloads[rolled.ID] = rolled
+			rollout.Revision = rolled.Revision
+		}
+
+		if rollout.Skipped != "" {
+			co.Logger.Infof("Push of %s did not roll out workload %s: %s", image, workload.Name, rollout.Skipped)
+		} else {
+			sub.LastImage, sub.LastPushedAt = image, &now
+			co.Logger.Infof("Push of %s rolled out workload %s (%s)", image, workload.Name, sub.Strategy)
+		}
+		rollouts = append(rollouts, rollout)
+	}
+	return rollouts
+}
+
+// workloadRequest returns the deployment request of a workload's current spec
+func workloadRequest(workload *Workload) (WorkloadDeploymentRequest, error) {
+	var req WorkloadDeploymentRequest
+	data, err := json.Marshal(workload)
+	if err != nil {
+		return req, err
+	}
+	err = json.Unmarshal(data, &req)
+	return req, err
+}
+
+// ReceiveRegistryWebhook rolls out the workloads subscribed to the tags a registry reports
+// pushed. It sits outside the API's authentication; registries authenticate with the
+// shared secret instead.
+func (co *CentralOrchestrator) ReceiveRegistryWebhook(c *gin.Context) {
+	format := c.Param("format")
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxRegistryWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !co.RegistryWebhooks.authenticate(c, format, body) {
+		co.Logger.Warnf("Rejected %s registry webhook from %s", format, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook credentials"})
+		return
+	}
+	c.Set("user", "registry:"+format)
+
+	pushes, err := parseImagePushes(format, c.GetHeader("X-GitHub-Event"), body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s webhook: %v", format, err)})
+		return
+	}
+	if len(pushes) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	now := time.Now()
+	events := make([]*ImagePushEvent, 0, len(pushes))
+	co.WorkloadManager.mutex.Lock()
+	for _, push := range pushes {
+		events = append(events, &ImagePushEvent{
+			ID:         generateID(),
+			Format:     format,
+			Push:       push,
+			Rollouts:   co.rollOutImagePush(push, now),
+			ReceivedAt: now,
+		})
+	}
+	co.WorkloadManager.mutex.Unlock()
+	co.AgentStreamHub.wake()
+
+	for _, event := range events {
+		co.RegistryWebhooks.record(event)
+		for _, rollout := range event.Rollouts {
+			if rollout.Skipped == "" {
+				co.AuditLog.RecordRequest(c, "workload.image_push", "workload:"+rollout.WorkloadID,
+					map[string]string{"image": rollout.Image, "strategy": string(rollout.Strategy)})
+			}
+		}
+	}
+	c.JSON(http.StatusAccepted, gin.H{"events": events})
+}
+
+// ListImagePushEvents lists the received push events, newest first
+func (co *CentralOrchestrator) ListImagePushEvents(c *gin.Context) {
+	rw := co.RegistryWebhooks
+	rw.mutex.RLock()
+	events := make([]*ImagePushEvent, 0, len(rw.events))
+	for i := len(rw.events) - 1; i >= 0; i-- {
+		events = append(events, rw.events[i])
+	}
+	rw.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"events": events, "count": len(events)})
+}
+
+// SetWorkloadImageSubscription subscribes a workload to pushes of its image's tags
+func (co *CentralOrchestrator) SetWorkloadImageSubscription(c *gin.Context) {
+	var req ImageSubscription
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+	switch {
+	case workload.BlueGreenOf != "":
+		c.JSON(http.StatusConflict, gin.H{"error": "Workload is the new version of a blue-green deployment"})
+		return
+	case workload.Type != WorkloadTypeDeployment && workload.Type != WorkloadTypeStatefulSet && workload.Type != WorkloadTypeDaemonSet:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Image subscriptions are not supported for %s workloads", workload.Type)})
+		return
+	}
+	if err := req.validate(workload.Image); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.LastImage, req.LastPushedAt = "", nil
+	if previous := workload.ImageSubscription; previous != nil {
+		req.LastImage, req.LastPushedAt = previous.LastImage, previous.LastPushedAt
+	}
+
+	workload.ImageSubscription = &req
+	workload.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Workload %s follows pushes of %s:%s with %s rollouts", workload.Name, req.Repository, req.TagPattern, req.Strategy)
+	co.AuditLog.RecordRequest(c, "workload.image_subscription.set", "workload:"+workload.ID,
+		map[string]string{"repository": req.Repository, "tag_pattern": req.TagPattern, "strategy": string(req.Strategy)})
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
+
+// DeleteWorkloadImageSubscription stops rolling a workload out on image pushes
+func (co *CentralOrchestrator) DeleteWorkloadImageSubscription(c *gin.Context) {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	workload.ImageSubscription = nil
+	workload.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Workload %s no longer follows image pushes", workload.Name)
+	co.AuditLog.RecordRequest(c, "workload.image_subscription.delete", "workload:"+workload.ID, nil)
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
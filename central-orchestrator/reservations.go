@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaxOvercommitRatio caps overcommit policies when MAX_OVERCOMMIT_RATIO is unset
+const DefaultMaxOvercommitRatio = 4.0
+
+// NodeGroup selects nodes by site and labels; an empty group matches every node
+type NodeGroup struct {
+	NodeSelector map[string]string `json:"node_selector"`
+	SiteID       string            `json:"site_id,omitempty"`
+}
+
+// matchesNode reports whether a node belongs to the group
+func (g NodeGroup) matchesNode(node *EdgeNode) bool {
+	if g.SiteID != "" && node.SiteID != g.SiteID {
+		return false
+	}
+	for key, value := range g.NodeSelector {
+		if node.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ResourceReservation holds back CPU and memory on a group of nodes for the kubelet,
+// the agent and other system components
+type ResourceReservation struct {
+	ID string `json:"id"`
+	NodeGroup
+	Name      string          `json:"name"`
+	CPU       string          `json:"cpu"`
+	Memory    string          `json:"memory"`
+	Reserved  ResourceAmounts `json:"reserved"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ResourceReservationRequest represents a reservation creation request
+type ResourceReservationRequest struct {
+	Name         string            `json:"name" binding:"required"`
+	NodeSelector map[string]string `json:"node_selector"`
+	SiteID       string            `json:"site_id"`
+	CPU          string            `json:"cpu"`
+	Memory       string            `json:"memory"`
+}
+
+// ReservationManager manages system resource reservations
+type ReservationManager struct {
+	reservations map[string]*ResourceReservation
+	overcommits  map[string]*OvercommitPolicy
+	// Highest CPU or memory overcommit ratio any policy may set
+	maxOvercommit float64
+	mutex         sync.RWMutex
+	logger        *logrus.Logger
+}
+
+// NewReservationManager creates a new reservation manager
+func NewReservationManager(logger *logrus.Logger) *ReservationManager {
+	maxOvercommit := DefaultMaxOvercommitRatio
+	if ratio, err := strconv.ParseFloat(os.Getenv("MAX_OVERCOMMIT_RATIO"), 64); err == nil && ratio >= 1 {
+		maxOvercommit = ratio
+	}
+	return &ReservationManager{
+		reservations:  make(map[string]*ResourceReservation),
+		overcommits:   make(map[string]*OvercommitPolicy),
+		maxOvercommit: maxOvercommit,
+		logger:        logger,
+	}
+}
+
+// ReservedFor returns the resources reserved on a node. When several reservations match,
+// the largest amount per dimension applies.
+func (rm *ReservationManager) ReservedFor(node *EdgeNode) ResourceAmounts {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	var reserved ResourceAmounts
+	for _, reservation := range rm.reservations {
+		if !reservation.matchesNode(node) {
+			continue
+		}
+		if reservation.Reserved.MilliCPU > reserved.MilliCPU {
+			reserved.MilliCPU = reservation.Reserved.MilliCPU
+		}
+		if reservation.Reserved.MemoryBytes > reserved.MemoryBytes {
+			reserved.MemoryBytes = reservation.Reserved.MemoryBytes
+		}
+	}
+	return reserved
+}
+
+// allocatableCapacity returns the node capacity minus system reservations. A reservation
+// at or above capacity leaves a single unit, so the dimension stays enforced with no room.
+func (co *CentralOrchestrator) allocatableCapacity(node *EdgeNode) ResourceAmounts {
+	capacity := nodeCapacity(node)
+	reserved := co.ReservationManager.ReservedFor(node)
+
+	if capacity.MilliCPU > 0 {
+		capacity.MilliCPU -= reserved.MilliCPU
+		if capacity.MilliCPU < 1 {
+			capacity.MilliCPU = 1
+		}
+	}
+	if capacity.MemoryBytes > 0 {
+		capacity.MemoryBytes -= reserved.MemoryBytes
+		if capacity.MemoryBytes < 1 {
+			capacity.MemoryBytes = 1
+		}
+	}
+	return capacity
+}
+
+// CreateResourceReservation reserves resources on a node group
+func (co *CentralOrchestrator) CreateResourceReservation(c *gin.Context) {
+	var req ResourceReservationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reservation := &ResourceReservation{
+		ID:        generateID(),
+		NodeGroup: NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID},
+		Name:      req.Name,
+		CPU:       req.CPU,
+		Memory:    req.Memory,
+		CreatedAt: time.Now(),
+	}
+	if reservation.NodeSelector == nil {
+		reservation.NodeSelector = make(map[string]string)
+	}
+
+	if req.CPU != "" {
+		q, ok := parseQuantity(req.CPU)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid cpu quantity %q", req.CPU)})
+			return
+		}
+		reservation.Reserved.MilliCPU = q.MilliValue()
+	}
+	if req.Memory != "" {
+		q, ok := parseQuantity(req.Memory)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid memory quantity %q", req.Memory)})
+			return
+		}
+		reservation.Reserved.MemoryBytes = q.Value()
+	}
+
+	co.ReservationManager.mutex.Lock()
+	co.ReservationManager.reservations[reservation.ID] = reservation
+	co.ReservationManager.mutex.Unlock()
+
+	co.Logger.Infof("Resource reservation %s created with ID %s", reservation.Name, reservation.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"id": reservation.ID, "reservation": reservation})
+}
+
+// ListResourceReservations returns all resource reservations
+func (co *CentralOrchestrator) ListResourceReservations(c *gin.Context) {
+	co.ReservationManager.mutex.RLock()
+	defer co.ReservationManager.mutex.RUnlock()
+
+	reservations := make([]*ResourceReservation, 0, len(co.ReservationManager.reservations))
+	for _, reservation := range co.ReservationManager.reservations {
+		reservations = append(reservations, reservation)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reservations": reservations})
+}
+
+// DeleteResourceReservation removes a resource reservation
+func (co *CentralOrchestrator) DeleteResourceReservation(c *gin.Context) {
+	reservationID := c.Param("id")
+
+	co.ReservationManager.mutex.Lock()
+	defer co.ReservationManager.mutex.Unlock()
+
+	if _, exists := co.ReservationManager.reservations[reservationID]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resource reservation not found"})
+		return
+	}
+
+	delete(co.ReservationManager.reservations, reservationID)
+	co.Logger.Infof("Resource reservation %s deleted", reservationID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Resource reservation deleted successfully"})
+}
+
+// GetNodeCapacity returns a node's capacity, reserved, committed and allocatable resources
+func (co *CentralOrchestrator) GetNodeCapacity(c *gin.Context) {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	node, exists := co.NodeManager.nodes[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	allocatable := co.allocatableCapacity(node)
+	c.JSON(http.StatusOK, gin.H{
+		"node_id":       node.ID,
+		"capacity":      nodeCapacity(node),
+		"reserved":      co.ReservationManager.ReservedFor(node),
+		"allocatable":   allocatable,
+		"overcommitted": co.overcommittedCapacity(node, allocatable),
+		"committed":     committedResources(co.WorkloadManager.workloads)[node.ID],
+		"usage":         nodeUsage(node),
+		"forecast":      co.capacityForecast(node.ID),
+		"cloud":         node.Cloud,
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Revisions kept per workload, as the revision history limit of a Kubernetes Deployment
+	MaxWorkloadRevisions = 10
+)
+
+// revisionExcludedFields are left out of a revision's spec. Besides the volatile fields,
+// scaling and expiry are not changes to roll back, as with kubectl rollout, and the
+// environment binding follows the environment.
+var revisionExcludedFields = append([]string{"replicas", "expires_at", "environment_binding"}, volatileWorkloadFields...)
+
+// WorkloadRevision is a workload's spec as of one change
+type WorkloadRevision struct {
+	Revision    int64                  `json:"revision"`
+	Spec        map[string]interface{} `json:"spec"`
+	SpecHash    string                 `json:"spec_hash"`
+	ChangeCause string                 `json:"change_cause,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// RollbackRequest selects the revision to return to; the previous one when zero
+type RollbackRequest struct {
+	Revision int64 `json:"revision"`
+}
+
+// WorkloadRevisionHistory keeps the latest revisions of every workload, oldest first
+type WorkloadRevisionHistory struct {
+	revisions map[string][]*WorkloadRevision
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewWorkloadRevisionHistory creates a new workload revision history
+func NewWorkloadRevisionHistory(logger *logrus.Logger) *WorkloadRevisionHistory {
+	return &WorkloadRevisionHistory{
+		revisions: make(map[string][]*WorkloadRevision),
+		logger:    logger,
+	}
+}
+
+// restore loads persisted histories, replacing the current ones when replace is set
+func (rh *WorkloadRevisionHistory) restore(revisions map[string][]*WorkloadRevision, replace bool) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	if replace {
+		rh.revisions = make(map[string][]*WorkloadRevision, len(revisions))
+	}
+	for id, history := range revisions {
+		rh.revisions[id] = history
+	}
+}
+
+// revisionSpec returns the parts of a workload that make up a revision, with their hash
+func revisionSpec(workload *Workload) (map[string]interface{}, string, error) {
+	data, err := json.Marshal(workload)
+	if err != nil {
+		return nil, "", err
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, "", err
+	}
+	for _, field := range revisionExcludedFields {
+		delete(spec, field)
+	}
+	hash, err := hashDocument(spec)
+	if err != nil {
+		return nil, "", err
+	}
+	return spec, hash, nil
+}
+
+// record makes the workload's spec its newest revision and sets workload.Revision. An
+// unchanged spec keeps its revision; returning to an older spec renumbers that revision
+// as the newest, as kubectl rollout undo does. Callers must hold the WorkloadManager lock.
+func (rh *WorkloadRevisionHistory) record(workload *Workload, cause string, now time.Time) (*WorkloadRevision, error) {
+	spec, hash, err := revisionSpec(workload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workload %s: %v", workload.ID, err)
+	}
+
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	history := rh.revisions[workload.ID]
+	next := int64(1)
+	if len(history) > 0 {
+		latest := history[len(history)-1]
+		if latest.SpecHash == hash {
+			workload.Revision = latest.Revision
+			return latest, nil
+		}
+		next = latest.Revision + 1
+	}
+
+	kept := make([]*WorkloadRevision, 0, len(history)+1)
+	for _, revision := range history {
+		if revision.SpecHash != hash {
+			kept = append(kept, revision)
+		}
+	}
+	revision := &WorkloadRevision{Revision: next, Spec: spec, SpecHash: hash, ChangeCause: cause, CreatedAt: now}
+	kept = append(kept, revision)
+	if len(kept) > MaxWorkloadRevisions {
+		kept = kept[len(kept)-MaxWorkloadRevisions:]
+	}
+	rh.revisions[workload.ID] = kept
+	workload.Revision = next
+	return revision, nil
+}
+
+// list returns a workload's revisions, oldest first
+func (rh *WorkloadRevisionHistory) list(workloadID string) []*WorkloadRevision {
+	rh.mutex.RLock()
+	defer rh.mutex.RUnlock()
+
+	return append([]*WorkloadRevision(nil), rh.revisions[workloadID]...)
+}
+
+// forget drops the history of a deleted workload
+func (rh *WorkloadRevisionHistory) forget(workloadID string) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	delete(rh.revisions, workloadID)
+}
+
+// recordRevision records a change to a workload's spec. current is the workload before
+// the change, recorded first for workloads changed before they had a history. Callers must
+// hold the WorkloadManager lock.
+func (co *CentralOrchestrator) recordRevision(current, next *Workload, cause string, now time.Time) {
+	if current != nil && len(co.RevisionHistory.list(current.ID)) == 0 {
+		if _, err := co.RevisionHistory.record(current, "", current.UpdatedAt); err != nil {
+			co.Logger.Errorf("Failed to record revision of workload %s: %v", current.Name, err)
+		}
+	}
+	if _, err := co.RevisionHistory.record(next, cause, now); err != nil {
+		co.Logger.Errorf("Failed to record revision of workload %s: %v", next.Name, err)
+	}
+}
+
+// workloadAtRevision returns the workload with its spec replaced by a revision's, keeping
+// its replicas, expiry, environment binding and status
+func workloadAtRevision(current *Workload, revision *WorkloadRevision) (*Workload, error) {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[string]bool, len(revisionExcludedFields))
+	for _, field := range revisionExcludedFields {
+		excluded[field] = true
+	}
+	for field := range document {
+		if !excluded[field] {
+			delete(document, field)
+		}
+	}
+	for field, value := range revision.Spec {
+		document[field] = value
+	}
+
+	if data, err = json.Marshal(document); err != nil {
+		return nil, err
+	}
+	next := &Workload{}
+	if err := json.Unmarshal(data, next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// ListWorkloadRevisions lists a workload's revisions, oldest first
+func (co *CentralOrchestrator) ListWorkloadRevisions(c *gin.Context) {
+	co.WorkloadManager.mutex.RLock()
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	var current int64
+	if exists {
+		current = workload.Revision
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	revisions := co.RevisionHistory.list(workload.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"workload_id":      workload.ID,
+		"current_revision": current,
+		"revisions":        revisions,
+		"count":            len(revisions),
+	})
+}
+
+// RollbackWorkload returns a workload to an earlier revision, the previous one by default,
+// and rolls it out to its nodes
+func (co *CentralOrchestrator) RollbackWorkload(c *gin.Context) {
+	var req RollbackRequest
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	current, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+	if !tenantAllowed(c, current.Tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", workloadTenant(current))})
+		return
+	}
+
+	history := co.RevisionHistory.list(current.ID)
+	var target *WorkloadRevision
+	if req.Revision == 0 {
+		// The revision before the current one
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Revision < current.Revision {
+				target = history[i]
+				break
+			}
+		}
+		if target == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Workload has no previous revision"})
+			return
+		}
+	} else {
+		for _, revision := range history {
+			if revision.Revision == req.Revision {
+				target = revision
+			}
+		}
+		if target == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Revision %d not found", req.Revision)})
+			return
+		}
+	}
+	if target.Revision == current.Revision {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Workload is already at revision %d", target.Revision)})
+		return
+	}
+
+	next, err := workloadAtRevision(current, target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	from := current.Revision
+	next = rollOutWorkload(current, next, now)
+	next.UpdatedAt = now
+	co.recordRevision(current, next, fmt.Sprintf("rollback to revision %d", target.Revision), now)
+	co.WorkloadManager.workloads[next.ID] = next
+	co.AgentStreamHub.wake()
+
+	co.Logger.Infof("Workload %s rolled back from revision %d to %d, now revision %d", next.Name, from, target.Revision, next.Revision)
+	co.AuditLog.RecordRequest(c, "workload.rollback", "workload:"+next.ID,
+		map[string]string{"from_revision": fmt.Sprint(from), "to_revision": fmt.Sprint(target.Revision)})
+	c.JSON(http.StatusAccepted, gin.H{"workload": next, "revision": next.Revision})
+}
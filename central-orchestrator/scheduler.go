@@ -0,0 +1,401 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SchedulingState is what plugins see of one placement decision
+type SchedulingState struct {
+	Orchestrator *CentralOrchestrator
+	Workload     *Workload
+	// Resources committed on each node by the replicas already placed
+	Committed map[string]Commitment
+	// Replicas of other workloads placed on each node
+	Placed map[string]int32
+	// The workload's resource requests
+	Request ResourceAmounts
+	Now     time.Time
+	// Set by callers that check capacity themselves, such as failover, which may make room
+	// by displacing less critical replicas
+	IgnoreCapacity bool
+}
+
+// FilterPlugin removes the nodes that cannot take a workload's replicas
+type FilterPlugin interface {
+	Name() string
+	Filter(state *SchedulingState, node *EdgeNode) bool
+}
+
+// ExplainingFilterPlugin is a filter plugin that can say why it drops a node, such as
+// "insufficient memory". The reasons are counted in the scheduling condition of workloads
+// that cannot be placed; nodes dropped by other filters count under the filter's name.
+type ExplainingFilterPlugin interface {
+	FilterPlugin
+	Explain(state *SchedulingState, node *EdgeNode) string
+}
+
+// ScorePlugin ranks the nodes that passed every filter; higher scores rank first
+type ScorePlugin interface {
+	Name() string
+	Score(state *SchedulingState, node *EdgeNode) float64
+}
+
+type filterPluginFunc struct {
+	name   string
+	filter func(state *SchedulingState, node *EdgeNode) bool
+}
+
+func (p filterPluginFunc) Name() string { return p.name }
+
+func (p filterPluginFunc) Filter(state *SchedulingState, node *EdgeNode) bool {
+	return p.filter(state, node)
+}
+
+type explainingFilterPluginFunc struct {
+	name    string
+	explain func(state *SchedulingState, node *EdgeNode) string
+}
+
+func (p explainingFilterPluginFunc) Name() string { return p.name }
+
+func (p explainingFilterPluginFunc) Filter(state *SchedulingState, node *EdgeNode) bool {
+	return p.explain(state, node) == ""
+}
+
+func (p explainingFilterPluginFunc) Explain(state *SchedulingState, node *EdgeNode) string {
+	return p.explain(state, node)
+}
+
+type scorePluginFunc struct {
+	name  string
+	score func(state *SchedulingState, node *EdgeNode) float64
+}
+
+func (p scorePluginFunc) Name() string { return p.name }
+
+func (p scorePluginFunc) Score(state *SchedulingState, node *EdgeNode) float64 {
+	return p.score(state, node)
+}
+
+// NewFilterPlugin makes a filter plugin of a function
+func NewFilterPlugin(name string, filter func(state *SchedulingState, node *EdgeNode) bool) FilterPlugin {
+	return filterPluginFunc{name: name, filter: filter}
+}
+
+// NewExplainingFilterPlugin makes a filter plugin of a function returning why it drops a
+// node, or "" to keep it
+func NewExplainingFilterPlugin(name string, explain func(state *SchedulingState, node *EdgeNode) string) FilterPlugin {
+	return explainingFilterPluginFunc{name: name, explain: explain}
+}
+
+// NewScorePlugin makes a score plugin of a function
+func NewScorePlugin(name string, score func(state *SchedulingState, node *EdgeNode) float64) ScorePlugin {
+	return scorePluginFunc{name: name, score: score}
+}
+
+var (
+	customFilterPlugins []FilterPlugin
+	customScorePlugins  []ScorePlugin
+)
+
+// RegisterFilterPlugin adds a filter to the scheduler, after the built-in ones. Call it
+// from an init function of the file defining the plugin.
+func RegisterFilterPlugin(plugin FilterPlugin) {
+	customFilterPlugins = append(customFilterPlugins, plugin)
+}
+
+// RegisterScorePlugin adds a score plugin to the scheduler for every placement strategy.
+// Call it from an init function of the file defining the plugin.
+func RegisterScorePlugin(plugin ScorePlugin) {
+	customScorePlugins = append(customScorePlugins, plugin)
+}
+
+// Scheduler places workloads through a pipeline of plugins. Filter plugins drop the nodes
+// that cannot take a replica. Score plugins then rank the rest: a node ranks above another
+// when the first plugin that tells them apart scores it higher. The workload's placement
+// preferences rank first, then custom score plugins, then those of its placement strategy.
+type Scheduler struct {
+	filters    []FilterPlugin
+	scores     []ScorePlugin
+	strategies map[PlacementStrategy][]ScorePlugin
+	logger     *logrus.Logger
+}
+
+// NewScheduler creates a scheduler with the built-in plugins and the registered custom ones
+func NewScheduler(logger *logrus.Logger) *Scheduler {
+	s := &Scheduler{
+		filters: []FilterPlugin{
+			NewExplainingFilterPlugin("schedulable", filterSchedulable),
+			NewExplainingFilterPlugin("constraints", filterConstraints),
+			NewExplainingFilterPlugin("tenancy", filterTenancy),
+			NewExplainingFilterPlugin("resources", filterResources),
+		},
+		scores: []ScorePlugin{
+			NewScorePlugin("taint-toleration", scoreTaintToleration),
+			NewScorePlugin("affinity", scoreAffinity),
+		},
+		strategies: map[PlacementStrategy][]ScorePlugin{
+			PlacementStrategyEdgeFirst: {
+				NewScorePlugin("edge-nodes", scoreEdgeNodes),
+			},
+			PlacementStrategyCloudFirst: {
+				NewScorePlugin("cloud-nodes", scoreCloudNodes),
+				NewScorePlugin("cloud-price", scoreCloudPrice),
+			},
+			PlacementStrategyLoadBalance: {
+				NewScorePlugin("fewest-replicas", scoreFewestReplicas),
+				NewScorePlugin("utilization", scoreUtilization),
+			},
+			PlacementStrategyResource: {
+				NewScorePlugin("headroom", scoreHeadroom),
+			},
+			PlacementStrategyLatency: {
+				NewScorePlugin("link-quality", scoreLinkQuality),
+				NewScorePlugin("latency", scoreLatency),
+			},
+		},
+		logger: logger,
+	}
+	s.filters = append(s.filters, customFilterPlugins...)
+	s.scores = append(s.scores, customScorePlugins...)
+	return s
+}
+
+// strategyPlugins returns the score plugins of a placement strategy; unknown strategies
+// are placed edge-first
+func (s *Scheduler) strategyPlugins(strategy PlacementStrategy) []ScorePlugin {
+	if plugins, exists := s.strategies[strategy]; exists {
+		return plugins
+	}
+	return s.strategies[PlacementStrategyEdgeFirst]
+}
+
+// selectNodes returns the nodes that may take a workload's replicas, best first
+func (s *Scheduler) selectNodes(state *SchedulingState, nodes map[string]*EdgeNode) []*EdgeNode {
+	var candidates []*EdgeNode
+	for _, node := range nodes {
+		if s.admits(state, node) {
+			candidates = append(candidates, node)
+		}
+	}
+
+	s.rank(state, candidates)
+
+	// The best node of each site is kept
+	if state.Workload.Placement.OneReplicaPerSite {
+		candidates = onePerSite(candidates)
+	}
+	return candidates
+}
+
+// rank sorts nodes best first by the score plugins of the workload's placement policy
+func (s *Scheduler) rank(state *SchedulingState, candidates []*EdgeNode) {
+	plugins := append(append([]ScorePlugin(nil), s.scores...), s.strategyPlugins(state.Workload.Placement.Strategy)...)
+	scores := make(map[string][]float64, len(candidates))
+	for _, node := range candidates {
+		nodeScores := make([]float64, len(plugins))
+		for i, plugin := range plugins {
+			nodeScores[i] = plugin.Score(state, node)
+		}
+		scores[node.ID] = nodeScores
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := scores[candidates[i].ID], scores[candidates[j].ID]
+		for k := range plugins {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+}
+
+// admits runs the filter plugins on a node
+func (s *Scheduler) admits(state *SchedulingState, node *EdgeNode) bool {
+	plugin, _ := s.rejection(state, node)
+	if plugin != nil {
+		s.logger.Debugf("Node %s filtered out for workload %s by %s", node.ID, state.Workload.Name, plugin.Name())
+		return false
+	}
+	return true
+}
+
+// rejection returns the first filter plugin that drops a node and why, or nil when every
+// filter keeps it
+func (s *Scheduler) rejection(state *SchedulingState, node *EdgeNode) (FilterPlugin, string) {
+	for _, plugin := range s.filters {
+		if explaining, ok := plugin.(ExplainingFilterPlugin); ok {
+			if reason := explaining.Explain(state, node); reason != "" {
+				return plugin, reason
+			}
+			continue
+		}
+		if !plugin.Filter(state, node) {
+			return plugin, plugin.Name()
+		}
+	}
+	return nil, ""
+}
+
+// newSchedulingState prepares a placement decision for a workload; callers must hold the
+// WorkloadManager lock
+func (co *CentralOrchestrator) newSchedulingState(workload *Workload) *SchedulingState {
+	return &SchedulingState{
+		Orchestrator: co,
+		Workload:     workload,
+		Committed:    committedResources(co.WorkloadManager.workloads),
+		Placed:       placedReplicas(co.WorkloadManager.workloads, workload.ID),
+		Request:      workloadRequests(workload),
+		Now:          co.schedulingNow(),
+	}
+}
+
+// schedulingNow returns the time placement decisions are made at: the capture time of the
+// fleet snapshot a replay runs against, or the current time
+func (co *CentralOrchestrator) schedulingNow() time.Time {
+	if !co.schedulingTime.IsZero() {
+		return co.schedulingTime
+	}
+	return time.Now()
+}
+
+// filterSchedulable drops offline nodes and nodes in unschedulable states
+func filterSchedulable(state *SchedulingState, node *EdgeNode) string {
+	return state.Orchestrator.unschedulableReason(node)
+}
+
+// filterConstraints drops nodes that do not match the workload's constraints or carry
+// taints it does not tolerate
+func filterConstraints(state *SchedulingState, node *EdgeNode) string {
+	return state.Orchestrator.workloadRejection(node, state.Workload, false)
+}
+
+// filterTenancy drops nodes dedicated to another tenant than the workload's
+func filterTenancy(state *SchedulingState, node *EdgeNode) string {
+	if tenant := nodeTenant(node); tenant != "" && tenant != workloadTenant(state.Workload) {
+		return "dedicated to tenant " + tenant
+	}
+	return ""
+}
+
+// filterResources drops nodes without allocatable capacity for a replica
+func filterResources(state *SchedulingState, node *EdgeNode) string {
+	if state.IgnoreCapacity {
+		return ""
+	}
+	if short := state.Orchestrator.shortResource(node, state.Committed[node.ID], state.Workload, 1); short != "" {
+		return "insufficient " + short
+	}
+	return ""
+}
+
+// scoreTaintToleration ranks nodes by how few PreferNoSchedule taints the workload does not
+// tolerate
+func scoreTaintToleration(state *SchedulingState, node *EdgeNode) float64 {
+	return -float64(untoleratedPreferNoSchedule(node, state.Workload))
+}
+
+// scoreAffinity sums the weights of the placement preferences a node matches
+func scoreAffinity(state *SchedulingState, node *EdgeNode) float64 {
+	score := 0.0
+	for _, preference := range state.Workload.Placement.Preferences {
+		if state.Orchestrator.nodeMatchesConstraints(node, []PlacementConstraint{preference.Terms}) {
+			score += float64(preference.Weight)
+		}
+	}
+	return score
+}
+
+// scoreEdgeNodes prefers edge nodes over cloud burst nodes
+func scoreEdgeNodes(state *SchedulingState, node *EdgeNode) float64 {
+	if isCloudNode(node) {
+		return 0
+	}
+	return 1
+}
+
+// scoreCloudNodes prefers cloud burst nodes over edge nodes
+func scoreCloudNodes(state *SchedulingState, node *EdgeNode) float64 {
+	return 1 - scoreEdgeNodes(state, node)
+}
+
+// scoreCloudPrice prefers the cheapest nodes; nodes of unknown price rank last
+func scoreCloudPrice(state *SchedulingState, node *EdgeNode) float64 {
+	price, known := cloudHourlyPrice(node)
+	if !known {
+		return -math.MaxFloat64
+	}
+	return -price
+}
+
+// scoreFewestReplicas prefers the nodes running the fewest replicas of other workloads
+func scoreFewestReplicas(state *SchedulingState, node *EdgeNode) float64 {
+	return -float64(state.Placed[node.ID])
+}
+
+// scoreUtilization prefers the least utilized nodes
+func scoreUtilization(state *SchedulingState, node *EdgeNode) float64 {
+	return -nodeUtilization(node)
+}
+
+// scoreHeadroom prefers the nodes with the most headroom left after placing the workload's
+// requests
+func scoreHeadroom(state *SchedulingState, node *EdgeNode) float64 {
+	return state.Orchestrator.headroomScore(node, state.Committed[node.ID], state.Request)
+}
+
+// scoreLatency prefers the nodes with the lowest measured round-trip time to the policy's
+// latency target; nodes without a recent measurement rank last
+func scoreLatency(state *SchedulingState, node *EdgeNode) float64 {
+	target := state.Workload.Placement.LatencyTarget
+	if target == "" {
+		target = LatencyTargetOrchestrator
+	}
+	latency, known := node.latencyTo(target, state.Now)
+	if !known {
+		return -math.MaxFloat64
+	}
+	return -latency
+}
+
+// scoreLinkQuality ranks the nodes whose link to the orchestrator is poor last, as a
+// lossy or jittery link makes a low round-trip time misleading
+func scoreLinkQuality(state *SchedulingState, node *EdgeNode) float64 {
+	if node.LinkQuality == LinkQualityPoor {
+		return -1
+	}
+	return 0
+}
+
+// ListSchedulerPlugins lists the scheduler's plugins in the order they run
+func (co *CentralOrchestrator) ListSchedulerPlugins(c *gin.Context) {
+	names := func(plugins []ScorePlugin) []string {
+		result := make([]string, 0, len(plugins))
+		for _, plugin := range plugins {
+			result = append(result, plugin.Name())
+		}
+		return result
+	}
+
+	filters := make([]string, 0, len(co.Scheduler.filters))
+	for _, plugin := range co.Scheduler.filters {
+		filters = append(filters, plugin.Name())
+	}
+	strategies := make(map[PlacementStrategy][]string, len(co.Scheduler.strategies))
+	for strategy, plugins := range co.Scheduler.strategies {
+		strategies[strategy] = names(plugins)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"filters":    filters,
+		"scores":     names(co.Scheduler.scores),
+		"strategies": strategies,
+	})
+}
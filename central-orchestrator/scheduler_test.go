@@ -0,0 +1,284 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newSchedulingTestOrchestrator returns an orchestrator with what scheduling passes need
+func newSchedulingTestOrchestrator() *CentralOrchestrator {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	return &CentralOrchestrator{
+		NodeManager:        NewNodeManager(logger),
+		WorkloadManager:    NewWorkloadManager(logger),
+		NodeStateManager:   NewNodeStateManager(logger),
+		ReservationManager: NewReservationManager(logger),
+		DatasetCatalog:     NewDatasetCatalog(logger),
+		Scheduler:          NewScheduler(logger),
+		TenantScheduler:    NewTenantScheduler(logger),
+		CloudProvisioner:   NewCloudProvisioner(logger),
+		AgentStreamHub:     NewAgentStreamHub(logger),
+		Logger:             logger,
+	}
+}
+
+// addSchedulingTestNode adds an online, active node with the given CPU capacity, 0 for
+// unknown
+func addSchedulingTestNode(co *CentralOrchestrator, id string, milliCPU int64) *EdgeNode {
+	node := &EdgeNode{ID: id, Name: id, Status: NodeStatusOnline, State: NodeStateActive, LastHeartbeat: time.Now()}
+	node.Resources.CPU.CapacityMillicores = milliCPU
+	co.NodeManager.nodes[id] = node
+	return node
+}
+
+// TestPlanReplicasSpreadsWithMaxPerNode checks that replicas spread evenly over the
+// selected nodes without exceeding max_replicas_per_node, and that the rest stay unplaced
+func TestPlanReplicasSpreadsWithMaxPerNode(t *testing.T) {
+	co := newSchedulingTestOrchestrator()
+	for _, id := range []string{"node-a", "node-b", "node-c"} {
+		addSchedulingTestNode(co, id, 0)
+	}
+
+	for _, test := range []struct {
+		replicas, maxPerNode, wantPlaced int32
+	}{
+		{replicas: 5, maxPerNode: 2, wantPlaced: 5},
+		{replicas: 7, maxPerNode: 2, wantPlaced: 6},
+		{replicas: 3, maxPerNode: 1, wantPlaced: 3},
+		{replicas: 4, maxPerNode: 0, wantPlaced: 4},
+	} {
+		workload := &Workload{ID: "w-1", Name: "w-1", Replicas: test.replicas,
+			Placement: PlacementPolicy{MaxReplicasPerNode: test.maxPerNode}}
+		co.WorkloadManager.workloads = map[string]*Workload{workload.ID: workload}
+
+		plan := co.planReplicas(workload)
+		var placed, most, fewest int32
+		fewest = test.replicas
+		for _, id := range []string{"node-a", "node-b", "node-c"} {
+			replicas := plan[id]
+			placed += replicas
+			if replicas > most {
+				most = replicas
+			}
+			if replicas < fewest {
+				fewest = replicas
+			}
+		}
+		if placed != test.wantPlaced {
+			t.Errorf("%d replicas with at most %d per node: placed %d, want %d", test.replicas, test.maxPerNode, placed, test.wantPlaced)
+		}
+		if test.maxPerNode > 0 && most > test.maxPerNode {
+			t.Errorf("%d replicas with at most %d per node: a node got %d", test.replicas, test.maxPerNode, most)
+		}
+		if most-fewest > 1 {
+			t.Errorf("%d replicas with at most %d per node: uneven plan %v", test.replicas, test.maxPerNode, plan)
+		}
+	}
+}
+
+// TestScheduleFairlyServesLowestShare checks that the tenant with the lowest dominant share
+// is served first, and that within a tenant more critical workloads go first
+func TestScheduleFairlyServesLowestShare(t *testing.T) {
+	co := newSchedulingTestOrchestrator()
+	// Room for three replicas of one CPU each
+	addSchedulingTestNode(co, "node-a", 3000)
+
+	now := time.Now()
+	newWorkload := func(id, tenant string, criticality int32, age time.Duration) *Workload {
+		workload := &Workload{ID: id, Name: id, Tenant: tenant, Replicas: 1, Criticality: criticality,
+			Status: WorkloadStatusPending, UpdatedAt: now.Add(-age)}
+		workload.Resources.Requests.CPU = "1"
+		co.WorkloadManager.workloads[id] = workload
+		return workload
+	}
+	running := newWorkload("a-running", "team-a", 0, time.Hour)
+	running.Status = WorkloadStatusRunning
+	running.Deployments = []WorkloadDeployment{{NodeID: "node-a", Status: WorkloadStatusRunning, Replicas: 1}}
+	// team-a waited longest, but already holds a third of the fleet
+	pendingA := newWorkload("a-pending", "team-a", 0, 3*time.Minute)
+	lowB := newWorkload("b-low", "team-b", 0, 2*time.Minute)
+	highB := newWorkload("b-high", "team-b", 10, time.Minute)
+
+	co.scheduleWorkloads()
+
+	// team-b goes first with its critical workload; both tenants then hold a third, and the
+	// tie goes to team-a, which fills the node
+	for _, test := range []struct {
+		workload *Workload
+		want     WorkloadStatus
+	}{
+		{highB, WorkloadStatusRunning},
+		{pendingA, WorkloadStatusRunning},
+		{lowB, WorkloadStatusPending},
+	} {
+		if test.workload.Status != test.want {
+			t.Errorf("Workload %s has status %s, want %s", test.workload.Name, test.workload.Status, test.want)
+		}
+	}
+}
+
+// TestSchedulerPipelineOrder checks that the first filter dropping a node is the one
+// reported, with later filters not consulted, and that the first score plugin telling two
+// nodes apart ranks them
+func TestSchedulerPipelineOrder(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	consulted := make(map[string][]string)
+	filter := func(name string, drops ...string) FilterPlugin {
+		return NewExplainingFilterPlugin(name, func(state *SchedulingState, node *EdgeNode) string {
+			consulted[node.ID] = append(consulted[node.ID], name)
+			for _, id := range drops {
+				if node.ID == id {
+					return name + " says no"
+				}
+			}
+			return ""
+		})
+	}
+	score := func(name string, scores map[string]float64) ScorePlugin {
+		return NewScorePlugin(name, func(state *SchedulingState, node *EdgeNode) float64 {
+			return scores[node.ID]
+		})
+	}
+	s := &Scheduler{
+		filters: []FilterPlugin{filter("first", "node-x"), filter("second", "node-x", "node-y")},
+		scores: []ScorePlugin{
+			score("coarse", map[string]float64{"node-1": 1, "node-2": 1, "node-3": 2}),
+			score("fine", map[string]float64{"node-1": 1, "node-2": 5, "node-3": 0}),
+		},
+		strategies: map[PlacementStrategy][]ScorePlugin{PlacementStrategyEdgeFirst: nil},
+		logger:     logger,
+	}
+	state := &SchedulingState{Workload: &Workload{ID: "w-1", Name: "w-1"}}
+
+	for _, test := range []struct {
+		node, plugin string
+		consulted    int
+	}{
+		{"node-x", "first", 1},
+		{"node-y", "second", 2},
+		{"node-1", "", 2},
+	} {
+		plugin, _ := s.rejection(state, &EdgeNode{ID: test.node})
+		name := ""
+		if plugin != nil {
+			name = plugin.Name()
+		}
+		if name != test.plugin {
+			t.Errorf("Node %s rejected by %q, want %q", test.node, name, test.plugin)
+		}
+		if len(consulted[test.node]) != test.consulted {
+			t.Errorf("Node %s consulted filters %v, want the first %d", test.node, consulted[test.node], test.consulted)
+		}
+	}
+
+	nodes := map[string]*EdgeNode{}
+	for _, id := range []string{"node-1", "node-2", "node-3", "node-x", "node-y"} {
+		nodes[id] = &EdgeNode{ID: id}
+	}
+	var order []string
+	for _, node := range s.selectNodes(state, nodes) {
+		order = append(order, node.ID)
+	}
+	want := []string{"node-3", "node-2", "node-1"}
+	if len(order) != len(want) {
+		t.Fatalf("Selected nodes %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Selected nodes %v, want %v", order, want)
+		}
+	}
+}
+
+// TestTaintRejection checks that NoSchedule and NoExecute taints keep new replicas off a
+// node unless tolerated, that only NoExecute ones evict running replicas, and that
+// PreferNoSchedule ones only rank the node lower
+func TestTaintRejection(t *testing.T) {
+	co := newSchedulingTestOrchestrator()
+	taint := func(effect TaintEffect) *EdgeNode {
+		return &EdgeNode{ID: "node-" + string(effect), Taints: []NodeTaint{{Key: "gpu", Value: "true", Effect: effect}}}
+	}
+	plain := &Workload{ID: "w-plain", Name: "w-plain"}
+	tolerant := &Workload{ID: "w-tolerant", Name: "w-tolerant", Placement: PlacementPolicy{
+		Tolerations: []Toleration{{Key: "gpu", Operator: TolerationOpEqual, Value: "true"}},
+	}}
+
+	for _, test := range []struct {
+		node     *EdgeNode
+		workload *Workload
+		existing bool
+		rejected bool
+	}{
+		{taint(TaintEffectNoSchedule), plain, false, true},
+		{taint(TaintEffectNoSchedule), plain, true, false},
+		{taint(TaintEffectNoSchedule), tolerant, false, false},
+		{taint(TaintEffectNoExecute), plain, false, true},
+		{taint(TaintEffectNoExecute), plain, true, true},
+		{taint(TaintEffectNoExecute), tolerant, true, false},
+		{taint(TaintEffectPreferNoSchedule), plain, false, false},
+	} {
+		reason := co.workloadRejection(test.node, test.workload, test.existing)
+		if (reason != "") != test.rejected {
+			t.Errorf("%s on %s (existing %v): rejection %q, want rejected %v",
+				test.workload.Name, test.node.ID, test.existing, reason, test.rejected)
+		}
+	}
+
+	state := &SchedulingState{Orchestrator: co, Workload: plain}
+	if preferred, clean := scoreTaintToleration(state, taint(TaintEffectPreferNoSchedule)), scoreTaintToleration(state, &EdgeNode{ID: "clean"}); preferred >= clean {
+		t.Errorf("Node with an untolerated PreferNoSchedule taint scored %v, not below %v", preferred, clean)
+	}
+}
+
+// TestAffinityRejection checks that workload affinity needs a selected workload of the same
+// tenant on the node, and that anti-affinity, in either direction, keeps replicas apart
+func TestAffinityRejection(t *testing.T) {
+	co := newSchedulingTestOrchestrator()
+	place := func(id, tenant, nodeID string, labels map[string]string, placement PlacementPolicy) *Workload {
+		workload := &Workload{ID: id, Name: id, Tenant: tenant, Labels: labels, Placement: placement, Status: WorkloadStatusRunning}
+		if nodeID != "" {
+			workload.Deployments = []WorkloadDeployment{{NodeID: nodeID, Status: WorkloadStatusRunning, Replicas: 1}}
+		}
+		co.WorkloadManager.workloads[id] = workload
+		return workload
+	}
+	db := map[string]string{"app": "db"}
+	place("db", "team-a", "node-db", db, PlacementPolicy{})
+	place("other-db", "team-b", "node-other", db, PlacementPolicy{})
+	place("loner", "team-a", "node-loner", map[string]string{"app": "loner"}, PlacementPolicy{
+		WorkloadAntiAffinity: []WorkloadAffinityTerm{{WorkloadSelector: map[string]string{"app": "web"}}},
+	})
+
+	selectDB := []WorkloadAffinityTerm{{WorkloadSelector: db}}
+	near := place("near", "team-a", "", nil, PlacementPolicy{WorkloadAffinity: selectDB})
+	apart := place("apart", "team-a", "", nil, PlacementPolicy{WorkloadAntiAffinity: selectDB})
+	web := place("web", "team-a", "", map[string]string{"app": "web"}, PlacementPolicy{})
+
+	for _, test := range []struct {
+		workload *Workload
+		node     string
+		rejected bool
+	}{
+		{near, "node-db", false},
+		{near, "node-empty", true},
+		// Affinity only selects workloads of the same tenant
+		{near, "node-other", true},
+		{apart, "node-db", true},
+		{apart, "node-empty", false},
+		{apart, "node-other", false},
+		// The anti-affinity of the workload already on the node applies too
+		{web, "node-loner", true},
+		{web, "node-db", false},
+	} {
+		reason := co.workloadRejection(&EdgeNode{ID: test.node}, test.workload, false)
+		if (reason != "") != test.rejected {
+			t.Errorf("%s on %s: rejection %q, want rejected %v", test.workload.Name, test.node, reason, test.rejected)
+		}
+	}
+}
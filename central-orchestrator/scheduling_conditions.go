@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons of a workload's scheduling condition
+const (
+	SchedulingReasonUnschedulable      = "Unschedulable"
+	SchedulingReasonPartiallyScheduled = "PartiallyScheduled"
+)
+
+// maxFilteredNodeIDs caps the node IDs listed for each reason nodes were filtered for
+const maxFilteredNodeIDs = 5
+
+// SchedulingCondition records why the scheduler could not place all of a workload's
+// replicas, so users can tell what to change without reading orchestrator logs
+type SchedulingCondition struct {
+	Reason          string `json:"reason"`
+	Message         string `json:"message"`
+	PlacedReplicas  int32  `json:"placed_replicas"`
+	DesiredReplicas int32  `json:"desired_replicas"`
+	// Nodes considered, and the ones left out grouped by reason, most frequent first
+	Nodes    int             `json:"nodes"`
+	Filtered []FilteredNodes `json:"filtered,omitempty"`
+	// When the workload first failed to schedule for this reason
+	Since     time.Time `json:"since"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FilteredNodes counts the nodes left out for one reason
+type FilteredNodes struct {
+	Reason string `json:"reason"`
+	// Filter plugin that dropped the nodes, or "spreading" for the replica spreading limits
+	Filter  string   `json:"filter"`
+	Count   int      `json:"count"`
+	NodeIDs []string `json:"node_ids"`
+}
+
+// diagnoseScheduling explains why nodes cannot take another replica of a workload. Each
+// node counts once, for the first filter that drops it; nodes every filter keeps count
+// for the spreading limit or resource they ran out of. Callers must hold the
+// WorkloadManager lock.
+func (co *CentralOrchestrator) diagnoseScheduling(workload *Workload) (int, []FilteredNodes) {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	maxPerNode := workload.Placement.MaxReplicasPerNode
+	if workload.Placement.OneReplicaPerSite || workload.antiAffineToItself() {
+		maxPerNode = 1
+	}
+	replicas := make(map[string]int32)
+	sites := make(map[string]bool)
+	for _, deployment := range workload.Deployments {
+		if !deployment.placed() {
+			continue
+		}
+		replicas[deployment.NodeID] += deployment.Replicas
+		if node, exists := co.NodeManager.nodes[deployment.NodeID]; exists {
+			sites[node.SiteID] = true
+		}
+	}
+
+	state := co.newSchedulingState(workload)
+	groups := make(map[string]*FilteredNodes)
+	for _, node := range co.NodeManager.nodes {
+		filter, reason := "", ""
+		if plugin, why := co.Scheduler.rejection(state, node); plugin != nil {
+			filter, reason = plugin.Name(), why
+		} else {
+			switch {
+			case maxPerNode > 0 && replicas[node.ID] >= maxPerNode:
+				filter, reason = "spreading", "max replicas per node reached"
+			case workload.Placement.OneReplicaPerSite && sites[node.SiteID] && replicas[node.ID] == 0:
+				filter, reason = "spreading", "site already runs a replica"
+			default:
+				// Planning commits replicas one at a time, so a node that passed the
+				// resources filter can still run out before the workload is placed
+				if short := co.shortResource(node, state.Committed[node.ID], workload, 1); short != "" {
+					filter, reason = "resources", "insufficient "+short
+				}
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		key := filter + "\x00" + reason
+		group, exists := groups[key]
+		if !exists {
+			group = &FilteredNodes{Reason: reason, Filter: filter, NodeIDs: []string{}}
+			groups[key] = group
+		}
+		group.Count++
+		group.NodeIDs = append(group.NodeIDs, node.ID)
+	}
+
+	filtered := make([]FilteredNodes, 0, len(groups))
+	for _, group := range groups {
+		sort.Strings(group.NodeIDs)
+		if len(group.NodeIDs) > maxFilteredNodeIDs {
+			group.NodeIDs = group.NodeIDs[:maxFilteredNodeIDs]
+		}
+		filtered = append(filtered, *group)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].Count != filtered[j].Count {
+			return filtered[i].Count > filtered[j].Count
+		}
+		return filtered[i].Reason < filtered[j].Reason
+	})
+	return len(co.NodeManager.nodes), filtered
+}
+
+// setSchedulingCondition records on a workload why not all of its replicas could be placed
+// and returns the nodes summary, such as "3 nodes filtered by region constraint, 2 by
+// insufficient memory"; callers must hold the WorkloadManager lock
+func (co *CentralOrchestrator) setSchedulingCondition(workload *Workload, placed, desired int32, now time.Time) string {
+	nodes, filtered := co.diagnoseScheduling(workload)
+
+	reason := SchedulingReasonPartiallyScheduled
+	if placed == 0 {
+		reason = SchedulingReasonUnschedulable
+	}
+	summary := ""
+	switch {
+	case nodes == 0:
+		summary = "no nodes are registered"
+	case len(filtered) > 0:
+		parts := make([]string, len(filtered))
+		for i, group := range filtered {
+			if i == 0 {
+				noun := "nodes"
+				if group.Count == 1 {
+					noun = "node"
+				}
+				parts[i] = fmt.Sprintf("%d %s filtered by %s", group.Count, noun, group.Reason)
+			} else {
+				parts[i] = fmt.Sprintf("%d by %s", group.Count, group.Reason)
+			}
+		}
+		summary = strings.Join(parts, ", ")
+	default:
+		summary = "no nodes were filtered out"
+	}
+	message := fmt.Sprintf("%d of %d replicas placed; %s", placed, desired, summary)
+
+	since := now
+	if previous := workload.Scheduling; previous != nil && previous.Reason == reason {
+		since = previous.Since
+	}
+	workload.Scheduling = &SchedulingCondition{
+		Reason:          reason,
+		Message:         message,
+		PlacedReplicas:  placed,
+		DesiredReplicas: desired,
+		Nodes:           nodes,
+		Filtered:        filtered,
+		Since:           since,
+		UpdatedAt:       now,
+	}
+	return summary
+}
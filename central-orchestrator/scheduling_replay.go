@@ -0,0 +1,701 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxFleetSnapshots caps the fleet snapshots kept for replay; the oldest are dropped first
+const MaxFleetSnapshots = 20
+
+// FleetSnapshot is the state scheduling decisions depend on, captured so they can be
+// replayed offline: the nodes, the workloads with their placements, and the node states,
+// resource reservations, overcommit policies and dataset catalog the filters consult.
+// Workload environment variables are left out, as they play no part in placement.
+type FleetSnapshot struct {
+	ID                 string                 `json:"id"`
+	Name               string                 `json:"name,omitempty"`
+	CapturedAt         time.Time              `json:"captured_at"`
+	Nodes              []*EdgeNode            `json:"nodes"`
+	Workloads          []*Workload            `json:"workloads"`
+	NodeStates         []*NodeStateDefinition `json:"node_states"`
+	Reservations       []*ResourceReservation `json:"reservations"`
+	OvercommitPolicies []*OvercommitPolicy    `json:"overcommit_policies"`
+	MaxOvercommitRatio float64                `json:"max_overcommit_ratio"`
+	Datasets           []*Dataset             `json:"datasets"`
+}
+
+// FleetSnapshotSummary describes a fleet snapshot without its contents
+type FleetSnapshotSummary struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name,omitempty"`
+	CapturedAt       time.Time `json:"captured_at"`
+	Nodes            int       `json:"nodes"`
+	Workloads        int       `json:"workloads"`
+	PendingWorkloads int       `json:"pending_workloads"`
+}
+
+// FleetSnapshotRequest names a fleet snapshot being captured
+type FleetSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// SchedulerPolicy modifies the scheduler a replay places workloads with. The zero policy
+// is the scheduler as built, with its custom plugins.
+type SchedulerPolicy struct {
+	// Filter and score plugins left out, by name, such as "utilization"
+	DisabledPlugins []string `json:"disabled_plugins,omitempty"`
+	// Score plugins of placement strategies, by name and in order, replacing their own
+	Strategies map[PlacementStrategy][]string `json:"strategies,omitempty"`
+	// Placement strategy every workload is placed with instead of its own
+	Strategy PlacementStrategy `json:"strategy,omitempty"`
+	// Replaces the snapshot's maximum overcommit ratio
+	MaxOvercommitRatio float64 `json:"max_overcommit_ratio,omitempty"`
+}
+
+// SchedulingReplayRequest replays a stored snapshot, or one given inline, under a baseline
+// and a candidate policy
+type SchedulingReplayRequest struct {
+	SnapshotID string         `json:"snapshot_id"`
+	Snapshot   *FleetSnapshot `json:"snapshot"`
+	// Place every pending and running workload from scratch rather than only the pending
+	// ones around the placements already made
+	Reschedule bool            `json:"reschedule"`
+	Baseline   SchedulerPolicy `json:"baseline"`
+	Candidate  SchedulerPolicy `json:"candidate"`
+}
+
+// ReplayedPlacement is where a replay placed one workload's replicas
+type ReplayedPlacement struct {
+	WorkloadID string           `json:"workload_id"`
+	Name       string           `json:"name"`
+	Tenant     string           `json:"tenant,omitempty"`
+	Desired    int32            `json:"desired_replicas"`
+	Placed     int32            `json:"placed_replicas"`
+	Nodes      map[string]int32 `json:"nodes"`
+	// Why not every replica was placed
+	Message string `json:"message,omitempty"`
+}
+
+// ReplayOutcome is how the workloads of a replay were placed under one policy
+type ReplayOutcome struct {
+	Policy                 SchedulerPolicy `json:"policy"`
+	Workloads              int             `json:"workloads"`
+	ScheduledWorkloads     int             `json:"scheduled_workloads"`
+	PartialWorkloads       int             `json:"partial_workloads"`
+	UnschedulableWorkloads int             `json:"unschedulable_workloads"`
+	PlacedReplicas         int32           `json:"placed_replicas"`
+	UnplacedReplicas       int32           `json:"unplaced_replicas"`
+	// Nodes running any replica of the fleet, and the most replicas on any one of them
+	NodesUsed          int                  `json:"nodes_used"`
+	MaxReplicasPerNode int32                `json:"max_replicas_per_node"`
+	Placements         []*ReplayedPlacement `json:"placements"`
+}
+
+// ReplayedPlacementChange is a workload placed differently by the candidate policy
+type ReplayedPlacementChange struct {
+	WorkloadID      string           `json:"workload_id"`
+	Name            string           `json:"name"`
+	BaselinePlaced  int32            `json:"baseline_placed_replicas"`
+	CandidatePlaced int32            `json:"candidate_placed_replicas"`
+	BaselineNodes   map[string]int32 `json:"baseline_nodes"`
+	CandidateNodes  map[string]int32 `json:"candidate_nodes"`
+	// Candidate replicas on nodes the baseline did not put them on
+	MovedReplicas int32 `json:"moved_replicas"`
+}
+
+// SchedulingReplayReport compares the placements of a snapshot's workloads under two
+// policies
+type SchedulingReplayReport struct {
+	SnapshotID string                     `json:"snapshot_id,omitempty"`
+	CapturedAt time.Time                  `json:"captured_at"`
+	Reschedule bool                       `json:"reschedule"`
+	Baseline   *ReplayOutcome             `json:"baseline"`
+	Candidate  *ReplayOutcome             `json:"candidate"`
+	Changes    []*ReplayedPlacementChange `json:"changes"`
+}
+
+// FleetSnapshotManager keeps the fleet snapshots captured for replay
+type FleetSnapshotManager struct {
+	snapshots map[string]*FleetSnapshot
+	// Snapshot IDs, oldest first
+	order  []string
+	mutex  sync.RWMutex
+	logger *logrus.Logger
+}
+
+// NewFleetSnapshotManager creates an empty fleet snapshot manager
+func NewFleetSnapshotManager(logger *logrus.Logger) *FleetSnapshotManager {
+	return &FleetSnapshotManager{
+		snapshots: make(map[string]*FleetSnapshot),
+		logger:    logger,
+	}
+}
+
+// add keeps a snapshot, dropping the oldest ones past MaxFleetSnapshots
+func (fm *FleetSnapshotManager) add(snapshot *FleetSnapshot) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	fm.snapshots[snapshot.ID] = snapshot
+	fm.order = append(fm.order, snapshot.ID)
+	for len(fm.order) > MaxFleetSnapshots {
+		delete(fm.snapshots, fm.order[0])
+		fm.order = fm.order[1:]
+	}
+}
+
+// get returns a stored snapshot; snapshots are never modified once captured
+func (fm *FleetSnapshotManager) get(id string) (*FleetSnapshot, bool) {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	snapshot, exists := fm.snapshots[id]
+	return snapshot, exists
+}
+
+// captureFleetSnapshot copies the state scheduling depends on
+func (co *CentralOrchestrator) captureFleetSnapshot(name string) (*FleetSnapshot, error) {
+	live := &FleetSnapshot{
+		ID:         generateID(),
+		Name:       name,
+		CapturedAt: time.Now(),
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	for _, workload := range co.WorkloadManager.workloads {
+		live.Workloads = append(live.Workloads, workload)
+	}
+	co.NodeManager.mutex.RLock()
+	for _, node := range co.NodeManager.nodes {
+		live.Nodes = append(live.Nodes, node)
+	}
+	co.NodeStateManager.mutex.RLock()
+	for _, definition := range co.NodeStateManager.states {
+		live.NodeStates = append(live.NodeStates, definition)
+	}
+	co.ReservationManager.mutex.RLock()
+	for _, reservation := range co.ReservationManager.reservations {
+		live.Reservations = append(live.Reservations, reservation)
+	}
+	for _, policy := range co.ReservationManager.overcommits {
+		live.OvercommitPolicies = append(live.OvercommitPolicies, policy)
+	}
+	live.MaxOvercommitRatio = co.ReservationManager.maxOvercommit
+	co.DatasetCatalog.mutex.RLock()
+	for _, dataset := range co.DatasetCatalog.datasets {
+		live.Datasets = append(live.Datasets, dataset)
+	}
+
+	// Encoding copies everything before the locks are released
+	data, err := json.Marshal(live)
+	co.DatasetCatalog.mutex.RUnlock()
+	co.ReservationManager.mutex.RUnlock()
+	co.NodeStateManager.mutex.RUnlock()
+	co.NodeManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &FleetSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	for _, workload := range snapshot.Workloads {
+		workload.Environment = nil
+	}
+	sort.Slice(snapshot.Nodes, func(i, j int) bool { return snapshot.Nodes[i].ID < snapshot.Nodes[j].ID })
+	sort.Slice(snapshot.Workloads, func(i, j int) bool { return snapshot.Workloads[i].ID < snapshot.Workloads[j].ID })
+	sort.Slice(snapshot.NodeStates, func(i, j int) bool { return snapshot.NodeStates[i].Name < snapshot.NodeStates[j].Name })
+	sort.Slice(snapshot.Reservations, func(i, j int) bool { return snapshot.Reservations[i].ID < snapshot.Reservations[j].ID })
+	sort.Slice(snapshot.OvercommitPolicies, func(i, j int) bool {
+		return snapshot.OvercommitPolicies[i].ID < snapshot.OvercommitPolicies[j].ID
+	})
+	sort.Slice(snapshot.Datasets, func(i, j int) bool { return snapshot.Datasets[i].Name < snapshot.Datasets[j].Name })
+	return snapshot, nil
+}
+
+// visibleFleetSnapshot returns the part of a snapshot the caller may see: for callers
+// limited to tenants, a copy with only their workloads and the nodes they may see
+func visibleFleetSnapshot(c *gin.Context, snapshot *FleetSnapshot) *FleetSnapshot {
+	if _, limited := c.Get(ContextKeyTenants); !limited {
+		return snapshot
+	}
+	visible := *snapshot
+	visible.Nodes = make([]*EdgeNode, 0, len(snapshot.Nodes))
+	for _, node := range snapshot.Nodes {
+		if nodeVisible(c, node) {
+			visible.Nodes = append(visible.Nodes, node)
+		}
+	}
+	visible.Workloads = make([]*Workload, 0, len(snapshot.Workloads))
+	for _, workload := range snapshot.Workloads {
+		if tenantAllowed(c, workloadTenant(workload)) {
+			visible.Workloads = append(visible.Workloads, workload)
+		}
+	}
+	return &visible
+}
+
+// summary describes a snapshot
+func (snapshot *FleetSnapshot) summary() FleetSnapshotSummary {
+	summary := FleetSnapshotSummary{
+		ID:         snapshot.ID,
+		Name:       snapshot.Name,
+		CapturedAt: snapshot.CapturedAt,
+		Nodes:      len(snapshot.Nodes),
+		Workloads:  len(snapshot.Workloads),
+	}
+	for _, workload := range snapshot.Workloads {
+		if workload.Status == WorkloadStatusPending {
+			summary.PendingWorkloads++
+		}
+	}
+	return summary
+}
+
+// scheduler builds the scheduler a policy describes
+func (policy SchedulerPolicy) scheduler(logger *logrus.Logger) (*Scheduler, error) {
+	s := NewScheduler(logger)
+
+	if policy.Strategy != "" {
+		if _, exists := s.strategies[policy.Strategy]; !exists {
+			return nil, fmt.Errorf("unknown placement strategy %q", policy.Strategy)
+		}
+	}
+	if policy.MaxOvercommitRatio != 0 && policy.MaxOvercommitRatio < 1 {
+		return nil, fmt.Errorf("max_overcommit_ratio must be at least 1")
+	}
+
+	if len(policy.Strategies) > 0 {
+		known := make(map[string]ScorePlugin)
+		for _, plugin := range s.scores {
+			known[plugin.Name()] = plugin
+		}
+		for _, plugins := range s.strategies {
+			for _, plugin := range plugins {
+				known[plugin.Name()] = plugin
+			}
+		}
+		for strategy, names := range policy.Strategies {
+			if _, exists := s.strategies[strategy]; !exists {
+				return nil, fmt.Errorf("unknown placement strategy %q", strategy)
+			}
+			plugins := make([]ScorePlugin, 0, len(names))
+			for _, name := range names {
+				plugin, exists := known[name]
+				if !exists {
+					return nil, fmt.Errorf("unknown score plugin %q", name)
+				}
+				plugins = append(plugins, plugin)
+			}
+			s.strategies[strategy] = plugins
+		}
+	}
+
+	for _, name := range policy.DisabledPlugins {
+		removed := false
+		filters := s.filters[:0]
+		for _, plugin := range s.filters {
+			if plugin.Name() == name {
+				removed = true
+				continue
+			}
+			filters = append(filters, plugin)
+		}
+		s.filters = filters
+
+		without := func(plugins []ScorePlugin) []ScorePlugin {
+			kept := make([]ScorePlugin, 0, len(plugins))
+			for _, plugin := range plugins {
+				if plugin.Name() == name {
+					removed = true
+					continue
+				}
+				kept = append(kept, plugin)
+			}
+			return kept
+		}
+		s.scores = without(s.scores)
+		for strategy, plugins := range s.strategies {
+			s.strategies[strategy] = without(plugins)
+		}
+		if !removed {
+			return nil, fmt.Errorf("unknown plugin %q", name)
+		}
+	}
+	return s, nil
+}
+
+// sandbox builds an orchestrator holding a copy of the snapshot, which placements made
+// through it modify without touching live state. Only the state a snapshot captures is
+// set up; plugins reaching for anything else fail the replay.
+func (snapshot *FleetSnapshot) sandbox(policy SchedulerPolicy, scheduler *Scheduler, logger *logrus.Logger) (*CentralOrchestrator, error) {
+	// Each replay starts from its own copy
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	copied := &FleetSnapshot{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		return nil, err
+	}
+
+	co := &CentralOrchestrator{
+		NodeManager:        NewNodeManager(logger),
+		WorkloadManager:    NewWorkloadManager(logger),
+		NodeStateManager:   NewNodeStateManager(logger),
+		ReservationManager: NewReservationManager(logger),
+		DatasetCatalog:     NewDatasetCatalog(logger),
+		Scheduler:          scheduler,
+		Logger:             logger,
+		schedulingTime:     snapshot.CapturedAt,
+	}
+	for _, node := range copied.Nodes {
+		co.NodeManager.nodes[node.ID] = node
+	}
+	for _, workload := range copied.Workloads {
+		if policy.Strategy != "" {
+			workload.Placement.Strategy = policy.Strategy
+		}
+		co.WorkloadManager.workloads[workload.ID] = workload
+	}
+	// Snapshots taken without node states keep the built-in active state
+	for _, definition := range copied.NodeStates {
+		co.NodeStateManager.states[definition.Name] = definition
+	}
+	for _, reservation := range copied.Reservations {
+		co.ReservationManager.reservations[reservation.ID] = reservation
+	}
+	for _, overcommit := range copied.OvercommitPolicies {
+		co.ReservationManager.overcommits[overcommit.ID] = overcommit
+	}
+	if copied.MaxOvercommitRatio >= 1 {
+		co.ReservationManager.maxOvercommit = copied.MaxOvercommitRatio
+	}
+	if policy.MaxOvercommitRatio != 0 {
+		co.ReservationManager.maxOvercommit = policy.MaxOvercommitRatio
+	}
+	for _, dataset := range copied.Datasets {
+		co.DatasetCatalog.datasets[dataset.Name] = dataset
+	}
+	return co, nil
+}
+
+// replayQueue returns the workloads a replay places, most critical and then oldest first.
+// When rescheduling, pending and running workloads lose their placements first.
+// Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) replayQueue(reschedule bool) []*Workload {
+	var queue []*Workload
+	for _, workload := range co.WorkloadManager.workloads {
+		switch {
+		case workload.Status == WorkloadStatusPending:
+		case reschedule && workload.Status == WorkloadStatusRunning:
+		default:
+			continue
+		}
+		if reschedule {
+			// Completed job runs stay, as placement counts them
+			kept := workload.Deployments[:0]
+			for _, deployment := range workload.Deployments {
+				if !deployment.placed() {
+					kept = append(kept, deployment)
+				}
+			}
+			workload.Deployments = kept
+			workload.Status = WorkloadStatusPending
+		}
+		workload.Scheduling = nil
+		queue = append(queue, workload)
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := queue[i], queue[j]
+		if a.Criticality != b.Criticality {
+			return a.Criticality > b.Criticality
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return queue
+}
+
+// replayPolicy places a snapshot's workloads under one policy. Tenant fair share and
+// quotas are not replayed, so workloads are placed in order of criticality and age alone.
+func replayPolicy(snapshot *FleetSnapshot, policy SchedulerPolicy, reschedule bool, logger *logrus.Logger) (outcome *ReplayOutcome, err error) {
+	scheduler, err := policy.scheduler(logger)
+	if err != nil {
+		return nil, err
+	}
+	co, err := snapshot.sandbox(policy, scheduler, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// A custom plugin may reach for state the sandbox does not hold
+	defer func() {
+		if r := recover(); r != nil {
+			outcome, err = nil, fmt.Errorf("replay failed: %v", r)
+		}
+	}()
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	queue := co.replayQueue(reschedule)
+	replayed := append([]*Workload(nil), queue...)
+	messages := make(map[string]string)
+	for len(queue) > 0 {
+		workload := queue[0]
+		queue = queue[1:]
+
+		// Gang members are placed as one unit, like on the live scheduler
+		members := []*Workload{workload}
+		if gang := workload.Placement.Gang; gang != nil && gang.Group != "" {
+			key := gangKey(workload)
+			remaining := queue[:0]
+			for _, queued := range queue {
+				if queued.Placement.Gang != nil && gangKey(queued) == key {
+					members = append(members, queued)
+				} else {
+					remaining = append(remaining, queued)
+				}
+			}
+			queue = remaining
+		}
+
+		if workload.Placement.Gang != nil {
+			if err := co.scheduleGang(members); err != nil {
+				for _, member := range members {
+					messages[member.ID] = err.Error()
+				}
+			}
+		} else if err := co.scheduleWorkload(workload); err != nil {
+			messages[workload.ID] = err.Error()
+		}
+	}
+
+	outcome = &ReplayOutcome{
+		Policy:     policy,
+		Workloads:  len(replayed),
+		Placements: make([]*ReplayedPlacement, 0, len(replayed)),
+	}
+	for _, workload := range replayed {
+		placement := &ReplayedPlacement{
+			WorkloadID: workload.ID,
+			Name:       workload.Name,
+			Tenant:     workload.Tenant,
+			Desired:    expectedReplicas(workload),
+			Placed:     workload.scheduledReplicas(),
+			Nodes:      make(map[string]int32),
+			Message:    messages[workload.ID],
+		}
+		if workload.Scheduling != nil {
+			placement.Message = workload.Scheduling.Message
+		}
+		for _, deployment := range workload.Deployments {
+			if deployment.placed() && deployment.Replicas > 0 {
+				placement.Nodes[deployment.NodeID] += deployment.Replicas
+			}
+		}
+
+		switch {
+		case placement.Placed >= placement.Desired:
+			outcome.ScheduledWorkloads++
+			placement.Message = ""
+		case placement.Placed > 0:
+			outcome.PartialWorkloads++
+			outcome.UnplacedReplicas += placement.Desired - placement.Placed
+		default:
+			outcome.UnschedulableWorkloads++
+			outcome.UnplacedReplicas += placement.Desired
+		}
+		outcome.PlacedReplicas += placement.Placed
+		outcome.Placements = append(outcome.Placements, placement)
+	}
+	sort.Slice(outcome.Placements, func(i, j int) bool {
+		return outcome.Placements[i].WorkloadID < outcome.Placements[j].WorkloadID
+	})
+
+	// Load is counted over the whole fleet, including the workloads not replayed
+	perNode := placedReplicas(co.WorkloadManager.workloads, "")
+	for _, replicas := range perNode {
+		if replicas == 0 {
+			continue
+		}
+		outcome.NodesUsed++
+		if replicas > outcome.MaxReplicasPerNode {
+			outcome.MaxReplicasPerNode = replicas
+		}
+	}
+	return outcome, nil
+}
+
+// replayScheduling replays a snapshot under the baseline and candidate policies and
+// compares where each placed the workloads
+func replayScheduling(snapshot *FleetSnapshot, req SchedulingReplayRequest) (*SchedulingReplayReport, error) {
+	// Replays place hundreds of workloads; their scheduling logs would drown the
+	// orchestrator's own
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	baseline, err := replayPolicy(snapshot, req.Baseline, req.Reschedule, logger)
+	if err != nil {
+		return nil, fmt.Errorf("baseline: %v", err)
+	}
+	candidate, err := replayPolicy(snapshot, req.Candidate, req.Reschedule, logger)
+	if err != nil {
+		return nil, fmt.Errorf("candidate: %v", err)
+	}
+
+	report := &SchedulingReplayReport{
+		SnapshotID: snapshot.ID,
+		CapturedAt: snapshot.CapturedAt,
+		Reschedule: req.Reschedule,
+		Baseline:   baseline,
+		Candidate:  candidate,
+		Changes:    make([]*ReplayedPlacementChange, 0),
+	}
+	// Both replays place the same workloads, so their placements line up
+	for i, before := range baseline.Placements {
+		after := candidate.Placements[i]
+		var moved int32
+		for nodeID, replicas := range after.Nodes {
+			if extra := replicas - before.Nodes[nodeID]; extra > 0 {
+				moved += extra
+			}
+		}
+		if moved == 0 && before.Placed == after.Placed {
+			continue
+		}
+		report.Changes = append(report.Changes, &ReplayedPlacementChange{
+			WorkloadID:      before.WorkloadID,
+			Name:            before.Name,
+			BaselinePlaced:  before.Placed,
+			CandidatePlaced: after.Placed,
+			BaselineNodes:   before.Nodes,
+			CandidateNodes:  after.Nodes,
+			MovedReplicas:   moved,
+		})
+	}
+	return report, nil
+}
+
+// CaptureFleetSnapshot captures the fleet's scheduling state for replay
+func (co *CentralOrchestrator) CaptureFleetSnapshot(c *gin.Context) {
+	var req FleetSnapshotRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	snapshot, err := co.captureFleetSnapshot(req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	co.FleetSnapshots.add(snapshot)
+	co.AuditLog.RecordRequest(c, "fleet-snapshot.capture", snapshot.ID, map[string]string{"name": snapshot.Name})
+	co.Logger.Infof("Captured fleet snapshot %s with %d nodes and %d workloads", snapshot.ID, len(snapshot.Nodes), len(snapshot.Workloads))
+
+	c.JSON(http.StatusCreated, gin.H{"snapshot": snapshot.summary()})
+}
+
+// ListFleetSnapshots lists the stored fleet snapshots, newest first
+func (co *CentralOrchestrator) ListFleetSnapshots(c *gin.Context) {
+	fm := co.FleetSnapshots
+	fm.mutex.RLock()
+	summaries := make([]FleetSnapshotSummary, 0, len(fm.order))
+	for i := len(fm.order) - 1; i >= 0; i-- {
+		summaries = append(summaries, visibleFleetSnapshot(c, fm.snapshots[fm.order[i]]).summary())
+	}
+	fm.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"snapshots": summaries})
+}
+
+// GetFleetSnapshot returns a fleet snapshot in full, the form replays take inline and
+// the replay-scheduling command reads from a file. Callers limited to tenants get their
+// part of it.
+func (co *CentralOrchestrator) GetFleetSnapshot(c *gin.Context) {
+	snapshot, exists := co.FleetSnapshots.get(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fleet snapshot not found"})
+		return
+	}
+	c.JSON(http.StatusOK, visibleFleetSnapshot(c, snapshot))
+}
+
+// DeleteFleetSnapshot removes a stored fleet snapshot
+func (co *CentralOrchestrator) DeleteFleetSnapshot(c *gin.Context) {
+	id := c.Param("id")
+
+	fm := co.FleetSnapshots
+	fm.mutex.Lock()
+	if _, exists := fm.snapshots[id]; !exists {
+		fm.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fleet snapshot not found"})
+		return
+	}
+	delete(fm.snapshots, id)
+	for i, stored := range fm.order {
+		if stored == id {
+			fm.order = append(fm.order[:i], fm.order[i+1:]...)
+			break
+		}
+	}
+	fm.mutex.Unlock()
+
+	co.AuditLog.RecordRequest(c, "fleet-snapshot.delete", id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Fleet snapshot deleted"})
+}
+
+// ReplayScheduling places a snapshot's workloads under a baseline and a candidate
+// scheduler policy and reports where their placements differ. Live state is never
+// modified.
+func (co *CentralOrchestrator) ReplayScheduling(c *gin.Context) {
+	var req SchedulingReplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot := req.Snapshot
+	switch {
+	case req.SnapshotID != "" && snapshot != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "snapshot_id and snapshot are mutually exclusive"})
+		return
+	case req.SnapshotID != "":
+		stored, exists := co.FleetSnapshots.get(req.SnapshotID)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Fleet snapshot not found"})
+			return
+		}
+		// Callers limited to tenants replay their own workloads on the nodes they may see
+		snapshot = visibleFleetSnapshot(c, stored)
+	case snapshot == nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "snapshot_id or snapshot is required"})
+		return
+	}
+
+	report, err := replayScheduling(snapshot, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replay": report})
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
-	"math/big"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -24,6 +27,13 @@ const (
 	RSAKeySize = 2048
 )
 
+// Context keys set by AuthMiddleware for certificate-authenticated callers
+const (
+	ContextKeyAuthMethod = "auth_method"
+	ContextKeyNodeID     = "node_id"
+	ContextKeyCertNames  = "cert_names"
+)
+
 // AuthMiddleware provides authentication middleware
 func (sm *SecurityManager) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -33,8 +43,61 @@ func (sm *SecurityManager) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// For now, implement basic token authentication
-		// In production, this should use proper mTLS client certificate validation
+		// ACME requests are authenticated by their JWS account signature
+		if strings.HasPrefix(c.Request.URL.Path, "/acme/") {
+			c.Next()
+			return
+		}
+
+		// Registry webhooks are authenticated by the shared webhook secret
+		if strings.HasPrefix(c.Request.URL.Path, "/webhooks/registry/") {
+			c.Next()
+			return
+		}
+
+		// Incident webhooks are authenticated by the platform's webhook secret
+		if strings.HasPrefix(c.Request.URL.Path, "/webhooks/incidents/") {
+			c.Next()
+			return
+		}
+
+		// Chatops requests are signed by the chat platform and run as the mapped chat user
+		if strings.HasPrefix(c.Request.URL.Path, "/chatops/") {
+			c.Next()
+			return
+		}
+
+		// The key set holds only public keys
+		if c.Request.URL.Path == JWKSPath {
+			c.Next()
+			return
+		}
+
+		// Prefer the client certificate identity when one was presented, directly
+		// or through a replica that forwarded the request
+		leaf := sm.forwardedClientCertificate(c)
+		if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+			leaf = c.Request.TLS.PeerCertificates[0]
+		}
+		if leaf != nil {
+			record, err := sm.LookupClientCertificate(leaf)
+			if err != nil {
+				sm.logger.Warnf("Rejected client certificate %q: %v", leaf.Subject.CommonName, err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid client certificate"})
+				c.Abort()
+				return
+			}
+
+			c.Set("user", "node:"+record.NodeID)
+			c.Set("role", "node")
+			c.Set(ContextKeyAuthMethod, "certificate")
+			c.Set(ContextKeyNodeID, record.NodeID)
+			c.Set(ContextKeyCertNames, certificateNames(leaf))
+			c.Next()
+			return
+		}
+
+		// Otherwise a bearer token: a signed JWT or a token of the access policy
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -51,23 +114,180 @@ func (sm *SecurityManager) AuthMiddleware() gin.HandlerFunc {
 		}
 
 		token := strings.TrimPrefix(authHeader, bearerPrefix)
-		
-		// For demo purposes, accept any non-empty token
-		// In production, validate JWT tokens or client certificates
 		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Set user context (in production, extract from validated token)
+		// Signed tokens carry their identity in their claims
+		if config := sm.jwtConfig(); config != nil && looksLikeJWT(token) {
+			claims, err := config.verify(token, time.Now())
+			if err != nil {
+				sm.logger.Debugf("Rejected JWT: %v", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+				c.Abort()
+				return
+			}
+			c.Set("user", claims.Subject)
+			c.Set("role", claims.Role)
+			c.Set(ContextKeyGroups, claims.Groups)
+			if len(claims.Tenants) > 0 {
+				c.Set(ContextKeyTenants, claims.Tenants)
+			}
+			if len(claims.Scopes) > 0 {
+				c.Set(ContextKeyScopes, claims.Scopes)
+			}
+			c.Set(ContextKeyTokenExpiry, time.Unix(claims.ExpiresAt, 0))
+			c.Set(ContextKeyAuthMethod, "jwt")
+			c.Next()
+			return
+		}
+
+		// Tokens of the access policy carry their own identity
+		if sm.policy != nil {
+			if apiToken, exists := sm.policy.lookupToken(token); exists {
+				c.Set("user", apiToken.User)
+				c.Set("role", apiToken.Role)
+				c.Set(ContextKeyGroups, apiToken.Groups)
+				if len(apiToken.Tenants) > 0 {
+					c.Set(ContextKeyTenants, apiToken.Tenants)
+				}
+				if len(apiToken.Scopes) > 0 {
+					c.Set(ContextKeyScopes, apiToken.Scopes)
+				}
+				c.Set(ContextKeyAuthMethod, "token")
+				c.Next()
+				return
+			}
+		}
+
+		// With an access policy or JWT keys configured, other tokens are only accepted
+		// from devices joining the fleet
+		if sm.enforcesRoles() {
+			user, joinTokenID, recognized := "", "", false
+			if sm.lookupDevice != nil {
+				user, joinTokenID, recognized = sm.lookupDevice(c, token)
+			}
+			if !recognized {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			c.Set("user", user)
+			c.Set("role", RoleDevice)
+			if joinTokenID != "" {
+				c.Set(ContextKeyJoinToken, joinTokenID)
+			}
+			c.Set(ContextKeyAuthMethod, "token")
+			c.Next()
+			return
+		}
+
+		// Without an access policy or JWT keys any token has full access
 		c.Set("user", "edge-node")
 		c.Set("role", "node")
+		c.Set(ContextKeyAuthMethod, "token")
 		
 		c.Next()
 	}
 }
 
+// nodeRoute registers a route agents call for their own node behind RequireNodeIdentity,
+// and records it so that middleware running before the route's handlers can tell
+func (co *CentralOrchestrator) nodeRoute(group *gin.RouterGroup, method, path string, handler gin.HandlerFunc) {
+	if co.nodeIdentityRoutes == nil {
+		co.nodeIdentityRoutes = make(map[string]bool)
+	}
+	co.nodeIdentityRoutes[method+" "+group.BasePath()+path] = true
+	group.Handle(method, path, co.RequireNodeIdentity(), handler)
+}
+
+// requiresNodeIdentity reports whether the route a request matched was registered with
+// nodeRoute
+func (co *CentralOrchestrator) requiresNodeIdentity(c *gin.Context) bool {
+	return co.nodeIdentityRoutes[c.Request.Method+" "+c.FullPath()]
+}
+
+// RequireNodeIdentity ensures a certificate-authenticated node can only act on its own
+// node routes, so node X cannot post heartbeats or status for node Y
+func (co *CentralOrchestrator) RequireNodeIdentity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nodeID := c.GetString(ContextKeyNodeID)
+		if nodeID == "" {
+			if co.SecurityManager.requireClientCerts {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Client certificate required"})
+				c.Abort()
+				return
+			}
+			if tokenID := c.GetString(ContextKeyJoinToken); tokenID != "" && !co.joinTokenCovers(tokenID, c.Param("id")) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Join token does not belong to this node"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		co.NodeManager.mutex.RLock()
+		node, exists := co.NodeManager.nodes[c.Param("id")]
+		co.NodeManager.mutex.RUnlock()
+
+		// A multi-cluster agent's certificate also covers the logical nodes it registered
+		if nodeID != c.Param("id") && !(exists && certificateMayActAs(c, node)) {
+			co.Logger.Warnf("Node %s attempted to access node %s", nodeID, c.Param("id"))
+			c.JSON(http.StatusForbidden, gin.H{"error": "Certificate does not belong to this node"})
+			c.Abort()
+			return
+		}
+
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			c.Abort()
+			return
+		}
+
+		if !certificateMayActAs(c, node) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Certificate subject does not match node"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// LookupClientCertificate maps a presented client certificate to the record it was issued under
+func (sm *SecurityManager) LookupClientCertificate(cert *x509.Certificate) (*Certificate, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	certID, exists := sm.fingerprints[certificateFingerprint(cert.Raw)]
+	if !exists {
+		return nil, fmt.Errorf("certificate was not issued by this orchestrator or has been revoked")
+	}
+
+	record := sm.certificates[certID]
+	now := time.Now()
+	if now.Before(record.IssuedAt) || now.After(record.ExpiresAt) {
+		return nil, fmt.Errorf("certificate is not valid at current time")
+	}
+
+	return record, nil
+}
+
+// certificateFingerprint returns the hex SHA-256 digest of a DER certificate
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// certificateNames returns the CN and DNS SANs of a certificate
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	return append(names, cert.DNSNames...)
+}
+
 // IssueCertificate issues a new certificate for a node
 func (co *CentralOrchestrator) IssueCertificate(c *gin.Context) {
 	var req CertificateRequest
@@ -88,6 +308,8 @@ func (co *CentralOrchestrator) IssueCertificate(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{
 		"certificate_id": cert.ID,
 		"certificate":    string(cert.Certificate),
+		"chain":          string(cert.Chain),
+		"signer":         cert.Signer,
 		"issued_at":     cert.IssuedAt,
 		"expires_at":    cert.ExpiresAt,
 	})
@@ -115,8 +337,10 @@ func (co *CentralOrchestrator) RevokeCertificate(c *gin.Context) {
 
 // GenerateCertificate generates a new TLS certificate
 func (sm *SecurityManager) GenerateCertificate(nodeID, commonName string, dnsNames, ipAddresses []string) (*Certificate, error) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+	signer, err := sm.certificateSigner()
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate private key
 	privateKey, err := rsa.GenerateKey(rand.Reader, RSAKeySize)
@@ -124,45 +348,53 @@ func (sm *SecurityManager) GenerateCertificate(nodeID, commonName string, dnsNam
 		return nil, fmt.Errorf("failed to generate private key: %v", err)
 	}
 
-	// Create certificate template
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
+	// Create certificate request template
+	template := x509.CertificateRequest{
 		Subject: pkix.Name{
-			Organization:  []string{"Kubernetes Edge Framework"},
-			Country:       []string{"US"},
-			Province:      []string{""},
-			Locality:      []string{""},
-			StreetAddress: []string{""},
-			PostalCode:    []string{""},
-			CommonName:    commonName,
+			Organization: []string{"Kubernetes Edge Framework"},
+			Country:      []string{"US"},
+			CommonName:   commonName,
 		},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().Add(CertValidityPeriod),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		IPAddresses:  []x509.IPAddress{},
-		DNSNames:     dnsNames,
+		DNSNames: dnsNames,
 	}
 
 	// Add IP addresses if provided
 	for _, ipStr := range ipAddresses {
-		if ip := x509.ParseIP(ipStr); ip != nil {
+		if ip := net.ParseIP(ipStr); ip != nil {
 			template.IPAddresses = append(template.IPAddresses, ip)
 		}
 	}
 
-	// For demo purposes, use self-signed certificates
-	// In production, use a proper CA
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create certificate: %v", err)
+		return nil, fmt.Errorf("failed to parse certificate request: %v", err)
 	}
 
-	// Encode certificate to PEM
-	certPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certDER,
+	// Have the configured CA sign the request
+	ctx, cancel := context.WithTimeout(context.Background(), CertificateSigningTimeout)
+	defer cancel()
+
+	signed, err := signer.Sign(ctx, &SigningRequest{
+		CSR:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+		Request:  csr,
+		Validity: CertValidityPeriod,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate with %s signer: %v", signer.Name(), err)
+	}
+
+	block, _ := pem.Decode(signed.Certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s signer returned an invalid certificate", signer.Name())
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signed certificate: %v", err)
+	}
 
 	// Encode private key to PEM
 	privateKeyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
@@ -180,14 +412,19 @@ func (sm *SecurityManager) GenerateCertificate(nodeID, commonName string, dnsNam
 	cert := &Certificate{
 		ID:          certID,
 		NodeID:      nodeID,
-		Certificate: certPEM,
+		Certificate: signed.Certificate,
+		Chain:       signed.Chain,
 		PrivateKey:  privateKeyPEM,
-		IssuedAt:    template.NotBefore,
-		ExpiresAt:   template.NotAfter,
+		Signer:      signer.Name(),
+		IssuedAt:    leaf.NotBefore,
+		ExpiresAt:   leaf.NotAfter,
 	}
 
 	// Store certificate
+	sm.mutex.Lock()
 	sm.certificates[certID] = cert
+	sm.fingerprints[certificateFingerprint(block.Bytes)] = certID
+	sm.mutex.Unlock()
 
 	return cert, nil
 }
@@ -197,13 +434,21 @@ func (sm *SecurityManager) RevokeCertificate(certificateID string) error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	if _, exists := sm.certificates[certificateID]; !exists {
+	cert, exists := sm.certificates[certificateID]
+	if !exists {
 		return fmt.Errorf("certificate not found")
 	}
+	if cert.RevokedAt != nil {
+		return fmt.Errorf("certificate already revoked")
+	}
 
-	// For now, just remove from storage
-	// In production, maintain a certificate revocation list (CRL)
-	delete(sm.certificates, certificateID)
+	if block, _ := pem.Decode(cert.Certificate); block != nil {
+		delete(sm.fingerprints, certificateFingerprint(block.Bytes))
+	}
+
+	// Keep the record so the inventory shows the revocation
+	now := time.Now()
+	cert.RevokedAt = &now
 	
 	return nil
 }
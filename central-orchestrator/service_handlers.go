@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNodeWorkloads returns the workloads with an active deployment on a node
+func (co *CentralOrchestrator) GetNodeWorkloads(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.NodeManager.mutex.RLock()
+	_, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	workloads := make([]*Workload, 0)
+	for _, workload := range co.WorkloadManager.workloads {
+		deployment := workload.deploymentFor(nodeID)
+		if deployment != nil && (deployment.Status == WorkloadStatusRunning || deployment.Status == WorkloadStatusPending) {
+			workloads = append(workloads, workload)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workloads": workloads})
+}
+
+// ReportWorkloadEndpoints records the service endpoints an agent exposes for a workload
+func (co *CentralOrchestrator) ReportWorkloadEndpoints(c *gin.Context) {
+	nodeID := c.Param("id")
+	workloadID := c.Param("wid")
+
+	var req EndpointReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := co.recordWorkloadEndpoints(nodeID, workloadID, req.Endpoints); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Endpoints updated"})
+}
+
+// recordWorkloadEndpoints stores the endpoints a workload exposes on a node
+func (co *CentralOrchestrator) recordWorkloadEndpoints(nodeID, workloadID string, endpoints []ServiceEndpoint) error {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[workloadID]
+	if !exists {
+		return fmt.Errorf("Workload not found")
+	}
+
+	deployment := workload.deploymentFor(nodeID)
+	if deployment == nil {
+		return fmt.Errorf("Workload is not deployed on this node")
+	}
+
+	deployment.Endpoints = endpoints
+	deployment.UpdatedAt = time.Now()
+	return nil
+}
+
+// GetWorkloadEndpoints returns the fleet-wide endpoint map of a workload, keyed by node ID
+func (co *CentralOrchestrator) GetWorkloadEndpoints(c *gin.Context) {
+	workloadID := c.Param("id")
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	workload, exists := co.WorkloadManager.workloads[workloadID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	endpoints := make(map[string][]ServiceEndpoint)
+	for _, deployment := range workload.Deployments {
+		if len(deployment.Endpoints) > 0 {
+			endpoints[deployment.NodeID] = deployment.Endpoints
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"workload_id":  workload.ID,
+		"service_type": workload.ServiceType,
+		"ports":        workload.Ports,
+		"endpoints":    endpoints,
+	})
+}
+
+// deploymentFor returns the workload's deployment on a node, or nil if it has none
+func (w *Workload) deploymentFor(nodeID string) *WorkloadDeployment {
+	for i := range w.Deployments {
+		if w.Deployments[i].NodeID == nodeID {
+			return &w.Deployments[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Alert attributes a silence matcher can test
+const (
+	SilenceMatcherName     = "name"
+	SilenceMatcherSeverity = "severity"
+	SilenceMatcherScope    = "scope"
+	SilenceMatcherScopeID  = "scope_id"
+	SilenceMatcherSiteID   = "site_id"
+	// The node the alert is about: a node alert's node, or the node of a camera or volume
+	SilenceMatcherNode = "node"
+	// Followed by a label key, a label of the node the alert is about
+	SilenceLabelMatcherPrefix = "label."
+)
+
+var silenceMatcherFields = []string{SilenceMatcherName, SilenceMatcherSeverity, SilenceMatcherScope, SilenceMatcherScopeID, SilenceMatcherSiteID, SilenceMatcherNode}
+
+// SilenceStatus is where a silence is in its time window
+type SilenceStatus string
+
+const (
+	SilenceStatusPending SilenceStatus = "pending"
+	SilenceStatusActive  SilenceStatus = "active"
+	SilenceStatusExpired SilenceStatus = "expired"
+)
+
+// SilenceMatcher tests one attribute of an alert
+type SilenceMatcher struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	// Value is a regular expression that must match the whole attribute
+	IsRegex bool `json:"is_regex"`
+
+	pattern *regexp.Regexp
+}
+
+// compile checks the matcher and compiles its pattern
+func (m *SilenceMatcher) compile() error {
+	if !contains(silenceMatcherFields, m.Field) && (!strings.HasPrefix(m.Field, SilenceLabelMatcherPrefix) || m.Field == SilenceLabelMatcherPrefix) {
+		return fmt.Errorf("unknown matcher field %q", m.Field)
+	}
+	if !m.IsRegex {
+		return nil
+	}
+	pattern, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return fmt.Errorf("matcher %s has an invalid pattern: %v", m.Field, err)
+	}
+	m.pattern = pattern
+	return nil
+}
+
+func (m SilenceMatcher) matches(value string, exists bool) bool {
+	if !exists {
+		return false
+	}
+	if m.pattern != nil {
+		return m.pattern.MatchString(value)
+	}
+	return value == m.Value
+}
+
+// Silence suppresses the alerts its matchers all match while its time window is open
+type Silence struct {
+	ID        string           `json:"id"`
+	Matchers  []SilenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"starts_at"`
+	EndsAt    time.Time        `json:"ends_at"`
+	CreatedBy string           `json:"created_by"`
+	Comment   string           `json:"comment"`
+	CreatedAt time.Time        `json:"created_at"`
+	// The maintenance window that owns the silence; it ends with the window
+	MaintenanceWindowID string `json:"maintenance_window_id,omitempty"`
+	// Filled in responses
+	Status SilenceStatus `json:"status,omitempty"`
+}
+
+// status returns where the silence is in its window
+func (s *Silence) status(now time.Time) SilenceStatus {
+	switch {
+	case now.Before(s.StartsAt):
+		return SilenceStatusPending
+	case now.Before(s.EndsAt):
+		return SilenceStatusActive
+	default:
+		return SilenceStatusExpired
+	}
+}
+
+// SilenceRequest represents a silence creation request
+type SilenceRequest struct {
+	Matchers []SilenceMatcher `json:"matchers" binding:"required"`
+	// Now when unset
+	StartsAt  *time.Time `json:"starts_at"`
+	EndsAt    time.Time  `json:"ends_at" binding:"required"`
+	CreatedBy string     `json:"created_by"`
+	Comment   string     `json:"comment" binding:"required"`
+}
+
+// MaintenanceWindow is planned downtime for a group of nodes. Alerts about the group's
+// nodes, and about its site when the group is a whole site, are silenced for the window.
+type MaintenanceWindow struct {
+	ID string `json:"id"`
+	NodeGroup
+	Name      string    `json:"name"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	SilenceID string    `json:"silence_id"`
+}
+
+// MaintenanceWindowRequest represents a maintenance window creation request
+type MaintenanceWindowRequest struct {
+	Name         string            `json:"name" binding:"required"`
+	NodeSelector map[string]string `json:"node_selector"`
+	SiteID       string            `json:"site_id"`
+	StartsAt     time.Time         `json:"starts_at" binding:"required"`
+	EndsAt       time.Time         `json:"ends_at" binding:"required"`
+	Reason       string            `json:"reason"`
+	CreatedBy    string            `json:"created_by"`
+}
+
+// matchers returns the silence matchers selecting the group's nodes
+func (w *MaintenanceWindow) matchers() []SilenceMatcher {
+	var matchers []SilenceMatcher
+	if w.SiteID != "" {
+		matchers = append(matchers, SilenceMatcher{Field: SilenceMatcherSiteID, Value: w.SiteID})
+	}
+	keys := make([]string, 0, len(w.NodeSelector))
+	for key := range w.NodeSelector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		matchers = append(matchers, SilenceMatcher{Field: SilenceLabelMatcherPrefix + key, Value: w.NodeSelector[key]})
+	}
+	return matchers
+}
+
+// SilenceManager keeps alert silences and the maintenance windows that create them
+type SilenceManager struct {
+	silences map[string]*Silence
+	windows  map[string]*MaintenanceWindow
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewSilenceManager creates a new silence manager
+func NewSilenceManager(logger *logrus.Logger) *SilenceManager {
+	return &SilenceManager{
+		silences: make(map[string]*Silence),
+		windows:  make(map[string]*MaintenanceWindow),
+		logger:   logger,
+	}
+}
+
+// pruneExpired drops silences and maintenance windows that ended past the alert history
+// retention; callers must hold the lock
+func (sm *SilenceManager) pruneExpired(now time.Time) {
+	for id, silence := range sm.silences {
+		if now.Sub(silence.EndsAt) > ResolvedAlertRetention {
+			delete(sm.silences, id)
+		}
+	}
+	for id, window := range sm.windows {
+		if now.Sub(window.EndsAt) > ResolvedAlertRetention {
+			delete(sm.windows, id)
+		}
+	}
+}
+
+// active returns copies of the silences open at the given time
+func (sm *SilenceManager) active(now time.Time) []Silence {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var silences []Silence
+	for _, silence := range sm.silences {
+		if silence.status(now) == SilenceStatusActive {
+			silences = append(silences, *silence)
+		}
+	}
+	return silences
+}
+
+// alertNodeID returns the node an alert is about, if any
+func alertNodeID(alert *Alert) string {
+	switch alert.Scope {
+	case AlertScopeNode:
+		return alert.ScopeID
+	case AlertScopeCamera:
+		nodeID, _, _ := strings.Cut(alert.ScopeID, "/")
+		return nodeID
+	case AlertScopeVolume:
+		// Keyed by workload, node and volume
+		if parts := strings.SplitN(alert.ScopeID, "/", 3); len(parts) == 3 {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// alertWorkloadID returns the workload an alert is about, if any
+func alertWorkloadID(alert *Alert) string {
+	switch alert.Scope {
+	case AlertScopeWorkload:
+		return alert.ScopeID
+	case AlertScopeVolume:
+		workloadID, _, _ := strings.Cut(alert.ScopeID, "/")
+		return workloadID
+	}
+	return ""
+}
+
+// silencedAlerts returns the IDs of the active silences suppressing each alert, by alert ID
+func (co *CentralOrchestrator) silencedAlerts(alerts []*Alert, now time.Time) map[string][]string {
+	silenced := make(map[string][]string)
+	silences := co.SilenceManager.active(now)
+	if len(silences) == 0 {
+		return silenced
+	}
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+	co.AlertManager.mutex.RLock()
+	defer co.AlertManager.mutex.RUnlock()
+
+	for _, alert := range alerts {
+		node := co.NodeManager.nodes[alertNodeID(alert)]
+		attribute := func(field string) (string, bool) {
+			switch field {
+			case SilenceMatcherName:
+				return alert.Name, true
+			case SilenceMatcherSeverity:
+				return string(alert.Severity), true
+			case SilenceMatcherScope:
+				return string(alert.Scope), true
+			case SilenceMatcherScopeID:
+				return alert.ScopeID, true
+			case SilenceMatcherSiteID:
+				if alert.SiteID == "" && node != nil {
+					return node.SiteID, node.SiteID != ""
+				}
+				return alert.SiteID, alert.SiteID != ""
+			case SilenceMatcherNode:
+				return alertNodeID(alert), node != nil
+			}
+			if node == nil {
+				return "", false
+			}
+			value, exists := node.Labels[strings.TrimPrefix(field, SilenceLabelMatcherPrefix)]
+			return value, exists
+		}
+
+		for _, silence := range silences {
+			matched := true
+			for _, matcher := range silence.Matchers {
+				if !matcher.matches(attribute(matcher.Field)) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				silenced[alert.ID] = append(silenced[alert.ID], silence.ID)
+			}
+		}
+	}
+	return silenced
+}
+
+// unsilencedAlerts drops the alerts active silences suppress
+func (co *CentralOrchestrator) unsilencedAlerts(alerts []*Alert) []*Alert {
+	silenced := co.silencedAlerts(alerts, time.Now())
+	kept := make([]*Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		if len(silenced[alert.ID]) == 0 {
+			kept = append(kept, alert)
+		}
+	}
+	return kept
+}
+
+// CreateSilence creates a silence for the alerts its matchers select
+func (co *CentralOrchestrator) CreateSilence(c *gin.Context) {
+	var req SilenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	silence := &Silence{
+		ID:        generateID(),
+		Matchers:  req.Matchers,
+		StartsAt:  now,
+		EndsAt:    req.EndsAt,
+		CreatedBy: req.CreatedBy,
+		Comment:   req.Comment,
+		CreatedAt: now,
+	}
+	if req.StartsAt != nil {
+		silence.StartsAt = *req.StartsAt
+	}
+	if silence.CreatedBy == "" {
+		silence.CreatedBy = requestActor(c)
+	}
+	if err := validateSilence(silence, now); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.SilenceManager.mutex.Lock()
+	co.SilenceManager.pruneExpired(now)
+	co.SilenceManager.silences[silence.ID] = silence
+	co.SilenceManager.mutex.Unlock()
+
+	co.Logger.Infof("Silence %s created by %s until %s", silence.ID, silence.CreatedBy, silence.EndsAt.Format(time.RFC3339))
+	co.AuditLog.RecordRequest(c, "silence.create", silence.ID, map[string]string{"comment": silence.Comment})
+
+	view := *silence
+	view.Status = silence.status(now)
+	c.JSON(http.StatusCreated, gin.H{"id": silence.ID, "silence": view})
+}
+
+// validateSilence checks a new silence's matchers, window and author
+func validateSilence(silence *Silence, now time.Time) error {
+	if len(silence.Matchers) == 0 {
+		return fmt.Errorf("a silence needs at least one matcher")
+	}
+	for i := range silence.Matchers {
+		if err := silence.Matchers[i].compile(); err != nil {
+			return err
+		}
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	if !silence.EndsAt.After(now) {
+		return fmt.Errorf("ends_at must be in the future")
+	}
+	if silence.CreatedBy == "" {
+		return fmt.Errorf("created_by is required")
+	}
+	return nil
+}
+
+// ListSilences returns silences newest first, optionally filtered by status
+func (co *CentralOrchestrator) ListSilences(c *gin.Context) {
+	status := SilenceStatus(c.Query("status"))
+	now := time.Now()
+
+	co.SilenceManager.mutex.RLock()
+	silences := make([]Silence, 0, len(co.SilenceManager.silences))
+	for _, silence := range co.SilenceManager.silences {
+		view := *silence
+		view.Status = silence.status(now)
+		if status == "" || view.Status == status {
+			silences = append(silences, view)
+		}
+	}
+	co.SilenceManager.mutex.RUnlock()
+
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].CreatedAt.After(silences[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"silences": silences})
+}
+
+// GetSilence returns a silence and the firing alerts it currently suppresses
+func (co *CentralOrchestrator) GetSilence(c *gin.Context) {
+	silenceID := c.Param("id")
+	now := time.Now()
+
+	co.SilenceManager.mutex.RLock()
+	silence, exists := co.SilenceManager.silences[silenceID]
+	var view Silence
+	if exists {
+		view = *silence
+		view.Status = silence.status(now)
+	}
+	co.SilenceManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Silence not found"})
+		return
+	}
+
+	firing := co.visibleAlerts(c, co.AlertManager.List(AlertFilter{Status: string(AlertStatusFiring)}))
+	silenced := co.silencedAlerts(firing, now)
+	suppressed := make([]*Alert, 0)
+	for _, alert := range firing {
+		if contains(silenced[alert.ID], silenceID) {
+			suppressed = append(suppressed, alert)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"silence": view, "alerts": co.localizeAlerts(suppressed, requestLocale(c))})
+}
+
+// ExpireSilence ends a silence now; silences of maintenance windows end with the window
+func (co *CentralOrchestrator) ExpireSilence(c *gin.Context) {
+	silenceID := c.Param("id")
+	now := time.Now()
+
+	co.SilenceManager.mutex.Lock()
+	silence, exists := co.SilenceManager.silences[silenceID]
+	if !exists {
+		co.SilenceManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Silence not found"})
+		return
+	}
+	if silence.MaintenanceWindowID != "" {
+		co.SilenceManager.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Silence belongs to maintenance window %s; delete the window instead", silence.MaintenanceWindowID)})
+		return
+	}
+	if silence.status(now) == SilenceStatusExpired {
+		co.SilenceManager.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Silence has already expired"})
+		return
+	}
+	expireSilence(silence, now)
+	co.SilenceManager.mutex.Unlock()
+
+	co.Logger.Infof("Silence %s expired", silenceID)
+	co.AuditLog.RecordRequest(c, "silence.expire", silenceID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Silence expired"})
+}
+
+// expireSilence ends a silence at the given time; callers must hold the SilenceManager lock
+func expireSilence(silence *Silence, now time.Time) {
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+	silence.EndsAt = now
+}
+
+// CreateMaintenanceWindow schedules downtime for a node group and silences its alerts for
+// the window
+func (co *CentralOrchestrator) CreateMaintenanceWindow(c *gin.Context) {
+	var req MaintenanceWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SiteID == "" && len(req.NodeSelector) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "site_id or node_selector is required"})
+		return
+	}
+
+	now := time.Now()
+	window := &MaintenanceWindow{
+		ID:        generateID(),
+		NodeGroup: NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID},
+		Name:      req.Name,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		Reason:    req.Reason,
+		CreatedBy: req.CreatedBy,
+		CreatedAt: now,
+	}
+	if window.NodeSelector == nil {
+		window.NodeSelector = make(map[string]string)
+	}
+	if window.CreatedBy == "" {
+		window.CreatedBy = requestActor(c)
+	}
+
+	comment := "Maintenance window " + window.Name
+	if window.Reason != "" {
+		comment += ": " + window.Reason
+	}
+	silence := &Silence{
+		ID:                  generateID(),
+		Matchers:            window.matchers(),
+		StartsAt:            window.StartsAt,
+		EndsAt:              window.EndsAt,
+		CreatedBy:           window.CreatedBy,
+		Comment:             comment,
+		CreatedAt:           now,
+		MaintenanceWindowID: window.ID,
+	}
+	if err := validateSilence(silence, now); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	window.SilenceID = silence.ID
+
+	co.SilenceManager.mutex.Lock()
+	co.SilenceManager.pruneExpired(now)
+	co.SilenceManager.windows[window.ID] = window
+	co.SilenceManager.silences[silence.ID] = silence
+	co.SilenceManager.mutex.Unlock()
+
+	co.Logger.Infof("Maintenance window %s created with ID %s from %s to %s", window.Name, window.ID,
+		window.StartsAt.Format(time.RFC3339), window.EndsAt.Format(time.RFC3339))
+	co.AuditLog.RecordRequest(c, "maintenance-window.create", window.ID, map[string]string{"name": window.Name})
+
+	c.JSON(http.StatusCreated, gin.H{"id": window.ID, "window": window})
+}
+
+// ListMaintenanceWindows returns maintenance windows by start time
+func (co *CentralOrchestrator) ListMaintenanceWindows(c *gin.Context) {
+	co.SilenceManager.mutex.RLock()
+	windows := make([]*MaintenanceWindow, 0, len(co.SilenceManager.windows))
+	for _, window := range co.SilenceManager.windows {
+		windows = append(windows, window)
+	}
+	co.SilenceManager.mutex.RUnlock()
+
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].StartsAt.Before(windows[j].StartsAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"windows": windows})
+}
+
+// DeleteMaintenanceWindow cancels a maintenance window and ends its silence
+func (co *CentralOrchestrator) DeleteMaintenanceWindow(c *gin.Context) {
+	windowID := c.Param("id")
+
+	co.SilenceManager.mutex.Lock()
+	window, exists := co.SilenceManager.windows[windowID]
+	if !exists {
+		co.SilenceManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+		return
+	}
+	delete(co.SilenceManager.windows, windowID)
+	if silence, exists := co.SilenceManager.silences[window.SilenceID]; exists {
+		now := time.Now()
+		if silence.status(now) != SilenceStatusExpired {
+			expireSilence(silence, now)
+		}
+		// The silence stays for history, no longer tied to a window
+		silence.MaintenanceWindowID = ""
+	}
+	co.SilenceManager.mutex.Unlock()
+
+	co.Logger.Infof("Maintenance window %s deleted", windowID)
+	co.AuditLog.RecordRequest(c, "maintenance-window.delete", windowID, map[string]string{"name": window.Name})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Maintenance window deleted successfully"})
+}
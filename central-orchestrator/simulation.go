@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeFailureSimulationRequest names the nodes and/or sites to treat as failed
+type NodeFailureSimulationRequest struct {
+	NodeIDs []string `json:"node_ids"`
+	SiteIDs []string `json:"site_ids"`
+}
+
+// SimulatedWorkloadImpact summarizes how a simulated failure affects one workload
+type SimulatedWorkloadImpact struct {
+	WorkloadID            string   `json:"workload_id"`
+	Name                  string   `json:"name"`
+	Criticality           int32    `json:"criticality"`
+	LostReplicas          int32    `json:"lost_replicas"`
+	ReplacedReplicas      int32    `json:"replaced_replicas"`
+	UnschedulableReplicas int32    `json:"unschedulable_replicas"`
+	Displaced             bool     `json:"displaced"`
+	NewNodes              []string `json:"new_nodes"`
+}
+
+// NodeFailureSimulationResult is the outcome of a what-if node failure simulation
+type NodeFailureSimulationResult struct {
+	FailedNodes   []string                   `json:"failed_nodes"`
+	Workloads     []*SimulatedWorkloadImpact `json:"workloads"`
+	Unschedulable []string                   `json:"unschedulable_workloads"`
+	Steps         []OperationStep            `json:"steps"`
+}
+
+// SimulateNodeFailure reports which workloads would lose replicas if the given nodes or
+// sites failed, where they would be re-placed, and which would become unschedulable.
+// Live state is never modified.
+func (co *CentralOrchestrator) SimulateNodeFailure(c *gin.Context) {
+	var req NodeFailureSimulationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.NodeIDs) == 0 && len(req.SiteIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "node_ids or site_ids is required"})
+		return
+	}
+
+	failed, err := co.resolveFailedNodes(req.NodeIDs, req.SiteIDs)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := co.simulateFailure(failed)
+	c.JSON(http.StatusOK, gin.H{"simulation": result})
+}
+
+// resolveFailedNodes expands node and site IDs into a set of node IDs
+func (co *CentralOrchestrator) resolveFailedNodes(nodeIDs, siteIDs []string) (map[string]bool, error) {
+	co.SiteManager.mutex.RLock()
+	for _, siteID := range siteIDs {
+		if _, exists := co.SiteManager.sites[siteID]; !exists {
+			co.SiteManager.mutex.RUnlock()
+			return nil, fmt.Errorf("site %s not found", siteID)
+		}
+	}
+	co.SiteManager.mutex.RUnlock()
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	failed := make(map[string]bool)
+	for _, nodeID := range nodeIDs {
+		if _, exists := co.NodeManager.nodes[nodeID]; !exists {
+			return nil, fmt.Errorf("node %s not found", nodeID)
+		}
+		failed[nodeID] = true
+	}
+	for _, node := range co.NodeManager.nodes {
+		if node.SiteID != "" && contains(siteIDs, node.SiteID) {
+			failed[node.ID] = true
+		}
+	}
+	return failed, nil
+}
+
+// simulateFailure runs the failover planner against a copy of the workload state
+func (co *CentralOrchestrator) simulateFailure(failed map[string]bool) *NodeFailureSimulationResult {
+	online := co.onlineNodes(failed)
+
+	co.WorkloadManager.mutex.RLock()
+	workloads := cloneWorkloads(co.WorkloadManager.workloads)
+	co.WorkloadManager.mutex.RUnlock()
+
+	planner := &failoverPlanner{co: co, workloads: workloads, online: online}
+	queue := planner.failLostDeployments()
+
+	result := &NodeFailureSimulationResult{
+		FailedNodes:   make([]string, 0, len(failed)),
+		Workloads:     make([]*SimulatedWorkloadImpact, 0),
+		Unschedulable: make([]string, 0),
+		Steps:         make([]OperationStep, 0),
+	}
+	for id := range failed {
+		result.FailedNodes = append(result.FailedNodes, id)
+	}
+	sort.Strings(result.FailedNodes)
+
+	impacts := make(map[string]*SimulatedWorkloadImpact)
+	impactFor := func(w *Workload) *SimulatedWorkloadImpact {
+		impact, exists := impacts[w.ID]
+		if !exists {
+			impact = &SimulatedWorkloadImpact{
+				WorkloadID:  w.ID,
+				Name:        w.Name,
+				Criticality: w.Criticality,
+				NewNodes:    make([]string, 0),
+			}
+			impacts[w.ID] = impact
+			result.Workloads = append(result.Workloads, impact)
+		}
+		return impact
+	}
+
+	for _, item := range queue {
+		impactFor(item.workload).LostReplicas += item.replicas
+	}
+
+	for _, outcome := range planner.plan(queue) {
+		impact := impactFor(outcome.item.workload)
+		switch outcome.action {
+		case "replaced":
+			impact.ReplacedReplicas += outcome.item.replicas
+			impact.NewNodes = append(impact.NewNodes, outcome.node.ID)
+		case "displaced":
+			impact.Displaced = true
+			impact.LostReplicas += outcome.item.replicas
+		case "unschedulable":
+			impact.UnschedulableReplicas += outcome.item.replicas
+		}
+
+		step := outcome.step()
+		step.Order = len(result.Steps) + 1
+		result.Steps = append(result.Steps, step)
+	}
+
+	for _, impact := range result.Workloads {
+		if impact.UnschedulableReplicas > 0 {
+			result.Unschedulable = append(result.Unschedulable, impact.WorkloadID)
+		}
+	}
+
+	return result
+}
+
+// cloneWorkloads copies workloads and their deployments so they can be mutated freely
+func cloneWorkloads(workloads map[string]*Workload) map[string]*Workload {
+	clones := make(map[string]*Workload, len(workloads))
+	for id, workload := range workloads {
+		clone := *workload
+		clone.Deployments = append([]WorkloadDeployment(nil), workload.Deployments...)
+		clones[id] = &clone
+	}
+	return clones
+}
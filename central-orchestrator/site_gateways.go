@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Capability of agents that may serve as their site's gateway
+	SiteGatewayCapability = "site-gateway"
+	// Label carrying the LAN port an eligible agent serves peer heartbeats on
+	SiteGatewayPortLabel = "site-gateway.edge.io/port"
+
+	// Interval between site gateway elections
+	SiteGatewayElectionInterval = 15 * time.Second
+)
+
+// SiteGateway is the agent a site's peers send heartbeats through, so the site keeps a
+// single connection to the orchestrator for heartbeats
+type SiteGateway struct {
+	SiteID    string    `json:"site_id"`
+	NodeID    string    `json:"node_id"`
+	NodeName  string    `json:"node_name"`
+	Address   string    `json:"address"`
+	ElectedAt time.Time `json:"elected_at"`
+	// Peers authenticate to the gateway with it; rotated on every election
+	secret string
+}
+
+// SiteGatewayAssignment is a node's view of its site's gateway
+type SiteGatewayAssignment struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	Secret  string `json:"secret"`
+	// The site's other nodes, for the gateway itself
+	Members []string `json:"members,omitempty"`
+}
+
+// SiteGatewayManager keeps the elected gateway of each site
+type SiteGatewayManager struct {
+	gateways map[string]*SiteGateway
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewSiteGatewayManager creates a new site gateway manager
+func NewSiteGatewayManager(logger *logrus.Logger) *SiteGatewayManager {
+	return &SiteGatewayManager{
+		gateways: make(map[string]*SiteGateway),
+		logger:   logger,
+	}
+}
+
+// actsFor reports whether a node is the elected gateway of another node's site
+func (sgm *SiteGatewayManager) actsFor(gatewayNodeID string, node *EdgeNode) bool {
+	sgm.mutex.RLock()
+	defer sgm.mutex.RUnlock()
+
+	gateway, exists := sgm.gateways[node.SiteID]
+	return exists && node.SiteID != "" && gateway.NodeID == gatewayNodeID
+}
+
+// siteGatewayAddress returns the LAN address a node serves peer heartbeats on, if it may
+// serve as its site's gateway
+func siteGatewayAddress(node *EdgeNode) (string, bool) {
+	if node.SiteID == "" || node.Address == "" || !contains(node.Capabilities, SiteGatewayCapability) {
+		return "", false
+	}
+	port, err := strconv.Atoi(node.Labels[SiteGatewayPortLabel])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", false
+	}
+	return net.JoinHostPort(node.Address, strconv.Itoa(port)), true
+}
+
+// siteGatewayController periodically elects site gateways
+func (co *CentralOrchestrator) siteGatewayController() {
+	ticker := time.NewTicker(SiteGatewayElectionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.electSiteGateways(time.Now())
+		}
+	}
+}
+
+// electSiteGateways keeps each site's gateway while it stays online and eligible, and
+// otherwise elects the eligible online node with the lowest ID
+func (co *CentralOrchestrator) electSiteGateways(now time.Time) {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	candidates := make(map[string][]*EdgeNode)
+	for _, node := range co.NodeManager.nodes {
+		if _, eligible := siteGatewayAddress(node); eligible && node.Status == NodeStatusOnline {
+			candidates[node.SiteID] = append(candidates[node.SiteID], node)
+		}
+	}
+
+	sgm := co.SiteGatewayManager
+	sgm.mutex.Lock()
+	defer sgm.mutex.Unlock()
+
+	for siteID, gateway := range sgm.gateways {
+		if len(candidates[siteID]) == 0 {
+			delete(sgm.gateways, siteID)
+			co.Logger.Warnf("Site %s has no eligible gateway left; its nodes send heartbeats directly", siteID)
+		} else if node := co.NodeManager.nodes[gateway.NodeID]; node != nil {
+			// Follow address changes of a gateway that stays elected
+			gateway.Address, _ = siteGatewayAddress(node)
+		}
+	}
+
+	for siteID, nodes := range candidates {
+		current, exists := sgm.gateways[siteID]
+		if exists && containsNode(nodes, current.NodeID) {
+			continue
+		}
+
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].ID < nodes[j].ID
+		})
+		elected := nodes[0]
+		address, _ := siteGatewayAddress(elected)
+		sgm.gateways[siteID] = &SiteGateway{
+			SiteID:    siteID,
+			NodeID:    elected.ID,
+			NodeName:  elected.Name,
+			Address:   address,
+			ElectedAt: now,
+			secret:    generateID(),
+		}
+		co.Logger.Infof("Node %s elected heartbeat gateway of site %s at %s", elected.Name, siteID, address)
+	}
+}
+
+// containsNode reports whether a node is among the nodes
+func containsNode(nodes []*EdgeNode, nodeID string) bool {
+	for _, node := range nodes {
+		if node.ID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNodeSiteGateway tells a node which agent to send its heartbeats through, and the
+// gateway itself which peers it serves
+func (co *CentralOrchestrator) GetNodeSiteGateway(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	co.SiteGatewayManager.mutex.RLock()
+	gateway, elected := co.SiteGatewayManager.gateways[node.SiteID]
+	var assignment SiteGatewayAssignment
+	if elected {
+		assignment = SiteGatewayAssignment{NodeID: gateway.NodeID, Address: gateway.Address, Secret: gateway.secret}
+	}
+	co.SiteGatewayManager.mutex.RUnlock()
+
+	if node.SiteID == "" || !elected {
+		c.JSON(http.StatusOK, gin.H{"gateway": nil})
+		return
+	}
+	if assignment.NodeID == nodeID {
+		assignment.Members = make([]string, 0)
+		for _, peer := range co.NodeManager.nodes {
+			if peer.SiteID == node.SiteID && peer.ID != nodeID {
+				assignment.Members = append(assignment.Members, peer.ID)
+			}
+		}
+		sort.Strings(assignment.Members)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"gateway": assignment})
+}
+
+// GetSiteGateway returns a site's heartbeat gateway and the nodes whose latest heartbeat
+// came through it
+func (co *CentralOrchestrator) GetSiteGateway(c *gin.Context) {
+	siteID := c.Param("id")
+
+	co.SiteGatewayManager.mutex.RLock()
+	gateway, elected := co.SiteGatewayManager.gateways[siteID]
+	var view SiteGateway
+	if elected {
+		view = *gateway
+	}
+	co.SiteGatewayManager.mutex.RUnlock()
+
+	if !elected {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site has no heartbeat gateway"})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	forwarded := make([]string, 0)
+	for _, node := range co.NodeManager.nodes {
+		if node.SiteID == siteID && node.HeartbeatTransport == HeartbeatTransportSiteGateway {
+			forwarded = append(forwarded, node.ID)
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+	sort.Strings(forwarded)
+
+	c.JSON(http.StatusOK, gin.H{"gateway": view, "forwarded_nodes": forwarded})
+}
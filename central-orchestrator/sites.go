@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SiteHealth represents the aggregated health of a site's member nodes
+type SiteHealth string
+
+const (
+	SiteHealthUp       SiteHealth = "up"
+	SiteHealthDegraded SiteHealth = "degraded"
+	SiteHealthDown     SiteHealth = "down"
+	SiteHealthUnknown  SiteHealth = "unknown"
+)
+
+// Site represents one physical location containing several edge nodes
+type Site struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Region      string            `json:"region"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+	Health      SiteHealth        `json:"health"`
+	NodeCount   int               `json:"node_count"`
+	OnlineNodes int               `json:"online_nodes"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// SiteManager manages sites
+type SiteManager struct {
+	sites  map[string]*Site
+	mutex  sync.RWMutex
+	logger *logrus.Logger
+}
+
+// SiteRequest represents a site creation request
+type SiteRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Region      string            `json:"region"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// SiteNodesRequest assigns nodes to a site
+type SiteNodesRequest struct {
+	NodeIDs []string `json:"node_ids" binding:"required"`
+}
+
+// NewSiteManager creates a new site manager
+func NewSiteManager(logger *logrus.Logger) *SiteManager {
+	return &SiteManager{
+		sites:  make(map[string]*Site),
+		logger: logger,
+	}
+}
+
+// aggregateSiteHealth derives site health from its member node statuses
+func aggregateSiteHealth(total, online int) SiteHealth {
+	switch {
+	case total == 0:
+		return SiteHealthUnknown
+	case online == total:
+		return SiteHealthUp
+	case online == 0:
+		return SiteHealthDown
+	default:
+		return SiteHealthDegraded
+	}
+}
+
+// siteHealthMonitor periodically rolls node health up to sites
+func (co *CentralOrchestrator) siteHealthMonitor() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.updateSiteHealth()
+		}
+	}
+}
+
+// updateSiteHealth recomputes site health and raises or resolves site-scoped alerts
+func (co *CentralOrchestrator) updateSiteHealth() {
+	total := make(map[string]int)
+	online := make(map[string]int)
+
+	co.NodeManager.mutex.RLock()
+	for _, node := range co.NodeManager.nodes {
+		if node.SiteID == "" {
+			continue
+		}
+		total[node.SiteID]++
+		if node.Status == NodeStatusOnline {
+			online[node.SiteID]++
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	co.SiteManager.mutex.Lock()
+	defer co.SiteManager.mutex.Unlock()
+
+	for _, site := range co.SiteManager.sites {
+		health := aggregateSiteHealth(total[site.ID], online[site.ID])
+		site.NodeCount = total[site.ID]
+		site.OnlineNodes = online[site.ID]
+
+		if health != site.Health {
+			co.Logger.Infof("Site %s (%s) health changed from %s to %s", site.Name, site.ID, site.Health, health)
+			site.Health = health
+			site.UpdatedAt = time.Now()
+		}
+
+		switch health {
+		case SiteHealthDown:
+			co.AlertManager.Resolve("SiteDegraded", AlertScopeSite, site.ID)
+			co.AlertManager.Fire("SiteDown", AlertSeverityCritical, AlertScopeSite, site.ID, site.ID,
+				newMessage(MsgSiteDown, "node_count", site.NodeCount, "site", site.Name))
+		case SiteHealthDegraded:
+			co.AlertManager.Resolve("SiteDown", AlertScopeSite, site.ID)
+			co.AlertManager.Fire("SiteDegraded", AlertSeverityWarning, AlertScopeSite, site.ID, site.ID,
+				newMessage(MsgSiteDegraded, "online_nodes", site.OnlineNodes, "node_count", site.NodeCount, "site", site.Name))
+		default:
+			co.AlertManager.Resolve("SiteDown", AlertScopeSite, site.ID)
+			co.AlertManager.Resolve("SiteDegraded", AlertScopeSite, site.ID)
+		}
+	}
+}
+
+// CreateSite creates a new site
+func (co *CentralOrchestrator) CreateSite(c *gin.Context) {
+	var req SiteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	site := &Site{
+		ID:          generateID(),
+		Name:        req.Name,
+		Region:      req.Region,
+		Description: req.Description,
+		Labels:      req.Labels,
+		Health:      SiteHealthUnknown,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if site.Labels == nil {
+		site.Labels = make(map[string]string)
+	}
+	if site.Region == "" {
+		site.Region = "default"
+	}
+
+	co.SiteManager.mutex.Lock()
+	co.SiteManager.sites[site.ID] = site
+	co.SiteManager.mutex.Unlock()
+
+	co.Logger.Infof("Site %s created with ID %s", site.Name, site.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"id": site.ID, "site": site})
+}
+
+// ListSites returns all sites
+func (co *CentralOrchestrator) ListSites(c *gin.Context) {
+	co.SiteManager.mutex.RLock()
+	defer co.SiteManager.mutex.RUnlock()
+
+	sites := make([]*Site, 0, len(co.SiteManager.sites))
+	for _, site := range co.SiteManager.sites {
+		if health := c.Query("health"); health != "" && string(site.Health) != health {
+			continue
+		}
+		sites = append(sites, site)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sites": sites})
+}
+
+// GetSite returns a site together with its member nodes
+func (co *CentralOrchestrator) GetSite(c *gin.Context) {
+	siteID := c.Param("id")
+
+	co.SiteManager.mutex.RLock()
+	site, exists := co.SiteManager.sites[siteID]
+	co.SiteManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	nodes := make([]*EdgeNode, 0)
+	for _, node := range co.NodeManager.nodes {
+		if node.SiteID == siteID {
+			nodes = append(nodes, node)
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"site": site, "nodes": nodes})
+}
+
+// DeleteSite removes a site and detaches its nodes
+func (co *CentralOrchestrator) DeleteSite(c *gin.Context) {
+	siteID := c.Param("id")
+
+	co.SiteManager.mutex.Lock()
+	if _, exists := co.SiteManager.sites[siteID]; !exists {
+		co.SiteManager.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+	delete(co.SiteManager.sites, siteID)
+	co.SiteManager.mutex.Unlock()
+
+	co.NodeManager.mutex.Lock()
+	for _, node := range co.NodeManager.nodes {
+		if node.SiteID == siteID {
+			node.SiteID = ""
+			node.UpdatedAt = time.Now()
+		}
+	}
+	co.NodeManager.mutex.Unlock()
+
+	co.AlertManager.Resolve("SiteDown", AlertScopeSite, siteID)
+	co.AlertManager.Resolve("SiteDegraded", AlertScopeSite, siteID)
+
+	co.Logger.Infof("Site %s deleted", siteID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Site deleted successfully"})
+}
+
+// AssignSiteNodes moves nodes into a site
+func (co *CentralOrchestrator) AssignSiteNodes(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var req SiteNodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.SiteManager.mutex.RLock()
+	_, exists := co.SiteManager.sites[siteID]
+	co.SiteManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	for _, nodeID := range req.NodeIDs {
+		if _, exists := co.NodeManager.nodes[nodeID]; !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Node %s not found", nodeID)})
+			return
+		}
+	}
+
+	for _, nodeID := range req.NodeIDs {
+		node := co.NodeManager.nodes[nodeID]
+		node.SiteID = siteID
+		node.UpdatedAt = time.Now()
+	}
+
+	co.Logger.Infof("Assigned %d nodes to site %s", len(req.NodeIDs), siteID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Nodes assigned to site"})
+}
+
+// GetSiteAlerts returns alerts scoped to a site, leaving out silenced alerts unless
+// silenced=true
+func (co *CentralOrchestrator) GetSiteAlerts(c *gin.Context) {
+	alerts := co.AlertManager.List(AlertFilter{
+		Status: c.Query("status"),
+		SiteID: c.Param("id"),
+	})
+	alerts = co.visibleAlerts(c, alerts)
+	if c.Query("silenced") != "true" {
+		alerts = co.unsilencedAlerts(alerts)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// onePerSite keeps at most one candidate per site; nodes without a site are skipped
+func onePerSite(candidates []*EdgeNode) []*EdgeNode {
+	seen := make(map[string]bool)
+	var result []*EdgeNode
+	for _, node := range candidates {
+		if node.SiteID == "" || seen[node.SiteID] {
+			continue
+		}
+		seen[node.SiteID] = true
+		result = append(result, node)
+	}
+	return result
+}
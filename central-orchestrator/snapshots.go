@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Backup scheduler interval
+	BackupCheckInterval = 1 * time.Minute
+
+	// How long migration volume hooks wait for a snapshot or restore task
+	VolumeTaskTimeout = 30 * time.Minute
+)
+
+// WorkloadVolume is a persistent volume claimed by a stateful workload
+type WorkloadVolume struct {
+	Name         string `json:"name"`
+	MountPath    string `json:"mount_path"`
+	Size         string `json:"size"`
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+// SnapshotMethod defines how an agent captures volume data
+type SnapshotMethod string
+
+const (
+	// CSI VolumeSnapshot on the edge cluster
+	SnapshotMethodCSI SnapshotMethod = "csi"
+	// File-level copy to the backup target
+	SnapshotMethodFile SnapshotMethod = "file"
+)
+
+// BackupTarget is where file-level backups are stored
+type BackupTarget struct {
+	// "s3" or "nfs"
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	Region   string `json:"region,omitempty"`
+}
+
+// BackupPolicy schedules snapshots of a workload's volumes and bounds how many are kept
+type BackupPolicy struct {
+	Method          SnapshotMethod `json:"method"`
+	Target          BackupTarget   `json:"target"`
+	IntervalMinutes int            `json:"interval_minutes"`
+	RetentionCount  int            `json:"retention_count"`
+	RetentionDays   int            `json:"retention_days"`
+}
+
+// VolumeTaskType is the action an agent performs on workload volumes
+type VolumeTaskType string
+
+const (
+	VolumeTaskSnapshot VolumeTaskType = "snapshot"
+	VolumeTaskRestore  VolumeTaskType = "restore"
+	VolumeTaskDelete   VolumeTaskType = "delete"
+)
+
+// VolumeTaskStatus represents the progress of a volume task
+type VolumeTaskStatus string
+
+const (
+	VolumeTaskPending   VolumeTaskStatus = "pending"
+	VolumeTaskRunning   VolumeTaskStatus = "running"
+	VolumeTaskCompleted VolumeTaskStatus = "completed"
+	VolumeTaskFailed    VolumeTaskStatus = "failed"
+)
+
+// Snapshot is a point-in-time copy of a workload's volumes
+type Snapshot struct {
+	ID          string           `json:"id"`
+	WorkloadID  string           `json:"workload_id"`
+	NodeID      string           `json:"node_id"`
+	Method      SnapshotMethod   `json:"method"`
+	Target      BackupTarget     `json:"target"`
+	Volumes     []WorkloadVolume `json:"volumes"`
+	Location    string           `json:"location,omitempty"`
+	SizeBytes   int64            `json:"size_bytes"`
+	Status      VolumeTaskStatus `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	Deleted     bool             `json:"deleted"`
+	CreatedAt   time.Time        `json:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+}
+
+// VolumeTask is work queued for an agent: take, restore, or delete a snapshot
+type VolumeTask struct {
+	ID           string           `json:"id"`
+	Type         VolumeTaskType   `json:"type"`
+	NodeID       string           `json:"node_id"`
+	SnapshotID   string           `json:"snapshot_id"`
+	WorkloadID   string           `json:"workload_id"`
+	WorkloadName string           `json:"workload_name"`
+	Namespace    string           `json:"namespace"`
+	Method       SnapshotMethod   `json:"method"`
+	Target       BackupTarget     `json:"target"`
+	Volumes      []WorkloadVolume `json:"volumes"`
+	Location     string           `json:"location,omitempty"`
+	Status       VolumeTaskStatus `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// VolumeTaskStatusRequest is an agent's progress report for a volume task
+type VolumeTaskStatusRequest struct {
+	Status    VolumeTaskStatus `json:"status" binding:"required"`
+	Location  string           `json:"location"`
+	SizeBytes int64            `json:"size_bytes"`
+	Error     string           `json:"error"`
+}
+
+// RestoreSnapshotRequest restores a snapshot onto a node
+type RestoreSnapshotRequest struct {
+	NodeID string `json:"node_id" binding:"required"`
+}
+
+// SnapshotManager tracks snapshots and the agent tasks that produce them
+type SnapshotManager struct {
+	snapshots map[string]*Snapshot
+	tasks     map[string]*VolumeTask
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewSnapshotManager creates a new snapshot manager
+func NewSnapshotManager(logger *logrus.Logger) *SnapshotManager {
+	return &SnapshotManager{
+		snapshots: make(map[string]*Snapshot),
+		tasks:     make(map[string]*VolumeTask),
+		logger:    logger,
+	}
+}
+
+// newTask queues a volume task for a node; callers must hold the lock
+func (sm *SnapshotManager) newTask(taskType VolumeTaskType, nodeID string, snapshot *Snapshot, workload *Workload) *VolumeTask {
+	now := time.Now()
+	task := &VolumeTask{
+		ID:           generateID(),
+		Type:         taskType,
+		NodeID:       nodeID,
+		SnapshotID:   snapshot.ID,
+		WorkloadID:   workload.ID,
+		WorkloadName: workload.Name,
+		Namespace:    workload.Namespace,
+		Method:       snapshot.Method,
+		Target:       snapshot.Target,
+		Volumes:      snapshot.Volumes,
+		Location:     snapshot.Location,
+		Status:       VolumeTaskPending,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	sm.tasks[task.ID] = task
+	return task
+}
+
+// TakeSnapshot queues a snapshot of a workload's volumes on the given node
+func (sm *SnapshotManager) TakeSnapshot(workload *Workload, nodeID string) (*Snapshot, *VolumeTask, error) {
+	if len(workload.Volumes) == 0 {
+		return nil, nil, fmt.Errorf("workload %s has no volumes", workload.Name)
+	}
+
+	policy := workload.Backup
+	if policy == nil {
+		policy = &BackupPolicy{Method: SnapshotMethodCSI}
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	snapshot := &Snapshot{
+		ID:         generateID(),
+		WorkloadID: workload.ID,
+		NodeID:     nodeID,
+		Method:     policy.Method,
+		Target:     policy.Target,
+		Volumes:    workload.Volumes,
+		Status:     VolumeTaskPending,
+		CreatedAt:  time.Now(),
+	}
+	sm.snapshots[snapshot.ID] = snapshot
+
+	task := sm.newTask(VolumeTaskSnapshot, nodeID, snapshot, workload)
+	sm.logger.Infof("Snapshot %s of workload %s queued on node %s", snapshot.ID, workload.Name, nodeID)
+	return snapshot, task, nil
+}
+
+// RestoreSnapshot queues a restore of a completed snapshot onto a node
+func (sm *SnapshotManager) RestoreSnapshot(snapshotID string, workload *Workload, nodeID string) (*VolumeTask, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	snapshot, exists := sm.snapshots[snapshotID]
+	if !exists || snapshot.Deleted {
+		return nil, fmt.Errorf("snapshot %s not found", snapshotID)
+	}
+	if snapshot.Status != VolumeTaskCompleted {
+		return nil, fmt.Errorf("snapshot %s is not complete", snapshotID)
+	}
+	if snapshot.Method == SnapshotMethodCSI && snapshot.NodeID != nodeID {
+		return nil, fmt.Errorf("CSI snapshots can only be restored on the node that took them")
+	}
+
+	task := sm.newTask(VolumeTaskRestore, nodeID, snapshot, workload)
+	sm.logger.Infof("Restore of snapshot %s queued on node %s", snapshotID, nodeID)
+	return task, nil
+}
+
+// waitForTask blocks until a task completes or fails
+func (sm *SnapshotManager) waitForTask(ctx context.Context, taskID string) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		sm.mutex.RLock()
+		task := sm.tasks[taskID]
+		status, taskErr := task.Status, task.Error
+		sm.mutex.RUnlock()
+
+		switch status {
+		case VolumeTaskCompleted:
+			return nil
+		case VolumeTaskFailed:
+			return fmt.Errorf("volume task %s failed: %s", taskID, taskErr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("volume task %s did not finish: %v", taskID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// backupScheduler takes scheduled snapshots and enforces retention
+func (co *CentralOrchestrator) backupScheduler() {
+	ticker := time.NewTicker(BackupCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.runScheduledBackups()
+			co.enforceSnapshotRetention()
+		}
+	}
+}
+
+// runScheduledBackups queues snapshots for workloads whose backup interval has elapsed
+func (co *CentralOrchestrator) runScheduledBackups() {
+	co.SnapshotManager.mutex.RLock()
+	lastSnapshot := make(map[string]time.Time)
+	for _, snapshot := range co.SnapshotManager.snapshots {
+		if snapshot.Status != VolumeTaskFailed && snapshot.CreatedAt.After(lastSnapshot[snapshot.WorkloadID]) {
+			lastSnapshot[snapshot.WorkloadID] = snapshot.CreatedAt
+		}
+	}
+	co.SnapshotManager.mutex.RUnlock()
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	for _, workload := range co.WorkloadManager.workloads {
+		policy := workload.Backup
+		if policy == nil || policy.IntervalMinutes <= 0 || len(workload.Volumes) == 0 {
+			continue
+		}
+		if time.Since(lastSnapshot[workload.ID]) < time.Duration(policy.IntervalMinutes)*time.Minute {
+			continue
+		}
+		for _, deployment := range workload.Deployments {
+			if deployment.Status == WorkloadStatusRunning {
+				if _, _, err := co.SnapshotManager.TakeSnapshot(workload, deployment.NodeID); err != nil {
+					co.Logger.Errorf("Failed to schedule snapshot of workload %s: %v", workload.Name, err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// enforceSnapshotRetention deletes completed snapshots beyond each workload's retention policy
+func (co *CentralOrchestrator) enforceSnapshotRetention() {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	sm := co.SnapshotManager
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	byWorkload := make(map[string][]*Snapshot)
+	for _, snapshot := range sm.snapshots {
+		if snapshot.Status == VolumeTaskCompleted && !snapshot.Deleted {
+			byWorkload[snapshot.WorkloadID] = append(byWorkload[snapshot.WorkloadID], snapshot)
+		}
+	}
+
+	for workloadID, snapshots := range byWorkload {
+		workload, exists := co.WorkloadManager.workloads[workloadID]
+		if !exists || workload.Backup == nil {
+			continue
+		}
+		policy := workload.Backup
+
+		sort.Slice(snapshots, func(i, j int) bool {
+			return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+		})
+
+		for i, snapshot := range snapshots {
+			expired := policy.RetentionDays > 0 && time.Since(snapshot.CreatedAt) > time.Duration(policy.RetentionDays)*24*time.Hour
+			excess := policy.RetentionCount > 0 && i >= policy.RetentionCount
+			if !expired && !excess {
+				continue
+			}
+			snapshot.Deleted = true
+			sm.newTask(VolumeTaskDelete, snapshot.NodeID, snapshot, workload)
+			co.Logger.Infof("Snapshot %s of workload %s expired by retention policy", snapshot.ID, workload.Name)
+		}
+	}
+}
+
+// snapshotVolumeHook moves volume data during migrations by snapshotting the source and
+// restoring onto the destination
+type snapshotVolumeHook struct {
+	co *CentralOrchestrator
+}
+
+func (h *snapshotVolumeHook) PrepareVolumes(ctx context.Context, migration *Migration, workload *Workload) error {
+	if len(workload.Volumes) == 0 {
+		return nil
+	}
+	if workload.Backup == nil || workload.Backup.Method != SnapshotMethodFile {
+		return fmt.Errorf("moving volumes between nodes requires a file-level backup policy")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, VolumeTaskTimeout)
+	defer cancel()
+
+	sm := h.co.SnapshotManager
+	snapshot, task, err := sm.TakeSnapshot(workload, migration.SourceNodeID)
+	if err != nil {
+		return err
+	}
+	if err := sm.waitForTask(ctx, task.ID); err != nil {
+		return err
+	}
+
+	restore, err := sm.RestoreSnapshot(snapshot.ID, workload, migration.DestinationNodeID)
+	if err != nil {
+		return err
+	}
+	return sm.waitForTask(ctx, restore.ID)
+}
+
+func (h *snapshotVolumeHook) FinalizeVolumes(ctx context.Context, migration *Migration, workload *Workload) error {
+	return nil
+}
+
+// GetNodeVolumeTasks returns pending and running volume tasks for an agent
+func (co *CentralOrchestrator) GetNodeVolumeTasks(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	co.SnapshotManager.mutex.RLock()
+	defer co.SnapshotManager.mutex.RUnlock()
+
+	tasks := make([]*VolumeTask, 0)
+	for _, task := range co.SnapshotManager.tasks {
+		if task.NodeID == nodeID && (task.Status == VolumeTaskPending || task.Status == VolumeTaskRunning) {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"tasks": tasks})
+}
+
+// ReportVolumeTaskStatus records an agent's progress on a volume task
+func (co *CentralOrchestrator) ReportVolumeTaskStatus(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req VolumeTaskStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sm := co.SnapshotManager
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	task, exists := sm.tasks[c.Param("tid")]
+	if !exists || task.NodeID != nodeID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Volume task not found"})
+		return
+	}
+
+	now := time.Now()
+	task.Status = req.Status
+	task.Error = req.Error
+	task.UpdatedAt = now
+
+	if snapshot, exists := sm.snapshots[task.SnapshotID]; exists && task.Type == VolumeTaskSnapshot {
+		snapshot.Status = req.Status
+		snapshot.Error = req.Error
+		if req.Location != "" {
+			snapshot.Location = req.Location
+		}
+		if req.SizeBytes > 0 {
+			snapshot.SizeBytes = req.SizeBytes
+		}
+		if req.Status == VolumeTaskCompleted {
+			snapshot.CompletedAt = &now
+		}
+	}
+
+	if req.Status == VolumeTaskFailed {
+		co.Logger.Errorf("Volume task %s (%s) failed on node %s: %s", task.ID, task.Type, nodeID, req.Error)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Volume task updated"})
+}
+
+// CreateWorkloadSnapshot takes an on-demand snapshot of a workload
+func (co *CentralOrchestrator) CreateWorkloadSnapshot(c *gin.Context) {
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	for _, deployment := range workload.Deployments {
+		if deployment.Status != WorkloadStatusRunning {
+			continue
+		}
+		snapshot, _, err := co.SnapshotManager.TakeSnapshot(workload, deployment.NodeID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"snapshot": snapshot})
+		return
+	}
+
+	c.JSON(http.StatusConflict, gin.H{"error": "Workload has no running deployment to snapshot"})
+}
+
+// ListSnapshots returns snapshots, optionally filtered by workload_id
+func (co *CentralOrchestrator) ListSnapshots(c *gin.Context) {
+	view := co.tenantView(c)
+	co.SnapshotManager.mutex.RLock()
+	defer co.SnapshotManager.mutex.RUnlock()
+
+	snapshots := make([]*Snapshot, 0)
+	for _, snapshot := range co.SnapshotManager.snapshots {
+		if workloadID := c.Query("workload_id"); workloadID != "" && snapshot.WorkloadID != workloadID {
+			continue
+		}
+		if snapshot.Deleted && c.Query("include_deleted") != "true" {
+			continue
+		}
+		if !view.workload(snapshot.WorkloadID) {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// RestoreSnapshotHandler restores a snapshot onto a node for disaster recovery
+func (co *CentralOrchestrator) RestoreSnapshotHandler(c *gin.Context) {
+	var req RestoreSnapshotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.SnapshotManager.mutex.RLock()
+	snapshot, exists := co.SnapshotManager.snapshots[c.Param("id")]
+	var workloadID string
+	if exists {
+		workloadID = snapshot.WorkloadID
+	}
+	co.SnapshotManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+
+	view := co.tenantView(c)
+	if !view.workload(workloadID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	if !view.node(req.NodeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	workload, exists := co.WorkloadManager.workloads[workloadID]
+	co.WorkloadManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	task, err := co.SnapshotManager.RestoreSnapshot(c.Param("id"), workload, req.NodeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"task": task})
+}
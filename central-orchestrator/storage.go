@@ -0,0 +1,866 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How often in-memory state is written to the store when STORAGE_SYNC_INTERVAL is unset
+	DefaultStorageSyncInterval = 5 * time.Second
+
+	// Upper bound on a single sync or load against the store
+	StorageTimeout = 30 * time.Second
+
+	// Database file of the sqlite backend when STORAGE_DSN is unset
+	DefaultSQLitePath = "/var/lib/edge-orchestrator/state.db"
+
+	// Lifetime of a node's heartbeat lease, matching when the health check marks it offline
+	HeartbeatLeaseTTL = 2 * time.Minute
+)
+
+// Kinds of records kept in the store
+const (
+	StateKindNodes         = "nodes"
+	StateKindWorkloads     = "workloads"
+	StateKindCertificates  = "certificates"
+	StateKindClusters      = "clusters"
+	StateKindInterop       = "interop_adapters"
+	StateKindOCMHubs       = "ocm_hubs"
+	StateKindEnvironments  = "environments"
+	StateKindDefinitions   = "workload_definitions"
+	StateKindRevisions     = "workload_revisions"
+	StateKindNamespaces    = "namespaces"
+	StateKindOrganizations = "organizations"
+	StateKindProjects      = "projects"
+)
+
+// Bookkeeping records that are not restored into managers. The leader stamps
+// stateSyncMarker on every sync so replicas can tell how far behind they are.
+const (
+	StateKindMeta   = "meta"
+	stateSyncMarker = "sync"
+)
+
+// stateSyncStamp is the data of the sync marker
+type stateSyncStamp struct {
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// stateKinds lists every kind, in the order they are restored
+var stateKinds = []string{StateKindCertificates, StateKindNodes, StateKindClusters, StateKindInterop, StateKindOCMHubs, StateKindEnvironments, StateKindDefinitions, StateKindRevisions, StateKindOrganizations, StateKindProjects, StateKindNamespaces, StateKindWorkloads}
+
+// StateChange writes one record to the store, or deletes it when Data is nil
+type StateChange struct {
+	Kind string
+	ID   string
+	Data []byte
+}
+
+// Store persists orchestrator state as JSON records keyed by kind and ID
+type Store interface {
+	// Backend names the implementation, such as "sqlite" or "postgres"
+	Backend() string
+	// Apply writes a batch of changes atomically
+	Apply(ctx context.Context, changes []StateChange) error
+	// Load returns every record of a kind by ID
+	Load(ctx context.Context, kind string) (map[string][]byte, error)
+	Close() error
+}
+
+// HeartbeatLeaser is implemented by stores that keep node heartbeats as expiring
+// leases, so liveness survives a change of leader
+type HeartbeatLeaser interface {
+	// RecordHeartbeat renews the node's lease for ttl
+	RecordHeartbeat(ctx context.Context, nodeID string, ttl time.Duration) error
+	// ExpiredHeartbeats reports nodes whose lease expired
+	ExpiredHeartbeats(ctx context.Context) <-chan string
+}
+
+// memoryStore keeps records in memory. It is the default and keeps the previous
+// behaviour of losing state on restart, but still serves backups.
+type memoryStore struct {
+	records map[string]map[string][]byte
+	mutex   sync.RWMutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]map[string][]byte)}
+}
+
+func (ms *memoryStore) Backend() string {
+	return "memory"
+}
+
+func (ms *memoryStore) Apply(_ context.Context, changes []StateChange) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, change := range changes {
+		if ms.records[change.Kind] == nil {
+			ms.records[change.Kind] = make(map[string][]byte)
+		}
+		if change.Data == nil {
+			delete(ms.records[change.Kind], change.ID)
+		} else {
+			ms.records[change.Kind][change.ID] = change.Data
+		}
+	}
+	return nil
+}
+
+func (ms *memoryStore) Load(_ context.Context, kind string) (map[string][]byte, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	records := make(map[string][]byte, len(ms.records[kind]))
+	for id, data := range ms.records[kind] {
+		records[id] = data
+	}
+	return records, nil
+}
+
+func (ms *memoryStore) Close() error {
+	return nil
+}
+
+// StateStore mirrors node, workload and certificate state into a Store so it survives
+// restarts. Changes are written in batches rather than on every mutation; anything
+// changed within the last sync interval before a crash is lost.
+type StateStore struct {
+	store    Store
+	interval time.Duration
+	// Read replicas never write, so they cannot migrate the schema either
+	readOnly bool
+	// Hash of each record as last written, by kind and ID
+	written map[string]map[string][32]byte
+	// Nodes whose heartbeat lease is due for renewal, for stores that lease heartbeats
+	heartbeats chan string
+	lastSync   time.Time
+	lastErr    error
+	// When a follower last read the store, and when the leader had last synced to it
+	lastRefresh    time.Time
+	leaderSyncedAt time.Time
+	// Serializes syncs so batches are applied in order
+	syncMutex sync.Mutex
+	mutex     sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewStateStore opens the store selected by STORAGE_BACKEND ("memory", "sqlite",
+// "postgres" or "etcd") with the connection string in STORAGE_DSN; for etcd it is a
+// comma-separated list of endpoints
+func NewStateStore(logger *logrus.Logger) (*StateStore, error) {
+	interval := DefaultStorageSyncInterval
+	if value := os.Getenv("STORAGE_SYNC_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid STORAGE_SYNC_INTERVAL %q", value)
+		}
+		interval = parsed
+	}
+
+	// Read replicas only read, and may be pointed at a read-only database
+	readOnly := os.Getenv("STORAGE_READ_ONLY") == "true"
+	store, err := openStore(os.Getenv("STORAGE_BACKEND"), os.Getenv("STORAGE_DSN"), readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Persisting orchestrator state to the %s store every %s", store.Backend(), interval)
+	return &StateStore{
+		store:      store,
+		interval:   interval,
+		readOnly:   readOnly,
+		written:    make(map[string]map[string][32]byte),
+		heartbeats: make(chan string, 1024),
+		logger:     logger,
+	}, nil
+}
+
+// openStore opens a store by backend name. SQLite needs no server, so a small
+// deployment can run on a single database file and move to Postgres or etcd later
+// with "central-orchestrator migrate-storage".
+func openStore(backend, dsn string, readOnly bool) (Store, error) {
+	switch backend {
+	case "", "memory":
+		return newMemoryStore(), nil
+	case "sqlite":
+		if dsn == "" {
+			dsn = DefaultSQLitePath
+		}
+		return openSQLStore(SQLDialectSQLite, dsn, readOnly)
+	case "postgres":
+		if dsn == "" {
+			return nil, fmt.Errorf("STORAGE_DSN is required for the postgres backend")
+		}
+		return openSQLStore(SQLDialectPostgres, dsn, readOnly)
+	case "etcd":
+		if dsn == "" {
+			return nil, fmt.Errorf("STORAGE_DSN is required for the etcd backend")
+		}
+		return openEtcdStore(dsn)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// stateSnapshot marshals every record of each kind, holding each manager's lock only
+// while its own records are encoded
+func (co *CentralOrchestrator) stateSnapshot() (map[string]map[string][]byte, error) {
+	snapshot := make(map[string]map[string][]byte, len(stateKinds))
+	for _, kind := range stateKinds {
+		snapshot[kind] = make(map[string][]byte)
+	}
+
+	var err error
+	co.NodeManager.mutex.RLock()
+	for id, node := range co.NodeManager.nodes {
+		if snapshot[StateKindNodes][id], err = json.Marshal(node); err != nil {
+			break
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode nodes: %v", err)
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	for id, workload := range co.WorkloadManager.workloads {
+		if snapshot[StateKindWorkloads][id], err = json.Marshal(workload); err != nil {
+			break
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workloads: %v", err)
+	}
+
+	co.SecurityManager.mutex.RLock()
+	for id, cert := range co.SecurityManager.certificates {
+		if snapshot[StateKindCertificates][id], err = json.Marshal(cert); err != nil {
+			break
+		}
+	}
+	co.SecurityManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificates: %v", err)
+	}
+
+	co.ImportedClusters.mutex.RLock()
+	for id, cluster := range co.ImportedClusters.clusters {
+		if snapshot[StateKindClusters][id], err = json.Marshal(cluster); err != nil {
+			break
+		}
+	}
+	co.ImportedClusters.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode clusters: %v", err)
+	}
+
+	co.Interop.mutex.RLock()
+	for id, adapter := range co.Interop.adapters {
+		if snapshot[StateKindInterop][id], err = json.Marshal(adapter); err != nil {
+			break
+		}
+	}
+	co.Interop.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode interop adapters: %v", err)
+	}
+
+	co.OCMHubs.mutex.RLock()
+	for id, hub := range co.OCMHubs.hubs {
+		if snapshot[StateKindOCMHubs][id], err = json.Marshal(hub); err != nil {
+			break
+		}
+	}
+	co.OCMHubs.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode OCM hubs: %v", err)
+	}
+
+	co.EnvironmentManager.mutex.RLock()
+	for name, environment := range co.EnvironmentManager.environments {
+		if snapshot[StateKindEnvironments][name], err = json.Marshal(environment); err != nil {
+			break
+		}
+	}
+	co.EnvironmentManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode environments: %v", err)
+	}
+
+	co.EnvironmentManager.mutex.RLock()
+	for id, definition := range co.EnvironmentManager.definitions {
+		if snapshot[StateKindDefinitions][id], err = json.Marshal(definition); err != nil {
+			break
+		}
+	}
+	co.EnvironmentManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workload definitions: %v", err)
+	}
+
+	co.RevisionHistory.mutex.RLock()
+	for id, history := range co.RevisionHistory.revisions {
+		if snapshot[StateKindRevisions][id], err = json.Marshal(history); err != nil {
+			break
+		}
+	}
+	co.RevisionHistory.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workload revisions: %v", err)
+	}
+
+	co.OrganizationManager.mutex.RLock()
+	for name, organization := range co.OrganizationManager.organizations {
+		if snapshot[StateKindOrganizations][name], err = json.Marshal(organization); err != nil {
+			break
+		}
+	}
+	co.OrganizationManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode organizations: %v", err)
+	}
+
+	co.OrganizationManager.mutex.RLock()
+	for name, project := range co.OrganizationManager.projects {
+		if snapshot[StateKindProjects][name], err = json.Marshal(project); err != nil {
+			break
+		}
+	}
+	co.OrganizationManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode projects: %v", err)
+	}
+
+	co.NamespaceManager.mutex.RLock()
+	for name, namespace := range co.NamespaceManager.namespaces {
+		if snapshot[StateKindNamespaces][name], err = json.Marshal(namespace); err != nil {
+			break
+		}
+	}
+	co.NamespaceManager.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode namespaces: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// persistState writes records that changed since the last sync and deletes removed ones
+func (co *CentralOrchestrator) persistState() error {
+	ss := co.StateStore
+	ss.syncMutex.Lock()
+	defer ss.syncMutex.Unlock()
+
+	err := co.syncState()
+
+	ss.mutex.Lock()
+	ss.lastErr = err
+	if err == nil {
+		ss.lastSync = time.Now()
+	}
+	ss.mutex.Unlock()
+	return err
+}
+
+// syncState diffs a snapshot against what was last written; callers must hold syncMutex
+func (co *CentralOrchestrator) syncState() error {
+	ss := co.StateStore
+	snapshot, err := co.stateSnapshot()
+	if err != nil {
+		return err
+	}
+
+	var changes []StateChange
+	hashes := make(map[string]map[string][32]byte, len(snapshot))
+	for _, kind := range stateKinds {
+		hashes[kind] = make(map[string][32]byte, len(snapshot[kind]))
+		for id, data := range snapshot[kind] {
+			hash := sha256.Sum256(data)
+			hashes[kind][id] = hash
+			if previous, exists := ss.written[kind][id]; !exists || previous != hash {
+				changes = append(changes, StateChange{Kind: kind, ID: id, Data: data})
+			}
+		}
+		for id := range ss.written[kind] {
+			if _, exists := snapshot[kind][id]; !exists {
+				changes = append(changes, StateChange{Kind: kind, ID: id})
+			}
+		}
+	}
+	stamp, err := json.Marshal(stateSyncStamp{SyncedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync marker: %v", err)
+	}
+	changes = append(changes, StateChange{Kind: StateKindMeta, ID: stateSyncMarker, Data: stamp})
+
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+	if err := ss.store.Apply(ctx, changes); err != nil {
+		return fmt.Errorf("failed to write %d state changes: %v", len(changes), err)
+	}
+	ss.written = hashes
+	return nil
+}
+
+// restoreState loads persisted nodes, workloads and certificates into their managers.
+// It must run before the server starts serving requests.
+func (co *CentralOrchestrator) restoreState() error {
+	ss := co.StateStore
+	ss.syncMutex.Lock()
+	defer ss.syncMutex.Unlock()
+
+	if path := os.Getenv("STORAGE_RESTORE_FROM"); path != "" {
+		if err := ss.importBackup(path); err != nil {
+			return err
+		}
+	}
+
+	if err := ss.migrateSchema(); err != nil {
+		return err
+	}
+
+	nodes, workloads, certificates, err := co.loadState(false)
+	if err != nil {
+		return err
+	}
+	co.Logger.Infof("Restored %d nodes, %d workloads and %d certificates from the %s store",
+		nodes, workloads, certificates, ss.store.Backend())
+	return nil
+}
+
+// refreshState replaces in-memory state with what the store holds, so followers serve
+// what the leader last wrote
+func (co *CentralOrchestrator) refreshState() error {
+	ss := co.StateStore
+	ss.syncMutex.Lock()
+	defer ss.syncMutex.Unlock()
+
+	if _, _, _, err := co.loadState(true); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+	meta, err := ss.store.Load(ctx, StateKindMeta)
+	if err != nil {
+		return fmt.Errorf("failed to load sync marker: %v", err)
+	}
+	var stamp stateSyncStamp
+	if data, exists := meta[stateSyncMarker]; exists {
+		if err := json.Unmarshal(data, &stamp); err != nil {
+			return fmt.Errorf("failed to decode sync marker: %v", err)
+		}
+	}
+
+	ss.mutex.Lock()
+	ss.lastRefresh = time.Now()
+	ss.leaderSyncedAt = stamp.SyncedAt
+	ss.mutex.Unlock()
+	return nil
+}
+
+// loadState loads every record from the store into the managers, dropping records the
+// store no longer holds when replace is set, and returns how many nodes, workloads and
+// certificates were loaded. Callers must hold syncMutex.
+func (co *CentralOrchestrator) loadState(replace bool) (int, int, int, error) {
+	ss := co.StateStore
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+
+	records := make(map[string]map[string][]byte, len(stateKinds))
+	for _, kind := range stateKinds {
+		loaded, err := ss.store.Load(ctx, kind)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to load %s: %v", kind, err)
+		}
+		records[kind] = loaded
+	}
+
+	certificates := make(map[string]*Certificate, len(records[StateKindCertificates]))
+	for id, data := range records[StateKindCertificates] {
+		cert := &Certificate{}
+		if err := json.Unmarshal(data, cert); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode certificate %s: %v", id, err)
+		}
+		certificates[id] = cert
+	}
+	nodes := make(map[string]*EdgeNode, len(records[StateKindNodes]))
+	for id, data := range records[StateKindNodes] {
+		node := &EdgeNode{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode node %s: %v", id, err)
+		}
+		nodes[id] = node
+	}
+	clusters := make(map[string]*ImportedCluster, len(records[StateKindClusters]))
+	for id, data := range records[StateKindClusters] {
+		cluster := &ImportedCluster{}
+		if err := json.Unmarshal(data, cluster); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode cluster %s: %v", id, err)
+		}
+		clusters[id] = cluster
+	}
+	adapters := make(map[string]*InteropAdapter, len(records[StateKindInterop]))
+	for id, data := range records[StateKindInterop] {
+		adapter := &InteropAdapter{}
+		if err := json.Unmarshal(data, adapter); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode interop adapter %s: %v", id, err)
+		}
+		adapters[id] = adapter
+	}
+	hubs := make(map[string]*OCMHub, len(records[StateKindOCMHubs]))
+	for id, data := range records[StateKindOCMHubs] {
+		hub := &OCMHub{}
+		if err := json.Unmarshal(data, hub); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode OCM hub %s: %v", id, err)
+		}
+		hubs[id] = hub
+	}
+	environments := make(map[string]*Environment, len(records[StateKindEnvironments]))
+	for name, data := range records[StateKindEnvironments] {
+		environment := &Environment{}
+		if err := json.Unmarshal(data, environment); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode environment %s: %v", name, err)
+		}
+		environments[name] = environment
+	}
+	definitions := make(map[string]*WorkloadDefinition, len(records[StateKindDefinitions]))
+	for id, data := range records[StateKindDefinitions] {
+		definition := &WorkloadDefinition{}
+		if err := json.Unmarshal(data, definition); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode workload definition %s: %v", id, err)
+		}
+		definitions[id] = definition
+	}
+	revisions := make(map[string][]*WorkloadRevision, len(records[StateKindRevisions]))
+	for id, data := range records[StateKindRevisions] {
+		var history []*WorkloadRevision
+		if err := json.Unmarshal(data, &history); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode revisions of workload %s: %v", id, err)
+		}
+		revisions[id] = history
+	}
+	organizations := make(map[string]*Organization, len(records[StateKindOrganizations]))
+	for name, data := range records[StateKindOrganizations] {
+		organization := &Organization{}
+		if err := json.Unmarshal(data, organization); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode organization %s: %v", name, err)
+		}
+		organizations[name] = organization
+	}
+	projects := make(map[string]*Project, len(records[StateKindProjects]))
+	for name, data := range records[StateKindProjects] {
+		project := &Project{}
+		if err := json.Unmarshal(data, project); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode project %s: %v", name, err)
+		}
+		projects[name] = project
+	}
+	namespaces := make(map[string]*Namespace, len(records[StateKindNamespaces]))
+	for name, data := range records[StateKindNamespaces] {
+		namespace := &Namespace{}
+		if err := json.Unmarshal(data, namespace); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode namespace %s: %v", name, err)
+		}
+		namespaces[name] = namespace
+	}
+	workloads := make(map[string]*Workload, len(records[StateKindWorkloads]))
+	for id, data := range records[StateKindWorkloads] {
+		workload := &Workload{}
+		if err := json.Unmarshal(data, workload); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to decode workload %s: %v", id, err)
+		}
+		workloads[id] = workload
+	}
+
+	co.SecurityManager.mutex.Lock()
+	if replace {
+		co.SecurityManager.certificates = make(map[string]*Certificate, len(certificates))
+		co.SecurityManager.fingerprints = make(map[string]string, len(certificates))
+	}
+	for id, cert := range certificates {
+		co.SecurityManager.certificates[id] = cert
+		if block, _ := pem.Decode(cert.Certificate); block != nil {
+			co.SecurityManager.fingerprints[certificateFingerprint(block.Bytes)] = id
+		}
+	}
+	co.SecurityManager.mutex.Unlock()
+
+	co.NodeManager.mutex.Lock()
+	if replace {
+		co.NodeManager.nodes = make(map[string]*EdgeNode, len(nodes))
+	}
+	for id, node := range nodes {
+		co.NodeManager.nodes[id] = node
+	}
+	co.NodeManager.mutex.Unlock()
+
+	co.ImportedClusters.restore(clusters, replace)
+	co.Interop.restore(adapters, replace)
+	co.OCMHubs.restore(hubs, replace)
+	co.EnvironmentManager.restore(environments, definitions, replace)
+	co.RevisionHistory.restore(revisions, replace)
+	co.OrganizationManager.restore(organizations, projects, replace)
+	co.NamespaceManager.restore(namespaces, replace)
+
+	co.WorkloadManager.mutex.Lock()
+	if replace {
+		co.WorkloadManager.workloads = make(map[string]*Workload, len(workloads))
+	}
+	for id, workload := range workloads {
+		co.WorkloadManager.workloads[id] = workload
+	}
+	co.WorkloadManager.mutex.Unlock()
+
+	// What was loaded is what the store holds, so the first sync only writes changes
+	for _, kind := range stateKinds {
+		ss.written[kind] = make(map[string][32]byte, len(records[kind]))
+		for id, data := range records[kind] {
+			ss.written[kind][id] = sha256.Sum256(data)
+		}
+	}
+
+	return len(nodes), len(workloads), len(certificates), nil
+}
+
+// stateSyncLoop periodically writes state to the store, or on followers reads back
+// what the leader wrote
+func (co *CentralOrchestrator) stateSyncLoop() {
+	ticker := time.NewTicker(co.StateStore.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !co.LeaderElection.IsLeader() {
+				if err := co.refreshState(); err != nil {
+					co.Logger.Errorf("Failed to refresh state: %v", err)
+				}
+				continue
+			}
+			if err := co.persistState(); err != nil {
+				co.Logger.Errorf("Failed to persist state: %v", err)
+			}
+		}
+	}
+}
+
+// recordHeartbeatLease queues a renewal of the node's heartbeat lease. It never blocks
+// the heartbeat path; a renewal dropped while the queue is full is made up by the
+// node's next heartbeat.
+func (ss *StateStore) recordHeartbeatLease(nodeID string) {
+	if _, ok := ss.store.(HeartbeatLeaser); !ok {
+		return
+	}
+	select {
+	case ss.heartbeats <- nodeID:
+	default:
+	}
+}
+
+// heartbeatLeaseLoop renews heartbeat leases and marks nodes offline as soon as their
+// lease expires, rather than waiting for the next health check
+func (co *CentralOrchestrator) heartbeatLeaseLoop() {
+	leaser, ok := co.StateStore.store.(HeartbeatLeaser)
+	if !ok {
+		return
+	}
+
+	expired := leaser.ExpiredHeartbeats(context.Background())
+	for {
+		select {
+		case nodeID := <-co.StateStore.heartbeats:
+			ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+			if err := leaser.RecordHeartbeat(ctx, nodeID, HeartbeatLeaseTTL); err != nil {
+				co.Logger.Warnf("Failed to renew heartbeat lease of node %s: %v", nodeID, err)
+			}
+			cancel()
+		case nodeID, ok := <-expired:
+			if !ok {
+				co.Logger.Warn("Heartbeat lease watch closed, restarting")
+				expired = leaser.ExpiredHeartbeats(context.Background())
+				continue
+			}
+			co.expireHeartbeat(nodeID)
+		}
+	}
+}
+
+// expireHeartbeat marks a node offline when its heartbeat lease expires. A heartbeat
+// received since, whose renewal is still queued, keeps the node online.
+func (co *CentralOrchestrator) expireHeartbeat(nodeID string) {
+	co.NodeManager.mutex.Lock()
+	defer co.NodeManager.mutex.Unlock()
+
+	node, exists := co.NodeManager.nodes[nodeID]
+	if !exists || node.Status == NodeStatusOffline || node.Status == NodeStatusMaintenance || time.Since(node.LastHeartbeat) < HeartbeatLeaseTTL {
+		return
+	}
+	co.Logger.Warnf("Node %s heartbeat lease expired, marking offline", node.Name)
+	node.Status = NodeStatusOffline
+	node.UpdatedAt = time.Now()
+}
+
+// StateBackup is a portable copy of the store, restorable into any backend with
+// STORAGE_RESTORE_FROM
+type StateBackup struct {
+	Backend string `json:"backend"`
+	// Backups taken before schema versioning have none and are migrated from scratch
+	SchemaVersion int                                   `json:"schema_version,omitempty"`
+	CreatedAt     time.Time                             `json:"created_at"`
+	Records       map[string]map[string]json.RawMessage `json:"records"`
+}
+
+// importBackup replaces the store's contents with a backup file
+func (ss *StateStore) importBackup(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %v", err)
+	}
+	var backup StateBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return fmt.Errorf("failed to parse backup: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+
+	var changes []StateChange
+	for _, kind := range stateKinds {
+		existing, err := ss.store.Load(ctx, kind)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", kind, err)
+		}
+		for id := range existing {
+			if _, kept := backup.Records[kind][id]; !kept {
+				changes = append(changes, StateChange{Kind: kind, ID: id})
+			}
+		}
+		for id, record := range backup.Records[kind] {
+			changes = append(changes, StateChange{Kind: kind, ID: id, Data: []byte(record)})
+		}
+	}
+	// Records keep the schema version of the backup, and are migrated from it
+	schema, err := schemaVersionChange(backup.SchemaVersion)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, schema)
+	if err := ss.store.Apply(ctx, changes); err != nil {
+		return fmt.Errorf("failed to import backup: %v", err)
+	}
+
+	ss.logger.Infof("Imported backup taken %s from the %s store", backup.CreatedAt.Format(time.RFC3339), backup.Backend)
+	return nil
+}
+
+// exportBackup reads every record and the schema version they are stored at
+func (ss *StateStore) exportBackup(ctx context.Context) (*StateBackup, error) {
+	schema, err := loadSchemaVersion(ctx, ss.store)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &StateBackup{
+		Backend:       ss.store.Backend(),
+		SchemaVersion: schema,
+		CreatedAt:     time.Now(),
+		Records:       make(map[string]map[string]json.RawMessage, len(stateKinds)),
+	}
+	for _, kind := range stateKinds {
+		records, err := ss.store.Load(ctx, kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %v", kind, err)
+		}
+		backup.Records[kind] = make(map[string]json.RawMessage, len(records))
+		for id, data := range records {
+			backup.Records[kind][id] = json.RawMessage(data)
+		}
+	}
+	return backup, nil
+}
+
+// StorageStatus describes the state store
+type StorageStatus struct {
+	Backend      string         `json:"backend"`
+	SyncInterval string         `json:"sync_interval"`
+	LastSync     *time.Time     `json:"last_sync,omitempty"`
+	LastError    string         `json:"last_error,omitempty"`
+	Records      map[string]int `json:"records"`
+}
+
+// GetStorageStatus reports the store backend and whether syncs are succeeding
+func (co *CentralOrchestrator) GetStorageStatus(c *gin.Context) {
+	ss := co.StateStore
+	ss.mutex.RLock()
+	status := StorageStatus{
+		Backend:      ss.store.Backend(),
+		SyncInterval: ss.interval.String(),
+		Records:      make(map[string]int, len(stateKinds)),
+	}
+	if !ss.lastSync.IsZero() {
+		lastSync := ss.lastSync
+		status.LastSync = &lastSync
+	}
+	if ss.lastErr != nil {
+		status.LastError = ss.lastErr.Error()
+	}
+	ss.mutex.RUnlock()
+
+	ss.syncMutex.Lock()
+	for _, kind := range stateKinds {
+		status.Records[kind] = len(ss.written[kind])
+	}
+	ss.syncMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"storage": status})
+}
+
+// BackupState syncs pending changes and returns every stored record. The backup holds
+// node private keys and must be kept as carefully as the store itself.
+func (co *CentralOrchestrator) BackupState(c *gin.Context) {
+	// Followers back up what the leader last wrote
+	if co.LeaderElection.IsLeader() {
+		if err := co.persistState(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ss := co.StateStore
+	ctx, cancel := context.WithTimeout(c.Request.Context(), StorageTimeout)
+	defer cancel()
+
+	backup, err := ss.exportBackup(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	counts := make([]string, 0, len(stateKinds))
+	for _, kind := range stateKinds {
+		counts = append(counts, fmt.Sprintf("%s=%d", kind, len(backup.Records[kind])))
+	}
+	sort.Strings(counts)
+
+	co.AuditLog.RecordRequest(c, "storage.backup", "storage:"+backup.Backend, map[string]string{
+		"records": strings.Join(counts, ","),
+	})
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=orchestrator-state-%s.json", backup.CreatedAt.UTC().Format("20060102T150405Z")))
+	c.JSON(http.StatusOK, backup)
+}
@@ -0,0 +1,8 @@
+package main
+
+// Database drivers for the SQL state store, registered as "postgres" and "sqlite".
+// The SQLite driver is pure Go, so the orchestrator still builds without cgo.
+import (
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	// Prefix for orchestrator keys when ETCD_PREFIX is unset
+	DefaultEtcdPrefix = "/edge-orchestrator/"
+
+	// How long the leader's session outlives it; a standby takes over within this
+	EtcdLeaderSessionTTL = 15
+
+	// etcd rejects transactions with more operations than this by default
+	etcdMaxTxnOps = 128
+)
+
+// etcdStore keeps records under <prefix>state/<kind>/<id> in etcd. Replicas sharing
+// the store elect a leader through it, and node heartbeats are kept alive as leases so
+// a new leader knows which nodes are still heartbeating.
+type etcdStore struct {
+	client *clientv3.Client
+	prefix string
+	// Heartbeat lease of each node
+	leases map[string]clientv3.LeaseID
+	mutex  sync.Mutex
+}
+
+// openEtcdStore connects to the comma-separated endpoints in dsn. ETCD_CA_FILE,
+// ETCD_CERT_FILE and ETCD_KEY_FILE enable TLS; ETCD_USERNAME and ETCD_PASSWORD enable
+// authentication.
+func openEtcdStore(dsn string) (*etcdStore, error) {
+	config := clientv3.Config{
+		Endpoints:   strings.Split(dsn, ","),
+		DialTimeout: 10 * time.Second,
+		Username:    os.Getenv("ETCD_USERNAME"),
+		Password:    os.Getenv("ETCD_PASSWORD"),
+	}
+
+	if caFile := os.Getenv("ETCD_CA_FILE"); caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read etcd CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.TLS = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		if certFile := os.Getenv("ETCD_CERT_FILE"); certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("ETCD_KEY_FILE"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load etcd client certificate: %v", err)
+			}
+			config.TLS.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	client, err := clientv3.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to etcd: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+	if _, err := client.Status(ctx, config.Endpoints[0]); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to reach etcd: %v", err)
+	}
+
+	prefix := os.Getenv("ETCD_PREFIX")
+	if prefix == "" {
+		prefix = DefaultEtcdPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &etcdStore{client: client, prefix: prefix, leases: make(map[string]clientv3.LeaseID)}, nil
+}
+
+func (s *etcdStore) Backend() string {
+	return "etcd"
+}
+
+func (s *etcdStore) stateKey(kind, id string) string {
+	return s.prefix + "state/" + kind + "/" + id
+}
+
+// Apply writes changes in transactions of at most etcdMaxTxnOps operations, so a large
+// batch is only atomic per transaction
+func (s *etcdStore) Apply(ctx context.Context, changes []StateChange) error {
+	for start := 0; start < len(changes); start += etcdMaxTxnOps {
+		end := start + etcdMaxTxnOps
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		ops := make([]clientv3.Op, 0, end-start)
+		for _, change := range changes[start:end] {
+			if change.Data == nil {
+				ops = append(ops, clientv3.OpDelete(s.stateKey(change.Kind, change.ID)))
+			} else {
+				ops = append(ops, clientv3.OpPut(s.stateKey(change.Kind, change.ID), string(change.Data)))
+			}
+		}
+		if _, err := s.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *etcdStore) Load(ctx context.Context, kind string) (map[string][]byte, error) {
+	prefix := s.stateKey(kind, "")
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		records[strings.TrimPrefix(string(kv.Key), prefix)] = kv.Value
+	}
+	return records, nil
+}
+
+func (s *etcdStore) Close() error {
+	return s.client.Close()
+}
+
+// Campaign blocks until this replica is elected leader. The returned channel is closed
+// if leadership is lost.
+func (s *etcdStore) Campaign(ctx context.Context, candidate string) (<-chan struct{}, error) {
+	session, err := concurrency.NewSession(s.client, concurrency.WithTTL(EtcdLeaderSessionTTL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd session: %v", err)
+	}
+	election := concurrency.NewElection(session, s.prefix+"leader")
+	if err := election.Campaign(ctx, candidate); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to campaign for leadership: %v", err)
+	}
+	return session.Done(), nil
+}
+
+// Leader returns the candidate whose campaign started first, as concurrency.Election does
+func (s *etcdStore) Leader(ctx context.Context) (string, error) {
+	resp, err := s.client.Get(ctx, s.prefix+"leader/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (s *etcdStore) heartbeatKey(nodeID string) string {
+	return s.prefix + "heartbeats/" + nodeID
+}
+
+// RecordHeartbeat keeps the node's heartbeat lease alive, granting a new one if it
+// has none or it already expired
+func (s *etcdStore) RecordHeartbeat(ctx context.Context, nodeID string, ttl time.Duration) error {
+	s.mutex.Lock()
+	lease, exists := s.leases[nodeID]
+	s.mutex.Unlock()
+
+	if exists {
+		if _, err := s.client.KeepAliveOnce(ctx, lease); err == nil {
+			return nil
+		}
+	}
+
+	grant, err := s.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to grant heartbeat lease: %v", err)
+	}
+	if _, err := s.client.Put(ctx, s.heartbeatKey(nodeID), nodeID, clientv3.WithLease(grant.ID)); err != nil {
+		return fmt.Errorf("failed to record heartbeat: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.leases[nodeID] = grant.ID
+	s.mutex.Unlock()
+	return nil
+}
+
+// ExpiredHeartbeats reports nodes whose heartbeat lease expired until ctx is done
+func (s *etcdStore) ExpiredHeartbeats(ctx context.Context) <-chan string {
+	expired := make(chan string)
+	prefix := s.heartbeatKey("")
+
+	go func() {
+		defer close(expired)
+		for resp := range s.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithFilterPut()) {
+			for _, event := range resp.Events {
+				nodeID := strings.TrimPrefix(string(event.Kv.Key), prefix)
+				s.mutex.Lock()
+				delete(s.leases, nodeID)
+				s.mutex.Unlock()
+
+				select {
+				case expired <- nodeID:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return expired
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// migratedKinds are copied by migrateStore: the restored kinds, and the sync marker so
+// read replicas of the new store report lag from the last sync of the old one
+var migratedKinds = append(append([]string{}, stateKinds...), StateKindMeta)
+
+// migrateStore copies every record from source to target and reads them back to verify
+// the copy. It returns the number of records copied per kind. Unless overwrite is set,
+// the target must be empty; with overwrite, records the source lacks are deleted so the
+// target ends up identical to it.
+func migrateStore(ctx context.Context, source, target Store, overwrite bool) (map[string]int, error) {
+	existing := make(map[string]map[string][]byte, len(migratedKinds))
+	for _, kind := range migratedKinds {
+		records, err := target.Load(ctx, kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the %s store: %v", kind, target.Backend(), err)
+		}
+		if len(records) > 0 && !overwrite {
+			return nil, fmt.Errorf("the %s store already holds %d %s records", target.Backend(), len(records), kind)
+		}
+		existing[kind] = records
+	}
+
+	copied := make(map[string]int, len(migratedKinds))
+	for _, kind := range migratedKinds {
+		records, err := source.Load(ctx, kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the %s store: %v", kind, source.Backend(), err)
+		}
+
+		changes := make([]StateChange, 0, len(records))
+		for id, data := range records {
+			changes = append(changes, StateChange{Kind: kind, ID: id, Data: data})
+		}
+		for id := range existing[kind] {
+			if _, ok := records[id]; !ok {
+				changes = append(changes, StateChange{Kind: kind, ID: id})
+			}
+		}
+		if err := target.Apply(ctx, changes); err != nil {
+			return nil, fmt.Errorf("failed to write %s to the %s store: %v", kind, target.Backend(), err)
+		}
+
+		written, err := target.Load(ctx, kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s in the %s store: %v", kind, target.Backend(), err)
+		}
+		if len(written) != len(records) {
+			return nil, fmt.Errorf("the %s store holds %d %s records after migration, expected %d",
+				target.Backend(), len(written), kind, len(records))
+		}
+		for id, data := range records {
+			if !bytes.Equal(written[id], data) {
+				return nil, fmt.Errorf("%s/%s differs in the %s store after migration", kind, id, target.Backend())
+			}
+		}
+		copied[kind] = len(records)
+	}
+	return copied, nil
+}
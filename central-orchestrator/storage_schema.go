@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// Meta record holding the schema version the stored records are written in
+	stateSchemaMarker = "schema"
+
+	// Where the store is backed up before migrating when STORAGE_BACKUP_DIR is unset
+	DefaultStorageBackupDir = "/var/lib/edge-orchestrator/backups"
+)
+
+// stateSchema is the data of the schema marker
+type stateSchema struct {
+	Version    int       `json:"version"`
+	MigratedAt time.Time `json:"migrated_at"`
+}
+
+// storeMigration upgrades the stored records from the previous version to Version.
+// Replicas sharing a store may start together and run the same migration, so a
+// migration must leave already-migrated records as they are.
+type storeMigration struct {
+	Version     int
+	Description string
+	Migrate     func(ctx context.Context, store Store) error
+}
+
+// storeMigrations are applied in order. Append a migration whenever a release changes
+// how records are stored in a way older records cannot be decoded into; never edit or
+// renumber one that has shipped.
+var storeMigrations = []storeMigration{
+	{
+		Version:     1,
+		Description: "start versioning the schema of stored records",
+		Migrate: func(context.Context, Store) error {
+			return nil
+		},
+	},
+}
+
+// latestSchemaVersion is the schema version this orchestrator writes
+func latestSchemaVersion() int {
+	return storeMigrations[len(storeMigrations)-1].Version
+}
+
+// loadSchemaVersion returns the schema version of the store, 0 when it has never been
+// stamped
+func loadSchemaVersion(ctx context.Context, store Store) (int, error) {
+	meta, err := store.Load(ctx, StateKindMeta)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load schema version: %v", err)
+	}
+	data, ok := meta[stateSchemaMarker]
+	if !ok {
+		return 0, nil
+	}
+	var schema stateSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return 0, fmt.Errorf("failed to decode schema version: %v", err)
+	}
+	return schema.Version, nil
+}
+
+// schemaVersionChange stamps the store with a schema version; version 0 removes the
+// stamp
+func schemaVersionChange(version int) (StateChange, error) {
+	change := StateChange{Kind: StateKindMeta, ID: stateSchemaMarker}
+	if version == 0 {
+		return change, nil
+	}
+	data, err := json.Marshal(stateSchema{Version: version, MigratedAt: time.Now()})
+	if err != nil {
+		return change, fmt.Errorf("failed to marshal schema version: %v", err)
+	}
+	change.Data = data
+	return change, nil
+}
+
+// migrateRecords rewrites every record of a kind with rewrite, which edits the decoded
+// record in place and reports whether it changed. Migrations use it to reshape records.
+func migrateRecords(ctx context.Context, store Store, kind string, rewrite func(id string, record map[string]interface{}) (bool, error)) error {
+	records, err := store.Load(ctx, kind)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", kind, err)
+	}
+
+	var changes []StateChange
+	for id, data := range records {
+		var record map[string]interface{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed to decode %s/%s: %v", kind, id, err)
+		}
+		changed, err := rewrite(id, record)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %v", kind, id, err)
+		}
+		if !changed {
+			continue
+		}
+		updated, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s/%s: %v", kind, id, err)
+		}
+		changes = append(changes, StateChange{Kind: kind, ID: id, Data: updated})
+	}
+	return store.Apply(ctx, changes)
+}
+
+// migrateSchema brings the store up to the latest schema version on startup. It refuses
+// to start against a store written by a newer orchestrator, since this one would
+// misread or drop what it does not know. Before migrating, the store is backed up to
+// STORAGE_BACKUP_DIR; restore the backup with STORAGE_RESTORE_FROM to roll back. With
+// STORAGE_AUTO_MIGRATE=false the orchestrator instead refuses to start until
+// "central-orchestrator migrate-schema" has been run.
+func (ss *StateStore) migrateSchema() error {
+	ctx, cancel := context.WithTimeout(context.Background(), MigrationTimeout)
+	defer cancel()
+
+	current, err := loadSchemaVersion(ctx, ss.store)
+	if err != nil {
+		return err
+	}
+	latest := latestSchemaVersion()
+	switch {
+	case current > latest:
+		return fmt.Errorf("the %s store is at schema version %d but this orchestrator supports up to %d; "+
+			"run a newer orchestrator, or restore a backup taken before the upgrade", ss.store.Backend(), current, latest)
+	case current == latest:
+		return nil
+	}
+
+	// A new store has nothing to migrate and starts at the latest version
+	empty := true
+	for _, kind := range stateKinds {
+		records, err := ss.store.Load(ctx, kind)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", kind, err)
+		}
+		if len(records) > 0 {
+			empty = false
+			break
+		}
+	}
+	if empty && current == 0 {
+		if ss.readOnly {
+			return nil
+		}
+		change, err := schemaVersionChange(latest)
+		if err != nil {
+			return err
+		}
+		return ss.store.Apply(ctx, []StateChange{change})
+	}
+
+	if ss.readOnly {
+		return fmt.Errorf("the %s store is at schema version %d but this orchestrator expects %d; "+
+			"upgrade the primary first so it migrates the store", ss.store.Backend(), current, latest)
+	}
+	if os.Getenv("STORAGE_AUTO_MIGRATE") == "false" {
+		return fmt.Errorf("the %s store is at schema version %d and needs migrating to %d; "+
+			"run \"central-orchestrator migrate-schema\" or unset STORAGE_AUTO_MIGRATE", ss.store.Backend(), current, latest)
+	}
+	return ss.applyMigrations(ctx, current)
+}
+
+// applyMigrations backs up the store, then applies every migration after version
+// current, stamping the store after each so an interrupted upgrade resumes where it
+// stopped
+func (ss *StateStore) applyMigrations(ctx context.Context, current int) error {
+	path, err := ss.writeMigrationBackup(ctx, current)
+	if err != nil {
+		return err
+	}
+	ss.logger.Infof("Backed up the %s store at schema version %d to %s", ss.store.Backend(), current, path)
+
+	for _, migration := range storeMigrations {
+		if migration.Version <= current {
+			continue
+		}
+		ss.logger.Infof("Migrating the %s store to schema version %d: %s", ss.store.Backend(), migration.Version, migration.Description)
+		if err := migration.Migrate(ctx, ss.store); err != nil {
+			return fmt.Errorf("failed to migrate to schema version %d: %v; restore %s with STORAGE_RESTORE_FROM to roll back",
+				migration.Version, err, path)
+		}
+		change, err := schemaVersionChange(migration.Version)
+		if err != nil {
+			return err
+		}
+		if err := ss.store.Apply(ctx, []StateChange{change}); err != nil {
+			return fmt.Errorf("failed to record schema version %d: %v", migration.Version, err)
+		}
+	}
+	return nil
+}
+
+// writeMigrationBackup writes a backup of the store to STORAGE_BACKUP_DIR and returns
+// its path. Like any backup it holds node private keys, so it is only readable by the
+// orchestrator's user.
+func (ss *StateStore) writeMigrationBackup(ctx context.Context, version int) (string, error) {
+	dir := os.Getenv("STORAGE_BACKUP_DIR")
+	if dir == "" {
+		dir = DefaultStorageBackupDir
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	backup, err := ss.exportBackup(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to back up the store before migrating: %v", err)
+	}
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal backup: %v", err)
+	}
+
+	name := fmt.Sprintf("orchestrator-state-v%d-%s.json", version, backup.CreatedAt.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write backup: %v", err)
+	}
+	return path, nil
+}
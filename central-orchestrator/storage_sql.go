@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLDialect is the database/sql driver a SQL store uses
+type SQLDialect string
+
+const (
+	SQLDialectSQLite   SQLDialect = "sqlite"
+	SQLDialectPostgres SQLDialect = "postgres"
+)
+
+// The schema and statements are shared: SQLite accepts $n placeholders and, since 3.24,
+// the same upsert syntax as Postgres
+const (
+	stateTableSchema = `CREATE TABLE IF NOT EXISTS orchestrator_state (
+	kind       TEXT NOT NULL,
+	id         TEXT NOT NULL,
+	data       TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (kind, id)
+)`
+	stateUpsert = `INSERT INTO orchestrator_state (kind, id, data, updated_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (kind, id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`
+	stateDelete = `DELETE FROM orchestrator_state WHERE kind = $1 AND id = $2`
+	stateSelect = `SELECT id, data FROM orchestrator_state WHERE kind = $1`
+)
+
+// sqlStore keeps records in a single table of a SQLite or Postgres database
+type sqlStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+// openSQLStore connects to the database and creates the state table if needed. A
+// read-only store, such as a Postgres hot standby, expects the table to exist.
+func openSQLStore(dialect SQLDialect, dsn string, readOnly bool) (*sqlStore, error) {
+	db, err := sql.Open(string(dialect), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %v", dialect, err)
+	}
+	if dialect == SQLDialectSQLite {
+		// SQLite allows one writer at a time; a single connection avoids busy errors
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s store: %v", dialect, err)
+	}
+	if readOnly {
+		return &sqlStore{db: db, dialect: dialect}, nil
+	}
+	if dialect == SQLDialectSQLite {
+		if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=WAL"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to enable WAL: %v", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, stateTableSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create state table: %v", err)
+	}
+
+	return &sqlStore{db: db, dialect: dialect}, nil
+}
+
+func (s *sqlStore) Backend() string {
+	return string(s.dialect)
+}
+
+func (s *sqlStore) Apply(ctx context.Context, changes []StateChange) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, change := range changes {
+		if change.Data == nil {
+			_, err = tx.ExecContext(ctx, stateDelete, change.Kind, change.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, stateUpsert, change.Kind, change.ID, string(change.Data), now)
+		}
+		if err != nil {
+			return fmt.Errorf("%s/%s: %v", change.Kind, change.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Load(ctx context.Context, kind string) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, stateSelect, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string][]byte)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		records[id] = []byte(data)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Default time a computed summary is served before being rebuilt
+	DefaultSummaryTTL = 5 * time.Second
+
+	// Number of worst offenders listed in the summary
+	SummaryWorstOffenders = 5
+)
+
+// RegionSummary counts nodes by status in one region
+type RegionSummary struct {
+	Region   string         `json:"region"`
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+// NodeOffender is a node ranked among the least healthy in the fleet
+type NodeOffender struct {
+	NodeID       string  `json:"node_id"`
+	Name         string  `json:"name"`
+	Region       string  `json:"region"`
+	Status       string  `json:"status"`
+	FiringAlerts int     `json:"firing_alerts"`
+	Availability float64 `json:"availability_daily"`
+}
+
+// FailingWorkload is a workload with firing alerts or a failed status, listed with its
+// owner and runbook so the wallboard shows who to call
+type FailingWorkload struct {
+	WorkloadID   string           `json:"workload_id"`
+	Name         string           `json:"name"`
+	Status       string           `json:"status"`
+	Criticality  int32            `json:"criticality"`
+	FiringAlerts int              `json:"firing_alerts"`
+	Metadata     WorkloadMetadata `json:"metadata"`
+}
+
+// FleetSummary is the wallboard document served by GET /summary
+type FleetSummary struct {
+	GeneratedAt        time.Time         `json:"generated_at"`
+	Regions            []RegionSummary   `json:"regions"`
+	Nodes              map[string]int    `json:"nodes"`
+	Workloads          map[string]int    `json:"workloads"`
+	AlertsBySeverity   map[string]int    `json:"alerts_by_severity"`
+	ActiveAlerts       int               `json:"active_alerts"`
+	RolloutsInProgress int               `json:"rollouts_in_progress"`
+	WorstOffenders     []NodeOffender    `json:"worst_offenders"`
+	FailingWorkloads   []FailingWorkload `json:"failing_workloads"`
+}
+
+// SummaryCache holds the last computed summary so wallboards polling every few seconds
+// do not rebuild it on each request
+type SummaryCache struct {
+	summary *FleetSummary
+	body    []byte
+	etag    string
+	ttl     time.Duration
+	mutex   sync.Mutex
+	logger  *logrus.Logger
+}
+
+// NewSummaryCache creates a summary cache; SUMMARY_TTL overrides the default lifetime
+func NewSummaryCache(logger *logrus.Logger) *SummaryCache {
+	ttl := DefaultSummaryTTL
+	if value := os.Getenv("SUMMARY_TTL"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			ttl = parsed
+		} else {
+			logger.Warnf("Invalid SUMMARY_TTL %q, using %s", value, ttl)
+		}
+	}
+
+	return &SummaryCache{
+		ttl:    ttl,
+		logger: logger,
+	}
+}
+
+// buildSummary computes the fleet summary from the managers, counting only the nodes,
+// workloads and alerts a tenant view sees when one is given
+func (co *CentralOrchestrator) buildSummary(view *tenantView) *FleetSummary {
+	summary := &FleetSummary{
+		GeneratedAt:      time.Now(),
+		Nodes:            make(map[string]int),
+		Workloads:        make(map[string]int),
+		AlertsBySeverity: make(map[string]int),
+		WorstOffenders:   make([]NodeOffender, 0),
+		FailingWorkloads: make([]FailingWorkload, 0),
+	}
+
+	firingByNode := make(map[string]int)
+	firingByWorkload := make(map[string]int)
+	// Silenced alerts, such as those of nodes in maintenance, are not counted
+	for _, alert := range co.unsilencedAlerts(co.AlertManager.List(AlertFilter{Status: string(AlertStatusFiring)})) {
+		if !view.alert(alert) {
+			continue
+		}
+		summary.ActiveAlerts++
+		summary.AlertsBySeverity[string(alert.Severity)]++
+		switch alert.Scope {
+		case AlertScopeNode:
+			firingByNode[alert.ScopeID]++
+		case AlertScopeWorkload:
+			firingByWorkload[alert.ScopeID]++
+		}
+	}
+
+	regions := make(map[string]*RegionSummary)
+	var offenders []NodeOffender
+
+	co.NodeManager.mutex.RLock()
+	for _, node := range co.NodeManager.nodes {
+		if !view.node(node.ID) {
+			continue
+		}
+		summary.Nodes[string(node.Status)]++
+
+		region, exists := regions[node.Region]
+		if !exists {
+			region = &RegionSummary{Region: node.Region, ByStatus: make(map[string]int)}
+			regions[node.Region] = region
+		}
+		region.Total++
+		region.ByStatus[string(node.Status)]++
+
+		availability := co.UptimeTracker.Availability(node.ID, UptimeWindowDaily, summary.GeneratedAt)
+		if node.Status != NodeStatusOnline || firingByNode[node.ID] > 0 || availability.Percent < 100 {
+			offenders = append(offenders, NodeOffender{
+				NodeID:       node.ID,
+				Name:         node.Name,
+				Region:       node.Region,
+				Status:       string(node.Status),
+				FiringAlerts: firingByNode[node.ID],
+				Availability: availability.Percent,
+			})
+		}
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	for _, region := range regions {
+		summary.Regions = append(summary.Regions, *region)
+	}
+	sort.Slice(summary.Regions, func(i, j int) bool {
+		return summary.Regions[i].Region < summary.Regions[j].Region
+	})
+
+	// Offline first, then most firing alerts, then lowest availability
+	sort.Slice(offenders, func(i, j int) bool {
+		a, b := offenders[i], offenders[j]
+		if (a.Status == string(NodeStatusOffline)) != (b.Status == string(NodeStatusOffline)) {
+			return a.Status == string(NodeStatusOffline)
+		}
+		if a.FiringAlerts != b.FiringAlerts {
+			return a.FiringAlerts > b.FiringAlerts
+		}
+		return a.Availability < b.Availability
+	})
+	if len(offenders) > SummaryWorstOffenders {
+		offenders = offenders[:SummaryWorstOffenders]
+	}
+	summary.WorstOffenders = append(summary.WorstOffenders, offenders...)
+
+	var failing []FailingWorkload
+	co.WorkloadManager.mutex.RLock()
+	for _, workload := range co.WorkloadManager.workloads {
+		if !view.workload(workload.ID) {
+			continue
+		}
+		summary.Workloads[string(workload.Status)]++
+		if workload.Status == WorkloadStatusFailed || firingByWorkload[workload.ID] > 0 {
+			failing = append(failing, FailingWorkload{
+				WorkloadID:   workload.ID,
+				Name:         workload.Name,
+				Status:       string(workload.Status),
+				Criticality:  workload.Criticality,
+				FiringAlerts: firingByWorkload[workload.ID],
+				Metadata:     workload.Metadata,
+			})
+		}
+	}
+	co.WorkloadManager.mutex.RUnlock()
+
+	// Most critical first, then most firing alerts
+	sort.Slice(failing, func(i, j int) bool {
+		a, b := failing[i], failing[j]
+		if a.Criticality != b.Criticality {
+			return a.Criticality > b.Criticality
+		}
+		if a.FiringAlerts != b.FiringAlerts {
+			return a.FiringAlerts > b.FiringAlerts
+		}
+		return a.Name < b.Name
+	})
+	if len(failing) > SummaryWorstOffenders {
+		failing = failing[:SummaryWorstOffenders]
+	}
+	summary.FailingWorkloads = append(summary.FailingWorkloads, failing...)
+
+	co.MigrationManager.mutex.RLock()
+	for _, migration := range co.MigrationManager.migrations {
+		if migration.CompletedAt == nil && view.workload(migration.WorkloadID) {
+			summary.RolloutsInProgress++
+		}
+	}
+	co.MigrationManager.mutex.RUnlock()
+
+	return summary
+}
+
+// GetSummary returns the cached fleet summary, rebuilding it once it is older than the TTL.
+// Clients can revalidate with If-None-Match. Callers limited to tenants get a summary of
+// their own nodes and workloads, built for each request.
+func (co *CentralOrchestrator) GetSummary(c *gin.Context) {
+	if view := co.tenantView(c); view != nil {
+		c.Header("Cache-Control", "private, no-store")
+		c.JSON(http.StatusOK, gin.H{"summary": co.buildSummary(view)})
+		return
+	}
+
+	cache := co.SummaryCache
+	cache.mutex.Lock()
+	if cache.summary == nil || time.Since(cache.summary.GeneratedAt) > cache.ttl {
+		summary := co.buildSummary(nil)
+		body, err := json.Marshal(gin.H{"summary": summary})
+		if err != nil {
+			cache.mutex.Unlock()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to encode summary: %v", err)})
+			return
+		}
+		hash := sha256.Sum256(body)
+		cache.summary = summary
+		cache.body = body
+		cache.etag = `"` + hex.EncodeToString(hash[:8]) + `"`
+	}
+	body, etag := cache.body, cache.etag
+	cache.mutex.Unlock()
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cache.ttl.Seconds())))
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
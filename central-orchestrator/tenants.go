@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Tenant assigned to workloads deployed without one
+	DefaultTenant = "default"
+)
+
+// TenantQuota weights a tenant's fair share of scheduling and optionally caps the resources
+// its running workloads may request
+type TenantQuota struct {
+	Tenant    string    `json:"tenant"`
+	Weight    float64   `json:"weight"`
+	CPU       string    `json:"cpu,omitempty"`
+	Memory    string    `json:"memory,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TenantQuotaRequest represents a tenant quota upsert request
+type TenantQuotaRequest struct {
+	Weight float64 `json:"weight"`
+	CPU    string  `json:"cpu"`
+	Memory string  `json:"memory"`
+}
+
+// TenantSchedulingStats reports how long a tenant's workloads wait to be scheduled
+type TenantSchedulingStats struct {
+	Pending              int     `json:"pending"`
+	Scheduled            int     `json:"scheduled"`
+	AverageWaitSeconds   float64 `json:"average_wait_seconds"`
+	MaxWaitSeconds       float64 `json:"max_wait_seconds"`
+	OldestPendingSeconds float64 `json:"oldest_pending_seconds"`
+}
+
+// pendingWorkload records when a workload was first seen pending
+type pendingWorkload struct {
+	tenant string
+	since  time.Time
+}
+
+// tenantWaits accumulates completed waits for a tenant
+type tenantWaits struct {
+	scheduled int
+	total     time.Duration
+	max       time.Duration
+}
+
+// tenantUsage is what a tenant's running workloads request during a scheduling pass
+type tenantUsage struct {
+	requested ResourceAmounts
+	replicas  int32
+}
+
+// TenantScheduler orders pending workloads by weighted fair share across tenants
+type TenantScheduler struct {
+	quotas  map[string]*TenantQuota
+	pending map[string]pendingWorkload
+	waits   map[string]*tenantWaits
+	mutex   sync.RWMutex
+	logger  *logrus.Logger
+}
+
+// NewTenantScheduler creates a new tenant scheduler
+func NewTenantScheduler(logger *logrus.Logger) *TenantScheduler {
+	return &TenantScheduler{
+		quotas:  make(map[string]*TenantQuota),
+		pending: make(map[string]pendingWorkload),
+		waits:   make(map[string]*tenantWaits),
+		logger:  logger,
+	}
+}
+
+// weight returns a tenant's fair-share weight; tenants without a quota weigh 1
+func (ts *TenantScheduler) weight(tenant string) float64 {
+	if quota, exists := ts.quotas[tenant]; exists && quota.Weight > 0 {
+		return quota.Weight
+	}
+	return 1
+}
+
+// withinQuota reports whether a tenant can take on additional requests
+func (ts *TenantScheduler) withinQuota(tenant string, usage, additional ResourceAmounts) bool {
+	quota, exists := ts.quotas[tenant]
+	if !exists {
+		return true
+	}
+	var limit ResourceAmounts
+	if q, ok := parseQuantity(quota.CPU); ok {
+		limit.MilliCPU = q.MilliValue()
+	}
+	if q, ok := parseQuantity(quota.Memory); ok {
+		limit.MemoryBytes = q.Value()
+	}
+	return fits(limit, usage, additional)
+}
+
+// share returns a tenant's dominant resource share divided by its weight. When the fleet's
+// capacity is unknown, running replicas stand in for resources.
+func (ts *TenantScheduler) share(tenant string, usage tenantUsage, capacity ResourceAmounts) float64 {
+	var dominant float64
+	if capacity.MilliCPU > 0 {
+		dominant = float64(usage.requested.MilliCPU) / float64(capacity.MilliCPU)
+	}
+	if capacity.MemoryBytes > 0 {
+		if memory := float64(usage.requested.MemoryBytes) / float64(capacity.MemoryBytes); memory > dominant {
+			dominant = memory
+		}
+	}
+	if capacity.MilliCPU == 0 && capacity.MemoryBytes == 0 {
+		dominant = float64(usage.replicas)
+	}
+	return dominant / ts.weight(tenant)
+}
+
+// workloadTenant returns the workload's tenant, falling back to the default tenant
+func workloadTenant(workload *Workload) string {
+	if workload.Tenant == "" {
+		return DefaultTenant
+	}
+	return workload.Tenant
+}
+
+// expectedReplicas returns the replicas a scheduling pass places for a workload
+func expectedReplicas(workload *Workload) int32 {
+	if workload.Replicas < 1 {
+		return 1
+	}
+	return workload.Replicas
+}
+
+// runningReplicas returns the replicas placed across all of the workload's deployments
+func (w *Workload) runningReplicas() int32 {
+	var replicas int32
+	for _, deployment := range w.Deployments {
+		if deployment.placed() {
+			replicas += deployment.Replicas
+		}
+	}
+	return replicas
+}
+
+// scheduleFairly schedules pending workloads one at a time, always serving the tenant with
+// the lowest weighted dominant share that still has quota headroom. Within a tenant, more
+// critical and then older workloads go first. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) scheduleFairly(now time.Time) {
+	ts := co.TenantScheduler
+
+	var capacity ResourceAmounts
+	for _, node := range co.onlineNodes(nil) {
+		capacity = capacity.Add(co.allocatableCapacity(node))
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	usage := make(map[string]tenantUsage)
+	queues := make(map[string][]*Workload)
+	for _, workload := range co.WorkloadManager.workloads {
+		tenant := workloadTenant(workload)
+		if running := workload.runningReplicas(); running > 0 {
+			u := usage[tenant]
+			u.requested = u.requested.Add(workloadRequests(workload).Scale(running))
+			u.replicas += running
+			usage[tenant] = u
+		}
+		if workload.Status != WorkloadStatusPending {
+			delete(ts.pending, workload.ID)
+			continue
+		}
+		if _, seen := ts.pending[workload.ID]; !seen {
+			ts.pending[workload.ID] = pendingWorkload{tenant: tenant, since: workload.UpdatedAt}
+		}
+		queues[tenant] = append(queues[tenant], workload)
+	}
+	for id := range ts.pending {
+		if _, exists := co.WorkloadManager.workloads[id]; !exists {
+			delete(ts.pending, id)
+		}
+	}
+
+	for tenant, queue := range queues {
+		sort.SliceStable(queue, func(i, j int) bool {
+			if queue[i].Criticality != queue[j].Criticality {
+				return queue[i].Criticality > queue[j].Criticality
+			}
+			return ts.pending[queue[i].ID].since.Before(ts.pending[queue[j].ID].since)
+		})
+		queues[tenant] = queue
+	}
+
+	for len(queues) > 0 {
+		tenants := make([]string, 0, len(queues))
+		for tenant := range queues {
+			tenants = append(tenants, tenant)
+		}
+		sort.Slice(tenants, func(i, j int) bool {
+			si, sj := ts.share(tenants[i], usage[tenants[i]], capacity), ts.share(tenants[j], usage[tenants[j]], capacity)
+			if si != sj {
+				return si < sj
+			}
+			return tenants[i] < tenants[j]
+		})
+
+		tenant := tenants[0]
+		workload := queues[tenant][0]
+		if len(queues[tenant]) == 1 {
+			delete(queues, tenant)
+		} else {
+			queues[tenant] = queues[tenant][1:]
+		}
+
+		// Gang members are admitted and placed as one unit
+		members := takeGangMembers(workload, queues)
+
+		// Replicas already placed are in the tenant's usage
+		var requested ResourceAmounts
+		for _, member := range members {
+			if missing := expectedReplicas(member) - member.runningReplicas(); missing > 0 {
+				requested = requested.Add(workloadRequests(member).Scale(missing))
+			}
+		}
+		if !ts.withinQuota(tenant, usage[tenant].requested, requested) {
+			co.Logger.Infof("Workload %s stays pending: tenant %s is at its quota", workload.Name, tenant)
+			continue
+		}
+
+		co.Logger.Infof("Scheduling workload %s for tenant %s", workload.Name, tenant)
+		before := make([]int32, len(members))
+		for i, member := range members {
+			before[i] = member.runningReplicas()
+		}
+		if workload.Placement.Gang != nil {
+			if err := co.scheduleGang(members); err != nil {
+				co.Logger.Infof("Workload %s stays pending: %v", workload.Name, err)
+				for _, member := range members {
+					co.CloudProvisioner.RequestCapacity(member)
+				}
+				continue
+			}
+		} else if err := co.scheduleWorkload(workload); err != nil {
+			co.Logger.Errorf("Failed to schedule workload %s: %v", workload.Name, err)
+			co.CloudProvisioner.RequestCapacity(workload)
+			continue
+		}
+
+		for i, member := range members {
+			added := member.runningReplicas() - before[i]
+			u := usage[tenant]
+			u.requested = u.requested.Add(workloadRequests(member).Scale(added))
+			u.replicas += added
+			usage[tenant] = u
+
+			wait := now.Sub(ts.pending[member.ID].since)
+			delete(ts.pending, member.ID)
+			stats, exists := ts.waits[tenant]
+			if !exists {
+				stats = &tenantWaits{}
+				ts.waits[tenant] = stats
+			}
+			stats.scheduled++
+			stats.total += wait
+			if wait > stats.max {
+				stats.max = wait
+			}
+		}
+	}
+}
+
+// Stats returns per-tenant scheduling wait statistics
+func (ts *TenantScheduler) Stats(now time.Time) map[string]TenantSchedulingStats {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	stats := make(map[string]TenantSchedulingStats)
+	for tenant, waits := range ts.waits {
+		s := stats[tenant]
+		s.Scheduled = waits.scheduled
+		s.AverageWaitSeconds = waits.total.Seconds() / float64(waits.scheduled)
+		s.MaxWaitSeconds = waits.max.Seconds()
+		stats[tenant] = s
+	}
+	for _, pending := range ts.pending {
+		s := stats[pending.tenant]
+		s.Pending++
+		if waited := now.Sub(pending.since).Seconds(); waited > s.OldestPendingSeconds {
+			s.OldestPendingSeconds = waited
+		}
+		stats[pending.tenant] = s
+	}
+	return stats
+}
+
+// SetTenantQuota creates or replaces a tenant's quota
+func (co *CentralOrchestrator) SetTenantQuota(c *gin.Context) {
+	var req TenantQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Weight == 0 {
+		req.Weight = 1
+	}
+	if req.Weight < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "weight must be positive"})
+		return
+	}
+	for _, value := range []string{req.CPU, req.Memory} {
+		if _, ok := parseQuantity(value); value != "" && !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quantity: " + value})
+			return
+		}
+	}
+
+	tenant := c.Param("tenant")
+	if !tenantAllowed(c, tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", tenant)})
+		return
+	}
+	now := time.Now()
+
+	co.TenantScheduler.mutex.Lock()
+	defer co.TenantScheduler.mutex.Unlock()
+
+	quota, exists := co.TenantScheduler.quotas[tenant]
+	if !exists {
+		quota = &TenantQuota{Tenant: tenant, CreatedAt: now}
+		co.TenantScheduler.quotas[tenant] = quota
+	}
+	quota.Weight = req.Weight
+	quota.CPU = req.CPU
+	quota.Memory = req.Memory
+	quota.UpdatedAt = now
+
+	co.Logger.Infof("Quota for tenant %s set (weight %.2f)", tenant, quota.Weight)
+
+	c.JSON(http.StatusOK, gin.H{"quota": quota})
+}
+
+// ListTenantQuotas returns the quotas of the tenants the caller may act on
+func (co *CentralOrchestrator) ListTenantQuotas(c *gin.Context) {
+	co.TenantScheduler.mutex.RLock()
+	defer co.TenantScheduler.mutex.RUnlock()
+
+	quotas := make([]*TenantQuota, 0, len(co.TenantScheduler.quotas))
+	for _, quota := range co.TenantScheduler.quotas {
+		if tenantAllowed(c, quota.Tenant) {
+			quotas = append(quotas, quota)
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].Tenant < quotas[j].Tenant
+	})
+
+	c.JSON(http.StatusOK, gin.H{"quotas": quotas})
+}
+
+// DeleteTenantQuota removes a tenant's quota
+func (co *CentralOrchestrator) DeleteTenantQuota(c *gin.Context) {
+	tenant := c.Param("tenant")
+	if !tenantAllowed(c, tenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not allowed to act on tenant %s", tenant)})
+		return
+	}
+
+	co.TenantScheduler.mutex.Lock()
+	defer co.TenantScheduler.mutex.Unlock()
+
+	if _, exists := co.TenantScheduler.quotas[tenant]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant quota not found"})
+		return
+	}
+
+	delete(co.TenantScheduler.quotas, tenant)
+	co.Logger.Infof("Quota for tenant %s deleted", tenant)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tenant quota deleted successfully"})
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// How often site TSDB workloads are reconciled with their configuration
+	TSDBSyncInterval = 30 * time.Second
+
+	// TSDB image when SITE_TSDB_IMAGE is unset. It wraps Prometheus and loads its
+	// configuration from the orchestrator, reloading whenever the version changes.
+	DefaultTSDBImage = "edge-framework/site-tsdb:latest"
+
+	// Defaults for site TSDBs that leave them unset
+	DefaultTSDBRetention      = "7d"
+	DefaultTSDBStorageSize    = "10Gi"
+	DefaultTSDBScrapeInterval = "30s"
+
+	// Port the TSDB serves queries and accepts remote writes from sensors on
+	TSDBPort = 9090
+
+	// Label identifying the site a TSDB workload serves
+	SiteTSDBLabel = "edge.io/site-tsdb"
+)
+
+// RemoteWriteTarget is an upstream the site TSDB forwards samples to
+type RemoteWriteTarget struct {
+	URL     string            `json:"url" binding:"required"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ScrapeTarget is a static set of endpoints scraped by the site TSDB, such as sensor gateways
+type ScrapeTarget struct {
+	JobName     string            `json:"job_name" binding:"required"`
+	Targets     []string          `json:"targets" binding:"required,min=1"`
+	MetricsPath string            `json:"metrics_path,omitempty"`
+	Interval    string            `json:"interval,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// SiteTSDBRequest enables or reconfigures the managed TSDB at a site
+type SiteTSDBRequest struct {
+	Retention      string              `json:"retention"`
+	StorageSize    string              `json:"storage_size"`
+	ScrapeInterval string              `json:"scrape_interval"`
+	RemoteWrite    []RemoteWriteTarget `json:"remote_write" binding:"dive"`
+	ScrapeTargets  []ScrapeTarget      `json:"scrape_targets" binding:"dive"`
+	// Discover pods annotated prometheus.io/scrape on the site's clusters
+	ScrapeAnnotatedPods bool              `json:"scrape_annotated_pods"`
+	Resources           WorkloadResources `json:"resources"`
+}
+
+// validate applies defaults and checks durations and targets
+func (req *SiteTSDBRequest) validate() error {
+	if req.Retention == "" {
+		req.Retention = DefaultTSDBRetention
+	}
+	if req.StorageSize == "" {
+		req.StorageSize = DefaultTSDBStorageSize
+	}
+	if req.ScrapeInterval == "" {
+		req.ScrapeInterval = DefaultTSDBScrapeInterval
+	}
+	if _, ok := parseQuantity(req.StorageSize); !ok {
+		return fmt.Errorf("invalid storage_size %q", req.StorageSize)
+	}
+	if _, err := parsePromDuration(req.Retention); err != nil {
+		return fmt.Errorf("invalid retention: %v", err)
+	}
+	if _, err := parsePromDuration(req.ScrapeInterval); err != nil {
+		return fmt.Errorf("invalid scrape_interval: %v", err)
+	}
+	jobs := make(map[string]bool)
+	for _, target := range req.ScrapeTargets {
+		if jobs[target.JobName] {
+			return fmt.Errorf("duplicate scrape job %s", target.JobName)
+		}
+		jobs[target.JobName] = true
+		if target.Interval != "" {
+			if _, err := parsePromDuration(target.Interval); err != nil {
+				return fmt.Errorf("invalid interval for scrape job %s: %v", target.JobName, err)
+			}
+		}
+	}
+	for _, remote := range req.RemoteWrite {
+		if !strings.HasPrefix(remote.URL, "http://") && !strings.HasPrefix(remote.URL, "https://") {
+			return fmt.Errorf("remote_write url must be http or https")
+		}
+	}
+	return nil
+}
+
+// parsePromDuration parses the durations Prometheus accepts, which add d, w and y units
+func parsePromDuration(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour, "y": 365 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if strings.HasSuffix(value, suffix) {
+			var n int
+			if _, err := fmt.Sscanf(strings.TrimSuffix(value, suffix), "%d", &n); err != nil || n <= 0 {
+				return 0, fmt.Errorf("%q is not a positive duration", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration", value)
+	}
+	return duration, nil
+}
+
+// SiteTSDB is the managed TSDB at a site
+type SiteTSDB struct {
+	SiteID string `json:"site_id"`
+	SiteTSDBRequest
+	WorkloadID string `json:"workload_id,omitempty"`
+	// Changes whenever the rendered configuration does
+	ConfigVersion string    `json:"config_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TSDBManager manages the per-site TSDB workloads
+type TSDBManager struct {
+	tsdbs map[string]*SiteTSDB
+	image string
+	// Upstream every site forwards to in addition to its own targets
+	upstream *RemoteWriteTarget
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewTSDBManager creates a TSDB manager; SITE_TSDB_IMAGE overrides the TSDB image and
+// SITE_TSDB_REMOTE_WRITE_URL adds a fleet-wide remote-write upstream
+func NewTSDBManager(logger *logrus.Logger) *TSDBManager {
+	image := os.Getenv("SITE_TSDB_IMAGE")
+	if image == "" {
+		image = DefaultTSDBImage
+	}
+
+	tm := &TSDBManager{
+		tsdbs:  make(map[string]*SiteTSDB),
+		image:  image,
+		logger: logger,
+	}
+	if url := os.Getenv("SITE_TSDB_REMOTE_WRITE_URL"); url != "" {
+		tm.upstream = &RemoteWriteTarget{URL: url}
+		if token := os.Getenv("SITE_TSDB_REMOTE_WRITE_TOKEN"); token != "" {
+			tm.upstream.Headers = map[string]string{"Authorization": "Bearer " + token}
+		}
+	}
+	return tm
+}
+
+// prometheusConfig is the subset of the Prometheus configuration file the TSDB is given
+type prometheusConfig struct {
+	Global        prometheusGlobal        `yaml:"global"`
+	ScrapeConfigs []prometheusScrape      `yaml:"scrape_configs,omitempty"`
+	RemoteWrite   []prometheusRemoteWrite `yaml:"remote_write,omitempty"`
+}
+
+type prometheusGlobal struct {
+	ScrapeInterval string            `yaml:"scrape_interval"`
+	ExternalLabels map[string]string `yaml:"external_labels"`
+}
+
+type prometheusScrape struct {
+	JobName             string              `yaml:"job_name"`
+	ScrapeInterval      string              `yaml:"scrape_interval,omitempty"`
+	MetricsPath         string              `yaml:"metrics_path,omitempty"`
+	StaticConfigs       []prometheusStatic  `yaml:"static_configs,omitempty"`
+	KubernetesSDConfigs []map[string]string `yaml:"kubernetes_sd_configs,omitempty"`
+	RelabelConfigs      []prometheusRelabel `yaml:"relabel_configs,omitempty"`
+}
+
+type prometheusStatic struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+type prometheusRelabel struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+}
+
+type prometheusRemoteWrite struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// renderConfig builds the Prometheus configuration for a site's TSDB. Samples carry the
+// site and region as external labels so upstream can tell sites apart.
+func (tm *TSDBManager) renderConfig(tsdb *SiteTSDB, site *Site) ([]byte, error) {
+	config := prometheusConfig{
+		Global: prometheusGlobal{
+			ScrapeInterval: tsdb.ScrapeInterval,
+			ExternalLabels: map[string]string{"site": site.ID, "site_name": site.Name, "region": site.Region},
+		},
+	}
+	for _, target := range tsdb.ScrapeTargets {
+		config.ScrapeConfigs = append(config.ScrapeConfigs, prometheusScrape{
+			JobName:        target.JobName,
+			ScrapeInterval: target.Interval,
+			MetricsPath:    target.MetricsPath,
+			StaticConfigs:  []prometheusStatic{{Targets: target.Targets, Labels: target.Labels}},
+		})
+	}
+	if tsdb.ScrapeAnnotatedPods {
+		config.ScrapeConfigs = append(config.ScrapeConfigs, prometheusScrape{
+			JobName:             "annotated-pods",
+			KubernetesSDConfigs: []map[string]string{{"role": "pod"}},
+			RelabelConfigs: []prometheusRelabel{
+				{SourceLabels: []string{"__meta_kubernetes_pod_annotation_prometheus_io_scrape"}, Regex: "true", Action: "keep"},
+				{SourceLabels: []string{"__meta_kubernetes_pod_annotation_prometheus_io_path"}, Regex: "(.+)", TargetLabel: "__metrics_path__"},
+				{SourceLabels: []string{"__meta_kubernetes_namespace"}, TargetLabel: "namespace"},
+				{SourceLabels: []string{"__meta_kubernetes_pod_name"}, TargetLabel: "pod"},
+			},
+		})
+	}
+	for _, remote := range tsdb.RemoteWrite {
+		config.RemoteWrite = append(config.RemoteWrite, prometheusRemoteWrite{URL: remote.URL, Headers: remote.Headers})
+	}
+	if tm.upstream != nil {
+		config.RemoteWrite = append(config.RemoteWrite, prometheusRemoteWrite{URL: tm.upstream.URL, Headers: tm.upstream.Headers})
+	}
+	return yaml.Marshal(config)
+}
+
+// tsdbWorkloadRequest is the workload running a site's TSDB
+func (tm *TSDBManager) tsdbWorkloadRequest(tsdb *SiteTSDB) WorkloadDeploymentRequest {
+	return WorkloadDeploymentRequest{
+		Name:      "site-tsdb-" + nameSlug(tsdb.SiteID),
+		Namespace: "edge-monitoring",
+		Type:      WorkloadTypeStatefulSet,
+		Image:     tm.image,
+		Replicas:  1,
+		Resources: tsdb.Resources,
+		Environment: map[string]string{
+			"TSDB_SITE_ID":    tsdb.SiteID,
+			"TSDB_CONFIG_URL": fmt.Sprintf("/api/v1/sites/%s/tsdb/config", tsdb.SiteID),
+			"TSDB_RETENTION":  tsdb.Retention,
+		},
+		Labels: map[string]string{SiteTSDBLabel: tsdb.SiteID},
+		Placement: PlacementPolicy{
+			Constraints: []PlacementConstraint{{Key: "site", Operator: "In", Values: []string{tsdb.SiteID}}},
+		},
+		Ports:       []WorkloadPort{{Name: "http", Port: TSDBPort}},
+		Volumes:     []WorkloadVolume{{Name: "data", MountPath: "/prometheus", Size: tsdb.StorageSize}},
+		Criticality: 50,
+	}
+}
+
+// syncSiteTSDBs keeps one TSDB workload per enabled site. Workloads are recreated when
+// their spec changes or they were deleted, and removed along with deleted sites.
+func (co *CentralOrchestrator) syncSiteTSDBs() {
+	co.SiteManager.mutex.RLock()
+	sites := make(map[string]Site, len(co.SiteManager.sites))
+	for id, site := range co.SiteManager.sites {
+		sites[id] = *site
+	}
+	co.SiteManager.mutex.RUnlock()
+
+	tm := co.TSDBManager
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	now := time.Now()
+	for siteID, tsdb := range tm.tsdbs {
+		site, siteExists := sites[siteID]
+		workload, running := co.WorkloadManager.workloads[tsdb.WorkloadID]
+
+		if !siteExists {
+			if running {
+				co.removeTSDBWorkload(workload, now)
+			}
+			delete(tm.tsdbs, siteID)
+			tm.logger.Infof("Removed TSDB of deleted site %s", siteID)
+			continue
+		}
+
+		if config, err := tm.renderConfig(tsdb, &site); err == nil {
+			sum := sha256.Sum256(config)
+			tsdb.ConfigVersion = hex.EncodeToString(sum[:8])
+		}
+
+		req := tm.tsdbWorkloadRequest(tsdb)
+		if running && workload.Image == req.Image && workload.Resources == req.Resources &&
+			len(workload.Volumes) == 1 && workload.Volumes[0] == req.Volumes[0] &&
+			workload.Environment["TSDB_RETENTION"] == tsdb.Retention {
+			continue
+		}
+		if running {
+			co.removeTSDBWorkload(workload, now)
+		}
+
+		workload, err := newWorkload(req, now)
+		if err != nil {
+			tm.logger.Errorf("Failed to create TSDB workload for site %s: %v", siteID, err)
+			continue
+		}
+		co.WorkloadManager.workloads[workload.ID] = workload
+		tsdb.WorkloadID = workload.ID
+		tm.logger.Infof("Deploying TSDB to site %s as workload %s", siteID, workload.ID)
+	}
+}
+
+// removeTSDBWorkload stops a site's TSDB workload; callers must hold the WorkloadManager lock
+func (co *CentralOrchestrator) removeTSDBWorkload(workload *Workload, now time.Time) {
+	workload.Status = WorkloadStatusStopped
+	workload.UpdatedAt = now
+	delete(co.WorkloadManager.workloads, workload.ID)
+	co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, workload.ID)
+	co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workload.ID)
+}
+
+// tsdbController periodically reconciles site TSDB workloads
+func (co *CentralOrchestrator) tsdbController() {
+	ticker := time.NewTicker(TSDBSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.syncSiteTSDBs()
+		}
+	}
+}
+
+// SiteTSDBView is a site's TSDB with the status of its workload
+type SiteTSDBView struct {
+	SiteTSDB
+	Status      WorkloadStatus `json:"status"`
+	NodeID      string         `json:"node_id,omitempty"`
+	RemoteWrite []string       `json:"remote_write_urls"`
+}
+
+// tsdbView describes a site's TSDB; callers must hold the TSDBManager and WorkloadManager locks
+func (co *CentralOrchestrator) tsdbView(tsdb *SiteTSDB) SiteTSDBView {
+	view := SiteTSDBView{SiteTSDB: *tsdb, Status: WorkloadStatusPending, RemoteWrite: make([]string, 0)}
+	if workload, exists := co.WorkloadManager.workloads[tsdb.WorkloadID]; exists {
+		view.Status = workload.Status
+		for _, deployment := range workload.Deployments {
+			if deployment.Status == WorkloadStatusRunning {
+				view.NodeID = deployment.NodeID
+			}
+		}
+	}
+	for _, remote := range tsdb.RemoteWrite {
+		view.RemoteWrite = append(view.RemoteWrite, remote.URL)
+	}
+	if co.TSDBManager.upstream != nil {
+		view.RemoteWrite = append(view.RemoteWrite, co.TSDBManager.upstream.URL)
+	}
+	// Headers may hold credentials and are only handed to the TSDB itself
+	view.SiteTSDBRequest.RemoteWrite = nil
+	return view
+}
+
+// PutSiteTSDB enables the managed TSDB at a site or changes its configuration
+func (co *CentralOrchestrator) PutSiteTSDB(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var req SiteTSDBRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.SiteManager.mutex.RLock()
+	_, exists := co.SiteManager.sites[siteID]
+	co.SiteManager.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	now := time.Now()
+	co.TSDBManager.mutex.Lock()
+	tsdb, exists := co.TSDBManager.tsdbs[siteID]
+	if !exists {
+		tsdb = &SiteTSDB{SiteID: siteID, CreatedAt: now}
+		co.TSDBManager.tsdbs[siteID] = tsdb
+	}
+	tsdb.SiteTSDBRequest = req
+	tsdb.UpdatedAt = now
+	co.TSDBManager.mutex.Unlock()
+
+	co.Logger.Infof("TSDB configured for site %s (retention %s, %d remote-write targets)", siteID, req.Retention, len(req.RemoteWrite))
+	co.syncSiteTSDBs()
+
+	co.TSDBManager.mutex.RLock()
+	defer co.TSDBManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"tsdb": co.tsdbView(tsdb)})
+}
+
+// GetSiteTSDB returns a site's TSDB configuration and workload status
+func (co *CentralOrchestrator) GetSiteTSDB(c *gin.Context) {
+	co.TSDBManager.mutex.RLock()
+	defer co.TSDBManager.mutex.RUnlock()
+
+	tsdb, exists := co.TSDBManager.tsdbs[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No TSDB is enabled at this site"})
+		return
+	}
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"tsdb": co.tsdbView(tsdb)})
+}
+
+// ListSiteTSDBs lists the TSDB at every site that has one
+func (co *CentralOrchestrator) ListSiteTSDBs(c *gin.Context) {
+	co.TSDBManager.mutex.RLock()
+	defer co.TSDBManager.mutex.RUnlock()
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	views := make([]SiteTSDBView, 0, len(co.TSDBManager.tsdbs))
+	for _, tsdb := range co.TSDBManager.tsdbs {
+		views = append(views, co.tsdbView(tsdb))
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].SiteID < views[j].SiteID
+	})
+
+	c.JSON(http.StatusOK, gin.H{"tsdbs": views})
+}
+
+// DeleteSiteTSDB disables a site's TSDB and removes its workload. Data on the volume is
+// lost; anything already forwarded upstream is kept there.
+func (co *CentralOrchestrator) DeleteSiteTSDB(c *gin.Context) {
+	siteID := c.Param("id")
+
+	co.TSDBManager.mutex.Lock()
+	defer co.TSDBManager.mutex.Unlock()
+
+	tsdb, exists := co.TSDBManager.tsdbs[siteID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No TSDB is enabled at this site"})
+		return
+	}
+	delete(co.TSDBManager.tsdbs, siteID)
+
+	co.WorkloadManager.mutex.Lock()
+	if workload, running := co.WorkloadManager.workloads[tsdb.WorkloadID]; running {
+		co.removeTSDBWorkload(workload, time.Now())
+	}
+	co.WorkloadManager.mutex.Unlock()
+
+	co.Logger.Infof("TSDB disabled for site %s", siteID)
+	c.JSON(http.StatusOK, gin.H{"message": "TSDB disabled"})
+}
+
+// GetSiteTSDBConfig returns the Prometheus configuration for a site's TSDB. The TSDB
+// passes its current version in If-None-Match and gets 304 if unchanged.
+func (co *CentralOrchestrator) GetSiteTSDBConfig(c *gin.Context) {
+	siteID := c.Param("id")
+
+	co.SiteManager.mutex.RLock()
+	var site Site
+	entry, siteExists := co.SiteManager.sites[siteID]
+	if siteExists {
+		site = *entry
+	}
+	co.SiteManager.mutex.RUnlock()
+
+	co.TSDBManager.mutex.RLock()
+	tsdb, exists := co.TSDBManager.tsdbs[siteID]
+	var config []byte
+	var err error
+	if exists && siteExists {
+		config, err = co.TSDBManager.renderConfig(tsdb, &site)
+	}
+	co.TSDBManager.mutex.RUnlock()
+
+	if !exists || !siteExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No TSDB is enabled at this site"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to render TSDB configuration: %v", err)})
+		return
+	}
+
+	sum := sha256.Sum256(config)
+	version := hex.EncodeToString(sum[:8])
+	c.Header("ETag", version)
+	if c.GetHeader("If-None-Match") == version {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/yaml", config)
+}
@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -22,6 +23,31 @@ type EdgeNode struct {
 	Capabilities     []string          `json:"capabilities"`
 	Region           string            `json:"region"`
 	Zone             string            `json:"zone"`
+	SiteID           string            `json:"site_id"`
+	Taints           []NodeTaint       `json:"taints"`
+	State            string            `json:"state"`
+	StateReason      string            `json:"state_reason,omitempty"`
+	StateChangedAt   time.Time         `json:"state_changed_at"`
+	// Cordoned by an operator: kept out of scheduling whatever its state
+	Unschedulable    bool              `json:"unschedulable,omitempty"`
+	HeartbeatTransport HeartbeatTransport `json:"heartbeat_transport"`
+	Hardware         *HardwareInventory `json:"hardware,omitempty"`
+	Cameras          []Camera          `json:"cameras,omitempty"`
+	Datasets         []LocalDataset    `json:"datasets,omitempty"`
+	// Devices reached through KubeEdge or Akri, for nodes imported by an interop adapter
+	Devices          []InteropDevice   `json:"devices,omitempty"`
+	// Instance metadata of cloud burst nodes, reported by their agent
+	Cloud            *CloudMetadata    `json:"cloud,omitempty"`
+	// Set once the provider announced it is reclaiming the node's spot instance
+	Preemption       *PreemptionNotice `json:"preemption,omitempty"`
+	// Round-trip times the node's agent measured, reported with its heartbeats
+	Latency          []LatencyMeasurement `json:"latency,omitempty"`
+	// Grade of the node's link to the orchestrator, from the packet loss and jitter it measured
+	LinkQuality      LinkQuality       `json:"link_quality,omitempty"`
+	// Values of the latest runs of the node's agent collectors
+	CustomMetrics    []CustomMetric    `json:"custom_metrics,omitempty"`
+	// Imported cluster managed by the orchestrator through its Kubernetes API, with no agent
+	Agentless        bool              `json:"agentless,omitempty"`
 	KubernetesVersion string           `json:"kubernetes_version"`
 	ContainerRuntime string            `json:"container_runtime"`
 	CreatedAt        time.Time         `json:"created_at"`
@@ -40,23 +66,36 @@ const (
 
 // NodeResources represents the resource capacity and usage of a node
 type NodeResources struct {
+	// The numeric fields are machine-readable; zero means unknown
 	CPU struct {
 		Capacity    string  `json:"capacity"`
 		Usage       string  `json:"usage"`
 		Percentage  float64 `json:"percentage"`
+		CapacityMillicores int64 `json:"capacity_millicores,omitempty"`
+		UsageMillicores    int64 `json:"usage_millicores,omitempty"`
 	} `json:"cpu"`
 	Memory struct {
 		Capacity    string  `json:"capacity"`
 		Usage       string  `json:"usage"`
 		Percentage  float64 `json:"percentage"`
+		CapacityBytes int64 `json:"capacity_bytes,omitempty"`
+		UsageBytes    int64 `json:"usage_bytes,omitempty"`
 	} `json:"memory"`
 	Storage struct {
 		Capacity    string  `json:"capacity"`
 		Usage       string  `json:"usage"`
 		Percentage  float64 `json:"percentage"`
+		CapacityBytes int64 `json:"capacity_bytes,omitempty"`
+		UsageBytes    int64 `json:"usage_bytes,omitempty"`
 	} `json:"storage"`
 	NetworkBandwidth string `json:"network_bandwidth"`
 	GPUs            int    `json:"gpus"`
+	// Per-card details from agents that detect their GPUs
+	GPUDevices      []GPUDevice `json:"gpu_devices,omitempty"`
+	// CPU layout and kubelet policies from agents that detect them
+	CPUTopology     *CPUTopology `json:"cpu_topology,omitempty"`
+	// When the host last booted, from agents that report it
+	BootTime        *time.Time   `json:"boot_time,omitempty"`
 }
 
 // Workload represents a workload that can be deployed to edge nodes
@@ -64,6 +103,8 @@ type Workload struct {
 	ID           string            `json:"id"`
 	Name         string            `json:"name"`
 	Namespace    string            `json:"namespace"`
+	Tenant       string            `json:"tenant"`
+	Metadata     WorkloadMetadata  `json:"metadata"`
 	Type         WorkloadType      `json:"type"`
 	Image        string            `json:"image"`
 	Replicas     int32             `json:"replicas"`
@@ -72,7 +113,41 @@ type Workload struct {
 	Labels       map[string]string `json:"labels"`
 	Selector     map[string]string `json:"selector"`
 	Placement    PlacementPolicy   `json:"placement"`
+	Ports        []WorkloadPort    `json:"ports"`
+	ServiceType  ServiceType       `json:"service_type"`
+	DNS          *WorkloadDNS      `json:"dns,omitempty"`
+	Criticality  int32             `json:"criticality"`
+	QoSClass     QoSClass          `json:"qos_class,omitempty"`
+	ReadinessProbe *Probe          `json:"readiness_probe,omitempty"`
+	Volumes      []WorkloadVolume  `json:"volumes"`
+	Backup       *BackupPolicy     `json:"backup,omitempty"`
+	// Cold files on the workload's volumes are tiered to object storage
+	Offload      *OffloadPolicy    `json:"offload,omitempty"`
+	// Real-time scheduling on PREEMPT_RT nodes, for industrial control loops
+	Realtime     *RealtimePolicy   `json:"realtime,omitempty"`
+	Autoscaling  *WorkloadAutoscaling `json:"autoscaling,omitempty"`
+	// New tags of the workload's image roll it out as the registry reports them pushed
+	ImageSubscription *ImageSubscription `json:"image_subscription,omitempty"`
+	// Camera stream the workload analyzes, set by the video analytics template
+	Camera       *CameraBinding    `json:"camera,omitempty"`
+	// Workloads are torn down across the fleet once this time passes
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	// Retries of jobs and the schedule of cron jobs
+	Job          *JobPolicy        `json:"job,omitempty"`
+	JobStatus    *JobStatus        `json:"job_status,omitempty"`
+	// Cron job that created this job
+	CronJobID    string            `json:"cron_job_id,omitempty"`
+	// Environment the workload was instantiated in from a workload definition
+	EnvironmentBinding *EnvironmentBinding `json:"environment_binding,omitempty"`
+	// New version running next to the workload until its traffic is switched over
+	BlueGreen    *BlueGreenDeployment `json:"blue_green,omitempty"`
+	// Workload whose new version this is, for the preview of a blue-green deployment
+	BlueGreenOf  string            `json:"blue_green_of,omitempty"`
+	// Latest revision of the workload's spec in its revision history
+	Revision     int64             `json:"revision,omitempty"`
 	Status       WorkloadStatus    `json:"status"`
+	// Why the scheduler could not place all of the workload's replicas
+	Scheduling   *SchedulingCondition `json:"scheduling,omitempty"`
 	Deployments  []WorkloadDeployment `json:"deployments"`
 	CreatedAt    time.Time         `json:"created_at"`
 	UpdatedAt    time.Time         `json:"updated_at"`
@@ -98,8 +173,43 @@ const (
 	WorkloadStatusCompleted WorkloadStatus = "completed"
 	WorkloadStatusFailed    WorkloadStatus = "failed"
 	WorkloadStatusStopped   WorkloadStatus = "stopped"
+	// Cron jobs, which are never placed themselves but create jobs on their schedule
+	WorkloadStatusScheduled WorkloadStatus = "scheduled"
 )
 
+// WorkloadPort defines a port exposed by a workload's service
+type WorkloadPort struct {
+	Name       string `json:"name"`
+	Port       int32  `json:"port"`
+	TargetPort int32  `json:"target_port"`
+	NodePort   int32  `json:"node_port,omitempty"`
+	Protocol   string `json:"protocol"`
+}
+
+// ServiceType defines how a workload's ports are exposed on the edge cluster
+type ServiceType string
+
+const (
+	ServiceTypeClusterIP    ServiceType = "ClusterIP"
+	ServiceTypeNodePort     ServiceType = "NodePort"
+	ServiceTypeLoadBalancer ServiceType = "LoadBalancer"
+)
+
+// ServiceEndpoint is a reachable address for a workload port on a specific node
+type ServiceEndpoint struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+	// Kubernetes type of the service exposing it: ClusterIP, NodePort or LoadBalancer
+	Type string `json:"type,omitempty"`
+}
+
+// external reports whether the endpoint is reachable from outside its node's cluster
+func (e ServiceEndpoint) external() bool {
+	return e.Type == "NodePort" || e.Type == "LoadBalancer"
+}
+
 // WorkloadResources defines resource requirements for a workload
 type WorkloadResources struct {
 	Requests struct {
@@ -110,6 +220,10 @@ type WorkloadResources struct {
 		CPU    string `json:"cpu"`
 		Memory string `json:"memory"`
 	} `json:"limits"`
+	// GPUs each replica needs
+	GPU *GPURequest `json:"gpu,omitempty"`
+	// Exclusive CPUs for latency-critical replicas
+	CPUPinning *CPUPinning `json:"cpu_pinning,omitempty"`
 }
 
 // PlacementPolicy defines where and how workloads should be placed
@@ -117,6 +231,22 @@ type PlacementPolicy struct {
 	Strategy    PlacementStrategy     `json:"strategy"`
 	Constraints []PlacementConstraint `json:"constraints"`
 	Preferences []PlacementPreference `json:"preferences"`
+	Tolerations []Toleration          `json:"tolerations"`
+	// Place at most one replica at each site
+	OneReplicaPerSite bool `json:"one_replica_per_site"`
+	// Place at most this many replicas on any one node; 0 for no limit beyond capacity
+	MaxReplicasPerNode int32 `json:"max_replicas_per_node,omitempty"`
+	// Place replicas only on nodes running workloads these terms select
+	WorkloadAffinity []WorkloadAffinityTerm `json:"workload_affinity,omitempty"`
+	// Never place replicas on nodes running workloads these terms select
+	WorkloadAntiAffinity []WorkloadAffinityTerm `json:"workload_anti_affinity,omitempty"`
+	// Provision cloud nodes when no edge node can take the workload
+	AllowCloudBurst bool `json:"allow_cloud_burst"`
+	// Place all replicas, or the whole gang group, at once or not at all
+	Gang *GangPolicy `json:"gang,omitempty"`
+	// Target the latency-aware strategy minimizes round-trip time to: "orchestrator"
+	// (default) or a probe target configured on the agents
+	LatencyTarget string `json:"latency_target,omitempty"`
 }
 
 // PlacementStrategy defines the strategy for workload placement
@@ -148,18 +278,94 @@ type WorkloadDeployment struct {
 	NodeID     string         `json:"node_id"`
 	Status     WorkloadStatus `json:"status"`
 	Replicas   int32         `json:"replicas"`
+	// Runs of a job on the node, counted up each time a failed run is retried here
+	Attempt    int32         `json:"attempt,omitempty"`
+	Endpoints  []ServiceEndpoint `json:"endpoints"`
+	// What the node's agent last reported for the workload
+	Observed   *ObservedWorkloadStatus `json:"observed,omitempty"`
 	DeployedAt time.Time     `json:"deployed_at"`
 	UpdatedAt  time.Time     `json:"updated_at"`
 }
 
+// ObservedWorkloadStatus is how a workload is actually doing on a node, as reported
+// by the agent running it
+type ObservedWorkloadStatus struct {
+	Phase           ObservedPhase `json:"phase"`
+	DesiredReplicas int32         `json:"desired_replicas"`
+	ReadyReplicas   int32         `json:"ready_replicas"`
+	// Container restarts across the workload's pods
+	Restarts int32 `json:"restarts"`
+	// Why pods are not running, such as ImagePullBackOff or CrashLoopBackOff
+	Reason     string    `json:"reason,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
 // CentralOrchestrator is the main orchestrator struct
 type CentralOrchestrator struct {
-	NodeManager       *NodeManager
-	WorkloadManager   *WorkloadManager
-	SecurityManager   *SecurityManager
-	MonitoringService *MonitoringService
-	Logger            *logrus.Logger
-	mu                sync.RWMutex
+	NodeManager          *NodeManager
+	WorkloadManager      *WorkloadManager
+	SecurityManager      *SecurityManager
+	MonitoringService    *MonitoringService
+	MetricsStore         *MetricsStore
+	DNSManager           *DNSManager
+	SiteManager          *SiteManager
+	AlertManager         *AlertManager
+	OperationManager     *OperationManager
+	CloudProvisioner     *CloudProvisioner
+	MigrationManager     *MigrationManager
+	SnapshotManager      *SnapshotManager
+	UptimeTracker        *UptimeTracker
+	NodeStateManager     *NodeStateManager
+	MessageCatalog       *MessageCatalog
+	ReservationManager   *ReservationManager
+	SummaryCache         *SummaryCache
+	LogSummaryStore      *LogSummaryStore
+	RevisionHistory      *WorkloadRevisionHistory
+	RegistryWebhooks     *RegistryWebhooks
+	ChatOps              *ChatOps
+	IncidentSync         *IncidentSync
+	BillingManager       *BillingManager
+	ConsistencyChecker   *ConsistencyChecker
+	SilenceManager       *SilenceManager
+	SiteGatewayManager   *SiteGatewayManager
+	UDPHeartbeatServer   *UDPHeartbeatServer
+	DesiredStateCache    *DesiredStateCache
+	PlacementReevaluator *PlacementReevaluator
+	TenantScheduler      *TenantScheduler
+	NamespaceManager     *NamespaceManager
+	OrganizationManager  *OrganizationManager
+	Scheduler            *Scheduler
+	CommandManager       *CommandManager
+	CampaignManager      *CampaignManager
+	PatchManager         *PatchManager
+	AuditLog             *AuditLog
+	TunnelBroker         *TunnelBroker
+	ACMEServer           *ACMEServer
+	LoadForecaster       *LoadForecaster
+	FederatedJobManager  *FederatedJobManager
+	FunctionManager      *FunctionManager
+	DatasetCatalog       *DatasetCatalog
+	OffloadManager       *OffloadManager
+	TSDBManager          *TSDBManager
+	StateStore           *StateStore
+	ImageBuilder         *ImageBuilder
+	ClaimManager         *ClaimManager
+	ReplacementManager   *ReplacementManager
+	AgentStreamHub       *AgentStreamHub
+	ImportedClusters     *ImportedClusterManager
+	Interop              *InteropManager
+	OCMHubs              *OCMHubManager
+	EnvironmentManager   *EnvironmentManager
+	LeaderElection       *LeaderElection
+	FleetSnapshots       *FleetSnapshotManager
+	Logger               *logrus.Logger
+	// Placement decisions are made as of this time rather than the current one when set,
+	// as when replaying a fleet snapshot
+	schedulingTime       time.Time
+	// Routes registered with nodeRoute, by method and path
+	nodeIdentityRoutes   map[string]bool
+	mu                   sync.RWMutex
 }
 
 // NodeManager manages edge nodes
@@ -179,8 +385,34 @@ type WorkloadManager struct {
 // SecurityManager handles security operations
 type SecurityManager struct {
 	certificates map[string]*Certificate
+	fingerprints map[string]string
 	mutex        sync.RWMutex
 	logger       *logrus.Logger
+
+	// Reject token-only callers on node-scoped routes
+	requireClientCerts bool
+
+	// Shared by orchestrator replicas to vouch for client certificates they forward
+	replicaToken string
+
+	// CA that signs node certificates, or why none could be configured
+	signer    CertificateSigner
+	signerErr error
+
+	// API tokens and role bindings; nil accepts any bearer token with full access
+	policy *AccessPolicy
+
+	// JWT signing keys read from jwtKeysFile, reloaded when its modification time
+	// changes; nil when JWT authentication is not configured
+	jwt            *JWTConfig
+	jwtKeysFile    string
+	jwtKeysModTime time.Time
+
+	// Recognizes the join tokens and claim secrets of devices joining the fleet, which
+	// the orchestrator holds; returns the user and the join token ID, if any
+	lookupDevice func(c *gin.Context, secret string) (string, string, bool)
+	// Adds the projects of the organizations among tenants, which the orchestrator holds
+	expandTenants func(tenants []string) []string
 }
 
 // MonitoringService provides monitoring and metrics
@@ -192,12 +424,18 @@ type MonitoringService struct {
 
 // Certificate represents a TLS certificate
 type Certificate struct {
-	ID          string    `json:"id"`
-	NodeID      string    `json:"node_id"`
-	Certificate []byte    `json:"certificate"`
-	PrivateKey  []byte    `json:"private_key"`
-	IssuedAt    time.Time `json:"issued_at"`
-	ExpiresAt   time.Time `json:"expires_at"`
+	ID          string     `json:"id"`
+	NodeID      string     `json:"node_id"`
+	Certificate []byte     `json:"certificate"`
+	// PEM chain of the issuing CA, up to the root
+	Chain       []byte     `json:"chain,omitempty"`
+	PrivateKey  []byte     `json:"private_key"`
+	// Name of the CA signer that issued the certificate
+	Signer      string     `json:"signer"`
+	IssuedAt    time.Time  `json:"issued_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	// Revoked certificates stay in the inventory but no longer authenticate
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
 }
 
 // NodeRegistrationRequest represents a node registration request
@@ -206,8 +444,11 @@ type NodeRegistrationRequest struct {
 	Address          string            `json:"address" binding:"required"`
 	Labels           map[string]string `json:"labels"`
 	Capabilities     []string          `json:"capabilities"`
+	// Taints the agent is configured with, such as reserving the node for GPU workloads
+	Taints           []NodeTaint       `json:"taints"`
 	Region           string            `json:"region"`
 	Zone             string            `json:"zone"`
+	SiteID           string            `json:"site_id"`
 	KubernetesVersion string           `json:"kubernetes_version"`
 	ContainerRuntime string            `json:"container_runtime"`
 }
@@ -216,6 +457,8 @@ type NodeRegistrationRequest struct {
 type WorkloadDeploymentRequest struct {
 	Name         string            `json:"name" binding:"required"`
 	Namespace    string            `json:"namespace"`
+	Tenant       string            `json:"tenant"`
+	Metadata     WorkloadMetadata  `json:"metadata"`
 	Type         WorkloadType      `json:"type" binding:"required"`
 	Image        string            `json:"image" binding:"required"`
 	Replicas     int32             `json:"replicas"`
@@ -223,6 +466,21 @@ type WorkloadDeploymentRequest struct {
 	Environment  map[string]string `json:"environment"`
 	Labels       map[string]string `json:"labels"`
 	Placement    PlacementPolicy   `json:"placement"`
+	Ports        []WorkloadPort    `json:"ports"`
+	ServiceType  ServiceType       `json:"service_type"`
+	DNS          *WorkloadDNS      `json:"dns"`
+	// Higher criticality workloads are re-placed first after failures
+	Criticality  int32             `json:"criticality"`
+	QoSClass     QoSClass          `json:"qos_class,omitempty"`
+	ReadinessProbe *Probe          `json:"readiness_probe"`
+	Volumes      []WorkloadVolume  `json:"volumes"`
+	Backup       *BackupPolicy     `json:"backup"`
+	Offload      *OffloadPolicy    `json:"offload"`
+	Realtime     *RealtimePolicy   `json:"realtime"`
+	Job          *JobPolicy        `json:"job"`
+	// Either a duration such as "6h" or an absolute expiry
+	TTL          string            `json:"ttl"`
+	ExpiresAt    *time.Time        `json:"expires_at"`
 }
 
 // HeartbeatRequest represents a node heartbeat request
@@ -230,9 +488,20 @@ type HeartbeatRequest struct {
 	Status    NodeStatus    `json:"status"`
 	Resources NodeResources `json:"resources"`
 	Timestamp time.Time     `json:"timestamp"`
+	// Latest round-trip measurements; omitted by agents that do not probe latency
+	Latency []LatencyMeasurement `json:"latency,omitempty"`
+	// Latest analysis of each workload's logs; omitted by agents that do not analyze logs
+	LogSummaries []WorkloadLogSummary `json:"log_summaries,omitempty"`
+	// Values of the agent's collectors; omitted by agents without collectors
+	CustomMetrics []CustomMetric `json:"custom_metrics,omitempty"`
 }
 
 // ScaleWorkloadRequest represents a workload scaling request
 type ScaleWorkloadRequest struct {
 	Replicas int32 `json:"replicas" binding:"required"`
 }
+
+// EndpointReportRequest represents the service endpoints an agent reports for a workload
+type EndpointReportRequest struct {
+	Endpoints []ServiceEndpoint `json:"endpoints"`
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long agents wait for a UDP heartbeat ack before retrying
+	UDPHeartbeatAckTimeout = 2 * time.Second
+
+	// Largest accepted heartbeat datagram
+	maxUDPHeartbeatSize = 64 * 1024
+)
+
+// HeartbeatTransport is how a node delivers heartbeats
+type HeartbeatTransport string
+
+const (
+	HeartbeatTransportHTTPS HeartbeatTransport = "https"
+	// Signed UDP datagrams with an application-level ack; tolerates loss on satellite links
+	HeartbeatTransportUDP HeartbeatTransport = "udp"
+	// Polled by the orchestrator from an imported cluster's Kubernetes API
+	HeartbeatTransportKubernetesAPI HeartbeatTransport = "kubernetes-api"
+	// Forwarded by the site's gateway agent in its batch
+	HeartbeatTransportSiteGateway HeartbeatTransport = "site-gateway"
+)
+
+// HeartbeatTransportRequest lists the transports an agent supports, most preferred first
+type HeartbeatTransportRequest struct {
+	Preferred []HeartbeatTransport `json:"preferred" binding:"required"`
+}
+
+// HeartbeatTransportResponse is the negotiated transport. For UDP it carries the port and
+// the per-node key used to sign datagrams; HTTPS heartbeats are always accepted as fallback.
+type HeartbeatTransportResponse struct {
+	Transport    HeartbeatTransport `json:"transport"`
+	Port         int                `json:"port,omitempty"`
+	Key          string             `json:"key,omitempty"`
+	AckTimeoutMS int64              `json:"ack_timeout_ms,omitempty"`
+}
+
+// udpHeartbeat is the JSON body of a heartbeat datagram. Datagrams are the 32-byte
+// HMAC-SHA256 of the body under the node key followed by the body.
+type udpHeartbeat struct {
+	NodeID    string           `json:"node_id"`
+	Seq       uint64           `json:"seq"`
+	Heartbeat HeartbeatRequest `json:"heartbeat"`
+}
+
+// udpHeartbeatAck acknowledges a heartbeat sequence number, signed the same way
+type udpHeartbeatAck struct {
+	NodeID string `json:"node_id"`
+	Seq    uint64 `json:"seq"`
+}
+
+// udpSession is the negotiated UDP state of one node
+type udpSession struct {
+	key     []byte
+	lastSeq uint64
+}
+
+// UDPHeartbeatServer receives heartbeats over UDP
+type UDPHeartbeatServer struct {
+	port     int
+	sessions map[string]*udpSession
+	mutex    sync.Mutex
+	logger   *logrus.Logger
+}
+
+// NewUDPHeartbeatServer creates the UDP heartbeat server; it is disabled unless
+// HEARTBEAT_UDP_PORT is set
+func NewUDPHeartbeatServer(logger *logrus.Logger) *UDPHeartbeatServer {
+	server := &UDPHeartbeatServer{
+		sessions: make(map[string]*udpSession),
+		logger:   logger,
+	}
+
+	if value := os.Getenv("HEARTBEAT_UDP_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			logger.Errorf("Invalid HEARTBEAT_UDP_PORT %q, UDP heartbeats disabled", value)
+		} else {
+			server.port = port
+		}
+	}
+
+	return server
+}
+
+// Enabled reports whether UDP heartbeats are accepted
+func (s *UDPHeartbeatServer) Enabled() bool {
+	return s.port != 0
+}
+
+// signDatagram prefixes a body with its HMAC
+func signDatagram(key, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return append(mac.Sum(nil), body...)
+}
+
+// verifyDatagram checks a datagram's HMAC and returns its body
+func verifyDatagram(key, datagram []byte) ([]byte, bool) {
+	if len(datagram) <= sha256.Size {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(datagram[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), datagram[:sha256.Size]) {
+		return nil, false
+	}
+	return datagram[sha256.Size:], true
+}
+
+// serveUDPHeartbeats listens for heartbeat datagrams until the listener fails
+func (co *CentralOrchestrator) serveUDPHeartbeats() {
+	server := co.UDPHeartbeatServer
+	if !server.Enabled() {
+		return
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: server.port})
+	if err != nil {
+		co.Logger.Errorf("Failed to listen for UDP heartbeats: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	co.Logger.Infof("Listening for UDP heartbeats on port %d", server.port)
+
+	buffer := make([]byte, maxUDPHeartbeatSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			co.Logger.Errorf("Failed to read UDP heartbeat: %v", err)
+			continue
+		}
+
+		ack, err := co.handleUDPHeartbeat(buffer[:n])
+		if err != nil {
+			co.Logger.Debugf("Dropped UDP heartbeat from %s: %v", addr, err)
+			continue
+		}
+		if _, err := conn.WriteToUDP(ack, addr); err != nil {
+			co.Logger.Warnf("Failed to ack UDP heartbeat to %s: %v", addr, err)
+		}
+	}
+}
+
+// handleUDPHeartbeat verifies and applies a datagram and returns the signed ack. Retransmits
+// of the latest sequence number are acked again without being re-applied.
+func (co *CentralOrchestrator) handleUDPHeartbeat(datagram []byte) ([]byte, error) {
+	if len(datagram) <= sha256.Size {
+		return nil, fmt.Errorf("datagram too short")
+	}
+
+	var heartbeat udpHeartbeat
+	if err := json.Unmarshal(datagram[sha256.Size:], &heartbeat); err != nil {
+		return nil, fmt.Errorf("invalid heartbeat body: %v", err)
+	}
+
+	server := co.UDPHeartbeatServer
+	server.mutex.Lock()
+	session, exists := server.sessions[heartbeat.NodeID]
+	if !exists {
+		server.mutex.Unlock()
+		return nil, fmt.Errorf("no UDP session for node %s", heartbeat.NodeID)
+	}
+	if _, ok := verifyDatagram(session.key, datagram); !ok {
+		server.mutex.Unlock()
+		return nil, fmt.Errorf("bad signature for node %s", heartbeat.NodeID)
+	}
+	if heartbeat.Seq < session.lastSeq {
+		server.mutex.Unlock()
+		return nil, fmt.Errorf("stale sequence %d for node %s", heartbeat.Seq, heartbeat.NodeID)
+	}
+	fresh := heartbeat.Seq > session.lastSeq
+	session.lastSeq = heartbeat.Seq
+	key := session.key
+	server.mutex.Unlock()
+
+	if fresh && !co.applyHeartbeat(heartbeat.NodeID, heartbeat.Heartbeat, HeartbeatTransportUDP) {
+		return nil, fmt.Errorf("node %s not found", heartbeat.NodeID)
+	}
+
+	body, err := json.Marshal(udpHeartbeatAck{NodeID: heartbeat.NodeID, Seq: heartbeat.Seq})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ack: %v", err)
+	}
+	return signDatagram(key, body), nil
+}
+
+// NegotiateHeartbeatTransport picks the first transport the agent prefers that the
+// orchestrator supports, issuing a fresh UDP key when UDP is chosen
+func (co *CentralOrchestrator) NegotiateHeartbeatTransport(c *gin.Context) {
+	nodeID := c.Param("id")
+
+	var req HeartbeatTransportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.NodeManager.mutex.RLock()
+	_, exists := co.NodeManager.nodes[nodeID]
+	co.NodeManager.mutex.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	response := HeartbeatTransportResponse{Transport: HeartbeatTransportHTTPS}
+	for _, transport := range req.Preferred {
+		if transport == HeartbeatTransportHTTPS {
+			break
+		}
+		if transport != HeartbeatTransportUDP || !co.UDPHeartbeatServer.Enabled() {
+			continue
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate heartbeat key"})
+			return
+		}
+
+		server := co.UDPHeartbeatServer
+		server.mutex.Lock()
+		server.sessions[nodeID] = &udpSession{key: key}
+		server.mutex.Unlock()
+
+		response = HeartbeatTransportResponse{
+			Transport:    HeartbeatTransportUDP,
+			Port:         server.port,
+			Key:          hex.EncodeToString(key),
+			AckTimeoutMS: UDPHeartbeatAckTimeout.Milliseconds(),
+		}
+		break
+	}
+
+	co.Logger.Infof("Node %s negotiated %s heartbeats", nodeID, response.Transport)
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Heartbeats further apart than this count as downtime between them
+	UptimeGapTolerance = 2 * time.Minute
+
+	// How often SLA compliance is evaluated
+	SLACheckInterval = 5 * time.Minute
+
+	// Uptime history is kept for the longest window plus a margin
+	UptimeRetention = 35 * 24 * time.Hour
+)
+
+// UptimeWindow is a rolling period availability is computed over
+type UptimeWindow string
+
+const (
+	UptimeWindowDaily   UptimeWindow = "daily"
+	UptimeWindowWeekly  UptimeWindow = "weekly"
+	UptimeWindowMonthly UptimeWindow = "monthly"
+)
+
+var uptimeWindows = []UptimeWindow{UptimeWindowDaily, UptimeWindowWeekly, UptimeWindowMonthly}
+
+// Duration returns the length of the rolling window
+func (w UptimeWindow) Duration() time.Duration {
+	switch w {
+	case UptimeWindowDaily:
+		return 24 * time.Hour
+	case UptimeWindowWeekly:
+		return 7 * 24 * time.Hour
+	case UptimeWindowMonthly:
+		return 30 * 24 * time.Hour
+	}
+	return 0
+}
+
+// uptimeInterval is a continuous run of heartbeats
+type uptimeInterval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// nodeUptime is the heartbeat history of a single node
+type nodeUptime struct {
+	FirstSeen time.Time
+	Intervals []uptimeInterval
+}
+
+// Availability is a node's uptime over one window
+type Availability struct {
+	Window          UptimeWindow `json:"window"`
+	ObservedSeconds int64        `json:"observed_seconds"`
+	UptimeSeconds   int64        `json:"uptime_seconds"`
+	Percent         float64      `json:"percent"`
+}
+
+// SLAPolicy sets an availability target for a group of nodes selected by labels
+type SLAPolicy struct {
+	ID string `json:"id"`
+	NodeGroup
+	Name          string       `json:"name"`
+	Window        UptimeWindow `json:"window"`
+	TargetPercent float64      `json:"target_percent"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// SLAPolicyRequest represents an SLA policy creation request
+type SLAPolicyRequest struct {
+	Name          string            `json:"name" binding:"required"`
+	NodeSelector  map[string]string `json:"node_selector"`
+	SiteID        string            `json:"site_id"`
+	Window        UptimeWindow      `json:"window"`
+	TargetPercent float64           `json:"target_percent" binding:"required"`
+}
+
+// NodeUptimeReport is the availability of one node across all windows
+type NodeUptimeReport struct {
+	NodeID       string         `json:"node_id"`
+	NodeName     string         `json:"node_name"`
+	SiteID       string         `json:"site_id,omitempty"`
+	Availability []Availability `json:"availability"`
+	Breaches     []string       `json:"breaches"`
+}
+
+// UptimeTracker records heartbeat continuity per node and the SLA policies evaluated against it
+type UptimeTracker struct {
+	nodes    map[string]*nodeUptime
+	policies map[string]*SLAPolicy
+	mutex    sync.RWMutex
+	logger   *logrus.Logger
+}
+
+// NewUptimeTracker creates a new uptime tracker
+func NewUptimeTracker(logger *logrus.Logger) *UptimeTracker {
+	return &UptimeTracker{
+		nodes:    make(map[string]*nodeUptime),
+		policies: make(map[string]*SLAPolicy),
+		logger:   logger,
+	}
+}
+
+// RecordHeartbeat extends the node's current uptime interval, or opens a new one after a gap
+func (ut *UptimeTracker) RecordHeartbeat(nodeID string, at time.Time) {
+	ut.mutex.Lock()
+	defer ut.mutex.Unlock()
+
+	history, exists := ut.nodes[nodeID]
+	if !exists {
+		history = &nodeUptime{FirstSeen: at}
+		ut.nodes[nodeID] = history
+	}
+
+	if n := len(history.Intervals); n > 0 && at.Sub(history.Intervals[n-1].End) <= UptimeGapTolerance {
+		history.Intervals[n-1].End = at
+	} else {
+		history.Intervals = append(history.Intervals, uptimeInterval{Start: at, End: at})
+	}
+
+	// Drop intervals that no window can reach any more
+	cutoff := at.Add(-UptimeRetention)
+	for len(history.Intervals) > 1 && history.Intervals[0].End.Before(cutoff) {
+		history.Intervals = history.Intervals[1:]
+	}
+}
+
+// Availability computes a node's uptime over a rolling window ending now. Time before the node
+// was first seen is not counted against it.
+func (ut *UptimeTracker) Availability(nodeID string, window UptimeWindow, now time.Time) Availability {
+	ut.mutex.RLock()
+	defer ut.mutex.RUnlock()
+
+	result := Availability{Window: window}
+	history, exists := ut.nodes[nodeID]
+	if !exists {
+		return result
+	}
+
+	start := now.Add(-window.Duration())
+	if history.FirstSeen.After(start) {
+		start = history.FirstSeen
+	}
+
+	var up time.Duration
+	for i, interval := range history.Intervals {
+		from, to := interval.Start, interval.End
+		// The latest interval is still up if its heartbeat is recent
+		if i == len(history.Intervals)-1 && now.Sub(to) <= UptimeGapTolerance {
+			to = now
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(now) {
+			to = now
+		}
+		if to.After(from) {
+			up += to.Sub(from)
+		}
+	}
+
+	observed := now.Sub(start)
+	result.ObservedSeconds = int64(observed.Seconds())
+	result.UptimeSeconds = int64(up.Seconds())
+	if observed > 0 {
+		result.Percent = float64(up) / float64(observed) * 100
+	} else {
+		result.Percent = 100
+	}
+	return result
+}
+
+// slaAlertName is the alert raised when a node breaches a policy
+func slaAlertName(policy *SLAPolicy) string {
+	return "SLABreach:" + policy.ID
+}
+
+// slaMonitor evaluates SLA policies periodically
+func (co *CentralOrchestrator) slaMonitor() {
+	ticker := time.NewTicker(SLACheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			co.evaluateSLAs()
+		}
+	}
+}
+
+// evaluateSLAs fires breach alerts for nodes below their group's availability target
+func (co *CentralOrchestrator) evaluateSLAs() {
+	co.UptimeTracker.mutex.RLock()
+	policies := make([]*SLAPolicy, 0, len(co.UptimeTracker.policies))
+	for _, policy := range co.UptimeTracker.policies {
+		policies = append(policies, policy)
+	}
+	co.UptimeTracker.mutex.RUnlock()
+
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	now := time.Now()
+	for _, policy := range policies {
+		for _, node := range co.NodeManager.nodes {
+			if !policy.matchesNode(node) {
+				continue
+			}
+			availability := co.UptimeTracker.Availability(node.ID, policy.Window, now)
+			if availability.Percent < policy.TargetPercent {
+				co.AlertManager.Fire(slaAlertName(policy), AlertSeverityWarning, AlertScopeNode, node.ID, node.SiteID,
+					newMessage(MsgSLABreach, "node", node.Name, "window", policy.Window,
+						"availability", fmt.Sprintf("%.3f", availability.Percent), "policy", policy.Name,
+						"target", fmt.Sprintf("%.3f", policy.TargetPercent)))
+			} else {
+				co.AlertManager.Resolve(slaAlertName(policy), AlertScopeNode, node.ID)
+			}
+		}
+	}
+}
+
+// nodeUptimeReport builds the availability report for one node; callers must hold the node lock
+func (co *CentralOrchestrator) nodeUptimeReport(node *EdgeNode, now time.Time) NodeUptimeReport {
+	report := NodeUptimeReport{
+		NodeID:   node.ID,
+		NodeName: node.Name,
+		SiteID:   node.SiteID,
+		Breaches: make([]string, 0),
+	}
+	for _, window := range uptimeWindows {
+		report.Availability = append(report.Availability, co.UptimeTracker.Availability(node.ID, window, now))
+	}
+
+	co.UptimeTracker.mutex.RLock()
+	defer co.UptimeTracker.mutex.RUnlock()
+	for _, policy := range co.UptimeTracker.policies {
+		if !policy.matchesNode(node) {
+			continue
+		}
+		for _, availability := range report.Availability {
+			if availability.Window == policy.Window && availability.Percent < policy.TargetPercent {
+				report.Breaches = append(report.Breaches, policy.ID)
+			}
+		}
+	}
+	return report
+}
+
+// GetNodeUptime returns a node's availability over each rolling window
+func (co *CentralOrchestrator) GetNodeUptime(c *gin.Context) {
+	co.NodeManager.mutex.RLock()
+	defer co.NodeManager.mutex.RUnlock()
+
+	node, exists := co.NodeManager.nodes[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uptime": co.nodeUptimeReport(node, time.Now())})
+}
+
+// GetUptimeReport returns availability for all nodes, as JSON or as CSV with format=csv
+func (co *CentralOrchestrator) GetUptimeReport(c *gin.Context) {
+	co.NodeManager.mutex.RLock()
+	now := time.Now()
+	reports := make([]NodeUptimeReport, 0, len(co.NodeManager.nodes))
+	for _, node := range co.NodeManager.nodes {
+		if siteID := c.Query("site_id"); siteID != "" && node.SiteID != siteID {
+			continue
+		}
+		reports = append(reports, co.nodeUptimeReport(node, now))
+	}
+	co.NodeManager.mutex.RUnlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].NodeName < reports[j].NodeName
+	})
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"generated_at": now, "nodes": reports})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=uptime-%s.csv", now.Format("2006-01-02")))
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"node_id", "node_name", "site_id", "window", "observed_seconds", "uptime_seconds", "availability_percent"})
+	for _, report := range reports {
+		for _, availability := range report.Availability {
+			writer.Write([]string{
+				report.NodeID,
+				report.NodeName,
+				report.SiteID,
+				string(availability.Window),
+				strconv.FormatInt(availability.ObservedSeconds, 10),
+				strconv.FormatInt(availability.UptimeSeconds, 10),
+				strconv.FormatFloat(availability.Percent, 'f', 3, 64),
+			})
+		}
+	}
+	writer.Flush()
+}
+
+// CreateSLAPolicy creates an SLA policy for a node group
+func (co *CentralOrchestrator) CreateSLAPolicy(c *gin.Context) {
+	var req SLAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Window == "" {
+		req.Window = UptimeWindowMonthly
+	}
+	if req.Window.Duration() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be daily, weekly or monthly"})
+		return
+	}
+	if req.TargetPercent <= 0 || req.TargetPercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_percent must be between 0 and 100"})
+		return
+	}
+
+	policy := &SLAPolicy{
+		ID:            generateID(),
+		NodeGroup:     NodeGroup{NodeSelector: req.NodeSelector, SiteID: req.SiteID},
+		Name:          req.Name,
+		Window:        req.Window,
+		TargetPercent: req.TargetPercent,
+		CreatedAt:     time.Now(),
+	}
+	if policy.NodeSelector == nil {
+		policy.NodeSelector = make(map[string]string)
+	}
+
+	co.UptimeTracker.mutex.Lock()
+	co.UptimeTracker.policies[policy.ID] = policy
+	co.UptimeTracker.mutex.Unlock()
+
+	co.Logger.Infof("SLA policy %s created with ID %s", policy.Name, policy.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"id": policy.ID, "policy": policy})
+}
+
+// ListSLAPolicies returns all SLA policies
+func (co *CentralOrchestrator) ListSLAPolicies(c *gin.Context) {
+	co.UptimeTracker.mutex.RLock()
+	defer co.UptimeTracker.mutex.RUnlock()
+
+	policies := make([]*SLAPolicy, 0, len(co.UptimeTracker.policies))
+	for _, policy := range co.UptimeTracker.policies {
+		policies = append(policies, policy)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// DeleteSLAPolicy removes an SLA policy and resolves its breach alerts
+func (co *CentralOrchestrator) DeleteSLAPolicy(c *gin.Context) {
+	policyID := c.Param("id")
+
+	co.UptimeTracker.mutex.Lock()
+	policy, exists := co.UptimeTracker.policies[policyID]
+	delete(co.UptimeTracker.policies, policyID)
+	co.UptimeTracker.mutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SLA policy not found"})
+		return
+	}
+
+	for _, alert := range co.AlertManager.List(AlertFilter{Status: string(AlertStatusFiring), Scope: string(AlertScopeNode)}) {
+		if alert.Name == slaAlertName(policy) {
+			co.AlertManager.Resolve(alert.Name, AlertScopeNode, alert.ScopeID)
+		}
+	}
+
+	co.Logger.Infof("SLA policy %s deleted", policyID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "SLA policy deleted successfully"})
+}
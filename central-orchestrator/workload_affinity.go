@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+)
+
+// WorkloadAffinityTerm selects other workloads of the same tenant by their labels. Under
+// workload_affinity a replica is only placed on a node running a selected workload; under
+// workload_anti_affinity never on one.
+type WorkloadAffinityTerm struct {
+	WorkloadSelector map[string]string `json:"workload_selector"`
+}
+
+// validate checks that a term selects something
+func (t WorkloadAffinityTerm) validate() error {
+	if len(t.WorkloadSelector) == 0 {
+		return fmt.Errorf("workload affinity terms require a workload_selector")
+	}
+	for key := range t.WorkloadSelector {
+		if key == "" {
+			return fmt.Errorf("workload_selector keys must not be empty")
+		}
+	}
+	return nil
+}
+
+// selects reports whether a term of owner's placement policy selects another workload
+func (t WorkloadAffinityTerm) selects(owner, other *Workload) bool {
+	if workloadTenant(owner) != workloadTenant(other) {
+		return false
+	}
+	for key, value := range t.WorkloadSelector {
+		if other.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// antiAffineToItself reports whether a workload's anti-affinity selects the workload
+// itself, so that no two of its replicas share a node
+func (w *Workload) antiAffineToItself() bool {
+	for _, term := range w.Placement.WorkloadAntiAffinity {
+		if term.selects(w, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWorkloadAffinity checks a placement policy's workload affinity terms
+func validateWorkloadAffinity(placement PlacementPolicy) error {
+	for _, term := range placement.WorkloadAffinity {
+		if err := term.validate(); err != nil {
+			return err
+		}
+	}
+	for _, term := range placement.WorkloadAntiAffinity {
+		if err := term.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeAdmitsWorkloadAffinity reports whether a new replica of a workload may go on a node
+// given the workloads already placed there. Every affinity term needs a selected workload
+// on the node, and neither the workload's anti-affinity terms nor those of the workloads on
+// the node may select the other; an anti-affinity term selecting the workload itself keeps
+// its replicas on separate nodes. Callers must hold the WorkloadManager lock.
+func (co *CentralOrchestrator) nodeAdmitsWorkloadAffinity(node *EdgeNode, workload *Workload) bool {
+	placement := workload.Placement
+	satisfied := make([]bool, len(placement.WorkloadAffinity))
+	for _, other := range co.WorkloadManager.workloads {
+		deployment := other.deploymentFor(node.ID)
+		if deployment == nil || !deployment.placed() {
+			continue
+		}
+
+		for _, term := range placement.WorkloadAntiAffinity {
+			if term.selects(workload, other) {
+				return false
+			}
+		}
+		if other.ID == workload.ID {
+			continue
+		}
+		for _, term := range other.Placement.WorkloadAntiAffinity {
+			if term.selects(other, workload) {
+				return false
+			}
+		}
+		for i, term := range placement.WorkloadAffinity {
+			if term.selects(workload, other) {
+				satisfied[i] = true
+			}
+		}
+	}
+
+	for _, ok := range satisfied {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
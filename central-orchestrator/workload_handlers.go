@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -15,29 +16,141 @@ func (co *CentralOrchestrator) DeployWorkload(c *gin.Context) {
 		return
 	}
 
+	workload, err := newWorkload(req, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, workloadSpecError(err))
+		return
+	}
+	if status, err := co.admitNewWorkload(c, workload); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err := co.storeNewWorkloads(workload); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.Logger.Infof("Workload %s created with ID %s", req.Name, workload.ID)
+	
+	c.JSON(http.StatusCreated, gin.H{
+		"id":       workload.ID,
+		"workload": workload,
+	})
+}
+
+// admitNewWorkload checks a new workload against the datasets it is constrained to and the
+// tenants the caller may act on, returning the response status of a refusal
+func (co *CentralOrchestrator) admitNewWorkload(c *gin.Context, workload *Workload) (int, error) {
+	if err := co.validateDatasetConstraints(workload.Placement.Constraints); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if !tenantAllowed(c, workload.Tenant) {
+		return http.StatusForbidden, fmt.Errorf("Not allowed to act on tenant %s", workloadTenant(workload))
+	}
+	return http.StatusOK, nil
+}
+
+// storeNewWorkloads stores new workloads and their first revisions, all of them or, when
+// one would take its namespace over its quota, none
+func (co *CentralOrchestrator) storeNewWorkloads(workloads ...*Workload) error {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	for i, workload := range workloads {
+		// Stored one by one, so each counts towards the quota of the next
+		if err := co.checkNamespaceQuota(workload); err != nil {
+			for _, stored := range workloads[:i] {
+				delete(co.WorkloadManager.workloads, stored.ID)
+			}
+			return err
+		}
+		co.WorkloadManager.workloads[workload.ID] = workload
+	}
+	for _, workload := range workloads {
+		co.recordRevision(nil, workload, "created", workload.CreatedAt)
+	}
+	return nil
+}
+
+// newWorkload validates a deployment request and builds the pending workload with defaults applied
+func newWorkload(req WorkloadDeploymentRequest, now time.Time) (*Workload, error) {
 	workloadID := generateID()
-	now := time.Now()
+
+	expiresAt, err := resolveExpiry(req.TTL, req.ExpiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWorkloadRequest(req); err != nil {
+		return nil, err
+	}
+	if req.Resources.CPUPinning != nil {
+		if err := req.Resources.CPUPinning.apply(&req.Resources, req.QoSClass); err != nil {
+			return nil, err
+		}
+	}
+	if req.Resources.GPU != nil {
+		if err := req.Resources.GPU.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if req.Realtime != nil {
+		if err := req.Realtime.apply(&req.Resources); err != nil {
+			return nil, err
+		}
+	}
+	if req.Offload != nil {
+		if err := req.Offload.validate(req.Volumes); err != nil {
+			return nil, err
+		}
+	}
+	if req.Type == WorkloadTypeJob || req.Type == WorkloadTypeCronJob {
+		if req.Job == nil {
+			req.Job = &JobPolicy{}
+		}
+		if err := req.Job.validate(req.Type); err != nil {
+			return nil, err
+		}
+	} else if req.Job != nil {
+		return nil, fmt.Errorf("job is only valid for jobs and cron jobs")
+	}
 	
 	workload := &Workload{
-		ID:          workloadID,
-		Name:        req.Name,
-		Namespace:   req.Namespace,
-		Type:        req.Type,
-		Image:       req.Image,
-		Replicas:    req.Replicas,
-		Resources:   req.Resources,
-		Environment: req.Environment,
-		Labels:      req.Labels,
-		Placement:   req.Placement,
-		Status:      WorkloadStatusPending,
-		Deployments: make([]WorkloadDeployment, 0),
-		CreatedAt:   now,
-		UpdatedAt:   now,
+		ID:             workloadID,
+		Name:           req.Name,
+		Namespace:      req.Namespace,
+		Tenant:         req.Tenant,
+		Metadata:       req.Metadata,
+		Type:           req.Type,
+		Image:          req.Image,
+		Replicas:       req.Replicas,
+		Resources:      req.Resources,
+		Environment:    req.Environment,
+		Labels:         req.Labels,
+		Placement:      req.Placement,
+		Ports:          req.Ports,
+		ServiceType:    req.ServiceType,
+		DNS:            req.DNS,
+		Criticality:    req.Criticality,
+		QoSClass:       req.QoSClass,
+		ReadinessProbe: req.ReadinessProbe,
+		Volumes:        req.Volumes,
+		Backup:         req.Backup,
+		Offload:        req.Offload,
+		Realtime:       req.Realtime,
+		Job:            req.Job,
+		ExpiresAt:      expiresAt,
+		Status:         WorkloadStatusPending,
+		Deployments:    make([]WorkloadDeployment, 0),
+		CreatedAt:      now,
+		UpdatedAt:      now,
 	}
 
 	// Set defaults
 	if workload.Namespace == "" {
-		workload.Namespace = "default"
+		workload.Namespace = DefaultNamespace
+	}
+	if workload.Tenant == "" {
+		workload.Tenant = DefaultTenant
 	}
 	if workload.Replicas == 0 {
 		workload.Replicas = 1
@@ -51,40 +164,93 @@ func (co *CentralOrchestrator) DeployWorkload(c *gin.Context) {
 	if workload.Placement.Strategy == "" {
 		workload.Placement.Strategy = PlacementStrategyEdgeFirst
 	}
+	if workload.Placement.Strategy == PlacementStrategyLatency && workload.Placement.LatencyTarget == "" {
+		workload.Placement.LatencyTarget = LatencyTargetOrchestrator
+	}
+	if len(workload.Ports) > 0 && workload.ServiceType == "" {
+		workload.ServiceType = ServiceTypeClusterIP
+	}
+	if workload.Backup != nil && workload.Backup.Method == "" {
+		workload.Backup.Method = SnapshotMethodCSI
+	}
+	if workload.DNS != nil && workload.DNS.Routing == "" {
+		workload.DNS.Routing = DNSRoutingPerSite
+	}
+	for i := range workload.Ports {
+		if workload.Ports[i].Protocol == "" {
+			workload.Ports[i].Protocol = "TCP"
+		}
+		if workload.Ports[i].TargetPort == 0 {
+			workload.Ports[i].TargetPort = workload.Ports[i].Port
+		}
+	}
+
+	switch workload.QoSClass {
+	case "":
+		workload.QoSClass = workloadQoS(workload)
+	case QoSGuaranteed, QoSBurstable, QoSBestEffort:
+	default:
+		return nil, fmt.Errorf("qos_class must be guaranteed, burstable or best-effort")
+	}
+
+	switch workload.Type {
+	case WorkloadTypeJob:
+		workload.JobStatus = &JobStatus{}
+	case WorkloadTypeCronJob:
+		workload.JobStatus = &JobStatus{}
+		workload.Status = WorkloadStatusScheduled
+	}
 
 	// Generate selector from labels
 	workload.Selector = make(map[string]string)
 	workload.Selector["app"] = workload.Name
 	workload.Selector["workload-id"] = workloadID
 
-	co.WorkloadManager.mutex.Lock()
-	co.WorkloadManager.workloads[workloadID] = workload
-	co.WorkloadManager.mutex.Unlock()
-
-	co.Logger.Infof("Workload %s created with ID %s", req.Name, workloadID)
-	
-	c.JSON(http.StatusCreated, gin.H{
-		"id":       workloadID,
-		"workload": workload,
-	})
+	return workload, nil
 }
 
-// ListWorkloads returns all workloads
+// ListWorkloads returns the workloads of the tenants the caller may act on, optionally
+// filtered by owner_team, with only the ?fields= requested
 func (co *CentralOrchestrator) ListWorkloads(c *gin.Context) {
+	fields, err := parseFieldSelection(c, Workload{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	co.WorkloadManager.mutex.RLock()
 	defer co.WorkloadManager.mutex.RUnlock()
 
 	workloads := make([]*Workload, 0, len(co.WorkloadManager.workloads))
 	for _, workload := range co.WorkloadManager.workloads {
+		if !tenantAllowed(c, workloadTenant(workload)) {
+			continue
+		}
+		if team := c.Query("owner_team"); team != "" && workload.Metadata.OwnerTeam != team {
+			continue
+		}
+		if namespace := c.Query("namespace"); namespace != "" && workloadNamespace(workload) != namespace {
+			continue
+		}
 		workloads = append(workloads, workload)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"workloads": workloads})
+	projected, err := fields.project(workloads)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"workloads": projected})
 }
 
-// GetWorkload returns a specific workload
+// GetWorkload returns a specific workload, with only the ?fields= requested
 func (co *CentralOrchestrator) GetWorkload(c *gin.Context) {
 	workloadID := c.Param("id")
+	fields, err := parseFieldSelection(c, Workload{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
 	co.WorkloadManager.mutex.RLock()
 	workload, exists := co.WorkloadManager.workloads[workloadID]
@@ -95,7 +261,12 @@ func (co *CentralOrchestrator) GetWorkload(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"workload": workload})
+	projected, err := fields.project(workload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"workload": projected})
 }
 
 // DeleteWorkload removes a workload
@@ -111,12 +282,25 @@ func (co *CentralOrchestrator) DeleteWorkload(c *gin.Context) {
 		return
 	}
 
-	// TODO: Actually delete workload from edge nodes
-	// For now, just mark as stopped and remove from memory
+	// Streaming agents remove it from their nodes once it leaves their desired state
 	workload.Status = WorkloadStatusStopped
 	workload.UpdatedAt = time.Now()
 	
 	delete(co.WorkloadManager.workloads, workloadID)
+	co.AlertManager.Resolve(WorkloadUnschedulableAlert, AlertScopeWorkload, workloadID)
+	co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workloadID)
+	co.RevisionHistory.forget(workloadID)
+
+	// A cron job's jobs go with it
+	for _, job := range co.WorkloadManager.workloads {
+		if job.CronJobID == workloadID {
+			co.removeJob(job)
+		}
+	}
+	if workload.BlueGreen != nil {
+		co.endBlueGreenDeployment(workload, workload.UpdatedAt)
+	}
+	co.AgentStreamHub.wake()
 	co.Logger.Infof("Workload %s deleted", workloadID)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Workload deleted successfully"})
@@ -141,6 +325,15 @@ func (co *CentralOrchestrator) ScaleWorkload(c *gin.Context) {
 		return
 	}
 
+	if err := validateReplicas(req.Replicas); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "replicas " + err.Error()})
+		return
+	}
+	if err := co.checkNamespaceScale(workload, req.Replicas); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
 	oldReplicas := workload.Replicas
 	workload.Replicas = req.Replicas
 	workload.Status = WorkloadStatusPending // Trigger rescheduling
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkloadMetadata tells whoever is paged for a workload what it is, who owns it and
+// where its docs live. It is informational and never sent to agents.
+type WorkloadMetadata struct {
+	Description string `json:"description,omitempty"`
+	OwnerTeam   string `json:"owner_team,omitempty"`
+	RunbookURL  string `json:"runbook_url,omitempty"`
+	// Source repository, as a URL or a git remote such as git@host:org/repo.git
+	Repository string `json:"repository,omitempty"`
+}
+
+// validate checks that the runbook link is an absolute http(s) URL
+func (m *WorkloadMetadata) validate() error {
+	if m.RunbookURL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(m.RunbookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("runbook_url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// workloadMetadataFor returns the metadata of the workloads that workload-scoped alerts
+// refer to, keyed by workload ID
+func (co *CentralOrchestrator) workloadMetadataFor(alerts []*Alert) map[string]WorkloadMetadata {
+	metadata := make(map[string]WorkloadMetadata)
+
+	co.WorkloadManager.mutex.RLock()
+	defer co.WorkloadManager.mutex.RUnlock()
+
+	for _, alert := range alerts {
+		if alert.Scope != AlertScopeWorkload {
+			continue
+		}
+		if workload, exists := co.WorkloadManager.workloads[alert.ScopeID]; exists {
+			metadata[workload.ID] = workload.Metadata
+		}
+	}
+	return metadata
+}
+
+// SetWorkloadMetadata replaces a workload's description, owner and links
+func (co *CentralOrchestrator) SetWorkloadMetadata(c *gin.Context) {
+	var req WorkloadMetadata
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workload not found"})
+		return
+	}
+
+	workload.Metadata = req
+	workload.UpdatedAt = time.Now()
+
+	co.Logger.Infof("Workload %s metadata updated (owner team %q)", workload.ID, req.OwnerTeam)
+	c.JSON(http.StatusOK, gin.H{"workload": workload})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fired while a workload's pods fail to start or keep crashing on any of its nodes
+const WorkloadFailingAlert = "WorkloadFailing"
+
+// ObservedPhase summarizes how a workload is doing on a node, as its agent sees it
+type ObservedPhase string
+
+const (
+	ObservedPhaseProgressing ObservedPhase = "progressing"
+	ObservedPhaseAvailable   ObservedPhase = "available"
+	ObservedPhaseCompleted   ObservedPhase = "completed"
+	ObservedPhaseFailed      ObservedPhase = "failed"
+)
+
+// placed reports whether the deployment holds its node: running, or scheduled and
+// awaiting confirmation from the node's agent
+func (d WorkloadDeployment) placed() bool {
+	return d.Status == WorkloadStatusRunning || d.Status == WorkloadStatusPending
+}
+
+// ReportWorkloadStatus records how a workload is running on the reporting node. A
+// deployment is scheduled as pending and only counts as running once its agent reports
+// every replica ready; it returns to pending while replicas are failing.
+func (co *CentralOrchestrator) ReportWorkloadStatus(c *gin.Context) {
+	nodeID := c.Param("id")
+	workloadID := c.Param("wid")
+
+	var req ObservedWorkloadStatus
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Phase {
+	case ObservedPhaseProgressing, ObservedPhaseAvailable, ObservedPhaseCompleted, ObservedPhaseFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown phase " + string(req.Phase)})
+		return
+	}
+	if req.ObservedAt.IsZero() {
+		req.ObservedAt = time.Now()
+	}
+
+	if err := co.recordWorkloadStatus(nodeID, workloadID, req); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Status updated"})
+}
+
+// recordWorkloadStatus applies a workload's observed status on a node, as reported by its
+// agent or read by the orchestrator from an imported cluster
+func (co *CentralOrchestrator) recordWorkloadStatus(nodeID, workloadID string, observed ObservedWorkloadStatus) error {
+	co.WorkloadManager.mutex.Lock()
+	defer co.WorkloadManager.mutex.Unlock()
+
+	workload, exists := co.WorkloadManager.workloads[workloadID]
+	if !exists {
+		return fmt.Errorf("Workload not found")
+	}
+
+	deployment := workload.deploymentFor(nodeID)
+	if deployment == nil {
+		return fmt.Errorf("Workload is not deployed on this node")
+	}
+
+	now := time.Now()
+	previous := deployment.Status
+	deployment.Observed = &observed
+	deployment.UpdatedAt = now
+
+	// Stopped and failed deployments stay so; the agent is about to remove them
+	if deployment.placed() {
+		switch observed.Phase {
+		case ObservedPhaseAvailable:
+			deployment.Status = WorkloadStatusRunning
+		case ObservedPhaseCompleted:
+			deployment.Status = WorkloadStatusCompleted
+			if workload.isJob() {
+				workload.jobStatus().Succeeded += deployment.Replicas
+			}
+		case ObservedPhaseFailed:
+			if workload.isJob() {
+				co.failJobRun(workload, deployment, now)
+			} else {
+				deployment.Status = WorkloadStatusPending
+			}
+		default:
+			deployment.Status = WorkloadStatusPending
+		}
+	}
+	if deployment.Status != previous {
+		co.Logger.Infof("Workload %s on node %s is now %s (%s)", workload.Name, nodeID, deployment.Status, observed.Phase)
+	}
+
+	// A workload whose every deployment ran to completion, such as a job, is done
+	if deployment.Status == WorkloadStatusCompleted && workload.allDeploymentsCompleted() {
+		workload.Status = WorkloadStatusCompleted
+		workload.UpdatedAt = now
+		if workload.isJob() {
+			workload.jobStatus().CompletedAt = &now
+		}
+		co.Logger.Infof("Workload %s completed on all nodes", workload.Name)
+	}
+
+	co.updateWorkloadFailingAlert(workload)
+	return nil
+}
+
+// allDeploymentsCompleted reports whether every deployment still placed or finished has
+// completed
+func (w *Workload) allDeploymentsCompleted() bool {
+	completed := 0
+	for _, deployment := range w.Deployments {
+		if deployment.placed() {
+			return false
+		}
+		if deployment.Status == WorkloadStatusCompleted {
+			completed++
+		}
+	}
+	return completed > 0
+}
+
+// updateWorkloadFailingAlert fires the workload's failing alert while any placed
+// deployment reports failure, and resolves it once none does; callers must hold the
+// WorkloadManager lock
+func (co *CentralOrchestrator) updateWorkloadFailingAlert(workload *Workload) {
+	nodes := 0
+	var failing []string
+	reasons := make(map[string]bool)
+	for _, deployment := range workload.Deployments {
+		if !deployment.placed() {
+			continue
+		}
+		nodes++
+		if observed := deployment.Observed; observed != nil && observed.Phase == ObservedPhaseFailed {
+			failing = append(failing, deployment.NodeID)
+			reason := observed.Reason
+			if reason == "" {
+				reason = observed.Message
+			}
+			reasons[reason] = true
+		}
+	}
+
+	if len(failing) == 0 {
+		co.AlertManager.Resolve(WorkloadFailingAlert, AlertScopeWorkload, workload.ID)
+		return
+	}
+
+	list := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		if reason != "" {
+			list = append(list, reason)
+		}
+	}
+	sort.Strings(list)
+	co.AlertManager.Fire(WorkloadFailingAlert, AlertSeverityWarning, AlertScopeWorkload, workload.ID, "",
+		newMessage(MsgWorkloadFailing, "workload", workload.Name, "failing_nodes", len(failing), "node_count", nodes,
+			"reason", strings.Join(list, ", ")))
+}
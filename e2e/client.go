@@ -0,0 +1,322 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Node statuses reported by the orchestrator
+const (
+	NodeStatusOnline  = "online"
+	NodeStatusOffline = "offline"
+)
+
+// Workload statuses reported by the orchestrator
+const (
+	WorkloadStatusPending = "pending"
+	WorkloadStatusRunning = "running"
+	WorkloadStatusFailed  = "failed"
+)
+
+// Node is the part of an edge node scenarios look at
+type Node struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Status        string            `json:"status"`
+	LastHeartbeat time.Time         `json:"last_heartbeat"`
+	Labels        map[string]string `json:"labels"`
+	SiteID        string            `json:"site_id"`
+	Unschedulable bool              `json:"unschedulable,omitempty"`
+}
+
+// WorkloadSpec is a workload deployment request. Placement and Resources take the
+// orchestrator's JSON fields as they are, such as {"strategy": "load-balance"}.
+type WorkloadSpec struct {
+	Name        string                 `json:"name"`
+	Namespace   string                 `json:"namespace,omitempty"`
+	Tenant      string                 `json:"tenant,omitempty"`
+	Type        string                 `json:"type"`
+	Image       string                 `json:"image"`
+	Replicas    int32                  `json:"replicas"`
+	Labels      map[string]string      `json:"labels,omitempty"`
+	Environment map[string]string      `json:"environment,omitempty"`
+	Resources   map[string]interface{} `json:"resources,omitempty"`
+	Placement   map[string]interface{} `json:"placement,omitempty"`
+}
+
+// Workload is the part of a workload scenarios look at
+type Workload struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Namespace   string               `json:"namespace"`
+	Image       string               `json:"image"`
+	Replicas    int32                `json:"replicas"`
+	Status      string               `json:"status"`
+	Revision    int64                `json:"revision"`
+	Deployments []WorkloadDeployment `json:"deployments"`
+	BlueGreen   *struct {
+		Phase             string `json:"phase"`
+		PreviewWorkloadID string `json:"preview_workload_id"`
+		Message           string `json:"message,omitempty"`
+	} `json:"blue_green,omitempty"`
+}
+
+// WorkloadDeployment is a workload's replicas on one node
+type WorkloadDeployment struct {
+	NodeID   string `json:"node_id"`
+	Status   string `json:"status"`
+	Replicas int32  `json:"replicas"`
+	Observed *struct {
+		Phase         string `json:"phase"`
+		ReadyReplicas int32  `json:"ready_replicas"`
+		Reason        string `json:"reason,omitempty"`
+	} `json:"observed,omitempty"`
+}
+
+// ReadyReplicas counts the replicas agents report ready across the workload's nodes
+func (w *Workload) ReadyReplicas() int32 {
+	var ready int32
+	for _, deployment := range w.Deployments {
+		if deployment.Observed != nil {
+			ready += deployment.Observed.ReadyReplicas
+		}
+	}
+	return ready
+}
+
+// NodeIDs returns the nodes running replicas of the workload
+func (w *Workload) NodeIDs() []string {
+	var nodeIDs []string
+	for _, deployment := range w.Deployments {
+		if deployment.Replicas > 0 {
+			nodeIDs = append(nodeIDs, deployment.NodeID)
+		}
+	}
+	return nodeIDs
+}
+
+// WorkloadRevision is one recorded version of a workload's spec
+type WorkloadRevision struct {
+	Revision    int64                  `json:"revision"`
+	Spec        map[string]interface{} `json:"spec"`
+	ChangeCause string                 `json:"change_cause,omitempty"`
+}
+
+// APIError is a response of the orchestrator with an error status
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+	// Invalid fields of a rejected workload spec
+	FieldErrors []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Client calls the orchestrator's REST API
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client of the orchestrator at baseURL. The harness's orchestrator
+// serves a self-signed certificate, so it is not verified.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		token:   token,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+// Do sends a request to an API path, such as /api/v1/nodes, and decodes the response
+// into out when it is not nil. Error statuses are returned as *APIError.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		var errorBody struct {
+			Error       string `json:"error"`
+			FieldErrors []struct {
+				Field   string `json:"field"`
+				Message string `json:"message"`
+			} `json:"field_errors"`
+		}
+		if json.Unmarshal(data, &errorBody) == nil && errorBody.Error != "" {
+			apiErr.Message = errorBody.Error
+			apiErr.FieldErrors = errorBody.FieldErrors
+		} else {
+			apiErr.Message = string(data)
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %v", method, path, err)
+	}
+	return nil
+}
+
+// WaitHealthy polls the orchestrator's health endpoint until it answers
+func (c *Client) WaitHealthy(ctx context.Context) error {
+	return Eventually(ctx, time.Minute, "orchestrator health", func(ctx context.Context) (bool, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+		if err != nil {
+			return false, "", err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return false, err.Error(), nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, resp.Status, nil
+	})
+}
+
+// Nodes lists the registered nodes
+func (c *Client) Nodes(ctx context.Context) ([]Node, error) {
+	var resp struct {
+		Nodes []Node `json:"nodes"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/api/v1/nodes", nil, &resp)
+	return resp.Nodes, err
+}
+
+// Node returns a node by ID
+func (c *Client) Node(ctx context.Context, id string) (*Node, error) {
+	var resp struct {
+		Node *Node `json:"node"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/v1/nodes/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Node, nil
+}
+
+// NodeByName returns the node registered under a name, or nil when there is none
+func (c *Client) NodeByName(ctx context.Context, name string) (*Node, error) {
+	nodes, err := c.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range nodes {
+		if nodes[i].Name == name {
+			return &nodes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// DeployWorkload creates a workload
+func (c *Client) DeployWorkload(ctx context.Context, spec WorkloadSpec) (*Workload, error) {
+	var resp struct {
+		Workload *Workload `json:"workload"`
+	}
+	if err := c.Do(ctx, http.MethodPost, "/api/v1/workloads", spec, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Workload, nil
+}
+
+// Workload returns a workload by ID
+func (c *Client) Workload(ctx context.Context, id string) (*Workload, error) {
+	var resp struct {
+		Workload *Workload `json:"workload"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/v1/workloads/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Workload, nil
+}
+
+// DeleteWorkload removes a workload from every node
+func (c *Client) DeleteWorkload(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/v1/workloads/"+url.PathEscape(id), nil, nil)
+}
+
+// ScaleWorkload sets a workload's replicas
+func (c *Client) ScaleWorkload(ctx context.Context, id string, replicas int32) error {
+	body := map[string]int32{"replicas": replicas}
+	return c.Do(ctx, http.MethodPost, "/api/v1/workloads/"+url.PathEscape(id)+"/scale", body, nil)
+}
+
+// StartBlueGreen deploys a new version of a workload next to it; traffic switches to it
+// once it is healthy unless manualSwitch is set
+func (c *Client) StartBlueGreen(ctx context.Context, id string, spec WorkloadSpec, manualSwitch bool) error {
+	body := map[string]interface{}{"spec": spec, "manual_switch": manualSwitch}
+	return c.Do(ctx, http.MethodPost, "/api/v1/workloads/"+url.PathEscape(id)+"/blue-green", body, nil)
+}
+
+// PromoteBlueGreen makes the switched-to version of a blue-green deployment the
+// workload's own spec, recording a revision
+func (c *Client) PromoteBlueGreen(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodPost, "/api/v1/workloads/"+url.PathEscape(id)+"/blue-green/promote", nil, nil)
+}
+
+// Revisions returns a workload's revision history, oldest first, and its current revision
+func (c *Client) Revisions(ctx context.Context, id string) ([]WorkloadRevision, int64, error) {
+	var resp struct {
+		CurrentRevision int64              `json:"current_revision"`
+		Revisions       []WorkloadRevision `json:"revisions"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/api/v1/workloads/"+url.PathEscape(id)+"/revisions", nil, &resp)
+	return resp.Revisions, resp.CurrentRevision, err
+}
+
+// Rollback returns a workload to a revision, the previous one when revision is 0, and
+// returns the revision recorded for the rollback
+func (c *Client) Rollback(ctx context.Context, id string, revision int64) (int64, error) {
+	var resp struct {
+		Revision int64 `json:"revision"`
+	}
+	body := map[string]int64{"revision": revision}
+	err := c.Do(ctx, http.MethodPost, "/api/v1/workloads/"+url.PathEscape(id)+"/rollback", body, &resp)
+	return resp.Revision, err
+}
@@ -0,0 +1,213 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EdgeCluster is a kind cluster managed by an edge agent, registered with the orchestrator
+// as one edge node named after the cluster
+type EdgeCluster struct {
+	Name       string
+	Kubeconfig string
+	Labels     map[string]string
+
+	kindImage       string
+	agentBinary     string
+	orchestratorURL string
+	dir             string
+
+	mutex sync.Mutex
+	agent *exec.Cmd
+	// Closed when the running agent exits
+	agentDone chan struct{}
+}
+
+func newEdgeCluster(options Options, index int, agentBinary, orchestratorURL string) *EdgeCluster {
+	name := fmt.Sprintf("%s-edge-%d", options.Name, index)
+	dir := filepath.Join(options.WorkDir, name)
+	return &EdgeCluster{
+		Name:            name,
+		Kubeconfig:      filepath.Join(dir, "kubeconfig"),
+		Labels:          options.NodeLabels[index],
+		kindImage:       options.KindImage,
+		agentBinary:     agentBinary,
+		orchestratorURL: orchestratorURL,
+		dir:             dir,
+	}
+}
+
+// create creates the kind cluster and waits for its control plane
+func (ec *EdgeCluster) create(ctx context.Context) error {
+	if err := os.MkdirAll(ec.dir, 0o755); err != nil {
+		return err
+	}
+	_, err := run(ctx, "kind", "create", "cluster",
+		"--name", ec.Name,
+		"--image", ec.kindImage,
+		"--kubeconfig", ec.Kubeconfig,
+		"--wait", "2m")
+	return err
+}
+
+func (ec *EdgeCluster) delete() error {
+	_, err := run(context.Background(), "kind", "delete", "cluster", "--name", ec.Name)
+	return err
+}
+
+// Kubectl runs kubectl against the cluster and returns its output
+func (ec *EdgeCluster) Kubectl(ctx context.Context, args ...string) (string, error) {
+	return run(ctx, "kubectl", append([]string{"--kubeconfig", ec.Kubeconfig}, args...)...)
+}
+
+// StartAgent starts the cluster's edge agent, which registers its node or resumes the
+// node it registered before
+func (ec *EdgeCluster) StartAgent() error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	if ec.agent != nil {
+		return fmt.Errorf("agent of cluster %s is already running", ec.Name)
+	}
+	configPath := filepath.Join(ec.dir, "agent.yaml")
+	if err := os.WriteFile(configPath, []byte(ec.agentConfig()), 0o600); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(ec.agentLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(ec.agentBinary)
+	cmd.Env = append(os.Environ(), "EDGE_AGENT_CONFIG="+configPath)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return fmt.Errorf("failed to start the agent of cluster %s: %v", ec.Name, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+		close(done)
+	}()
+	ec.agent = cmd
+	ec.agentDone = done
+	return nil
+}
+
+// StopAgent kills the cluster's edge agent, so its node stops sending heartbeats
+func (ec *EdgeCluster) StopAgent() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	if ec.agent == nil {
+		return
+	}
+	ec.agent.Process.Kill()
+	<-ec.agentDone
+	ec.agent = nil
+	ec.agentDone = nil
+}
+
+// AgentRunning reports whether the cluster's edge agent is running
+func (ec *EdgeCluster) AgentRunning() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	if ec.agent == nil {
+		return false
+	}
+	select {
+	case <-ec.agentDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// AgentLogs returns the last lines of the cluster's agent log
+func (ec *EdgeCluster) AgentLogs() string {
+	data, err := os.ReadFile(ec.agentLogPath())
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > 50 {
+		lines = lines[len(lines)-50:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Fail takes the whole edge site down: the agent is killed and the cluster's node
+// container stopped, as when the site loses power
+func (ec *EdgeCluster) Fail(ctx context.Context) error {
+	ec.StopAgent()
+	_, err := run(ctx, "docker", "stop", ec.Name+"-control-plane")
+	return err
+}
+
+// Recover brings a failed site back: the node container starts again and, once its API
+// server answers, the agent
+func (ec *EdgeCluster) Recover(ctx context.Context) error {
+	if _, err := run(ctx, "docker", "start", ec.Name+"-control-plane"); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		_, err := ec.Kubectl(ctx, "get", "--raw", "/readyz")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("API server of cluster %s did not come back: %v", ec.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return ec.StartAgent()
+}
+
+func (ec *EdgeCluster) agentLogPath() string {
+	return filepath.Join(ec.dir, "agent.log")
+}
+
+// agentConfig is the agent's configuration file. The orchestrator's certificate is
+// self-signed, which the agent accepts.
+func (ec *EdgeCluster) agentConfig() string {
+	var config strings.Builder
+	field := func(name, value string) {
+		fmt.Fprintf(&config, "%s: %s\n", name, strconv.Quote(value))
+	}
+	field("orchestrator_url", ec.orchestratorURL)
+	field("node_name", ec.Name)
+	field("node_address", "127.0.0.1")
+	field("auth_token", DefaultToken)
+	field("kubeconfig_path", ec.Kubeconfig)
+	field("state_file", filepath.Join(ec.dir, "state.json"))
+	field("federated_data_dir", filepath.Join(ec.dir, "federated"))
+	field("heartbeat_interval", "5s")
+	config.WriteString("labels:\n")
+	keys := make([]string, 0, len(ec.Labels))
+	for key := range ec.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(&config, "  %s: %s\n", strconv.Quote("e2e.edge.io/cluster"), strconv.Quote(ec.Name))
+	for _, key := range keys {
+		fmt.Fprintf(&config, "  %s: %s\n", strconv.Quote(key), strconv.Quote(ec.Labels[key]))
+	}
+	return config.String()
+}
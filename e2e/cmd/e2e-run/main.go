@@ -0,0 +1,108 @@
+// Command e2e-run starts an orchestrator with kind-based edge clusters and runs the
+// end-to-end scenarios against it.
+//
+//	e2e-run [--clusters 3] [--scenarios register,deploy,rollback,node-failure]
+//	        [--plugin scheduler_plugin.go]... [--env NAME=VALUE]... [--keep] [--list]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ishaqelkhalifa/kubernetes-edge-framework/e2e"
+)
+
+// repeated collects a flag given several times
+type repeated []string
+
+func (r *repeated) String() string     { return strings.Join(*r, ",") }
+func (r *repeated) Set(v string) error { *r = append(*r, v); return nil }
+
+func main() {
+	var (
+		options   e2e.Options
+		plugins   repeated
+		env       repeated
+		scenarios string
+		list      bool
+	)
+	flag.IntVar(&options.Clusters, "clusters", e2e.DefaultClusters, "edge clusters to create")
+	flag.StringVar(&options.RepoDir, "repo", "", "repository checkout to build from (default: found from the working directory)")
+	flag.StringVar(&options.KindImage, "kind-image", e2e.DefaultKindImage, "node image of the kind clusters")
+	flag.StringVar(&options.Name, "name", "", "prefix of the clusters' and containers' names (default: random)")
+	flag.StringVar(&options.WorkDir, "workdir", "", "directory for binaries, kubeconfigs and logs (default: temporary)")
+	flag.BoolVar(&options.Keep, "keep", false, "leave the environment running for inspection")
+	flag.Var(&plugins, "plugin", "Go file built into the orchestrator, such as a scheduler plugin (repeatable)")
+	flag.Var(&env, "env", "NAME=VALUE environment variable of the orchestrator (repeatable)")
+	flag.StringVar(&scenarios, "scenarios", "", "comma-separated scenarios to run (default: all)")
+	flag.BoolVar(&list, "list", false, "list the scenarios and exit")
+	flag.Parse()
+
+	selected := e2e.DefaultScenarios()
+	if list {
+		for _, scenario := range selected {
+			fmt.Printf("%-14s %s\n", scenario.Name, scenario.Description)
+		}
+		return
+	}
+	if scenarios != "" {
+		var err error
+		selected, err = e2e.SelectScenarios(selected, strings.Split(scenarios, ","))
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	options.PluginFiles = plugins
+	options.OrchestratorEnv = make(map[string]string, len(env))
+	for _, variable := range env {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok || name == "" {
+			fatalf("--env %q is not NAME=VALUE", variable)
+		}
+		options.OrchestratorEnv[name] = value
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	environment, err := e2e.Start(ctx, options)
+	if err != nil {
+		if environment != nil {
+			environment.Close()
+		}
+		fatalf("failed to start the environment: %v", err)
+	}
+
+	results := e2e.Run(ctx, environment, selected)
+	if err := environment.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to tear down the environment: %v\n", err)
+	}
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			fmt.Printf("SKIP  %s\n", result.Scenario)
+		case result.Err != nil:
+			failed++
+			fmt.Printf("FAIL  %s (%s): %v\n", result.Scenario, result.Duration, result.Err)
+		default:
+			fmt.Printf("PASS  %s (%s)\n", result.Scenario, result.Duration)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d scenarios failed\n", failed, len(results))
+		os.Exit(1)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "e2e-run: "+format+"\n", args...)
+	os.Exit(1)
+}
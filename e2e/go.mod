@@ -0,0 +1,3 @@
+module github.com/ishaqelkhalifa/kubernetes-edge-framework/e2e
+
+go 1.21
@@ -0,0 +1,273 @@
+// Package e2e runs the orchestrator against real edge clusters. Start builds the
+// orchestrator and the edge agent from source, creates one kind cluster per edge node
+// with an agent managing it, and returns an Environment whose client drives the
+// orchestrator's API. Scenarios then exercise it end to end: nodes registering, workloads
+// deploying, nodes failing and workloads rolling back.
+//
+// Custom scheduler plugins and policies are validated the same way: Options.PluginFiles
+// builds the plugin sources into the orchestrator, and scenarios of their own assert the
+// placements and behavior they expect.
+//
+// The harness needs docker, kind, kubectl and a Go toolchain on the PATH.
+package e2e
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClusters is the number of edge clusters an environment starts with
+	DefaultClusters = 3
+
+	// DefaultKindImage is the node image of the kind clusters
+	DefaultKindImage = "kindest/node:v1.28.0"
+
+	// DefaultToken is the bearer token the client and the agents authenticate with
+	DefaultToken = "e2e-token"
+
+	// DefaultStartTimeout bounds building the binaries and creating the clusters
+	DefaultStartTimeout = 10 * time.Minute
+)
+
+// Options configures an environment
+type Options struct {
+	// Edge clusters to create, each registered as one edge node; DefaultClusters when 0
+	Clusters int
+	// Repository checkout the orchestrator and agent are built from; found from the
+	// working directory when empty
+	RepoDir string
+	// Go files built into the orchestrator's package next to its own, such as custom
+	// scheduler plugins registering themselves from init functions
+	PluginFiles []string
+	// Environment variables of the orchestrator, such as MAX_OVERCOMMIT_RATIO
+	OrchestratorEnv map[string]string
+	// Labels of each cluster's node, by cluster index
+	NodeLabels map[int]map[string]string
+	// Node image of the kind clusters; DefaultKindImage when empty
+	KindImage string
+	// Prefix of the clusters' and containers' names; a random one when empty
+	Name string
+	// Directory for binaries, kubeconfigs and logs; a temporary one when empty
+	WorkDir string
+	// Leave the clusters and the orchestrator running when the environment closes, for
+	// inspecting a failed run
+	Keep bool
+	// Progress messages; log.Printf when nil
+	Logf func(format string, args ...interface{})
+}
+
+// Environment is a running orchestrator with its edge clusters
+type Environment struct {
+	Options      Options
+	Orchestrator *Orchestrator
+	Clusters     []*EdgeCluster
+	// Client of the orchestrator's API, authenticated with DefaultToken
+	Client *Client
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Start builds the orchestrator and agent, creates the edge clusters and waits for every
+// agent to register its node. The environment must be closed, even when Start fails
+// part way through and returns it with an error.
+func Start(ctx context.Context, options Options) (*Environment, error) {
+	if err := options.complete(); err != nil {
+		return nil, err
+	}
+	env := &Environment{Options: options}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultStartTimeout)
+	defer cancel()
+
+	for _, tool := range []string{"docker", "kind", "kubectl", "go"} {
+		if _, err := lookPath(tool); err != nil {
+			return env, err
+		}
+	}
+
+	env.logf("Building the orchestrator and the edge agent in %s", options.WorkDir)
+	orchestratorBinary, err := buildOrchestrator(ctx, options)
+	if err != nil {
+		return env, err
+	}
+	agentBinary, err := buildAgent(ctx, options)
+	if err != nil {
+		return env, err
+	}
+
+	env.logf("Starting the orchestrator")
+	env.Orchestrator, err = startOrchestrator(ctx, options, orchestratorBinary)
+	if err != nil {
+		return env, err
+	}
+	env.Client = NewClient(env.Orchestrator.URL, DefaultToken)
+	if err := env.Client.WaitHealthy(ctx); err != nil {
+		return env, fmt.Errorf("orchestrator did not become healthy: %v\n%s", err, env.Orchestrator.Logs())
+	}
+
+	// Clusters are created in parallel; each takes a minute or so
+	env.Clusters = make([]*EdgeCluster, options.Clusters)
+	errs := make([]error, options.Clusters)
+	var wg sync.WaitGroup
+	for i := range env.Clusters {
+		env.Clusters[i] = newEdgeCluster(options, i, agentBinary, env.Orchestrator.URL)
+		wg.Add(1)
+		go func(cluster *EdgeCluster, i int) {
+			defer wg.Done()
+			env.logf("Creating kind cluster %s", cluster.Name)
+			errs[i] = cluster.create(ctx)
+		}(env.Clusters[i], i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return env, err
+	}
+
+	for _, cluster := range env.Clusters {
+		if err := cluster.StartAgent(); err != nil {
+			return env, err
+		}
+	}
+	for _, cluster := range env.Clusters {
+		if _, err := env.WaitNodeStatus(ctx, cluster, NodeStatusOnline, 2*time.Minute); err != nil {
+			return env, fmt.Errorf("node of cluster %s did not register: %v\n%s", cluster.Name, err, cluster.AgentLogs())
+		}
+	}
+	env.logf("%d edge clusters registered", len(env.Clusters))
+	return env, nil
+}
+
+// Close stops the agents, deletes the clusters and removes the orchestrator unless
+// Options.Keep is set
+func (env *Environment) Close() error {
+	env.closeOnce.Do(func() {
+		var errs []error
+		for _, cluster := range env.Clusters {
+			if cluster != nil {
+				cluster.StopAgent()
+			}
+		}
+		if env.Options.Keep {
+			env.logf("Keeping the environment; work directory %s", env.Options.WorkDir)
+			return
+		}
+		for _, cluster := range env.Clusters {
+			if cluster != nil {
+				errs = append(errs, cluster.delete())
+			}
+		}
+		if env.Orchestrator != nil {
+			errs = append(errs, env.Orchestrator.stop())
+		}
+		env.closeErr = errors.Join(errs...)
+	})
+	return env.closeErr
+}
+
+// Cluster returns the edge cluster of a name, or nil
+func (env *Environment) Cluster(name string) *EdgeCluster {
+	for _, cluster := range env.Clusters {
+		if cluster.Name == name {
+			return cluster
+		}
+	}
+	return nil
+}
+
+func (env *Environment) logf(format string, args ...interface{}) {
+	env.Options.Logf(format, args...)
+}
+
+// complete fills in the defaults of unset options
+func (o *Options) complete() error {
+	if o.Clusters <= 0 {
+		o.Clusters = DefaultClusters
+	}
+	if o.KindImage == "" {
+		o.KindImage = DefaultKindImage
+	}
+	if o.Logf == nil {
+		o.Logf = log.Printf
+	}
+	if o.Name == "" {
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return err
+		}
+		o.Name = "e2e-" + hex.EncodeToString(suffix)
+	}
+	if o.RepoDir == "" {
+		dir, err := findRepoDir()
+		if err != nil {
+			return err
+		}
+		o.RepoDir = dir
+	}
+	if o.WorkDir == "" {
+		dir, err := os.MkdirTemp("", o.Name+"-")
+		if err != nil {
+			return err
+		}
+		o.WorkDir = dir
+	}
+	// Mounted into the orchestrator's container, which takes absolute paths
+	dir, err := filepath.Abs(o.WorkDir)
+	if err != nil {
+		return err
+	}
+	o.WorkDir = dir
+	for _, file := range o.PluginFiles {
+		if filepath.Ext(file) != ".go" {
+			return fmt.Errorf("plugin file %s is not a Go file", file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("plugin file: %v", err)
+		}
+	}
+	return nil
+}
+
+// findRepoDir walks up from the working directory, then from this file's directory, to
+// the checkout holding the orchestrator and the agent
+func findRepoDir() (string, error) {
+	var starts []string
+	if wd, err := os.Getwd(); err == nil {
+		starts = append(starts, wd)
+	}
+	if _, file, _, ok := runtime.Caller(0); ok {
+		starts = append(starts, filepath.Dir(file))
+	}
+	for _, dir := range starts {
+		for {
+			if isRepoDir(dir) {
+				return dir, nil
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return "", fmt.Errorf("no central-orchestrator and edge-agent directories above the working directory; set Options.RepoDir")
+}
+
+func isRepoDir(dir string) bool {
+	for _, module := range []string{"central-orchestrator", "edge-agent"} {
+		if _, err := os.Stat(filepath.Join(dir, module, "go.mod")); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// OrchestratorImage is the base image the orchestrator binary runs in
+	OrchestratorImage = "alpine:3.19"
+
+	// Port the orchestrator serves its API on inside its container
+	orchestratorPort = "8443"
+)
+
+// Orchestrator is the orchestrator running in a container, its API published on the
+// loopback interface
+type Orchestrator struct {
+	Container string
+	// Base URL of the API, such as https://127.0.0.1:32771
+	URL string
+}
+
+// Logs returns the orchestrator's output so far
+func (o *Orchestrator) Logs() string {
+	output, _ := exec.Command("docker", "logs", o.Container).CombinedOutput()
+	return string(output)
+}
+
+// Restart restarts the orchestrator's container. Its state is lost unless
+// OrchestratorEnv configured persistent storage.
+func (o *Orchestrator) Restart(ctx context.Context) error {
+	_, err := run(ctx, "docker", "restart", o.Container)
+	return err
+}
+
+func (o *Orchestrator) stop() error {
+	_, err := run(context.Background(), "docker", "rm", "-f", o.Container)
+	return err
+}
+
+// buildOrchestrator builds the orchestrator for the docker daemon's platform, with the
+// plugin files overlaid into its package
+func buildOrchestrator(ctx context.Context, options Options) (string, error) {
+	sourceDir := filepath.Join(options.RepoDir, "central-orchestrator")
+	arch, err := run(ctx, "docker", "version", "--format", "{{.Server.Arch}}")
+	if err != nil {
+		return "", err
+	}
+	output := filepath.Join(options.WorkDir, "bin", "central-orchestrator")
+	args := []string{"build", "-o", output}
+
+	if len(options.PluginFiles) > 0 {
+		overlay, err := writePluginOverlay(options.WorkDir, sourceDir, options.PluginFiles)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "-overlay", overlay)
+	}
+
+	cmd := exec.CommandContext(ctx, "go", append(args, ".")...)
+	cmd.Dir = sourceDir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+strings.TrimSpace(arch), "GOFLAGS=-mod=mod")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build the orchestrator: %v\n%s", err, output)
+	}
+	return output, nil
+}
+
+// writePluginOverlay writes a go build overlay adding each plugin file to the
+// orchestrator's package as e2e_plugin_<name>.go
+func writePluginOverlay(workDir, sourceDir string, pluginFiles []string) (string, error) {
+	overlay := struct {
+		Replace map[string]string
+	}{Replace: make(map[string]string)}
+	for _, file := range pluginFiles {
+		source, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		target := filepath.Join(sourceDir, "e2e_plugin_"+filepath.Base(file))
+		if _, exists := overlay.Replace[target]; exists {
+			return "", fmt.Errorf("plugin files share the name %s", filepath.Base(file))
+		}
+		overlay.Replace[target] = source
+	}
+	data, err := json.Marshal(overlay)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(workDir, "overlay.json")
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// buildAgent builds the edge agent for this machine; agents run here and reach their
+// clusters through kubeconfigs
+func buildAgent(ctx context.Context, options Options) (string, error) {
+	output := filepath.Join(options.WorkDir, "bin", "edge-agent")
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", output, ".")
+	cmd.Dir = filepath.Join(options.RepoDir, "edge-agent")
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build the edge agent: %v\n%s", err, out)
+	}
+	return output, nil
+}
+
+// startOrchestrator runs the orchestrator binary in a container with a self-signed
+// serving certificate
+func startOrchestrator(ctx context.Context, options Options, binary string) (*Orchestrator, error) {
+	certDir := filepath.Join(options.WorkDir, "certs")
+	if err := writeServingCertificate(certDir); err != nil {
+		return nil, err
+	}
+
+	container := options.Name + "-orchestrator"
+	args := []string{"run", "-d", "--name", container,
+		"-p", "127.0.0.1::" + orchestratorPort,
+		"-v", binary + ":/usr/local/bin/central-orchestrator:ro",
+		"-v", certDir + ":/etc/certs:ro",
+		"-e", "PORT=" + orchestratorPort,
+	}
+	names := make([]string, 0, len(options.OrchestratorEnv))
+	for name := range options.OrchestratorEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-e", name+"="+options.OrchestratorEnv[name])
+	}
+	args = append(args, OrchestratorImage, "/usr/local/bin/central-orchestrator")
+	if _, err := run(ctx, "docker", args...); err != nil {
+		return nil, err
+	}
+	orchestrator := &Orchestrator{Container: container}
+
+	published, err := run(ctx, "docker", "port", container, orchestratorPort+"/tcp")
+	if err != nil {
+		return orchestrator, err
+	}
+	// One line per address family, such as 127.0.0.1:32771
+	address := strings.TrimSpace(strings.SplitN(published, "\n", 2)[0])
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return orchestrator, fmt.Errorf("unexpected published port %q: %v", published, err)
+	}
+	orchestrator.URL = "https://127.0.0.1:" + port
+	return orchestrator, nil
+}
+
+// writeServingCertificate writes a self-signed certificate for localhost as tls.crt and
+// tls.key, the files the orchestrator serves with
+func writeServingCertificate(dir string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "central-orchestrator"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	// Readable by the container's user
+	return os.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o644)
+}
+
+// run runs a command and returns its standard output, with its standard error in the
+// error when it fails
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func lookPath(tool string) (string, error) {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return "", fmt.Errorf("%s is required on the PATH: %v", tool, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,461 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultScenarioTimeout bounds a scenario without a timeout of its own
+	DefaultScenarioTimeout = 10 * time.Minute
+
+	// PollInterval is how often waits check their condition
+	PollInterval = 2 * time.Second
+
+	// Images the built-in scenarios deploy and roll between
+	ScenarioImage         = "nginx:1.25-alpine"
+	ScenarioUpgradedImage = "nginx:1.26-alpine"
+
+	// Namespace the built-in scenarios deploy to
+	ScenarioNamespace = "default"
+)
+
+// Scenario is one end-to-end check against an environment. Scenarios run one after the
+// other and should remove the workloads they create.
+type Scenario struct {
+	Name        string
+	Description string
+	// Edge clusters the scenario needs
+	MinClusters int
+	// DefaultScenarioTimeout when 0
+	Timeout time.Duration
+	Run     func(ctx context.Context, env *Environment) error
+}
+
+// Result is the outcome of a scenario
+type Result struct {
+	Scenario string
+	// Nil when the scenario passed
+	Err      error
+	Skipped  bool
+	Duration time.Duration
+}
+
+// DefaultScenarios are the built-in scenarios, in the order they run. Node failure runs
+// last as it takes a cluster down for a few minutes.
+func DefaultScenarios() []Scenario {
+	return []Scenario{
+		{
+			Name:        "register",
+			Description: "every agent registers its cluster as an online node and keeps heartbeating",
+			MinClusters: 1,
+			Timeout:     2 * time.Minute,
+			Run:         scenarioRegister,
+		},
+		{
+			Name:        "deploy",
+			Description: "a workload spread across the clusters runs on each and is removed on deletion",
+			MinClusters: 1,
+			Run:         scenarioDeploy,
+		},
+		{
+			Name:        "rollback",
+			Description: "a blue-green upgrade is promoted, then rolled back to the previous revision",
+			MinClusters: 1,
+			Run:         scenarioRollback,
+		},
+		{
+			Name:        "node-failure",
+			Description: "the replicas of a site that goes down are re-placed on another cluster",
+			MinClusters: 2,
+			Run:         scenarioNodeFailure,
+		},
+	}
+}
+
+// SelectScenarios returns the scenarios of the given names, in the order given
+func SelectScenarios(scenarios []Scenario, names []string) ([]Scenario, error) {
+	var selected []Scenario
+	for _, name := range names {
+		found := false
+		for _, scenario := range scenarios {
+			if scenario.Name == name {
+				selected = append(selected, scenario)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown scenario %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// Run runs scenarios one after the other and returns their results. Scenarios needing
+// more clusters than the environment has are skipped.
+func Run(ctx context.Context, env *Environment, scenarios []Scenario) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		result := Result{Scenario: scenario.Name}
+		if len(env.Clusters) < scenario.MinClusters {
+			result.Skipped = true
+			env.logf("SKIP %s: needs %d edge clusters", scenario.Name, scenario.MinClusters)
+			results = append(results, result)
+			continue
+		}
+
+		timeout := scenario.Timeout
+		if timeout == 0 {
+			timeout = DefaultScenarioTimeout
+		}
+		env.logf("RUN  %s: %s", scenario.Name, scenario.Description)
+		scenarioCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		result.Err = scenario.Run(scenarioCtx, env)
+		result.Duration = time.Since(start).Round(time.Second)
+		cancel()
+
+		if result.Err != nil {
+			env.logf("FAIL %s (%s): %v", scenario.Name, result.Duration, result.Err)
+		} else {
+			env.logf("PASS %s (%s)", scenario.Name, result.Duration)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Eventually polls a condition until it holds, it fails or timeout passes. The condition
+// returns a status describing what it saw, included in the error on timeout; it returns
+// an error only for failures that waiting cannot fix.
+func Eventually(ctx context.Context, timeout time.Duration, what string, condition func(ctx context.Context) (bool, string, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var status string
+	for {
+		done, seen, err := condition(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %v", what, err)
+		}
+		if done {
+			return nil
+		}
+		status = seen
+		select {
+		case <-ctx.Done():
+			if status == "" {
+				return fmt.Errorf("timed out waiting for %s", what)
+			}
+			return fmt.Errorf("timed out waiting for %s; last seen: %s", what, status)
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// NodeOf returns the node a cluster's agent registered, or nil
+func (env *Environment) NodeOf(ctx context.Context, cluster *EdgeCluster) (*Node, error) {
+	return env.Client.NodeByName(ctx, cluster.Name)
+}
+
+// ClusterOfNode returns the cluster whose agent registered a node, or nil
+func (env *Environment) ClusterOfNode(ctx context.Context, nodeID string) (*EdgeCluster, error) {
+	node, err := env.Client.Node(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return env.Cluster(node.Name), nil
+}
+
+// WaitNodeStatus waits for a cluster's node to reach a status
+func (env *Environment) WaitNodeStatus(ctx context.Context, cluster *EdgeCluster, status string, timeout time.Duration) (*Node, error) {
+	var node *Node
+	err := Eventually(ctx, timeout, fmt.Sprintf("node %s to be %s", cluster.Name, status), func(ctx context.Context) (bool, string, error) {
+		var err error
+		node, err = env.NodeOf(ctx, cluster)
+		if err != nil {
+			return false, err.Error(), nil
+		}
+		if node == nil {
+			return false, "not registered", nil
+		}
+		return node.Status == status, node.Status, nil
+	})
+	return node, err
+}
+
+// WaitWorkload waits for a workload to satisfy a condition, returning it as last seen
+func (env *Environment) WaitWorkload(ctx context.Context, id string, timeout time.Duration, what string, condition func(*Workload) bool) (*Workload, error) {
+	var workload *Workload
+	err := Eventually(ctx, timeout, what, func(ctx context.Context) (bool, string, error) {
+		var err error
+		workload, err = env.Client.Workload(ctx, id)
+		if err != nil {
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode < 500 {
+				return false, "", err
+			}
+			return false, err.Error(), nil
+		}
+		return condition(workload), describeWorkload(workload), nil
+	})
+	return workload, err
+}
+
+// WaitWorkloadReady waits until every replica of a workload is placed and reported ready
+func (env *Environment) WaitWorkloadReady(ctx context.Context, id string, timeout time.Duration) (*Workload, error) {
+	return env.WaitWorkload(ctx, id, timeout, "workload "+id+" to be ready", func(workload *Workload) bool {
+		return workload.Status == WorkloadStatusRunning && workload.ReadyReplicas() >= workload.Replicas
+	})
+}
+
+// DeploymentImage returns the image of a workload's Kubernetes deployment in a cluster
+func (ec *EdgeCluster) DeploymentImage(ctx context.Context, namespace, name string) (string, error) {
+	output, err := ec.Kubectl(ctx, "get", "deployment", name, "-n", namespace, "-o", "jsonpath={.spec.template.spec.containers[0].image}")
+	return strings.TrimSpace(output), err
+}
+
+func describeWorkload(workload *Workload) string {
+	parts := []string{fmt.Sprintf("status %s, %d/%d ready, image %s", workload.Status, workload.ReadyReplicas(), workload.Replicas, workload.Image)}
+	for _, deployment := range workload.Deployments {
+		part := fmt.Sprintf("node %s %s", deployment.NodeID, deployment.Status)
+		if deployment.Observed != nil && deployment.Observed.Reason != "" {
+			part += " (" + deployment.Observed.Reason + ")"
+		}
+		parts = append(parts, part)
+	}
+	if workload.BlueGreen != nil {
+		parts = append(parts, "blue-green "+workload.BlueGreen.Phase)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// scenarioSpec is the nginx workload the built-in scenarios deploy
+func scenarioSpec(name string, replicas int32) WorkloadSpec {
+	return WorkloadSpec{
+		Name:      name,
+		Namespace: ScenarioNamespace,
+		Type:      "deployment",
+		Image:     ScenarioImage,
+		Replicas:  replicas,
+		Labels:    map[string]string{"app": name},
+		Resources: map[string]interface{}{
+			"requests": map[string]string{"cpu": "10m", "memory": "16Mi"},
+		},
+	}
+}
+
+// cleanup deletes a scenario's workload, logging rather than failing on errors
+func (env *Environment) cleanup(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := env.Client.DeleteWorkload(ctx, id); err != nil {
+		env.logf("Failed to delete workload %s: %v", id, err)
+	}
+}
+
+func scenarioRegister(ctx context.Context, env *Environment) error {
+	first := make(map[string]time.Time, len(env.Clusters))
+	for _, cluster := range env.Clusters {
+		node, err := env.WaitNodeStatus(ctx, cluster, NodeStatusOnline, time.Minute)
+		if err != nil {
+			return err
+		}
+		if node.Labels["e2e.edge.io/cluster"] != cluster.Name {
+			return fmt.Errorf("node %s has labels %v, missing its configured label", cluster.Name, node.Labels)
+		}
+		first[cluster.Name] = node.LastHeartbeat
+	}
+
+	// Heartbeats keep arriving
+	for _, cluster := range env.Clusters {
+		err := Eventually(ctx, time.Minute, "a new heartbeat from "+cluster.Name, func(ctx context.Context) (bool, string, error) {
+			node, err := env.NodeOf(ctx, cluster)
+			if err != nil || node == nil {
+				return false, fmt.Sprint(err), nil
+			}
+			return node.LastHeartbeat.After(first[cluster.Name]), "last heartbeat " + node.LastHeartbeat.String(), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scenarioDeploy(ctx context.Context, env *Environment) error {
+	spec := scenarioSpec("e2e-deploy", int32(len(env.Clusters)))
+	spec.Placement = map[string]interface{}{"strategy": "load-balance", "max_replicas_per_node": 1}
+	workload, err := env.Client.DeployWorkload(ctx, spec)
+	if err != nil {
+		return err
+	}
+	deleted := false
+	defer func() {
+		if !deleted {
+			env.cleanup(workload.ID)
+		}
+	}()
+
+	workload, err = env.WaitWorkloadReady(ctx, workload.ID, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	if nodes := workload.NodeIDs(); len(nodes) != len(env.Clusters) {
+		return fmt.Errorf("workload runs on %d nodes, expected one replica on each of %d", len(nodes), len(env.Clusters))
+	}
+	for _, cluster := range env.Clusters {
+		image, err := cluster.DeploymentImage(ctx, spec.Namespace, spec.Name)
+		if err != nil {
+			return fmt.Errorf("deployment missing from cluster %s: %v", cluster.Name, err)
+		}
+		if image != spec.Image {
+			return fmt.Errorf("cluster %s runs image %s, expected %s", cluster.Name, image, spec.Image)
+		}
+	}
+
+	// Deleting the workload removes it from every cluster
+	if err := env.Client.DeleteWorkload(ctx, workload.ID); err != nil {
+		return err
+	}
+	deleted = true
+	for _, cluster := range env.Clusters {
+		err := Eventually(ctx, 2*time.Minute, "deployment removed from "+cluster.Name, func(ctx context.Context) (bool, string, error) {
+			_, err := cluster.DeploymentImage(ctx, spec.Namespace, spec.Name)
+			return err != nil && strings.Contains(err.Error(), "NotFound"), "still present", nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scenarioRollback(ctx context.Context, env *Environment) error {
+	spec := scenarioSpec("e2e-rollback", 1)
+	workload, err := env.Client.DeployWorkload(ctx, spec)
+	if err != nil {
+		return err
+	}
+	defer env.cleanup(workload.ID)
+
+	if _, err := env.WaitWorkloadReady(ctx, workload.ID, 5*time.Minute); err != nil {
+		return err
+	}
+
+	// Upgrade through a blue-green deployment, switched automatically and then promoted
+	upgraded := spec
+	upgraded.Image = ScenarioUpgradedImage
+	if err := env.Client.StartBlueGreen(ctx, workload.ID, upgraded, false); err != nil {
+		return err
+	}
+	if _, err := env.WaitWorkload(ctx, workload.ID, 5*time.Minute, "blue-green traffic switch", func(w *Workload) bool {
+		return w.BlueGreen != nil && w.BlueGreen.Phase == "switched"
+	}); err != nil {
+		return err
+	}
+	if err := env.Client.PromoteBlueGreen(ctx, workload.ID); err != nil {
+		return err
+	}
+	workload, err = env.WaitWorkload(ctx, workload.ID, 5*time.Minute, "promotion to "+ScenarioUpgradedImage, func(w *Workload) bool {
+		return w.BlueGreen == nil && w.Image == ScenarioUpgradedImage && w.Status == WorkloadStatusRunning && w.ReadyReplicas() >= w.Replicas
+	})
+	if err != nil {
+		return err
+	}
+	upgradedRevision := workload.Revision
+
+	// Back to the previous revision
+	revision, err := env.Client.Rollback(ctx, workload.ID, 0)
+	if err != nil {
+		return err
+	}
+	if revision <= upgradedRevision {
+		return fmt.Errorf("rollback recorded revision %d, expected one after %d", revision, upgradedRevision)
+	}
+	workload, err = env.WaitWorkload(ctx, workload.ID, 5*time.Minute, "rollback to "+ScenarioImage, func(w *Workload) bool {
+		return w.Image == ScenarioImage && w.Status == WorkloadStatusRunning && w.ReadyReplicas() >= w.Replicas
+	})
+	if err != nil {
+		return err
+	}
+	revisions, current, err := env.Client.Revisions(ctx, workload.ID)
+	if err != nil {
+		return err
+	}
+	if current != revision || len(revisions) < 3 {
+		return fmt.Errorf("revision history has %d revisions at %d, expected at least 3 at %d", len(revisions), current, revision)
+	}
+
+	for _, nodeID := range workload.NodeIDs() {
+		cluster, err := env.ClusterOfNode(ctx, nodeID)
+		if err != nil || cluster == nil {
+			return fmt.Errorf("no cluster for node %s: %v", nodeID, err)
+		}
+		err = Eventually(ctx, 2*time.Minute, "rolled back image in "+cluster.Name, func(ctx context.Context) (bool, string, error) {
+			image, err := cluster.DeploymentImage(ctx, spec.Namespace, spec.Name)
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			return image == ScenarioImage, "image " + image, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scenarioNodeFailure(ctx context.Context, env *Environment) error {
+	spec := scenarioSpec("e2e-failover", 1)
+	workload, err := env.Client.DeployWorkload(ctx, spec)
+	if err != nil {
+		return err
+	}
+	defer env.cleanup(workload.ID)
+
+	workload, err = env.WaitWorkloadReady(ctx, workload.ID, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	nodes := workload.NodeIDs()
+	if len(nodes) != 1 {
+		return fmt.Errorf("workload runs on %d nodes, expected 1", len(nodes))
+	}
+	failedNode := nodes[0]
+	failed, err := env.ClusterOfNode(ctx, failedNode)
+	if err != nil || failed == nil {
+		return fmt.Errorf("no cluster for node %s: %v", failedNode, err)
+	}
+
+	env.logf("Taking cluster %s down", failed.Name)
+	if err := failed.Fail(ctx); err != nil {
+		return err
+	}
+	// Bring the site back whatever happens, for the scenarios after this one
+	defer func() {
+		recoverCtx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+		defer cancel()
+		if err := failed.Recover(recoverCtx); err != nil {
+			env.logf("Failed to recover cluster %s: %v", failed.Name, err)
+			return
+		}
+		if _, err := env.WaitNodeStatus(recoverCtx, failed, NodeStatusOnline, 2*time.Minute); err != nil {
+			env.logf("Cluster %s did not come back online: %v", failed.Name, err)
+		}
+	}()
+
+	// Nodes go offline after two minutes without heartbeats
+	if _, err := env.WaitNodeStatus(ctx, failed, NodeStatusOffline, 4*time.Minute); err != nil {
+		return err
+	}
+	_, err = env.WaitWorkload(ctx, workload.ID, 4*time.Minute, "replica re-placed off "+failed.Name, func(w *Workload) bool {
+		nodes := w.NodeIDs()
+		return len(nodes) == 1 && nodes[0] != failedNode && w.Status == WorkloadStatusRunning && w.ReadyReplicas() >= w.Replicas
+	})
+	return err
+}
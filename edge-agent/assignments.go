@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// Workload delivery over a WebSocket the orchestrator pushes commands on (default)
+	WorkloadDeliveryStream = "stream"
+	// Workload delivery over long-polled assignment requests, for networks whose proxies
+	// or firewalls do not pass WebSockets
+	WorkloadDeliveryLongPoll = "long-poll"
+
+	// How long the orchestrator holds an assignment request open waiting for a change
+	AssignmentPollWait = 60 * time.Second
+
+	// How long to wait before polling again after a failed request
+	AssignmentPollRetryInterval = 10 * time.Second
+)
+
+// AssignmentsResponse is the node's assigned workloads at a resource version, the hash of
+// its desired-state document
+type AssignmentsResponse struct {
+	ResourceVersion string                 `json:"resource_version"`
+	Unchanged       bool                   `json:"unchanged,omitempty"`
+	Workloads       map[string]interface{} `json:"workloads,omitempty"`
+}
+
+// validateWorkloadDelivery checks the workload delivery mode. Long polls are REST
+// requests, so they need the REST API transport.
+func validateWorkloadDelivery(config *Config) error {
+	switch config.WorkloadDelivery {
+	case "", WorkloadDeliveryStream:
+	case WorkloadDeliveryLongPoll:
+		if config.APITransport == "grpc" {
+			return fmt.Errorf("workload_delivery long-poll requires api_transport rest")
+		}
+	default:
+		return fmt.Errorf("unknown workload_delivery %q", config.WorkloadDelivery)
+	}
+	return nil
+}
+
+// startAssignmentPolling long-polls the orchestrator for assignment changes and applies
+// them as soon as they are returned, instead of holding a workload stream open. Between
+// changes the regular reconciliation pass still runs every heartbeat interval.
+func (ea *EdgeAgent) startAssignmentPolling() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, assignment polling disabled")
+		return
+	}
+
+	// The shared client's timeout is shorter than a poll
+	client := &http.Client{
+		Timeout:   AssignmentPollWait + DefaultTimeout,
+		Transport: ea.httpClient.Transport,
+	}
+
+	ea.logger.Info("Starting assignment polling")
+
+	for {
+		changed, err := ea.pollAssignments(client)
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		default:
+		}
+
+		if err != nil {
+			ea.logger.Warnf("Failed to poll assignments: %v", err)
+			select {
+			case <-ea.registrationCtx.Done():
+				return
+			case <-time.After(AssignmentPollRetryInterval):
+			}
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err := ea.reconcileWorkloads(); err != nil {
+			ea.logger.Errorf("Failed to reconcile workloads: %v", err)
+		}
+	}
+}
+
+// pollAssignments waits for the node's assignments to move from the version the agent has
+// and stores the new desired state, reporting whether it changed
+func (ea *EdgeAgent) pollAssignments(client *http.Client) (bool, error) {
+	ea.desiredMutex.Lock()
+	version := ea.desired.hash
+	ea.desiredMutex.Unlock()
+
+	query := url.Values{}
+	if version != "" {
+		query.Set("resourceVersion", version)
+		query.Set("wait", AssignmentPollWait.String())
+	}
+	path := fmt.Sprintf("/api/v1/nodes/%s/assignments", ea.nodeID)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ea.registrationCtx, "GET", ea.config.OrchestratorURL+path, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+ea.config.AuthToken)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("request GET %s failed with status %d: %s", path, resp.StatusCode, string(respBody))
+	}
+
+	var assignments AssignmentsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&assignments); err != nil {
+		return false, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if assignments.Unchanged {
+		return false, nil
+	}
+	return true, ea.acceptAssignments(&assignments)
+}
+
+// acceptAssignments stores the assignments as the desired state after verifying them
+// against their resource version. The version is left as the hint, so the next pass uses
+// the stored document without fetching it again.
+func (ea *EdgeAgent) acceptAssignments(assignments *AssignmentsResponse) error {
+	workloads := assignments.Workloads
+	if workloads == nil {
+		workloads = make(map[string]interface{})
+	}
+	document := map[string]interface{}{"workloads": workloads}
+
+	hash, err := hashDocument(document)
+	if err != nil {
+		return err
+	}
+	if hash != assignments.ResourceVersion {
+		return fmt.Errorf("assignments hash mismatch: got %s, expected %s", hash, assignments.ResourceVersion)
+	}
+
+	ea.desiredMutex.Lock()
+	defer ea.desiredMutex.Unlock()
+
+	ea.desired = desiredState{hash: hash, document: document}
+	ea.desiredHint = hash
+	return nil
+}
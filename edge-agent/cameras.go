@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Where the kernel lists V4L2 video devices
+	Video4LinuxPath = "/sys/class/video4linux"
+
+	// Stable names for video devices, derived from their USB serial numbers
+	VideoByIDPath = "/dev/v4l/by-id"
+
+	// How long each camera's stream is sampled to measure its frame rate
+	CameraSampleDuration = 3 * time.Second
+)
+
+// CameraConfig is a network camera this node can reach over RTSP
+type CameraConfig struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+type CameraHealth struct {
+	Status          string     `json:"status"`
+	FramesPerSecond float64    `json:"frames_per_second"`
+	LastFrameAt     *time.Time `json:"last_frame_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	CheckedAt       time.Time  `json:"checked_at"`
+}
+
+// Camera is a video source attached to or reachable from this node
+type Camera struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	DevicePath string       `json:"device_path,omitempty"`
+	StreamURL  string       `json:"stream_url,omitempty"`
+	Health     CameraHealth `json:"health"`
+}
+
+type CameraReport struct {
+	Cameras []Camera `json:"cameras"`
+}
+
+// startCameraMonitoring reports the cameras attached to this host and the health of their
+// streams. Last frame times are remembered between probes so a stalled camera still
+// shows when it last delivered video.
+func (ea *EdgeAgent) startCameraMonitoring() {
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	lastFrames := make(map[string]time.Time)
+	if err := ea.reportCameras(lastFrames); err != nil {
+		ea.logger.Errorf("Failed to report cameras: %v", err)
+	}
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.reportCameras(lastFrames); err != nil {
+				ea.logger.Errorf("Failed to report cameras: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) reportCameras(lastFrames map[string]time.Time) error {
+	cameras := append(discoverVideoDevices(ea.config.NodeName), configuredCameras(ea.config.Cameras)...)
+	probeCameras(ea.registrationCtx, cameras)
+
+	for i := range cameras {
+		health := &cameras[i].Health
+		if health.Status == "streaming" {
+			lastFrames[cameras[i].ID] = health.CheckedAt
+		}
+		if lastFrame, ok := lastFrames[cameras[i].ID]; ok {
+			health.LastFrameAt = &lastFrame
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/nodes/%s/cameras", ea.nodeID)
+	return ea.doRequest("PUT", path, CameraReport{Cameras: cameras}, nil)
+}
+
+// discoverVideoDevices lists the V4L2 capture devices on this host. USB cameras expose a
+// metadata device next to each capture device; only the capture device, index 0, is kept.
+func discoverVideoDevices(nodeName string) []Camera {
+	entries, err := os.ReadDir(Video4LinuxPath)
+	if err != nil {
+		return nil
+	}
+
+	// Prefer the serial-based name, which survives reboots and replugging into another port
+	stableIDs := make(map[string]string)
+	if links, err := os.ReadDir(VideoByIDPath); err == nil {
+		for _, link := range links {
+			target, err := filepath.EvalSymlinks(filepath.Join(VideoByIDPath, link.Name()))
+			if err == nil {
+				stableIDs[filepath.Base(target)] = strings.TrimSuffix(link.Name(), "-video-index0")
+			}
+		}
+	}
+
+	var cameras []Camera
+	for _, entry := range entries {
+		device := entry.Name()
+		dir := filepath.Join(Video4LinuxPath, device)
+		if index, err := os.ReadFile(filepath.Join(dir, "index")); err == nil && strings.TrimSpace(string(index)) != "0" {
+			continue
+		}
+
+		id := nodeName + "-" + device
+		if stableID, ok := stableIDs[device]; ok {
+			id = stableID
+		}
+		name, _ := os.ReadFile(filepath.Join(dir, "name"))
+		cameras = append(cameras, Camera{
+			ID:         id,
+			Name:       strings.TrimSpace(string(name)),
+			Type:       "usb",
+			DevicePath: "/dev/" + device,
+		})
+	}
+	return cameras
+}
+
+// configuredCameras returns the network cameras from the agent configuration
+func configuredCameras(configs []CameraConfig) []Camera {
+	cameras := make([]Camera, 0, len(configs))
+	for _, config := range configs {
+		name := config.Name
+		if name == "" {
+			name = config.ID
+		}
+		cameras = append(cameras, Camera{ID: config.ID, Name: name, Type: "rtsp", StreamURL: config.URL})
+	}
+	return cameras
+}
+
+// parseCameraList parses "id=url" pairs separated by commas, as given in RTSP_CAMERAS
+func parseCameraList(value string) []CameraConfig {
+	var cameras []CameraConfig
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			cameras = append(cameras, CameraConfig{ID: parts[0], URL: parts[1]})
+		}
+	}
+	return cameras
+}
+
+// probeCameras measures every camera's frame rate in parallel
+func probeCameras(ctx context.Context, cameras []Camera) {
+	_, err := exec.LookPath("ffprobe")
+	available := err == nil
+
+	var wg sync.WaitGroup
+	for i := range cameras {
+		if !available {
+			cameras[i].Health = CameraHealth{Status: "unknown", Error: "ffprobe is not installed", CheckedAt: time.Now()}
+			continue
+		}
+		wg.Add(1)
+		go func(camera *Camera) {
+			defer wg.Done()
+			camera.Health = probeCamera(ctx, camera)
+		}(&cameras[i])
+	}
+	wg.Wait()
+}
+
+// probeCamera counts the video packets ffprobe reads from a camera over a short window
+func probeCamera(ctx context.Context, camera *Camera) CameraHealth {
+	ctx, cancel := context.WithTimeout(ctx, CameraSampleDuration+10*time.Second)
+	defer cancel()
+
+	args := []string{"-v", "error"}
+	source := camera.StreamURL
+	if camera.Type == "usb" {
+		args = append(args, "-f", "v4l2")
+		source = camera.DevicePath
+	} else {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args,
+		"-read_intervals", fmt.Sprintf("%%+%d", int(CameraSampleDuration.Seconds())),
+		"-select_streams", "v:0", "-count_packets",
+		"-show_entries", "stream=nb_read_packets", "-of", "csv=p=0",
+		source)
+
+	output, err := exec.CommandContext(ctx, "ffprobe", args...).Output()
+	checkedAt := time.Now()
+	if err != nil {
+		message := err.Error()
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			message = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		// A capture device can only be opened once, so a camera bound to a running
+		// analytics container cannot be sampled by the agent
+		if strings.Contains(message, "Device or resource busy") {
+			return CameraHealth{Status: "unknown", Error: "device in use", CheckedAt: checkedAt}
+		}
+		return CameraHealth{Status: "unreachable", Error: message, CheckedAt: checkedAt}
+	}
+
+	packets, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return CameraHealth{Status: "unreachable", Error: "no video stream found", CheckedAt: checkedAt}
+	}
+	if packets == 0 {
+		return CameraHealth{Status: "stalled", CheckedAt: checkedAt}
+	}
+	return CameraHealth{
+		Status:          "streaming",
+		FramesPerSecond: float64(packets) / CameraSampleDuration.Seconds(),
+		CheckedAt:       checkedAt,
+	}
+}
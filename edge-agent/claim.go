@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// How often an unclaimed device checks whether it has been claimed
+	ClaimPollInterval = 10 * time.Second
+
+	// getty shows files in this directory on the console login screen
+	ClaimIssueFile = "/etc/issue.d/edge-claim.issue"
+
+	// Characters claim codes are drawn from, leaving out ones that are easily misread
+	claimCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// ClaimBinding is what the orchestrator hands a device once an operator claims it
+type ClaimBinding struct {
+	NodeName  string            `json:"node_name"`
+	AuthToken string            `json:"auth_token"`
+	SiteID    string            `json:"site_id"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// claimRecord survives restarts so a device keeps its code while unclaimed and skips
+// the claim flow once claimed
+type claimRecord struct {
+	Code    string        `json:"code"`
+	Secret  string        `json:"secret"`
+	Binding *ClaimBinding `json:"binding,omitempty"`
+}
+
+// claimFile keeps the claim record next to the state file; it holds credentials and is
+// only readable by the agent
+func (ea *EdgeAgent) claimFile() string {
+	return filepath.Join(filepath.Dir(ea.config.StateFile), "claim.json")
+}
+
+func newClaimRecord() (*claimRecord, error) {
+	random := make([]byte, 8+32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate claim code: %v", err)
+	}
+	code := make([]byte, 0, 9)
+	for i, b := range random[:8] {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, claimCodeAlphabet[int(b)%len(claimCodeAlphabet)])
+	}
+	return &claimRecord{Code: string(code), Secret: hex.EncodeToString(random[8:])}, nil
+}
+
+func (ea *EdgeAgent) saveClaimRecord(record *claimRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal claim record: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(ea.claimFile()), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	return os.WriteFile(ea.claimFile(), data, 0600)
+}
+
+// awaitClaim blocks until an operator claims this device, then applies the binding to
+// the configuration so registration proceeds as usual
+func (ea *EdgeAgent) awaitClaim(ctx context.Context) error {
+	record := &claimRecord{}
+	if data, err := os.ReadFile(ea.claimFile()); err == nil {
+		if err := json.Unmarshal(data, record); err != nil {
+			return fmt.Errorf("failed to parse claim record: %v", err)
+		}
+	}
+	if record.Binding != nil {
+		ea.applyClaimBinding(record.Binding)
+		return nil
+	}
+	if record.Code == "" {
+		fresh, err := newClaimRecord()
+		if err != nil {
+			return err
+		}
+		record = fresh
+		if err := ea.saveClaimRecord(record); err != nil {
+			return err
+		}
+	}
+
+	announced := false
+	ticker := time.NewTicker(ClaimPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if !announced {
+			status, err := ea.claimRequest("POST", "/api/v1/claims", record.Secret, map[string]interface{}{
+				"code":         record.Code,
+				"name":         ea.config.NodeName,
+				"hostname":     hostname(),
+				"address":      ea.config.NodeAddress,
+				"capabilities": ea.config.Capabilities,
+			}, nil)
+			switch {
+			case status == http.StatusConflict:
+				// Another device holds the code; pick a new one
+				fresh, err := newClaimRecord()
+				if err != nil {
+					return err
+				}
+				record = fresh
+				if err := ea.saveClaimRecord(record); err != nil {
+					return err
+				}
+				continue
+			case err != nil:
+				ea.logger.Warnf("Failed to announce device for claiming: %v", err)
+			default:
+				announced = true
+				ea.showClaimCode(record.Code)
+			}
+		} else {
+			var resp struct {
+				Status  string        `json:"status"`
+				Binding *ClaimBinding `json:"binding"`
+			}
+			status, err := ea.claimRequest("GET", "/api/v1/claims/"+record.Code+"/status", record.Secret, nil, &resp)
+			switch {
+			case status == http.StatusNotFound:
+				// The orchestrator forgot the code, such as after a restart
+				announced = false
+				continue
+			case err != nil:
+				ea.logger.Warnf("Failed to check claim status: %v", err)
+			case resp.Status == "rejected":
+				os.Remove(ea.claimFile())
+				os.Remove(ClaimIssueFile)
+				return fmt.Errorf("device was rejected by an operator")
+			case resp.Status == "claimed" && resp.Binding != nil:
+				record.Binding = resp.Binding
+				if err := ea.saveClaimRecord(record); err != nil {
+					return err
+				}
+				os.Remove(ClaimIssueFile)
+				ea.applyClaimBinding(resp.Binding)
+				ea.logger.Infof("Device claimed as %s", ea.config.NodeName)
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// showClaimCode logs the claim code and puts it on the console login screen, along with
+// the URL a QR code for it encodes
+func (ea *EdgeAgent) showClaimCode(code string) {
+	claimURL := ea.config.OrchestratorURL + "/claim?code=" + url.QueryEscape(code)
+	ea.logger.Infof("Waiting to be claimed. Claim code: %s (%s)", code, claimURL)
+
+	issue := fmt.Sprintf("\nThis edge device is waiting to be claimed.\n  Claim code: %s\n  %s\n\n", code, claimURL)
+	if err := os.MkdirAll(filepath.Dir(ClaimIssueFile), 0755); err == nil {
+		os.WriteFile(ClaimIssueFile, []byte(issue), 0644)
+	}
+}
+
+func (ea *EdgeAgent) applyClaimBinding(binding *ClaimBinding) {
+	if binding.NodeName != "" {
+		ea.config.NodeName = binding.NodeName
+	}
+	ea.config.AuthToken = binding.AuthToken
+	if binding.SiteID != "" {
+		ea.config.SiteID = binding.SiteID
+	}
+	for key, value := range binding.Labels {
+		ea.config.Labels[key] = value
+	}
+}
+
+// claimRequest is doRequest authenticated with the device secret, returning the status
+// so callers can tell a lost or taken code from other failures
+func (ea *EdgeAgent) claimRequest(method, path, secret string, payload, out interface{}) (int, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, ea.config.OrchestratorURL+path, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+secret)
+
+	resp, err := ea.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("request %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Interval between cloud instance metadata reports
+	CloudMetadataReportInterval = time.Hour
+
+	// Label the orchestrator's cloud provisioner gives the nodes it creates
+	CloudNodeLabel = "edge.io/cloud-node"
+
+	// Instance metadata services; all providers serve them on the link-local address
+	awsMetadataURL   = "http://169.254.169.254/latest"
+	gcpMetadataURL   = "http://metadata.google.internal/computeMetadata/v1/instance"
+	azureMetadataURL = "http://169.254.169.254/metadata/instance?api-version=2021-02-01"
+
+	// Metadata services answer within milliseconds; anything slower is not one
+	cloudMetadataTimeout = 2 * time.Second
+)
+
+// CloudMetadata describes the cloud instance this agent runs on
+type CloudMetadata struct {
+	Provider         string    `json:"provider"`
+	InstanceID       string    `json:"instance_id"`
+	InstanceType     string    `json:"instance_type"`
+	Region           string    `json:"region"`
+	AvailabilityZone string    `json:"availability_zone"`
+	Lifecycle        string    `json:"lifecycle"`
+	CollectedAt      time.Time `json:"collected_at"`
+}
+
+// cloudMetadataEnabled reports whether this node should read its instance metadata: when
+// configured, or when the cloud provisioner created it
+func (ea *EdgeAgent) cloudMetadataEnabled() bool {
+	return ea.config.CloudMetadata || ea.config.Labels[CloudNodeLabel] == "true"
+}
+
+func (ea *EdgeAgent) startCloudMetadataReporting() {
+	ticker := time.NewTicker(CloudMetadataReportInterval)
+	defer ticker.Stop()
+
+	if err := ea.reportCloudMetadata(); err != nil {
+		ea.logger.Errorf("Failed to report cloud metadata: %v", err)
+	}
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.reportCloudMetadata(); err != nil {
+				ea.logger.Errorf("Failed to report cloud metadata: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) reportCloudMetadata() error {
+	metadata, err := collectCloudMetadata(ea.registrationCtx)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/nodes/%s/cloud-metadata", ea.nodeID)
+	return ea.doRequest("POST", path, metadata, nil)
+}
+
+// collectCloudMetadata asks each provider's instance metadata service in turn and returns
+// the answer of the first that responds
+func collectCloudMetadata(ctx context.Context) (*CloudMetadata, error) {
+	client := &http.Client{Timeout: cloudMetadataTimeout}
+	collectors := []func(context.Context, *http.Client) (*CloudMetadata, error){
+		collectAWSMetadata,
+		collectGCPMetadata,
+		collectAzureMetadata,
+	}
+
+	var errs []string
+	for _, collect := range collectors {
+		metadata, err := collect(ctx, client)
+		if err == nil {
+			metadata.CollectedAt = time.Now()
+			return metadata, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("no instance metadata service found: %s", strings.Join(errs, "; "))
+}
+
+// metadataGet fetches a metadata document with the given headers
+func metadataGet(ctx context.Context, client *http.Client, method, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// collectAWSMetadata reads EC2 instance metadata through an IMDSv2 session token
+func collectAWSMetadata(ctx context.Context, client *http.Client) (*CloudMetadata, error) {
+	token, err := metadataGet(ctx, client, "PUT", awsMetadataURL+"/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws: %v", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+
+	metadata := &CloudMetadata{Provider: "aws"}
+	fields := map[string]*string{
+		"instance-id":                 &metadata.InstanceID,
+		"instance-type":               &metadata.InstanceType,
+		"placement/region":            &metadata.Region,
+		"placement/availability-zone": &metadata.AvailabilityZone,
+		"instance-life-cycle":         &metadata.Lifecycle,
+	}
+	for path, field := range fields {
+		value, err := metadataGet(ctx, client, "GET", awsMetadataURL+"/meta-data/"+path, headers)
+		if err != nil {
+			return nil, fmt.Errorf("aws: %v", err)
+		}
+		*field = value
+	}
+	// EC2 reports "spot", "on-demand" or "scheduled"
+	if metadata.Lifecycle != "spot" {
+		metadata.Lifecycle = "on-demand"
+	}
+	return metadata, nil
+}
+
+// collectGCPMetadata reads Compute Engine instance metadata
+func collectGCPMetadata(ctx context.Context, client *http.Client) (*CloudMetadata, error) {
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+
+	metadata := &CloudMetadata{Provider: "gcp"}
+	values := make(map[string]string)
+	for _, path := range []string{"id", "machine-type", "zone", "scheduling/provisioning-model", "scheduling/preemptible"} {
+		value, err := metadataGet(ctx, client, "GET", gcpMetadataURL+"/"+path, headers)
+		if err != nil && path != "scheduling/provisioning-model" {
+			return nil, fmt.Errorf("gcp: %v", err)
+		}
+		values[path] = value
+	}
+
+	// Machine type and zone are resource paths such as projects/123/zones/us-central1-a
+	metadata.InstanceID = values["id"]
+	metadata.InstanceType = values["machine-type"][strings.LastIndex(values["machine-type"], "/")+1:]
+	metadata.AvailabilityZone = values["zone"][strings.LastIndex(values["zone"], "/")+1:]
+	if i := strings.LastIndex(metadata.AvailabilityZone, "-"); i > 0 {
+		metadata.Region = metadata.AvailabilityZone[:i]
+	}
+	metadata.Lifecycle = "on-demand"
+	if values["scheduling/provisioning-model"] == "SPOT" || strings.EqualFold(values["scheduling/preemptible"], "TRUE") {
+		metadata.Lifecycle = "spot"
+	}
+	return metadata, nil
+}
+
+// collectAzureMetadata reads Azure instance metadata
+func collectAzureMetadata(ctx context.Context, client *http.Client) (*CloudMetadata, error) {
+	body, err := metadataGet(ctx, client, "GET", azureMetadataURL, map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, fmt.Errorf("azure: %v", err)
+	}
+
+	var instance struct {
+		Compute struct {
+			VMID     string `json:"vmId"`
+			VMSize   string `json:"vmSize"`
+			Location string `json:"location"`
+			Zone     string `json:"zone"`
+			Priority string `json:"priority"`
+		} `json:"compute"`
+	}
+	if err := json.Unmarshal([]byte(body), &instance); err != nil {
+		return nil, fmt.Errorf("azure: invalid instance metadata: %v", err)
+	}
+
+	metadata := &CloudMetadata{
+		Provider:     "azure",
+		InstanceID:   instance.Compute.VMID,
+		InstanceType: instance.Compute.VMSize,
+		Region:       instance.Compute.Location,
+		Lifecycle:    "on-demand",
+	}
+	// Azure zones are numbered within the region
+	if instance.Compute.Zone != "" {
+		metadata.AvailabilityZone = instance.Compute.Location + "-" + instance.Compute.Zone
+	}
+	if strings.EqualFold(instance.Compute.Priority, "Spot") {
+		metadata.Lifecycle = "spot"
+	}
+	return metadata, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// Extended resource name used to count GPUs across a cluster's nodes
+	GPUResourceName corev1.ResourceName = "nvidia.com/gpu"
+)
+
+// ClusterConfig describes one cluster managed by a multi-cluster agent. Each cluster
+// registers as its own logical edge node; unset fields inherit the agent's configuration.
+type ClusterConfig struct {
+	Name           string            `yaml:"name"`
+	KubeconfigPath string            `yaml:"kubeconfig_path"`
+	Context        string            `yaml:"context"`
+	NodeAddress    string            `yaml:"node_address"`
+	Region         string            `yaml:"region"`
+	Zone           string            `yaml:"zone"`
+	SiteID         string            `yaml:"site_id"`
+	Labels         map[string]string `yaml:"labels"`
+	Capabilities   []string          `yaml:"capabilities"`
+}
+
+// NodeHeartbeatEntry is one logical node's heartbeat within a batch
+type NodeHeartbeatEntry struct {
+	NodeID    string        `json:"node_id"`
+	Status    NodeStatus    `json:"status"`
+	Resources NodeResources `json:"resources"`
+	Timestamp time.Time     `json:"timestamp"`
+	// Only sent for site peers; cluster heartbeats leave them out
+	Latency       []LatencyMeasurement `json:"latency,omitempty"`
+	LogSummaries  []WorkloadLogSummary `json:"log_summaries,omitempty"`
+	CustomMetrics []CustomMetric       `json:"custom_metrics,omitempty"`
+}
+
+// BatchHeartbeatRequest carries the heartbeats of every managed cluster, or of a site's
+// agents when sent by its gateway
+type BatchHeartbeatRequest struct {
+	Heartbeats    []NodeHeartbeatEntry `json:"heartbeats"`
+	SiteGatewayID string               `json:"site_gateway_id,omitempty"`
+}
+
+// BatchHeartbeatResult is the orchestrator's answer for one heartbeat in a batch
+type BatchHeartbeatResult struct {
+	Accepted         bool   `json:"accepted"`
+	Error            string `json:"error,omitempty"`
+	DesiredStateHash string `json:"desired_state_hash,omitempty"`
+}
+
+// BatchHeartbeatResponse maps node IDs to their heartbeat results
+type BatchHeartbeatResponse struct {
+	Results map[string]BatchHeartbeatResult `json:"results"`
+}
+
+// clusterStateFile returns the state file of a cluster's logical node, next to the agent's
+func clusterStateFile(stateFile, name string) string {
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + "-" + name + ext
+}
+
+// nodeConfig returns the configuration of the cluster's logical node
+func (cc *ClusterConfig) nodeConfig(base *Config) *Config {
+	config := *base
+	config.Clusters = nil
+	config.NodeName = cc.Name
+	config.KubeconfigPath = cc.KubeconfigPath
+	config.StateFile = clusterStateFile(base.StateFile, cc.Name)
+	// Heartbeats are multiplexed over HTTPS; UDP sessions are negotiated per node
+	config.HeartbeatTransport = "https"
+
+	if cc.NodeAddress != "" {
+		config.NodeAddress = cc.NodeAddress
+	}
+	if cc.Region != "" {
+		config.Region = cc.Region
+	}
+	if cc.Zone != "" {
+		config.Zone = cc.Zone
+	}
+	if cc.SiteID != "" {
+		config.SiteID = cc.SiteID
+	}
+
+	config.Labels = make(map[string]string, len(base.Labels)+len(cc.Labels))
+	for key, value := range base.Labels {
+		config.Labels[key] = value
+	}
+	for key, value := range cc.Labels {
+		config.Labels[key] = value
+	}
+	config.Capabilities = append(append([]string{}, base.Capabilities...), cc.Capabilities...)
+
+	return &config
+}
+
+// clusterClients builds Kubernetes clients for the cluster's kubeconfig and context
+func clusterClients(cc *ClusterConfig) (kubernetes.Interface, dynamic.Interface, error) {
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: cc.KubeconfigPath},
+		&clientcmd.ConfigOverrides{CurrentContext: cc.Context})
+
+	kubeconfig, err := loader.ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build kubeconfig: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(kubeconfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(kubeconfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create dynamic Kubernetes client: %v", err)
+	}
+	return kubeClient, dynamicClient, nil
+}
+
+// newClusterAgents creates an agent for each configured cluster. They share this agent's
+// orchestrator client, logger and lifetime.
+func (ea *EdgeAgent) newClusterAgents() ([]*EdgeAgent, error) {
+	agents := make([]*EdgeAgent, 0, len(ea.config.Clusters))
+	seen := make(map[string]bool)
+
+	for i := range ea.config.Clusters {
+		cluster := &ea.config.Clusters[i]
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("cluster %d has no name", i+1)
+		}
+		if seen[cluster.Name] {
+			return nil, fmt.Errorf("cluster %s is configured more than once", cluster.Name)
+		}
+		seen[cluster.Name] = true
+		if cluster.KubeconfigPath == "" {
+			return nil, fmt.Errorf("cluster %s has no kubeconfig_path", cluster.Name)
+		}
+
+		kubeClient, dynamicClient, err := clusterClients(cluster)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clients for cluster %s: %v", cluster.Name, err)
+		}
+
+		config := cluster.nodeConfig(ea.config)
+		agents = append(agents, &EdgeAgent{
+			config:          config,
+			logger:          ea.logger,
+			httpClient:      ea.httpClient,
+			grpc:            ea.grpc,
+			kubeClient:      kubeClient,
+			dynamicClient:   dynamicClient,
+			state:           newStateStore(config.StateFile),
+			cluster:         cluster,
+			registrationCtx: ea.registrationCtx,
+			cancel:          ea.cancel,
+		})
+	}
+
+	return agents, nil
+}
+
+// collectClusterResources reports a cluster's allocatable capacity and the requests of its
+// running pods, in place of the host metrics a single-cluster agent sends
+func (ea *EdgeAgent) collectClusterResources() (NodeResources, error) {
+	var resources NodeResources
+
+	ctx, cancel := context.WithTimeout(ea.registrationCtx, DefaultTimeout)
+	defer cancel()
+
+	nodes, err := ea.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return resources, fmt.Errorf("failed to list nodes in cluster %s: %v", ea.cluster.Name, err)
+	}
+
+	var cpuCapacity, memoryCapacity, storageCapacity resource.Quantity
+	var gpus int64
+	for _, node := range nodes.Items {
+		allocatable := node.Status.Allocatable
+		cpuCapacity.Add(allocatable[corev1.ResourceCPU])
+		memoryCapacity.Add(allocatable[corev1.ResourceMemory])
+		storageCapacity.Add(allocatable[corev1.ResourceEphemeralStorage])
+		if gpu, ok := allocatable[GPUResourceName]; ok {
+			gpus += gpu.Value()
+		}
+	}
+
+	pods, err := ea.kubeClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "status.phase=Running"})
+	if err != nil {
+		return resources, fmt.Errorf("failed to list pods in cluster %s: %v", ea.cluster.Name, err)
+	}
+
+	var cpuRequests, memoryRequests resource.Quantity
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			cpuRequests.Add(container.Resources.Requests[corev1.ResourceCPU])
+			memoryRequests.Add(container.Resources.Requests[corev1.ResourceMemory])
+		}
+	}
+
+	resources.CPU.Capacity = cpuCapacity.String()
+	resources.CPU.Usage = cpuRequests.String()
+	resources.CPU.Percentage = percentOf(cpuRequests.MilliValue(), cpuCapacity.MilliValue())
+	resources.Memory.Capacity = memoryCapacity.String()
+	resources.Memory.Usage = memoryRequests.String()
+	resources.Memory.Percentage = percentOf(memoryRequests.Value(), memoryCapacity.Value())
+	resources.Storage.Capacity = storageCapacity.String()
+	resources.CPU.CapacityMillicores, resources.CPU.UsageMillicores = cpuCapacity.MilliValue(), cpuRequests.MilliValue()
+	resources.Memory.CapacityBytes, resources.Memory.UsageBytes = memoryCapacity.Value(), memoryRequests.Value()
+	resources.Storage.CapacityBytes = storageCapacity.Value()
+	resources.GPUs = int(gpus)
+
+	return resources, nil
+}
+
+func percentOf(used, capacity int64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(used) / float64(capacity) * 100
+}
+
+// startClusterHeartbeats sends the heartbeats of every cluster in one request per interval
+func startClusterHeartbeats(agents []*EdgeAgent) {
+	lead := agents[0]
+	ticker := time.NewTicker(lead.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	lead.logger.Infof("Starting multiplexed heartbeat service for %d clusters", len(agents))
+
+	for {
+		select {
+		case <-lead.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := sendClusterHeartbeats(agents); err != nil {
+				lead.logger.Errorf("Failed to send cluster heartbeats: %v", err)
+			}
+		}
+	}
+}
+
+// sendClusterHeartbeats posts one batch covering every cluster. A cluster whose API server
+// cannot be reached is reported degraded.
+func sendClusterHeartbeats(agents []*EdgeAgent) error {
+	req := BatchHeartbeatRequest{Heartbeats: make([]NodeHeartbeatEntry, 0, len(agents))}
+	for _, agent := range agents {
+		status := NodeStatusOnline
+		resources, err := agent.collectResources()
+		if err != nil {
+			agent.logger.Warnf("Failed to collect resources for cluster %s: %v", agent.cluster.Name, err)
+			status = NodeStatusDegraded
+		}
+		req.Heartbeats = append(req.Heartbeats, NodeHeartbeatEntry{
+			NodeID:    agent.nodeID,
+			Status:    status,
+			Resources: resources,
+			Timestamp: time.Now(),
+		})
+	}
+
+	var resp BatchHeartbeatResponse
+	err := agents[0].doRequest("POST", "/api/v1/nodes/heartbeats", req, &resp)
+
+	for _, agent := range agents {
+		if err != nil {
+			agent.recordHeartbeat(err)
+			continue
+		}
+		result, exists := resp.Results[agent.nodeID]
+		if !exists || !result.Accepted {
+			rejected := fmt.Errorf("heartbeat rejected: %s", result.Error)
+			agent.logger.Errorf("Heartbeat for cluster %s failed: %v", agent.cluster.Name, rejected)
+			agent.recordHeartbeat(rejected)
+			continue
+		}
+		agent.recordHeartbeat(nil)
+		agent.setDesiredStateHint(result.DesiredStateHash)
+	}
+
+	return err
+}
+
+// setDesiredStateHint records the desired-state hash reported alongside a heartbeat, so the
+// next sync can skip its request when nothing changed
+func (ea *EdgeAgent) setDesiredStateHint(hash string) {
+	ea.desiredMutex.Lock()
+	defer ea.desiredMutex.Unlock()
+
+	ea.desiredHint = hash
+}
+
+// resyncClusters resyncs every cluster and records the combined result in the agent's own
+// state file, which "edge-agent resync" watches
+func (ea *EdgeAgent) resyncClusters(agents []*EdgeAgent) error {
+	var failed []string
+	for _, agent := range agents {
+		if err := agent.resync(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", agent.cluster.Name, err))
+		}
+	}
+
+	var err error
+	if len(failed) > 0 {
+		err = fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	ea.recordResync(err)
+	return err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultCollectorInterval = 5 * time.Minute
+	DefaultCollectorTimeout  = 30 * time.Second
+
+	// Metrics kept per collector run; the rest of a larger document is dropped
+	MaxCollectorMetrics = 100
+)
+
+var collectorNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// CollectorConfig runs a command on an interval and reports the numbers in the JSON object
+// it prints as custom metrics, for site-specific sensors and checks
+type CollectorConfig struct {
+	// Metrics are reported as custom.<name>.<key>
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// 5m when unset
+	Interval time.Duration `yaml:"interval"`
+	// 30s when unset
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// CustomMetric is a value a collector reported, sent with heartbeats
+type CustomMetric struct {
+	Collector   string    `json:"collector"`
+	Name        string    `json:"name"`
+	Value       float64   `json:"value"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// validateCollectors checks the configured collectors and applies their defaults
+func validateCollectors(collectors []CollectorConfig) error {
+	names := make(map[string]bool, len(collectors))
+	for i := range collectors {
+		collector := &collectors[i]
+		if !collectorNamePattern.MatchString(collector.Name) {
+			return fmt.Errorf("collector name %q must be lowercase letters, digits and underscores", collector.Name)
+		}
+		if names[collector.Name] {
+			return fmt.Errorf("collector %s is configured twice", collector.Name)
+		}
+		names[collector.Name] = true
+		if len(collector.Command) == 0 {
+			return fmt.Errorf("collector %s has no command", collector.Name)
+		}
+		if collector.Interval <= 0 {
+			collector.Interval = DefaultCollectorInterval
+		}
+		if collector.Timeout <= 0 {
+			collector.Timeout = DefaultCollectorTimeout
+		}
+	}
+	return nil
+}
+
+// startCollectors runs every configured collector on its own interval
+func (ea *EdgeAgent) startCollectors() {
+	for _, collector := range ea.config.Collectors {
+		go ea.runCollector(collector)
+	}
+}
+
+func (ea *EdgeAgent) runCollector(collector CollectorConfig) {
+	ticker := time.NewTicker(collector.Interval)
+	defer ticker.Stop()
+
+	for {
+		metrics, err := ea.collect(collector)
+		if err != nil {
+			// A failed run drops the collector's previous values rather than report them as current
+			ea.logger.Warnf("Collector %s failed: %v", collector.Name, err)
+		}
+		ea.customMetricMutex.Lock()
+		ea.customMetrics[collector.Name] = metrics
+		ea.customMetricMutex.Unlock()
+
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect runs a collector's command and parses its output
+func (ea *EdgeAgent) collect(collector CollectorConfig) ([]CustomMetric, error) {
+	ctx, cancel := context.WithTimeout(ea.registrationCtx, collector.Timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, collector.Command[0], collector.Command[1:]...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out after %s", collector.Timeout)
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%v: %s", err, tailOutput(exitErr.Stderr))
+		}
+		return nil, err
+	}
+	return parseCollectorOutput(collector.Name, output, time.Now())
+}
+
+// parseCollectorOutput reads a JSON object of metrics. Nested objects are flattened into
+// dotted names, booleans count as 1 or 0, and other values are ignored.
+func parseCollectorOutput(collector string, output []byte, now time.Time) ([]CustomMetric, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(output, &document); err != nil {
+		return nil, fmt.Errorf("output is not a JSON object: %v", err)
+	}
+
+	values := make(map[string]float64)
+	flattenMetrics("", document, values)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > MaxCollectorMetrics {
+		names = names[:MaxCollectorMetrics]
+	}
+
+	metrics := make([]CustomMetric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, CustomMetric{Collector: collector, Name: name, Value: values[name], CollectedAt: now})
+	}
+	return metrics, nil
+}
+
+func flattenMetrics(prefix string, document map[string]interface{}, values map[string]float64) {
+	for key, value := range document {
+		name := strings.TrimPrefix(prefix+"."+key, ".")
+		switch v := value.(type) {
+		case float64:
+			values[name] = v
+		case bool:
+			if v {
+				values[name] = 1
+			} else {
+				values[name] = 0
+			}
+		case map[string]interface{}:
+			flattenMetrics(name, v, values)
+		}
+	}
+}
+
+// latestCustomMetrics returns the values of every collector's latest run
+func (ea *EdgeAgent) latestCustomMetrics() []CustomMetric {
+	ea.customMetricMutex.Lock()
+	defer ea.customMetricMutex.Unlock()
+
+	names := make([]string, 0, len(ea.customMetrics))
+	for name := range ea.customMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var metrics []CustomMetric
+	for _, name := range names {
+		metrics = append(metrics, ea.customMetrics[name]...)
+	}
+	return metrics
+}
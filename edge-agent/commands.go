@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Clock skew beyond this breaks certificate validation and heartbeat timing
+	MaxClockSkew = 30 * time.Second
+
+	// Certificates closer than this to expiry are reported as warnings
+	CertificateExpiryWarning = 14 * 24 * time.Hour
+
+	// How long resync waits for the running agent to finish
+	ResyncTimeout = 60 * time.Second
+)
+
+const usage = `Usage: edge-agent [command]
+
+Commands:
+  run      Run the agent (default)
+  status   Show local agent state and the last heartbeat result
+  check    Check orchestrator connectivity, TLS, clock and Kubernetes API reachability
+  resync   Ask the running agent to heartbeat and resync immediately
+`
+
+// runCommand dispatches a diagnostic subcommand and returns the process exit code
+func runCommand(command string) int {
+	switch command {
+	case "status":
+		return cmdStatus()
+	case "check":
+		return cmdCheck()
+	case "resync":
+		return cmdResync()
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+	return 2
+}
+
+func loadCommandConfig() (*Config, error) {
+	configPath := os.Getenv("EDGE_AGENT_CONFIG")
+	if configPath == "" {
+		configPath = DefaultConfigPath
+	}
+	return loadConfig(configPath)
+}
+
+// agentRunning reports whether the process recorded in the state file is alive
+func agentRunning(state *LocalState) bool {
+	if state.PID <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(state.PID)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(t).Round(time.Second))
+}
+
+func cmdStatus() int {
+	config, err := loadCommandConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	state, err := readLocalState(config.StateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No local state (has the agent run on this host?): %v\n", err)
+		return 1
+	}
+
+	running := "not running"
+	if agentRunning(state) {
+		running = fmt.Sprintf("running (pid %d)", state.PID)
+	}
+
+	fmt.Printf("Agent:            %s\n", running)
+	healthy := true
+	if len(config.Clusters) == 0 {
+		healthy = printNodeStatus(state)
+	}
+	fmt.Printf("Orchestrator:     %s\n", state.OrchestratorURL)
+	fmt.Printf("Started:          %s\n", formatTime(state.StartedAt))
+	fmt.Printf("Last resync:      %s\n", formatTime(state.LastResyncAt))
+	if state.LastResyncError != "" {
+		fmt.Printf("Resync error:     %s\n", state.LastResyncError)
+	}
+
+	// Each cluster of a multi-cluster agent keeps its own state file
+	for _, cluster := range config.Clusters {
+		fmt.Printf("\nCluster %s\n", cluster.Name)
+		clusterState, err := readLocalState(clusterStateFile(config.StateFile, cluster.Name))
+		if err != nil {
+			fmt.Printf("No local state:   %v\n", err)
+			healthy = false
+			continue
+		}
+		if !printNodeStatus(clusterState) {
+			healthy = false
+		}
+	}
+
+	if !agentRunning(state) || !healthy {
+		return 1
+	}
+	return 0
+}
+
+// printNodeStatus prints a logical node's registration and heartbeat, reporting whether the
+// last heartbeat succeeded
+func printNodeStatus(state *LocalState) bool {
+	heartbeat := "ok"
+	if !state.LastHeartbeatOK {
+		heartbeat = "failed: " + state.LastHeartbeatError
+	}
+
+	fmt.Printf("Node:             %s (%s)\n", state.NodeName, state.NodeID)
+	fmt.Printf("Registered:       %s\n", formatTime(state.RegisteredAt))
+	fmt.Printf("Last heartbeat:   %s\n", formatTime(state.LastHeartbeatAt))
+	if !state.LastHeartbeatAt.IsZero() {
+		fmt.Printf("Heartbeat result: %s\n", heartbeat)
+	}
+	return state.LastHeartbeatOK
+}
+
+// checkResult is the outcome of one diagnostic check
+type checkResult struct {
+	name    string
+	ok      bool
+	warning bool
+	detail  string
+}
+
+func cmdCheck() int {
+	config, err := loadCommandConfig()
+	if err != nil {
+		fmt.Printf("[FAIL] config: %v\n", err)
+		return 1
+	}
+	fmt.Printf("[ OK ] config: orchestrator %s\n", config.OrchestratorURL)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	agent, err := NewEdgeAgent(config, logger)
+	if err != nil {
+		fmt.Printf("[FAIL] agent: %v\n", err)
+		return 1
+	}
+
+	results := []checkResult{}
+	connectivity, serverDate := agent.checkConnectivity()
+	results = append(results, connectivity)
+	results = append(results, agent.checkTLS()...)
+	results = append(results, checkClock(serverDate))
+	results = append(results, agent.checkKubernetes())
+
+	failed := false
+	for _, result := range results {
+		label := "[ OK ]"
+		switch {
+		case !result.ok:
+			label = "[FAIL]"
+			failed = true
+		case result.warning:
+			label = "[WARN]"
+		}
+		fmt.Printf("%s %s: %s\n", label, result.name, result.detail)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkConnectivity calls the orchestrator health endpoint and returns the server's clock
+func (ea *EdgeAgent) checkConnectivity() (checkResult, time.Time) {
+	result := checkResult{name: "connectivity"}
+
+	start := time.Now()
+	resp, err := ea.httpClient.Get(ea.config.OrchestratorURL + "/health")
+	if err != nil {
+		result.detail = err.Error()
+		return result, time.Time{}
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		result.detail = fmt.Sprintf("health check returned status %d", resp.StatusCode)
+		return result, time.Time{}
+	}
+
+	result.ok = true
+	result.detail = fmt.Sprintf("orchestrator healthy, round trip %s", latency.Round(time.Millisecond))
+
+	serverDate, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return result, time.Time{}
+	}
+	// The Date header is taken mid-request
+	return result, serverDate.Add(latency / 2)
+}
+
+// checkTLS performs a handshake with the orchestrator and reports certificate expiry
+func (ea *EdgeAgent) checkTLS() []checkResult {
+	result := checkResult{name: "tls"}
+
+	orchestratorURL, err := url.Parse(ea.config.OrchestratorURL)
+	if err != nil {
+		result.detail = fmt.Sprintf("invalid orchestrator URL: %v", err)
+		return []checkResult{result}
+	}
+	if orchestratorURL.Scheme != "https" {
+		result.ok = true
+		result.warning = true
+		result.detail = "orchestrator URL is not https, traffic is unencrypted"
+		return []checkResult{result}
+	}
+
+	host := orchestratorURL.Host
+	if orchestratorURL.Port() == "" {
+		host = net.JoinHostPort(orchestratorURL.Hostname(), "443")
+	}
+
+	tlsConfig := ea.httpClient.Transport.(*http.Transport).TLSClientConfig.Clone()
+	dialer := &net.Dialer{Timeout: DefaultTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	if err != nil {
+		result.detail = fmt.Sprintf("handshake failed: %v", err)
+		return []checkResult{result}
+	}
+	defer conn.Close()
+
+	connState := conn.ConnectionState()
+	result.ok = true
+	result.detail = fmt.Sprintf("handshake ok, %s", tls.VersionName(connState.Version))
+
+	results := []checkResult{result}
+	if len(connState.PeerCertificates) > 0 {
+		results = append(results, certificateExpiry("server certificate", connState.PeerCertificates[0]))
+	}
+	if len(tlsConfig.Certificates) > 0 {
+		if cert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0]); err == nil {
+			results = append(results, certificateExpiry("client certificate", cert))
+		}
+	}
+	return results
+}
+
+func certificateExpiry(name string, cert *x509.Certificate) checkResult {
+	result := checkResult{name: name}
+	remaining := time.Until(cert.NotAfter)
+
+	switch {
+	case remaining <= 0:
+		result.detail = fmt.Sprintf("%s expired on %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	case time.Now().Before(cert.NotBefore):
+		result.detail = fmt.Sprintf("%s is not valid until %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+	default:
+		result.ok = true
+		result.warning = remaining < CertificateExpiryWarning
+		result.detail = fmt.Sprintf("%s expires in %d days", cert.Subject.CommonName, int(remaining.Hours()/24))
+	}
+	return result
+}
+
+// checkClock compares the local clock with the orchestrator's
+func checkClock(serverDate time.Time) checkResult {
+	result := checkResult{name: "clock"}
+	if serverDate.IsZero() {
+		result.ok = true
+		result.warning = true
+		result.detail = "could not read orchestrator time"
+		return result
+	}
+
+	skew := time.Since(serverDate)
+	if skew < 0 {
+		skew = -skew
+	}
+	// The Date header only has second resolution
+	result.ok = skew <= MaxClockSkew+time.Second
+	result.detail = fmt.Sprintf("local clock differs from orchestrator by %s", skew.Round(time.Second))
+	return result
+}
+
+// checkKubernetes verifies the local Kubernetes API is reachable
+func (ea *EdgeAgent) checkKubernetes() checkResult {
+	result := checkResult{name: "kubernetes api"}
+	if ea.kubeClient == nil {
+		result.detail = "no Kubernetes client configured (set kubeconfig_path or run in-cluster)"
+		return result
+	}
+
+	version, err := ea.kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		result.detail = err.Error()
+		return result
+	}
+
+	result.ok = true
+	result.detail = fmt.Sprintf("reachable, server version %s", version.String())
+	return result
+}
+
+func cmdResync() int {
+	config, err := loadCommandConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	state, err := readLocalState(config.StateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No local state (has the agent run on this host?): %v\n", err)
+		return 1
+	}
+	if !agentRunning(state) {
+		fmt.Fprintln(os.Stderr, "Agent is not running")
+		return 1
+	}
+
+	previous := state.LastResyncAt
+	if err := syscall.Kill(state.PID, syscall.SIGHUP); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to signal agent: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Resync requested from agent (pid %d)\n", state.PID)
+
+	deadline := time.Now().Add(ResyncTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+
+		state, err = readLocalState(config.StateFile)
+		if err != nil || !state.LastResyncAt.After(previous) {
+			continue
+		}
+		if state.LastResyncError != "" {
+			fmt.Printf("Resync failed: %s\n", state.LastResyncError)
+			return 1
+		}
+		fmt.Println("Resync completed")
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, "Timed out waiting for the agent to resync")
+	return 1
+}
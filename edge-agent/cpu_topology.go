@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultKubeletConfigPath = "/var/lib/kubelet/config.yaml"
+
+	// Where the kernel lists each NUMA node's CPUs
+	numaNodeGlob = "/sys/devices/system/node/node[0-9]*/cpulist"
+
+	// How often the CPU topology is detected again
+	CPUTopologyInterval = 5 * time.Minute
+)
+
+// CPUTopology is the node's CPU layout and the kubelet policies that decide whether
+// workloads asking for exclusive CPUs are pinned
+type CPUTopology struct {
+	CPUs                  int    `json:"cpus"`
+	NUMANodes             int    `json:"numa_nodes"`
+	CPUsPerNUMANode       int    `json:"cpus_per_numa_node"`
+	ReservedCPUs          int    `json:"reserved_cpus"`
+	CPUManagerPolicy      string `json:"cpu_manager_policy"`
+	TopologyManagerPolicy string `json:"topology_manager_policy"`
+}
+
+// kubeletCPUConfig is the part of a KubeletConfiguration that governs CPU pinning
+type kubeletCPUConfig struct {
+	CPUManagerPolicy      string `yaml:"cpuManagerPolicy"`
+	TopologyManagerPolicy string `yaml:"topologyManagerPolicy"`
+	ReservedSystemCPUs    string `yaml:"reservedSystemCPUs"`
+}
+
+// cpuTopology returns the node's CPU topology, detecting it again when the last detection
+// is due for a refresh
+func (ea *EdgeAgent) cpuTopology() *CPUTopology {
+	ea.topologyMutex.Lock()
+	defer ea.topologyMutex.Unlock()
+
+	if time.Now().Before(ea.topologyDetectAt) {
+		return ea.topology
+	}
+	ea.topologyDetectAt = time.Now().Add(CPUTopologyInterval)
+
+	topology, err := detectCPUTopology(ea.config)
+	if err != nil {
+		ea.logger.Warnf("Failed to detect CPU topology: %v", err)
+		return ea.topology
+	}
+	ea.topology = topology
+	return topology
+}
+
+// detectCPUTopology reads the CPU layout from the kernel and the CPU and topology manager
+// policies from the kubelet configuration, which the agent configuration overrides for
+// kubelets configured with flags
+func detectCPUTopology(config *Config) (*CPUTopology, error) {
+	cpus, err := cpu.Counts(true)
+	if err != nil {
+		return nil, err
+	}
+	topology := &CPUTopology{CPUs: cpus, NUMANodes: 1, CPUsPerNUMANode: cpus}
+
+	if paths, _ := filepath.Glob(numaNodeGlob); len(paths) > 0 {
+		topology.NUMANodes = len(paths)
+		for i, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			count, err := countCPUList(string(data))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if i == 0 || count < topology.CPUsPerNUMANode {
+				topology.CPUsPerNUMANode = count
+			}
+		}
+	}
+
+	var kubelet kubeletCPUConfig
+	if data, err := os.ReadFile(config.KubeletConfigPath); err == nil {
+		if err := yaml.Unmarshal(data, &kubelet); err != nil {
+			return nil, fmt.Errorf("failed to parse kubelet config %s: %v", config.KubeletConfigPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if config.CPUManagerPolicy != "" {
+		kubelet.CPUManagerPolicy = config.CPUManagerPolicy
+	}
+	if config.TopologyManagerPolicy != "" {
+		kubelet.TopologyManagerPolicy = config.TopologyManagerPolicy
+	}
+
+	// The kubelet's defaults
+	topology.CPUManagerPolicy, topology.TopologyManagerPolicy = "none", "none"
+	if kubelet.CPUManagerPolicy != "" {
+		topology.CPUManagerPolicy = kubelet.CPUManagerPolicy
+	}
+	if kubelet.TopologyManagerPolicy != "" {
+		topology.TopologyManagerPolicy = kubelet.TopologyManagerPolicy
+	}
+	if kubelet.ReservedSystemCPUs != "" {
+		reserved, err := countCPUList(kubelet.ReservedSystemCPUs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reservedSystemCPUs: %v", err)
+		}
+		topology.ReservedCPUs = reserved
+	}
+	return topology, nil
+}
+
+// countCPUList counts the CPUs of a Linux CPU list such as "0-3,8,10-11"
+func countCPUList(list string) (int, error) {
+	count := 0
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu list %q", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return 0, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		count += last - first + 1
+	}
+	return count, nil
+}
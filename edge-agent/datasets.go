@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often local datasets are re-scanned; sizing a large dataset walks every file
+const DatasetReportInterval = 5 * time.Minute
+
+// DatasetConfig is a dataset stored on this node
+type DatasetConfig struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Path    string `yaml:"path"`
+}
+
+type LocalDataset struct {
+	Name       string     `json:"name"`
+	Version    string     `json:"version"`
+	Path       string     `json:"path,omitempty"`
+	SizeBytes  int64      `json:"size_bytes"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+}
+
+type DatasetReport struct {
+	Datasets []LocalDataset `json:"datasets"`
+}
+
+// startDatasetReporting advertises the datasets present on this host so workloads that
+// need them are placed here
+func (ea *EdgeAgent) startDatasetReporting() {
+	ticker := time.NewTicker(DatasetReportInterval)
+	defer ticker.Stop()
+
+	if err := ea.reportDatasets(); err != nil {
+		ea.logger.Errorf("Failed to report datasets: %v", err)
+	}
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.reportDatasets(); err != nil {
+				ea.logger.Errorf("Failed to report datasets: %v", err)
+			}
+		}
+	}
+}
+
+// reportDatasets sends every configured dataset whose path exists. Missing datasets are
+// left out, so a wiped disk stops attracting workloads.
+func (ea *EdgeAgent) reportDatasets() error {
+	datasets := make([]LocalDataset, 0, len(ea.config.Datasets))
+	for _, config := range ea.config.Datasets {
+		dataset, err := scanDataset(config)
+		if err != nil {
+			ea.logger.Warnf("Dataset %s is not available: %v", config.Name, err)
+			continue
+		}
+		datasets = append(datasets, dataset)
+	}
+
+	path := fmt.Sprintf("/api/v1/nodes/%s/datasets", ea.nodeID)
+	return ea.doRequest("PUT", path, DatasetReport{Datasets: datasets}, nil)
+}
+
+// scanDataset measures a dataset's size and when any of its files last changed
+func scanDataset(config DatasetConfig) (LocalDataset, error) {
+	dataset := LocalDataset{Name: config.Name, Version: config.Version, Path: config.Path}
+
+	var modified time.Time
+	err := filepath.WalkDir(config.Path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			dataset.SizeBytes += info.Size()
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return dataset, err
+	}
+	if !modified.IsZero() {
+		dataset.ModifiedAt = &modified
+	}
+	return dataset, nil
+}
+
+// parseDatasetList parses "name[@version]=path" entries separated by commas, as given in DATASETS
+func parseDatasetList(value string) []DatasetConfig {
+	var datasets []DatasetConfig
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		name, version, _ := strings.Cut(parts[0], "@")
+		datasets = append(datasets, DatasetConfig{Name: name, Version: version, Path: parts[1]})
+	}
+	return datasets
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PatchOperation is a JSON Patch (RFC 6902) operation as sent by the orchestrator
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// DesiredStateResponse is either a full desired-state document or a patch against BaseHash
+type DesiredStateResponse struct {
+	Hash      string                 `json:"hash"`
+	BaseHash  string                 `json:"base_hash,omitempty"`
+	Unchanged bool                   `json:"unchanged,omitempty"`
+	Patch     []PatchOperation       `json:"patch,omitempty"`
+	Document  map[string]interface{} `json:"document,omitempty"`
+}
+
+// desiredState is the last desired-state document the agent synced and its hash
+type desiredState struct {
+	hash     string
+	document map[string]interface{}
+}
+
+// syncDesiredState brings the local desired-state document up to date, applying a patch
+// when the orchestrator sends one and falling back to a full resync when the patched
+// document does not match the orchestrator's hash
+func (ea *EdgeAgent) syncDesiredState() (map[string]interface{}, error) {
+	ea.desiredMutex.Lock()
+	defer ea.desiredMutex.Unlock()
+
+	// A multiplexed heartbeat already reported the orchestrator's hash; nothing to fetch
+	// when it matches
+	hint := ea.desiredHint
+	ea.desiredHint = ""
+	if hint != "" && hint == ea.desired.hash {
+		return ea.desired.document, nil
+	}
+
+	if ea.desired.hash != "" {
+		resp, err := ea.fetchDesiredState(ea.desired.hash)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case resp.Unchanged && resp.Hash == ea.desired.hash:
+			return ea.desired.document, nil
+		case resp.Document != nil:
+			return ea.acceptDesiredState(resp)
+		case resp.BaseHash == ea.desired.hash:
+			document, err := applyPatch(ea.desired.document, resp.Patch)
+			if err == nil {
+				var hash string
+				if hash, err = hashDocument(document); err == nil && hash == resp.Hash {
+					ea.desired = desiredState{hash: hash, document: document}
+					return document, nil
+				}
+				if err == nil {
+					err = fmt.Errorf("hash mismatch: got %s, expected %s", hash, resp.Hash)
+				}
+			}
+			ea.logger.Warnf("Failed to apply desired-state patch, requesting full resync: %v", err)
+		default:
+			ea.logger.Warnf("Unexpected desired-state response for base %s, requesting full resync", ea.desired.hash)
+		}
+	}
+
+	resp, err := ea.fetchDesiredState("")
+	if err != nil {
+		return nil, err
+	}
+	return ea.acceptDesiredState(resp)
+}
+
+// fetchDesiredState requests the node's desired state, as a patch from since when set
+func (ea *EdgeAgent) fetchDesiredState(since string) (*DesiredStateResponse, error) {
+	if ea.grpc != nil {
+		return ea.fetchDesiredStateGRPC(since)
+	}
+
+	path := fmt.Sprintf("/api/v1/nodes/%s/desired-state", ea.nodeID)
+	if since != "" {
+		path += "?since=" + url.QueryEscape(since)
+	}
+
+	var resp DesiredStateResponse
+	if err := ea.doRequest("GET", path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// acceptDesiredState stores a full document after verifying it against its hash
+func (ea *EdgeAgent) acceptDesiredState(resp *DesiredStateResponse) (map[string]interface{}, error) {
+	if resp.Document == nil {
+		return nil, fmt.Errorf("desired-state response has no document")
+	}
+	hash, err := hashDocument(resp.Document)
+	if err != nil {
+		return nil, err
+	}
+	if hash != resp.Hash {
+		return nil, fmt.Errorf("desired-state hash mismatch: got %s, expected %s", hash, resp.Hash)
+	}
+
+	ea.desired = desiredState{hash: hash, document: resp.Document}
+	return resp.Document, nil
+}
+
+// hashDocument returns the SHA-256 of the document's canonical JSON (map keys sorted),
+// matching the orchestrator's hashing
+func hashDocument(document map[string]interface{}) (string, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal desired state: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// applyPatch applies add, remove and replace operations to a copy of the document
+func applyPatch(document map[string]interface{}, ops []PatchOperation) (map[string]interface{}, error) {
+	patched, ok := copyValue(document).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("desired state is not an object")
+	}
+
+	for _, op := range ops {
+		if op.Path == "" || !strings.HasPrefix(op.Path, "/") {
+			return nil, fmt.Errorf("unsupported patch path %q", op.Path)
+		}
+		tokens := strings.Split(op.Path[1:], "/")
+		for i, token := range tokens {
+			tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		}
+
+		parent := patched
+		for _, token := range tokens[:len(tokens)-1] {
+			child, ok := parent[token].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("patch path %q does not exist", op.Path)
+			}
+			parent = child
+		}
+		key := tokens[len(tokens)-1]
+
+		switch op.Op {
+		case "add":
+			parent[key] = op.Value
+		case "replace":
+			if _, exists := parent[key]; !exists {
+				return nil, fmt.Errorf("patch path %q does not exist", op.Path)
+			}
+			parent[key] = op.Value
+		case "remove":
+			if _, exists := parent[key]; !exists {
+				return nil, fmt.Errorf("patch path %q does not exist", op.Path)
+			}
+			delete(parent, key)
+		default:
+			return nil, fmt.Errorf("unsupported patch operation %q", op.Op)
+		}
+	}
+
+	return patched, nil
+}
+
+// copyValue deep-copies decoded JSON objects so patches never touch the stored document
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			copied[key] = copyValue(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, child := range v {
+			copied[i] = copyValue(child)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// desiredWorkloads decodes the workloads in a desired-state document, ordered by ID
+func desiredWorkloads(document map[string]interface{}) ([]AssignedWorkload, error) {
+	data, err := json.Marshal(document["workloads"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal desired workloads: %v", err)
+	}
+	var byID map[string]AssignedWorkload
+	if err := json.Unmarshal(data, &byID); err != nil {
+		return nil, fmt.Errorf("failed to decode desired workloads: %v", err)
+	}
+
+	workloads := make([]AssignedWorkload, 0, len(byID))
+	for _, workload := range byID {
+		workloads = append(workloads, workload)
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		return workloads[i].ID < workloads[j].ID
+	})
+	return workloads, nil
+}
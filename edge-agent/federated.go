@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Host directory federated training rounds exchange models through
+	DefaultFederatedDataDir = "/var/lib/edge-agent/federated"
+
+	// Labels tying training jobs to the federated job, round and node they run
+	FederatedJobLabel   = "edge.io/federated-job"
+	FederatedRoundLabel = "edge.io/federated-round"
+	FederatedNodeLabel  = "edge.io/federated-node"
+
+	// Where a round's directory is mounted in the training container
+	federatedMountPath = "/fl"
+)
+
+type ResourceList struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+type WorkloadResources struct {
+	Requests ResourceList `json:"requests"`
+	Limits   ResourceList `json:"limits"`
+	GPU      *GPURequest  `json:"gpu,omitempty"`
+}
+
+type ModelArtifact struct {
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+// FederatedTask is this node's part in the current round of a federated learning job
+type FederatedTask struct {
+	JobID       string            `json:"job_id"`
+	JobName     string            `json:"job_name"`
+	Round       int               `json:"round"`
+	Namespace   string            `json:"namespace"`
+	Image       string            `json:"image"`
+	Command     []string          `json:"command,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Resources   WorkloadResources `json:"resources"`
+	Accelerator string            `json:"accelerator"`
+	Deadline    time.Time         `json:"deadline"`
+	GlobalModel *ModelArtifact    `json:"global_model,omitempty"`
+	Status      string            `json:"status"`
+}
+
+type FederatedTasksResponse struct {
+	Tasks []FederatedTask `json:"tasks"`
+}
+
+type FederatedTaskStatusRequest struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// startFederatedTasks trains federated learning rounds assigned to this node. Training runs
+// as a Job pinned to this host, exchanging models with the agent through a host directory,
+// so it is only available to a single-cluster agent running on the node itself.
+func (ea *EdgeAgent) startFederatedTasks() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, federated learning disabled")
+		return
+	}
+	if ea.cluster != nil {
+		ea.logger.Infof("Federated learning is not available for cluster %s", ea.config.NodeName)
+		return
+	}
+
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ea.logger.Info("Starting federated task processing")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.processFederatedTasks(); err != nil {
+				ea.logger.Errorf("Failed to process federated tasks: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) processFederatedTasks() error {
+	var resp FederatedTasksResponse
+	path := fmt.Sprintf("/api/v1/nodes/%s/federated-tasks", ea.nodeID)
+	if err := ea.doRequest("GET", path, nil, &resp); err != nil {
+		return fmt.Errorf("failed to fetch federated tasks: %v", err)
+	}
+
+	for _, task := range resp.Tasks {
+		report, err := ea.runFederatedTask(ea.registrationCtx, task)
+		if err != nil {
+			ea.logger.Errorf("Federated job %s round %d failed: %v", task.JobName, task.Round, err)
+			report = &FederatedTaskStatusRequest{Status: "failed", Error: err.Error()}
+			ea.cleanupFederatedRound(ea.registrationCtx, task)
+		}
+		if report == nil || report.Status == task.Status {
+			continue
+		}
+
+		statusPath := fmt.Sprintf("/api/v1/nodes/%s/federated-tasks/%s/rounds/%d/status", ea.nodeID, task.JobID, task.Round)
+		if err := ea.doRequest("POST", statusPath, report, nil); err != nil {
+			ea.logger.Errorf("Failed to report federated job %s round %d: %v", task.JobName, task.Round, err)
+		}
+	}
+
+	ea.pruneFederatedRounds(ea.registrationCtx, resp.Tasks)
+	return nil
+}
+
+// federatedJobName is the training Job for one round of a federated job
+func federatedJobName(task FederatedTask) string {
+	return fmt.Sprintf("fl-%s-r%d", shortID(task.JobID), task.Round)
+}
+
+// federatedRoundDir is the host directory a round's models are exchanged through
+func (ea *EdgeAgent) federatedRoundDir(task FederatedTask) string {
+	return filepath.Join(ea.config.FederatedDataDir, task.JobID, fmt.Sprintf("round-%d", task.Round))
+}
+
+// runFederatedTask drives the training Job for a round and returns the status to report.
+// Once the Job succeeds its update is uploaded, which completes the node's round.
+func (ea *EdgeAgent) runFederatedTask(ctx context.Context, task FederatedTask) (*FederatedTaskStatusRequest, error) {
+	dir := ea.federatedRoundDir(task)
+	jobs := ea.kubeClient.BatchV1().Jobs(task.Namespace)
+
+	existing, err := jobs.Get(ctx, federatedJobName(task), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create round directory: %v", err)
+		}
+		if task.GlobalModel != nil {
+			if err := ea.downloadGlobalModel(ctx, task, filepath.Join(dir, "global.model")); err != nil {
+				return nil, err
+			}
+		}
+
+		job, err := buildFederatedJob(task, ea.nodeID, ea.config.NodeName, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		ea.logger.Infof("Starting federated job %s round %d as %s/%s", task.JobName, task.Round, job.Namespace, job.Name)
+		if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create job %s: %v", job.Name, err)
+		}
+		return &FederatedTaskStatusRequest{Status: "training"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job %s: %v", federatedJobName(task), err)
+	}
+
+	switch {
+	case existing.Status.Succeeded > 0:
+		if err := ea.uploadModelUpdate(ctx, task, dir); err != nil {
+			// The update stays on disk, so the upload is retried until the round closes
+			ea.logger.Warnf("Federated job %s round %d: %v", task.JobName, task.Round, err)
+			return nil, nil
+		}
+		ea.logger.Infof("Submitted update for federated job %s round %d", task.JobName, task.Round)
+		ea.cleanupFederatedRound(ctx, task)
+		return nil, nil
+	case existing.Status.Failed > 0:
+		return nil, fmt.Errorf("job %s failed", existing.Name)
+	}
+	return &FederatedTaskStatusRequest{Status: "training"}, nil
+}
+
+// cleanupFederatedRound removes a round's training Job and model files
+func (ea *EdgeAgent) cleanupFederatedRound(ctx context.Context, task FederatedTask) {
+	propagation := metav1.DeletePropagationBackground
+	err := ea.kubeClient.BatchV1().Jobs(task.Namespace).Delete(ctx, federatedJobName(task), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		ea.logger.Warnf("Failed to delete federated job %s: %v", federatedJobName(task), err)
+	}
+	if err := os.RemoveAll(ea.federatedRoundDir(task)); err != nil {
+		ea.logger.Warnf("Failed to remove federated round directory: %v", err)
+	}
+}
+
+// pruneFederatedRounds stops training for rounds the orchestrator no longer assigns this
+// node, because the round closed or the job was cancelled
+func (ea *EdgeAgent) pruneFederatedRounds(ctx context.Context, tasks []FederatedTask) {
+	activeRounds := make(map[string]bool)
+	activeJobs := make(map[string]bool)
+	for _, task := range tasks {
+		activeRounds[federatedJobName(task)] = true
+		activeJobs[task.JobID] = true
+	}
+
+	jobs, err := ea.kubeClient.BatchV1().Jobs("").List(ctx, metav1.ListOptions{LabelSelector: FederatedNodeLabel + "=" + ea.nodeID})
+	if err != nil {
+		ea.logger.Warnf("Failed to list federated training jobs: %v", err)
+		return
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs.Items {
+		if activeRounds[job.Name] {
+			continue
+		}
+		ea.logger.Infof("Stopping federated training job %s/%s", job.Namespace, job.Name)
+		err := ea.kubeClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !errors.IsNotFound(err) {
+			ea.logger.Warnf("Failed to delete federated job %s: %v", job.Name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(ea.config.FederatedDataDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !activeJobs[entry.Name()] {
+			os.RemoveAll(filepath.Join(ea.config.FederatedDataDir, entry.Name()))
+		}
+	}
+}
+
+// artifactRequest sends a model transfer to the orchestrator. Models can be large, so
+// transfers are bounded by the round deadline rather than the usual request timeout.
+func (ea *EdgeAgent) artifactRequest(ctx context.Context, method, path string, body io.Reader, header map[string]string) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, method, ea.config.OrchestratorURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+ea.config.AuthToken)
+	for key, value := range header {
+		httpReq.Header.Set(key, value)
+	}
+
+	client := &http.Client{Transport: ea.httpClient.Transport}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	return resp, nil
+}
+
+// downloadGlobalModel fetches the model a round starts from and checks its digest
+func (ea *EdgeAgent) downloadGlobalModel(ctx context.Context, task FederatedTask, dest string) error {
+	ctx, cancel := context.WithDeadline(ctx, task.Deadline)
+	defer cancel()
+
+	path := fmt.Sprintf("/api/v1/nodes/%s/federated-tasks/%s/rounds/%d/model", ea.nodeID, task.JobID, task.Round)
+	resp, err := ea.artifactRequest(ctx, "GET", path, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download global model: %v", err)
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create global model file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return fmt.Errorf("failed to download global model: %v", err)
+	}
+	if digest := hex.EncodeToString(hash.Sum(nil)); digest != task.GlobalModel.SHA256 {
+		return fmt.Errorf("global model digest %s does not match %s", digest, task.GlobalModel.SHA256)
+	}
+	return nil
+}
+
+// uploadModelUpdate sends the update a training Job left in the round directory, with
+// the sample count it reported if any
+func (ea *EdgeAgent) uploadModelUpdate(ctx context.Context, task FederatedTask, dir string) error {
+	ctx, cancel := context.WithDeadline(ctx, task.Deadline)
+	defer cancel()
+
+	file, err := os.Open(filepath.Join(dir, "update.model"))
+	if err != nil {
+		return fmt.Errorf("training finished without a model update: %v", err)
+	}
+	defer file.Close()
+
+	header := map[string]string{"Content-Type": "application/octet-stream"}
+	if data, err := os.ReadFile(filepath.Join(dir, "samples")); err == nil {
+		if samples, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			header["X-Sample-Count"] = strconv.FormatInt(samples, 10)
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/nodes/%s/federated-tasks/%s/rounds/%d/update", ea.nodeID, task.JobID, task.Round)
+	resp, err := ea.artifactRequest(ctx, "PUT", path, file, header)
+	if err != nil {
+		return fmt.Errorf("failed to upload model update: %v", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// buildFederatedJob creates the training Job for a round. The round directory is mounted
+// at /fl; the container finds its inputs and writes its outputs through FL_* variables.
+func buildFederatedJob(task FederatedTask, nodeID, nodeName, dir string) (*batchv1.Job, error) {
+	requirements, err := federatedResources(task)
+	if err != nil {
+		return nil, err
+	}
+
+	globalModel := ""
+	if task.GlobalModel != nil {
+		globalModel = federatedMountPath + "/global.model"
+	}
+	env := []corev1.EnvVar{
+		{Name: "FL_JOB_ID", Value: task.JobID},
+		{Name: "FL_ROUND", Value: strconv.Itoa(task.Round)},
+		{Name: "FL_GLOBAL_MODEL", Value: globalModel},
+		{Name: "FL_UPDATE_PATH", Value: federatedMountPath + "/update.model"},
+		{Name: "FL_SAMPLES_PATH", Value: federatedMountPath + "/samples"},
+	}
+	for name, value := range task.Environment {
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	backoffLimit := int32(1)
+	deadline := int64(time.Until(task.Deadline).Seconds())
+	if deadline < 1 {
+		return nil, fmt.Errorf("round deadline has passed")
+	}
+	hostPathType := corev1.HostPathDirectory
+	labels := map[string]string{
+		ManagedByLabel:      ManagedByValue,
+		FederatedJobLabel:   task.JobID,
+		FederatedRoundLabel: strconv.Itoa(task.Round),
+		FederatedNodeLabel:  nodeID,
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      federatedJobName(task),
+			Namespace: task.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					// Training data stays on this node, so the pod must too
+					NodeSelector: map[string]string{"kubernetes.io/hostname": nodeName},
+					Containers: []corev1.Container{
+						{
+							Name:         "train",
+							Image:        task.Image,
+							Command:      task.Command,
+							Env:          env,
+							Resources:    requirements,
+							VolumeMounts: []corev1.VolumeMount{{Name: "round", MountPath: federatedMountPath}},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "round",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{Path: dir, Type: &hostPathType},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// federatedResources converts a task's resources, adding a GPU for GPU training
+func federatedResources(task FederatedTask) (corev1.ResourceRequirements, error) {
+	requirements, err := resourceRequirements(task.Resources)
+	if err != nil {
+		return requirements, err
+	}
+
+	if task.Accelerator == "gpu" {
+		requirements.Limits[GPUResourceName] = resource.MustParse("1")
+	}
+	return requirements, nil
+}
+
+// resourceRequirements converts workload resources to container requirements
+func resourceRequirements(resources WorkloadResources) (corev1.ResourceRequirements, error) {
+	requirements := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	quantities := []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{requirements.Requests, corev1.ResourceCPU, resources.Requests.CPU},
+		{requirements.Requests, corev1.ResourceMemory, resources.Requests.Memory},
+		{requirements.Limits, corev1.ResourceCPU, resources.Limits.CPU},
+		{requirements.Limits, corev1.ResourceMemory, resources.Limits.Memory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return requirements, fmt.Errorf("invalid %s quantity %q: %v", q.name, q.value, err)
+		}
+		q.list[q.name] = quantity
+	}
+	gpuRequirements(requirements, resources.GPU)
+	return requirements, nil
+}
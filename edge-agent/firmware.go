@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Interval between hardware inventory reports
+	HardwareReportInterval = 6 * time.Hour
+
+	// Where the kernel exposes SMBIOS system and BIOS information
+	DMIPath = "/sys/class/dmi/id"
+)
+
+// HardwareInventory is the hardware and firmware found on this host
+type HardwareInventory struct {
+	SystemVendor string            `json:"system_vendor,omitempty"`
+	ProductName  string            `json:"product_name,omitempty"`
+	BIOSVendor   string            `json:"bios_vendor,omitempty"`
+	BIOSVersion  string            `json:"bios_version,omitempty"`
+	BIOSDate     string            `json:"bios_date,omitempty"`
+	BMCVersion   string            `json:"bmc_version,omitempty"`
+	Firmware     map[string]string `json:"firmware,omitempty"`
+	CollectedAt  time.Time         `json:"collected_at"`
+}
+
+func (ea *EdgeAgent) startHardwareInventory() {
+	ticker := time.NewTicker(HardwareReportInterval)
+	defer ticker.Stop()
+
+	if err := ea.reportHardware(); err != nil {
+		ea.logger.Errorf("Failed to report hardware inventory: %v", err)
+	}
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.reportHardware(); err != nil {
+				ea.logger.Errorf("Failed to report hardware inventory: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) reportHardware() error {
+	inventory := collectHardwareInventory(ea.registrationCtx)
+	path := fmt.Sprintf("/api/v1/nodes/%s/hardware", ea.nodeID)
+	return ea.doRequest("POST", path, inventory, nil)
+}
+
+// collectHardwareInventory reads SMBIOS data from sysfs, the BMC firmware revision from
+// ipmitool and device firmware from fwupd; sources that are unavailable are skipped
+func collectHardwareInventory(ctx context.Context) HardwareInventory {
+	return HardwareInventory{
+		SystemVendor: readDMI("sys_vendor"),
+		ProductName:  readDMI("product_name"),
+		BIOSVendor:   readDMI("bios_vendor"),
+		BIOSVersion:  readDMI("bios_version"),
+		BIOSDate:     readDMI("bios_date"),
+		BMCVersion:   bmcVersion(ctx),
+		Firmware:     deviceFirmware(ctx),
+		CollectedAt:  time.Now(),
+	}
+}
+
+func readDMI(name string) string {
+	data, err := os.ReadFile(filepath.Join(DMIPath, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// runProbe runs an inventory tool if it is installed, bounded by a short timeout
+func runProbe(ctx context.Context, name string, args ...string) ([]byte, bool) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return nil, false
+	}
+	return output, true
+}
+
+func bmcVersion(ctx context.Context) string {
+	output, ok := runProbe(ctx, "ipmitool", "mc", "info")
+	if !ok {
+		return ""
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "Firmware Revision" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+func deviceFirmware(ctx context.Context) map[string]string {
+	output, ok := runProbe(ctx, "fwupdmgr", "get-devices", "--json")
+	if !ok {
+		return nil
+	}
+
+	var devices struct {
+		Devices []struct {
+			Name    string `json:"Name"`
+			Version string `json:"Version"`
+		} `json:"Devices"`
+	}
+	if err := json.Unmarshal(output, &devices); err != nil {
+		return nil
+	}
+
+	firmware := make(map[string]string)
+	for _, device := range devices.Devices {
+		if device.Name != "" && device.Version != "" {
+			firmware[device.Name] = device.Version
+		}
+	}
+	return firmware
+}
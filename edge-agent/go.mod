@@ -9,4 +9,10 @@ require (
 	k8s.io/client-go v0.28.4
 	gopkg.in/yaml.v2 v2.4.0
 	github.com/shirou/gopsutil/v3 v3.23.10
+	github.com/ishaqelkhalifa/kubernetes-edge-framework/api v0.0.0
+	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
+	github.com/gorilla/websocket v1.5.0
 )
+
+replace github.com/ishaqelkhalifa/kubernetes-edge-framework/api => ../api
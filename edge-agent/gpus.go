@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// How the node's NVIDIA device plugin shares GPUs; must match the orchestrator's
+	GPUSharingTimeSlicing = "time-slicing"
+	GPUSharingMPS         = "mps"
+
+	// Resource the device plugin advertises shared GPUs as, with renameByDefault set
+	SharedGPUResourceName corev1.ResourceName = "nvidia.com/gpu.shared"
+	// Prefix of the resources the device plugin's mixed MIG strategy advertises
+	MIGResourcePrefix = "nvidia.com/mig-"
+
+	// How often GPUs are detected again; heartbeats in between report the last result
+	GPUDetectionInterval = 5 * time.Minute
+	GPUDetectionTimeout  = 10 * time.Second
+)
+
+// GPURequest is what each replica of a workload needs of the node's GPUs: whole cards, a
+// share of a card, or an instance of a MIG profile
+type GPURequest struct {
+	Count      int32   `json:"count,omitempty"`
+	Share      float64 `json:"share,omitempty"`
+	MIGProfile string  `json:"mig_profile,omitempty"`
+}
+
+// GPUDevice is a GPU reported to the orchestrator so it can allocate shares and MIG
+// instances of each card
+type GPUDevice struct {
+	Index    int    `json:"index"`
+	UUID     string `json:"uuid,omitempty"`
+	Model    string `json:"model,omitempty"`
+	MemoryMB int64  `json:"memory_mb,omitempty"`
+	Sharing  string `json:"sharing,omitempty"`
+	// Instances of each profile on a card partitioned with MIG
+	MIGInstances map[string]int `json:"mig_instances,omitempty"`
+}
+
+// validateGPUSharing checks the configured GPU sharing mode
+func validateGPUSharing(sharing string) error {
+	switch sharing {
+	case "", GPUSharingTimeSlicing, GPUSharingMPS:
+		return nil
+	}
+	return fmt.Errorf("gpu_sharing must be %s or %s", GPUSharingTimeSlicing, GPUSharingMPS)
+}
+
+// gpuDevices returns the node's GPUs, detecting them again when the last detection is due
+// for a refresh. Nodes without nvidia-smi report none.
+func (ea *EdgeAgent) gpuDevices() []GPUDevice {
+	ea.gpuMutex.Lock()
+	defer ea.gpuMutex.Unlock()
+
+	if time.Now().Before(ea.gpuDetectAt) {
+		return ea.gpus
+	}
+	ea.gpuDetectAt = time.Now().Add(GPUDetectionInterval)
+
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		ea.gpus = nil
+		return nil
+	}
+	devices, err := detectGPUs(ea.config.GPUSharing)
+	if err != nil {
+		// Keep reporting the last detected cards rather than none
+		ea.logger.Warnf("Failed to detect GPUs: %v", err)
+		return ea.gpus
+	}
+	ea.gpus = devices
+	return devices
+}
+
+// detectGPUs lists the cards nvidia-smi reports and the MIG instances on each
+func detectGPUs(sharing string) ([]GPUDevice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), GPUDetectionTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=index,uuid,name,memory.total", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi query failed: %v", err)
+	}
+	devices, err := parseGPUQuery(output, sharing)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := exec.CommandContext(ctx, "nvidia-smi", "-L").Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi -L failed: %v", err)
+	}
+	for index, instances := range parseMIGInstances(listing) {
+		for i := range devices {
+			if devices[i].Index == index {
+				// A MIG-enabled card is only used through its instances
+				devices[i].MIGInstances = instances
+				devices[i].Sharing = ""
+			}
+		}
+	}
+	return devices, nil
+}
+
+// parseGPUQuery reads "index, uuid, name, memory.total" lines
+func parseGPUQuery(output []byte, sharing string) ([]GPUDevice, error) {
+	var devices []GPUDevice
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected nvidia-smi output %q", line)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected GPU index %q", fields[0])
+		}
+		// Memory is unknown on some cards, reported as "[N/A]"
+		memory, _ := strconv.ParseInt(fields[3], 10, 64)
+		devices = append(devices, GPUDevice{
+			Index:    index,
+			UUID:     fields[1],
+			Model:    fields[2],
+			MemoryMB: memory,
+			Sharing:  sharing,
+		})
+	}
+	return devices, scanner.Err()
+}
+
+// parseMIGInstances counts the MIG instances of each profile per card from nvidia-smi -L,
+// which lists them indented under their card:
+//
+//	GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-...)
+//	  MIG 1g.5gb      Device  0: (UUID: MIG-...)
+func parseMIGInstances(output []byte) map[int]map[string]int {
+	instances := make(map[int]map[string]int)
+	card := -1
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "GPU":
+			index, err := strconv.Atoi(strings.TrimSuffix(fields[1], ":"))
+			if err != nil {
+				card = -1
+				continue
+			}
+			card = index
+		case "MIG":
+			if card < 0 {
+				continue
+			}
+			if instances[card] == nil {
+				instances[card] = make(map[string]int)
+			}
+			instances[card][fields[1]]++
+		}
+	}
+	return instances
+}
+
+// gpuRequirements adds a workload's GPU request to its container requirements. Whole cards
+// are nvidia.com/gpu, a share takes one replica of a shared card, and a MIG profile one
+// instance of the profile's resource.
+func gpuRequirements(requirements corev1.ResourceRequirements, gpu *GPURequest) {
+	switch {
+	case gpu == nil:
+	case gpu.Count > 0:
+		requirements.Limits[GPUResourceName] = *resource.NewQuantity(int64(gpu.Count), resource.DecimalSI)
+	case gpu.Share > 0:
+		requirements.Limits[SharedGPUResourceName] = resource.MustParse("1")
+	case gpu.MIGProfile != "":
+		requirements.Limits[corev1.ResourceName(MIGResourcePrefix+gpu.MIGProfile)] = resource.MustParse("1")
+	}
+}
+
+// gpuShareEnv limits a replica sharing a card through MPS to its share of the card's
+// compute; time-sliced cards ignore it
+func gpuShareEnv(gpu *GPURequest) (corev1.EnvVar, bool) {
+	if gpu == nil || gpu.Share <= 0 {
+		return corev1.EnvVar{}, false
+	}
+	percentage := int(gpu.Share*100 + 0.5)
+	if percentage < 1 {
+		percentage = 1
+	}
+	return corev1.EnvVar{Name: "CUDA_MPS_ACTIVE_THREAD_PERCENTAGE", Value: strconv.Itoa(percentage)}, true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	agentv1 "github.com/ishaqelkhalifa/kubernetes-edge-framework/api/agent/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// agentGRPCClient talks to the orchestrator's agent gRPC API. It carries registration,
+// heartbeats and workload sync; the agent's other calls stay on REST.
+type agentGRPCClient struct {
+	conn   *grpc.ClientConn
+	client agentv1.AgentServiceClient
+}
+
+// newAgentGRPCClient connects to address with the agent's TLS configuration, so the
+// orchestrator sees the same client certificate as on REST. The connection is made
+// lazily on the first call.
+func newAgentGRPCClient(address string, tlsConfig *tls.Config) (*agentGRPCClient, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC connection: %v", err)
+	}
+	return &agentGRPCClient{conn: conn, client: agentv1.NewAgentServiceClient(conn)}, nil
+}
+
+// grpcContext bounds a call like the HTTP client timeout and authenticates it with the
+// auth token, read on every call since claiming a device replaces it
+func (ea *EdgeAgent) grpcContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+ea.config.AuthToken), cancel
+}
+
+// registerGRPC registers the node and returns its ID
+func (ea *EdgeAgent) registerGRPC(req RegistrationRequest) (string, error) {
+	ctx, cancel := ea.grpcContext()
+	defer cancel()
+
+	taints := make([]*agentv1.NodeTaint, 0, len(req.Taints))
+	for _, taint := range req.Taints {
+		taints = append(taints, &agentv1.NodeTaint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect})
+	}
+	resp, err := ea.grpc.client.Register(ctx, &agentv1.RegisterRequest{
+		Name:              req.Name,
+		Address:           req.Address,
+		Labels:            req.Labels,
+		Capabilities:      req.Capabilities,
+		Region:            req.Region,
+		Zone:              req.Zone,
+		SiteId:            req.SiteID,
+		KubernetesVersion: req.KubernetesVersion,
+		ContainerRuntime:  req.ContainerRuntime,
+		Taints:            taints,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send registration request: %v", err)
+	}
+	return resp.NodeId, nil
+}
+
+// sendGRPCHeartbeat sends a heartbeat and records the desired-state hash that comes
+// back, so the next sync skips its request when nothing changed
+func (ea *EdgeAgent) sendGRPCHeartbeat(req HeartbeatRequest) error {
+	ctx, cancel := ea.grpcContext()
+	defer cancel()
+
+	resp, err := ea.grpc.client.Heartbeat(ctx, &agentv1.HeartbeatRequest{
+		NodeId:        ea.nodeID,
+		Status:        string(req.Status),
+		Resources:     nodeResourcesToProto(req.Resources),
+		Timestamp:     timestamppb.New(req.Timestamp),
+		Latency:       latencyToProto(req.Latency),
+		LogSummaries:  logSummariesToProto(req.LogSummaries),
+		CustomMetrics: customMetricsToProto(req.CustomMetrics),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %v", err)
+	}
+
+	ea.setDesiredStateHint(resp.DesiredStateHash)
+	return nil
+}
+
+// fetchDesiredStateGRPC is fetchDesiredState over gRPC
+func (ea *EdgeAgent) fetchDesiredStateGRPC(since string) (*DesiredStateResponse, error) {
+	ctx, cancel := ea.grpcContext()
+	defer cancel()
+
+	resp, err := ea.grpc.client.SyncWorkloads(ctx, &agentv1.SyncWorkloadsRequest{NodeId: ea.nodeID, Since: since})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync workloads: %v", err)
+	}
+
+	desired := &DesiredStateResponse{
+		Hash:      resp.Hash,
+		BaseHash:  resp.BaseHash,
+		Unchanged: resp.Unchanged,
+	}
+	for _, op := range resp.Patch {
+		desired.Patch = append(desired.Patch, PatchOperation{Op: op.Op, Path: op.Path, Value: op.Value.AsInterface()})
+	}
+	if resp.Document != nil {
+		desired.Document = resp.Document.AsMap()
+	}
+	return desired, nil
+}
+
+func latencyToProto(measurements []LatencyMeasurement) []*agentv1.LatencyMeasurement {
+	converted := make([]*agentv1.LatencyMeasurement, 0, len(measurements))
+	for _, measurement := range measurements {
+		converted = append(converted, &agentv1.LatencyMeasurement{
+			Target:            measurement.Target,
+			RttMs:             measurement.RTTMillis,
+			MeasuredAt:        timestamppb.New(measurement.MeasuredAt),
+			PacketLossPercent: measurement.PacketLossPercent,
+			JitterMs:          measurement.JitterMillis,
+		})
+	}
+	return converted
+}
+
+func logSummariesToProto(summaries []WorkloadLogSummary) []*agentv1.WorkloadLogSummary {
+	converted := make([]*agentv1.WorkloadLogSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		samples := make([]*agentv1.LogSample, 0, len(summary.Samples))
+		for _, sample := range summary.Samples {
+			samples = append(samples, &agentv1.LogSample{Level: sample.Level, Message: sample.Message, Count: sample.Count})
+		}
+		converted = append(converted, &agentv1.WorkloadLogSummary{
+			WorkloadId:    summary.WorkloadID,
+			WindowSeconds: summary.WindowSeconds,
+			Lines:         summary.Lines,
+			Errors:        summary.Errors,
+			Warnings:      summary.Warnings,
+			Samples:       samples,
+			CollectedAt:   timestamppb.New(summary.CollectedAt),
+		})
+	}
+	return converted
+}
+
+func customMetricsToProto(metrics []CustomMetric) []*agentv1.CustomMetric {
+	converted := make([]*agentv1.CustomMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		converted = append(converted, &agentv1.CustomMetric{
+			Collector:   metric.Collector,
+			Name:        metric.Name,
+			Value:       metric.Value,
+			CollectedAt: timestamppb.New(metric.CollectedAt),
+		})
+	}
+	return converted
+}
+
+func nodeResourcesToProto(resources NodeResources) *agentv1.NodeResources {
+	devices := make([]*agentv1.GPUDevice, 0, len(resources.GPUDevices))
+	for _, device := range resources.GPUDevices {
+		var instances map[string]int32
+		if len(device.MIGInstances) > 0 {
+			instances = make(map[string]int32, len(device.MIGInstances))
+			for profile, count := range device.MIGInstances {
+				instances[profile] = int32(count)
+			}
+		}
+		devices = append(devices, &agentv1.GPUDevice{
+			Index:        int32(device.Index),
+			Uuid:         device.UUID,
+			Model:        device.Model,
+			MemoryMb:     device.MemoryMB,
+			Sharing:      device.Sharing,
+			MigInstances: instances,
+		})
+	}
+	var topology *agentv1.CPUTopology
+	if t := resources.CPUTopology; t != nil {
+		topology = &agentv1.CPUTopology{
+			Cpus:                  int32(t.CPUs),
+			NumaNodes:             int32(t.NUMANodes),
+			CpusPerNumaNode:       int32(t.CPUsPerNUMANode),
+			ReservedCpus:          int32(t.ReservedCPUs),
+			CpuManagerPolicy:      t.CPUManagerPolicy,
+			TopologyManagerPolicy: t.TopologyManagerPolicy,
+		}
+	}
+	var bootTime *timestamppb.Timestamp
+	if resources.BootTime != nil {
+		bootTime = timestamppb.New(*resources.BootTime)
+	}
+	return &agentv1.NodeResources{
+		Cpu: &agentv1.ResourceUsage{
+			Capacity:      resources.CPU.Capacity,
+			Usage:         resources.CPU.Usage,
+			Percentage:    resources.CPU.Percentage,
+			CapacityValue: resources.CPU.CapacityMillicores,
+			UsageValue:    resources.CPU.UsageMillicores,
+		},
+		Memory: &agentv1.ResourceUsage{
+			Capacity:      resources.Memory.Capacity,
+			Usage:         resources.Memory.Usage,
+			Percentage:    resources.Memory.Percentage,
+			CapacityValue: resources.Memory.CapacityBytes,
+			UsageValue:    resources.Memory.UsageBytes,
+		},
+		Storage: &agentv1.ResourceUsage{
+			Capacity:      resources.Storage.Capacity,
+			Usage:         resources.Storage.Usage,
+			Percentage:    resources.Storage.Percentage,
+			CapacityValue: resources.Storage.CapacityBytes,
+			UsageValue:    resources.Storage.UsageBytes,
+		},
+		NetworkBandwidth: resources.NetworkBandwidth,
+		Gpus:             int32(resources.GPUs),
+		GpuDevices:       devices,
+		CpuTopology:      topology,
+		BootTime:         bootTime,
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Where systemd keeps the host's stable machine ID
+const MachineIDPath = "/etc/machine-id"
+
+// resolveNodeIdentity fills in the node name and address on devices provisioned from an
+// image or waiting to be claimed, which share one configuration file. The name is the configured prefix and the
+// host's machine ID; the address is the one the host reaches the orchestrator from.
+func resolveNodeIdentity(config *Config) error {
+	if config.NodeName == "" && config.NodeNamePrefix == "" && config.ClaimMode {
+		config.NodeNamePrefix = "edge"
+	}
+	if config.NodeName == "" && config.NodeNamePrefix != "" {
+		id, err := os.ReadFile(MachineIDPath)
+		suffix := strings.TrimSpace(string(id))
+		if err != nil || suffix == "" {
+			if suffix, err = os.Hostname(); err != nil {
+				return fmt.Errorf("failed to derive node name: %v", err)
+			}
+		}
+		if len(suffix) > 12 {
+			suffix = suffix[:12]
+		}
+		config.NodeName = config.NodeNamePrefix + "-" + suffix
+	}
+
+	if config.NodeAddress == "" && config.OrchestratorURL != "" {
+		orchestratorURL, err := url.Parse(config.OrchestratorURL)
+		if err != nil {
+			return fmt.Errorf("invalid orchestrator URL: %v", err)
+		}
+		port := orchestratorURL.Port()
+		if port == "" {
+			port = "443"
+		}
+		// Connecting a UDP socket sends nothing; it only selects the outbound address
+		conn, err := net.Dial("udp", net.JoinHostPort(orchestratorURL.Hostname(), port))
+		if err != nil {
+			return fmt.Errorf("failed to detect node address: %v", err)
+		}
+		config.NodeAddress = conn.LocalAddr().(*net.UDPAddr).IP.String()
+		conn.Close()
+	}
+	return nil
+}
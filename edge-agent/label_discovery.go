@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// Where cloud-init leaves the metadata of the instance it configured
+	CloudInitInstanceDataPath = "/run/cloud-init/instance-data.json"
+
+	// Prefixes of the labels discovered without rules
+	HardwareLabelPrefix  = "hardware.edge.io/"
+	CloudInitLabelPrefix = "cloud-init.edge.io/"
+)
+
+var labelValueInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// LabelDiscoveryConfig derives node labels from what the host reports about itself, so
+// devices provisioned from one image do not depend on labels typed in by hand
+type LabelDiscoveryConfig struct {
+	// Label the node with its SMBIOS vendor, product and serial number
+	DMI bool `yaml:"dmi"`
+	// Label the node with the platform, region and zone cloud-init configured it for
+	CloudInit bool `yaml:"cloud_init"`
+	// Applied in order; a later rule overrides the labels of an earlier one
+	Rules []LabelRule `yaml:"rules"`
+}
+
+// LabelRule matches a value the host reports and sets labels from the match
+type LabelRule struct {
+	// "hostname", "dmi.<attribute>" such as dmi.product_name, or "cloud_init.<path>" such
+	// as cloud_init.v1.region, a dotted path into cloud-init's instance data
+	Source string `yaml:"source"`
+	// Regular expression the value must match; the whole value when unset
+	Pattern string `yaml:"pattern"`
+	// Label values may refer to the match and its groups as $1 or ${name}; labels whose
+	// value expands to nothing are not set
+	Labels map[string]string `yaml:"labels"`
+
+	pattern *regexp.Regexp
+}
+
+// validateLabelDiscovery checks the configured rules and compiles their patterns
+func validateLabelDiscovery(discovery *LabelDiscoveryConfig) error {
+	if discovery == nil {
+		return nil
+	}
+	for i := range discovery.Rules {
+		rule := &discovery.Rules[i]
+		if rule.Source != "hostname" && !strings.HasPrefix(rule.Source, "dmi.") && !strings.HasPrefix(rule.Source, "cloud_init.") {
+			return fmt.Errorf("label rule %d: unknown source %q", i+1, rule.Source)
+		}
+		pattern := rule.Pattern
+		if pattern == "" {
+			pattern = "^.*$"
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("label rule %d: invalid pattern: %v", i+1, err)
+		}
+		rule.pattern = compiled
+		if len(rule.Labels) == 0 {
+			return fmt.Errorf("label rule %d sets no labels", i+1)
+		}
+		for key := range rule.Labels {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return fmt.Errorf("label rule %d: invalid label %q: %s", i+1, key, strings.Join(errs, "; "))
+			}
+		}
+	}
+	return nil
+}
+
+// discoverLabels adds the discovered labels to the configured ones. Labels set in the
+// configuration win over discovered ones.
+func discoverLabels(config *Config, logger *logrus.Logger) {
+	if config.LabelDiscovery == nil {
+		return
+	}
+	discovered := newLabelSources(logger).labels(config.LabelDiscovery)
+
+	keys := make([]string, 0, len(discovered))
+	for key := range discovered {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := discovered[key]
+		if configured, exists := config.Labels[key]; exists {
+			if configured != value {
+				logger.Warnf("Label %s is configured as %q; ignoring discovered value %q", key, configured, value)
+			}
+			continue
+		}
+		config.Labels[key] = value
+	}
+	logger.Infof("Discovered %d node labels", len(discovered))
+}
+
+// labelSources reads the values labels are discovered from, each source once
+type labelSources struct {
+	logger    *logrus.Logger
+	cloudInit map[string]interface{}
+	loaded    bool
+}
+
+func newLabelSources(logger *logrus.Logger) *labelSources {
+	return &labelSources{logger: logger}
+}
+
+func (s *labelSources) labels(discovery *LabelDiscoveryConfig) map[string]string {
+	labels := make(map[string]string)
+	set := func(key, value string) {
+		if value = labelValue(value); value != "" {
+			labels[key] = value
+		}
+	}
+
+	if discovery.DMI {
+		set(HardwareLabelPrefix+"vendor", readDMI("sys_vendor"))
+		set(HardwareLabelPrefix+"product", readDMI("product_name"))
+		// Readable by root only
+		set(HardwareLabelPrefix+"serial", readDMI("product_serial"))
+	}
+	if discovery.CloudInit {
+		set(CloudInitLabelPrefix+"platform", s.value("cloud_init.v1.platform"))
+		set(CloudInitLabelPrefix+"region", s.value("cloud_init.v1.region"))
+		set(CloudInitLabelPrefix+"zone", s.value("cloud_init.v1.availability_zone"))
+	}
+
+	for _, rule := range discovery.Rules {
+		value := s.value(rule.Source)
+		match := rule.pattern.FindStringSubmatchIndex(value)
+		if value == "" || match == nil {
+			continue
+		}
+		for key, template := range rule.Labels {
+			set(key, string(rule.pattern.ExpandString(nil, template, value, match)))
+		}
+	}
+	return labels
+}
+
+// value returns what a source reports, or "" when it is unavailable
+func (s *labelSources) value(source string) string {
+	switch {
+	case source == "hostname":
+		hostname, _ := os.Hostname()
+		return hostname
+	case strings.HasPrefix(source, "dmi."):
+		return readDMI(strings.TrimPrefix(source, "dmi."))
+	case strings.HasPrefix(source, "cloud_init."):
+		var current interface{} = s.instanceData()
+		for _, key := range strings.Split(strings.TrimPrefix(source, "cloud_init."), ".") {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return ""
+			}
+			current = object[key]
+		}
+		switch value := current.(type) {
+		case string:
+			return value
+		case float64, bool:
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// instanceData reads cloud-init's instance data on first use; hosts not set up by
+// cloud-init have none
+func (s *labelSources) instanceData() map[string]interface{} {
+	if s.loaded {
+		return s.cloudInit
+	}
+	s.loaded = true
+
+	data, err := os.ReadFile(CloudInitInstanceDataPath)
+	if err != nil {
+		s.logger.Debugf("No cloud-init instance data: %v", err)
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.cloudInit); err != nil {
+		s.logger.Warnf("Failed to parse cloud-init instance data: %v", err)
+	}
+	return s.cloudInit
+}
+
+// labelValue turns a reported value into a valid label value: runs of other characters
+// become dashes, and it is cut to 63 characters starting and ending alphanumeric
+func labelValue(value string) string {
+	value = labelValueInvalidChars.ReplaceAllString(strings.TrimSpace(value), "-")
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(value, "-_.")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	// Interval between latency probes
+	LatencyProbeInterval = time.Minute
+
+	// Connections opened per target each probe. The fastest is reported as the round-trip
+	// time, as the others include queueing on the way; the failed ones give the packet loss
+	// and the spread of the rest the jitter.
+	LatencyProbeSamples = 10
+
+	// Target name the orchestrator is reported under
+	LatencyTargetOrchestrator = "orchestrator"
+
+	// A lost SYN is retransmitted after a second, so a connection not set up by then lost
+	// a packet on the way
+	latencyProbeTimeout = time.Second
+)
+
+// LatencyProbeConfig is an additional target whose round-trip time the agent reports,
+// such as a site gateway or a peer data center
+type LatencyProbeConfig struct {
+	Name string `yaml:"name"`
+	// host:port accepting TCP connections
+	Address string `yaml:"address"`
+}
+
+// LatencyMeasurement is the round-trip time and link quality to a target, reported with
+// heartbeats
+type LatencyMeasurement struct {
+	Target string `json:"target"`
+	// 0 when every connection attempt was lost
+	RTTMillis         float64   `json:"rtt_ms"`
+	PacketLossPercent float64   `json:"packet_loss_percent"`
+	JitterMillis      float64   `json:"jitter_ms"`
+	MeasuredAt        time.Time `json:"measured_at"`
+}
+
+// startLatencyProbes measures the round-trip time, packet loss and jitter to the
+// orchestrator and the configured probe targets; heartbeats carry the latest measurements
+func (ea *EdgeAgent) startLatencyProbes() {
+	ticker := time.NewTicker(LatencyProbeInterval)
+	defer ticker.Stop()
+
+	ea.probeLatency()
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			ea.probeLatency()
+		}
+	}
+}
+
+func (ea *EdgeAgent) probeLatency() {
+	targets, err := ea.latencyTargets()
+	if err != nil {
+		ea.logger.Errorf("Failed to probe latency: %v", err)
+		return
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	measurements := make([]LatencyMeasurement, 0, len(targets))
+	for _, name := range names {
+		measurement, err := measureLink(targets[name])
+		if err != nil {
+			// Reported all the same: a target that cannot be reached is a lossy link
+			ea.logger.Warnf("Failed to measure latency to %s: %v", name, err)
+		}
+		measurement.Target = name
+		measurement.MeasuredAt = time.Now()
+		measurements = append(measurements, measurement)
+	}
+
+	ea.latencyMutex.Lock()
+	ea.latency = measurements
+	ea.latencyMutex.Unlock()
+}
+
+// latencyTargets returns the address of every target by name
+func (ea *EdgeAgent) latencyTargets() (map[string]string, error) {
+	orchestratorURL, err := url.Parse(ea.config.OrchestratorURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid orchestrator URL: %v", err)
+	}
+	port := orchestratorURL.Port()
+	if port == "" {
+		port = "443"
+		if orchestratorURL.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	targets := map[string]string{
+		LatencyTargetOrchestrator: net.JoinHostPort(orchestratorURL.Hostname(), port),
+	}
+	for _, probe := range ea.config.LatencyProbes {
+		targets[probe.Name] = probe.Address
+	}
+	return targets, nil
+}
+
+// measureLink opens several TCP connections to address, each set up in one round trip. It
+// returns the fastest round trip, the share of connections that failed, and the jitter as
+// the mean difference between consecutive round trips. The error of the last failed
+// connection is returned when none succeeded.
+func measureLink(address string) (LatencyMeasurement, error) {
+	var rtts []time.Duration
+	var lastErr error
+	for i := 0; i < LatencyProbeSamples; i++ {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", address, latencyProbeTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, time.Since(start))
+		conn.Close()
+	}
+
+	measurement := LatencyMeasurement{
+		PacketLossPercent: float64(LatencyProbeSamples-len(rtts)) * 100 / LatencyProbeSamples,
+	}
+	if len(rtts) == 0 {
+		return measurement, lastErr
+	}
+
+	fastest := rtts[0]
+	var variation time.Duration
+	for i, rtt := range rtts {
+		if rtt < fastest {
+			fastest = rtt
+		}
+		if i > 0 {
+			variation += time.Duration(math.Abs(float64(rtt - rtts[i-1])))
+		}
+	}
+	measurement.RTTMillis = float64(fastest.Microseconds()) / 1000
+	if len(rtts) > 1 {
+		measurement.JitterMillis = float64(variation.Microseconds()) / 1000 / float64(len(rtts)-1)
+	}
+	return measurement, nil
+}
+
+// latencyMeasurements returns the latest measurements, or nil before the first probe
+func (ea *EdgeAgent) latencyMeasurements() []LatencyMeasurement {
+	ea.latencyMutex.Lock()
+	defer ea.latencyMutex.Unlock()
+
+	return ea.latency
+}
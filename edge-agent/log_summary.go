@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"regexp"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Interval between log analyses; each covers the logs written since the previous one
+	LogSummaryInterval = time.Minute
+
+	// Distinct messages kept as samples per workload and window
+	LogSummarySamples = 5
+
+	// Log bytes read per container and window; the rest of a very chatty window is skipped
+	logSummaryMaxBytes = 1 << 20
+	logSampleMaxLength = 512
+)
+
+var (
+	logErrorPattern   = regexp.MustCompile(`(?i)\b(error|fatal|panic|exception|critical|severe)\b`)
+	logWarningPattern = regexp.MustCompile(`(?i)\b(warn|warning)\b`)
+
+	// Parts of a message that vary between occurrences of the same message
+	logVariablePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`),
+		regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{16,}\b`),
+		regexp.MustCompile(`\d+`),
+	}
+)
+
+// LogSample is a message that recurred in a workload's logs
+type LogSample struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	// Lines that differ from message only in numbers and IDs
+	Count int64 `json:"count"`
+}
+
+// WorkloadLogSummary is what the agent reports of a workload's logs instead of the logs:
+// how many lines were errors and warnings, with the most frequent ones as samples
+type WorkloadLogSummary struct {
+	WorkloadID    string      `json:"workload_id"`
+	WindowSeconds int64       `json:"window_seconds"`
+	Lines         int64       `json:"lines"`
+	Errors        int64       `json:"errors"`
+	Warnings      int64       `json:"warnings"`
+	Samples       []LogSample `json:"samples,omitempty"`
+	CollectedAt   time.Time   `json:"collected_at"`
+}
+
+// startLogSummaries analyzes the logs of the workloads assigned to this node; heartbeats
+// carry the latest summaries
+func (ea *EdgeAgent) startLogSummaries() {
+	ticker := time.NewTicker(LogSummaryInterval)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := ea.summarizeLogs(since, now); err != nil {
+				ea.logger.Errorf("Failed to summarize workload logs: %v", err)
+				continue
+			}
+			since = now
+		}
+	}
+}
+
+func (ea *EdgeAgent) summarizeLogs(since, now time.Time) error {
+	workloads, err := ea.fetchAssignments()
+	if err != nil {
+		return err
+	}
+
+	summaries := make([]WorkloadLogSummary, 0, len(workloads))
+	for _, workload := range workloads {
+		summary, err := ea.summarizeWorkloadLogs(ea.registrationCtx, workload, since)
+		if err != nil {
+			ea.logger.Warnf("Failed to summarize logs of workload %s: %v", workload.Name, err)
+			continue
+		}
+		summary.WindowSeconds = int64(now.Sub(since).Seconds())
+		summary.CollectedAt = now
+		summaries = append(summaries, summary)
+	}
+
+	ea.logSummaryMutex.Lock()
+	ea.logSummaries = summaries
+	ea.logSummaryMutex.Unlock()
+	return nil
+}
+
+// summarizeWorkloadLogs reads what every container of the workload's pods logged since the
+// given time
+func (ea *EdgeAgent) summarizeWorkloadLogs(ctx context.Context, workload AssignedWorkload, since time.Time) (WorkloadLogSummary, error) {
+	summary := WorkloadLogSummary{WorkloadID: workload.ID}
+
+	namespace := workload.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	pods, err := ea.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: workloadSelector(workload.ID)})
+	if err != nil {
+		return summary, err
+	}
+
+	analyzer := newLogAnalyzer()
+	limit := int64(logSummaryMaxBytes)
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			stream, err := ea.kubeClient.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container:  container.Name,
+				SinceTime:  &metav1.Time{Time: since},
+				LimitBytes: &limit,
+			}).Stream(ctx)
+			if err != nil {
+				// A container that has not started yet has no logs
+				ea.logger.Debugf("Failed to read logs of %s/%s: %v", pod.Name, container.Name, err)
+				continue
+			}
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), logSummaryMaxBytes)
+			for scanner.Scan() {
+				analyzer.add(scanner.Text())
+			}
+			stream.Close()
+		}
+	}
+
+	analyzer.summarize(&summary)
+	return summary, nil
+}
+
+// logAnalyzer counts log lines by level and groups the errors and warnings by message
+type logAnalyzer struct {
+	lines, errors, warnings int64
+	messages                map[string]*LogSample
+}
+
+func newLogAnalyzer() *logAnalyzer {
+	return &logAnalyzer{messages: make(map[string]*LogSample)}
+}
+
+func (a *logAnalyzer) add(line string) {
+	a.lines++
+
+	var level string
+	switch {
+	case logErrorPattern.MatchString(line):
+		level = "error"
+		a.errors++
+	case logWarningPattern.MatchString(line):
+		level = "warning"
+		a.warnings++
+	default:
+		return
+	}
+
+	key := level + " " + logFingerprint(line)
+	sample, exists := a.messages[key]
+	if !exists {
+		if len(line) > logSampleMaxLength {
+			line = line[:logSampleMaxLength]
+		}
+		sample = &LogSample{Level: level, Message: line}
+		a.messages[key] = sample
+	}
+	sample.Count++
+}
+
+// summarize fills in the counts and the most frequent messages, errors before warnings
+func (a *logAnalyzer) summarize(summary *WorkloadLogSummary) {
+	summary.Lines, summary.Errors, summary.Warnings = a.lines, a.errors, a.warnings
+
+	samples := make([]LogSample, 0, len(a.messages))
+	for _, sample := range a.messages {
+		samples = append(samples, *sample)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Level != samples[j].Level {
+			return samples[i].Level == "error"
+		}
+		if samples[i].Count != samples[j].Count {
+			return samples[i].Count > samples[j].Count
+		}
+		return samples[i].Message < samples[j].Message
+	})
+	if len(samples) > LogSummarySamples {
+		samples = samples[:LogSummarySamples]
+	}
+	summary.Samples = samples
+}
+
+// logFingerprint reduces a line to what stays the same between occurrences of a message
+func logFingerprint(line string) string {
+	for _, pattern := range logVariablePatterns {
+		line = pattern.ReplaceAllString(line, "#")
+	}
+	return line
+}
+
+// latestLogSummaries returns the summaries of the latest analysis, or nil before the first
+func (ea *EdgeAgent) latestLogSummaries() []WorkloadLogSummary {
+	ea.logSummaryMutex.Lock()
+	defer ea.logSummaryMutex.Unlock()
+
+	return ea.logSummaries
+}
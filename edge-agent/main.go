@@ -11,15 +11,19 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
 	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -34,16 +38,71 @@ const (
 type Config struct {
 	OrchestratorURL    string        `yaml:"orchestrator_url"`
 	NodeName           string        `yaml:"node_name"`
+	// Derive the node name from the machine ID when node_name is unset
+	NodeNamePrefix     string        `yaml:"node_name_prefix"`
+	// Wait for an operator to claim the device before registering
+	ClaimMode          bool          `yaml:"claim_mode"`
 	NodeAddress        string        `yaml:"node_address"`
 	Region             string        `yaml:"region"`
 	Zone               string        `yaml:"zone"`
+	SiteID             string        `yaml:"site_id"`
 	HeartbeatInterval  time.Duration `yaml:"heartbeat_interval"`
 	AuthToken          string        `yaml:"auth_token"`
 	TLSCertPath        string        `yaml:"tls_cert_path"`
 	TLSKeyPath         string        `yaml:"tls_key_path"`
 	KubeconfigPath     string        `yaml:"kubeconfig_path"`
 	Labels             map[string]string `yaml:"labels"`
+	// Labels derived from DMI, cloud-init and the hostname, added to the configured ones
+	LabelDiscovery     *LabelDiscoveryConfig `yaml:"label_discovery"`
 	Capabilities       []string      `yaml:"capabilities"`
+	// Taints declared at registration; taints operators set on the node take precedence
+	Taints             []NodeTaint   `yaml:"taints"`
+	BackupImage        string        `yaml:"backup_image"`
+	// Host directory federated learning rounds exchange models through
+	FederatedDataDir   string        `yaml:"federated_data_dir"`
+	StateFile          string        `yaml:"state_file"`
+	LogLevel           string        `yaml:"log_level"`
+	LogFormat          string        `yaml:"log_format"`
+	LogFile            string        `yaml:"log_file"`
+	LogMaxSizeMB       int           `yaml:"log_max_size_mb"`
+	LogMaxBackups      int           `yaml:"log_max_backups"`
+	// "https" (default) or "udp" for lossy links; UDP falls back to HTTPS automatically
+	HeartbeatTransport string        `yaml:"heartbeat_transport"`
+	// "rest" (default) or "grpc" to register, heartbeat and sync workloads over gRPC
+	APITransport       string        `yaml:"api_transport"`
+	// host:port of the orchestrator's gRPC API, required with api_transport "grpc"
+	GRPCAddress        string        `yaml:"grpc_address"`
+	// "stream" (default) to have workload changes pushed over a WebSocket, or "long-poll"
+	// to wait for them with held assignment requests where WebSockets do not get through
+	WorkloadDelivery   string        `yaml:"workload_delivery"`
+	// Run host commands queued by the orchestrator, such as firmware update hooks
+	AllowNodeCommands  bool          `yaml:"allow_node_commands"`
+	// RTSP cameras this node can reach; attached USB cameras are discovered automatically
+	Cameras            []CameraConfig `yaml:"cameras"`
+	// Datasets stored on this node, advertised so workloads can be placed next to them
+	Datasets           []DatasetConfig `yaml:"datasets"`
+	// Clusters to manage as separate logical edge nodes instead of the single kubeconfig
+	Clusters           []ClusterConfig `yaml:"clusters"`
+	// Report instance type, zone and spot lifecycle from the cloud's instance metadata
+	// service; always on for nodes created by the orchestrator's cloud provisioner
+	CloudMetadata      bool          `yaml:"cloud_metadata"`
+	// Targets, besides the orchestrator, whose round-trip time and link quality are reported for latency-aware placement
+	LatencyProbes      []LatencyProbeConfig `yaml:"latency_probes"`
+	// Count errors and warnings in workload logs and report them with heartbeats, so error
+	// spikes raise alerts without shipping the logs
+	LogSummaries       bool          `yaml:"log_summaries"`
+	// Commands whose JSON output is reported as custom metrics with heartbeats
+	Collectors         []CollectorConfig `yaml:"collectors"`
+	// "time-slicing" or "mps" when the NVIDIA device plugin shares this node's GPUs, so
+	// replicas may request a share of a card
+	GPUSharing         string        `yaml:"gpu_sharing"`
+	// Kubelet configuration read for its CPU and topology manager policies, which the two
+	// policy fields override for kubelets configured with flags
+	KubeletConfigPath     string     `yaml:"kubelet_config_path"`
+	CPUManagerPolicy      string     `yaml:"cpu_manager_policy"`
+	TopologyManagerPolicy string     `yaml:"topology_manager_policy"`
+	// Send heartbeats through an agent elected gateway of the site, over the LAN
+	SiteGateway        *SiteGatewayConfig `yaml:"site_gateway"`
 }
 
 type EdgeAgent struct {
@@ -51,9 +110,36 @@ type EdgeAgent struct {
 	logger          *logrus.Logger
 	httpClient      *http.Client
 	kubeClient      kubernetes.Interface
-	nodeID          string
-	registrationCtx context.Context
-	cancel          context.CancelFunc
+	dynamicClient   dynamic.Interface
+	state           *stateStore
+	udp             *udpHeartbeatClient
+	grpc            *agentGRPCClient
+	udpRetryAt      time.Time
+	udpMutex        sync.Mutex
+	desired         desiredState
+	desiredMutex    sync.Mutex
+	desiredHint     string
+	latency         []LatencyMeasurement
+	latencyMutex    sync.Mutex
+	logSummaries    []WorkloadLogSummary
+	logSummaryMutex sync.Mutex
+	// Latest values by collector name
+	customMetrics     map[string][]CustomMetric
+	customMetricMutex sync.Mutex
+	// GPUs detected by nvidia-smi, refreshed every GPUDetectionInterval
+	gpus              []GPUDevice
+	gpuDetectAt       time.Time
+	gpuMutex          sync.Mutex
+	// CPU topology, refreshed every CPUTopologyInterval
+	topology          *CPUTopology
+	topologyDetectAt  time.Time
+	topologyMutex     sync.Mutex
+	// Set when heartbeats may go through the site's gateway
+	gateway           *siteGateway
+	cluster           *ClusterConfig
+	nodeID            string
+	registrationCtx   context.Context
+	cancel            context.CancelFunc
 }
 
 type NodeStatus string
@@ -67,28 +153,40 @@ const (
 
 type NodeResources struct {
 	CPU struct {
-		Capacity   string  `json:"capacity"`
-		Usage      string  `json:"usage"`
-		Percentage float64 `json:"percentage"`
+		Capacity           string  `json:"capacity"`
+		Usage              string  `json:"usage"`
+		Percentage         float64 `json:"percentage"`
+		CapacityMillicores int64   `json:"capacity_millicores,omitempty"`
+		UsageMillicores    int64   `json:"usage_millicores,omitempty"`
 	} `json:"cpu"`
 	Memory struct {
-		Capacity   string  `json:"capacity"`
-		Usage      string  `json:"usage"`
-		Percentage float64 `json:"percentage"`
+		Capacity      string  `json:"capacity"`
+		Usage         string  `json:"usage"`
+		Percentage    float64 `json:"percentage"`
+		CapacityBytes int64   `json:"capacity_bytes,omitempty"`
+		UsageBytes    int64   `json:"usage_bytes,omitempty"`
 	} `json:"memory"`
 	Storage struct {
-		Capacity   string  `json:"capacity"`
-		Usage      string  `json:"usage"`
-		Percentage float64 `json:"percentage"`
+		Capacity      string  `json:"capacity"`
+		Usage         string  `json:"usage"`
+		Percentage    float64 `json:"percentage"`
+		CapacityBytes int64   `json:"capacity_bytes,omitempty"`
+		UsageBytes    int64   `json:"usage_bytes,omitempty"`
 	} `json:"storage"`
 	NetworkBandwidth string `json:"network_bandwidth"`
 	GPUs            int    `json:"gpus"`
+	GPUDevices      []GPUDevice `json:"gpu_devices,omitempty"`
+	CPUTopology     *CPUTopology `json:"cpu_topology,omitempty"`
+	BootTime        *time.Time   `json:"boot_time,omitempty"`
 }
 
 type HeartbeatRequest struct {
-	Status    NodeStatus    `json:"status"`
-	Resources NodeResources `json:"resources"`
-	Timestamp time.Time     `json:"timestamp"`
+	Status        NodeStatus           `json:"status"`
+	Resources     NodeResources        `json:"resources"`
+	Timestamp     time.Time            `json:"timestamp"`
+	Latency       []LatencyMeasurement `json:"latency,omitempty"`
+	LogSummaries  []WorkloadLogSummary `json:"log_summaries,omitempty"`
+	CustomMetrics []CustomMetric       `json:"custom_metrics,omitempty"`
 }
 
 type RegistrationRequest struct {
@@ -96,8 +194,10 @@ type RegistrationRequest struct {
 	Address          string            `json:"address"`
 	Labels           map[string]string `json:"labels"`
 	Capabilities     []string          `json:"capabilities"`
+	Taints           []NodeTaint       `json:"taints"`
 	Region           string            `json:"region"`
 	Zone             string            `json:"zone"`
+	SiteID           string            `json:"site_id"`
 	KubernetesVersion string           `json:"kubernetes_version"`
 	ContainerRuntime string            `json:"container_runtime"`
 }
@@ -108,6 +208,11 @@ type RegistrationResponse struct {
 }
 
 func main() {
+	// Local diagnostic subcommands run without starting the agent
+	if len(os.Args) > 1 && os.Args[1] != "run" {
+		os.Exit(runCommand(os.Args[1]))
+	}
+
 	// Initialize logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
@@ -126,6 +231,13 @@ func main() {
 		logger.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	if err := configureLogger(logger, config); err != nil {
+		logger.Fatalf("Failed to configure logging: %v", err)
+	}
+	go watchLogLevelSignal(logger)
+	discoverLabels(config, logger)
+	detectRealtimeKernel(config, logger)
+
 	// Initialize edge agent
 	agent, err := NewEdgeAgent(config, logger)
 	if err != nil {
@@ -137,14 +249,84 @@ func main() {
 	agent.registrationCtx = ctx
 	agent.cancel = cancel
 
+	agent.recordStarted()
+
+	// Unclaimed devices wait for an operator to bind them to a site before registering
+	if config.ClaimMode {
+		if err := agent.awaitClaim(ctx); err != nil {
+			logger.Fatalf("Failed to complete device claim: %v", err)
+		}
+	}
+
+	// In multi-cluster mode each cluster registers as its own logical node
+	agents := []*EdgeAgent{agent}
+	if len(config.Clusters) > 0 {
+		agents, err = agent.newClusterAgents()
+		if err != nil {
+			logger.Fatalf("Failed to initialize clusters: %v", err)
+		}
+		logger.Infof("Managing %d clusters as logical edge nodes", len(agents))
+		for _, member := range agents {
+			member.recordStarted()
+		}
+	}
+
 	// Register with central orchestrator
-	if err := agent.register(); err != nil {
-		logger.Fatalf("Failed to register with orchestrator: %v", err)
+	for _, member := range agents {
+		if err := member.register(); err != nil {
+			logger.Fatalf("Failed to register %s with orchestrator: %v", member.config.NodeName, err)
+		}
 	}
 
 	// Start background services
-	go agent.startHeartbeat()
-	go agent.startResourceMonitoring()
+	if len(config.Clusters) > 0 {
+		go startClusterHeartbeats(agents)
+	} else {
+		go agent.startHeartbeat()
+		// Hardware inventory, cameras, datasets, latency and collectors describe this host, so only a single-cluster agent reports them
+		go agent.startHardwareInventory()
+		go agent.startLatencyProbes()
+		go agent.startCameraMonitoring()
+		go agent.startDatasetReporting()
+		if config.LogSummaries {
+			go agent.startLogSummaries()
+		}
+		go agent.startCollectors()
+		if agent.cloudMetadataEnabled() {
+			go agent.startCloudMetadataReporting()
+			go agent.startPreemptionWatch()
+		}
+	}
+	for _, member := range agents {
+		go member.startResourceMonitoring()
+		go member.startServiceSync()
+		go member.startVolumeTasks()
+		go member.startOffloadTasks()
+		go member.startNodeCommands()
+		go member.startTunnel()
+		go member.startFederatedTasks()
+		go member.startWorkloadReconciliation()
+		if config.WorkloadDelivery == WorkloadDeliveryLongPoll {
+			go member.startAssignmentPolling()
+		} else {
+			go member.startWorkloadStream()
+		}
+	}
+
+	// Resync on SIGHUP, sent by "edge-agent resync"
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			resync := agent.resync
+			if len(config.Clusters) > 0 {
+				resync = func() error { return agent.resyncClusters(agents) }
+			}
+			if err := resync(); err != nil {
+				logger.Errorf("Resync failed: %v", err)
+			}
+		}
+	}()
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -167,6 +349,14 @@ func loadConfig(path string) (*Config, error) {
 		Capabilities:     []string{},
 		Region:           "default",
 		Zone:             "default",
+		BackupImage:      DefaultBackupImage,
+		FederatedDataDir: DefaultFederatedDataDir,
+		StateFile:        DefaultStateFile,
+		LogMaxSizeMB:     DefaultLogMaxSizeMB,
+		LogMaxBackups:    DefaultLogMaxBackups,
+		HeartbeatTransport: "https",
+		APITransport:       "rest",
+		KubeletConfigPath:  DefaultKubeletConfigPath,
 	}
 
 	// Check if config file exists
@@ -176,10 +366,56 @@ func loadConfig(path string) (*Config, error) {
 		config.NodeName = os.Getenv("NODE_NAME")
 		config.NodeAddress = os.Getenv("NODE_ADDRESS")
 		config.AuthToken = os.Getenv("AUTH_TOKEN")
+		if transport := os.Getenv("HEARTBEAT_TRANSPORT"); transport != "" {
+			config.HeartbeatTransport = transport
+		}
+		if transport := os.Getenv("API_TRANSPORT"); transport != "" {
+			config.APITransport = transport
+		}
+		config.GRPCAddress = os.Getenv("GRPC_ADDRESS")
+		config.WorkloadDelivery = os.Getenv("WORKLOAD_DELIVERY")
+		config.LogLevel = os.Getenv("LOG_LEVEL")
+		config.LogFormat = os.Getenv("LOG_FORMAT")
+		config.LogFile = os.Getenv("LOG_FILE")
+		if size, err := strconv.Atoi(os.Getenv("LOG_MAX_SIZE_MB")); err == nil && size > 0 {
+			config.LogMaxSizeMB = size
+		}
+		if backups, err := strconv.Atoi(os.Getenv("LOG_MAX_BACKUPS")); err == nil && backups >= 0 {
+			config.LogMaxBackups = backups
+		}
+		if stateFile := os.Getenv("EDGE_AGENT_STATE_FILE"); stateFile != "" {
+			config.StateFile = stateFile
+		}
+		if backupImage := os.Getenv("BACKUP_IMAGE"); backupImage != "" {
+			config.BackupImage = backupImage
+		}
+		if dataDir := os.Getenv("FEDERATED_DATA_DIR"); dataDir != "" {
+			config.FederatedDataDir = dataDir
+		}
+		config.AllowNodeCommands = os.Getenv("ALLOW_NODE_COMMANDS") == "true"
+		config.Cameras = parseCameraList(os.Getenv("RTSP_CAMERAS"))
+		config.Datasets = parseDatasetList(os.Getenv("DATASETS"))
+		config.ClaimMode = os.Getenv("CLAIM_MODE") == "true"
+		config.GPUSharing = os.Getenv("GPU_SHARING")
+		config.CPUManagerPolicy = os.Getenv("CPU_MANAGER_POLICY")
+		config.TopologyManagerPolicy = os.Getenv("TOPOLOGY_MANAGER_POLICY")
+		config.Taints = parseTaintList(os.Getenv("NODE_TAINTS"))
+		if err := validateGPUSharing(config.GPUSharing); err != nil {
+			return nil, err
+		}
+		if err := validateTaints(config.Taints); err != nil {
+			return nil, err
+		}
+		if err := validateWorkloadDelivery(config); err != nil {
+			return nil, err
+		}
 		
 		if config.OrchestratorURL == "" {
 			return nil, fmt.Errorf("ORCHESTRATOR_URL is required")
 		}
+		if err := resolveNodeIdentity(config); err != nil {
+			return nil, err
+		}
 		if config.NodeName == "" {
 			return nil, fmt.Errorf("NODE_NAME is required")
 		}
@@ -199,6 +435,30 @@ func loadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
+	if err := resolveNodeIdentity(config); err != nil {
+		return nil, err
+	}
+	if err := validateCollectors(config.Collectors); err != nil {
+		return nil, err
+	}
+	if err := validateLabelDiscovery(config.LabelDiscovery); err != nil {
+		return nil, err
+	}
+	if config.Labels == nil {
+		config.Labels = make(map[string]string)
+	}
+	if err := validateSiteGateway(config); err != nil {
+		return nil, err
+	}
+	if err := validateGPUSharing(config.GPUSharing); err != nil {
+		return nil, err
+	}
+	if err := validateTaints(config.Taints); err != nil {
+		return nil, err
+	}
+	if err := validateWorkloadDelivery(config); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
@@ -209,6 +469,15 @@ func NewEdgeAgent(config *Config, logger *logrus.Logger) (*EdgeAgent, error) {
 		InsecureSkipVerify: true, // For demo purposes, in production verify certificates
 	}
 
+	// Present a client certificate so the orchestrator can derive the node identity
+	if config.TLSCertPath != "" && config.TLSKeyPath != "" {
+		clientCert, err := tls.LoadX509KeyPair(config.TLSCertPath, config.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
 	httpClient := &http.Client{
 		Timeout: DefaultTimeout,
 		Transport: &http.Transport{
@@ -218,6 +487,7 @@ func NewEdgeAgent(config *Config, logger *logrus.Logger) (*EdgeAgent, error) {
 
 	// Initialize Kubernetes client
 	var kubeClient kubernetes.Interface
+	var dynamicClient dynamic.Interface
 	var err error
 
 	if config.KubeconfigPath != "" {
@@ -229,6 +499,10 @@ func NewEdgeAgent(config *Config, logger *logrus.Logger) (*EdgeAgent, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
 		}
+		dynamicClient, err = dynamic.NewForConfig(kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %v", err)
+		}
 	} else {
 		// Use in-cluster config
 		kubeconfig, err := rest.InClusterConfig()
@@ -239,14 +513,44 @@ func NewEdgeAgent(config *Config, logger *logrus.Logger) (*EdgeAgent, error) {
 			if err != nil {
 				logger.Warnf("Failed to create in-cluster Kubernetes client: %v", err)
 			}
+			dynamicClient, err = dynamic.NewForConfig(kubeconfig)
+			if err != nil {
+				logger.Warnf("Failed to create in-cluster dynamic Kubernetes client: %v", err)
+			}
 		}
 	}
 
+	// Registration, heartbeats and workload sync can go over the orchestrator's gRPC API
+	var grpcClient *agentGRPCClient
+	switch config.APITransport {
+	case "", "rest":
+	case "grpc":
+		if config.GRPCAddress == "" {
+			return nil, fmt.Errorf("grpc_address is required when api_transport is grpc")
+		}
+		grpcClient, err = newAgentGRPCClient(config.GRPCAddress, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown api_transport %q", config.APITransport)
+	}
+
+	var gateway *siteGateway
+	if config.SiteGateway != nil {
+		gateway = newSiteGateway()
+	}
+
 	return &EdgeAgent{
-		config:     config,
-		logger:     logger,
-		httpClient: httpClient,
-		kubeClient: kubeClient,
+		config:        config,
+		logger:        logger,
+		httpClient:    httpClient,
+		grpc:          grpcClient,
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		state:         newStateStore(config.StateFile),
+		customMetrics: make(map[string][]CustomMetric),
+		gateway:       gateway,
 	}, nil
 }
 
@@ -271,12 +575,23 @@ func (ea *EdgeAgent) register() error {
 		Address:          ea.config.NodeAddress,
 		Labels:           ea.config.Labels,
 		Capabilities:     ea.config.Capabilities,
+		Taints:           ea.config.Taints,
 		Region:           ea.config.Region,
 		Zone:             ea.config.Zone,
+		SiteID:           ea.config.SiteID,
 		KubernetesVersion: k8sVersion,
 		ContainerRuntime: containerRuntime,
 	}
 
+	if ea.grpc != nil {
+		nodeID, err := ea.registerGRPC(req)
+		if err != nil {
+			return err
+		}
+		ea.registered(nodeID)
+		return nil
+	}
+
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal registration request: %v", err)
@@ -306,12 +621,20 @@ func (ea *EdgeAgent) register() error {
 		return fmt.Errorf("failed to decode registration response: %v", err)
 	}
 
-	ea.nodeID = regResp.ID
-	ea.logger.Infof("Successfully registered with node ID: %s", ea.nodeID)
-
+	ea.registered(regResp.ID)
 	return nil
 }
 
+// registered records the node ID the orchestrator assigned
+func (ea *EdgeAgent) registered(nodeID string) {
+	ea.nodeID = nodeID
+	ea.logger.Infof("Successfully registered with node ID: %s", ea.nodeID)
+	ea.recordState(func(state *LocalState) {
+		state.NodeID = ea.nodeID
+		state.RegisteredAt = time.Now()
+	})
+}
+
 func (ea *EdgeAgent) startHeartbeat() {
 	ticker := time.NewTicker(ea.config.HeartbeatInterval)
 	defer ticker.Stop()
@@ -323,9 +646,11 @@ func (ea *EdgeAgent) startHeartbeat() {
 		case <-ea.registrationCtx.Done():
 			return
 		case <-ticker.C:
-			if err := ea.sendHeartbeat(); err != nil {
+			err := ea.sendHeartbeat()
+			if err != nil {
 				ea.logger.Errorf("Failed to send heartbeat: %v", err)
 			}
+			ea.recordHeartbeat(err)
 		}
 	}
 }
@@ -338,9 +663,28 @@ func (ea *EdgeAgent) sendHeartbeat() error {
 	}
 
 	req := HeartbeatRequest{
-		Status:    NodeStatusOnline,
-		Resources: resources,
-		Timestamp: time.Now(),
+		Status:        NodeStatusOnline,
+		Resources:     resources,
+		Timestamp:     time.Now(),
+		Latency:       ea.latencyMeasurements(),
+		LogSummaries:  ea.latestLogSummaries(),
+		CustomMetrics: ea.latestCustomMetrics(),
+	}
+
+	// Send through the site's gateway when one is elected, directly when it is unreachable
+	if ea.gateway != nil {
+		if handled, err := ea.sendSiteHeartbeat(req); handled {
+			return err
+		}
+	}
+
+	// Prefer the negotiated UDP path, falling back to HTTPS when it goes unacknowledged
+	if ea.sendUDPHeartbeat(req) {
+		return nil
+	}
+
+	if ea.grpc != nil {
+		return ea.sendGRPCHeartbeat(req)
 	}
 
 	jsonData, err := json.Marshal(req)
@@ -371,7 +715,50 @@ func (ea *EdgeAgent) sendHeartbeat() error {
 	return nil
 }
 
+// doRequest sends an authenticated JSON request to the orchestrator and decodes the response into out
+func (ea *EdgeAgent) doRequest(method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	httpReq, err := http.NewRequest(method, ea.config.OrchestratorURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+ea.config.AuthToken)
+
+	resp, err := ea.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+
+	return nil
+}
+
 func (ea *EdgeAgent) collectResources() (NodeResources, error) {
+	if ea.cluster != nil {
+		return ea.collectClusterResources()
+	}
+
 	var resources NodeResources
 
 	// Collect CPU information
@@ -380,6 +767,10 @@ func (ea *EdgeAgent) collectResources() (NodeResources, error) {
 		resources.CPU.Percentage = cpuPercent[0]
 		resources.CPU.Usage = fmt.Sprintf("%.1f%%", cpuPercent[0])
 		resources.CPU.Capacity = "100%" // Simplified
+		if cores, err := cpu.Counts(true); err == nil && cores > 0 {
+			resources.CPU.CapacityMillicores = int64(cores) * 1000
+			resources.CPU.UsageMillicores = int64(cpuPercent[0] / 100 * float64(resources.CPU.CapacityMillicores))
+		}
 	}
 
 	// Collect memory information
@@ -388,6 +779,8 @@ func (ea *EdgeAgent) collectResources() (NodeResources, error) {
 		resources.Memory.Capacity = fmt.Sprintf("%.0f MB", float64(memInfo.Total)/1024/1024)
 		resources.Memory.Usage = fmt.Sprintf("%.0f MB", float64(memInfo.Used)/1024/1024)
 		resources.Memory.Percentage = memInfo.UsedPercent
+		resources.Memory.CapacityBytes = int64(memInfo.Total)
+		resources.Memory.UsageBytes = int64(memInfo.Used)
 	}
 
 	// Collect disk information
@@ -396,6 +789,8 @@ func (ea *EdgeAgent) collectResources() (NodeResources, error) {
 		resources.Storage.Capacity = fmt.Sprintf("%.0f GB", float64(diskInfo.Total)/1024/1024/1024)
 		resources.Storage.Usage = fmt.Sprintf("%.0f GB", float64(diskInfo.Used)/1024/1024/1024)
 		resources.Storage.Percentage = diskInfo.UsedPercent
+		resources.Storage.CapacityBytes = int64(diskInfo.Total)
+		resources.Storage.UsageBytes = int64(diskInfo.Used)
 	}
 
 	// Collect network information (simplified)
@@ -404,8 +799,13 @@ func (ea *EdgeAgent) collectResources() (NodeResources, error) {
 		resources.NetworkBandwidth = "1 Gbps" // Simplified
 	}
 
-	// GPU count (simplified - would need proper GPU detection)
-	resources.GPUs = 0
+	resources.CPUTopology = ea.cpuTopology()
+	if boot, err := host.BootTime(); err == nil {
+		bootTime := time.Unix(int64(boot), 0)
+		resources.BootTime = &bootTime
+	}
+	resources.GPUDevices = ea.gpuDevices()
+	resources.GPUs = len(resources.GPUDevices)
 
 	return resources, nil
 }
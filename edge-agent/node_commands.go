@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+const (
+	// Only the tail of a command's output is reported back to the orchestrator
+	MaxCommandOutputBytes = 16 * 1024
+)
+
+// NodeCommand is a host command queued for this node, such as a firmware update hook
+type NodeCommand struct {
+	ID             string   `json:"id"`
+	Source         string   `json:"source"`
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+	Status         string   `json:"status"`
+}
+
+type NodeCommandsResponse struct {
+	Commands []NodeCommand `json:"commands"`
+}
+
+type NodeCommandStatusRequest struct {
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (ea *EdgeAgent) startNodeCommands() {
+	if !ea.config.AllowNodeCommands {
+		ea.logger.Info("Node commands are not allowed by configuration, command channel disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ea.logger.Info("Starting node command processing")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.processNodeCommands(); err != nil {
+				ea.logger.Errorf("Failed to process node commands: %v", err)
+			}
+		}
+	}
+}
+
+// processNodeCommands runs queued commands one at a time. A command already marked running
+// was interrupted by an agent restart and is reported failed rather than run twice.
+func (ea *EdgeAgent) processNodeCommands() error {
+	var resp NodeCommandsResponse
+	path := fmt.Sprintf("/api/v1/nodes/%s/commands", ea.nodeID)
+	if err := ea.doRequest("GET", path, nil, &resp); err != nil {
+		return fmt.Errorf("failed to fetch node commands: %v", err)
+	}
+
+	for _, cmd := range resp.Commands {
+		var report NodeCommandStatusRequest
+		if cmd.Status == "running" {
+			report = NodeCommandStatusRequest{Status: "failed", ExitCode: -1, Error: "interrupted by agent restart"}
+		} else {
+			ea.reportNodeCommand(cmd, NodeCommandStatusRequest{Status: "running"})
+			report = ea.runNodeCommand(cmd)
+
+			// Commands are usually firmware hooks, so refresh the inventory before reporting
+			if err := ea.reportHardware(); err != nil {
+				ea.logger.Errorf("Failed to report hardware inventory: %v", err)
+			}
+		}
+		ea.reportNodeCommand(cmd, report)
+	}
+
+	return nil
+}
+
+func (ea *EdgeAgent) runNodeCommand(cmd NodeCommand) NodeCommandStatusRequest {
+	if len(cmd.Command) == 0 {
+		return NodeCommandStatusRequest{Status: "failed", ExitCode: -1, Error: "empty command"}
+	}
+
+	ea.logger.Infof("Running command %s from %s: %v", cmd.ID, cmd.Source, cmd.Command)
+
+	ctx, cancel := context.WithTimeout(ea.registrationCtx, time.Duration(cmd.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	var output bytes.Buffer
+	command := exec.CommandContext(ctx, cmd.Command[0], cmd.Command[1:]...)
+	command.Stdout = &output
+	command.Stderr = &output
+
+	err := command.Run()
+	report := NodeCommandStatusRequest{Status: "succeeded", Output: tailOutput(output.Bytes())}
+	if err != nil {
+		report.Status = "failed"
+		report.ExitCode = -1
+		report.Error = err.Error()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			report.ExitCode = exitErr.ExitCode()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			report.Error = fmt.Sprintf("timed out after %ds", cmd.TimeoutSeconds)
+		}
+		ea.logger.Errorf("Command %s failed: %s", cmd.ID, report.Error)
+	}
+	return report
+}
+
+func (ea *EdgeAgent) reportNodeCommand(cmd NodeCommand, report NodeCommandStatusRequest) {
+	path := fmt.Sprintf("/api/v1/nodes/%s/commands/%s/status", ea.nodeID, cmd.ID)
+	if err := ea.doRequest("POST", path, report, nil); err != nil {
+		ea.logger.Errorf("Failed to report command %s: %v", cmd.ID, err)
+	}
+}
+
+func tailOutput(output []byte) string {
+	if len(output) > MaxCommandOutputBytes {
+		output = output[len(output)-MaxCommandOutputBytes:]
+	}
+	return string(output)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Label tying offload and recall jobs to the orchestrator run or recall that created them
+	OffloadTaskLabel = "edge.io/offload-task"
+
+	// Directory inside each managed volume recording where offloaded files went, so
+	// workloads can find them without asking the orchestrator
+	OffloadIndexDir = ".edge-offload"
+
+	// Markers the offload job prints around its results
+	offloadFilesMarker = "EDGE-OFFLOAD-FILES"
+	offloadUsageMarker = "EDGE-OFFLOAD-USAGE"
+)
+
+// Moves cold files out once the volume is over its watermark, lists what reached the
+// remote, and records the remote location and moved paths inside the volume
+const offloadScript = `set -e
+usage() { df -P /data | awk 'NR==2 { sub("%", "", $5); print $5 }'; }
+if [ "$(usage)" -ge "$HIGH_WATERMARK" ]; then
+  rclone lsf -R --files-only --min-age "$MIN_AGE" --min-size "$MIN_SIZE" --exclude "/` + OffloadIndexDir + `/**" /data > /tmp/cold
+  if [ -s /tmp/cold ]; then
+    rclone move --files-from-raw /tmp/cold /data "$REMOTE"
+    mkdir -p /data/` + OffloadIndexDir + `
+    echo "$LOCATION" > /data/` + OffloadIndexDir + `/location
+    cat /tmp/cold >> /data/` + OffloadIndexDir + `/index
+    echo ` + offloadFilesMarker + `
+    rclone lsjson -R --files-only --files-from-raw /tmp/cold "$REMOTE"
+  fi
+fi
+echo ` + offloadUsageMarker + ` "$(usage)"
+`
+
+// Moves recalled files back and drops them from the volume's offload index
+const recallScript = `set -e
+printf '%s\n' "$RECALL_FILES" > /tmp/recall
+rclone move --files-from-raw /tmp/recall "$REMOTE" /data
+if [ -f /data/` + OffloadIndexDir + `/index ]; then
+  grep -vxF -f /tmp/recall /data/` + OffloadIndexDir + `/index > /tmp/index || true
+  cat /tmp/index > /data/` + OffloadIndexDir + `/index
+fi
+`
+
+// OffloadRun is a volume the orchestrator wants offloaded on this node
+type OffloadRun struct {
+	RunID                string       `json:"run_id"`
+	WorkloadID           string       `json:"workload_id"`
+	WorkloadName         string       `json:"workload_name"`
+	Namespace            string       `json:"namespace"`
+	Volume               string       `json:"volume"`
+	Target               BackupTarget `json:"target"`
+	Location             string       `json:"location"`
+	ColdAfterHours       int          `json:"cold_after_hours"`
+	HighWatermarkPercent int          `json:"high_watermark_percent"`
+	MinFileSizeBytes     int64        `json:"min_file_size_bytes"`
+}
+
+// OffloadRecall copies offloaded files back onto a volume
+type OffloadRecall struct {
+	ID           string       `json:"id"`
+	WorkloadName string       `json:"workload_name"`
+	Namespace    string       `json:"namespace"`
+	Volume       string       `json:"volume"`
+	Paths        []string     `json:"paths"`
+	Target       BackupTarget `json:"target"`
+	Location     string       `json:"location"`
+	Status       string       `json:"status"`
+}
+
+type OffloadTasksResponse struct {
+	Runs    []OffloadRun    `json:"runs"`
+	Recalls []OffloadRecall `json:"recalls"`
+}
+
+type OffloadedFileReport struct {
+	Path       string    `json:"path"`
+	SizeBytes  int64     `json:"size_bytes"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type OffloadReport struct {
+	RunID        string                `json:"run_id"`
+	WorkloadID   string                `json:"workload_id"`
+	Volume       string                `json:"volume"`
+	UsagePercent int                   `json:"usage_percent"`
+	Files        []OffloadedFileReport `json:"files"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// rcloneListing is one entry of "rclone lsjson" output
+type rcloneListing struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+}
+
+// startOffloadTasks tiers cold data on designated volumes to object storage and recalls it
+// on request
+func (ea *EdgeAgent) startOffloadTasks() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, storage offload disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ea.logger.Info("Starting storage offload processing")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.processOffloadTasks(); err != nil {
+				ea.logger.Errorf("Failed to process offload tasks: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) processOffloadTasks() error {
+	var resp OffloadTasksResponse
+	path := fmt.Sprintf("/api/v1/nodes/%s/offload-tasks", ea.nodeID)
+	if err := ea.doRequest("GET", path, nil, &resp); err != nil {
+		return fmt.Errorf("failed to fetch offload tasks: %v", err)
+	}
+
+	for _, run := range resp.Runs {
+		report, err := ea.runOffload(ea.registrationCtx, run)
+		if err != nil {
+			ea.logger.Errorf("Offload of volume %s of %s failed: %v", run.Volume, run.WorkloadName, err)
+			report = &OffloadReport{RunID: run.RunID, WorkloadID: run.WorkloadID, Volume: run.Volume, Error: err.Error()}
+		}
+		if report == nil {
+			continue
+		}
+
+		reportPath := fmt.Sprintf("/api/v1/nodes/%s/offload-runs", ea.nodeID)
+		if err := ea.doRequest("POST", reportPath, report, nil); err != nil {
+			// The Job is kept so the next poll reports the same run again
+			ea.logger.Errorf("Failed to report offload run %s: %v", run.RunID, err)
+			continue
+		}
+		ea.deleteOffloadJob(ea.registrationCtx, run.Namespace, offloadJobName(run.WorkloadName, run.RunID))
+	}
+
+	for _, recall := range resp.Recalls {
+		report, err := ea.runRecall(ea.registrationCtx, recall)
+		if err != nil {
+			ea.logger.Errorf("Recall %s failed: %v", recall.ID, err)
+			report = &VolumeTaskStatusRequest{Status: "failed", Error: err.Error()}
+		}
+		if report == nil || report.Status == recall.Status {
+			continue
+		}
+
+		statusPath := fmt.Sprintf("/api/v1/nodes/%s/offload-recalls/%s/status", ea.nodeID, recall.ID)
+		if err := ea.doRequest("POST", statusPath, report, nil); err != nil {
+			ea.logger.Errorf("Failed to report recall %s: %v", recall.ID, err)
+			continue
+		}
+		if report.Status != "running" {
+			ea.deleteOffloadJob(ea.registrationCtx, recall.Namespace, recallJobName(recall))
+		}
+	}
+
+	return nil
+}
+
+func offloadJobName(workloadName, runID string) string {
+	return fmt.Sprintf("%s-offload-%s", workloadName, shortID(runID))
+}
+
+func recallJobName(recall OffloadRecall) string {
+	return fmt.Sprintf("%s-recall-%s", recall.WorkloadName, shortID(recall.ID))
+}
+
+// runOffload drives the offload Job for a run. Returns nil while the Job is running and
+// the report to send once it has finished.
+func (ea *EdgeAgent) runOffload(ctx context.Context, run OffloadRun) (*OffloadReport, error) {
+	name := offloadJobName(run.WorkloadName, run.RunID)
+	env := []corev1.EnvVar{
+		{Name: "REMOTE", Value: rcloneRemote(VolumeTask{Target: run.Target}, run.Location)},
+		{Name: "LOCATION", Value: run.Location},
+		{Name: "HIGH_WATERMARK", Value: fmt.Sprintf("%d", run.HighWatermarkPercent)},
+		{Name: "MIN_AGE", Value: fmt.Sprintf("%dh", run.ColdAfterHours)},
+		{Name: "MIN_SIZE", Value: fmt.Sprintf("%dB", run.MinFileSizeBytes)},
+	}
+	job := buildOffloadJob(name, run.Namespace, claimName(run.WorkloadName, run.Volume), run.RunID, offloadScript, env, ea.config.BackupImage)
+
+	finished, err := ea.driveOffloadJob(ctx, job)
+	if err != nil || !finished {
+		return nil, err
+	}
+
+	logs, err := ea.offloadJobLogs(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	report, err := parseOffloadLogs(logs)
+	if err != nil {
+		return nil, fmt.Errorf("job %s: %v", name, err)
+	}
+	report.RunID, report.WorkloadID, report.Volume = run.RunID, run.WorkloadID, run.Volume
+	if len(report.Files) > 0 {
+		ea.logger.Infof("Offloaded %d files from volume %s of %s", len(report.Files), run.Volume, run.WorkloadName)
+	}
+	return report, nil
+}
+
+// runRecall drives the Job copying a recall's files back onto the volume
+func (ea *EdgeAgent) runRecall(ctx context.Context, recall OffloadRecall) (*VolumeTaskStatusRequest, error) {
+	env := []corev1.EnvVar{
+		{Name: "REMOTE", Value: rcloneRemote(VolumeTask{Target: recall.Target}, recall.Location)},
+		{Name: "RECALL_FILES", Value: strings.Join(recall.Paths, "\n")},
+	}
+	job := buildOffloadJob(recallJobName(recall), recall.Namespace, claimName(recall.WorkloadName, recall.Volume), recall.ID, recallScript, env, ea.config.BackupImage)
+
+	finished, err := ea.driveOffloadJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	if !finished {
+		return &VolumeTaskStatusRequest{Status: "running"}, nil
+	}
+	return &VolumeTaskStatusRequest{Status: "completed"}, nil
+}
+
+// driveOffloadJob creates the Job if needed and reports whether it has succeeded
+func (ea *EdgeAgent) driveOffloadJob(ctx context.Context, job *batchv1.Job) (bool, error) {
+	jobs := ea.kubeClient.BatchV1().Jobs(job.Namespace)
+
+	existing, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		ea.logger.Infof("Creating job %s/%s", job.Namespace, job.Name)
+		if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			return false, fmt.Errorf("failed to create job %s: %v", job.Name, err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get job %s: %v", job.Name, err)
+	}
+
+	switch {
+	case existing.Status.Succeeded > 0:
+		return true, nil
+	case existing.Status.Failed > 0:
+		ea.deleteOffloadJob(ctx, job.Namespace, job.Name)
+		return false, fmt.Errorf("job %s failed", job.Name)
+	}
+	return false, nil
+}
+
+// offloadJobLogs returns the output of a finished Job's successful pod
+func (ea *EdgeAgent) offloadJobLogs(ctx context.Context, job *batchv1.Job) (string, error) {
+	pods, err := ea.kubeClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job.Name})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods of job %s: %v", job.Name, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		logs, err := ea.kubeClient.CoreV1().Pods(job.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to read logs of job %s: %v", job.Name, err)
+		}
+		return string(logs), nil
+	}
+	return "", fmt.Errorf("no successful pod found for job %s", job.Name)
+}
+
+func (ea *EdgeAgent) deleteOffloadJob(ctx context.Context, namespace, name string) {
+	propagation := metav1.DeletePropagationBackground
+	err := ea.kubeClient.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		ea.logger.Warnf("Failed to delete job %s: %v", name, err)
+	}
+}
+
+// parseOffloadLogs reads the moved files and final volume usage from an offload Job's output
+func parseOffloadLogs(logs string) (*OffloadReport, error) {
+	report := &OffloadReport{Files: make([]OffloadedFileReport, 0)}
+
+	usageAt := strings.LastIndex(logs, offloadUsageMarker)
+	if usageAt < 0 {
+		return nil, fmt.Errorf("no volume usage in output")
+	}
+	if _, err := fmt.Sscanf(logs[usageAt+len(offloadUsageMarker):], "%d", &report.UsagePercent); err != nil {
+		return nil, fmt.Errorf("invalid volume usage in output: %v", err)
+	}
+
+	if filesAt := strings.Index(logs, offloadFilesMarker); filesAt >= 0 && filesAt < usageAt {
+		var listing []rcloneListing
+		if err := json.Unmarshal([]byte(logs[filesAt+len(offloadFilesMarker):usageAt]), &listing); err != nil {
+			return nil, fmt.Errorf("invalid file listing in output: %v", err)
+		}
+		for _, file := range listing {
+			report.Files = append(report.Files, OffloadedFileReport{Path: file.Path, SizeBytes: file.Size, ModifiedAt: file.ModTime})
+		}
+	}
+	return report, nil
+}
+
+// buildOffloadJob runs an rclone script against one volume, mounted at /data
+func buildOffloadJob(name, namespace, claim, taskID, script string, env []corev1.EnvVar, image string) *batchv1.Job {
+	backoffLimit := int32(2)
+	labels := map[string]string{
+		ManagedByLabel:   ManagedByValue,
+		OffloadTaskLabel: taskID,
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "offload",
+							Image:   image,
+							Command: []string{"sh", "-c", script},
+							Env:     env,
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "data", MountPath: "/data"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
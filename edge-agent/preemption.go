@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Interval between preemption notice checks; GCP gives only 30 seconds of notice
+	PreemptionCheckInterval = 5 * time.Second
+
+	// Azure scheduled events, which announce spot evictions
+	azureScheduledEventsURL = "http://169.254.169.254/metadata/scheduledevents?api-version=2020-07-01"
+)
+
+// PreemptionNotice tells the orchestrator the provider is reclaiming this spot instance
+type PreemptionNotice struct {
+	Provider    string     `json:"provider"`
+	Action      string     `json:"action"`
+	TerminateAt *time.Time `json:"terminate_at,omitempty"`
+	DetectedAt  time.Time  `json:"detected_at"`
+}
+
+// startPreemptionWatch polls the instance metadata service of a spot instance for a
+// preemption notice and reports it, so the orchestrator moves the workloads away in time
+func (ea *EdgeAgent) startPreemptionWatch() {
+	metadata, err := collectCloudMetadata(ea.registrationCtx)
+	if err != nil {
+		ea.logger.Warnf("Not watching for preemption: %v", err)
+		return
+	}
+	if metadata.Lifecycle != "spot" {
+		return
+	}
+
+	check := map[string]func(context.Context, *http.Client) (*PreemptionNotice, error){
+		"aws":   awsPreemptionNotice,
+		"gcp":   gcpPreemptionNotice,
+		"azure": azurePreemptionNotice,
+	}[metadata.Provider]
+	if check == nil {
+		return
+	}
+
+	ea.logger.Infof("Watching for %s spot preemption notices", metadata.Provider)
+	client := &http.Client{Timeout: cloudMetadataTimeout}
+	ticker := time.NewTicker(PreemptionCheckInterval)
+	defer ticker.Stop()
+
+	var notice *PreemptionNotice
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if notice == nil {
+			notice, err = check(ea.registrationCtx, client)
+			if err != nil {
+				ea.logger.Debugf("Failed to check for preemption: %v", err)
+				continue
+			}
+			if notice == nil {
+				continue
+			}
+			notice.Provider = metadata.Provider
+			notice.DetectedAt = time.Now()
+			ea.logger.Warnf("Instance is being preempted (%s)", notice.Action)
+		}
+
+		// Repeated until the orchestrator has it; the instance is gone soon after
+		path := fmt.Sprintf("/api/v1/nodes/%s/preemption", ea.nodeID)
+		if err := ea.doRequest("POST", path, notice, nil); err != nil {
+			ea.logger.Errorf("Failed to report preemption: %v", err)
+			continue
+		}
+		return
+	}
+}
+
+// awsPreemptionNotice reads the spot instance action, which EC2 publishes two minutes
+// before it interrupts the instance
+func awsPreemptionNotice(ctx context.Context, client *http.Client) (*PreemptionNotice, error) {
+	token, err := metadataGet(ctx, client, "PUT", awsMetadataURL+"/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", awsMetadataURL+"/meta-data/spot/instance-action", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Not found until an interruption is scheduled
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("spot instance action returned status %d", resp.StatusCode)
+	}
+
+	var action struct {
+		Action string    `json:"action"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&action); err != nil {
+		return nil, fmt.Errorf("invalid spot instance action: %v", err)
+	}
+	return &PreemptionNotice{Action: action.Action, TerminateAt: &action.Time}, nil
+}
+
+// gcpPreemptionNotice reads whether Compute Engine has started preempting the instance,
+// which it stops 30 seconds later
+func gcpPreemptionNotice(ctx context.Context, client *http.Client) (*PreemptionNotice, error) {
+	preempted, err := metadataGet(ctx, client, "GET", gcpMetadataURL+"/preempted", map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(preempted, "TRUE") {
+		return nil, nil
+	}
+	terminateAt := time.Now().Add(30 * time.Second)
+	return &PreemptionNotice{Action: "stop", TerminateAt: &terminateAt}, nil
+}
+
+// azurePreemptionNotice reads the scheduled events for a spot eviction of this instance
+func azurePreemptionNotice(ctx context.Context, client *http.Client) (*PreemptionNotice, error) {
+	body, err := metadataGet(ctx, client, "GET", azureScheduledEventsURL, map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+
+	var scheduled struct {
+		Events []struct {
+			EventType string `json:"EventType"`
+			NotBefore string `json:"NotBefore"`
+		} `json:"Events"`
+	}
+	if err := json.Unmarshal([]byte(body), &scheduled); err != nil {
+		return nil, fmt.Errorf("invalid scheduled events: %v", err)
+	}
+
+	for _, event := range scheduled.Events {
+		if event.EventType != "Preempt" {
+			continue
+		}
+		notice := &PreemptionNotice{Action: "terminate"}
+		if notBefore, err := time.Parse(time.RFC1123, event.NotBefore); err == nil {
+			notice.TerminateAt = &notBefore
+		}
+		return notice, nil
+	}
+	return nil, nil
+}
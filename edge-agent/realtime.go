@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Capability advertised by nodes running a PREEMPT_RT kernel; must match the orchestrator's
+	RealtimeCapability = "realtime"
+
+	// Reads "1" on PREEMPT_RT kernels
+	realtimeSysfsPath = "/sys/kernel/realtime"
+	// Kernel build string, which names PREEMPT_RT on real-time kernels
+	kernelVersionPath = "/proc/sys/kernel/version"
+)
+
+// RealtimePolicy is how a real-time workload's threads are scheduled
+type RealtimePolicy struct {
+	// "fifo" or "rr"
+	Policy   string `json:"policy"`
+	Priority int32  `json:"priority"`
+}
+
+// realtimeKernel reports whether the node runs a PREEMPT_RT kernel
+func realtimeKernel() bool {
+	if data, err := os.ReadFile(realtimeSysfsPath); err == nil {
+		return strings.TrimSpace(string(data)) == "1"
+	}
+	data, err := os.ReadFile(kernelVersionPath)
+	return err == nil && strings.Contains(string(data), "PREEMPT_RT")
+}
+
+// detectRealtimeKernel adds the realtime capability on nodes running a PREEMPT_RT kernel.
+// The clusters of a multi-cluster agent run on other kernels and are left alone.
+func detectRealtimeKernel(config *Config, logger *logrus.Logger) {
+	if len(config.Clusters) > 0 || !realtimeKernel() {
+		return
+	}
+	if !containsString(config.Capabilities, RealtimeCapability) {
+		config.Capabilities = append(config.Capabilities, RealtimeCapability)
+	}
+	logger.Info("PREEMPT_RT kernel detected, advertising the realtime capability")
+}
+
+// realtimeContainer lets a real-time workload's container raise its threads to its
+// scheduling policy and priority, which it reads from EDGE_RT_POLICY and EDGE_RT_PRIORITY,
+// and lock its memory so page faults do not add latency
+func realtimeContainer(container *corev1.Container, realtime *RealtimePolicy) {
+	if realtime == nil {
+		return
+	}
+	policy := "SCHED_FIFO"
+	if realtime.Policy == "rr" {
+		policy = "SCHED_RR"
+	}
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "EDGE_RT_POLICY", Value: policy},
+		corev1.EnvVar{Name: "EDGE_RT_PRIORITY", Value: strconv.Itoa(int(realtime.Priority))},
+	)
+	container.SecurityContext = &corev1.SecurityContext{
+		Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"SYS_NICE", "IPC_LOCK"}},
+	}
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Hash of the workload spec an object was last applied from; objects whose hash matches
+// the desired state are left alone
+const SpecHashAnnotation = "edge-agent/spec-hash"
+
+// WorkloadPhase summarizes how a workload is doing on this node
+type WorkloadPhase string
+
+const (
+	WorkloadPhaseProgressing WorkloadPhase = "progressing"
+	WorkloadPhaseAvailable   WorkloadPhase = "available"
+	WorkloadPhaseCompleted   WorkloadPhase = "completed"
+	WorkloadPhaseFailed      WorkloadPhase = "failed"
+)
+
+// WorkloadStatusReport is the observed state of a workload, reported after every pass
+type WorkloadStatusReport struct {
+	Phase           WorkloadPhase `json:"phase"`
+	DesiredReplicas int32         `json:"desired_replicas"`
+	ReadyReplicas   int32         `json:"ready_replicas"`
+	Restarts        int32         `json:"restarts"`
+	Reason          string        `json:"reason,omitempty"`
+	Message         string        `json:"message,omitempty"`
+	ObservedAt      time.Time     `json:"observed_at"`
+}
+
+// failingWaitingReasons are container waiting reasons that will not clear on their own
+var failingWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// startWorkloadReconciliation runs the workloads assigned to this node: every interval
+// it applies the desired state to the cluster, removes workloads no longer assigned and
+// reports how each one is doing
+func (ea *EdgeAgent) startWorkloadReconciliation() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, workload reconciliation disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ea.logger.Info("Starting workload reconciliation")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.reconcileWorkloads(); err != nil {
+				ea.logger.Errorf("Failed to reconcile workloads: %v", err)
+			}
+		}
+	}
+}
+
+// reconcileWorkloads makes one pass over the desired state. A workload that fails to
+// apply is reported as failed and retried on the next pass.
+func (ea *EdgeAgent) reconcileWorkloads() error {
+	workloads, err := ea.fetchAssignments()
+	if err != nil {
+		return fmt.Errorf("failed to fetch assignments: %v", err)
+	}
+	ctx := ea.registrationCtx
+
+	desired := make(map[string]AssignedWorkload, len(workloads))
+	for _, workload := range workloads {
+		desired[workload.ID] = workload
+
+		var report WorkloadStatusReport
+		if err := ea.applyWorkload(ctx, workload); err != nil {
+			ea.logger.Errorf("Failed to apply workload %s: %v", workload.Name, err)
+			report = WorkloadStatusReport{Phase: WorkloadPhaseFailed, DesiredReplicas: workload.NodeReplicas, Reason: "ApplyFailed", Message: err.Error()}
+		} else if report, err = ea.workloadStatus(ctx, workload); err != nil {
+			ea.logger.Errorf("Failed to read status of workload %s: %v", workload.Name, err)
+			continue
+		}
+		report.ObservedAt = time.Now()
+
+		path := fmt.Sprintf("/api/v1/nodes/%s/workloads/%s/status", ea.nodeID, workload.ID)
+		if err := ea.doRequest("POST", path, report, nil); err != nil {
+			ea.logger.Errorf("Failed to report status of workload %s: %v", workload.Name, err)
+		}
+	}
+
+	objects, err := ea.managedObjects(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list running workloads: %v", err)
+	}
+	removed := make(map[string]bool)
+	for _, object := range objects {
+		workload, assigned := desired[object.workloadID]
+		switch {
+		case !assigned:
+			if removed[object.workloadID] {
+				continue
+			}
+			removed[object.workloadID] = true
+			ea.logger.Infof("Removing workload %s, no longer assigned to this node", object.workloadID)
+			if err := ea.deleteWorkload(ctx, object.workloadID); err != nil {
+				ea.logger.Errorf("Failed to remove workload %s: %v", object.workloadID, err)
+			}
+		case object.stale(workload):
+			// Left behind when the workload changed type, name or namespace
+			ea.logger.Infof("Removing %s %s/%s of workload %s, which no longer matches its spec",
+				object.kind, object.namespace, object.name, object.workloadID)
+			if err := ea.deleteManagedObject(ctx, object); err != nil {
+				ea.logger.Errorf("Failed to remove %s %s/%s: %v", object.kind, object.namespace, object.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyWorkload creates or updates the Kubernetes object that runs the workload
+func (ea *EdgeAgent) applyWorkload(ctx context.Context, workload AssignedWorkload) error {
+	if workload.Name == "" || workload.Image == "" {
+		return fmt.Errorf("workload spec has no name or image")
+	}
+	if workload.Namespace == "" {
+		workload.Namespace = "default"
+	}
+
+	hash, err := workloadSpecHash(workload)
+	if err != nil {
+		return err
+	}
+	template, err := buildPodTemplate(workload)
+	if err != nil {
+		return err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      workload.Name,
+		Namespace: workload.Namespace,
+		Labels: map[string]string{
+			ManagedByLabel:  ManagedByValue,
+			WorkloadIDLabel: workload.ID,
+		},
+		Annotations: map[string]string{SpecHashAnnotation: hash},
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{WorkloadIDLabel: workload.ID}}
+	replicas := workload.NodeReplicas
+
+	switch workload.Type {
+	case "", "deployment":
+		deployments := ea.kubeClient.AppsV1().Deployments(workload.Namespace)
+		desired := &appsv1.Deployment{
+			ObjectMeta: meta,
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Selector: selector, Template: template},
+		}
+		existing, err := deployments.Get(ctx, desired.Name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			ea.logger.Infof("Creating deployment %s/%s", desired.Namespace, desired.Name)
+			_, err = deployments.Create(ctx, desired, metav1.CreateOptions{})
+		case err != nil, existing.DeletionTimestamp != nil:
+			// Errors are returned below; objects being deleted are created again later
+		case !managedByAgent(existing):
+			err = notManagedError("deployment", existing)
+		case selectorChanged(existing.Spec.Selector, selector):
+			ea.logger.Infof("Recreating deployment %s/%s for its new selector", desired.Namespace, desired.Name)
+			err = deployments.Delete(ctx, desired.Name, deleteInBackground())
+		case existing.Annotations[SpecHashAnnotation] != hash:
+			existing.Labels, existing.Annotations = desired.Labels, desired.Annotations
+			existing.Spec.Replicas = desired.Spec.Replicas
+			existing.Spec.Template = desired.Spec.Template
+			_, err = deployments.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply deployment: %v", err)
+		}
+	case "statefulset":
+		statefulSets := ea.kubeClient.AppsV1().StatefulSets(workload.Namespace)
+		desired := &appsv1.StatefulSet{
+			ObjectMeta: meta,
+			Spec: appsv1.StatefulSetSpec{
+				Replicas:    &replicas,
+				Selector:    selector,
+				Template:    template,
+				ServiceName: workload.Name,
+			},
+		}
+		existing, err := statefulSets.Get(ctx, desired.Name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			ea.logger.Infof("Creating statefulset %s/%s", desired.Namespace, desired.Name)
+			_, err = statefulSets.Create(ctx, desired, metav1.CreateOptions{})
+		case err != nil, existing.DeletionTimestamp != nil:
+		case !managedByAgent(existing):
+			err = notManagedError("statefulset", existing)
+		case selectorChanged(existing.Spec.Selector, selector):
+			ea.logger.Infof("Recreating statefulset %s/%s for its new selector", desired.Namespace, desired.Name)
+			err = statefulSets.Delete(ctx, desired.Name, deleteInBackground())
+		case existing.Annotations[SpecHashAnnotation] != hash:
+			existing.Labels, existing.Annotations = desired.Labels, desired.Annotations
+			existing.Spec.Replicas = desired.Spec.Replicas
+			existing.Spec.Template = desired.Spec.Template
+			_, err = statefulSets.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply statefulset: %v", err)
+		}
+	case "daemonset":
+		daemonSets := ea.kubeClient.AppsV1().DaemonSets(workload.Namespace)
+		desired := &appsv1.DaemonSet{
+			ObjectMeta: meta,
+			Spec:       appsv1.DaemonSetSpec{Selector: selector, Template: template},
+		}
+		existing, err := daemonSets.Get(ctx, desired.Name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			ea.logger.Infof("Creating daemonset %s/%s", desired.Namespace, desired.Name)
+			_, err = daemonSets.Create(ctx, desired, metav1.CreateOptions{})
+		case err != nil, existing.DeletionTimestamp != nil:
+		case !managedByAgent(existing):
+			err = notManagedError("daemonset", existing)
+		case selectorChanged(existing.Spec.Selector, selector):
+			ea.logger.Infof("Recreating daemonset %s/%s for its new selector", desired.Namespace, desired.Name)
+			err = daemonSets.Delete(ctx, desired.Name, deleteInBackground())
+		case existing.Annotations[SpecHashAnnotation] != hash:
+			existing.Labels, existing.Annotations = desired.Labels, desired.Annotations
+			existing.Spec.Template = desired.Spec.Template
+			_, err = daemonSets.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply daemonset: %v", err)
+		}
+	case "job":
+		jobs := ea.kubeClient.BatchV1().Jobs(workload.Namespace)
+		template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+		// The orchestrator retries failed runs itself, possibly on another node
+		backoffLimit := int32(0)
+		desired := &batchv1.Job{
+			ObjectMeta: meta,
+			Spec: batchv1.JobSpec{
+				Completions:  &replicas,
+				Parallelism:  &replicas,
+				BackoffLimit: &backoffLimit,
+				Template:     template,
+			},
+		}
+		existing, err := jobs.Get(ctx, desired.Name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			ea.logger.Infof("Creating job %s/%s", desired.Namespace, desired.Name)
+			_, err = jobs.Create(ctx, desired, metav1.CreateOptions{})
+		case err != nil, existing.DeletionTimestamp != nil:
+		case !managedByAgent(existing):
+			err = notManagedError("job", existing)
+		case existing.Annotations[SpecHashAnnotation] != hash:
+			// A job's pod template cannot change; it is recreated on the next pass once
+			// the old one is gone
+			ea.logger.Infof("Recreating job %s/%s for its new spec", desired.Namespace, desired.Name)
+			err = jobs.Delete(ctx, desired.Name, deleteInBackground())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply job: %v", err)
+		}
+	default:
+		return fmt.Errorf("workload type %q is not supported by the agent", workload.Type)
+	}
+	return nil
+}
+
+// managedByAgent reports whether the agent created an object. Objects of a workload's name
+// that something else created are left alone rather than taken over.
+func managedByAgent(object metav1.Object) bool {
+	return object.GetLabels()[ManagedByLabel] == ManagedByValue
+}
+
+func notManagedError(kind string, object metav1.Object) error {
+	return fmt.Errorf("%s %s/%s exists and is not managed by the edge agent", kind, object.GetNamespace(), object.GetName())
+}
+
+// selectorChanged reports whether an object selects other pods than desired. Selectors
+// cannot be updated, so the object is deleted and created again on a later pass once the
+// old one is gone.
+func selectorChanged(existing, desired *metav1.LabelSelector) bool {
+	return !equality.Semantic.DeepEqual(existing, desired)
+}
+
+// workloadStatus reads back the object applyWorkload created, and its pods for image
+// pull failures and crash loops
+func (ea *EdgeAgent) workloadStatus(ctx context.Context, workload AssignedWorkload) (WorkloadStatusReport, error) {
+	report, err := ea.objectStatus(ctx, workload)
+	if err != nil || report.Phase == WorkloadPhaseCompleted {
+		return report, err
+	}
+
+	namespace := workload.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	pods, err := ea.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: workloadSelector(workload.ID)})
+	if err != nil {
+		return report, fmt.Errorf("failed to list pods: %v", err)
+	}
+	for _, pod := range pods.Items {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+				report.Phase, report.Reason, report.Message = WorkloadPhaseFailed, condition.Reason, condition.Message
+			}
+		}
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			report.Restarts += status.RestartCount
+			if waiting := status.State.Waiting; waiting != nil && failingWaitingReasons[waiting.Reason] {
+				report.Phase, report.Reason, report.Message = WorkloadPhaseFailed, waiting.Reason, waiting.Message
+			}
+		}
+	}
+	return report, nil
+}
+
+// objectStatus reports readiness from the workload's Deployment, StatefulSet,
+// DaemonSet or Job
+func (ea *EdgeAgent) objectStatus(ctx context.Context, workload AssignedWorkload) (WorkloadStatusReport, error) {
+	namespace := workload.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	report := WorkloadStatusReport{DesiredReplicas: workload.NodeReplicas}
+
+	switch workload.Type {
+	case "", "deployment":
+		deployment, err := ea.kubeClient.AppsV1().Deployments(namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return report, err
+		}
+		report.ReadyReplicas = deployment.Status.ReadyReplicas
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse {
+				report.Phase, report.Reason, report.Message = WorkloadPhaseFailed, condition.Reason, condition.Message
+				return report, nil
+			}
+		}
+	case "statefulset":
+		statefulSet, err := ea.kubeClient.AppsV1().StatefulSets(namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return report, err
+		}
+		report.ReadyReplicas = statefulSet.Status.ReadyReplicas
+	case "daemonset":
+		daemonSet, err := ea.kubeClient.AppsV1().DaemonSets(namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return report, err
+		}
+		report.DesiredReplicas = daemonSet.Status.DesiredNumberScheduled
+		report.ReadyReplicas = daemonSet.Status.NumberReady
+	case "job":
+		job, err := ea.kubeClient.BatchV1().Jobs(namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return report, err
+		}
+		// The job of an earlier attempt is still being replaced; its outcome was reported
+		workload.Namespace = namespace
+		if hash, err := workloadSpecHash(workload); err != nil || job.Annotations[SpecHashAnnotation] != hash {
+			report.Phase = WorkloadPhaseProgressing
+			return report, err
+		}
+		report.ReadyReplicas = job.Status.Succeeded
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				report.Phase = WorkloadPhaseCompleted
+				return report, nil
+			case batchv1.JobFailed:
+				report.Phase, report.Reason, report.Message = WorkloadPhaseFailed, condition.Reason, condition.Message
+				return report, nil
+			}
+		}
+		report.Phase = WorkloadPhaseProgressing
+		return report, nil
+	}
+
+	if report.ReadyReplicas >= report.DesiredReplicas {
+		report.Phase = WorkloadPhaseAvailable
+	} else {
+		report.Phase = WorkloadPhaseProgressing
+	}
+	return report, nil
+}
+
+// workloadSpecHash hashes the parts of the workload that shape its object
+func workloadSpecHash(workload AssignedWorkload) (string, error) {
+	// Only the service follows a traffic switch
+	workload.ServiceSelector = nil
+	data, err := json.Marshal(workload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal workload spec: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// buildPodTemplate runs the workload image as a single container. Pods carry the
+// workload's labels and selector so its Service selects them.
+func buildPodTemplate(workload AssignedWorkload) (corev1.PodTemplateSpec, error) {
+	resources, err := resourceRequirements(workload.Resources)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+
+	podLabels := map[string]string{}
+	for key, value := range workload.Labels {
+		podLabels[key] = value
+	}
+	for key, value := range workload.Selector {
+		podLabels[key] = value
+	}
+	podLabels[ManagedByLabel] = ManagedByValue
+	podLabels[WorkloadIDLabel] = workload.ID
+
+	names := make([]string, 0, len(workload.Environment))
+	for name := range workload.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]corev1.EnvVar, 0, len(names))
+	for _, name := range names {
+		env = append(env, corev1.EnvVar{Name: name, Value: workload.Environment[name]})
+	}
+	if shareEnv, ok := gpuShareEnv(workload.Resources.GPU); ok {
+		if _, set := workload.Environment[shareEnv.Name]; !set {
+			env = append(env, shareEnv)
+		}
+	}
+
+	ports := make([]corev1.ContainerPort, 0, len(workload.Ports))
+	for _, p := range workload.Ports {
+		containerPort := p.TargetPort
+		if containerPort == 0 {
+			containerPort = p.Port
+		}
+		ports = append(ports, corev1.ContainerPort{
+			Name:          p.Name,
+			ContainerPort: containerPort,
+			Protocol:      corev1.Protocol(p.Protocol),
+		})
+	}
+
+	container := corev1.Container{
+		Name:           workload.Name,
+		Image:          workload.Image,
+		Env:            env,
+		Ports:          ports,
+		Resources:      resources,
+		ReadinessProbe: readinessProbe(workload.ReadinessProbe),
+	}
+	realtimeContainer(&container, workload.Realtime)
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{container},
+		},
+	}, nil
+}
+
+// readinessProbe renders a workload's readiness probe for its container: an HTTP GET when
+// it has a path, a TCP connect otherwise
+func readinessProbe(probe *Probe) *corev1.Probe {
+	if probe == nil {
+		return nil
+	}
+	port := intstr.FromInt(int(probe.Port))
+	handler := corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: port}}
+	if probe.HTTPPath != "" {
+		handler = corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: probe.HTTPPath, Port: port}}
+	}
+	return &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		FailureThreshold:    probe.FailureThreshold,
+	}
+}
+
+// workloadSelector selects the objects the agent created for a workload
+func workloadSelector(workloadID string) string {
+	return labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedByValue, WorkloadIDLabel: workloadID}).String()
+}
+
+// deleteInBackground also removes the pods of deleted jobs, which are otherwise orphaned
+func deleteInBackground() metav1.DeleteOptions {
+	propagation := metav1.DeletePropagationBackground
+	return metav1.DeleteOptions{PropagationPolicy: &propagation}
+}
+
+// deleteWorkload removes every object the agent created for a workload
+func (ea *EdgeAgent) deleteWorkload(ctx context.Context, workloadID string) error {
+	options := metav1.ListOptions{LabelSelector: workloadSelector(workloadID)}
+	apps := ea.kubeClient.AppsV1()
+
+	deployments, err := apps.Deployments("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for _, deployment := range deployments.Items {
+		if err := apps.Deployments(deployment.Namespace).Delete(ctx, deployment.Name, deleteInBackground()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete deployment %s/%s: %v", deployment.Namespace, deployment.Name, err)
+		}
+	}
+
+	statefulSets, err := apps.StatefulSets("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list statefulsets: %v", err)
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if err := apps.StatefulSets(statefulSet.Namespace).Delete(ctx, statefulSet.Name, deleteInBackground()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete statefulset %s/%s: %v", statefulSet.Namespace, statefulSet.Name, err)
+		}
+	}
+
+	daemonSets, err := apps.DaemonSets("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list daemonsets: %v", err)
+	}
+	for _, daemonSet := range daemonSets.Items {
+		if err := apps.DaemonSets(daemonSet.Namespace).Delete(ctx, daemonSet.Name, deleteInBackground()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete daemonset %s/%s: %v", daemonSet.Namespace, daemonSet.Name, err)
+		}
+	}
+
+	jobs, err := ea.kubeClient.BatchV1().Jobs("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %v", err)
+	}
+	for _, job := range jobs.Items {
+		if err := ea.kubeClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, deleteInBackground()); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete job %s/%s: %v", job.Namespace, job.Name, err)
+		}
+	}
+
+	services, err := ea.kubeClient.CoreV1().Services("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, service := range services.Items {
+		if err := ea.kubeClient.CoreV1().Services(service.Namespace).Delete(ctx, service.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service %s/%s: %v", service.Namespace, service.Name, err)
+		}
+	}
+	return nil
+}
+
+// managedObject is an object the agent created for a workload
+type managedObject struct {
+	kind       string
+	namespace  string
+	name       string
+	workloadID string
+}
+
+// workloadKinds maps the workload types the agent runs to the kind of their object
+var workloadKinds = map[string]string{
+	"":            "deployment",
+	"deployment":  "deployment",
+	"statefulset": "statefulset",
+	"daemonset":   "daemonset",
+	"job":         "job",
+}
+
+// stale reports whether an object no longer matches its workload's spec. Workloads of
+// types the agent does not run keep their objects.
+func (o managedObject) stale(workload AssignedWorkload) bool {
+	kind, supported := workloadKinds[workload.Type]
+	if !supported {
+		return false
+	}
+	namespace := workload.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	if o.namespace != namespace || o.name != workload.Name {
+		return true
+	}
+	// Services go with workloads that declare ports
+	if o.kind == "service" {
+		return len(workload.Ports) == 0
+	}
+	return o.kind != kind
+}
+
+// managedObjects lists the objects the agent created for workloads
+func (ea *EdgeAgent) managedObjects(ctx context.Context) ([]managedObject, error) {
+	selector := labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedByValue}).String() + "," + WorkloadIDLabel
+	options := metav1.ListOptions{LabelSelector: selector}
+	var objects []managedObject
+	add := func(kind string, object metav1.Object) {
+		objects = append(objects, managedObject{kind: kind, namespace: object.GetNamespace(), name: object.GetName(),
+			workloadID: object.GetLabels()[WorkloadIDLabel]})
+	}
+
+	deployments, err := ea.kubeClient.AppsV1().Deployments("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		add("deployment", &deployments.Items[i])
+	}
+
+	statefulSets, err := ea.kubeClient.AppsV1().StatefulSets("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		add("statefulset", &statefulSets.Items[i])
+	}
+
+	daemonSets, err := ea.kubeClient.AppsV1().DaemonSets("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		add("daemonset", &daemonSets.Items[i])
+	}
+
+	jobs, err := ea.kubeClient.BatchV1().Jobs("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		add("job", &jobs.Items[i])
+	}
+
+	services, err := ea.kubeClient.CoreV1().Services("").List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for i := range services.Items {
+		add("service", &services.Items[i])
+	}
+	return objects, nil
+}
+
+// deleteManagedObject removes one object the agent created
+func (ea *EdgeAgent) deleteManagedObject(ctx context.Context, object managedObject) error {
+	var err error
+	switch object.kind {
+	case "deployment":
+		err = ea.kubeClient.AppsV1().Deployments(object.namespace).Delete(ctx, object.name, deleteInBackground())
+	case "statefulset":
+		err = ea.kubeClient.AppsV1().StatefulSets(object.namespace).Delete(ctx, object.name, deleteInBackground())
+	case "daemonset":
+		err = ea.kubeClient.AppsV1().DaemonSets(object.namespace).Delete(ctx, object.name, deleteInBackground())
+	case "job":
+		err = ea.kubeClient.BatchV1().Jobs(object.namespace).Delete(ctx, object.name, deleteInBackground())
+	case "service":
+		err = ea.kubeClient.CoreV1().Services(object.namespace).Delete(ctx, object.name, metav1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// runningWorkloads returns the IDs of the workloads the agent deployed
+func (ea *EdgeAgent) runningWorkloads(ctx context.Context) ([]string, error) {
+	objects, err := ea.managedObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, object := range objects {
+		// A workload whose only object left is its service no longer runs
+		if object.kind != "service" {
+			ids[object.workloadID] = true
+		}
+	}
+
+	running := make([]string, 0, len(ids))
+	for id := range ids {
+		running = append(running, id)
+	}
+	sort.Strings(running)
+	return running, nil
+}
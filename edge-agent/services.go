@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// Label applied to every object the agent manages on the edge cluster
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "edge-agent"
+
+	// Label tying objects to the orchestrator workload they belong to
+	WorkloadIDLabel = "workload-id"
+)
+
+type WorkloadPort struct {
+	Name       string `json:"name"`
+	Port       int32  `json:"port"`
+	TargetPort int32  `json:"target_port"`
+	NodePort   int32  `json:"node_port,omitempty"`
+	Protocol   string `json:"protocol"`
+}
+
+// Probe describes how to check that a workload replica is ready, against the container
+// port Port
+type Probe struct {
+	HTTPPath            string `json:"http_path,omitempty"`
+	Port                int32  `json:"port"`
+	InitialDelaySeconds int32  `json:"initial_delay_seconds"`
+	PeriodSeconds       int32  `json:"period_seconds"`
+	TimeoutSeconds      int32  `json:"timeout_seconds"`
+	FailureThreshold    int32  `json:"failure_threshold"`
+}
+
+type ServiceEndpoint struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+	// Kubernetes type of the service exposing it: ClusterIP, NodePort or LoadBalancer
+	Type string `json:"type,omitempty"`
+}
+
+// AssignedWorkload is the subset of the orchestrator's workload spec the agent acts on
+type AssignedWorkload struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Type        string            `json:"type"`
+	Image       string            `json:"image"`
+	Environment map[string]string `json:"environment"`
+	Labels      map[string]string `json:"labels"`
+	Resources   WorkloadResources `json:"resources"`
+	Selector    map[string]string `json:"selector"`
+	Ports       []WorkloadPort    `json:"ports"`
+	ServiceType string            `json:"service_type"`
+	// Scheduling of a real-time workload's threads on a PREEMPT_RT node
+	Realtime *RealtimePolicy `json:"realtime,omitempty"`
+	// Pods the service selects instead of the workload's own, while a blue-green
+	// deployment's new version serves its traffic
+	ServiceSelector map[string]string `json:"service_selector,omitempty"`
+	// Checked on the workload's container; replicas only count as available once they pass it
+	ReadinessProbe *Probe `json:"readiness_probe,omitempty"`
+	// Replicas of the workload on this node
+	NodeReplicas int32 `json:"node_replicas"`
+	// Run of a job on this node; the orchestrator counts up on each retry, which changes
+	// the spec hash so the job is recreated
+	NodeAttempt int32 `json:"node_attempt,omitempty"`
+}
+
+type EndpointReportRequest struct {
+	Endpoints []ServiceEndpoint `json:"endpoints"`
+}
+
+func (ea *EdgeAgent) startServiceSync() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, service sync disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ea.logger.Info("Starting service sync")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.syncServices(); err != nil {
+				ea.logger.Errorf("Failed to sync services: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) fetchAssignments() ([]AssignedWorkload, error) {
+	document, err := ea.syncDesiredState()
+	if err != nil {
+		return nil, err
+	}
+	return desiredWorkloads(document)
+}
+
+// syncServices ensures a Service exists for every assigned workload that declares ports
+// and reports the resulting node-level endpoints back to the orchestrator
+func (ea *EdgeAgent) syncServices() error {
+	workloads, err := ea.fetchAssignments()
+	if err != nil {
+		return fmt.Errorf("failed to fetch assignments: %v", err)
+	}
+
+	for _, workload := range workloads {
+		if len(workload.Ports) == 0 {
+			continue
+		}
+
+		service, err := ea.ensureService(ea.registrationCtx, workload)
+		if err != nil {
+			ea.logger.Errorf("Failed to ensure service for workload %s: %v", workload.Name, err)
+			continue
+		}
+
+		report := EndpointReportRequest{Endpoints: ea.serviceEndpoints(service)}
+		path := fmt.Sprintf("/api/v1/nodes/%s/workloads/%s/endpoints", ea.nodeID, workload.ID)
+		if err := ea.doRequest("POST", path, report, nil); err != nil {
+			ea.logger.Errorf("Failed to report endpoints for workload %s: %v", workload.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (ea *EdgeAgent) ensureService(ctx context.Context, workload AssignedWorkload) (*corev1.Service, error) {
+	desired := buildService(workload)
+	services := ea.kubeClient.CoreV1().Services(desired.Namespace)
+
+	existing, err := services.Get(ctx, desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		ea.logger.Infof("Creating service %s/%s", desired.Namespace, desired.Name)
+		return services.Create(ctx, desired, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	existing.Labels = desired.Labels
+	existing.Spec.Type = desired.Spec.Type
+	existing.Spec.Selector = desired.Spec.Selector
+	existing.Spec.Ports = desired.Spec.Ports
+	return services.Update(ctx, existing, metav1.UpdateOptions{})
+}
+
+func buildService(workload AssignedWorkload) *corev1.Service {
+	serviceType := corev1.ServiceType(workload.ServiceType)
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+
+	ports := make([]corev1.ServicePort, 0, len(workload.Ports))
+	for _, p := range workload.Ports {
+		port := corev1.ServicePort{
+			Name:       p.Name,
+			Port:       p.Port,
+			TargetPort: intstr.FromInt(int(p.TargetPort)),
+			Protocol:   corev1.Protocol(p.Protocol),
+		}
+		if serviceType != corev1.ServiceTypeClusterIP {
+			port.NodePort = p.NodePort
+		}
+		ports = append(ports, port)
+	}
+
+	selector := workload.Selector
+	if len(workload.ServiceSelector) > 0 {
+		selector = workload.ServiceSelector
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      workload.Name,
+			Namespace: workload.Namespace,
+			Labels: map[string]string{
+				ManagedByLabel:  ManagedByValue,
+				WorkloadIDLabel: workload.ID,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: selector,
+			Ports:    ports,
+		},
+	}
+}
+
+// serviceEndpoints derives the addresses at which a service is reachable from outside the node
+func (ea *EdgeAgent) serviceEndpoints(service *corev1.Service) []ServiceEndpoint {
+	var endpoints []ServiceEndpoint
+
+	for _, port := range service.Spec.Ports {
+		switch service.Spec.Type {
+		case corev1.ServiceTypeLoadBalancer:
+			for _, ingress := range service.Status.LoadBalancer.Ingress {
+				address := ingress.IP
+				if address == "" {
+					address = ingress.Hostname
+				}
+				endpoints = append(endpoints, ServiceEndpoint{
+					Name:     port.Name,
+					Address:  address,
+					Port:     port.Port,
+					Protocol: string(port.Protocol),
+					Type:     string(service.Spec.Type),
+				})
+			}
+		case corev1.ServiceTypeNodePort:
+			endpoints = append(endpoints, ServiceEndpoint{
+				Name:     port.Name,
+				Address:  ea.config.NodeAddress,
+				Port:     port.NodePort,
+				Protocol: string(port.Protocol),
+				Type:     string(service.Spec.Type),
+			})
+		default:
+			endpoints = append(endpoints, ServiceEndpoint{
+				Name:     port.Name,
+				Address:  service.Spec.ClusterIP,
+				Port:     port.Port,
+				Protocol: string(port.Protocol),
+				Type:     string(service.Spec.Type),
+			})
+		}
+	}
+
+	return endpoints
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSiteGatewayPort = 7070
+
+	// Capability and label an eligible agent registers with; they must match the orchestrator's
+	SiteGatewayCapability = "site-gateway"
+	SiteGatewayPortLabel  = "site-gateway.edge.io/port"
+
+	// How often an agent asks which agent is its site's gateway
+	SiteGatewayRefreshInterval = 5 * time.Minute
+	// How long a peer waits for the gateway before sending directly
+	SiteGatewayPeerTimeout = 5 * time.Second
+)
+
+// SiteGatewayConfig sends the heartbeats of a site's agents through one of them, the
+// gateway the orchestrator elects, so the site keeps one heartbeat connection over the WAN
+type SiteGatewayConfig struct {
+	// This agent may be elected gateway and serve its peers' heartbeats
+	Eligible bool `yaml:"eligible"`
+	// LAN port the gateway serves peer heartbeats on; 7070 when unset
+	Port int `yaml:"port"`
+}
+
+// siteGatewayAssignment is the orchestrator's answer to which agent is the site's gateway
+type siteGatewayAssignment struct {
+	NodeID  string   `json:"node_id"`
+	Address string   `json:"address"`
+	Secret  string   `json:"secret"`
+	Members []string `json:"members,omitempty"`
+}
+
+// siteGateway is the agent's side of its site's gateway: where to send heartbeats as a
+// peer and, while this agent is the gateway, the peer heartbeats awaiting forwarding
+type siteGateway struct {
+	assignment *siteGatewayAssignment
+	refreshAt  time.Time
+	serving    bool
+	// Latest heartbeat of each peer, forwarded with the gateway's next one
+	pending map[string]NodeHeartbeatEntry
+	// Outcome of each peer's last forwarded heartbeat
+	results map[string]BatchHeartbeatResult
+	mutex   sync.Mutex
+}
+
+func newSiteGateway() *siteGateway {
+	return &siteGateway{
+		pending: make(map[string]NodeHeartbeatEntry),
+		results: make(map[string]BatchHeartbeatResult),
+	}
+}
+
+// validateSiteGateway applies the site gateway defaults and advertises an eligible agent
+func validateSiteGateway(config *Config) error {
+	gateway := config.SiteGateway
+	if gateway == nil {
+		return nil
+	}
+	if config.SiteID == "" {
+		return fmt.Errorf("site_gateway requires site_id")
+	}
+	if len(config.Clusters) > 0 {
+		return fmt.Errorf("site_gateway is not supported with clusters, whose heartbeats are already batched")
+	}
+	if gateway.Port == 0 {
+		gateway.Port = DefaultSiteGatewayPort
+	}
+	if gateway.Port < 0 || gateway.Port > 65535 {
+		return fmt.Errorf("site_gateway port %d is out of range", gateway.Port)
+	}
+	if gateway.Eligible {
+		if !containsString(config.Capabilities, SiteGatewayCapability) {
+			config.Capabilities = append(config.Capabilities, SiteGatewayCapability)
+		}
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		config.Labels[SiteGatewayPortLabel] = strconv.Itoa(gateway.Port)
+	}
+	return nil
+}
+
+// sendSiteHeartbeat sends a heartbeat through the site's gateway, or forwards the peers'
+// heartbeats with this agent's own when it is the gateway. It reports false when there is
+// no gateway or it could not be reached, and the heartbeat should be sent directly.
+func (ea *EdgeAgent) sendSiteHeartbeat(req HeartbeatRequest) (bool, error) {
+	assignment := ea.siteGatewayAssignment()
+	if assignment == nil {
+		return false, nil
+	}
+
+	entry := NodeHeartbeatEntry{
+		NodeID:        ea.nodeID,
+		Status:        req.Status,
+		Resources:     req.Resources,
+		Timestamp:     req.Timestamp,
+		Latency:       req.Latency,
+		LogSummaries:  req.LogSummaries,
+		CustomMetrics: req.CustomMetrics,
+	}
+
+	if assignment.NodeID == ea.nodeID {
+		if err := ea.forwardSiteHeartbeats(entry); err != nil {
+			ea.logger.Warnf("Failed to forward site heartbeats: %v", err)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	if err := ea.sendToSiteGateway(entry, assignment); err != nil {
+		ea.logger.Warnf("Site gateway %s unreachable, sending heartbeat directly: %v", assignment.Address, err)
+		ea.gateway.mutex.Lock()
+		ea.gateway.refreshAt = time.Time{}
+		ea.gateway.mutex.Unlock()
+		return false, nil
+	}
+	return true, nil
+}
+
+// siteGatewayAssignment returns the site's gateway, asking the orchestrator when the last
+// answer is due for a refresh; it starts serving peers once this agent is elected
+func (ea *EdgeAgent) siteGatewayAssignment() *siteGatewayAssignment {
+	gateway := ea.gateway
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(gateway.refreshAt) {
+		return gateway.assignment
+	}
+	gateway.refreshAt = now.Add(SiteGatewayRefreshInterval)
+
+	var resp struct {
+		Gateway *siteGatewayAssignment `json:"gateway"`
+	}
+	if err := ea.doRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/site-gateway", ea.nodeID), nil, &resp); err != nil {
+		ea.logger.Warnf("Failed to look up site gateway: %v", err)
+		gateway.assignment = nil
+		return nil
+	}
+
+	previous := gateway.assignment
+	gateway.assignment = resp.Gateway
+	switch {
+	case resp.Gateway == nil:
+		if previous != nil {
+			ea.logger.Info("Site has no heartbeat gateway; sending heartbeats directly")
+		}
+	case resp.Gateway.NodeID == ea.nodeID:
+		if previous == nil || previous.NodeID != ea.nodeID {
+			ea.logger.Infof("Elected heartbeat gateway for %d peers", len(resp.Gateway.Members))
+		}
+		if !gateway.serving {
+			gateway.serving = true
+			go ea.serveSiteGateway()
+		}
+	case previous == nil || previous.NodeID != resp.Gateway.NodeID:
+		ea.logger.Infof("Sending heartbeats through site gateway %s", resp.Gateway.Address)
+	}
+	return gateway.assignment
+}
+
+// sendToSiteGateway hands a heartbeat to the site's gateway over the LAN
+func (ea *EdgeAgent) sendToSiteGateway(entry NodeHeartbeatEntry, assignment *siteGatewayAssignment) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ea.registrationCtx, SiteGatewayPeerTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://"+assignment.Address+"/site-gateway/heartbeat", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+assignment.Secret)
+
+	resp, err := ea.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var result BatchHeartbeatResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	if !result.Accepted {
+		return fmt.Errorf("heartbeat rejected: %s", result.Error)
+	}
+	ea.setDesiredStateHint(result.DesiredStateHash)
+	return nil
+}
+
+// forwardSiteHeartbeats sends the gateway's own heartbeat together with the peers' latest
+func (ea *EdgeAgent) forwardSiteHeartbeats(own NodeHeartbeatEntry) error {
+	gateway := ea.gateway
+	gateway.mutex.Lock()
+	req := BatchHeartbeatRequest{Heartbeats: []NodeHeartbeatEntry{own}, SiteGatewayID: ea.nodeID}
+	// A peer that has gone quiet is not reported alive on the strength of an old heartbeat
+	staleBefore := time.Now().Add(-2 * ea.config.HeartbeatInterval)
+	for nodeID, entry := range gateway.pending {
+		if entry.Timestamp.After(staleBefore) {
+			req.Heartbeats = append(req.Heartbeats, entry)
+		}
+		delete(gateway.pending, nodeID)
+	}
+	gateway.mutex.Unlock()
+
+	var resp BatchHeartbeatResponse
+	if err := ea.doRequest("POST", "/api/v1/nodes/heartbeats", req, &resp); err != nil {
+		return err
+	}
+
+	gateway.mutex.Lock()
+	for _, entry := range req.Heartbeats[1:] {
+		result, exists := resp.Results[entry.NodeID]
+		if !exists {
+			result = BatchHeartbeatResult{Error: "no result from orchestrator"}
+		}
+		gateway.results[entry.NodeID] = result
+	}
+	gateway.mutex.Unlock()
+
+	result := resp.Results[ea.nodeID]
+	if !result.Accepted {
+		return fmt.Errorf("heartbeat rejected: %s", result.Error)
+	}
+	ea.setDesiredStateHint(result.DesiredStateHash)
+	if len(req.Heartbeats) > 1 {
+		ea.logger.Debugf("Forwarded heartbeats of %d peers", len(req.Heartbeats)-1)
+	}
+	return nil
+}
+
+// serveSiteGateway accepts peer heartbeats over TLS on the LAN, with the node certificate
+// when configured and a self-signed one otherwise
+func (ea *EdgeAgent) serveSiteGateway() {
+	cert, err := ea.siteGatewayCertificate()
+	if err != nil {
+		ea.logger.Errorf("Failed to serve site gateway: %v", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/site-gateway/heartbeat", ea.handlePeerHeartbeat)
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(ea.config.SiteGateway.Port),
+		Handler:           mux,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}},
+		ReadHeaderTimeout: SiteGatewayPeerTimeout,
+	}
+	go func() {
+		<-ea.registrationCtx.Done()
+		server.Close()
+	}()
+
+	ea.logger.Infof("Serving site gateway on port %d", ea.config.SiteGateway.Port)
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		ea.logger.Errorf("Site gateway stopped: %v", err)
+	}
+}
+
+// handlePeerHeartbeat queues a peer's heartbeat for the next forward and answers with the
+// outcome of its previous one
+func (ea *EdgeAgent) handlePeerHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gateway := ea.gateway
+	gateway.mutex.Lock()
+	assignment := gateway.assignment
+	gateway.mutex.Unlock()
+	if assignment == nil || assignment.NodeID != ea.nodeID {
+		http.Error(w, "not the site gateway", http.StatusConflict)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(assignment.Secret)) != 1 {
+		http.Error(w, "invalid site gateway secret", http.StatusUnauthorized)
+		return
+	}
+
+	var entry NodeHeartbeatEntry
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&entry); err != nil {
+		http.Error(w, fmt.Sprintf("invalid heartbeat: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !containsString(assignment.Members, entry.NodeID) {
+		http.Error(w, "node is not at this site", http.StatusForbidden)
+		return
+	}
+
+	gateway.mutex.Lock()
+	gateway.pending[entry.NodeID] = entry
+	result, exists := gateway.results[entry.NodeID]
+	gateway.mutex.Unlock()
+	if !exists {
+		// Not forwarded yet
+		result = BatchHeartbeatResult{Accepted: true}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// siteGatewayCertificate returns the certificate the gateway serves peers with
+func (ea *EdgeAgent) siteGatewayCertificate() (tls.Certificate, error) {
+	if ea.config.TLSCertPath != "" && ea.config.TLSKeyPath != "" {
+		return tls.LoadX509KeyPair(ea.config.TLSCertPath, ea.config.TLSKeyPath)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: ea.config.NodeName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// containsString reports whether a value is among the values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
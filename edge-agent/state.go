@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultStateFile = "/var/lib/edge-agent/state.json"
+)
+
+// LocalState is what the running agent records on disk for the local diagnostic commands
+type LocalState struct {
+	PID                int       `json:"pid"`
+	NodeID             string    `json:"node_id"`
+	NodeName           string    `json:"node_name"`
+	OrchestratorURL    string    `json:"orchestrator_url"`
+	StartedAt          time.Time `json:"started_at"`
+	RegisteredAt       time.Time `json:"registered_at"`
+	LastHeartbeatAt    time.Time `json:"last_heartbeat_at"`
+	LastHeartbeatOK    bool      `json:"last_heartbeat_ok"`
+	LastHeartbeatError string    `json:"last_heartbeat_error,omitempty"`
+	LastResyncAt       time.Time `json:"last_resync_at"`
+	LastResyncError    string    `json:"last_resync_error,omitempty"`
+}
+
+// stateStore persists LocalState for the running agent
+type stateStore struct {
+	path  string
+	state LocalState
+	mutex sync.Mutex
+}
+
+func newStateStore(path string) *stateStore {
+	return &stateStore{path: path}
+}
+
+// update applies fn to the state and writes it out atomically
+func (s *stateStore) update(fn func(state *LocalState)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fn(&s.state)
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %v", err)
+	}
+	return nil
+}
+
+// readLocalState loads the state written by a running agent
+func readLocalState(path string) (*LocalState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var state LocalState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %v", err)
+	}
+	return &state, nil
+}
+
+// recordState updates the on-disk state, logging instead of failing the caller
+func (ea *EdgeAgent) recordState(fn func(state *LocalState)) {
+	if err := ea.state.update(fn); err != nil {
+		ea.logger.Warnf("Failed to record local state: %v", err)
+	}
+}
+
+// recordStarted resets the on-disk state for a new agent process
+func (ea *EdgeAgent) recordStarted() {
+	ea.recordState(func(state *LocalState) {
+		*state = LocalState{
+			PID:             os.Getpid(),
+			NodeName:        ea.config.NodeName,
+			OrchestratorURL: ea.config.OrchestratorURL,
+			StartedAt:       time.Now(),
+		}
+	})
+}
+
+// recordHeartbeat stores the result of the latest heartbeat
+func (ea *EdgeAgent) recordHeartbeat(err error) {
+	ea.recordState(func(state *LocalState) {
+		state.LastHeartbeatAt = time.Now()
+		state.LastHeartbeatOK = err == nil
+		state.LastHeartbeatError = ""
+		if err != nil {
+			state.LastHeartbeatError = err.Error()
+		}
+	})
+}
+
+// resync pushes a heartbeat and runs every sync loop immediately
+func (ea *EdgeAgent) resync() error {
+	ea.logger.Info("Resyncing with central orchestrator")
+
+	// Drop the cached desired state so the next sync fetches the full document
+	ea.desiredMutex.Lock()
+	ea.desired = desiredState{}
+	ea.desiredMutex.Unlock()
+
+	err := ea.sendHeartbeat()
+	ea.recordHeartbeat(err)
+	if err == nil && ea.kubeClient != nil {
+		if err = ea.syncServices(); err == nil {
+			err = ea.processVolumeTasks()
+		}
+	}
+
+	ea.recordResync(err)
+	return err
+}
+
+// recordResync stores the result of the latest resync
+func (ea *EdgeAgent) recordResync(err error) {
+	ea.recordState(func(state *LocalState) {
+		state.LastResyncAt = time.Now()
+		state.LastResyncError = ""
+		if err != nil {
+			state.LastResyncError = err.Error()
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeTaint keeps workloads that do not tolerate it off the node, so operators can reserve
+// nodes for GPU workloads or steer work away from nodes on unstable links
+type NodeTaint struct {
+	Key   string `yaml:"key" json:"key"`
+	Value string `yaml:"value" json:"value"`
+	// "NoSchedule", "PreferNoSchedule" or "NoExecute"
+	Effect string `yaml:"effect" json:"effect"`
+}
+
+// validateTaints checks the configured taints
+func validateTaints(taints []NodeTaint) error {
+	for _, taint := range taints {
+		if taint.Key == "" {
+			return fmt.Errorf("taint key is required")
+		}
+		switch taint.Effect {
+		case "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return fmt.Errorf("taint %s effect must be NoSchedule, PreferNoSchedule or NoExecute", taint.Key)
+		}
+	}
+	return nil
+}
+
+// parseTaintList parses "key[=value]:effect" entries separated by commas, as given in NODE_TAINTS
+func parseTaintList(value string) []NodeTaint {
+	var taints []NodeTaint
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		separator := strings.LastIndex(entry, ":")
+		if separator <= 0 {
+			continue
+		}
+		key, value, _ := strings.Cut(entry[:separator], "=")
+		taints = append(taints, NodeTaint{Key: key, Value: value, Effect: entry[separator+1:]})
+	}
+	return taints
+}
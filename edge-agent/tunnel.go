@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// Seconds the orchestrator may hold a stream poll open; below the HTTP client timeout
+	TunnelPollWaitSeconds = 8
+
+	// Protocol named in the Upgrade header of tunnel connections
+	TunnelUpgradeProtocol = "edge-tunnel"
+)
+
+// TunnelStream is an operator port-forward connection waiting for this agent to attach
+type TunnelStream struct {
+	ID        string            `json:"id"`
+	SessionID string            `json:"session_id"`
+	Namespace string            `json:"namespace"`
+	Selector  map[string]string `json:"selector"`
+	Port      int32             `json:"port"`
+}
+
+type TunnelStreamsResponse struct {
+	Streams []TunnelStream `json:"streams"`
+}
+
+type TunnelStreamRejectRequest struct {
+	Error string `json:"error"`
+}
+
+// startTunnel long-polls the orchestrator for port-forward streams and serves each one by
+// dialing back out, so the node needs no inbound connectivity
+func (ea *EdgeAgent) startTunnel() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, port-forward tunnel disabled")
+		return
+	}
+
+	ea.logger.Info("Starting port-forward tunnel")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		default:
+		}
+
+		var resp TunnelStreamsResponse
+		path := fmt.Sprintf("/api/v1/nodes/%s/tunnel-streams?wait=%d", ea.nodeID, TunnelPollWaitSeconds)
+		if err := ea.doRequest("GET", path, nil, &resp); err != nil {
+			ea.logger.Errorf("Failed to poll tunnel streams: %v", err)
+			select {
+			case <-ea.registrationCtx.Done():
+				return
+			case <-time.After(ea.config.HeartbeatInterval):
+			}
+			continue
+		}
+
+		for _, stream := range resp.Streams {
+			go ea.serveTunnelStream(stream)
+		}
+	}
+}
+
+func (ea *EdgeAgent) serveTunnelStream(stream TunnelStream) {
+	target, err := ea.dialWorkloadPod(ea.registrationCtx, stream)
+	if err != nil {
+		ea.logger.Errorf("Tunnel stream %s: %v", stream.ID, err)
+		path := fmt.Sprintf("/api/v1/nodes/%s/tunnel-streams/%s/reject", ea.nodeID, stream.ID)
+		if err := ea.doRequest("POST", path, TunnelStreamRejectRequest{Error: err.Error()}, nil); err != nil {
+			ea.logger.Errorf("Failed to reject tunnel stream %s: %v", stream.ID, err)
+		}
+		return
+	}
+	defer target.Close()
+
+	tunnel, err := ea.dialTunnel(fmt.Sprintf("/api/v1/nodes/%s/tunnel-streams/%s/attach", ea.nodeID, stream.ID))
+	if err != nil {
+		ea.logger.Errorf("Failed to attach tunnel stream %s: %v", stream.ID, err)
+		return
+	}
+	defer tunnel.Close()
+
+	ea.logger.Infof("Tunnel stream %s attached for session %s", stream.ID, stream.SessionID)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(target, tunnel)
+		target.Close()
+		tunnel.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(tunnel, target)
+		target.Close()
+		tunnel.Close()
+	}()
+	wg.Wait()
+}
+
+// dialWorkloadPod connects to the stream's port on a running pod of the workload
+func (ea *EdgeAgent) dialWorkloadPod(ctx context.Context, stream TunnelStream) (net.Conn, error) {
+	pods, err := ea.kubeClient.CoreV1().Pods(stream.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(stream.Selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		address := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(stream.Port)))
+		conn, err := net.DialTimeout("tcp", address, DefaultTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to pod %s: %v", pod.Name, err)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("no running pod matches the workload in namespace %s", stream.Namespace)
+}
+
+// dialTunnel opens a connection to the orchestrator and upgrades it to a raw stream
+func (ea *EdgeAgent) dialTunnel(path string) (net.Conn, error) {
+	base, err := url.Parse(ea.config.OrchestratorURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid orchestrator URL: %v", err)
+	}
+
+	host := base.Host
+	if base.Port() == "" {
+		if base.Scheme == "https" {
+			host = net.JoinHostPort(base.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(base.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: DefaultTimeout}
+	var conn net.Conn
+	if base.Scheme == "https" {
+		var tlsConfig *tls.Config
+		if transport, ok := ea.httpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		} else {
+			tlsConfig = &tls.Config{}
+		}
+		// The upgrade needs HTTP/1.1
+		tlsConfig.NextProtos = nil
+		tlsConfig.ServerName = base.Hostname()
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to orchestrator: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", ea.config.OrchestratorURL+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create tunnel request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+ea.config.AuthToken)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", TunnelUpgradeProtocol)
+
+	conn.SetDeadline(time.Now().Add(DefaultTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send tunnel request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read tunnel response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		conn.Close()
+		return nil, fmt.Errorf("tunnel request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads through the buffer used while parsing the upgrade response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.reader.Read(p)
+}
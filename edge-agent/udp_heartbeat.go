@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// Sends of one heartbeat before falling back to HTTPS for that beat
+	UDPHeartbeatAttempts = 3
+
+	// Consecutive unacknowledged heartbeats before UDP is abandoned
+	UDPFailuresBeforeFallback = 5
+
+	// How long to stay on HTTPS before negotiating UDP again
+	UDPRenegotiateInterval = 10 * time.Minute
+)
+
+type HeartbeatTransportRequest struct {
+	Preferred []string `json:"preferred"`
+}
+
+type HeartbeatTransportResponse struct {
+	Transport    string `json:"transport"`
+	Port         int    `json:"port"`
+	Key          string `json:"key"`
+	AckTimeoutMS int64  `json:"ack_timeout_ms"`
+}
+
+type udpHeartbeat struct {
+	NodeID    string           `json:"node_id"`
+	Seq       uint64           `json:"seq"`
+	Heartbeat HeartbeatRequest `json:"heartbeat"`
+}
+
+type udpHeartbeatAck struct {
+	NodeID string `json:"node_id"`
+	Seq    uint64 `json:"seq"`
+}
+
+// udpHeartbeatClient sends signed heartbeat datagrams and waits for signed acks
+type udpHeartbeatClient struct {
+	conn       *net.UDPConn
+	key        []byte
+	seq        uint64
+	ackTimeout time.Duration
+	failures   int
+}
+
+// negotiateHeartbeatTransport asks the orchestrator for a UDP heartbeat session when the
+// agent is configured to prefer UDP; callers must hold udpMutex
+func (ea *EdgeAgent) negotiateHeartbeatTransport() error {
+	req := HeartbeatTransportRequest{Preferred: []string{"udp", "https"}}
+	var resp HeartbeatTransportResponse
+	path := fmt.Sprintf("/api/v1/nodes/%s/heartbeat-transport", ea.nodeID)
+	if err := ea.doRequest("POST", path, req, &resp); err != nil {
+		return fmt.Errorf("failed to negotiate heartbeat transport: %v", err)
+	}
+	if resp.Transport != "udp" {
+		return fmt.Errorf("orchestrator does not accept UDP heartbeats")
+	}
+
+	key, err := hex.DecodeString(resp.Key)
+	if err != nil {
+		return fmt.Errorf("invalid heartbeat key: %v", err)
+	}
+
+	orchestratorURL, err := url.Parse(ea.config.OrchestratorURL)
+	if err != nil {
+		return fmt.Errorf("invalid orchestrator URL: %v", err)
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(orchestratorURL.Hostname(), strconv.Itoa(resp.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to resolve UDP heartbeat address: %v", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP heartbeat socket: %v", err)
+	}
+
+	ea.udp = &udpHeartbeatClient{
+		conn:       conn,
+		key:        key,
+		ackTimeout: time.Duration(resp.AckTimeoutMS) * time.Millisecond,
+	}
+	ea.logger.Infof("Using UDP heartbeats to %s", addr)
+	return nil
+}
+
+// sendUDPHeartbeat delivers a heartbeat over UDP if a session is available, returning false
+// when the caller should fall back to HTTPS
+func (ea *EdgeAgent) sendUDPHeartbeat(req HeartbeatRequest) bool {
+	if ea.config.HeartbeatTransport != "udp" {
+		return false
+	}
+
+	ea.udpMutex.Lock()
+	defer ea.udpMutex.Unlock()
+
+	if ea.udp == nil {
+		if time.Now().Before(ea.udpRetryAt) {
+			return false
+		}
+		if err := ea.negotiateHeartbeatTransport(); err != nil {
+			ea.logger.Warnf("UDP heartbeats unavailable, using HTTPS: %v", err)
+			ea.udpRetryAt = time.Now().Add(UDPRenegotiateInterval)
+			return false
+		}
+	}
+
+	if err := ea.udp.send(ea.nodeID, req); err != nil {
+		ea.udp.failures++
+		ea.logger.Warnf("UDP heartbeat failed (%d consecutive): %v", ea.udp.failures, err)
+		if ea.udp.failures >= UDPFailuresBeforeFallback {
+			ea.logger.Warnf("Falling back to HTTPS heartbeats for %s", UDPRenegotiateInterval)
+			ea.udp.conn.Close()
+			ea.udp = nil
+			ea.udpRetryAt = time.Now().Add(UDPRenegotiateInterval)
+		}
+		return false
+	}
+
+	ea.udp.failures = 0
+	return true
+}
+
+func signDatagram(key, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return append(mac.Sum(nil), body...)
+}
+
+func verifyDatagram(key, datagram []byte) ([]byte, bool) {
+	if len(datagram) <= sha256.Size {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(datagram[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), datagram[:sha256.Size]) {
+		return nil, false
+	}
+	return datagram[sha256.Size:], true
+}
+
+// send transmits one heartbeat, retransmitting the same sequence number until it is acked
+func (c *udpHeartbeatClient) send(nodeID string, req HeartbeatRequest) error {
+	c.seq++
+	body, err := json.Marshal(udpHeartbeat{NodeID: nodeID, Seq: c.seq, Heartbeat: req})
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %v", err)
+	}
+	datagram := signDatagram(c.key, body)
+
+	buffer := make([]byte, 1024)
+	for attempt := 0; attempt < UDPHeartbeatAttempts; attempt++ {
+		if _, err := c.conn.Write(datagram); err != nil {
+			return fmt.Errorf("failed to send datagram: %v", err)
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(c.ackTimeout))
+		for {
+			n, err := c.conn.Read(buffer)
+			if err != nil {
+				// Timed out (or ICMP error); retransmit
+				break
+			}
+			payload, ok := verifyDatagram(c.key, buffer[:n])
+			if !ok {
+				continue
+			}
+			var ack udpHeartbeatAck
+			if err := json.Unmarshal(payload, &ack); err == nil && ack.Seq == c.seq {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no ack for heartbeat %d after %d attempts", c.seq, UDPHeartbeatAttempts)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Image used by file-level backup and restore jobs
+	DefaultBackupImage = "rclone/rclone:1.65"
+
+	// Label tying snapshot objects and jobs to the orchestrator task that created them
+	VolumeTaskLabel = "edge.io/volume-task"
+)
+
+var volumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+type WorkloadVolume struct {
+	Name         string `json:"name"`
+	MountPath    string `json:"mount_path"`
+	Size         string `json:"size"`
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+type BackupTarget struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	Region   string `json:"region,omitempty"`
+}
+
+// VolumeTask is a snapshot, restore or delete queued for this node by the orchestrator
+type VolumeTask struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"`
+	SnapshotID   string           `json:"snapshot_id"`
+	WorkloadID   string           `json:"workload_id"`
+	WorkloadName string           `json:"workload_name"`
+	Namespace    string           `json:"namespace"`
+	Method       string           `json:"method"`
+	Target       BackupTarget     `json:"target"`
+	Volumes      []WorkloadVolume `json:"volumes"`
+	Location     string           `json:"location"`
+	Status       string           `json:"status"`
+}
+
+type VolumeTasksResponse struct {
+	Tasks []VolumeTask `json:"tasks"`
+}
+
+type VolumeTaskStatusRequest struct {
+	Status    string `json:"status"`
+	Location  string `json:"location,omitempty"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (ea *EdgeAgent) startVolumeTasks() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, volume tasks disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ea.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ea.logger.Info("Starting volume task processing")
+
+	for {
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-ticker.C:
+			if err := ea.processVolumeTasks(); err != nil {
+				ea.logger.Errorf("Failed to process volume tasks: %v", err)
+			}
+		}
+	}
+}
+
+func (ea *EdgeAgent) processVolumeTasks() error {
+	var resp VolumeTasksResponse
+	path := fmt.Sprintf("/api/v1/nodes/%s/volume-tasks", ea.nodeID)
+	if err := ea.doRequest("GET", path, nil, &resp); err != nil {
+		return fmt.Errorf("failed to fetch volume tasks: %v", err)
+	}
+
+	for _, task := range resp.Tasks {
+		var report *VolumeTaskStatusRequest
+		var err error
+
+		if task.Method == "file" {
+			report, err = ea.runFileTask(ea.registrationCtx, task)
+		} else {
+			report, err = ea.runCSITask(ea.registrationCtx, task)
+		}
+		if err != nil {
+			ea.logger.Errorf("Volume task %s (%s) failed: %v", task.ID, task.Type, err)
+			report = &VolumeTaskStatusRequest{Status: "failed", Error: err.Error()}
+		}
+		if report == nil || report.Status == task.Status {
+			continue
+		}
+
+		statusPath := fmt.Sprintf("/api/v1/nodes/%s/volume-tasks/%s/status", ea.nodeID, task.ID)
+		if err := ea.doRequest("POST", statusPath, report, nil); err != nil {
+			ea.logger.Errorf("Failed to report volume task %s: %v", task.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// claimName is the PVC the agent uses for a workload volume
+func claimName(workloadName, volumeName string) string {
+	return fmt.Sprintf("%s-%s", workloadName, volumeName)
+}
+
+// snapshotName is the VolumeSnapshot holding one volume of an orchestrator snapshot
+func snapshotName(task VolumeTask, volumeName string) string {
+	return fmt.Sprintf("%s-%s-%s", task.WorkloadName, volumeName, shortID(task.SnapshotID))
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// runCSITask drives CSI VolumeSnapshots for a task and returns the status to report
+func (ea *EdgeAgent) runCSITask(ctx context.Context, task VolumeTask) (*VolumeTaskStatusRequest, error) {
+	if ea.dynamicClient == nil {
+		return nil, fmt.Errorf("no dynamic Kubernetes client available for CSI snapshots")
+	}
+	snapshots := ea.dynamicClient.Resource(volumeSnapshotResource).Namespace(task.Namespace)
+
+	switch task.Type {
+	case "snapshot":
+		ready := true
+		for _, volume := range task.Volumes {
+			name := snapshotName(task, volume.Name)
+			existing, err := snapshots.Get(ctx, name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				ea.logger.Infof("Creating volume snapshot %s/%s", task.Namespace, name)
+				if _, err := snapshots.Create(ctx, buildVolumeSnapshot(task, volume), metav1.CreateOptions{}); err != nil {
+					return nil, fmt.Errorf("failed to create volume snapshot %s: %v", name, err)
+				}
+				ready = false
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get volume snapshot %s: %v", name, err)
+			}
+
+			if message, found, _ := unstructured.NestedString(existing.Object, "status", "error", "message"); found {
+				return nil, fmt.Errorf("volume snapshot %s: %s", name, message)
+			}
+			if isReady, _, _ := unstructured.NestedBool(existing.Object, "status", "readyToUse"); !isReady {
+				ready = false
+			}
+		}
+
+		if !ready {
+			return &VolumeTaskStatusRequest{Status: "running"}, nil
+		}
+		location := fmt.Sprintf("volumesnapshot://%s/%s-*-%s", task.Namespace, task.WorkloadName, shortID(task.SnapshotID))
+		return &VolumeTaskStatusRequest{Status: "completed", Location: location}, nil
+
+	case "restore":
+		claims := ea.kubeClient.CoreV1().PersistentVolumeClaims(task.Namespace)
+		for _, volume := range task.Volumes {
+			claim := buildRestoreClaim(task, volume)
+			if err := claims.Delete(ctx, claim.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to replace claim %s: %v", claim.Name, err)
+			}
+			ea.logger.Infof("Restoring claim %s/%s from snapshot", task.Namespace, claim.Name)
+			if _, err := claims.Create(ctx, claim, metav1.CreateOptions{}); err != nil {
+				return nil, fmt.Errorf("failed to create claim %s: %v", claim.Name, err)
+			}
+		}
+		return &VolumeTaskStatusRequest{Status: "completed"}, nil
+
+	case "delete":
+		for _, volume := range task.Volumes {
+			name := snapshotName(task, volume.Name)
+			if err := snapshots.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to delete volume snapshot %s: %v", name, err)
+			}
+		}
+		return &VolumeTaskStatusRequest{Status: "completed"}, nil
+	}
+
+	return nil, fmt.Errorf("unknown volume task type %q", task.Type)
+}
+
+func buildVolumeSnapshot(task VolumeTask, volume WorkloadVolume) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"name":      snapshotName(task, volume.Name),
+			"namespace": task.Namespace,
+			"labels": map[string]interface{}{
+				ManagedByLabel:  ManagedByValue,
+				VolumeTaskLabel: task.ID,
+			},
+		},
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{
+				"persistentVolumeClaimName": claimName(task.WorkloadName, volume.Name),
+			},
+		},
+	}}
+}
+
+func buildRestoreClaim(task VolumeTask, volume WorkloadVolume) *corev1.PersistentVolumeClaim {
+	apiGroup := "snapshot.storage.k8s.io"
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName(task.WorkloadName, volume.Name),
+			Namespace: task.Namespace,
+			Labels: map[string]string{
+				ManagedByLabel:  ManagedByValue,
+				VolumeTaskLabel: task.ID,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshotName(task, volume.Name),
+			},
+		},
+	}
+	if volume.StorageClass != "" {
+		claim.Spec.StorageClassName = &volume.StorageClass
+	}
+	if quantity, err := resource.ParseQuantity(volume.Size); err == nil {
+		claim.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: quantity}
+	}
+	return claim
+}
+
+// runFileTask drives a backup, restore or purge job for a task and returns the status to report
+func (ea *EdgeAgent) runFileTask(ctx context.Context, task VolumeTask) (*VolumeTaskStatusRequest, error) {
+	if task.Type == "restore" {
+		claims := ea.kubeClient.CoreV1().PersistentVolumeClaims(task.Namespace)
+		for _, volume := range task.Volumes {
+			if _, err := claims.Get(ctx, claimName(task.WorkloadName, volume.Name), metav1.GetOptions{}); errors.IsNotFound(err) {
+				claim := buildRestoreClaim(task, volume)
+				claim.Spec.DataSource = nil
+				if _, err := claims.Create(ctx, claim, metav1.CreateOptions{}); err != nil {
+					return nil, fmt.Errorf("failed to create claim %s: %v", claim.Name, err)
+				}
+			}
+		}
+	}
+
+	job, err := buildFileJob(task, ea.config.BackupImage)
+	if err != nil {
+		return nil, err
+	}
+	jobs := ea.kubeClient.BatchV1().Jobs(task.Namespace)
+
+	existing, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		ea.logger.Infof("Creating %s job %s/%s", task.Type, job.Namespace, job.Name)
+		if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create job %s: %v", job.Name, err)
+		}
+		return &VolumeTaskStatusRequest{Status: "running"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job %s: %v", job.Name, err)
+	}
+
+	switch {
+	case existing.Status.Succeeded > 0:
+		return &VolumeTaskStatusRequest{Status: "completed", Location: backupDestination(task)}, nil
+	case existing.Status.Failed > 0:
+		return nil, fmt.Errorf("job %s failed", job.Name)
+	}
+	return &VolumeTaskStatusRequest{Status: "running"}, nil
+}
+
+// backupDestination is where a snapshot's files live on the backup target
+func backupDestination(task VolumeTask) string {
+	if task.Location != "" {
+		return task.Location
+	}
+	location := strings.TrimSuffix(task.Target.Location, "/")
+	suffix := fmt.Sprintf("%s/%s/%s", task.Namespace, task.WorkloadName, task.SnapshotID)
+
+	if task.Target.Type == "nfs" {
+		return "/target/" + suffix
+	}
+	return fmt.Sprintf("%s/%s", location, suffix)
+}
+
+// rcloneRemote turns a destination into an rclone path on the configured target
+func rcloneRemote(task VolumeTask, destination string) string {
+	if task.Target.Type == "nfs" {
+		return destination
+	}
+	remote := ":s3,provider=AWS,env_auth=true"
+	if task.Target.Region != "" {
+		remote += ",region=" + task.Target.Region
+	}
+	return remote + ":" + strings.TrimPrefix(destination, "s3://")
+}
+
+func buildFileJob(task VolumeTask, image string) (*batchv1.Job, error) {
+	if task.Target.Type != "s3" && task.Target.Type != "nfs" {
+		return nil, fmt.Errorf("unsupported backup target type %q", task.Target.Type)
+	}
+
+	remote := rcloneRemote(task, backupDestination(task))
+	var args []string
+	switch task.Type {
+	case "snapshot":
+		args = []string{"copy", "/data", remote}
+	case "restore":
+		args = []string{"sync", remote, "/data"}
+	case "delete":
+		args = []string{"purge", remote}
+	default:
+		return nil, fmt.Errorf("unknown volume task type %q", task.Type)
+	}
+
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	if task.Type != "delete" {
+		for _, volume := range task.Volumes {
+			volumes = append(volumes, corev1.Volume{
+				Name: volume.Name,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: claimName(task.WorkloadName, volume.Name),
+					},
+				},
+			})
+			mounts = append(mounts, corev1.VolumeMount{Name: volume.Name, MountPath: "/data/" + volume.Name})
+		}
+	}
+
+	if task.Target.Type == "nfs" {
+		// Location is "server:/export/path"
+		parts := strings.SplitN(task.Target.Location, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid NFS target %q, expected server:/path", task.Target.Location)
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: "backup-target",
+			VolumeSource: corev1.VolumeSource{
+				NFS: &corev1.NFSVolumeSource{Server: parts[0], Path: parts[1]},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: "backup-target", MountPath: "/target"})
+	}
+
+	backoffLimit := int32(2)
+	labels := map[string]string{
+		ManagedByLabel:  ManagedByValue,
+		VolumeTaskLabel: task.ID,
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s-%s", task.WorkloadName, task.Type, shortID(task.ID)),
+			Namespace: task.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:         "backup",
+							Image:        image,
+							Args:         args,
+							VolumeMounts: mounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}, nil
+}
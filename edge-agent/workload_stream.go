@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// How long to wait before reopening a dropped workload stream
+	WorkloadStreamRetryInterval = 10 * time.Second
+
+	// The orchestrator pings every 30 seconds; a stream silent for longer is dead
+	WorkloadStreamTimeout = 90 * time.Second
+)
+
+// WorkloadCommand is a deploy, delete or scale pushed by the orchestrator
+type WorkloadCommand struct {
+	ID         string                 `json:"id"`
+	Action     string                 `json:"action"`
+	WorkloadID string                 `json:"workload_id"`
+	Spec       map[string]interface{} `json:"spec,omitempty"`
+	Replicas   int32                  `json:"replicas"`
+	IssuedAt   time.Time              `json:"issued_at"`
+}
+
+type StreamHello struct {
+	Workloads []string `json:"workloads"`
+}
+
+type WorkloadCommandResult struct {
+	CommandID  string    `json:"command_id"`
+	WorkloadID string    `json:"workload_id"`
+	Action     string    `json:"action"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	AppliedAt  time.Time `json:"applied_at"`
+}
+
+// StreamMessage is one JSON frame on the workload stream
+type StreamMessage struct {
+	Type    string                 `json:"type"`
+	Hello   *StreamHello           `json:"hello,omitempty"`
+	Command *WorkloadCommand       `json:"command,omitempty"`
+	Result  *WorkloadCommandResult `json:"result,omitempty"`
+}
+
+// startWorkloadStream keeps a WebSocket open to the orchestrator and applies the workload
+// commands it pushes. The agent dials out, so this works from behind NAT.
+func (ea *EdgeAgent) startWorkloadStream() {
+	if ea.kubeClient == nil {
+		ea.logger.Warn("No Kubernetes client available, workload stream disabled")
+		return
+	}
+
+	ea.logger.Info("Starting workload stream")
+
+	for {
+		err := ea.runWorkloadStream()
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		default:
+		}
+		ea.logger.Warnf("Workload stream disconnected: %v", err)
+
+		select {
+		case <-ea.registrationCtx.Done():
+			return
+		case <-time.After(WorkloadStreamRetryInterval):
+		}
+	}
+}
+
+// runWorkloadStream serves one stream until it fails or the agent stops
+func (ea *EdgeAgent) runWorkloadStream() error {
+	streamURL, err := url.Parse(ea.config.OrchestratorURL)
+	if err != nil {
+		return fmt.Errorf("invalid orchestrator URL: %v", err)
+	}
+	if streamURL.Scheme == "http" {
+		streamURL.Scheme = "ws"
+	} else {
+		streamURL.Scheme = "wss"
+	}
+	streamURL.Path = fmt.Sprintf("/api/v1/nodes/%s/stream", ea.nodeID)
+
+	var tlsConfig *tls.Config
+	if transport, ok := ea.httpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: DefaultTimeout,
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+ea.config.AuthToken)
+
+	ws, resp, err := dialer.DialContext(ea.registrationCtx, streamURL.String(), header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("failed to open stream: status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("failed to open stream: %v", err)
+	}
+	defer ws.Close()
+
+	// Closing the connection on shutdown unblocks the read below
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ea.registrationCtx.Done():
+			ws.Close()
+		case <-stop:
+		}
+	}()
+
+	running, err := ea.runningWorkloads(ea.registrationCtx)
+	if err != nil {
+		return fmt.Errorf("failed to list running workloads: %v", err)
+	}
+	if err := ws.WriteJSON(StreamMessage{Type: "hello", Hello: &StreamHello{Workloads: running}}); err != nil {
+		return fmt.Errorf("failed to send hello: %v", err)
+	}
+	ea.logger.Infof("Workload stream connected, %d workloads running", len(running))
+
+	ws.SetReadDeadline(time.Now().Add(WorkloadStreamTimeout))
+	ws.SetPingHandler(func(data string) error {
+		ws.SetReadDeadline(time.Now().Add(WorkloadStreamTimeout))
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(DefaultTimeout))
+	})
+
+	for {
+		var message StreamMessage
+		if err := ws.ReadJSON(&message); err != nil {
+			return err
+		}
+		ws.SetReadDeadline(time.Now().Add(WorkloadStreamTimeout))
+		if message.Type != "command" || message.Command == nil {
+			continue
+		}
+
+		result := ea.applyWorkloadCommand(ea.registrationCtx, *message.Command)
+		if err := ws.WriteJSON(StreamMessage{Type: "result", Result: &result}); err != nil {
+			return fmt.Errorf("failed to report result: %v", err)
+		}
+	}
+}
+
+// applyWorkloadCommand carries out a pushed command and returns the result to report
+func (ea *EdgeAgent) applyWorkloadCommand(ctx context.Context, command WorkloadCommand) WorkloadCommandResult {
+	var err error
+	switch command.Action {
+	case "deploy":
+		err = ea.deployWorkload(ctx, command)
+	case "delete":
+		err = ea.deleteWorkload(ctx, command.WorkloadID)
+	case "scale":
+		err = ea.scaleWorkload(ctx, command.WorkloadID, command.Replicas)
+	default:
+		err = fmt.Errorf("unknown action %q", command.Action)
+	}
+
+	result := WorkloadCommandResult{
+		CommandID:  command.ID,
+		WorkloadID: command.WorkloadID,
+		Action:     command.Action,
+		Success:    err == nil,
+		AppliedAt:  time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		ea.logger.Errorf("Failed to %s workload %s: %v", command.Action, command.WorkloadID, err)
+	} else {
+		ea.logger.Infof("Applied %s of workload %s", command.Action, command.WorkloadID)
+	}
+	return result
+}
+
+// deployWorkload applies the workload in a pushed command, and its Service when it
+// declares ports
+func (ea *EdgeAgent) deployWorkload(ctx context.Context, command WorkloadCommand) error {
+	data, err := json.Marshal(command.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workload spec: %v", err)
+	}
+	var workload AssignedWorkload
+	if err := json.Unmarshal(data, &workload); err != nil {
+		return fmt.Errorf("failed to decode workload spec: %v", err)
+	}
+	if workload.Namespace == "" {
+		workload.Namespace = "default"
+	}
+	workload.ID = command.WorkloadID
+	workload.NodeReplicas = command.Replicas
+
+	if err := ea.applyWorkload(ctx, workload); err != nil {
+		return err
+	}
+	if len(workload.Ports) > 0 {
+		if _, err := ea.ensureService(ctx, workload); err != nil {
+			return fmt.Errorf("failed to ensure service: %v", err)
+		}
+	}
+	return nil
+}
+
+// scaleWorkload sets the replicas of the workload's Deployments and StatefulSets
+func (ea *EdgeAgent) scaleWorkload(ctx context.Context, workloadID string, replicas int32) error {
+	options := metav1.ListOptions{LabelSelector: workloadSelector(workloadID)}
+	apps := ea.kubeClient.AppsV1()
+	scaled := 0
+
+	deployments, err := apps.Deployments("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		deployment.Spec.Replicas = &replicas
+		if _, err := apps.Deployments(deployment.Namespace).Update(ctx, deployment, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to scale deployment %s/%s: %v", deployment.Namespace, deployment.Name, err)
+		}
+		scaled++
+	}
+
+	statefulSets, err := apps.StatefulSets("").List(ctx, options)
+	if err != nil {
+		return fmt.Errorf("failed to list statefulsets: %v", err)
+	}
+	for i := range statefulSets.Items {
+		statefulSet := &statefulSets.Items[i]
+		statefulSet.Spec.Replicas = &replicas
+		if _, err := apps.StatefulSets(statefulSet.Namespace).Update(ctx, statefulSet, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to scale statefulset %s/%s: %v", statefulSet.Namespace, statefulSet.Name, err)
+		}
+		scaled++
+	}
+
+	if scaled == 0 {
+		return fmt.Errorf("workload is not deployed on this node")
+	}
+	return nil
+}